@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var accessLogFile *rotatingFile
+
+// withAccessLog writes an Apache "combined" format line to w for every
+// request, so standard log analyzers can read it.
+func withAccessLog(w io.Writer, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: rw, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		io.WriteString(w, combinedLogLine(r, start, sw.status, sw.bytes))
+	})
+}
+
+// combinedLogLine formats
+// %h %l %u [%t] "%r" %>s %b "%{Referer}i" "%{User-Agent}i"
+func combinedLogLine(r *http.Request, start time.Time, status, bytes int) string {
+	size := "-"
+	if bytes > 0 {
+		size = strconv.Itoa(bytes)
+	}
+	user := "-"
+	if u, _, ok := r.BasicAuth(); ok && u != "" {
+		user = escapeLogField(u)
+	}
+
+	var b strings.Builder
+	b.WriteString(getIP(r))
+	b.WriteString(" - ")
+	b.WriteString(user)
+	b.WriteString(" [")
+	b.WriteString(start.Format("02/Jan/2006:15:04:05 -0700"))
+	b.WriteString(`] "`)
+	b.WriteString(escapeLogField(r.Method + " " + r.RequestURI + " " + r.Proto))
+	b.WriteString(`" `)
+	b.WriteString(strconv.Itoa(status))
+	b.WriteString(" ")
+	b.WriteString(size)
+	b.WriteString(` "`)
+	b.WriteString(escapeLogField(orDash(r.Referer())))
+	b.WriteString(`" "`)
+	b.WriteString(escapeLogField(orDash(r.UserAgent())))
+	b.WriteString("\"\n")
+	return b.String()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// escapeLogField escapes quotes, backslashes and control characters the
+// way Apache does, so a crafted header can't forge log lines.
+func escapeLogField(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			b.WriteString(`\x`)
+			b.WriteByte("0123456789abcdef"[c>>4])
+			b.WriteByte("0123456789abcdef"[c&0xF])
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCombinedLogLine(t *testing.T) {
+	start := time.Date(2024, 5, 1, 13, 55, 36, 0, time.FixedZone("", -7*3600))
+
+	tests := []struct {
+		name     string
+		setup    func(r *http.Request)
+		status   int
+		bytes    int
+		expected string
+	}{
+		{
+			name: "Typical request",
+			setup: func(r *http.Request) {
+				r.Header.Set("Referer", "http://example.com/guestbook")
+				r.Header.Set("User-Agent", "Mozilla/5.0")
+			},
+			status:   200,
+			bytes:    2326,
+			expected: `192.168.1.1 - - [01/May/2024:13:55:36 -0700] "GET /comments?sort=oldest HTTP/1.1" 200 2326 "http://example.com/guestbook" "Mozilla/5.0"` + "\n",
+		},
+		{
+			name:     "Empty body and headers",
+			setup:    func(r *http.Request) {},
+			status:   304,
+			bytes:    0,
+			expected: `192.168.1.1 - - [01/May/2024:13:55:36 -0700] "GET /comments?sort=oldest HTTP/1.1" 304 - "-" "-"` + "\n",
+		},
+		{
+			name: "Hostile user agent",
+			setup: func(r *http.Request) {
+				r.Header.Set("User-Agent", "evil\" \\\n")
+			},
+			status:   404,
+			bytes:    10,
+			expected: `192.168.1.1 - - [01/May/2024:13:55:36 -0700] "GET /comments?sort=oldest HTTP/1.1" 404 10 "-" "evil\" \\\x0a"` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/comments?sort=oldest", nil)
+			req.RemoteAddr = "192.168.1.1:5555"
+			tt.setup(req)
+
+			if got := combinedLogLine(req, start, tt.status, tt.bytes); got != tt.expected {
+				t.Errorf("combinedLogLine()\n got: %q\nwant: %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestWithAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	handler := withAccessLog(&buf, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusTeapot)
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !bytes.Contains(buf.Bytes(), []byte(`"GET / HTTP/1.1" 418 5 "-" "-"`)) {
+		t.Errorf("Unexpected access log %q", buf.String())
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// autocertEnabled reports whether certificates for autocert_domains should
+// be obtained and renewed automatically from Let's Encrypt.
+func autocertEnabled() bool {
+	return len(config.AutocertDomains) > 0
+}
+
+// newCertManager returns a manager that only requests certificates for the
+// configured domains and keeps them in autocert_cache_dir across restarts.
+func newCertManager() *autocert.Manager {
+	dir := config.AutocertCacheDir
+	if dir == "" {
+		dir = "certs"
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(config.AutocertDomains...),
+		Cache:      autocert.DirCache(dir),
+		Email:      config.AutocertEmail,
+	}
+}
+
+// challengeServer answers ACME http-01 challenges and redirects every other
+// plain HTTP request to https.
+func challengeServer(m *autocert.Manager) *http.Server {
+	return &http.Server{
+		Handler:           m.HTTPHandler(nil),
+		ReadHeaderTimeout: seconds(config.ReadHeaderTimeout, 5),
+		ReadTimeout:       seconds(config.ReadTimeout, 15),
+		WriteTimeout:      seconds(config.WriteTimeout, 60),
+		IdleTimeout:       seconds(config.IdleTimeout, 120),
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestAutocertEnabled(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+
+	config.AutocertDomains = nil
+	if autocertEnabled() {
+		t.Error("autocertEnabled() = true without domains")
+	}
+	config.AutocertDomains = []string{"guestbook.example.com"}
+	if !autocertEnabled() {
+		t.Error("autocertEnabled() = false with domains")
+	}
+}
+
+func TestNewCertManager(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.AutocertDomains = []string{"guestbook.example.com"}
+	config.AutocertCacheDir = ""
+
+	m := newCertManager()
+	if dir, ok := m.Cache.(autocert.DirCache); !ok || dir != "certs" {
+		t.Errorf("Cache = %#v, want DirCache(\"certs\")", m.Cache)
+	}
+
+	tests := []struct {
+		host    string
+		allowed bool
+	}{
+		{"guestbook.example.com", true},
+		{"evil.example.com", false},
+		{"example.com", false},
+	}
+	for _, tt := range tests {
+		err := m.HostPolicy(context.Background(), tt.host)
+		if (err == nil) != tt.allowed {
+			t.Errorf("HostPolicy(%q) = %v, want allowed=%v", tt.host, err, tt.allowed)
+		}
+	}
+}
+
+func TestChallengeServerRedirects(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.AutocertDomains = []string{"guestbook.example.com"}
+	config.AutocertCacheDir = t.TempDir()
+
+	srv := challengeServer(newCertManager())
+	req := httptest.NewRequest("GET", "http://guestbook.example.com/comments?limit=5", nil)
+	rec := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Status = %d, want 302", rec.Code)
+	}
+	if loc := rec.Header().Get("Location"); loc != "https://guestbook.example.com/comments?limit=5" {
+		t.Errorf("Location = %q", loc)
+	}
+}
@@ -0,0 +1,955 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Every site is an ActivityPub actor that fediverse users can follow.
+// Approved comments are published to its followers as Notes, and replies
+// to those Notes come back as comments held for moderation. State lives in
+// the SQL database: the actor's key, its followers and which comments
+// arrived as replies.
+
+const (
+	activityStreams = "https://www.w3.org/ns/activitystreams"
+	publicAudience  = activityStreams + "#Public"
+	activityJSON    = "application/activity+json"
+)
+
+// apClient fetches actors and delivers activities to other servers. Key
+// IDs and inboxes come from whoever sends an activity, so like
+// publicClient it only connects to public addresses.
+var apClient = &http.Client{Timeout: 10 * time.Second, Transport: breakerTransport{publicTransport()}}
+
+// apMaxResponse bounds the documents read from other servers.
+const apMaxResponse = 1 << 20
+
+// handleActivityPub registers WebFinger and the actor endpoints on mux.
+func handleActivityPub(mux *http.ServeMux) {
+	mux.HandleFunc("/.well-known/webfinger", webfingerHandler)
+	mux.HandleFunc("/ap/{name}", apActorHandler)
+	mux.HandleFunc("/ap/{name}/inbox", apInboxHandler)
+	mux.HandleFunc("/ap/{name}/outbox", apOutboxHandler)
+	mux.HandleFunc("/ap/{name}/followers", apFollowersHandler)
+	mux.HandleFunc("/ap/{name}/comments/{id}", apNoteHandler)
+}
+
+// apActorName is the username of site's actor: the slug with multi_tenant,
+// activitypub_username without.
+func apActorName(site *Site) string {
+	if config.MultiTenant {
+		return site.Slug
+	}
+	return config.ActivityPubUsername
+}
+
+func apActorURL(site *Site) string {
+	return strings.TrimSuffix(config.PublicURL, "/") + "/ap/" + apActorName(site)
+}
+
+func apNoteURL(site *Site, id int) string {
+	return apActorURL(site) + "/comments/" + strconv.Itoa(id)
+}
+
+// apSiteByName finds the site whose actor is called name.
+func apSiteByName(ctx context.Context, name string) (*Site, error) {
+	if !config.MultiTenant {
+		if name != config.ActivityPubUsername {
+			return nil, errUnknownSite
+		}
+		return defaultSite, nil
+	}
+	return lookupSite(ctx, "", name)
+}
+
+// apSite resolves the actor named in r's path, answering 404 itself if
+// there's none.
+func apSite(w http.ResponseWriter, r *http.Request) (*Site, bool) {
+	site, err := apSiteByName(r.Context(), r.PathValue("name"))
+	if err == errUnknownSite {
+		httpError(w, r, http.StatusNotFound, codeNotFound, "No such actor")
+		return nil, false
+	} else if err != nil {
+		internalError(w, r, err)
+		return nil, false
+	}
+	return site, true
+}
+
+func writeActivityJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", activityJSON)
+	json.NewEncoder(w).Encode(v)
+}
+
+// webfingerHandler answers /.well-known/webfinger?resource=acct:<name>@<host>,
+// which is how fediverse servers find an actor from its handle.
+func webfingerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	host := ""
+	if u, err := url.Parse(config.PublicURL); err == nil {
+		host = u.Host
+	}
+	resource := r.URL.Query().Get("resource")
+	name, ok := strings.CutPrefix(resource, "acct:")
+	if ok {
+		name, ok = strings.CutSuffix(name, "@"+host)
+	} else {
+		// the actor's URL works as well as its handle
+		name, ok = strings.CutPrefix(resource, strings.TrimSuffix(config.PublicURL, "/")+"/ap/")
+	}
+	if !ok || name == "" || strings.Contains(name, "/") {
+		httpError(w, r, http.StatusNotFound, codeNotFound, "No such account")
+		return
+	}
+	site, err := apSiteByName(r.Context(), name)
+	if err == errUnknownSite {
+		httpError(w, r, http.StatusNotFound, codeNotFound, "No such account")
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+
+	type link struct {
+		Rel  string `json:"rel"`
+		Type string `json:"type"`
+		Href string `json:"href"`
+	}
+	w.Header().Set("Content-Type", "application/jrd+json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(struct {
+		Subject string   `json:"subject"`
+		Aliases []string `json:"aliases"`
+		Links   []link   `json:"links"`
+	}{
+		Subject: "acct:" + apActorName(site) + "@" + host,
+		Aliases: []string{apActorURL(site)},
+		Links: []link{
+			{"self", activityJSON, apActorURL(site)},
+			{"http://webfinger.net/rel/profile-page", "text/html", publicPageURL(site)},
+		},
+	})
+}
+
+// apActor is an actor document, ours or one fetched from another server.
+type apActor struct {
+	Context           any          `json:"@context,omitempty"`
+	ID                string       `json:"id"`
+	Type              string       `json:"type"`
+	PreferredUsername string       `json:"preferredUsername"`
+	Name              string       `json:"name,omitempty"`
+	Summary           string       `json:"summary,omitempty"`
+	URL               string       `json:"url,omitempty"`
+	Inbox             string       `json:"inbox"`
+	Outbox            string       `json:"outbox,omitempty"`
+	Followers         string       `json:"followers,omitempty"`
+	Endpoints         *apEndpoints `json:"endpoints,omitempty"`
+	PublicKey         apPublicKey  `json:"publicKey"`
+}
+
+type apEndpoints struct {
+	SharedInbox string `json:"sharedInbox,omitempty"`
+}
+
+type apPublicKey struct {
+	ID           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPem string `json:"publicKeyPem"`
+}
+
+// handle is the actor's @user@host as fediverse software shows it.
+func (a *apActor) handle() string {
+	u, err := url.Parse(a.ID)
+	if err != nil || a.PreferredUsername == "" {
+		return a.ID
+	}
+	return "@" + a.PreferredUsername + "@" + u.Host
+}
+
+func apActorHandler(w http.ResponseWriter, r *http.Request) {
+	site, ok := apSite(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	key, err := actorKey(r.Context(), site.ID)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	pem, err := encodePublicKey(&key.PublicKey)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	id := apActorURL(site)
+	name := settings().PageTitle
+	if config.MultiTenant {
+		name = site.Name
+	}
+	writeActivityJSON(w, apActor{
+		Context:           []string{activityStreams, "https://w3id.org/security/v1"},
+		ID:                id,
+		Type:              "Service",
+		PreferredUsername: apActorName(site),
+		Name:              name,
+		Summary:           "Guestbook entries as they're approved. Replies are held for moderation.",
+		URL:               publicPageURL(site),
+		Inbox:             id + "/inbox",
+		Outbox:            id + "/outbox",
+		Followers:         id + "/followers",
+		PublicKey:         apPublicKey{ID: id + "#main-key", Owner: id, PublicKeyPem: pem},
+	})
+}
+
+// apKeys caches the private keys of actors by site ID.
+var apKeys sync.Map
+
+// actorKey returns the key site's actor signs with, creating it the first
+// time.
+func actorKey(ctx context.Context, siteID int) (*rsa.PrivateKey, error) {
+	if key, ok := apKeys.Load(siteID); ok {
+		return key.(*rsa.PrivateKey), nil
+	}
+	const query = "SELECT private_key FROM activitypub_keys WHERE site_id = ?"
+	var encoded string
+	err := db.QueryRowContext(ctx, query, siteID).Scan(&encoded)
+	if err == sql.ErrNoRows {
+		if err := createActorKey(ctx, siteID); err != nil {
+			return nil, err
+		}
+		// another request may have stored its key first
+		err = db.QueryRowContext(ctx, query, siteID).Scan(&encoded)
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := decodePrivateKey(encoded)
+	if err != nil {
+		return nil, err
+	}
+	apKeys.Store(siteID, key)
+	return key, nil
+}
+
+func createActorKey(ctx context.Context, siteID int) error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	encoded, err := encodePrivateKey(key)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "INSERT INTO activitypub_keys (site_id, private_key) VALUES (?, ?)", siteID, encoded)
+	if isUniqueViolation(err) {
+		return nil
+	}
+	return err
+}
+
+// apNote is a comment as a Note.
+type apNote struct {
+	Context      any       `json:"@context,omitempty"`
+	ID           string    `json:"id"`
+	Type         string    `json:"type"`
+	AttributedTo string    `json:"attributedTo"`
+	InReplyTo    string    `json:"inReplyTo,omitempty"`
+	Content      string    `json:"content"`
+	Published    time.Time `json:"published"`
+	URL          string    `json:"url,omitempty"`
+	To           []string  `json:"to"`
+	Cc           []string  `json:"cc,omitempty"`
+}
+
+// newAPNote publishes c from site's actor under the commenter's name. A
+// reply to a comment that came from the fediverse replies to the original
+// Note.
+func newAPNote(ctx context.Context, site *Site, c *Comment) (apNote, error) {
+	actor := apActorURL(site)
+	text := strings.ReplaceAll(html.EscapeString(c.Text), "\n", "<br>")
+	n := apNote{
+		ID:           apNoteURL(site, c.ID),
+		Type:         "Note",
+		AttributedTo: actor,
+		Content:      "<p><strong>" + html.EscapeString(c.Name) + "</strong></p><p>" + text + "</p>",
+		Published:    c.Created,
+		URL:          publicPageURL(site) + "#comment-" + strconv.Itoa(c.ID),
+		To:           []string{publicAudience},
+		Cc:           []string{actor + "/followers"},
+	}
+	if c.ParentID != 0 {
+		var objectID string
+		err := db.QueryRowContext(ctx, "SELECT object_id FROM activitypub_notes WHERE comment_id = ?", c.ParentID).Scan(&objectID)
+		switch {
+		case err == sql.ErrNoRows:
+			n.InReplyTo = apNoteURL(site, c.ParentID)
+		case err != nil:
+			return n, err
+		default:
+			n.InReplyTo = objectID
+		}
+	}
+	return n, nil
+}
+
+func apNoteHandler(w http.ResponseWriter, r *http.Request) {
+	site, ok := apSite(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		httpError(w, r, 400, codeInvalidID, "Invalid comment ID")
+		return
+	}
+	c, err := store.Get(r.Context(), site.ID, id)
+	if err == errNotFound || (err == nil && c.Status != "approved") {
+		httpError(w, r, http.StatusNotFound, codeNotFound, "Comment not found")
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	n, err := newAPNote(r.Context(), site, c)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	n.Context = activityStreams
+	writeActivityJSON(w, n)
+}
+
+// apOutboxSize is how many of the newest comments the outbox lists.
+const apOutboxSize = 20
+
+type apCollection struct {
+	Context      any    `json:"@context"`
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	TotalItems   int    `json:"totalItems"`
+	OrderedItems []any  `json:"orderedItems,omitempty"`
+}
+
+func apOutboxHandler(w http.ResponseWriter, r *http.Request) {
+	site, ok := apSite(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	ctx := r.Context()
+	q := CommentQuery{SiteID: site.ID, Status: "approved"}
+	total, err := store.Count(ctx, q)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	q.Limit = apOutboxSize
+	comments, err := store.List(ctx, q)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	out := apCollection{Context: activityStreams, ID: apActorURL(site) + "/outbox", Type: "OrderedCollection", TotalItems: total}
+	for i := range comments {
+		a, err := apChangeActivity(ctx, site, "created", &comments[i])
+		if err != nil {
+			internalError(w, r, err)
+			return
+		}
+		out.OrderedItems = append(out.OrderedItems, a)
+	}
+	writeActivityJSON(w, out)
+}
+
+// apFollowersHandler only tells how many followers there are; who they
+// are is nobody else's business.
+func apFollowersHandler(w http.ResponseWriter, r *http.Request) {
+	site, ok := apSite(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var total int
+	if err := db.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM activitypub_followers WHERE site_id = ?", site.ID).Scan(&total); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	writeActivityJSON(w, apCollection{Context: activityStreams, ID: apActorURL(site) + "/followers", Type: "OrderedCollection", TotalItems: total})
+}
+
+// apActivity is an activity we send.
+type apActivity struct {
+	Context any      `json:"@context,omitempty"`
+	ID      string   `json:"id"`
+	Type    string   `json:"type"`
+	Actor   string   `json:"actor"`
+	Object  any      `json:"object"`
+	To      []string `json:"to,omitempty"`
+	Cc      []string `json:"cc,omitempty"`
+}
+
+// apChangeActivity turns a change as publicChange names it into the
+// Create, Update or Delete of c's Note.
+func apChangeActivity(ctx context.Context, site *Site, change string, c *Comment) (apActivity, error) {
+	n, err := newAPNote(ctx, site, c)
+	if err != nil {
+		return apActivity{}, err
+	}
+	a := apActivity{Context: activityStreams, Actor: n.AttributedTo, Object: n, To: n.To, Cc: n.Cc}
+	switch change {
+	case "created":
+		a.ID, a.Type = n.ID+"#create", "Create"
+	case "updated":
+		a.ID, a.Type = n.ID+"#update-"+strconv.FormatInt(c.Updated.UnixMilli(), 10), "Update"
+	case "deleted":
+		a.ID, a.Type = n.ID+"#delete", "Delete"
+		a.Object = map[string]string{"id": n.ID, "type": "Tombstone"}
+	}
+	return a, nil
+}
+
+// apRef is a link to an object, which may come as the object's ID or as
+// the object itself.
+type apRef string
+
+func (ref *apRef) UnmarshalJSON(b []byte) error {
+	var id string
+	if json.Unmarshal(b, &id) == nil {
+		*ref = apRef(id)
+		return nil
+	}
+	var obj struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return err
+	}
+	*ref = apRef(obj.ID)
+	return nil
+}
+
+// inboxActivity is an activity another server sent to an inbox.
+type inboxActivity struct {
+	ID     string          `json:"id"`
+	Type   string          `json:"type"`
+	Actor  apRef           `json:"actor"`
+	Object json.RawMessage `json:"object"`
+}
+
+// inboxNote is the part of a Note a reply needs.
+type inboxNote struct {
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	AttributedTo apRef  `json:"attributedTo"`
+	InReplyTo    apRef  `json:"inReplyTo"`
+	Content      string `json:"content"`
+}
+
+// apInboxHandler takes activities addressed to a site's actor: follows,
+// unfollows, replies to its Notes and deletions of those replies.
+// Everything else is accepted and ignored. Requests must carry an HTTP
+// signature by the activity's actor.
+func apInboxHandler(w http.ResponseWriter, r *http.Request) {
+	site, ok := apSite(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		httpErrorDetails(w, r, http.StatusRequestEntityTooLarge, codeBodyTooLarge,
+			"Request body is larger than "+strconv.FormatInt(tooLarge.Limit, 10)+" bytes", map[string]any{"limit": tooLarge.Limit})
+		return
+	} else if err != nil {
+		httpError(w, r, 400, codeInvalidJSON, "Unreadable body")
+		return
+	}
+	ctx := r.Context()
+	log := requestLogger(r)
+
+	owner, err := verifyInbox(ctx, site, r, body)
+	if err != nil {
+		log.Info("ActivityPub signature refused", "error", err)
+		httpError(w, r, http.StatusUnauthorized, codeUnauthorized, "A valid HTTP signature is required")
+		return
+	}
+	var a inboxActivity
+	if err := json.Unmarshal(body, &a); err != nil {
+		httpError(w, r, 400, codeInvalidJSON, "Invalid activity")
+		return
+	}
+	if string(a.Actor) != owner {
+		httpError(w, r, http.StatusUnauthorized, codeUnauthorized, "The activity isn't signed by its actor")
+		return
+	}
+	blocked, err := store.IsBlocked(ctx, getIP(r))
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if blocked {
+		httpError(w, r, http.StatusForbidden, codeForbidden, "Forbidden")
+		return
+	}
+
+	if err := handleActivity(ctx, log, site, a, body, getIP(r)); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func handleActivity(ctx context.Context, log *slog.Logger, site *Site, a inboxActivity, body []byte, ip string) error {
+	actor := string(a.Actor)
+	switch a.Type {
+	case "Follow":
+		var target apRef
+		if err := json.Unmarshal(a.Object, &target); err != nil || string(target) != apActorURL(site) {
+			return &apiError{status: 400, code: codeInvalidJSON, message: "Follow must be of this actor"}
+		}
+		var follower apActor
+		if err := fetchAP(ctx, site, actor, &follower); err != nil {
+			return &apiError{status: 400, code: codeInvalidJSON, message: "The follower couldn't be fetched"}
+		}
+		inbox := follower.Inbox
+		if follower.Endpoints != nil && follower.Endpoints.SharedInbox != "" {
+			inbox = follower.Endpoints.SharedInbox
+		}
+		if err := addFollower(ctx, site.ID, actor, inbox); err != nil {
+			return err
+		}
+		log.Info("ActivityPub follower added", "site", site.Slug, "actor", actor)
+		accept := apActivity{
+			Context: activityStreams,
+			ID:      apActorURL(site) + "#accept-" + randomToken(),
+			Type:    "Accept",
+			Actor:   apActorURL(site),
+			Object:  json.RawMessage(body),
+		}
+		go func() {
+			if err := deliverActivity(context.Background(), site, follower.Inbox, accept); err != nil {
+				log.Warn("ActivityPub Accept not delivered", "actor", actor, "error", err)
+			}
+		}()
+
+	case "Undo":
+		var inner inboxActivity
+		if json.Unmarshal(a.Object, &inner) == nil && inner.Type == "Follow" && string(inner.Actor) == actor {
+			if _, err := db.ExecContext(ctx, "DELETE FROM activitypub_followers WHERE site_id = ? AND actor = ?", site.ID, actor); err != nil {
+				return err
+			}
+			log.Info("ActivityPub follower removed", "site", site.Slug, "actor", actor)
+		}
+
+	case "Create":
+		var note inboxNote
+		if json.Unmarshal(a.Object, &note) != nil || note.Type != "Note" || note.ID == "" || string(note.AttributedTo) != actor {
+			return nil
+		}
+		return addReply(ctx, log, site, actor, note, ip)
+
+	case "Delete":
+		var object apRef
+		if json.Unmarshal(a.Object, &object) != nil {
+			return nil
+		}
+		if string(object) == actor {
+			// the account is gone
+			_, err := db.ExecContext(ctx, "DELETE FROM activitypub_followers WHERE site_id = ? AND actor = ?", site.ID, actor)
+			return err
+		}
+		var id int
+		err := db.QueryRowContext(ctx, "SELECT comment_id FROM activitypub_notes WHERE object_id = ? AND actor = ?", string(object), actor).Scan(&id)
+		if err == sql.ErrNoRows {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := store.Delete(ctx, site.ID, id); err != nil && err != errNotFound {
+			return err
+		}
+		_, err = db.ExecContext(ctx, "DELETE FROM activitypub_notes WHERE comment_id = ?", id)
+		log.Info("ActivityPub reply deleted by its author", "site", site.Slug, "id", id)
+		return err
+	}
+	return nil
+}
+
+func addFollower(ctx context.Context, siteID int, actor, inbox string) error {
+	_, err := db.ExecContext(ctx, "INSERT INTO activitypub_followers (site_id, actor, inbox) VALUES (?, ?, ?)", siteID, actor, inbox)
+	if isUniqueViolation(err) {
+		_, err = db.ExecContext(ctx, "UPDATE activitypub_followers SET inbox = ? WHERE site_id = ? AND actor = ?", inbox, siteID, actor)
+	}
+	return err
+}
+
+// addReply stores note as a comment held for moderation, if it replies to
+// an approved comment of site. Replies to anything else are ignored.
+func addReply(ctx context.Context, log *slog.Logger, site *Site, actor string, note inboxNote, ip string) error {
+	parent, err := replyParent(ctx, site, string(note.InReplyTo))
+	if err != nil || parent == nil {
+		return err
+	}
+	if site.Archived {
+		return &apiError{status: http.StatusGone, code: codeSiteArchived, message: "This guestbook is archived and read-only"}
+	}
+	var existing int
+	err = db.QueryRowContext(ctx, "SELECT comment_id FROM activitypub_notes WHERE object_id = ?", note.ID).Scan(&existing)
+	if err == nil {
+		return nil
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+
+	var author apActor
+	if err := fetchAP(ctx, site, actor, &author); err != nil {
+		return &apiError{status: 400, code: codeInvalidJSON, message: "The author couldn't be fetched"}
+	}
+	name := author.Name
+	if name == "" {
+		name = author.handle()
+	}
+	text := htmlToText(note.Content)
+	// replies start by mentioning the guestbook
+	text = strings.TrimSpace(strings.TrimPrefix(text, "@"+apActorName(site)))
+	if text == "" {
+		return nil
+	}
+	location := ""
+	if u, err := url.Parse(actor); err == nil {
+		location = u.Host
+	}
+	c := &Comment{
+		SiteID:   site.ID,
+		Name:     name,
+		Text:     text,
+		IP:       ip,
+		Location: location,
+		ParentID: parent.ID,
+		Status:   "pending",
+	}
+	if err := store.Create(ctx, c); err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, "INSERT INTO activitypub_notes (comment_id, object_id, actor) VALUES (?, ?, ?)", c.ID, note.ID, actor); err != nil {
+		return err
+	}
+	log.Info("ActivityPub reply received", "site", site.Slug, "id", c.ID, "parent", parent.ID, "actor", actor)
+	return nil
+}
+
+// replyParent returns the approved comment of site that inReplyTo names,
+// either by its Note's URL or as a reply that came from the fediverse, or
+// nil.
+func replyParent(ctx context.Context, site *Site, inReplyTo string) (*Comment, error) {
+	var id int
+	if s, ok := strings.CutPrefix(inReplyTo, apActorURL(site)+"/comments/"); ok {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, nil
+		}
+		id = n
+	} else {
+		err := db.QueryRowContext(ctx, "SELECT comment_id FROM activitypub_notes WHERE object_id = ?", inReplyTo).Scan(&id)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	c, err := store.Get(ctx, site.ID, id)
+	if err == errNotFound || (err == nil && c.Status != "approved") {
+		return nil, nil
+	}
+	return c, err
+}
+
+// remoteKey is a public key fetched from another server.
+type remoteKey struct {
+	owner string
+	key   *rsa.PublicKey
+}
+
+// apRemoteKeys caches remoteKeys that verified a signature, by key ID,
+// for apKeyCacheTTL. Only keys that did are kept, and at most
+// apKeyCacheMax of them, so made-up key IDs can't fill it.
+var apRemoteKeys = struct {
+	sync.Mutex
+	keys map[string]cachedRemoteKey
+}{keys: map[string]cachedRemoteKey{}}
+
+type cachedRemoteKey struct {
+	*remoteKey
+	expires time.Time
+}
+
+const (
+	apKeyCacheTTL = 24 * time.Hour
+	apKeyCacheMax = 1000
+)
+
+// cachedKey returns the cached key keyID, if there's one.
+func cachedKey(keyID string, now time.Time) (*remoteKey, bool) {
+	apRemoteKeys.Lock()
+	defer apRemoteKeys.Unlock()
+	k, ok := apRemoteKeys.keys[keyID]
+	if !ok || !k.expires.After(now) {
+		return nil, false
+	}
+	return k.remoteKey, true
+}
+
+// cacheKey keeps k, making room by dropping expired keys and then the
+// one closest to expiring.
+func cacheKey(keyID string, k *remoteKey, now time.Time) {
+	apRemoteKeys.Lock()
+	defer apRemoteKeys.Unlock()
+	keys := apRemoteKeys.keys
+	if _, ok := keys[keyID]; !ok && len(keys) >= apKeyCacheMax {
+		oldest := ""
+		for id, c := range keys {
+			if !c.expires.After(now) {
+				delete(keys, id)
+			} else if oldest == "" || c.expires.Before(keys[oldest].expires) {
+				oldest = id
+			}
+		}
+		if len(keys) >= apKeyCacheMax {
+			delete(keys, oldest)
+		}
+	}
+	keys[keyID] = cachedRemoteKey{k, now.Add(apKeyCacheTTL)}
+}
+
+// fetchRemoteKey fetches the public key keyID from its server.
+func fetchRemoteKey(ctx context.Context, site *Site, keyID string) (*remoteKey, error) {
+	// a key ID is usually the actor's URL with a fragment, sometimes a
+	// document of its own
+	var doc struct {
+		apActor
+		Owner        string `json:"owner"`
+		PublicKeyPem string `json:"publicKeyPem"`
+	}
+	docURL, _, _ := strings.Cut(keyID, "#")
+	if err := fetchAP(ctx, site, docURL, &doc); err != nil {
+		return nil, err
+	}
+	pk := doc.PublicKey
+	if pk.ID == "" {
+		pk = apPublicKey{ID: doc.ID, Owner: doc.Owner, PublicKeyPem: doc.PublicKeyPem}
+	}
+	if pk.ID != keyID || pk.Owner == "" {
+		return nil, fmt.Errorf("%s doesn't hold key %s", docURL, keyID)
+	}
+	key, err := decodePublicKey(pk.PublicKeyPem)
+	if err != nil {
+		return nil, err
+	}
+	return &remoteKey{owner: pk.Owner, key: key}, nil
+}
+
+// verifyInbox checks r's HTTP signature and returns the actor that owns
+// the key. The key is only fetched for a request that passes every other
+// check, and a cached key that no longer verifies is fetched again, in
+// case the actor changed it.
+func verifyInbox(ctx context.Context, site *Site, r *http.Request, body []byte) (string, error) {
+	keyID := signatureKeyID(r)
+	if keyID == "" {
+		return "", errBadSignature
+	}
+	if err := checkSignedParts(r, body); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	k, cached := cachedKey(keyID, now)
+	var err error
+	if !cached {
+		if k, err = fetchRemoteKey(ctx, site, keyID); err != nil {
+			return "", err
+		}
+	}
+	err = verifySignature(r, body, k.key)
+	if errors.Is(err, errBadSignature) && cached {
+		if k, err = fetchRemoteKey(ctx, site, keyID); err != nil {
+			return "", err
+		}
+		err = verifySignature(r, body, k.key)
+	}
+	if err != nil {
+		return "", err
+	}
+	cacheKey(keyID, k, now)
+	return k.owner, nil
+}
+
+// fetchAP reads the ActivityPub document at rawURL into v, signed as
+// site's actor for servers that only answer signed requests.
+func fetchAP(ctx context.Context, site *Site, rawURL string, v any) error {
+	if u, err := url.Parse(rawURL); err != nil || u.Scheme != "https" {
+		return fmt.Errorf("%q isn't an https URL", rawURL)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", activityJSON+`, application/ld+json; profile="`+activityStreams+`"`)
+	key, err := actorKey(ctx, site.ID)
+	if err != nil {
+		return err
+	}
+	if err := signRequest(req, apActorURL(site)+"#main-key", key, nil); err != nil {
+		return err
+	}
+	resp, err := apClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, apMaxResponse)).Decode(v)
+}
+
+// deliverActivity posts activity to inbox, signed as site's actor.
+func deliverActivity(ctx context.Context, site *Site, inbox string, activity any) error {
+	body, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, inbox, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", activityJSON)
+	key, err := actorKey(ctx, site.ID)
+	if err != nil {
+		return err
+	}
+	if err := signRequest(req, apActorURL(site)+"#main-key", key, body); err != nil {
+		return err
+	}
+	resp, err := apClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, apMaxResponse))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("POST %s: %s", inbox, resp.Status)
+	}
+	return nil
+}
+
+// runFederation sends every change to approved comments to the followers
+// of their site's actor until ctx is cancelled. Replies that came from the
+// fediverse aren't sent back out.
+func runFederation(ctx context.Context) {
+	sub := feed.subscribe(allSites)
+	defer feed.unsubscribe(sub)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-sub.events:
+			if sub.lagged.Swap(false) {
+				logger.Warn("ActivityPub fell behind, some changes weren't delivered")
+			}
+			change, ok := publicChange(e)
+			if !ok {
+				continue
+			}
+			go func(c Comment) {
+				if err := federateChange(ctx, change, &c); err != nil {
+					logger.Warn("ActivityPub delivery failed", "id", c.ID, "error", err)
+				}
+			}(e.Comment)
+		}
+	}
+}
+
+func federateChange(ctx context.Context, change string, c *Comment) error {
+	if config.MultiTenant && c.SiteID == 0 {
+		// the default site has no actor
+		return nil
+	}
+	var n int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM activitypub_notes WHERE comment_id = ?", c.ID).Scan(&n); err != nil || n > 0 {
+		return err
+	}
+	site, err := siteByID(ctx, c.SiteID)
+	if err != nil {
+		return err
+	}
+	inboxes, err := followerInboxes(ctx, site.ID)
+	if err != nil || len(inboxes) == 0 {
+		return err
+	}
+	activity, err := apChangeActivity(ctx, site, change, c)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, inbox := range inboxes {
+		if err := deliverActivity(ctx, site, inbox, activity); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// followerInboxes returns where to deliver to the followers of a site,
+// once per shared inbox.
+func followerInboxes(ctx context.Context, siteID int) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT DISTINCT inbox FROM activitypub_followers WHERE site_id = ?", siteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var inboxes []string
+	for rows.Next() {
+		var inbox string
+		if err := rows.Scan(&inbox); err != nil {
+			return nil, err
+		}
+		inboxes = append(inboxes, inbox)
+	}
+	return inboxes, rows.Err()
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeFediverse is another server with one user, alice, whose inbox
+// collects what the guestbook delivers.
+type fakeFediverse struct {
+	srv   *httptest.Server
+	key   *rsa.PrivateKey
+	inbox chan delivery
+}
+
+type delivery struct {
+	req  *http.Request
+	body []byte
+}
+
+func newFakeFediverse(t *testing.T) *fakeFediverse {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeFediverse{key: key, inbox: make(chan delivery, 10)}
+	f.srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/users/alice":
+			pem, _ := encodePublicKey(&f.key.PublicKey)
+			json.NewEncoder(w).Encode(apActor{
+				ID:                f.actor(),
+				Type:              "Person",
+				PreferredUsername: "alice",
+				Name:              "Alice",
+				Inbox:             f.actor() + "/inbox",
+				PublicKey:         apPublicKey{ID: f.actor() + "#main-key", Owner: f.actor(), PublicKeyPem: pem},
+			})
+		case r.Method == "POST" && r.URL.Path == "/users/alice/inbox":
+			body, _ := io.ReadAll(r.Body)
+			f.inbox <- delivery{r, body}
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(f.srv.Close)
+	defer func(c *http.Client) { t.Cleanup(func() { apClient = c }) }(apClient)
+	apClient = f.srv.Client()
+	return f
+}
+
+func (f *fakeFediverse) actor() string {
+	return f.srv.URL + "/users/alice"
+}
+
+// send posts activity to the guestbook's inbox as alice.
+func (f *fakeFediverse) send(t *testing.T, mux http.Handler, activity any) int {
+	t.Helper()
+	body, _ := json.Marshal(activity)
+	req := httptest.NewRequest("POST", "https://guestbook.example/ap/guestbook/inbox", bytes.NewReader(body))
+	if err := signRequest(req, f.actor()+"#main-key", f.key, body); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+// next returns the next activity delivered to alice, after checking it's
+// signed by the guestbook.
+func (f *fakeFediverse) next(t *testing.T) map[string]any {
+	t.Helper()
+	select {
+	case d := <-f.inbox:
+		key, err := actorKey(context.Background(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := verifySignature(d.req, d.body, &key.PublicKey); err != nil {
+			t.Errorf("Delivery isn't signed by the guestbook: %v", err)
+		}
+		var a map[string]any
+		json.Unmarshal(d.body, &a)
+		return a
+	case <-time.After(5 * time.Second):
+		t.Fatal("Nothing delivered")
+	}
+	return nil
+}
+
+func activityPubTestConfig(t *testing.T) *http.ServeMux {
+	t.Helper()
+	defer func(c Config) { t.Cleanup(func() { config = c }) }(config)
+	config.ActivityPub = true
+	config.PublicURL = "https://guestbook.example"
+	config.ActivityPubUsername = "guestbook"
+	config.MultiTenant = false
+	db.Exec("DELETE FROM activitypub_followers")
+	db.Exec("DELETE FROM activitypub_notes")
+	mux := http.NewServeMux()
+	handleActivityPub(mux)
+	return mux
+}
+
+func TestWebfinger(t *testing.T) {
+	needSQLite(t)
+	mux := activityPubTestConfig(t)
+	tests := []struct {
+		resource string
+		status   int
+	}{
+		{"acct:guestbook@guestbook.example", 200},
+		{"https://guestbook.example/ap/guestbook", 200},
+		{"acct:someone@guestbook.example", 404},
+		{"acct:guestbook@elsewhere.example", 404},
+		{"", 404},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", "/.well-known/webfinger?resource="+tt.resource, nil))
+		if rec.Code != tt.status {
+			t.Errorf("WebFinger %q = %d, want %d", tt.resource, rec.Code, tt.status)
+			continue
+		}
+		if tt.status == 200 && !strings.Contains(rec.Body.String(), `"href":"https://guestbook.example/ap/guestbook"`) {
+			t.Errorf("WebFinger %q = %s, missing the actor", tt.resource, rec.Body)
+		}
+	}
+}
+
+func TestAPActor(t *testing.T) {
+	needSQLite(t)
+	mux := activityPubTestConfig(t)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/ap/guestbook", nil))
+	if rec.Code != 200 || rec.Header().Get("Content-Type") != activityJSON {
+		t.Fatalf("GET /ap/guestbook = %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var actor apActor
+	json.NewDecoder(rec.Body).Decode(&actor)
+	if actor.ID != "https://guestbook.example/ap/guestbook" || actor.Inbox != actor.ID+"/inbox" || actor.PublicKey.Owner != actor.ID {
+		t.Errorf("Actor %+v", actor)
+	}
+	key, _ := actorKey(context.Background(), 0)
+	if pub, err := decodePublicKey(actor.PublicKey.PublicKeyPem); err != nil || !pub.Equal(&key.PublicKey) {
+		t.Errorf("publicKeyPem isn't the signing key: %v", err)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/ap/nobody", nil))
+	if rec.Code != 404 {
+		t.Errorf("GET /ap/nobody = %d, want 404", rec.Code)
+	}
+}
+
+func TestAPInbox(t *testing.T) {
+	needSQLite(t)
+	mux := activityPubTestConfig(t)
+	defer func(s CommentStore) { store = s }(store)
+	store = newMemoryStore()
+	f := newFakeFediverse(t)
+	ctx := context.Background()
+	me := "https://guestbook.example/ap/guestbook"
+	followers := func() int {
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM activitypub_followers").Scan(&n)
+		return n
+	}
+
+	follow := map[string]any{"id": f.actor() + "#follow", "type": "Follow", "actor": f.actor(), "object": me}
+	body, _ := json.Marshal(follow)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("POST", "/ap/guestbook/inbox", bytes.NewReader(body)))
+	if rec.Code != 401 || followers() != 0 {
+		t.Errorf("Unsigned Follow = %d with %d followers, want 401 and none", rec.Code, followers())
+	}
+
+	if code := f.send(t, mux, map[string]any{"type": "Follow", "actor": "https://remote.example/users/bob", "object": me}); code != 401 {
+		t.Errorf("Follow for someone else = %d, want 401", code)
+	}
+
+	if code := f.send(t, mux, follow); code != 202 || followers() != 1 {
+		t.Fatalf("Follow = %d with %d followers", code, followers())
+	}
+	if a := f.next(t); a["type"] != "Accept" || a["actor"] != me {
+		t.Errorf("Follow answered with %v", a)
+	}
+
+	parent := &Comment{Name: "Host", Email: "host@example.com", Text: "Welcome"}
+	store.Create(ctx, parent)
+	reply := func(id, inReplyTo string) map[string]any {
+		return map[string]any{"type": "Create", "actor": f.actor(), "object": map[string]any{
+			"id": id, "type": "Note", "attributedTo": f.actor(), "inReplyTo": inReplyTo,
+			"content": `<p><span class="h-card"><a href="` + me + `">@guestbook</a></span> Nice<br>guestbook!</p>`,
+		}}
+	}
+	note := f.actor() + "/statuses/1"
+	for range 2 {
+		if code := f.send(t, mux, reply(note, me+"/comments/"+strconv.Itoa(parent.ID))); code != 202 {
+			t.Fatalf("Reply = %d", code)
+		}
+	}
+	f.send(t, mux, reply(f.actor()+"/statuses/2", "https://elsewhere.example/notes/9"))
+	got, _ := store.List(ctx, CommentQuery{Status: "pending"})
+	if len(got) != 1 {
+		t.Fatalf("Replies stored: %+v, want one", got)
+	}
+	if c := got[0]; c.Name != "Alice" || c.Text != "Nice\nguestbook!" || c.Status != "pending" || c.Location != strings.TrimPrefix(f.srv.URL, "https://") {
+		t.Errorf("Reply stored as %+v", c)
+	}
+
+	f.send(t, mux, map[string]any{"type": "Delete", "actor": f.actor(), "object": map[string]any{"id": note, "type": "Tombstone"}})
+	if _, err := store.Get(ctx, 0, got[0].ID); err != errNotFound {
+		t.Errorf("Deleted reply still there: %v", err)
+	}
+
+	if code := f.send(t, mux, map[string]any{"type": "Undo", "actor": f.actor(), "object": follow}); code != 202 || followers() != 0 {
+		t.Errorf("Undo = %d with %d followers, want none", code, followers())
+	}
+}
+
+func TestRunFederation(t *testing.T) {
+	needSQLite(t)
+	activityPubTestConfig(t)
+	defer func(s CommentStore) { store = s }(store)
+	mem := newMemoryStore()
+	store = feedStore{mem}
+	f := newFakeFediverse(t)
+	db.Exec("INSERT INTO activitypub_followers (site_id, actor, inbox) VALUES (0, ?, ?)", f.actor(), f.actor()+"/inbox")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	before := feedSubscribers()
+	go runFederation(ctx)
+	for feedSubscribers() == before {
+		time.Sleep(time.Millisecond)
+	}
+
+	c := &Comment{Name: "Bob <b>", Email: "b@example.com", Text: "Hello\nthere"}
+	store.Create(ctx, c)
+	a := f.next(t)
+	obj, _ := a["object"].(map[string]any)
+	if a["type"] != "Create" || obj["id"] != "https://guestbook.example/ap/guestbook/comments/"+strconv.Itoa(c.ID) {
+		t.Fatalf("Delivered %v", a)
+	}
+	if obj["content"] != "<p><strong>Bob &lt;b&gt;</strong></p><p>Hello<br>there</p>" {
+		t.Errorf("Note content %q", obj["content"])
+	}
+
+	// replies from the fediverse aren't sent back
+	fromFediverse := &Comment{Name: "Alice", Text: "Hi", Status: "pending"}
+	store.Create(ctx, fromFediverse)
+	db.Exec("INSERT INTO activitypub_notes (comment_id, object_id, actor) VALUES (?, ?, ?)", fromFediverse.ID, f.actor()+"/statuses/5", f.actor())
+	fromFediverse.Status = "approved"
+	store.Update(ctx, fromFediverse)
+
+	store.Delete(ctx, 0, c.ID)
+	if a := f.next(t); a["type"] != "Delete" {
+		t.Errorf("Delivered %v, want the Delete", a)
+	}
+}
+
+func TestAPInboxPrivateKeyID(t *testing.T) {
+	needSQLite(t)
+	mux := activityPubTestConfig(t)
+	f := newFakeFediverse(t)
+	var hits atomic.Int32
+	internal := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.NotFound(w, r)
+	}))
+	defer internal.Close()
+	// the real client, trusting the test servers' certificate
+	transport := publicTransport()
+	transport.TLSClientConfig = internal.Client().Transport.(*http.Transport).TLSClientConfig
+	apClient = &http.Client{Transport: breakerTransport{transport}}
+
+	body := []byte(`{"type": "Follow"}`)
+	send := func(keyID string, date time.Time) int {
+		req := httptest.NewRequest("POST", "https://guestbook.example/ap/guestbook/inbox", bytes.NewReader(body))
+		if err := signRequest(req, keyID, f.key, body); err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Date", date.UTC().Format(http.TimeFormat))
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := send(internal.URL+"/actor#main-key", time.Now()); code != 401 || hits.Load() != 0 {
+		t.Errorf("Key ID on a loopback address = %d after %d fetches, want 401 and none", code, hits.Load())
+	}
+
+	// a request that fails the other checks doesn't get its key fetched
+	apClient = internal.Client()
+	if code := send(internal.URL+"/actor#main-key", time.Now().Add(-2*signatureMaxSkew)); code != 401 || hits.Load() != 0 {
+		t.Errorf("Stale request = %d after %d fetches, want 401 and none", code, hits.Load())
+	}
+}
+
+func TestAPKeyCacheBounded(t *testing.T) {
+	defer func() { apRemoteKeys.keys = map[string]cachedRemoteKey{} }()
+	now := time.Now()
+	for i := range apKeyCacheMax + 10 {
+		cacheKey("https://remote.example/users/"+strconv.Itoa(i)+"#main-key", &remoteKey{}, now.Add(time.Duration(i)*time.Second))
+	}
+	if n := len(apRemoteKeys.keys); n != apKeyCacheMax {
+		t.Errorf("%d keys cached, want %d", n, apKeyCacheMax)
+	}
+	if _, ok := cachedKey("https://remote.example/users/0#main-key", now); ok {
+		t.Error("The oldest key wasn't dropped")
+	}
+	last := "https://remote.example/users/" + strconv.Itoa(apKeyCacheMax+9) + "#main-key"
+	if _, ok := cachedKey(last, now); !ok {
+		t.Error("The newest key isn't cached")
+	}
+	if _, ok := cachedKey(last, now.Add(apKeyCacheTTL+time.Hour)); ok {
+		t.Error("An expired key is still cached")
+	}
+}
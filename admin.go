@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// isAdmin reports whether the request carries the configured admin token,
+// or an admin's ID token with oidc_issuer, as "Authorization: Bearer
+// <token>". With admin_jwt_secret it has to be an access token from
+// /admin/login instead. With none configured nobody is admin.
+func isAdmin(r *http.Request) bool {
+	return requestRole(r) == roleAdmin
+}
+
+// requestRole is the role the request's Authorization header grants, or
+// empty.
+func requestRole(r *http.Request) string {
+	return authorizationRole(r.Context(), r.Header.Get("Authorization"))
+}
+
+// authorizationRole checks an Authorization header value, or the gRPC
+// metadata of the same name. The admin token makes an admin; an ID token
+// from oidc_issuer makes whatever its groups map to. With admin_jwt_secret
+// only the access tokens /admin/login hands out for them count.
+func authorizationRole(ctx context.Context, auth string) string {
+	token, ok := strings.CutPrefix(auth, "Bearer ")
+	if !ok || token == "" {
+		return ""
+	}
+	if config.AdminJWTSecret != "" {
+		claims, err := verifyAccessToken(ctx, token)
+		if err != nil {
+			logger.Debug("bearer access token rejected", "error", err)
+			return ""
+		}
+		return claims.str("role")
+	}
+	if s := credentialSession(ctx, token); s != nil {
+		return s.Role
+	}
+	return ""
+}
+
+// credentialSession checks a token that proves who's an admin or
+// moderator: the admin token, or an ID token from oidc_issuer. It returns
+// the session a login with it gets, or nil.
+func credentialSession(ctx context.Context, token string) *adminSession {
+	if adminToken := settings().AdminToken; adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1 {
+		return &adminSession{Token: hashToken(adminToken), Role: roleAdmin}
+	}
+	if config.OIDCIssuer != "" && strings.Count(token, ".") == 2 {
+		claims, err := verifyIDToken(ctx, token, "")
+		if err != nil {
+			logger.Debug("bearer ID token rejected", "error", err)
+			return nil
+		}
+		if role := oidcRole(claims); role != "" {
+			return &adminSession{Issuer: config.OIDCIssuer, Role: role, Name: oidcName(claims)}
+		}
+	}
+	return nil
+}
+
+// requireAdmin wraps handlers under /admin/ so they reject requests
+// without the admin token or an admin's ID token.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return requireRole(next, roleAdmin)
+}
+
+// requireModerator is requireAdmin for the endpoints moderators may use
+// too.
+func requireModerator(next http.HandlerFunc) http.HandlerFunc {
+	return requireRole(next, roleAdmin, roleModerator)
+}
+
+func requireRole(next http.HandlerFunc, roles ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		role := requestRole(r)
+		if role == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			httpError(w, r, http.StatusUnauthorized, codeUnauthorized, "Admin token required")
+			return
+		}
+		if !slices.Contains(roles, role) {
+			httpError(w, r, http.StatusForbidden, codeAdminOnly, "Only admins can do this")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// commentStatuses are what a comment's status can be. Unconfirmed ones
+// wait for the commenter to follow the link of a double_opt_in email.
+var commentStatuses = []string{"approved", "pending", "spam", "unconfirmed"}
+
+// moderateHandler sets the status of comment id. Only approved comments
+// show up in public listings and search.
+func moderateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !parseForm(w, r) {
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		httpError(w, r, 400, codeInvalidID, "id must be a comment id")
+		return
+	}
+	status := r.FormValue("status")
+	if _, err := moderateComment(r.Context(), siteFor(r).ID, id, status); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"id": id, "status": status})
+}
+
+// moderateComment sets the status of comment id of a site. Refusals are
+// *apiError.
+func moderateComment(ctx context.Context, siteID, id int, status string) (*Comment, error) {
+	if !slices.Contains(commentStatuses, status) {
+		return nil, &apiError{status: 400, code: codeInvalidStatus, message: "status must be one of approved, pending, spam or unconfirmed"}
+	}
+	c, err := store.Get(ctx, siteID, id)
+	if err == errNotFound {
+		return nil, &apiError{status: http.StatusNotFound, code: codeNotFound, message: "Comment not found"}
+	} else if err != nil {
+		return nil, err
+	}
+	c.Status = status
+	if err := store.Update(ctx, c); err != nil {
+		return nil, err
+	}
+	if err := learnSpam(ctx, c); err != nil {
+		logger.Warn("Spam classifier didn't learn from the decision", "id", c.ID, "error", err)
+	}
+	return c, nil
+}
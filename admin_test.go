@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestModerateHandler(t *testing.T) {
+	needSQLite(t)
+	_, err := db.Exec("DELETE FROM comments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := db.Exec("INSERT INTO comments (name, email, text, ip, location) VALUES (?, ?, ?, ?, ?)",
+		"Spammer", "spam@example.com", "Cheap pills", "1.2.3.4", "Test Location")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+
+	tests := []struct {
+		name           string
+		formData       string
+		expectedStatus int
+		expectedListed int
+	}{
+		{"Mark as spam", "id=" + strconv.FormatInt(id, 10) + "&status=spam", 200, 0},
+		{"Approve again", "id=" + strconv.FormatInt(id, 10) + "&status=approved", 200, 1},
+		{"Invalid status", "id=" + strconv.FormatInt(id, 10) + "&status=deleted", 400, 1},
+		{"Unknown comment", "id=999999&status=spam", 404, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/admin/moderate", strings.NewReader(tt.formData))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			recorder := httptest.NewRecorder()
+
+			moderateHandler(recorder, req)
+
+			if recorder.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+
+			req = httptest.NewRequest("GET", "/all", nil)
+			recorder = httptest.NewRecorder()
+			allCommentsHandler(recorder, req)
+
+			var comments []Comment
+			if err := json.NewDecoder(recorder.Body).Decode(&comments); err != nil {
+				t.Fatal(err)
+			}
+			if len(comments) != tt.expectedListed {
+				t.Errorf("Expected %d listed comments, got %d", tt.expectedListed, len(comments))
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// adminJWTIssuer is the iss and aud of the access tokens the guestbook
+// issues, telling them apart from anybody else's.
+const adminJWTIssuer = "guestbook-admin"
+
+// adminTokens answers /admin/login and /admin/refresh.
+type adminTokens struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Role         string `json:"role"`
+}
+
+func adminAccessTTL() time.Duration {
+	return seconds(config.AdminJWTTTL, 900)
+}
+
+func adminRefreshTTL() time.Duration {
+	return seconds(config.AdminRefreshTTL, 30*24*60*60)
+}
+
+func adminRefreshKey(token string) string {
+	return "admin-refresh:" + hashToken(token)
+}
+
+func revokedSessionKey(sid string) string {
+	return "admin-revoked:" + sid
+}
+
+// issueAdminTokens hands out a new access token for s, and a refresh token
+// that gets the next pair.
+func issueAdminTokens(ctx context.Context, s adminSession) (*adminTokens, error) {
+	now := time.Now()
+	access, err := signJWT([]byte(config.AdminJWTSecret), jwtClaims{
+		"iss":  adminJWTIssuer,
+		"aud":  adminJWTIssuer,
+		"sub":  cmp.Or(s.Name, "admin token"),
+		"role": s.Role,
+		"sid":  s.Sid,
+		"iat":  now.Unix(),
+		"exp":  now.Add(adminAccessTTL()).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	refresh := randomToken()
+	v, _ := json.Marshal(s)
+	if err := shared.Set(ctx, adminRefreshKey(refresh), v, adminRefreshTTL()); err != nil {
+		return nil, err
+	}
+	return &adminTokens{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(adminAccessTTL().Seconds()),
+		RefreshToken: refresh,
+		Role:         s.Role,
+	}, nil
+}
+
+// verifyAccessToken checks an access token from issueAdminTokens: its
+// signature with admin_jwt_secret, that it hasn't expired and that its
+// login wasn't revoked.
+func verifyAccessToken(ctx context.Context, token string) (jwtClaims, error) {
+	h, claims, signed, sig, err := splitJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(h.Alg, []byte(config.AdminJWTSecret), signed, sig); err != nil {
+		return nil, err
+	}
+	if claims.str("iss") != adminJWTIssuer || !claims.hasAudience(adminJWTIssuer) {
+		return nil, errors.New("not an access token of the guestbook")
+	}
+	if err := claims.checkTimes(time.Now()); err != nil {
+		return nil, err
+	}
+	if revoked, err := sessionRevoked(ctx, claims.str("sid")); err != nil {
+		return nil, err
+	} else if revoked {
+		return nil, errors.New("token revoked")
+	}
+	return claims, nil
+}
+
+// sessionRevoked looks sid up in the revocation list. Lookups that fail
+// count as revoked.
+func sessionRevoked(ctx context.Context, sid string) (bool, error) {
+	if sid == "" {
+		return true, nil
+	}
+	_, ok, err := shared.Get(ctx, revokedSessionKey(sid))
+	return ok || err != nil, err
+}
+
+// revokeSession puts sid on the revocation list for as long as any of its
+// tokens could still be used.
+func revokeSession(ctx context.Context, sid string) error {
+	return shared.Set(ctx, revokedSessionKey(sid), []byte("1"), max(adminRefreshTTL(), adminAccessTTL())+jwtLeeway)
+}
+
+// adminTokenLogin is /admin/login for API clients: the admin token, or an
+// admin's or moderator's ID token, posted as token, buys an access token
+// for the API that expires after admin_jwt_ttl and a refresh token.
+func adminTokenLogin(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if config.AdminJWTSecret == "" {
+		httpError(w, r, http.StatusNotFound, codeNotFound, "Token logins are off, use the admin token as bearer token")
+		return
+	}
+	if !parseForm(w, r) {
+		return
+	}
+	if loginLocked(r) {
+		httpError(w, r, http.StatusTooManyRequests, codeUnauthorized, "Too many failed attempts, try again later")
+		return
+	}
+	s := credentialSession(r.Context(), r.PostFormValue("token"))
+	if s == nil {
+		countLoginFailure(r)
+		httpError(w, r, http.StatusUnauthorized, codeUnauthorized, "token must be the admin token or an ID token of an admin or moderator")
+		return
+	}
+	s.Sid = randomToken()
+	tokens, err := issueAdminTokens(r.Context(), *s)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	requestLogger(r).Info("admin token issued", "role", s.Role, "name", s.Name)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// adminRefreshHandler trades a refresh token for a new access and refresh
+// token. Each refresh token works once; using one again revokes the whole
+// login, since it means somebody else has a copy.
+func adminRefreshHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if !parseForm(w, r) {
+		return
+	}
+	ctx := r.Context()
+	key := adminRefreshKey(r.PostFormValue("refresh_token"))
+	v, ok, err := shared.Get(ctx, key)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	var s adminSession
+	if !ok || json.Unmarshal(v, &s) != nil {
+		httpError(w, r, http.StatusUnauthorized, codeUnauthorized, "Invalid or expired refresh token")
+		return
+	}
+	if s.Rotated {
+		requestLogger(r).Warn("refresh token used twice, revoking the login", "role", s.Role, "name", s.Name)
+		if err := revokeSession(ctx, s.Sid); err != nil {
+			internalError(w, r, err)
+			return
+		}
+		httpError(w, r, http.StatusUnauthorized, codeUnauthorized, "Invalid or expired refresh token")
+		return
+	}
+	revoked, err := sessionRevoked(ctx, s.Sid)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if revoked || !s.current() {
+		httpError(w, r, http.StatusUnauthorized, codeUnauthorized, "Invalid or expired refresh token")
+		return
+	}
+
+	used := s
+	used.Rotated = true
+	v, _ = json.Marshal(used)
+	if err := shared.Set(ctx, key, v, adminRefreshTTL()); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	tokens, err := issueAdminTokens(ctx, s)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// adminRevokeHandler ends the login an access or refresh token posted as
+// token belongs to, like RFC 7009: all its tokens stop working. Anyone
+// holding a token may revoke it, and unknown tokens are no error.
+func adminRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !parseForm(w, r) {
+		return
+	}
+	ctx := r.Context()
+	token := r.PostFormValue("token")
+	var sid string
+	if h, claims, signed, sig, err := splitJWT(token); err == nil {
+		// expired access tokens may be revoked too, their login may not be
+		if verifyJWTSignature(h.Alg, []byte(config.AdminJWTSecret), signed, sig) == nil && claims.str("iss") == adminJWTIssuer {
+			sid = claims.str("sid")
+		}
+	} else if v, ok, err := shared.Get(ctx, adminRefreshKey(token)); err != nil {
+		internalError(w, r, err)
+		return
+	} else if ok {
+		var s adminSession
+		json.Unmarshal(v, &s)
+		sid = s.Sid
+	}
+	if sid != "" {
+		if err := revokeSession(ctx, sid); err != nil {
+			internalError(w, r, err)
+			return
+		}
+		requestLogger(r).Info("admin login revoked")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
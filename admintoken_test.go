@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminTokens(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.AdminToken = "static"
+	config.AdminJWTSecret = strings.Repeat("k", 32)
+
+	post := func(h http.HandlerFunc, path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		req.RemoteAddr = "198.51.100.7:1234"
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec
+	}
+	tokens := func(rec *httptest.ResponseRecorder) adminTokens {
+		t.Helper()
+		var v adminTokens
+		if rec.Code != 200 || json.Unmarshal(rec.Body.Bytes(), &v) != nil || v.AccessToken == "" || v.RefreshToken == "" {
+			t.Fatalf("Answer %d: %s", rec.Code, rec.Body.String())
+		}
+		return v
+	}
+	admin := func(bearer string) int {
+		req := httptest.NewRequest("GET", "/admin/watchdog", nil)
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		rec := httptest.NewRecorder()
+		requireAdmin(func(w http.ResponseWriter, r *http.Request) {})(rec, req)
+		return rec.Code
+	}
+
+	if rec := post(adminLoginHandler, "/admin/login", url.Values{"token": {"wrong"}}); rec.Code != 401 {
+		t.Errorf("Login with a wrong token = %d", rec.Code)
+	}
+	first := tokens(post(adminLoginHandler, "/admin/login", url.Values{"token": {"static"}}))
+	if first.Role != roleAdmin || first.ExpiresIn != 900 {
+		t.Errorf("Login = %+v", first)
+	}
+	if code := admin("static"); code != 401 {
+		t.Errorf("Admin token as bearer = %d, want 401", code)
+	}
+	if code := admin(first.AccessToken); code != 200 {
+		t.Errorf("Access token = %d", code)
+	}
+
+	second := tokens(post(adminRefreshHandler, "/admin/refresh", url.Values{"refresh_token": {first.RefreshToken}}))
+	if code := admin(second.AccessToken); code != 200 {
+		t.Errorf("Refreshed access token = %d", code)
+	}
+	// a refresh token used twice gives the login away
+	if rec := post(adminRefreshHandler, "/admin/refresh", url.Values{"refresh_token": {first.RefreshToken}}); rec.Code != 401 {
+		t.Errorf("Second refresh with the same token = %d", rec.Code)
+	}
+	if code := admin(second.AccessToken); code != 401 {
+		t.Errorf("Access token after reuse = %d, want 401", code)
+	}
+	if rec := post(adminRefreshHandler, "/admin/refresh", url.Values{"refresh_token": {second.RefreshToken}}); rec.Code != 401 {
+		t.Errorf("Refresh after reuse = %d, want 401", rec.Code)
+	}
+
+	for _, revoke := range []func(adminTokens) string{
+		func(t adminTokens) string { return t.AccessToken },
+		func(t adminTokens) string { return t.RefreshToken },
+	} {
+		login := tokens(post(adminLoginHandler, "/admin/login", url.Values{"token": {"static"}}))
+		if rec := post(adminRevokeHandler, "/admin/revoke", url.Values{"token": {revoke(login)}}); rec.Code != 204 {
+			t.Fatalf("Revoke = %d", rec.Code)
+		}
+		if code := admin(login.AccessToken); code != 401 {
+			t.Errorf("Revoked access token = %d, want 401", code)
+		}
+		if rec := post(adminRefreshHandler, "/admin/refresh", url.Values{"refresh_token": {login.RefreshToken}}); rec.Code != 401 {
+			t.Errorf("Revoked refresh token = %d, want 401", rec.Code)
+		}
+	}
+	if rec := post(adminRevokeHandler, "/admin/revoke", url.Values{"token": {"unknown"}}); rec.Code != 204 {
+		t.Errorf("Revoking an unknown token = %d", rec.Code)
+	}
+
+	// changing the admin token ends refreshes, a new secret all access tokens
+	login := tokens(post(adminLoginHandler, "/admin/login", url.Values{"token": {"static"}}))
+	config.AdminToken = "changed"
+	if rec := post(adminRefreshHandler, "/admin/refresh", url.Values{"refresh_token": {login.RefreshToken}}); rec.Code != 401 {
+		t.Errorf("Refresh after changing admin_token = %d, want 401", rec.Code)
+	}
+	config.AdminJWTSecret = strings.Repeat("n", 32)
+	if code := admin(login.AccessToken); code != 401 {
+		t.Errorf("Access token with another secret = %d, want 401", code)
+	}
+
+	expired, _ := signJWT([]byte(config.AdminJWTSecret), jwtClaims{
+		"iss": adminJWTIssuer, "aud": adminJWTIssuer, "role": roleAdmin, "sid": "s",
+		"exp": time.Now().Add(-time.Hour).Unix(),
+	})
+	if code := admin(expired); code != 401 {
+		t.Errorf("Expired access token = %d, want 401", code)
+	}
+}
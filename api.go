@@ -0,0 +1,120 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// apiPrefix is where the current version of the API lives. A change that
+// would break clients, like a different response shape, goes under the
+// next version while this one keeps working; see "Versioning" in the
+// README.
+const apiPrefix = "/api/v1"
+
+// apiUnversionedDeprecated is when the paths without a version were
+// deprecated, sent in their Deprecation header.
+var apiUnversionedDeprecated = time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+
+// openAPISpec describes every apiRoute. TestOpenAPISpec fails when the
+// two disagree on paths or methods.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+type apiRoute struct {
+	path    string
+	handler http.HandlerFunc
+}
+
+// apiRoutes are the endpoints under apiPrefix. Sites live in the SQL
+// database, so their endpoints only exist with a SQL backend.
+func apiRoutes(sites bool) []apiRoute {
+	routes := []apiRoute{
+		{"/comments", requireSite(commentsHandler)},
+		{"/comments/poll", requireSite(pollHandler)},
+		{"/comments/draft", requireSite(draftHandler)},
+		{"/all", requireSite(allCommentsHandler)},
+		{"/search", requireSite(searchHandler)},
+		{"/archive", requireSite(archiveHandler)},
+		{"/like", requireSite(likeHandler)},
+		{"/csrf-token", csrfTokenHandler},
+		{"/form-token", formTokenHandler},
+		{"/nonce", requireSite(nonceHandler)},
+		{"/admin/stats", requireModerator(requireSite(statsHandler))},
+		{"/admin/moderate", requireModerator(requireSite(moderateHandler))},
+		{"/admin/export", requireAdmin(requireSite(exportHandler))},
+		{"/admin/comments/bulk", requireAdmin(requireSite(bulkCommentsHandler))},
+		{"/admin/watchdog", requireAdmin(watchdogHandler)},
+		{"/admin/reload", requireAdmin(reloadHandler)},
+		{"/admin/backup", requireAdmin(backupHandler)},
+		{"/admin/jobs", requireAdmin(jobsHandler)},
+	}
+	if sites {
+		routes = append(routes,
+			apiRoute{"/admin/sites", requireAdmin(sitesHandler)},
+			apiRoute{"/admin/sites/rotate-key", requireAdmin(rotateKeyHandler)},
+			apiRoute{"/admin/sites/archive", requireAdmin(archiveSiteHandler)},
+			apiRoute{"/admin/sites/close", requireAdmin(closeSiteHandler)},
+			apiRoute{"/admin/outbox", requireAdmin(outboxHandler)},
+			apiRoute{"/admin/notifiers", requireAdmin(notifiersHandler)},
+			apiRoute{"/admin/request-log", requireAdmin(requestLogHandler)},
+		)
+	}
+	return routes
+}
+
+// handleAPI registers h at path under apiPrefix, and at path itself where
+// the API lived before it was versioned. The old path answers the same
+// but is marked deprecated, pointing clients to the new one.
+func handleAPI(mux *http.ServeMux, path string, h http.HandlerFunc) {
+	mux.HandleFunc(apiPrefix+path, h)
+	mux.HandleFunc(path, deprecatedPath(apiPrefix+path, h))
+}
+
+// deprecatedPath adds the RFC 9745 Deprecation header and a link to the
+// path that replaces it.
+func deprecatedPath(successor string, h http.HandlerFunc) http.HandlerFunc {
+	deprecation := "@" + strconv.FormatInt(apiUnversionedDeprecated.Unix(), 10)
+	link := "<" + successor + `>; rel="successor-version"`
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", deprecation)
+		w.Header().Add("Link", link)
+		requestLogger(r).Debug("Deprecated API path", "successor", successor)
+		h(w, r)
+	}
+}
+
+// openAPIHandler serves openAPISpec at /openapi.json for client generators
+// and API explorers, which may run on other origins.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(openAPISpec)
+}
+
+// swaggerUIVersion is the swagger-ui-dist release /api/docs loads from
+// unpkg.
+const swaggerUIVersion = "5.17.14"
+
+// apiDocsHandler serves Swagger UI for openAPISpec at /api/docs when
+// api_docs is on. The UI itself comes from unpkg, so it's off by default.
+func apiDocsHandler(w http.ResponseWriter, r *http.Request) {
+	if !settings().APIDocs {
+		httpError(w, r, http.StatusNotFound, codeNotFound, "api_docs is off")
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	v := struct{ Base, Nonce string }{"https://unpkg.com/swagger-ui-dist@" + swaggerUIVersion, randomToken()}
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; script-src https://unpkg.com 'nonce-"+v.Nonce+"'; style-src https://unpkg.com 'unsafe-inline'; img-src 'self' data:; connect-src 'self'")
+	renderHTML(w, r, templates.Load().page, http.StatusOK, "docs.html", v)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHandleAPI(t *testing.T) {
+	mux := http.NewServeMux()
+	handleAPI(mux, "/comments", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "comments")
+	})
+
+	tests := []struct {
+		path       string
+		status     int
+		deprecated bool
+	}{
+		{"/api/v1/comments", 200, false},
+		{"/comments", 200, true},
+		{"/api/v2/comments", 404, false},
+		{"/api/v1/all", 404, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("GET %s = %d, want %d", tt.path, rec.Code, tt.status)
+			}
+			dep, link := rec.Header().Get("Deprecation"), rec.Header().Get("Link")
+			if tt.deprecated && (dep != "@1792195200" || link != `</api/v1/comments>; rel="successor-version"`) {
+				t.Errorf("Deprecation %q, Link %q", dep, link)
+			}
+			if !tt.deprecated && (dep != "" || link != "") {
+				t.Errorf("Current path is marked deprecated: %q, %q", dep, link)
+			}
+		})
+	}
+}
+
+func TestBodyLimitVersionedBulk(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.MaxBodyBytes = 10
+	config.MaxBulkBodyBytes = 1000
+
+	handler := withBodyLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		}
+	}))
+	for _, path := range []string{"/admin/comments/bulk", "/api/v1/admin/comments/bulk"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("POST", path, strings.NewReader(strings.Repeat("x", 100))))
+		if rec.Code != 200 {
+			t.Errorf("POST %s = %d, want the bulk limit", path, rec.Code)
+		}
+	}
+}
+
+func TestOpenAPISpec(t *testing.T) {
+	needSQLite(t)
+	defer func(c Config) { config = c }(config)
+	defer func(s CommentStore) { store = s }(store)
+	config.AdminToken = "secret"
+	store = newMemoryStore()
+
+	var spec struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	routes := apiRoutes(true)
+	var paths []string
+	for _, route := range routes {
+		paths = append(paths, route.path)
+	}
+	slices.Sort(paths)
+	if documented := slices.Sorted(maps.Keys(spec.Paths)); !slices.Equal(documented, paths) {
+		t.Fatalf("openapi.json has paths %v, the API %v", documented, paths)
+	}
+
+	// methods the spec leaves out must be refused; GETs it lists must not
+	// be, the POSTs would change things
+	for _, route := range routes {
+		for _, method := range []string{"GET", "POST", "PUT", "PATCH", "DELETE"} {
+			_, documented := spec.Paths[route.path][strings.ToLower(method)]
+			if documented && method != "GET" {
+				continue
+			}
+			req := httptest.NewRequest(method, apiPrefix+route.path, nil)
+			req.Header.Set("Authorization", "Bearer secret")
+			rec := httptest.NewRecorder()
+			route.handler(rec, req)
+			if refused := rec.Code == http.StatusMethodNotAllowed; refused == documented {
+				t.Errorf("%s %s = %d, documented: %v", method, route.path, rec.Code, documented)
+			}
+		}
+	}
+}
+
+func TestAPIDocs(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+
+	rec := httptest.NewRecorder()
+	apiDocsHandler(rec, httptest.NewRequest("GET", "/api/docs", nil))
+	if rec.Code != 404 {
+		t.Errorf("Docs with api_docs off = %d, want 404", rec.Code)
+	}
+
+	config.APIDocs = true
+	rec = httptest.NewRecorder()
+	apiDocsHandler(rec, httptest.NewRequest("GET", "/api/docs", nil))
+	if body := rec.Body.String(); rec.Code != 200 || !strings.Contains(body, `url: "/openapi.json"`) || !strings.Contains(body, "swagger-ui-dist@"+swaggerUIVersion) {
+		t.Errorf("Docs = %d:\n%s", rec.Code, body)
+	}
+	if csp := rec.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "script-src https://unpkg.com 'nonce-") {
+		t.Errorf("CSP = %q", csp)
+	}
+
+	rec = httptest.NewRecorder()
+	openAPIHandler(rec, httptest.NewRequest("GET", "/openapi.json", nil))
+	if rec.Code != 200 || rec.Header().Get("Content-Type") != "application/json" || !json.Valid(rec.Body.Bytes()) {
+		t.Errorf("GET /openapi.json = %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// archiveHandler lists the archived comments of a site, a page at a time.
+// It takes the filters and sort of GET /comments, plus limit (default 50)
+// and page, and reports how many comments match in X-Total-Count.
+func archiveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	q, err := commentQuery(r)
+	if err == errAdminOnly {
+		httpError(w, r, http.StatusForbidden, codeAdminOnly, err.Error())
+		return
+	} else if err != nil {
+		httpError(w, r, 400, codeInvalidFilter, err.Error())
+		return
+	}
+	if q.Sort, err = commentSort(r); err != nil {
+		httpError(w, r, 400, codeInvalidSort, err.Error())
+		return
+	}
+	q.Archived = true
+
+	q.Limit = 50
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > 100 {
+			httpError(w, r, 400, codeInvalidLimit, "limit must be between 1 and 100")
+			return
+		}
+		q.Limit = n
+	}
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	q.Offset = (max(page, 1) - 1) * q.Limit
+
+	total, err := store.Count(r.Context(), q)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	comments, err := store.List(r.Context(), q)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if !isAdmin(r) {
+		for i := range comments {
+			comments[i].hidePersonal()
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	writeCommentsJSON(w, comments)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestArchive(t *testing.T) {
+	defer func(c Config, s CommentStore) { config, store = c, s }(config, store)
+	store = newMemoryStore()
+	config.ArchiveAfterYears, config.ArchiveSchedule = 2, "@monthly"
+	ctx := context.Background()
+
+	old := time.Now().AddDate(-3, 0, 0)
+	for _, c := range []*Comment{
+		{Name: "Ann", Text: "First", Created: old},
+		{Name: "Bob", Text: "Second", Created: old.Add(time.Hour)},
+		{Name: "Cat", Text: "Held", Status: "pending", Created: old},
+		{Name: "Dan", Text: "Recent"},
+	} {
+		if err := store.Create(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	jobs := scheduledJobs(config)
+	if len(jobs) != 1 || jobs[0].name != "archive" || jobs[0].schedule != "@monthly" {
+		t.Fatalf("scheduledJobs() = %+v", jobs)
+	}
+	if result, err := jobs[0].run(ctx); err != nil || result != "archived 3 comments" {
+		t.Errorf("archive job = %q, %v", result, err)
+	}
+	if n, _ := store.Count(ctx, CommentQuery{}); n != 1 {
+		t.Errorf("%d live comments after archiving, want 1", n)
+	}
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		want   []string
+	}{
+		{"Approved newest first", "", 200, []string{"Bob", "Ann"}},
+		{"Oldest first", "?sort=oldest", 200, []string{"Ann", "Bob"}},
+		{"Second page", "?limit=1&page=2", 200, []string{"Ann"}},
+		{"Filtered", "?name=ann", 200, []string{"Ann"}},
+		{"Limit too high", "?limit=101", 400, nil},
+		{"Bad sort", "?sort=random", 400, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			archiveHandler(rec, httptest.NewRequest("GET", "/archive"+tt.query, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("GET /archive%s = %d: %s", tt.query, rec.Code, rec.Body.String())
+			}
+			if tt.status != 200 {
+				return
+			}
+			var comments []Comment
+			if err := json.Unmarshal(rec.Body.Bytes(), &comments); err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, c := range comments {
+				names = append(names, c.Name)
+			}
+			if len(names) != len(tt.want) || (len(names) > 0 && names[0] != tt.want[0]) {
+				t.Errorf("GET /archive%s = %v, want %v", tt.query, names, tt.want)
+			}
+			if total := rec.Header().Get("X-Total-Count"); tt.name == "Second page" && total != "2" {
+				t.Errorf("X-Total-Count = %q, want 2", total)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// With avatar_uploads, commenters who didn't sign in with an avatar can
+// send one as the avatar field of the multipart form. Whatever its size,
+// it's cropped to a square in the middle and scaled to avatarSize, and
+// stored as a PNG like images (see media.go), so the comment's avatar is a
+// /media/ URL.
+
+// With identicons, comments that end up without an avatar get one made
+// from the SHA-256 of their email, served at /avatar/<hash>.png. The same
+// email always gets the same picture, so it's cached for a year.
+
+// avatarSize is the width and height of uploaded avatars, twice what the
+// pages show them at for high-density screens.
+const avatarSize = 96
+
+// formAvatar reads the avatar field of r's multipart form, if there is
+// one, and makes an avatar of it. Refusals are *apiError.
+func formAvatar(r *http.Request, c Config) (*mediaUpload, error) {
+	data, err := formFile(r, c, "avatar")
+	if data == nil || err != nil {
+		return nil, err
+	}
+	return resizeAvatar(data)
+}
+
+// resizeAvatar decodes an image, the first frame of a GIF, and returns it
+// as an avatarSize PNG.
+func resizeAvatar(data []byte) (*mediaUpload, error) {
+	ext, err := imageType(data)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, &apiError{status: 400, code: codeInvalidImage, message: "The avatar can't be read"}
+	}
+	if ext == "jpg" {
+		img = orient(img, jpegOrientation(data))
+	}
+	var out bytes.Buffer
+	if err := png.Encode(&out, scaleSquare(img, avatarSize)); err != nil {
+		return nil, err
+	}
+	return newMediaUpload("png", out.Bytes()), nil
+}
+
+// scaleSquare crops the largest square out of the middle of img and
+// scales it to size×size, each pixel the average of the ones it covers.
+// Images smaller than size are scaled up the same way, pixel by pixel.
+func scaleSquare(img image.Image, size int) *image.RGBA {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	crop := image.Rect(0, 0, side, side)
+	src := image.NewRGBA(crop)
+	draw.Draw(src, crop, img, image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2), draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := range size {
+		y0, y1 := y*side/size, max((y+1)*side/size, y*side/size+1)
+		for x := range size {
+			x0, x1 := x*side/size, max((x+1)*side/size, x*side/size+1)
+			var r, g, bl, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					p := src.RGBAAt(sx, sy)
+					r, g, bl, a, n = r+uint32(p.R), g+uint32(p.G), bl+uint32(p.B), a+uint32(p.A), n+1
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2], dst.Pix[i+3] = uint8(r/n), uint8(g/n), uint8(bl/n), uint8(a/n)
+		}
+	}
+	return dst
+}
+
+// identiconName matches the file part of identicon URLs.
+var identiconName = regexp.MustCompile(`^([0-9a-f]{64})\.png$`)
+
+// emailHash is the hex SHA-256 of an email address, trimmed and in lower
+// case the way Gravatar hashes them.
+func emailHash(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(sum[:])
+}
+
+// identiconURL is the URL of the identicon for email, absolute with
+// public_url.
+func identiconURL(c Config, email string) string {
+	return strings.TrimSuffix(c.PublicURL, "/") + "/avatar/" + emailHash(email) + ".png"
+}
+
+// identicon draws a 5×5 grid of cells mirrored left to right on a light
+// background, in a colour and pattern taken from hash, at avatarSize.
+func identicon(hash []byte) *image.RGBA {
+	const cells, margin = 5, avatarSize / 12
+	cell := (avatarSize - 2*margin) / cells
+	img := image.NewRGBA(image.Rect(0, 0, avatarSize, avatarSize))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{240, 240, 240, 255}), image.Point{}, draw.Src)
+
+	fg := image.NewUniform(hslColor(float64(int(hash[0])<<8|int(hash[1]))/65536, 0.45+float64(hash[2])/255*0.2, 0.45+float64(hash[3])/255*0.15))
+	for i := range cells * (cells + 1) / 2 {
+		// one bit per cell in the left three columns, the right two copy them
+		if hash[4+i/8]>>(i%8)&1 == 0 {
+			continue
+		}
+		x, y := i/cells, i%cells
+		for _, col := range []int{x, cells - 1 - x} {
+			r := image.Rect(col*cell, y*cell, (col+1)*cell, (y+1)*cell).Add(image.Pt(margin, margin))
+			draw.Draw(img, r, fg, image.Point{}, draw.Src)
+		}
+	}
+	return img
+}
+
+// hslColor converts a hue, saturation and lightness, each 0 to 1.
+func hslColor(h, s, l float64) color.RGBA {
+	q := l + s - l*s
+	if l < 0.5 {
+		q = l * (1 + s)
+	}
+	p := 2*l - q
+	channel := func(t float64) uint8 {
+		t -= float64(int(t))
+		if t < 0 {
+			t++
+		}
+		var v float64
+		switch {
+		case t < 1.0/6:
+			v = p + (q-p)*6*t
+		case t < 1.0/2:
+			v = q
+		case t < 2.0/3:
+			v = p + (q-p)*(2.0/3-t)*6
+		default:
+			v = p
+		}
+		return uint8(v*255 + 0.5)
+	}
+	return color.RGBA{channel(h + 1.0/3), channel(h), channel(h - 1.0/3), 255}
+}
+
+// identiconHandler serves /avatar/<hash>.png.
+func identiconHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	m := identiconName.FindStringSubmatch(strings.TrimPrefix(r.URL.Path, "/avatar/"))
+	if m == nil {
+		httpError(w, r, http.StatusNotFound, codeNotFound, "File not found")
+		return
+	}
+	hash, _ := hex.DecodeString(m[1])
+	var b bytes.Buffer
+	if err := png.Encode(&b, identicon(hash)); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", `"`+m[1][:16]+`"`)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'")
+	http.ServeContent(w, r, m[0], time.Time{}, bytes.NewReader(b.Bytes()))
+}
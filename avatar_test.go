@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"mime/multipart"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestResizeAvatar(t *testing.T) {
+	// red on the left and right, blue in the middle third, which is what
+	// the square crop keeps
+	img := image.NewRGBA(image.Rect(0, 0, 300, 100))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(100, 0, 200, 100), image.NewUniform(color.RGBA{0, 0, 255, 255}), image.Point{}, draw.Src)
+	var b bytes.Buffer
+	png.Encode(&b, img)
+
+	up, err := resizeAvatar(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := png.Decode(bytes.NewReader(up.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Bounds().Dx() != avatarSize || out.Bounds().Dy() != avatarSize || !strings.HasSuffix(up.Name, ".png") {
+		t.Fatalf("Avatar %s is %v", up.Name, out.Bounds())
+	}
+	for _, p := range []image.Point{{0, 0}, {avatarSize - 1, avatarSize - 1}, {avatarSize / 2, avatarSize / 2}} {
+		if r, _, bl, _ := out.At(p.X, p.Y).RGBA(); r != 0 || bl != 0xffff {
+			t.Errorf("Pixel %v = %v, want blue", p, out.At(p.X, p.Y))
+		}
+	}
+
+	// smaller than avatarSize is scaled up
+	var small bytes.Buffer
+	png.Encode(&small, image.NewGray(image.Rect(0, 0, 10, 20)))
+	if up, err := resizeAvatar(small.Bytes()); err != nil {
+		t.Error(err)
+	} else if cfg, _ := png.DecodeConfig(bytes.NewReader(up.Data)); cfg.Width != avatarSize || cfg.Height != avatarSize {
+		t.Errorf("Small avatar is %d×%d", cfg.Width, cfg.Height)
+	}
+
+	if _, err := resizeAvatar([]byte("<svg></svg>")); err == nil || err.(*apiError).code != codeInvalidImage {
+		t.Errorf("SVG: %v, want invalid_image", err)
+	}
+}
+
+func TestAddCommentAvatar(t *testing.T) {
+	defer func(c Config, s CommentStore, m MediaStore) { config, store, media = c, s, m }(config, store, media)
+	store = newMemoryStore()
+	config.MediaStorage, config.AvatarUploads, config.MediaMaxBytes, config.PublicURL = "disk", true, 1<<20, ""
+	var err error
+	if media, err = openMediaStore(Config{MediaStorage: "disk", MediaDir: t.TempDir()}); err != nil {
+		t.Fatal(err)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("name", "Ann")
+	mw.WriteField("email", "ann@example.com")
+	mw.WriteField("comment", "Hi")
+	fw, _ := mw.CreateFormFile("avatar", "me.jpg")
+	fw.Write(exifJPEG(t, 40, 30, 1))
+	mw.Close()
+	req := httptest.NewRequest("POST", "/comments", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	addComment(rec, req)
+	if rec.Code != 201 {
+		t.Fatalf("POST = %d %s", rec.Code, rec.Body)
+	}
+
+	comments, _ := store.List(t.Context(), CommentQuery{})
+	if len(comments) != 1 || !mediaName.MatchString(strings.TrimPrefix(comments[0].Avatar, "/media/")) || comments[0].Image != "" {
+		t.Fatalf("Stored %+v", comments)
+	}
+	f, err := media.Open(t.Context(), strings.TrimPrefix(comments[0].Avatar, "/media/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if cfg, err := png.DecodeConfig(f); err != nil || cfg.Width != avatarSize {
+		t.Errorf("Stored avatar: %v, %v", cfg, err)
+	}
+}
+
+func TestIdenticon(t *testing.T) {
+	if emailHash(" Ann@Example.com") != emailHash("ann@example.com") {
+		t.Error("emailHash() depends on case or spaces")
+	}
+	hash := sha256.Sum256([]byte("ann@example.com"))
+	img := identicon(hash[:])
+	if !bytes.Equal(img.Pix, identicon(hash[:]).Pix) {
+		t.Error("identicon() isn't deterministic")
+	}
+	other := sha256.Sum256([]byte("bob@example.com"))
+	if bytes.Equal(img.Pix, identicon(other[:]).Pix) {
+		t.Error("Two emails got the same identicon")
+	}
+	for y := range avatarSize {
+		for x := range avatarSize / 2 {
+			if img.RGBAAt(x, y) != img.RGBAAt(avatarSize-1-x, y) {
+				t.Fatalf("Identicon isn't symmetric at %d,%d", x, y)
+			}
+		}
+	}
+
+	u := identiconURL(Config{PublicURL: "https://guestbook.example/"}, "Ann@example.com")
+	if u != "https://guestbook.example/avatar/"+emailHash("ann@example.com")+".png" {
+		t.Fatalf("identiconURL() = %s", u)
+	}
+	path := strings.TrimPrefix(u, "https://guestbook.example")
+	rec := httptest.NewRecorder()
+	identiconHandler(rec, httptest.NewRequest("GET", path, nil))
+	if rec.Code != 200 || rec.Header().Get("Content-Type") != "image/png" || !strings.Contains(rec.Header().Get("Cache-Control"), "immutable") {
+		t.Fatalf("GET %s = %d, headers %v", path, rec.Code, rec.Header())
+	}
+	if cfg, err := png.DecodeConfig(rec.Body); err != nil || cfg.Width != avatarSize {
+		t.Errorf("Identicon: %v, %v", cfg, err)
+	}
+	req := httptest.NewRequest("GET", path, nil)
+	req.Header.Set("If-None-Match", rec.Header().Get("ETag"))
+	rec = httptest.NewRecorder()
+	identiconHandler(rec, req)
+	if rec.Code != 304 {
+		t.Errorf("Conditional GET = %d, want 304", rec.Code)
+	}
+	for _, p := range []string{"/avatar/abc.png", "/avatar/" + strings.Repeat("A", 64) + ".png", "/avatar/" + strings.Repeat("0", 64) + ".jpg"} {
+		rec := httptest.NewRecorder()
+		identiconHandler(rec, httptest.NewRequest("GET", p, nil))
+		if rec.Code != 404 {
+			t.Errorf("GET %s = %d, want 404", p, rec.Code)
+		}
+	}
+}
+
+func TestAddCommentIdenticon(t *testing.T) {
+	defer func(c Config, s CommentStore) { config, store = c, s }(config, store)
+	store = newMemoryStore()
+	config.Identicons, config.PublicURL = true, ""
+
+	form := url.Values{"name": {"Ann"}, "email": {"ann@example.com"}, "comment": {"Hi"}}
+	req := httptest.NewRequest("POST", "/comments", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	addComment(rec, req)
+	if rec.Code != 201 {
+		t.Fatalf("POST = %d %s", rec.Code, rec.Body)
+	}
+	comments, _ := store.List(t.Context(), CommentQuery{})
+	if len(comments) != 1 || comments[0].Avatar != "/avatar/"+emailHash("ann@example.com")+".png" {
+		t.Fatalf("Stored %+v", comments)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Backup is a snapshot file in backup_dir.
+type Backup struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+
+	// S3Key is where the backup was uploaded, if backup_s3_bucket is set.
+	S3Key       string `json:"s3_key,omitempty"`
+	UploadError string `json:"upload_error,omitempty"`
+}
+
+const backupTimeFormat = "20060102T150405Z"
+
+var errBackupUnsupported = errors.New("backups need db_driver sqlite3")
+
+// backupMu keeps scheduled and on-demand backups from running at once.
+var backupMu sync.Mutex
+
+// createBackup snapshots the database into backup_dir, uploads it if a
+// bucket is configured and then deletes all but the newest backup_keep
+// local snapshots.
+func createBackup(ctx context.Context) (*Backup, error) {
+	if db == nil || config.DBDriver != "sqlite3" {
+		return nil, errBackupUnsupported
+	}
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	cfg := settings()
+	if err := os.MkdirAll(cfg.BackupDir, 0o755); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	name := "guestbook-" + now.Format(backupTimeFormat) + ".db"
+	path := filepath.Join(cfg.BackupDir, name)
+
+	// a half-written snapshot must never look like a backup
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	if err := sqliteBackup(ctx, db, tmp); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Backup{Name: name, Size: info.Size(), Created: now.Truncate(time.Second)}
+
+	// a failed upload still leaves a good local backup
+	if s3Enabled() {
+		if b.S3Key, err = uploadBackup(ctx, b, path); err != nil {
+			b.S3Key, b.UploadError = "", err.Error()
+			logger.Error("Backup upload failed", "name", name, "bucket", config.BackupS3Bucket, "error", err)
+		}
+	}
+
+	if err := pruneBackups(cfg.BackupDir, cfg.BackupKeep); err != nil {
+		logger.Warn("Couldn't delete old backups", "error", err)
+	}
+	return b, nil
+}
+
+// listBackups returns the snapshots in dir, newest first.
+func listBackups(dir string) ([]Backup, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Backup{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	backups := []Backup{}
+	for _, e := range entries {
+		stamp, ok := strings.CutPrefix(e.Name(), "guestbook-")
+		stamp, ok2 := strings.CutSuffix(stamp, ".db")
+		if !ok || !ok2 {
+			continue
+		}
+		created, err := time.Parse(backupTimeFormat, stamp)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, Backup{Name: e.Name(), Size: info.Size(), Created: created})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Created.After(backups[j].Created) })
+	return backups, nil
+}
+
+// pruneBackups deletes all but the newest keep snapshots. keep 0 keeps
+// everything.
+func pruneBackups(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	backups, err := listBackups(dir)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, b := range backups[min(keep, len(backups)):] {
+		errs = append(errs, os.Remove(filepath.Join(dir, b.Name)))
+	}
+	return errors.Join(errs...)
+}
+
+// backupHandler lists the backups on GET and takes one on POST.
+func backupHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		backups, err := listBackups(settings().BackupDir)
+		if err != nil {
+			internalError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backups)
+	case http.MethodPost:
+		b, err := createBackup(r.Context())
+		if err == errBackupUnsupported {
+			httpError(w, r, http.StatusNotImplemented, codeNotSupported, err.Error())
+			return
+		} else if err != nil {
+			internalError(w, r, err)
+			return
+		}
+		requestLogger(r).Info("Backup created", "name", b.Name, "size", b.Size, "s3_key", b.S3Key)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(b)
+	default:
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupHandler(t *testing.T) {
+	needSQLite(t)
+	defer func(c Config) { config = c }(config)
+	config.DBDriver = "sqlite3"
+	config.BackupDir = filepath.Join(t.TempDir(), "backups")
+	config.BackupKeep = 2
+
+	if _, err := db.Exec("INSERT INTO comments (name, email, text, ip, location) VALUES ('Ann', 'ann@example.com', 'Back me up', '', '')"); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	backupHandler(rec, httptest.NewRequest("POST", "/admin/backup", nil))
+	if rec.Code != 201 {
+		t.Fatalf("POST /admin/backup = %d: %s", rec.Code, rec.Body)
+	}
+	var b Backup
+	if err := json.NewDecoder(rec.Body).Decode(&b); err != nil || b.Size == 0 {
+		t.Fatalf("Backup = %+v, %v", b, err)
+	}
+
+	// the snapshot is a complete database of its own
+	snap, err := sql.Open("sqlite3", filepath.Join(config.BackupDir, b.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+	var n int
+	if err := snap.QueryRow("SELECT COUNT(*) FROM comments WHERE text = 'Back me up'").Scan(&n); err != nil || n != 1 {
+		t.Errorf("Comment in backup: %d, %v", n, err)
+	}
+
+	for _, name := range []string{"guestbook-20240101T000000Z.db", "guestbook-20240102T000000Z.db", "notes.txt"} {
+		os.WriteFile(filepath.Join(config.BackupDir, name), []byte("x"), 0o644)
+	}
+	if err := pruneBackups(config.BackupDir, config.BackupKeep); err != nil {
+		t.Fatal(err)
+	}
+
+	rec = httptest.NewRecorder()
+	backupHandler(rec, httptest.NewRequest("GET", "/admin/backup", nil))
+	var backups []Backup
+	json.NewDecoder(rec.Body).Decode(&backups)
+	if len(backups) != 2 || backups[0].Name != b.Name || backups[1].Name != "guestbook-20240102T000000Z.db" {
+		t.Errorf("GET /admin/backup = %+v", backups)
+	}
+	if _, err := os.Stat(filepath.Join(config.BackupDir, "notes.txt")); err != nil {
+		t.Error("Pruning deleted a file that isn't a backup")
+	}
+}
+
+func TestBackupUnsupported(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.DBDriver = "mysql"
+
+	rec := httptest.NewRecorder()
+	backupHandler(rec, httptest.NewRequest("POST", "/admin/backup", nil))
+	if rec.Code != 501 || rec.Header().Get("X-Error-Code") != codeNotSupported {
+		t.Errorf("POST /admin/backup with MySQL = %d %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// parseNetwork reads an entry of the blocklist or trusted_proxies: an IP
+// address, or a network like 203.0.113.0/24. IPv4-mapped IPv6 addresses
+// count as IPv4.
+func parseNetwork(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil || addr.Zone() != "" {
+			return netip.Prefix{}, errors.New("not an IP address or network")
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, errors.New("not an IP address or network")
+	}
+	if p.Addr().Is4In6() && p.Bits() >= 96 {
+		p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+	}
+	return p.Masked(), nil
+}
+
+// banKey is how a ban is stored: the address alone for a single IP, the
+// network in CIDR notation otherwise.
+func banKey(p netip.Prefix) string {
+	if p.IsSingleIP() {
+		return p.Addr().String()
+	}
+	return p.String()
+}
+
+// banTree is the blocklist as a binary trie over address bits, so a lookup
+// takes at most 128 steps however many IPs and networks are banned. IPv4
+// lives under the IPv4-mapped part of the IPv6 space.
+type banTree struct {
+	root banNode
+}
+
+type banNode struct {
+	next [2]*banNode
+	// banned marks where a ban's network ends; expires is when it does, or
+	// zero if never.
+	banned  bool
+	expires time.Time
+}
+
+// newBanTree builds the tree of the bans in blocklist that haven't
+// expired by now. Entries that don't parse are skipped.
+func newBanTree(blocklist []BlockedIP, now time.Time) *banTree {
+	t := &banTree{}
+	for _, b := range blocklist {
+		p, err := parseNetwork(b.IP)
+		if err != nil || (!b.Expires.IsZero() && !b.Expires.After(now)) {
+			continue
+		}
+		t.insert(p, b.Expires)
+	}
+	return t
+}
+
+// banBits places p in the 128 bit space of the tree.
+func banBits(p netip.Prefix) ([16]byte, int) {
+	bits := p.Bits()
+	if p.Addr().Is4() {
+		bits += 96
+	}
+	return p.Addr().As16(), bits
+}
+
+func bit(a [16]byte, i int) int {
+	return int(a[i/8]>>(7-i%8)) & 1
+}
+
+func (t *banTree) insert(p netip.Prefix, expires time.Time) {
+	a, bits := banBits(p)
+	n := &t.root
+	for i := range bits {
+		b := bit(a, i)
+		if n.next[b] == nil {
+			n.next[b] = &banNode{}
+		}
+		n = n.next[b]
+	}
+	// of two bans on the same network, the one that lasts longer counts
+	if !n.banned || (!n.expires.IsZero() && (expires.IsZero() || expires.After(n.expires))) {
+		n.banned, n.expires = true, expires
+	}
+}
+
+// contains reports whether any ban that hasn't expired at now covers ip.
+func (t *banTree) contains(ip netip.Addr, now time.Time) bool {
+	if !ip.IsValid() {
+		return false
+	}
+	ip = ip.Unmap().WithZone("")
+	a, bits := banBits(netip.PrefixFrom(ip, ip.BitLen()))
+	n := &t.root
+	for i := 0; n != nil; i++ {
+		if n.banned && (n.expires.IsZero() || n.expires.After(now)) {
+			return true
+		}
+		if i == bits {
+			break
+		}
+		n = n.next[bit(a, i)]
+	}
+	return false
+}
+
+// banCache keeps a store's blocklist as a banTree for IsBlocked. Changes
+// through the store invalidate it.
+type banCache struct {
+	mu     sync.Mutex
+	tree   *banTree
+	loaded time.Time
+}
+
+// banCacheMaxAge is how long an instance may miss bans another one added.
+const banCacheMaxAge = 30 * time.Second
+
+// isBlocked looks ip up, loading the tree first if it's been invalidated
+// or, with maxAge, is older than that, for changes other instances make
+// to a shared database.
+func (c *banCache) isBlocked(ip string, maxAge time.Duration, load func() ([]BlockedIP, error)) (bool, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false, nil
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tree == nil || (maxAge > 0 && now.Sub(c.loaded) > maxAge) {
+		blocklist, err := load()
+		if err != nil {
+			return false, err
+		}
+		c.tree, c.loaded = newBanTree(blocklist, now), now
+	}
+	return c.tree.contains(addr, now), nil
+}
+
+func (c *banCache) invalidate() {
+	c.mu.Lock()
+	c.tree = nil
+	c.mu.Unlock()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestParseNetwork(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"203.0.113.7", "203.0.113.7"},
+		{" 203.0.113.7 ", "203.0.113.7"},
+		{"203.0.113.99/24", "203.0.113.0/24"},
+		{"203.0.113.7/32", "203.0.113.7"},
+		{"::ffff:203.0.113.7", "203.0.113.7"},
+		{"::ffff:203.0.113.0/120", "203.0.113.0/24"},
+		{"2001:DB8::1/48", "2001:db8::/48"},
+		{"nope", ""},
+		{"203.0.113.0/33", ""},
+		{"fe80::1%eth0", ""},
+	}
+	for _, tt := range tests {
+		p, err := parseNetwork(tt.in)
+		got := ""
+		if err == nil {
+			got = banKey(p)
+		}
+		if got != tt.want {
+			t.Errorf("parseNetwork(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestBanTree(t *testing.T) {
+	now := time.Now()
+	tree := newBanTree([]BlockedIP{
+		{IP: "0.0.0.0/0", Expires: now.Add(-time.Hour)},
+		{IP: "10.0.0.0/8", Expires: now.Add(time.Hour)},
+		{IP: "10.1.0.0/16"},
+		{IP: "192.0.2.1"},
+		{IP: "2001:db8::/32"},
+		{IP: "garbage"},
+	}, now)
+	for ip, want := range map[string]bool{
+		"10.200.0.1":      true,
+		"10.1.2.3":        true,
+		"11.0.0.1":        false,
+		"192.0.2.1":       true,
+		"192.0.2.2":       false,
+		"2001:db8::5":     true,
+		"::1":             false,
+		"::ffff:10.1.0.1": true,
+	} {
+		if got := tree.contains(netip.MustParseAddr(ip), now); got != want {
+			t.Errorf("contains(%s) = %v, want %v", ip, got, want)
+		}
+	}
+	// the /8 runs out, the /16 inside it doesn't
+	later := now.Add(2 * time.Hour)
+	if tree.contains(netip.MustParseAddr("10.200.0.1"), later) || !tree.contains(netip.MustParseAddr("10.1.0.1"), later) {
+		t.Error("temporary ban didn't expire on its own")
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/url"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// The bayes spam check is a naive Bayes classifier that learns from
+// moderators: approving a comment teaches it ham, marking one as spam
+// teaches it spam, and changing their mind unlearns the first decision.
+// What it learned is in the spam_tokens and spam_trained tables, so it
+// needs db_driver sqlite3 or mysql.
+
+const (
+	// bayesMinTrained is how many comments of each class the classifier
+	// wants to have learned from before it says anything.
+	bayesMinTrained = 10
+	// bayesMaxTokens bounds the tokens taken from a comment.
+	bayesMaxTokens = 200
+	// bayesInteresting is how many of a comment's tokens decide, the
+	// ones that point most strongly either way.
+	bayesInteresting = 15
+)
+
+// spamTokens are the words of a comment's name and text, lowercased and
+// each once, and the hosts of its links as "host:example.com".
+func spamTokens(name, text string) []string {
+	var tokens []string
+	add := func(t string) {
+		if len(tokens) < bayesMaxTokens && len(t) <= 100 && !slices.Contains(tokens, t) {
+			tokens = append(tokens, t)
+		}
+	}
+	for _, u := range findURLs(text) {
+		if p, err := url.Parse(u); err == nil && p.Host != "" {
+			add("host:" + strings.ToLower(p.Hostname()))
+		}
+	}
+	words := strings.FieldsFunc(strings.ToLower(name+" "+text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		if n := len(w); n >= 2 && n <= 30 {
+			add(w)
+		}
+	}
+	return tokens
+}
+
+// bayesSpamCheck scores a comment by how likely the classifier thinks it
+// is spam, 0 at even odds or below and 1 when it's sure.
+func bayesSpamCheck(ctx context.Context, _ Config, in commentInput) (float64, string, error) {
+	if db == nil {
+		return 0, "", nil
+	}
+	p, err := spamProbability(ctx, spamTokens(in.Name, in.Text))
+	if err != nil || p <= 0.5 {
+		return 0, "", err
+	}
+	return (p - 0.5) * 2, fmt.Sprintf("%.0f%% likely spam", p*100), nil
+}
+
+// spamProbability is the chance that a comment with tokens is spam, 0.5
+// until the classifier has learned enough.
+func spamProbability(ctx context.Context, tokens []string) (float64, error) {
+	nSpam, nHam, err := spamTrainedCounts(ctx)
+	if err != nil || nSpam < bayesMinTrained || nHam < bayesMinTrained || len(tokens) == 0 {
+		return 0.5, err
+	}
+	args := make([]any, len(tokens))
+	for i, t := range tokens {
+		args[i] = t
+	}
+	rows, err := db.QueryContext(ctx, "SELECT token, spam, ham FROM spam_tokens WHERE token IN (?"+strings.Repeat(", ?", len(tokens)-1)+")", args...)
+	if err != nil {
+		return 0.5, err
+	}
+	defer rows.Close()
+	var evidence []float64
+	for rows.Next() {
+		var token string
+		var spam, ham int
+		if err := rows.Scan(&token, &spam, &ham); err != nil {
+			return 0.5, err
+		}
+		if spam+ham == 0 {
+			continue
+		}
+		// how much more often the token turns up in spam than in ham,
+		// smoothed so a token seen once doesn't decide alone
+		pSpam := (float64(spam) + 1) / (float64(nSpam) + 2)
+		pHam := (float64(ham) + 1) / (float64(nHam) + 2)
+		evidence = append(evidence, math.Log(pSpam/pHam))
+	}
+	if err := rows.Err(); err != nil {
+		return 0.5, err
+	}
+	slices.SortFunc(evidence, func(a, b float64) int { return cmp.Compare(math.Abs(b), math.Abs(a)) })
+	logOdds := math.Log(float64(nSpam) / float64(nHam))
+	for _, e := range evidence[:min(len(evidence), bayesInteresting)] {
+		logOdds += e
+	}
+	return 1 / (1 + math.Exp(-logOdds)), nil
+}
+
+// spamTrainedCounts is how many spam and ham comments the classifier
+// learned from.
+func spamTrainedCounts(ctx context.Context) (spam, ham int, err error) {
+	rows, err := db.QueryContext(ctx, "SELECT class, COUNT(*) FROM spam_trained GROUP BY class")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var class string
+		var n int
+		if err := rows.Scan(&class, &n); err != nil {
+			return 0, 0, err
+		}
+		if class == "spam" {
+			spam = n
+		} else {
+			ham = n
+		}
+	}
+	return spam, ham, rows.Err()
+}
+
+// learnSpam teaches the classifier the status a moderator gave c: spam,
+// ham for approved, or to forget c for pending. What it learned from c
+// before is unlearned first.
+func learnSpam(ctx context.Context, c *Comment) error {
+	if db == nil {
+		return nil
+	}
+	class := map[string]string{"spam": "spam", "approved": "ham"}[c.Status]
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var prev string
+	err = tx.QueryRowContext(ctx, "SELECT class FROM spam_trained WHERE comment_id = ?", c.ID).Scan(&prev)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if prev == class {
+		return nil
+	}
+	tokens := spamTokens(c.Name, c.Text)
+	if prev != "" {
+		for _, t := range tokens {
+			// the text may have been edited since, so counts can't go
+			// below zero
+			if _, err := tx.ExecContext(ctx, "UPDATE spam_tokens SET "+prev+" = "+prev+" - 1 WHERE token = ? AND "+prev+" > 0", t); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM spam_trained WHERE comment_id = ?", c.ID); err != nil {
+			return err
+		}
+	}
+	if class != "" {
+		for _, t := range tokens {
+			if _, err := tx.ExecContext(ctx, insertIgnore()+" INTO spam_tokens (token) VALUES (?)", t); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, "UPDATE spam_tokens SET "+class+" = "+class+" + 1 WHERE token = ?", t); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO spam_trained (comment_id, class) VALUES (?, ?)", c.ID, class); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestSpamTokens(t *testing.T) {
+	got := spamTokens("Ann", "Cheap PILLS, cheap! See https://Pills.example/buy a")
+	want := []string{"host:pills.example", "ann", "cheap", "pills", "see", "https", "example", "buy"}
+	if !slices.Equal(got, want) {
+		t.Errorf("spamTokens() = %q, want %q", got, want)
+	}
+}
+
+func TestBayesSpamCheck(t *testing.T) {
+	needSQLite(t)
+	db.Exec("DELETE FROM spam_tokens")
+	db.Exec("DELETE FROM spam_trained")
+	defer db.Exec("DELETE FROM spam_trained")
+	ctx := context.Background()
+	check := func(text string) float64 {
+		t.Helper()
+		score, _, err := bayesSpamCheck(ctx, config, commentInput{Name: "Someone", Text: text})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return score
+	}
+
+	learn := func(id int, status, text string) {
+		t.Helper()
+		if err := learnSpam(ctx, &Comment{ID: id, Name: "Someone", Text: text, Status: status}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range bayesMinTrained {
+		learn(1000+i, "spam", fmt.Sprintf("Cheap pills and casino bonus %d at https://pills.example", i))
+		if i < bayesMinTrained-1 {
+			learn(2000+i, "approved", fmt.Sprintf("Lovely guestbook, greetings from Berlin %d", i))
+		}
+	}
+	if s := check("cheap pills casino"); s != 0 {
+		t.Errorf("Score before enough ham = %v, want 0", s)
+	}
+	learn(2000+bayesMinTrained, "approved", "Lovely guestbook, greetings from Hamburg")
+
+	if s := check("Cheap casino pills, visit https://pills.example"); s < 0.9 {
+		t.Errorf("Spammy comment scored %v", s)
+	}
+	if s := check("Greetings from a lovely town"); s != 0 {
+		t.Errorf("Friendly comment scored %v", s)
+	}
+
+	// learning the same decision again changes nothing, a new one
+	// replaces it and pending forgets the comment
+	learn(1000, "spam", "Cheap pills and casino bonus 0 at https://pills.example")
+	learn(1001, "approved", "Cheap pills and casino bonus 1 at https://pills.example")
+	learn(1002, "pending", "Cheap pills and casino bonus 2 at https://pills.example")
+	var spam, ham int
+	db.QueryRow("SELECT spam, ham FROM spam_tokens WHERE token = 'casino'").Scan(&spam, &ham)
+	if spam != bayesMinTrained-2 || ham != 1 {
+		t.Errorf("casino counted %d spam, %d ham, want %d and 1", spam, ham, bayesMinTrained-2)
+	}
+	if nSpam, nHam, _ := spamTrainedCounts(ctx); nSpam != bayesMinTrained-2 || nHam != bayesMinTrained+1 {
+		t.Errorf("Trained on %d spam, %d ham", nSpam, nHam)
+	}
+}
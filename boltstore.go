@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltComments  = []byte("comments")
+	boltArchive   = []byte("comments_archive")
+	boltLikes     = []byte("likes")
+	boltBlocklist = []byte("blocklist")
+	boltBotHits   = []byte("bot_hits")
+)
+
+// boltStore keeps everything in a single bbolt file. It's pure Go, so
+// builds without cgo (and the sqlite3 driver) can still persist comments.
+// Lists and search scan the whole site, which is fine at guestbook sizes.
+type boltStore struct {
+	db   *bolt.DB
+	bans banCache
+}
+
+type boltBlock struct {
+	Reason  string    `json:"reason"`
+	Hits    int       `json:"hits"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+	Expires time.Time `json:"expires,omitzero"`
+}
+
+type boltBotHit struct {
+	BotHit
+	Created time.Time
+}
+
+// openBoltStore opens or creates the database at path. bbolt locks the
+// file, so only one process can have it open.
+func openBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltComments, boltArchive, boltLikes, boltBlocklist, boltBotHits} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+func boltKey(id int) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(id))
+}
+
+func getBoltComment(tx *bolt.Tx, siteID, id int) (*Comment, error) {
+	v := tx.Bucket(boltComments).Get(boltKey(id))
+	if v == nil {
+		return nil, errNotFound
+	}
+	var bc commentRecord
+	if err := json.Unmarshal(v, &bc); err != nil {
+		return nil, err
+	}
+	if bc.SiteID != siteID {
+		return nil, errNotFound
+	}
+	c := Comment(bc)
+	return &c, nil
+}
+
+func putBoltComment(tx *bolt.Tx, c *Comment) error {
+	v, err := json.Marshal(commentRecord(*c))
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltComments).Put(boltKey(c.ID), v)
+}
+
+func (s *boltStore) Create(ctx context.Context, c *Comment) error {
+	c.setDefaults()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		id, err := tx.Bucket(boltComments).NextSequence()
+		if err != nil {
+			return err
+		}
+		c.ID = int(id)
+		return putBoltComment(tx, c)
+	})
+}
+
+func (s *boltStore) CreateMany(ctx context.Context, comments []*Comment) error {
+	ids := make([]int, len(comments))
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltComments)
+		for i, c := range comments {
+			id := uint64(c.ID)
+			if id == 0 {
+				var err error
+				if id, err = b.NextSequence(); err != nil {
+					return err
+				}
+			} else if id > b.Sequence() {
+				if err := b.SetSequence(id); err != nil {
+					return err
+				}
+			}
+			if b.Get(boltKey(int(id))) != nil || tx.Bucket(boltArchive).Get(boltKey(int(id))) != nil {
+				return fmt.Errorf("%w: %d", errDuplicateID, id)
+			}
+			rec := *c
+			rec.setDefaults()
+			rec.ID = int(id)
+			if err := putBoltComment(tx, &rec); err != nil {
+				return err
+			}
+			ids[i] = int(id)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// only touch the callers' comments once the transaction committed
+	for i, c := range comments {
+		c.setDefaults()
+		c.ID = ids[i]
+	}
+	return nil
+}
+
+// siteComments decodes the comments of a site that q selects.
+func (s *boltStore) siteComments(q CommentQuery) ([]Comment, error) {
+	bucket := boltComments
+	if q.Archived {
+		bucket = boltArchive
+	}
+	var comments []Comment
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+			var bc commentRecord
+			if err := json.Unmarshal(v, &bc); err != nil {
+				return err
+			}
+			if c := Comment(bc); q.matches(c) {
+				comments = append(comments, c)
+			}
+			return nil
+		})
+	})
+	return comments, err
+}
+
+func (s *boltStore) List(ctx context.Context, q CommentQuery) ([]Comment, error) {
+	comments, err := s.siteComments(q)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(comments, q.less)
+	return q.page(comments), nil
+}
+
+func (s *boltStore) Each(ctx context.Context, q CommentQuery, fn func(Comment) error) error {
+	comments, err := s.List(ctx, q)
+	if err != nil {
+		return err
+	}
+	for _, c := range comments {
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *boltStore) Get(ctx context.Context, siteID, id int) (*Comment, error) {
+	var c *Comment
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		c, err = getBoltComment(tx, siteID, id)
+		return err
+	})
+	return c, err
+}
+
+func (s *boltStore) Update(ctx context.Context, c *Comment) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		old, err := getBoltComment(tx, c.SiteID, c.ID)
+		if err != nil {
+			return err
+		}
+		old.Name, old.Email, old.Text, old.Location, old.Status = c.Name, c.Email, c.Text, c.Location, c.Status
+		old.SpamScore, old.SpamReasons = c.SpamScore, c.SpamReasons
+		old.Updated = time.Now().UTC()
+		return putBoltComment(tx, old)
+	})
+}
+
+func (s *boltStore) Delete(ctx context.Context, siteID, id int) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if _, err := getBoltComment(tx, siteID, id); err != nil {
+			return err
+		}
+		if err := tx.Bucket(boltComments).Delete(boltKey(id)); err != nil {
+			return err
+		}
+		return deleteBoltLikes(tx, id)
+	})
+}
+
+// deleteBoltLikes forgets who liked comment id.
+func deleteBoltLikes(tx *bolt.Tx, id int) error {
+	// likes are keyed by comment id and then IP
+	likes := tx.Bucket(boltLikes).Cursor()
+	prefix := boltKey(id)
+	for k, _ := likes.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = likes.Seek(prefix) {
+		if err := likes.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *boltStore) Archive(ctx context.Context, siteID int, before time.Time) (int, error) {
+	var ids []int
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		ids = nil
+		comments, archive := tx.Bucket(boltComments), tx.Bucket(boltArchive)
+		moved := map[int][]byte{}
+		err := comments.ForEach(func(k, v []byte) error {
+			var bc commentRecord
+			if err := json.Unmarshal(v, &bc); err != nil {
+				return err
+			}
+			if bc.SiteID == siteID && bc.Created.Before(before) {
+				ids = append(ids, bc.ID)
+				moved[bc.ID] = slices.Clone(v)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// a bucket can't change while ForEach walks it
+		for _, id := range ids {
+			if err := archive.Put(boltKey(id), moved[id]); err != nil {
+				return err
+			}
+			if err := comments.Delete(boltKey(id)); err != nil {
+				return err
+			}
+			if err := deleteBoltLikes(tx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return len(ids), err
+}
+
+func (s *boltStore) Count(ctx context.Context, q CommentQuery) (int, error) {
+	comments, err := s.siteComments(q)
+	return len(comments), err
+}
+
+func (s *boltStore) Search(ctx context.Context, siteID int, query string, limit int) ([]SearchResult, error) {
+	comments, err := s.siteComments(CommentQuery{SiteID: siteID, Status: "approved"})
+	if err != nil {
+		return nil, err
+	}
+	return searchComments(comments, query, limit), nil
+}
+
+func (s *boltStore) Like(ctx context.Context, siteID, id int, ip string) (int, error) {
+	var likes int
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		c, err := getBoltComment(tx, siteID, id)
+		if err != nil {
+			return err
+		}
+		likes = c.Likes
+		key := append(boltKey(id), ip...)
+		if tx.Bucket(boltLikes).Get(key) != nil {
+			return nil
+		}
+		if err := tx.Bucket(boltLikes).Put(key, []byte(time.Now().UTC().Format(time.RFC3339))); err != nil {
+			return err
+		}
+		c.Likes++
+		c.Updated = time.Now().UTC()
+		likes = c.Likes
+		return putBoltComment(tx, c)
+	})
+	return likes, err
+}
+
+func (s *boltStore) Version(ctx context.Context, q CommentQuery) (CommentVersion, error) {
+	comments, err := s.siteComments(q)
+	var v CommentVersion
+	for _, c := range comments {
+		v.add(c)
+	}
+	return v, err
+}
+
+func (s *boltStore) Stats(ctx context.Context, siteID int, now time.Time) (*Stats, error) {
+	comments, err := s.siteComments(CommentQuery{SiteID: siteID})
+	if err != nil {
+		return nil, err
+	}
+	return commentStats(comments, now), nil
+}
+
+func (s *boltStore) BlockIP(ctx context.Context, ip, reason string, expires time.Time) error {
+	defer s.bans.invalidate()
+	now := time.Now().UTC()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBlocklist)
+		entry := boltBlock{Created: now}
+		if v := b.Get([]byte(ip)); v != nil {
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+		}
+		entry.Reason = reason
+		entry.Hits++
+		entry.Updated = now
+		entry.Expires = expires.UTC()
+		v, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(ip), v)
+	})
+}
+
+func (s *boltStore) UnblockIP(ctx context.Context, ip string) error {
+	defer s.bans.invalidate()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBlocklist).Delete([]byte(ip))
+	})
+}
+
+func (s *boltStore) Blocklist(ctx context.Context) ([]BlockedIP, error) {
+	now := time.Now()
+	var blocked []BlockedIP
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBlocklist).ForEach(func(k, v []byte) error {
+			var entry boltBlock
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			if !entry.Expires.IsZero() && !entry.Expires.After(now) {
+				return nil
+			}
+			blocked = append(blocked, BlockedIP{IP: string(k), Reason: entry.Reason, Hits: entry.Hits, Created: entry.Created, Updated: entry.Updated, Expires: entry.Expires})
+			return nil
+		})
+	})
+	sortBlocklist(blocked)
+	return blocked, err
+}
+
+func (s *boltStore) IsBlocked(ctx context.Context, ip string) (bool, error) {
+	return s.bans.isBlocked(ip, 0, func() ([]BlockedIP, error) { return s.Blocklist(ctx) })
+}
+
+func (s *boltStore) RecordBotHit(ctx context.Context, hit BotHit) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBotHits)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		v, err := json.Marshal(boltBotHit{hit, time.Now().UTC()})
+		if err != nil {
+			return err
+		}
+		return b.Put(boltKey(int(id)), v)
+	})
+}
+
+func (s *boltStore) DeleteBotHits(ctx context.Context, before time.Time) (int, error) {
+	var keys [][]byte
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		keys = nil
+		b := tx.Bucket(boltBotHits)
+		err := b.ForEach(func(k, v []byte) error {
+			var hit boltBotHit
+			if err := json.Unmarshal(v, &hit); err != nil {
+				return err
+			}
+			if hit.Created.Before(before) {
+				keys = append(keys, slices.Clone(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// a bucket can't change while ForEach walks it
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return len(keys), err
+}
+
+func (s *boltStore) Ping(ctx context.Context) error {
+	return s.db.View(func(tx *bolt.Tx) error { return nil })
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestBoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "guestbook.bolt")
+	s, err := openBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, s)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// everything survives reopening the file
+	s, err = openBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	if n, err := s.Count(ctx, CommentQuery{}); err != nil || n != 2 {
+		t.Errorf("Count() after reopening = %d, %v; want 2", n, err)
+	}
+	if blocked, _ := s.IsBlocked(ctx, "7.7.7.7"); !blocked {
+		t.Error("Blocklist lost after reopening")
+	}
+
+	c := &Comment{Name: "Dan", Email: "dan@example.com", Text: "Still here"}
+	if err := s.Create(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	if c.ID != 503 {
+		t.Errorf("ID after reopening = %d, want 503 since IDs are never reused", c.ID)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Calls to other services go through a circuit breaker per target: after
+// breaker_failures failures in a row the target is left alone for
+// breaker_cooldown seconds, and calls to it fail straight away. Once the
+// cooldown is over one call is let through to see whether it's back.
+
+var errCircuitOpen = errors.New("circuit open")
+
+type breakerState struct {
+	failures  int
+	openUntil time.Time
+}
+
+// breakers holds the targets that failed since they last succeeded.
+var breakers = struct {
+	sync.Mutex
+	m map[string]*breakerState
+}{m: map[string]*breakerState{}}
+
+// breakerMaxTargets bounds breakers, since the hosts Webmentions go to
+// are up to whoever writes a comment.
+const breakerMaxTargets = 10000
+
+// breakerAllow returns an error wrapping errCircuitOpen if target's
+// breaker is open.
+func breakerAllow(target string, now time.Time) error {
+	c := settings()
+	breakers.Lock()
+	defer breakers.Unlock()
+	b := breakers.m[target]
+	if b == nil || c.BreakerFailures == 0 || b.failures < c.BreakerFailures {
+		return nil
+	}
+	if now.Before(b.openUntil) {
+		return fmt.Errorf("%s: %w until %s", target, errCircuitOpen, b.openUntil.UTC().Format(time.TimeOnly))
+	}
+	// this call is the trial; the rest wait for another cooldown unless
+	// it succeeds
+	b.openUntil = now.Add(time.Duration(c.BreakerCooldown) * time.Second)
+	return nil
+}
+
+// breakerDone records how a call to target went.
+func breakerDone(target string, failed bool, now time.Time) {
+	c := settings()
+	breakers.Lock()
+	defer breakers.Unlock()
+	if !failed {
+		delete(breakers.m, target)
+		return
+	}
+	b := breakers.m[target]
+	if b == nil {
+		if len(breakers.m) >= breakerMaxTargets {
+			for t, s := range breakers.m {
+				if !now.Before(s.openUntil) {
+					delete(breakers.m, t)
+				}
+			}
+		}
+		b = &breakerState{}
+		breakers.m[target] = b
+	}
+	b.failures++
+	if c.BreakerFailures > 0 && b.failures >= c.BreakerFailures {
+		if b.failures == c.BreakerFailures {
+			logger.Warn("Circuit breaker opened", "target", target, "failures", b.failures, "cooldown", c.BreakerCooldown)
+		}
+		b.openUntil = now.Add(time.Duration(c.BreakerCooldown) * time.Second)
+	}
+}
+
+// callExternal runs fn behind target's breaker and gives up on it after
+// timeout, if that's set, even if fn doesn't watch its context.
+func callExternal[T any](ctx context.Context, target string, timeout time.Duration, fn func(context.Context) (T, error)) (T, error) {
+	var zero T
+	if err := breakerAllow(target, time.Now()); err != nil {
+		return zero, err
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	type result struct {
+		v   T
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		v, err := fn(ctx)
+		done <- result{v, err}
+	}()
+	select {
+	case r := <-done:
+		breakerDone(target, r.err != nil, time.Now())
+		return r.v, r.err
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			breakerDone(target, true, time.Now())
+		}
+		return zero, fmt.Errorf("%s: %w", target, ctx.Err())
+	}
+}
+
+// breakerTransport puts a breaker in front of every host a client talks
+// to. Errors, 5xx and 429 count as failures, requests the caller cancels
+// don't count at all.
+type breakerTransport struct{ next http.RoundTripper }
+
+func (t breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if err := breakerAllow(host, time.Now()); err != nil {
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil && errors.Is(req.Context().Err(), context.Canceled) {
+		return nil, err
+	}
+	breakerDone(host, err != nil || resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, time.Now())
+	return resp, err
+}
+
+// lookupLocation is getLocation behind the "geoip" breaker, bounded by
+// lookup_timeout so a slow lookup can't hold up a comment. Comments get
+// "Unknown Location" when it fails.
+func lookupLocation(ctx context.Context, log *slog.Logger, ip string) string {
+	loc, err := callExternal(ctx, "geoip", time.Duration(settings().LookupTimeout)*time.Second, func(context.Context) (string, error) {
+		return getLocation(ip), nil
+	})
+	if err != nil {
+		log.Warn("Location lookup failed", "error", err)
+		return "Unknown Location"
+	}
+	return loc
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCallExternal(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.BreakerFailures, config.BreakerCooldown = 2, 60
+	ctx := context.Background()
+	slow := func(context.Context) (string, error) {
+		time.Sleep(time.Second)
+		return "late", nil
+	}
+
+	start := time.Now()
+	for range 2 {
+		if _, err := callExternal(ctx, "test-slow", 10*time.Millisecond, slow); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Slow call = %v, want a timeout", err)
+		}
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Timed out calls took %v", time.Since(start))
+	}
+	called := false
+	if _, err := callExternal(ctx, "test-slow", 0, func(context.Context) (string, error) { called = true; return "", nil }); !errors.Is(err, errCircuitOpen) || called {
+		t.Errorf("Call with the breaker open = %v, called %v", err, called)
+	}
+
+	// after the cooldown one trial call goes through and closes it again
+	breakers.Lock()
+	breakers.m["test-slow"].openUntil = time.Now()
+	breakers.Unlock()
+	if v, err := callExternal(ctx, "test-slow", 0, func(context.Context) (string, error) { return "ok", nil }); err != nil || v != "ok" {
+		t.Errorf("Trial call = %q, %v", v, err)
+	}
+	if err := breakerAllow("test-slow", time.Now()); err != nil {
+		t.Errorf("Breaker still open after a success: %v", err)
+	}
+}
+
+func TestBreakerTransport(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.BreakerFailures, config.BreakerCooldown = 3, 60
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: breakerTransport{srv.Client().Transport}}
+
+	for i := range 5 {
+		resp, err := client.Get(srv.URL)
+		if i < 3 {
+			if err != nil || resp.StatusCode != http.StatusBadGateway {
+				t.Fatalf("Request %d = %v", i, err)
+			}
+			resp.Body.Close()
+		} else if !errors.Is(err, errCircuitOpen) {
+			t.Errorf("Request %d with the breaker open = %v", i, err)
+		}
+	}
+	if hits != 3 {
+		t.Errorf("Server got %d requests, want 3", hits)
+	}
+}
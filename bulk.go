@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// bulkCommentsHandler creates the comments in a JSON array of the objects
+// /admin/export writes, in one go. IDs, timestamps and statuses are taken
+// as given, so it's meant for migrating existing comments in.
+func bulkCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var recs []commentRecord
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&recs); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			httpErrorDetails(w, r, http.StatusRequestEntityTooLarge, codeBodyTooLarge,
+				"Request body is larger than "+strconv.FormatInt(tooLarge.Limit, 10)+" bytes", map[string]any{"limit": tooLarge.Limit})
+			return
+		}
+		httpError(w, r, 400, codeInvalidJSON, "Body must be a JSON array of comments: "+err.Error())
+		return
+	}
+
+	site := siteFor(r)
+	comments := make([]*Comment, len(recs))
+	for i, rec := range recs {
+		if msg := validateBulkComment(rec); msg != "" {
+			httpErrorDetails(w, r, 400, codeInvalidComment, fmt.Sprintf("comment %d: %s", i, msg), map[string]any{"index": i})
+			return
+		}
+		c := Comment(rec)
+		c.SiteID = site.ID
+		comments[i] = &c
+	}
+
+	err := store.CreateMany(r.Context(), comments)
+	if errors.Is(err, errDuplicateID) {
+		httpError(w, r, http.StatusConflict, codeDuplicateID, err.Error())
+		return
+	}
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+
+	ids := make([]int, len(comments))
+	for i, c := range comments {
+		ids[i] = c.ID
+	}
+	requestLogger(r).Info("comments created in bulk", "site", site.Slug, "count", len(comments))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{"created": len(ids), "ids": ids})
+}
+
+// validateBulkComment returns what's wrong with rec, or "".
+func validateBulkComment(rec commentRecord) string {
+	switch {
+	case rec.Name == "" || rec.Text == "":
+		return "name and text are required"
+	case rec.ID < 0 || rec.ParentID < 0 || rec.Likes < 0:
+		return "id, parent_id and likes must not be negative"
+	case rec.Status != "" && !slices.Contains(commentStatuses, rec.Status):
+		return fmt.Sprintf("status %q must be approved, pending, spam or unconfirmed", rec.Status)
+	}
+	return ""
+}
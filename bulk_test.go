@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBulkCommentsHandler(t *testing.T) {
+	defer func(s CommentStore) { store = s }(store)
+	store = newMemoryStore()
+
+	body := `[
+		{"id": 10, "name": "Ann", "email": "ann@example.com", "text": "Old one", "ip": "192.0.2.1", "created": "2019-01-01T12:00:00Z", "status": "spam", "likes": 3},
+		{"name": "Bob", "text": "Reply", "parent_id": 10, "site_id": 42}
+	]`
+	rec := httptest.NewRecorder()
+	bulkCommentsHandler(rec, httptest.NewRequest("POST", "/admin/comments/bulk", strings.NewReader(body)))
+	if rec.Code != 201 {
+		t.Fatalf("POST /admin/comments/bulk = %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Created int   `json:"created"`
+		IDs     []int `json:"ids"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.Created != 2 || resp.IDs[0] != 10 || resp.IDs[1] != 11 {
+		t.Errorf("Response = %+v, %v", resp, err)
+	}
+
+	ann, err := store.Get(t.Context(), 0, 10)
+	if err != nil || ann.Status != "spam" || ann.Likes != 3 || !ann.Created.Equal(time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("Comment 10 = %+v, %v", ann, err)
+	}
+	// site_id comes from the request, not the body
+	if bob, err := store.Get(t.Context(), 0, 11); err != nil || bob.ParentID != 10 || bob.Status != "approved" {
+		t.Errorf("Comment 11 = %+v, %v", bob, err)
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantErr  string
+	}{
+		{"Not an array", `{"name": "Ann"}`, 400, codeInvalidJSON},
+		{"Unknown field", `[{"name": "Ann", "text": "Hi", "comment": "typo"}]`, 400, codeInvalidJSON},
+		{"Missing text", `[{"name": "Ann", "text": "Hi"}, {"name": "Bob"}]`, 400, codeInvalidComment},
+		{"Bad status", `[{"name": "Ann", "text": "Hi", "status": "deleted"}]`, 400, codeInvalidComment},
+		{"Negative ID", `[{"id": -1, "name": "Ann", "text": "Hi"}]`, 400, codeInvalidComment},
+		{"Taken ID", `[{"id": 99, "name": "Ann", "text": "Hi"}, {"id": 10, "name": "Ann", "text": "Hi"}]`, 409, codeDuplicateID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			bulkCommentsHandler(rec, httptest.NewRequest("POST", "/admin/comments/bulk", strings.NewReader(tt.body)))
+			if rec.Code != tt.wantCode || rec.Header().Get("X-Error-Code") != tt.wantErr {
+				t.Errorf("Status = %d %s, want %d %s", rec.Code, rec.Body, tt.wantCode, tt.wantErr)
+			}
+		})
+	}
+	if n, _ := store.Count(t.Context(), CommentQuery{}); n != 2 {
+		t.Errorf("%d comments after rejected requests, want 2", n)
+	}
+}
+
+func BenchmarkBulkCreate(b *testing.B) {
+	withTempDB(b)
+	if err := initSchema(); err != nil {
+		b.Fatal(err)
+	}
+	s := &sqlStore{db: db}
+	comments := make([]*Comment, 10000)
+	for b.Loop() {
+		for i := range comments {
+			comments[i] = &Comment{Name: "Ann", Email: "ann@example.com", Text: "Migrated comment number " + strings.Repeat("x", i%50)}
+		}
+		if err := s.CreateMany(b.Context(), comments); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
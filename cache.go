@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// cachedResponse is a serialized GET /comments listing, the version it was
+// built at and the cache generation of its site when it was read.
+type cachedResponse struct {
+	Generation int64          `json:"generation"`
+	Body       []byte         `json:"body"`
+	Version    CommentVersion `json:"version"`
+}
+
+// The recent-comments response of every site lives in shared state, next
+// to a generation counter that every write through cachingStore bumps. A
+// cached response only counts while its generation is current, so a
+// listing read just before a write can't be served after it, and with
+// Redis a write on one instance reaches all of them. The TTL bounds how
+// stale it gets when other processes write to the database.
+func recentKey(siteID int) string {
+	return "recent:" + strconv.Itoa(siteID)
+}
+
+func recentGenerationKey(siteID int) string {
+	return "recent-generation:" + strconv.Itoa(siteID)
+}
+
+// cachedRecent returns the cached response of a site and the current
+// generation, to hand to cacheRecent on a miss.
+func cachedRecent(ctx context.Context, siteID int) (cachedResponse, int64, bool, error) {
+	gen, err := recentGeneration(ctx, siteID)
+	if err != nil {
+		return cachedResponse{}, 0, false, err
+	}
+
+	var e cachedResponse
+	v, ok, err := shared.Get(ctx, recentKey(siteID))
+	if err != nil || !ok {
+		return e, gen, false, err
+	}
+	if err := json.Unmarshal(v, &e); err != nil {
+		return e, gen, false, err
+	}
+	return e, gen, e.Generation == gen, nil
+}
+
+// recentGeneration returns the cache generation of a site, which changes
+// with every write to its comments on any instance.
+func recentGeneration(ctx context.Context, siteID int) (int64, error) {
+	v, ok, err := shared.Get(ctx, recentGenerationKey(siteID))
+	if err != nil || !ok {
+		return 0, err
+	}
+	gen, _ := strconv.ParseInt(string(v), 10, 64)
+	return gen, nil
+}
+
+func cacheRecent(ctx context.Context, siteID int, e cachedResponse, ttl time.Duration) error {
+	v, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return shared.Set(ctx, recentKey(siteID), v, ttl)
+}
+
+func invalidateRecent(ctx context.Context, siteID int) {
+	// the counter never expires, or an old response could match again
+	_, err := shared.Incr(ctx, recentGenerationKey(siteID), 0)
+	if err == nil {
+		err = shared.Delete(ctx, recentKey(siteID))
+	}
+	if err != nil {
+		logger.Warn("invalidating the response cache failed", "site", siteID, "error", err)
+	}
+}
+
+// cachingStore invalidates the recent-comments cache on every write that
+// can change a listing.
+type cachingStore struct {
+	CommentStore
+}
+
+func (s cachingStore) Create(ctx context.Context, c *Comment) error {
+	defer invalidateRecent(ctx, c.SiteID)
+	return s.CommentStore.Create(ctx, c)
+}
+
+func (s cachingStore) CreateMany(ctx context.Context, comments []*Comment) error {
+	defer func() {
+		seen := map[int]bool{}
+		for _, c := range comments {
+			if !seen[c.SiteID] {
+				seen[c.SiteID] = true
+				invalidateRecent(ctx, c.SiteID)
+			}
+		}
+	}()
+	return s.CommentStore.CreateMany(ctx, comments)
+}
+
+func (s cachingStore) Update(ctx context.Context, c *Comment) error {
+	defer invalidateRecent(ctx, c.SiteID)
+	return s.CommentStore.Update(ctx, c)
+}
+
+func (s cachingStore) Delete(ctx context.Context, siteID, id int) error {
+	defer invalidateRecent(ctx, siteID)
+	return s.CommentStore.Delete(ctx, siteID, id)
+}
+
+func (s cachingStore) Like(ctx context.Context, siteID, id int, ip string) (int, error) {
+	defer invalidateRecent(ctx, siteID)
+	return s.CommentStore.Like(ctx, siteID, id, ip)
+}
+
+func (s cachingStore) Archive(ctx context.Context, siteID int, before time.Time) (int, error) {
+	defer invalidateRecent(ctx, siteID)
+	return s.CommentStore.Archive(ctx, siteID, before)
+}
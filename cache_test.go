@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecentCommentsCache(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	defer func(s CommentStore) { store = s }(store)
+	config.ResponseCacheSeconds = 60
+	store = cachingStore{newMemoryStore()}
+	defer func(s SharedState) { shared = s }(shared)
+	shared = newMemoryState()
+
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		getComments(rec, httptest.NewRequest("GET", target, nil), 15)
+		return rec
+	}
+	c := &Comment{Name: "Ann", Email: "ann@example.com", Text: "First"}
+	store.Create(t.Context(), c)
+
+	steps := []struct {
+		name      string
+		write     func()
+		target    string
+		wantCache string
+		wantText  string
+	}{
+		{"Cold", nil, "/comments", "MISS", "First"},
+		{"Warm", nil, "/comments", "HIT", "First"},
+		{"Filtered listings aren't cached", nil, "/comments?sort=oldest", "", "First"},
+		{"New comment", func() { store.Create(t.Context(), &Comment{Name: "Bob", Email: "bob@example.com", Text: "Second"}) }, "/comments", "MISS", "Second"},
+		{"Warm again", nil, "/comments", "HIT", "Second"},
+		{"Like", func() { store.Like(t.Context(), 0, c.ID, "192.0.2.1") }, "/comments", "MISS", `"likes":1`},
+		{"Other site written", func() { store.Create(t.Context(), &Comment{SiteID: 5, Name: "Cy", Text: "Elsewhere"}) }, "/comments", "HIT", `"likes":1`},
+		{"Moderation", func() { c.Status = "spam"; store.Update(t.Context(), c) }, "/comments", "MISS", "Second"},
+	}
+	for _, step := range steps {
+		if step.write != nil {
+			step.write()
+		}
+		rec := get(step.target)
+		if got := rec.Header().Get("X-Cache"); got != step.wantCache {
+			t.Errorf("%s: X-Cache = %q, want %q", step.name, got, step.wantCache)
+		}
+		if !strings.Contains(rec.Body.String(), step.wantText) {
+			t.Errorf("%s: body %s doesn't contain %s", step.name, rec.Body, step.wantText)
+		}
+	}
+	if strings.Contains(get("/comments").Body.String(), "First") {
+		t.Error("Comment marked as spam still listed")
+	}
+
+	// a hit still answers conditional requests
+	etag := get("/comments").Header().Get("ETag")
+	req := httptest.NewRequest("GET", "/comments", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec := httptest.NewRecorder()
+	getComments(rec, req, 15)
+	if rec.Code != 304 || rec.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("Conditional hit = %d %s", rec.Code, rec.Header().Get("X-Cache"))
+	}
+
+	config.ResponseCacheSeconds = 0
+	if rec := get("/comments"); rec.Header().Get("X-Cache") != "" {
+		t.Error("Cache used with response_cache_seconds = 0")
+	}
+}
+
+func TestRecentCacheGenerations(t *testing.T) {
+	defer func(s SharedState) { shared = s }(shared)
+	shared = newMemoryState()
+	ctx := t.Context()
+
+	_, gen, hit, err := cachedRecent(ctx, 1)
+	if hit || err != nil {
+		t.Fatalf("cachedRecent() on an empty cache = %v, %v", hit, err)
+	}
+	// a write between reading the listing and caching it wins
+	invalidateRecent(ctx, 1)
+	cacheRecent(ctx, 1, cachedResponse{Generation: gen, Body: []byte("stale")}, time.Minute)
+	if _, _, hit, _ := cachedRecent(ctx, 1); hit {
+		t.Error("Response read before a write was cached")
+	}
+
+	_, gen, _, _ = cachedRecent(ctx, 1)
+	cacheRecent(ctx, 1, cachedResponse{Generation: gen, Body: []byte("fresh")}, time.Minute)
+	if e, _, hit, _ := cachedRecent(ctx, 1); !hit || string(e.Body) != "fresh" {
+		t.Errorf("cachedRecent() = %q, %v", e.Body, hit)
+	}
+	if _, _, hit, _ := cachedRecent(ctx, 2); hit {
+		t.Error("Cache shared between sites")
+	}
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// cliSite resolves the -site flag of a subcommand, 0 for the single-tenant
+// guestbook.
+func cliSite(ctx context.Context, slug string) (*Site, error) {
+	if slug == "" {
+		return defaultSite, nil
+	}
+	if db == nil {
+		return nil, errors.New("sites need db_driver sqlite3 or mysql")
+	}
+	site, err := lookupSite(ctx, "", slug)
+	if err != nil {
+		return nil, fmt.Errorf("site %s: %w", slug, err)
+	}
+	return site, nil
+}
+
+// runComments implements the comments subcommand:
+//
+//	guestbook comments [-site slug] [-status s] [-limit n] [-json]
+func runComments(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("comments", flag.ContinueOnError)
+	slug := fs.String("site", "", "site to list (default: the single-tenant guestbook)")
+	status := fs.String("status", "", "only comments with this status: approved, pending, spam or unconfirmed")
+	limit := fs.Int("limit", 20, "number of comments, newest first; 0 for all")
+	asJSON := fs.Bool("json", false, "print JSON lines instead of a table")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("usage: guestbook comments [-site slug] [-status s] [-limit n] [-json]")
+	}
+	ctx := context.Background()
+	site, err := cliSite(ctx, *slug)
+	if err != nil {
+		return err
+	}
+	comments, err := store.List(ctx, CommentQuery{SiteID: site.ID, Status: *status, Limit: max(*limit, 0)})
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		for _, c := range comments {
+			if err := enc.Encode(commentRecord(c)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return writeCommentTable(out, comments)
+}
+
+// writeCommentTable lists comments one per line.
+func writeCommentTable(out io.Writer, comments []Comment) error {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATUS\tCREATED\tNAME\tIP\tTEXT")
+	for _, c := range comments {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", c.ID, c.Status, c.Created.Format(time.DateTime), c.Name, c.IP, snippet(c.Text, 60))
+	}
+	return tw.Flush()
+}
+
+// snippet shortens text to one line of at most n characters.
+func snippet(text string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if r := []rune(text); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return text
+}
+
+// isNotFound reports whether moderateComment refused because the comment
+// doesn't exist.
+func isNotFound(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.code == codeNotFound
+}
+
+// moderateCommand returns the approve, pending, spam or delete
+// subcommand, which take one or more comment IDs:
+//
+//	guestbook approve [-site slug] <id>...
+func moderateCommand(action string) func(args []string, out io.Writer) error {
+	return func(args []string, out io.Writer) error {
+		return runModerate(action, args, out)
+	}
+}
+
+func runModerate(action string, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet(action, flag.ContinueOnError)
+	slug := fs.String("site", "", "site of the comments (default: the single-tenant guestbook)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: guestbook %s [-site slug] <id>...", action)
+	}
+	var ids []int
+	for _, arg := range fs.Args() {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return fmt.Errorf("%q is not a comment id", arg)
+		}
+		ids = append(ids, id)
+	}
+	ctx := context.Background()
+	site, err := cliSite(ctx, *slug)
+	if err != nil {
+		return err
+	}
+	if site.Archived {
+		return fmt.Errorf("site %s is archived and read-only", site.Slug)
+	}
+
+	status := action
+	if action == "approve" {
+		status = "approved"
+	}
+	for _, id := range ids {
+		if action == "delete" {
+			err = store.Delete(ctx, site.ID, id)
+		} else {
+			_, err = moderateComment(ctx, site.ID, id, status)
+		}
+		if err == errNotFound || isNotFound(err) {
+			return fmt.Errorf("comment %d not found", id)
+		} else if err != nil {
+			return fmt.Errorf("comment %d: %w", id, err)
+		}
+		if action == "delete" {
+			fmt.Fprintf(out, "Deleted comment %d\n", id)
+		} else {
+			fmt.Fprintf(out, "Comment %d is now %s\n", id, status)
+		}
+	}
+	return nil
+}
+
+// parseAge reads the -older-than flag of purge and moderate: a Go duration
+// or a number of days or weeks, like 90d or 2w.
+func parseAge(s string) (time.Duration, error) {
+	if n, ok := strings.CutSuffix(s, "d"); ok {
+		if days, err := strconv.Atoi(n); err == nil && days >= 0 {
+			return time.Duration(days) * 24 * time.Hour, nil
+		}
+	} else if n, ok := strings.CutSuffix(s, "w"); ok {
+		if weeks, err := strconv.Atoi(n); err == nil && weeks >= 0 {
+			return time.Duration(weeks) * 7 * 24 * time.Hour, nil
+		}
+	} else if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return d, nil
+	}
+	return 0, fmt.Errorf("%q is not an age like 90d, 2w or 36h", s)
+}
+
+// bulkSelect lists the comments of a site with one of statuses created
+// until then, or at any time if it's zero, oldest first.
+func bulkSelect(ctx context.Context, siteID int, statuses []string, until time.Time) ([]Comment, error) {
+	var comments []Comment
+	for _, status := range statuses {
+		list, err := store.List(ctx, CommentQuery{SiteID: siteID, Status: status, Until: until, Sort: "oldest"})
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, list...)
+	}
+	slices.SortStableFunc(comments, func(a, b Comment) int { return a.Created.Compare(b.Created) })
+	return comments, nil
+}
+
+// runPurge implements the purge subcommand, which deletes spam or pending
+// comments in bulk:
+//
+//	guestbook purge [-site slug] [-spam] [-pending] [-older-than 90d] [-dry-run]
+func runPurge(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("purge", flag.ContinueOnError)
+	slug := fs.String("site", "", "site to clean up (default: the single-tenant guestbook)")
+	spam := fs.Bool("spam", false, "delete spam")
+	pending := fs.Bool("pending", false, "delete comments waiting for moderation or confirmation")
+	olderThan := fs.String("older-than", "", "only comments older than this, like 90d or 36h")
+	dryRun := fs.Bool("dry-run", false, "list the comments instead of deleting them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var statuses []string
+	if *spam {
+		statuses = append(statuses, "spam")
+	}
+	if *pending {
+		statuses = append(statuses, "pending", "unconfirmed")
+	}
+	if fs.NArg() != 0 || len(statuses) == 0 {
+		return errors.New("usage: guestbook purge [-site slug] [-spam] [-pending] [-older-than 90d] [-dry-run]")
+	}
+	return bulkModerate(*slug, statuses, *olderThan, "delete", *dryRun, out)
+}
+
+// runModerateAll implements the moderate subcommand, which approves or
+// rejects the whole moderation queue:
+//
+//	guestbook moderate [-site slug] -approve-all-pending|-spam-all-pending [-older-than 7d] [-dry-run]
+func runModerateAll(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("moderate", flag.ContinueOnError)
+	slug := fs.String("site", "", "site to moderate (default: the single-tenant guestbook)")
+	approve := fs.Bool("approve-all-pending", false, "approve every pending comment")
+	spam := fs.Bool("spam-all-pending", false, "mark every pending comment as spam")
+	olderThan := fs.String("older-than", "", "only comments older than this, like 7d or 36h")
+	dryRun := fs.Bool("dry-run", false, "list the comments instead of changing them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || *approve == *spam {
+		return errors.New("usage: guestbook moderate [-site slug] -approve-all-pending|-spam-all-pending [-older-than 7d] [-dry-run]")
+	}
+	action := "approve"
+	if *spam {
+		action = "spam"
+	}
+	return bulkModerate(*slug, []string{"pending"}, *olderThan, action, *dryRun, out)
+}
+
+// bulkModerate applies action, as the approve, spam or delete subcommands
+// do, to the comments with one of statuses older than olderThan. With
+// dryRun it only lists them.
+func bulkModerate(slug string, statuses []string, olderThan, action string, dryRun bool, out io.Writer) error {
+	ctx := context.Background()
+	site, err := cliSite(ctx, slug)
+	if err != nil {
+		return err
+	}
+	if site.Archived && !dryRun {
+		return fmt.Errorf("site %s is archived and read-only", site.Slug)
+	}
+	var until time.Time
+	if olderThan != "" {
+		age, err := parseAge(olderThan)
+		if err != nil {
+			return err
+		}
+		until = time.Now().Add(-age)
+	}
+	comments, err := bulkSelect(ctx, site.ID, statuses, until)
+	if err != nil {
+		return err
+	}
+	verbs := map[string][2]string{"approve": {"approve", "Approved"}, "spam": {"mark as spam", "Marked as spam"}, "delete": {"delete", "Deleted"}}
+	if dryRun {
+		if len(comments) > 0 {
+			if err := writeCommentTable(out, comments); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(out, "Would %s %d comments\n", verbs[action][0], len(comments))
+		return nil
+	}
+
+	status := map[string]string{"approve": "approved", "spam": "spam"}[action]
+	for i, c := range comments {
+		if action == "delete" {
+			err = store.Delete(ctx, site.ID, c.ID)
+		} else {
+			_, err = moderateComment(ctx, site.ID, c.ID, status)
+		}
+		// a comment someone else deleted meanwhile needs nothing more
+		if err != nil && err != errNotFound && !isNotFound(err) {
+			fmt.Fprintf(out, "%s %d comments\n", verbs[action][1], i)
+			return fmt.Errorf("comment %d: %w", c.ID, err)
+		}
+	}
+	fmt.Fprintf(out, "%s %d comments\n", verbs[action][1], len(comments))
+	return nil
+}
+
+// runBan implements the ban subcommand:
+//
+//	guestbook ban [-for 24h] [-reason r] <ip|network>...
+func runBan(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("ban", flag.ContinueOnError)
+	duration := fs.Duration("for", 0, "how long the ban lasts (default: for good)")
+	reason := fs.String("reason", "banned from the command line", "reason shown on the dashboard")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 || *duration < 0 {
+		return errors.New("usage: guestbook ban [-for 24h] [-reason r] <ip|network>...")
+	}
+	keys, err := banKeys(fs.Args())
+	if err != nil {
+		return err
+	}
+	var expires time.Time
+	if *duration > 0 {
+		expires = time.Now().Add(*duration)
+	}
+	for _, key := range keys {
+		if err := store.BlockIP(context.Background(), key, *reason, expires); err != nil {
+			return err
+		}
+		if expires.IsZero() {
+			fmt.Fprintf(out, "Banned %s\n", key)
+		} else {
+			fmt.Fprintf(out, "Banned %s until %s\n", key, expires.UTC().Format(time.DateTime))
+		}
+	}
+	return nil
+}
+
+// runUnban implements `guestbook unban <ip|network>...`.
+func runUnban(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("usage: guestbook unban <ip|network>...")
+	}
+	keys, err := banKeys(args)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := store.UnblockIP(context.Background(), key); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Unbanned %s\n", key)
+	}
+	return nil
+}
+
+// banKeys checks all of args before anything is banned, so a typo doesn't
+// leave half of them done.
+func banKeys(args []string) ([]string, error) {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		p, err := parseNetwork(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP address or a network like 203.0.113.0/24", arg)
+		}
+		keys[i] = banKey(p)
+	}
+	return keys, nil
+}
+
+// runBans implements `guestbook bans`, which lists the blocklist.
+func runBans(args []string, out io.Writer) error {
+	if len(args) != 0 {
+		return errors.New("usage: guestbook bans")
+	}
+	bans, err := store.Blocklist(context.Background())
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "IP\tHITS\tBANNED\tEXPIRES\tREASON")
+	for _, b := range bans {
+		expires := "never"
+		if !b.Expires.IsZero() {
+			expires = b.Expires.UTC().Format(time.DateTime)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", b.IP, b.Hits, b.Updated.UTC().Format(time.DateTime), expires, b.Reason)
+	}
+	return tw.Flush()
+}
+
+// runStats implements the stats subcommand:
+//
+//	guestbook stats [-site slug] [-json]
+func runStats(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	slug := fs.String("site", "", "site to count (default: the single-tenant guestbook)")
+	asJSON := fs.Bool("json", false, "print the same JSON as /admin/stats")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("usage: guestbook stats [-site slug] [-json]")
+	}
+	ctx := context.Background()
+	site, err := cliSite(ctx, *slug)
+	if err != nil {
+		return err
+	}
+	stats, err := store.Stats(ctx, site.ID, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return json.NewEncoder(out).Encode(stats)
+	}
+
+	last7 := 0
+	for _, d := range stats.PerDay[max(len(stats.PerDay)-7, 0):] {
+		last7 += d.Count
+	}
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Comments\t%d\n", stats.Total)
+	for _, status := range commentStatuses {
+		fmt.Fprintf(tw, "  %s\t%d\n", status, stats.ByStatus[status])
+	}
+	fmt.Fprintf(tw, "Last 7 days\t%d\n", last7)
+	fmt.Fprintf(tw, "Likes\t%d\n", stats.Likes)
+	if len(stats.TopNames) > 0 {
+		fmt.Fprintf(tw, "Top commenter\t%s (%d)\n", stats.TopNames[0].Key, stats.TopNames[0].Count)
+	}
+	return tw.Flush()
+}
+
+// runBackup implements `guestbook backup`, the same snapshot as
+// POST /admin/backup.
+func runBackup(args []string, out io.Writer) error {
+	if len(args) != 0 {
+		return errors.New("usage: guestbook backup")
+	}
+	b, err := createBackup(context.Background())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Created backup %s (%d bytes)\n", b.Name, b.Size)
+	if b.S3Key != "" {
+		fmt.Fprintf(out, "Uploaded to %s\n", b.S3Key)
+	} else if b.UploadError != "" {
+		return fmt.Errorf("uploading backup: %s", b.UploadError)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestModerationCommands(t *testing.T) {
+	defer func(s CommentStore) { store = s }(store)
+	store = newMemoryStore()
+	ctx := context.Background()
+
+	run := func(args ...string) (string, error) {
+		var out bytes.Buffer
+		err := commands[args[0]].run(args[1:], &out)
+		return out.String(), err
+	}
+	var ids []string
+	for _, c := range []*Comment{
+		{Name: "Ann", Text: "Hello\nthere", IP: "192.0.2.1", Status: "pending"},
+		{Name: "Bob", Text: "Buy now", IP: "198.51.100.2", Status: "pending"},
+	} {
+		if err := store.Create(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, strconv.Itoa(c.ID))
+	}
+
+	out, err := run("comments", "-status", "pending")
+	if err != nil || strings.Count(out, "\n") != 3 || !strings.Contains(out, "Hello there") {
+		t.Errorf("comments = %v:\n%s", err, out)
+	}
+	if _, err := run("approve", ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run("spam", ids[1], "999"); err == nil || !strings.Contains(err.Error(), "comment 999 not found") {
+		t.Errorf("spam of a missing comment = %v", err)
+	}
+	if out, _ := run("comments", "-json", "-status", "spam"); !strings.Contains(out, `"name":"Bob"`) || strings.Count(out, "\n") != 1 {
+		t.Errorf("comments -json = %s", out)
+	}
+	if out, err := run("delete", ids[1]); err != nil || out != "Deleted comment "+ids[1]+"\n" {
+		t.Errorf("delete = %q, %v", out, err)
+	}
+	if out, _ := run("stats"); !regexp.MustCompile(`(?m)^Comments +1\n +approved +1$`).MatchString(out) {
+		t.Errorf("stats =\n%s", out)
+	}
+
+	if _, err := run("ban", "203.0.113.0/24", "not-an-ip"); err == nil {
+		t.Error("ban with a bad address succeeded")
+	}
+	if blocked, _ := store.Blocklist(ctx); len(blocked) != 0 {
+		t.Errorf("Blocklist after a failed ban = %+v", blocked)
+	}
+	if _, err := run("ban", "-for", "1h", "203.0.113.9/24", "2001:db8::1"); err != nil {
+		t.Fatal(err)
+	}
+	if blocked, _ := store.IsBlocked(ctx, "203.0.113.77"); !blocked {
+		t.Error("network not banned")
+	}
+	out, _ = run("bans")
+	if !strings.Contains(out, "203.0.113.0/24") || !strings.Contains(out, "2001:db8::1") || strings.Contains(out, "never") {
+		t.Errorf("bans =\n%s", out)
+	}
+	if _, err := run("unban", "203.0.113.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	if blocked, _ := store.IsBlocked(ctx, "203.0.113.77"); blocked {
+		t.Error("network still banned after unban")
+	}
+	if _, err := run("backup"); err != errBackupUnsupported {
+		t.Errorf("backup without sqlite3 = %v", err)
+	}
+	if blocked, _ := store.Blocklist(ctx); len(blocked) != 1 || blocked[0].Expires.Before(time.Now()) {
+		t.Errorf("Blocklist = %+v", blocked)
+	}
+}
+
+func TestBulkCommands(t *testing.T) {
+	defer func(s CommentStore) { store = s }(store)
+	store = newMemoryStore()
+	ctx := context.Background()
+	old := time.Now().AddDate(0, 0, -100)
+	for _, c := range []*Comment{
+		{Name: "Old spam", Status: "spam", Created: old},
+		{Name: "New spam", Status: "spam"},
+		{Name: "Old pending", Status: "pending", Created: old},
+		{Name: "New pending", Status: "pending"},
+		{Name: "Approved", Created: old},
+	} {
+		if err := store.Create(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run := func(args ...string) string {
+		t.Helper()
+		var out bytes.Buffer
+		if err := commands[args[0]].run(args[1:], &out); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+	count := func(status string) int {
+		n, _ := store.Count(ctx, CommentQuery{Status: status})
+		return n
+	}
+
+	if out := run("purge", "--spam", "--older-than", "90d", "--dry-run"); !strings.Contains(out, "Old spam") || strings.Contains(out, "New spam") ||
+		!strings.HasSuffix(out, "Would delete 1 comments\n") || count("spam") != 2 {
+		t.Errorf("purge -dry-run =\n%s", out)
+	}
+	if out := run("purge", "-spam", "-pending", "-older-than", "90d"); out != "Deleted 2 comments\n" || count("spam") != 1 || count("pending") != 1 {
+		t.Errorf("purge = %q", out)
+	}
+	if out := run("moderate", "-approve-all-pending", "-dry-run"); !strings.HasSuffix(out, "Would approve 1 comments\n") || count("pending") != 1 {
+		t.Errorf("moderate -dry-run =\n%s", out)
+	}
+	if out := run("moderate", "-approve-all-pending"); out != "Approved 1 comments\n" || count("pending") != 0 || count("approved") != 2 {
+		t.Errorf("moderate = %q", out)
+	}
+
+	var out bytes.Buffer
+	for _, args := range [][]string{{"purge"}, {"purge", "-spam", "-older-than", "soon"}, {"moderate"}, {"moderate", "-approve-all-pending", "-spam-all-pending"}} {
+		if err := commands[args[0]].run(args[1:], &out); err == nil {
+			t.Errorf("%v succeeded", args)
+		}
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	for s, want := range map[string]time.Duration{"90d": 90 * 24 * time.Hour, "2w": 14 * 24 * time.Hour, "36h": 36 * time.Hour, "0d": 0} {
+		if got, err := parseAge(s); err != nil || got != want {
+			t.Errorf("parseAge(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "d", "-3d", "1.5d", "soon", "-1h"} {
+		if _, err := parseAge(s); err == nil {
+			t.Errorf("parseAge(%q) succeeded", s)
+		}
+	}
+}
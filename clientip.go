@@ -0,0 +1,163 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+)
+
+// forwarding is trusted_proxies, parsed, and client_ip_header, set by
+// setForwarding at startup and on reloads rather than for every request.
+var forwarding atomic.Pointer[forwardingConfig]
+
+type forwardingConfig struct {
+	proxies []netip.Prefix
+	header  string
+}
+
+// setForwarding applies trusted_proxies and client_ip_header from c, which
+// has been validated.
+func setForwarding(c Config) {
+	f := &forwardingConfig{header: http.CanonicalHeaderKey(c.ClientIPHeader)}
+	for _, s := range c.TrustedProxies {
+		if p, err := parseNetwork(s); err == nil {
+			f.proxies = append(f.proxies, p)
+		}
+	}
+	forwarding.Store(f)
+}
+
+// getIP returns the address of the client behind r. The client_ip_header
+// is only believed when the connection comes from one of trusted_proxies;
+// anybody else could put whatever they like in it. Of the chain of hops
+// it lists, the client is the last one that isn't a trusted proxy, since
+// anything left of that was sent by the client itself.
+func getIP(r *http.Request) string {
+	peer := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+	if !trustedProxy(peer) {
+		return peer
+	}
+	ip := peer
+	hops := forwardedHops(r.Header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHop(hops[i])
+		if !ok {
+			// an obfuscated or unknown hop hides whoever came before it
+			break
+		}
+		ip = addr.String()
+		if !trustedProxy(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// forwardedHops lists the addresses a request passed through, client
+// first, from client_ip_header: the RFC 7239 Forwarded header,
+// X-Forwarded-For or X-Real-IP. The others are ignored, since the proxy
+// passes along whatever the client sent in them.
+func forwardedHops(h http.Header) []string {
+	f := forwarding.Load()
+	if f == nil {
+		return nil
+	}
+	var hops []string
+	switch f.header {
+	case "Forwarded":
+		for _, v := range h.Values("Forwarded") {
+			for _, element := range splitQuoted(v, ',') {
+				hops = append(hops, forwardedFor(element))
+			}
+		}
+	case "X-Forwarded-For":
+		for _, v := range h.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(v, ",")...)
+		}
+	case "X-Real-Ip":
+		if v := h.Get("X-Real-IP"); v != "" {
+			hops = []string{v}
+		}
+	}
+	return hops
+}
+
+// forwardedFor returns the for parameter of a Forwarded element like
+// `for="[2001:db8::1]:4711";proto=https`, unquoted, or empty.
+func forwardedFor(element string) string {
+	for _, pair := range splitQuoted(element, ';') {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && strings.EqualFold(name, "for") {
+			return strings.Trim(value, `"`)
+		}
+	}
+	return ""
+}
+
+// splitQuoted splits s at sep outside of double quotes.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseHop reads an address from a forwarding header, which may come with
+// a port and, for IPv6, in brackets.
+func parseHop(hop string) (netip.Addr, bool) {
+	hop = strings.TrimSpace(hop)
+	if addr, err := netip.ParseAddrPort(hop); err == nil {
+		return addr.Addr().Unmap().WithZone(""), true
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]"))
+	if err != nil || addr.Zone() != "" {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// trustedProxy reports whether ip is in trusted_proxies.
+func trustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	f := forwarding.Load()
+	if f == nil {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+	for _, p := range f.proxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipNetwork is what per-client limits count by: an IPv4 address as it is,
+// and an IPv6 address by its /64, which a subscriber usually gets whole
+// and can pick new addresses from at will.
+func ipNetwork(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || !addr.Unmap().Is6() {
+		return ip
+	}
+	p, _ := addr.WithZone("").Prefix(64)
+	return p.String()
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// parseClosedAfter reads a closed_after setting: an RFC 3339 time, or a
+// date, which keeps comments open until the end of that day in UTC. Empty
+// is the zero time, never closing.
+func parseClosedAfter(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, dateOnly, err := parseFilterTime(v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("closed_after %q must be RFC 3339 or YYYY-MM-DD", v)
+	}
+	if dateOnly {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t.UTC(), nil
+}
+
+// closedSince returns when site stopped taking new comments, the earlier
+// of the global closed_after and its own, or the zero time if it's open
+// at now.
+func closedSince(site *Site, cfg Config, now time.Time) time.Time {
+	// validateConfig has checked it parses
+	closed, _ := parseClosedAfter(cfg.ClosedAfter)
+	if !site.ClosedAfter.IsZero() && (closed.IsZero() || site.ClosedAfter.Before(closed)) {
+		closed = site.ClosedAfter
+	}
+	if closed.IsZero() || now.Before(closed) {
+		return time.Time{}
+	}
+	return closed
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseClosedAfter(t *testing.T) {
+	for v, want := range map[string]time.Time{
+		"":                          {},
+		"2024-12-31":                time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		"2024-06-01T12:00:00+02:00": time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC),
+	} {
+		if got, err := parseClosedAfter(v); err != nil || !got.Equal(want) {
+			t.Errorf("parseClosedAfter(%q) = %v, %v; want %v", v, got, err, want)
+		}
+	}
+	if _, err := parseClosedAfter("next week"); err == nil {
+		t.Error("parseClosedAfter() of a bad date succeeded")
+	}
+}
+
+func TestClosedSince(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	early, late := now.AddDate(0, -1, 0), now.AddDate(0, 0, -1)
+	tests := []struct {
+		name   string
+		global string
+		site   time.Time
+		want   time.Time
+	}{
+		{"Open", "", time.Time{}, time.Time{}},
+		{"Global", "2024-05-31", time.Time{}, now},
+		{"Global in the future", "2024-06-01", time.Time{}, time.Time{}},
+		{"Site", "", late, late},
+		{"Site in the future", "", now.Add(time.Hour), time.Time{}},
+		{"Earlier of both", "2024-05-31", early, early},
+	}
+	for _, tt := range tests {
+		cfg := Config{ClosedAfter: tt.global}
+		if got := closedSince(&Site{ClosedAfter: tt.site}, cfg, now); !got.Equal(tt.want) {
+			t.Errorf("%s: closedSince() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCommentsClosed(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.ClosedAfter = "2020-01-01"
+
+	form := url.Values{"name": {"Ann"}, "email": {"ann@example.com"}, "comment": {"Too late"}}
+	rec := postForm(commentsHandler, "/comments", form)
+	if rec.Code != 403 || rec.Header().Get("X-Error-Code") != codeCommentsClosed {
+		t.Errorf("POST /comments after closed_after = %d %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+// command is a subcommand of the guestbook binary. Apart from init, which
+// writes the config, they all run against the loaded config and the store
+// it opens, so one-off jobs share everything with the server but the HTTP
+// side.
+type command struct {
+	run     func(args []string, out io.Writer) error
+	summary string
+}
+
+// commands is what `guestbook [flags] <command> [args]` runs; serve when
+// there's no command.
+var commands = map[string]command{
+	"serve":    {runServe, "run the guestbook server (the default)"},
+	"migrate":  {runMigrate, "apply, revert or list schema migrations"},
+	"export":   {runExport, "write every comment as CSV, JSON or XML"},
+	"import":   {runImport, "import comments from Disqus, Isso or Commento"},
+	"add-site": {runAddSite, "create a site and print its API key"},
+	"comments": {runComments, "list comments"},
+	"approve":  {moderateCommand("approve"), "approve comments"},
+	"pending":  {moderateCommand("pending"), "hold comments for moderation"},
+	"spam":     {moderateCommand("spam"), "mark comments as spam"},
+	"delete":   {moderateCommand("delete"), "delete comments"},
+	"purge":    {runPurge, "delete spam or pending comments in bulk"},
+	"moderate": {runModerateAll, "approve or reject every pending comment"},
+	"ban":      {runBan, "ban IP addresses or networks"},
+	"unban":    {runUnban, "lift bans"},
+	"bans":     {runBans, "list bans"},
+	"stats":    {runStats, "show comment statistics"},
+	"backup":   {runBackup, "snapshot the SQLite database"},
+}
+
+// usage prints the global flags and the commands.
+func usage() {
+	w := flag.CommandLine.Output()
+	fmt.Fprintf(w, "Usage: %s [flags] [command] [args]\n\nFlags:\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintf(w, "\nCommands:\n  %-10s %s\n", "init", "write config.toml and create the database")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-10s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintf(w, "\nRun %s <command> -h for the flags of a command.\n", os.Args[0])
+}
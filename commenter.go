@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const commenterCookie = "guestbook_commenter"
+
+// signInCookie carries the state of a sign-in in progress, scoped to the
+// path its callback is under.
+const signInCookie = "guestbook_signin"
+
+// commenterSessionTTL is how long a commenter stays signed in.
+const commenterSessionTTL = 30 * 24 * time.Hour
+
+// signInTimeout is how long a visitor has to sign in at their provider.
+const signInTimeout = 10 * time.Minute
+
+// commenterSession is a commenter who signed in, kept in shared state
+// under a hash of the session cookie like adminSession. IndieAuth vouches
+// for a Website; OAuth providers for a Name, and maybe more.
+type commenterSession struct {
+	Provider string `json:"provider"`
+	Website  string `json:"website,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Email    string `json:"email,omitempty"`
+	Avatar   string `json:"avatar,omitempty"`
+}
+
+func commenterSessionKey(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return "commenter-session:" + hex.EncodeToString(sum[:])
+}
+
+// signInEnabled reports whether commenters can sign in at all.
+func signInEnabled() bool {
+	return config.IndieAuth || len(enabledOAuthProviders()) > 0
+}
+
+// signedInCommenter returns the session of a signed-in commenter, or nil.
+func signedInCommenter(r *http.Request) *commenterSession {
+	c, err := r.Cookie(commenterCookie)
+	if err != nil || c.Value == "" {
+		return nil
+	}
+	v, ok, err := shared.Get(r.Context(), commenterSessionKey(c.Value))
+	if err != nil {
+		requestLogger(r).Warn("reading the commenter session failed", "error", err)
+		return nil
+	}
+	var s commenterSession
+	if !ok || json.Unmarshal(v, &s) != nil || s.Provider == "" {
+		return nil
+	}
+	return &s
+}
+
+// startCommenterSession signs the visitor in as s.
+func startCommenterSession(w http.ResponseWriter, r *http.Request, s commenterSession) error {
+	id := randomToken()
+	v, _ := json.Marshal(s)
+	if err := shared.Set(r.Context(), commenterSessionKey(id), v, commenterSessionTTL); err != nil {
+		return err
+	}
+	// Lax, since signing in ends with a redirect from another site
+	http.SetCookie(w, &http.Cookie{
+		Name:     commenterCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(commenterSessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	requestLogger(r).Info("commenter signed in", "provider", s.Provider, "website", s.Website, "name", s.Name)
+	return nil
+}
+
+// beginSignIn keeps v for a sign-in whose callback is under path and
+// returns the state parameter that finds it again. The state is also set
+// as a cookie, so only the browser that started can finish.
+func beginSignIn(w http.ResponseWriter, r *http.Request, path string, v any) (string, error) {
+	state := randomToken()
+	b, _ := json.Marshal(v)
+	if err := shared.Set(r.Context(), "sign-in:"+hashToken(state), b, signInTimeout); err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     signInCookie,
+		Value:    state,
+		Path:     path,
+		MaxAge:   int(signInTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return state, nil
+}
+
+// finishSignIn reads what beginSignIn kept for the ?state= of a callback
+// under path into v. A state is good for one try. It answers the request
+// itself and returns false when the state doesn't check out.
+func finishSignIn(w http.ResponseWriter, r *http.Request, path string, v any) bool {
+	state := r.URL.Query().Get("state")
+	cookie, err := r.Cookie(signInCookie)
+	if err != nil || !sameToken(state, cookie.Value) {
+		httpError(w, r, 400, codeSignInFailed, "Sign-in expired, please try again")
+		return false
+	}
+	http.SetCookie(w, &http.Cookie{Name: signInCookie, Path: path, MaxAge: -1, HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode})
+
+	key := "sign-in:" + hashToken(state)
+	b, ok, err := shared.Get(r.Context(), key)
+	if err != nil {
+		internalError(w, r, err)
+		return false
+	}
+	if !ok || json.Unmarshal(b, v) != nil {
+		httpError(w, r, 400, codeSignInFailed, "Sign-in expired, please try again")
+		return false
+	}
+	if err := shared.Delete(r.Context(), key); err != nil {
+		internalError(w, r, err)
+		return false
+	}
+	return true
+}
+
+// commenterLogoutHandler signs the commenter out and sends them back to
+// the page they came from. The form carries the page's CSRF token.
+func commenterLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !parseForm(w, r) {
+		return
+	}
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil || !sameToken(r.PostFormValue("csrf_token"), cookie.Value) {
+		httpError(w, r, http.StatusForbidden, codeCSRFFailed, "Invalid CSRF token, reload the page and try again")
+		return
+	}
+	if c, err := r.Cookie(commenterCookie); err == nil && c.Value != "" {
+		if err := shared.Delete(r.Context(), commenterSessionKey(c.Value)); err != nil {
+			internalError(w, r, err)
+			return
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: commenterCookie, Path: "/", MaxAge: -1, HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode})
+	http.Redirect(w, r, localReturn(r.PostFormValue("return")), http.StatusSeeOther)
+}
+
+// localReturn is ref if it's a path on this server, to send visitors back
+// to after signing in or out, and "/" otherwise.
+func localReturn(ref string) string {
+	if !strings.HasPrefix(ref, "/") || strings.HasPrefix(ref, "//") || strings.HasPrefix(ref, "/\\") {
+		return "/"
+	}
+	return ref
+}
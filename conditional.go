@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// notModified sets the validators for a response built from comments at
+// version v and reports whether the client's copy is still current, in
+// which case it has already answered 304. If-None-Match wins over
+// If-Modified-Since when both are sent, as RFC 9110 asks.
+func notModified(w http.ResponseWriter, r *http.Request, v CommentVersion) bool {
+	etag := v.ETag()
+	w.Header().Set("ETag", etag)
+	// let caches keep the response but check back every time
+	w.Header().Set("Cache-Control", "no-cache")
+	if !v.Modified.IsZero() {
+		w.Header().Set("Last-Modified", v.Modified.UTC().Format(http.TimeFormat))
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !etagMatches(inm, etag) {
+			return false
+		}
+	} else if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err != nil ||
+		v.Modified.IsZero() || v.Modified.Truncate(time.Second).After(ims) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches compares a comma separated If-None-Match list against etag,
+// ignoring weakness since a GET only needs the weak comparison.
+func etagMatches(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == etag {
+			return true
+		}
+	}
+	return false
+}
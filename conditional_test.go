@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConditionalComments(t *testing.T) {
+	defer func(s CommentStore) { store = s }(store)
+	store = newMemoryStore()
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	c := &Comment{Name: "Ann", Email: "ann@example.com", Text: "Hi", Created: created}
+	store.Create(t.Context(), c)
+
+	get := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/comments", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rec := httptest.NewRecorder()
+		getComments(rec, req, 15)
+		return rec
+	}
+
+	first := get("", "")
+	etag := first.Header().Get("ETag")
+	if first.Code != 200 || etag == "" || first.Header().Get("Last-Modified") != "Wed, 01 May 2024 12:00:00 GMT" {
+		t.Fatalf("First GET = %d, headers %v", first.Code, first.Header())
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		want   int
+	}{
+		{"Matching ETag", "If-None-Match", etag, 304},
+		{"ETag in a list", "If-None-Match", `"other", ` + etag, 304},
+		{"Weak ETag", "If-None-Match", "W/" + etag, 304},
+		{"Star", "If-None-Match", "*", 304},
+		{"Other ETag", "If-None-Match", `"other"`, 200},
+		{"Not modified since", "If-Modified-Since", "Wed, 01 May 2024 12:00:00 GMT", 304},
+		{"Modified since", "If-Modified-Since", "Wed, 01 May 2024 11:59:59 GMT", 200},
+		{"Bad date", "If-Modified-Since", "yesterday", 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := get(tt.header, tt.value)
+			if rec.Code != tt.want {
+				t.Errorf("Status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == 304 && (rec.Body.Len() != 0 || rec.Header().Get("ETag") != etag) {
+				t.Errorf("304 with body %q and ETag %q", rec.Body, rec.Header().Get("ETag"))
+			}
+		})
+	}
+
+	// a like changes neither the count nor the newest ID
+	if _, err := store.Like(t.Context(), 0, c.ID, "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	rec := get("If-None-Match", etag)
+	if rec.Code != 200 || rec.Header().Get("ETag") == etag {
+		t.Errorf("GET after a like = %d with ETag %s", rec.Code, rec.Header().Get("ETag"))
+	}
+}
+
+func TestETagOfEmptyListing(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/comments", nil)
+	if notModified(rec, req, CommentVersion{}) {
+		t.Error("notModified() without validators = true")
+	}
+	if rec.Header().Get("ETag") != `"0-0-0"` || rec.Header().Get("Last-Modified") != "" {
+		t.Errorf("Headers = %v", rec.Header())
+	}
+
+	req.Header.Set("If-None-Match", `"0-0-0"`)
+	rec = httptest.NewRecorder()
+	if !notModified(rec, req, CommentVersion{}) || rec.Code != http.StatusNotModified {
+		t.Errorf("notModified() with the same ETag = %d", rec.Code)
+	}
+}
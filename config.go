@@ -0,0 +1,608 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/redis/go-redis/v9"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix namespaces environment overrides, e.g. GUESTBOOK_DB_PATH
+// overrides db_path.
+const envPrefix = "GUESTBOOK_"
+
+// configPath is where the config is loaded from, at startup and on reload.
+var configPath = "config.toml"
+
+// configFiles are tried in order when no -config flag is given.
+var configFiles = []string{"config.toml", "config.yaml", "config.yml", "config.json"}
+
+// findConfig returns the first of configFiles that exists, or config.toml.
+func findConfig() string {
+	for _, path := range configFiles {
+		if fileExists(path) {
+			return path
+		}
+	}
+	return configFiles[0]
+}
+
+// defaultConfig returns the settings used for keys that neither the config
+// file nor the environment set.
+func defaultConfig() Config {
+	return Config{
+		Port:                 9001,
+		DBPath:               "./guestbook.db",
+		DBDriver:             "sqlite3",
+		AutoMigrate:          true,
+		SQLiteJournalMode:    "wal",
+		SQLiteBusyTimeout:    5000,
+		SQLiteForeignKeys:    true,
+		BackupDir:            "./backups",
+		BackupKeep:           7,
+		PurgeSchedule:        "@daily",
+		BotHitRetentionDays:  30,
+		ArchiveSchedule:      "@daily",
+		JobJitterSeconds:     60,
+		BackupS3Endpoint:     "s3.amazonaws.com",
+		BackupS3Prefix:       "guestbook",
+		MediaDir:             "./media",
+		MediaMaxBytes:        2 << 20,
+		MediaS3Prefix:        "media",
+		LogPath:              "./guestbook.log",
+		LogOutput:            "file",
+		LogFormat:            "text",
+		LogLevel:             "info",
+		SyslogTag:            "guestbook",
+		AutocertCacheDir:     "certs",
+		AutocertHTTPPort:     80,
+		ActivityPubUsername:  "guestbook",
+		AdminJWTTTL:          900,
+		AdminRefreshTTL:      30 * 24 * 60 * 60,
+		OIDCScopes:           []string{"openid", "profile", "email"},
+		OIDCRoleClaim:        "groups",
+		CSRFMode:             "api",
+		FormTokenMaxAge:      2 * 60 * 60,
+		SubmissionNonceTTL:   60 * 60,
+		PolicyVersion:        "1",
+		PageTitle:            "Guestbook",
+		DisplayTimezone:      "UTC",
+		DuplicateWindow:      60,
+		SpamWeights:          map[string]float64{"honeypot": 5, "rate": 1, "words": 2, "links": 1, "bayes": 2, "dnsbl": 1},
+		SpamModerateScore:    1,
+		SpamRejectScore:      5,
+		SpamRateLimit:        5,
+		SpamMaxLinks:         3,
+		DNSBLTimeoutMS:       500,
+		ResponseCacheSeconds: 60,
+		PollTimeout:          30,
+		RedisPrefix:          "guestbook:",
+		ServiceName:          "guestbook",
+		TraceSampleRatio:     1.0,
+		ShutdownTimeout:      10,
+		ReadHeaderTimeout:    5,
+		ReadTimeout:          15,
+		WriteTimeout:         60,
+		IdleTimeout:          120,
+		MaxHeaderBytes:       16 << 10,
+		MaxBodyBytes:         64 << 10,
+		MaxBulkBodyBytes:     32 << 20,
+		OverloadRetryAfter:   1,
+		LookupTimeout:        2,
+		BreakerFailures:      5,
+		BreakerCooldown:      60,
+		OutboxMaxAttempts:    10,
+		MastodonTemplate:     defaultMastodonTemplate,
+		MastodonVisibility:   "public",
+		MastodonMaxPerHour:   6,
+		TrustedProxies:       []string{"127.0.0.0/8", "::1"},
+		ClientIPHeader:       "X-Forwarded-For",
+	}
+}
+
+// loadConfig reads path over the defaults and applies environment overrides
+// on top. A missing file is fine, so containers can be configured purely
+// through the environment.
+func loadConfig(path string, lookupEnv func(string) (string, bool)) (Config, error) {
+	c := defaultConfig()
+	if err := decodeConfigFile(path, &c); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return c, err
+	}
+	if err := applyEnv(&c, lookupEnv); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// validateConfig checks c for settings that would otherwise only fail once
+// the server is running, reporting every problem at once.
+func validateConfig(c Config) error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Port >= 1 && c.Port <= 65535, "port %d is out of range 1-65535", c.Port)
+	check(c.GRPCPort >= 0 && c.GRPCPort <= 65535, "grpc_port %d is out of range 0-65535", c.GRPCPort)
+	check(c.GRPCPort != c.Port, "grpc_port must differ from port")
+	check(oneOf(c.LogOutput, "file", "stdout", "stderr", "syslog"), "log_output %q must be file, stdout, stderr or syslog", c.LogOutput)
+	check(oneOf(c.LogFormat, "text", "json"), "log_format %q must be text or json", c.LogFormat)
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.LogLevel)) == nil, "log_level %q must be debug, info, warn or error", c.LogLevel)
+	check(oneOf(c.CSRFMode, "api", "cookie", "signed"), "csrf_mode %q must be api, cookie or signed", c.CSRFMode)
+	check(c.CSRFMode != "signed" || len(c.FormTokenSecret) >= 32, "csrf_mode signed needs a form_token_secret of at least 32 characters")
+	check(c.FormTokenMaxAge >= 60, "form_token_max_age must be at least 60")
+	check(c.SubmissionNonceTTL >= 60, "submission_nonce_ttl must be at least 60")
+	check(!c.SubmissionNonces || len(c.FormTokenSecret) >= 32, "submission_nonces needs a form_token_secret of at least 32 characters")
+	check(oneOf(strings.ToLower(c.SQLiteJournalMode), "wal", "delete", "truncate", "persist", "memory", "off"),
+		"sqlite_journal_mode %q must be wal, delete, truncate, persist, memory or off", c.SQLiteJournalMode)
+	check(c.TraceSampleRatio >= 0 && c.TraceSampleRatio <= 1, "trace_sample_ratio %v must be between 0 and 1", c.TraceSampleRatio)
+
+	for key, v := range map[string]int{
+		"log_max_size_mb": c.LogMaxSizeMB, "log_max_age_hours": c.LogMaxAgeHours, "log_max_backups": c.LogMaxBackups,
+		"shutdown_timeout": c.ShutdownTimeout, "read_header_timeout": c.ReadHeaderTimeout, "read_timeout": c.ReadTimeout,
+		"write_timeout": c.WriteTimeout, "idle_timeout": c.IdleTimeout, "max_header_bytes": c.MaxHeaderBytes,
+		"max_body_bytes": c.MaxBodyBytes, "max_bulk_body_bytes": c.MaxBulkBodyBytes, "tarpit_seconds": c.TarpitSeconds, "watchdog_interval": c.WatchdogInterval,
+		"honeypot_ban_minutes": c.HoneypotBanMinutes, "bot_hit_retention_days": c.BotHitRetentionDays,
+		"max_goroutines": c.MaxGoroutines, "max_heap_mb": c.MaxHeapMB, "max_open_fds": c.MaxOpenFDs,
+		"sqlite_busy_timeout": c.SQLiteBusyTimeout, "db_max_open_conns": c.DBMaxOpenConns,
+		"db_max_idle_conns": c.DBMaxIdleConns, "db_conn_max_lifetime": c.DBConnMaxLifetime,
+		"backup_interval_hours": c.BackupIntervalHours, "backup_keep": c.BackupKeep, "duplicate_window": c.DuplicateWindow,
+		"response_cache_seconds": c.ResponseCacheSeconds, "poll_timeout": c.PollTimeout,
+		"spam_retention_days": c.SpamRetentionDays, "pending_retention_days": c.PendingRetentionDays,
+		"request_log_retention_days": c.RequestLogRetentionDays, "job_jitter_seconds": c.JobJitterSeconds,
+		"archive_after_years": c.ArchiveAfterYears, "max_concurrent_reads": c.MaxConcurrentReads, "max_concurrent_writes": c.MaxConcurrentWrites,
+		"overload_retry_after": c.OverloadRetryAfter, "lookup_timeout": c.LookupTimeout, "breaker_failures": c.BreakerFailures,
+		"breaker_cooldown": c.BreakerCooldown, "mastodon_max_per_hour": c.MastodonMaxPerHour,
+		"spam_rate_limit": c.SpamRateLimit, "spam_max_links": c.SpamMaxLinks, "max_links": c.MaxLinks,
+	} {
+		check(v >= 0, "%s must not be negative", key)
+	}
+	for name, weight := range c.SpamWeights {
+		check(slices.ContainsFunc(spamChecks, func(s spamCheck) bool { return s.name == name }), "spam_weights: unknown check %q", name)
+		check(weight >= 0, "spam_weights: %s must not be negative", name)
+	}
+	check(c.SpamModerateScore >= 0 && c.SpamRejectScore >= 0, "spam_moderate_score and spam_reject_score must not be negative")
+	for _, zone := range c.DNSBLZones {
+		check(zone != "" && !strings.ContainsAny(zone, "/:*@ ") && !strings.HasPrefix(zone, ".") && !strings.HasSuffix(zone, "."), "dnsbl_zones: %q must be a zone like zen.spamhaus.org", zone)
+	}
+	// it's on every comment's path, so it has to give up quickly
+	check(c.DNSBLTimeoutMS >= 1 && c.DNSBLTimeoutMS <= 5000, "dnsbl_timeout_ms must be between 1 and 5000")
+	for key, domains := range map[string][]string{"link_blocklist": c.LinkBlocklist, "link_allowlist": c.LinkAllowlist} {
+		for _, d := range domains {
+			check(d != "" && !strings.ContainsAny(d, "/:*@ "), "%s: %q must be a domain like example.com", key, d)
+		}
+	}
+	for _, path := range c.HoneypotPaths {
+		check(strings.HasPrefix(path, "/"), "honeypot path %q must start with /", path)
+	}
+
+	check(oneOf(c.DBDriver, "sqlite3", "mysql", "bbolt", "memory"), "db_driver %q must be sqlite3, mysql, bbolt or memory", c.DBDriver)
+	check(c.DBDriver != "sqlite3" || sqliteAvailable, "db_driver sqlite3 isn't available in builds without cgo, use bbolt or mysql")
+	check(c.BackupIntervalHours == 0 || c.DBDriver == "sqlite3", "backup_interval_hours needs db_driver sqlite3")
+	check(c.BackupSchedule == "" || c.DBDriver == "sqlite3", "backup_schedule needs db_driver sqlite3")
+	check(c.BackupSchedule == "" || c.BackupIntervalHours == 0, "set backup_schedule or backup_interval_hours, not both")
+	for key, spec := range map[string]string{"backup_schedule": c.BackupSchedule, "purge_schedule": c.PurgeSchedule, "archive_schedule": c.ArchiveSchedule, "digest_schedule": c.DigestSchedule} {
+		if spec == "" {
+			continue
+		}
+		if _, err := parseSchedule(spec); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+	check(c.PurgeSchedule != "" || (c.SpamRetentionDays == 0 && c.PendingRetentionDays == 0), "spam_retention_days and pending_retention_days need a purge_schedule")
+	check(c.PurgeSchedule != "" || c.RequestLogRetentionDays == 0, "request_log_retention_days needs a purge_schedule")
+	check(c.PurgeSchedule != "" || c.BotHitRetentionDays == 0, "bot_hit_retention_days needs a purge_schedule")
+	if _, err := parseClosedAfter(c.ClosedAfter); err != nil {
+		errs = append(errs, err)
+	}
+	check(c.ArchiveSchedule != "" || c.ArchiveAfterYears == 0, "archive_after_years needs an archive_schedule")
+	if c.BackupS3Bucket != "" {
+		check(c.BackupS3Endpoint != "", "backup_s3_endpoint is required with backup_s3_bucket")
+		if _, _, err := parseS3Endpoint(c.BackupS3Endpoint); err != nil {
+			errs = append(errs, fmt.Errorf("backup_s3_endpoint: %w", err))
+		}
+		check((c.BackupS3AccessKey == "") == (c.BackupS3SecretKey == ""), "backup_s3_access_key and backup_s3_secret_key must be set together")
+	}
+	check(oneOf(c.MediaStorage, "", "disk", "s3"), "media_storage %q must be disk or s3", c.MediaStorage)
+	check(c.MediaStorage != "disk" || c.MediaDir != "", "media_dir is required with media_storage disk")
+	if c.MediaStorage == "s3" {
+		check(c.MediaS3Bucket != "", "media_s3_bucket is required with media_storage s3")
+		if _, _, err := parseS3Endpoint(c.BackupS3Endpoint); err != nil {
+			errs = append(errs, fmt.Errorf("backup_s3_endpoint: %w", err))
+		}
+	}
+	check(c.MediaStorage == "" || c.MediaMaxBytes > 0, "media_max_bytes must be positive")
+	check(!c.AvatarUploads || c.MediaStorage != "", "avatar_uploads needs media_storage")
+	if c.RedisURL != "" {
+		if _, err := redis.ParseURL(c.RedisURL); err != nil {
+			errs = append(errs, fmt.Errorf("redis_url: %w", err))
+		}
+	}
+	if _, err := time.LoadLocation(c.DisplayTimezone); err != nil {
+		errs = append(errs, fmt.Errorf("display_timezone: %w", err))
+	}
+	if c.TemplatesDir != "" {
+		if _, err := parseTemplates(c.TemplatesDir); err != nil {
+			errs = append(errs, fmt.Errorf("templates_dir: %w", err))
+		}
+		if err := parseEmailTemplates(c.TemplatesDir); err != nil {
+			errs = append(errs, fmt.Errorf("templates_dir: %w", err))
+		}
+	}
+	if c.StaticDir != "" {
+		if fi, err := os.Stat(c.StaticDir); err != nil || !fi.IsDir() {
+			errs = append(errs, fmt.Errorf("static_dir %q isn't a directory", c.StaticDir))
+		}
+	}
+	if c.DBDriver == "bbolt" || c.DBDriver == "memory" {
+		check(!c.MultiTenant, "multi_tenant needs db_driver sqlite3 or mysql")
+		check(!c.ActivityPub, "activitypub needs db_driver sqlite3 or mysql")
+		check(!c.Webmention, "webmention needs db_driver sqlite3 or mysql")
+		check(!notificationsEnabled(c), "webhook_urls, notify_email, telegram_bot_token and mastodon_server need db_driver sqlite3 or mysql")
+		check(c.EmailVerificationSecret == "", "email_verification_secret needs db_driver sqlite3 or mysql")
+		check(!c.RequestLogTable, "request_log_table needs db_driver sqlite3 or mysql")
+	}
+	if c.PublicURL != "" {
+		u, err := url.Parse(c.PublicURL)
+		check(err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != "" && strings.Trim(u.Path, "/") == "",
+			"public_url %q must look like https://guestbook.example.com", c.PublicURL)
+	}
+	if c.ActivityPub {
+		check(c.PublicURL != "", "public_url is required with activitypub")
+		check(validSlug.MatchString(c.ActivityPubUsername), "activitypub_username %q must be lowercase letters, digits and dashes", c.ActivityPubUsername)
+	}
+	check(!c.Webmention || c.PublicURL != "", "public_url is required with webmention")
+	check(!c.SendWebmentions || c.PublicURL != "", "public_url is required with send_webmentions")
+	for _, w := range c.WebhookURLs {
+		u, err := url.Parse(w)
+		check(err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != "", "webhook URL %q must be an http or https URL", w)
+	}
+	if c.NotifyEmail != "" {
+		check(c.MailFrom != "", "mail_from is required with notify_email")
+		_, _, err := net.SplitHostPort(c.SMTPAddr)
+		check(err == nil, "smtp_addr %q must be host:port", c.SMTPAddr)
+	}
+	check(c.DigestSchedule == "" || c.NotifyEmail != "", "digest_schedule needs notify_email")
+	if c.EmailVerificationSecret != "" {
+		check(len(c.EmailVerificationSecret) >= 32, "email_verification_secret must be at least 32 characters")
+		check(c.PublicURL != "", "public_url is required with email_verification_secret")
+		check(c.MailFrom != "", "mail_from is required with email_verification_secret")
+		_, _, err := net.SplitHostPort(c.SMTPAddr)
+		check(err == nil, "smtp_addr %q must be host:port", c.SMTPAddr)
+	}
+	check(!c.ApproveVerified || c.EmailVerificationSecret != "", "approve_verified needs email_verification_secret")
+	check(!c.DoubleOptIn || c.EmailVerificationSecret != "", "double_opt_in needs email_verification_secret")
+	check(!c.CommentManagement || c.EmailVerificationSecret != "", "comment_management needs email_verification_secret")
+	check(c.EmailLocale == "" || validLocale.MatchString(c.EmailLocale), "email_locale %q must be a language tag like de or pt-BR", c.EmailLocale)
+	check(c.OutboxMaxAttempts >= 1, "outbox_max_attempts must be at least 1")
+	for _, name := range c.DisabledNotifiers {
+		check(notifierByName(name) != nil, "disabled_notifiers: unknown notifier %q", name)
+	}
+	check((c.TelegramBotToken == "") == (c.TelegramChatID == ""), "telegram_bot_token and telegram_chat_id must be set together")
+	check(c.TelegramBotToken == "" || c.PublicURL != "", "public_url is required with telegram_bot_token")
+	if c.MastodonServer != "" {
+		u, err := url.Parse(c.MastodonServer)
+		check(err == nil && u.Scheme == "https" && u.Host != "", "mastodon_server %q must look like https://mastodon.social", c.MastodonServer)
+		check(c.MastodonToken != "", "mastodon_token is required with mastodon_server")
+		check(oneOf(c.MastodonVisibility, "public", "unlisted", "private"), "mastodon_visibility %q must be public, unlisted or private", c.MastodonVisibility)
+		_, err = template.New("mastodon").Parse(c.MastodonTemplate)
+		check(err == nil, "mastodon_template: %v", err)
+	}
+	check(oneOf(c.TranslateProvider, "", "deepl", "libretranslate"), "translate_provider %q must be deepl or libretranslate", c.TranslateProvider)
+	check(c.TranslateProvider != "deepl" || c.TranslateAPIKey != "", "translate_api_key is required with translate_provider deepl")
+	check(c.TranslateProvider != "libretranslate" || c.TranslateURL != "", "translate_url is required with translate_provider libretranslate")
+	check(c.TranslateProvider == "" || len(c.TranslateLanguages) > 0, "translate_provider needs translate_languages")
+	if c.TranslateURL != "" {
+		u, err := url.Parse(c.TranslateURL)
+		check(err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != "", "translate_url %q must be an http or https URL", c.TranslateURL)
+	}
+	for _, l := range c.TranslateLanguages {
+		check(validLocale.MatchString(l), "translate_languages: %q must be a language tag like de or pt-BR", l)
+	}
+	signIn := c.IndieAuth
+	check(!c.IndieAuth || c.PublicURL != "", "public_url is required with indieauth")
+	for _, p := range oauthProviders {
+		id, secret := p.credentials(c)
+		check((id == "") == (secret == ""), "%s_client_id and %s_client_secret must be set together", p.Name, p.Name)
+		check(id == "" || c.PublicURL != "", "public_url is required with %s_client_id", p.Name)
+		if id != "" {
+			signIn = true
+		}
+	}
+	if c.OIDCIssuer != "" {
+		u, err := url.Parse(c.OIDCIssuer)
+		check(err == nil && u.Scheme == "https" && u.Host != "" && u.RawQuery == "", "oidc_issuer %q must be an https URL", c.OIDCIssuer)
+		check(c.OIDCClientID != "" && c.OIDCClientSecret != "", "oidc_client_id and oidc_client_secret are required with oidc_issuer")
+		check(c.PublicURL != "", "public_url is required with oidc_issuer")
+		check(slices.Contains(c.OIDCScopes, "openid"), "oidc_scopes must include openid")
+		check(c.OIDCRoleClaim != "", "oidc_role_claim is required with oidc_issuer")
+		check(len(c.OIDCAdminGroups)+len(c.OIDCModeratorGroups) > 0, "oidc_admin_groups or oidc_moderator_groups is required with oidc_issuer")
+	}
+	if c.AdminJWTSecret != "" {
+		check(len(c.AdminJWTSecret) >= 32, "admin_jwt_secret must be at least 32 characters")
+		check(c.AdminToken != "" || c.OIDCIssuer != "", "admin_jwt_secret needs admin_token or oidc_issuer to log in with")
+		check(c.AdminJWTTTL >= 0 && c.AdminRefreshTTL >= 0, "admin_jwt_ttl and admin_refresh_ttl must not be negative")
+	}
+	for _, p := range c.TrustedProxies {
+		_, err := parseNetwork(p)
+		check(err == nil, "trusted_proxies: %q is not an IP address or network", p)
+	}
+	check(slices.ContainsFunc([]string{"Forwarded", "X-Forwarded-For", "X-Real-IP"}, func(h string) bool { return strings.EqualFold(h, c.ClientIPHeader) }),
+		"client_ip_header must be Forwarded, X-Forwarded-For or X-Real-IP")
+	check(!c.RequireSignIn || signIn, "require_sign_in needs indieauth or an OAuth provider to sign in with")
+	switch c.DBDriver {
+	case "mysql":
+		check(c.DBDSN != "", "db_dsn is required with db_driver mysql")
+		if _, err := mysqlDSN(c.DBDSN); c.DBDSN != "" && err != nil {
+			errs = append(errs, fmt.Errorf("db_dsn: %w", err))
+		}
+	case "sqlite3", "bbolt":
+		check(c.DBPath != "", "db_path is required")
+		if c.DBPath != "" && !strings.HasPrefix(c.DBPath, ":memory:") {
+			if err := checkPathWritable(c.DBPath); err != nil {
+				errs = append(errs, fmt.Errorf("db_path: %w", err))
+			}
+		}
+	}
+	if c.LogOutput == "file" {
+		check(c.LogPath != "", "log_path is required when log_output is file")
+		if c.LogPath != "" {
+			if err := checkPathWritable(c.LogPath); err != nil {
+				errs = append(errs, fmt.Errorf("log_path: %w", err))
+			}
+		}
+	}
+	if c.AccessLogPath != "" {
+		if err := checkPathWritable(c.AccessLogPath); err != nil {
+			errs = append(errs, fmt.Errorf("access_log_path: %w", err))
+		}
+	}
+	if len(c.AutocertDomains) > 0 {
+		check(c.AutocertHTTPPort >= 1 && c.AutocertHTTPPort <= 65535, "autocert_http_port %d is out of range 1-65535", c.AutocertHTTPPort)
+		check(c.AutocertHTTPPort != c.Port, "autocert_http_port must differ from port")
+		check(c.AutocertCacheDir != "", "autocert_cache_dir is required with autocert_domains")
+	}
+	return errors.Join(errs...)
+}
+
+func oneOf(s string, options ...string) bool {
+	for _, o := range options {
+		if s == o {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPathWritable reports whether the file at path can be written, or
+// created if it doesn't exist yet, without leaving anything behind.
+func checkPathWritable(path string) error {
+	if f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0); err == nil {
+		return f.Close()
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, ".guestbook-check-*")
+	if err != nil {
+		var pe *fs.PathError
+		if errors.As(err, &pe) {
+			err = pe.Err
+		}
+		return fmt.Errorf("can't create files in %s: %w", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// decodeConfigFile decodes path into c according to its extension. YAML
+// and JSON use the same keys as TOML.
+func decodeConfigFile(path string, c *Config) error {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".toml" {
+		_, err := toml.DecodeFile(path, c)
+		return err
+	}
+	if ext != ".yaml" && ext != ".yml" && ext != ".json" {
+		return fmt.Errorf("unsupported config format %q, use .toml, .yaml or .json", ext)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var m map[string]any
+	if ext == ".json" {
+		err = json.Unmarshal(data, &m)
+	} else {
+		err = yaml.Unmarshal(data, &m)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return applyMap(c, m)
+}
+
+// applyMap sets the fields of c named by the keys of m, which holds the
+// generic values a YAML or JSON decoder produces.
+func applyMap(c *Config, m map[string]any) error {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := t.Field(i).Tag.Get("toml")
+		val, ok := m[key]
+		if key == "" || !ok || val == nil {
+			continue
+		}
+		if err := setValue(v.Field(i), val); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func setValue(f reflect.Value, val any) error {
+	switch f.Kind() {
+	case reflect.String:
+		if s, ok := val.(string); ok {
+			f.SetString(s)
+			return nil
+		}
+	case reflect.Int:
+		switch n := val.(type) {
+		case int:
+			f.SetInt(int64(n))
+			return nil
+		case float64:
+			if n == float64(int64(n)) {
+				f.SetInt(int64(n))
+				return nil
+			}
+		}
+	case reflect.Float64:
+		switch n := val.(type) {
+		case int:
+			f.SetFloat(float64(n))
+			return nil
+		case float64:
+			f.SetFloat(n)
+			return nil
+		}
+	case reflect.Bool:
+		if b, ok := val.(bool); ok {
+			f.SetBool(b)
+			return nil
+		}
+	case reflect.Map:
+		// numbers by name, like spam_weights, over the defaults
+		items, ok := val.(map[string]any)
+		if !ok {
+			break
+		}
+		m := maps.Clone(f.Interface().(map[string]float64))
+		if m == nil {
+			m = map[string]float64{}
+		}
+		for k, item := range items {
+			switch n := item.(type) {
+			case int:
+				m[k] = float64(n)
+			case float64:
+				m[k] = n
+			default:
+				return fmt.Errorf("%s: expected a number, got %v", k, item)
+			}
+		}
+		f.Set(reflect.ValueOf(m))
+		return nil
+	case reflect.Slice:
+		items, ok := val.([]any)
+		if !ok {
+			break
+		}
+		list := make([]string, len(items))
+		for i, item := range items {
+			if list[i], ok = item.(string); !ok {
+				return fmt.Errorf("expected a list of strings, got %v", val)
+			}
+		}
+		f.Set(reflect.ValueOf(list))
+		return nil
+	}
+	return fmt.Errorf("expected %s, got %v", f.Type(), val)
+}
+
+// applyEnv overrides every field of c whose GUESTBOOK_<KEY> variable is set,
+// where KEY is the upper-cased toml key. Lists are comma separated.
+func applyEnv(c *Config, lookupEnv func(string) (string, bool)) error {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := t.Field(i).Tag.Get("toml")
+		if key == "" {
+			continue
+		}
+		name := envPrefix + strings.ToUpper(key)
+		s, ok := lookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setField(v.Field(i), s); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setField(f reflect.Value, s string) error {
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(s)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(n))
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		f.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Map:
+		// name=number pairs like links=2,words=0, over the defaults
+		m := maps.Clone(f.Interface().(map[string]float64))
+		if m == nil {
+			m = map[string]float64{}
+		}
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			k, v, ok := strings.Cut(item, "=")
+			n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if !ok || err != nil {
+				return fmt.Errorf("%q must be name=number", item)
+			}
+			m[strings.TrimSpace(k)] = n
+		}
+		f.Set(reflect.ValueOf(m))
+	case reflect.Slice:
+		var list []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		f.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported type %s", f.Type())
+	}
+	return nil
+}
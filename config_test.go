@@ -0,0 +1,300 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func envMap(m map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := m[key]
+		return v, ok
+	}
+}
+
+func TestLoadConfigEnvOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	err := os.WriteFile(path, []byte("port = 9001\ndb_path = \"./guestbook.db\"\nlog_path = \"./guestbook.log\"\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := loadConfig(path, envMap(map[string]string{
+		"GUESTBOOK_PORT":               "8080",
+		"GUESTBOOK_DB_PATH":            "/data/guestbook.db",
+		"GUESTBOOK_LOG_COMPRESS":       "true",
+		"GUESTBOOK_TRACE_SAMPLE_RATIO": "0.25",
+		"GUESTBOOK_HONEYPOT_PATHS":     "/wp-login.php, /.env",
+		"GUESTBOOK_SPAM_WEIGHTS":       "words=0, links=1.5",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Port != 8080 || c.DBPath != "/data/guestbook.db" || !c.LogCompress || c.TraceSampleRatio != 0.25 {
+		t.Errorf("Overrides not applied: %+v", c)
+	}
+	if c.LogPath != "./guestbook.log" {
+		t.Errorf("LogPath = %q, want value from file", c.LogPath)
+	}
+	if want := []string{"/wp-login.php", "/.env"}; !slices.Equal(c.HoneypotPaths, want) {
+		t.Errorf("HoneypotPaths = %q, want %q", c.HoneypotPaths, want)
+	}
+	if w := c.SpamWeights; w["words"] != 0 || w["links"] != 1.5 || w["honeypot"] != 5 {
+		t.Errorf("SpamWeights = %v, want words and links overridden", w)
+	}
+}
+
+func TestLoadConfigWithoutFile(t *testing.T) {
+	c, err := loadConfig(filepath.Join(t.TempDir(), "missing.toml"), envMap(map[string]string{
+		"GUESTBOOK_PORT": "9001",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Port != 9001 || c.PolicyVersion != "1" {
+		t.Errorf("loadConfig() = %+v", c)
+	}
+}
+
+func TestLoadConfigInvalidEnv(t *testing.T) {
+	tests := []map[string]string{
+		{"GUESTBOOK_PORT": "eighty"},
+		{"GUESTBOOK_LOG_COMPRESS": "maybe"},
+		{"GUESTBOOK_TRACE_SAMPLE_RATIO": "half"},
+		{"GUESTBOOK_SPAM_WEIGHTS": "links"},
+	}
+	for _, env := range tests {
+		if _, err := loadConfig(filepath.Join(t.TempDir(), "missing.toml"), envMap(env)); err == nil {
+			t.Errorf("loadConfig() with %v succeeded, want error", env)
+		}
+	}
+}
+
+func TestDefaultConfigIsValid(t *testing.T) {
+	needSQLite(t)
+	c := defaultConfig()
+	dir := t.TempDir()
+	c.DBPath = filepath.Join(dir, "guestbook.db")
+	c.LogPath = filepath.Join(dir, "guestbook.log")
+	if err := validateConfig(c); err != nil {
+		t.Errorf("validateConfig(defaultConfig()) = %v", err)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   string
+	}{
+		{"port", func(c *Config) { c.Port = 70000 }, "port 70000 is out of range"},
+		{"log output", func(c *Config) { c.LogOutput = "tape" }, `log_output "tape"`},
+		{"log format", func(c *Config) { c.LogFormat = "xml" }, `log_format "xml"`},
+		{"log level", func(c *Config) { c.LogLevel = "loud" }, `log_level "loud"`},
+		{"csrf mode", func(c *Config) { c.CSRFMode = "strict" }, `csrf_mode "strict"`},
+		{"form token secret", func(c *Config) { c.CSRFMode = "signed" }, "csrf_mode signed needs a form_token_secret"},
+		{"form token age", func(c *Config) { c.FormTokenMaxAge = 10 }, "form_token_max_age must be at least 60"},
+		{"nonce ttl", func(c *Config) { c.SubmissionNonceTTL = 0 }, "submission_nonce_ttl must be at least 60"},
+		{"nonce secret", func(c *Config) { c.SubmissionNonces = true }, "submission_nonces needs a form_token_secret of at least 32 characters"},
+		{"sample ratio", func(c *Config) { c.TraceSampleRatio = 2 }, "trace_sample_ratio 2"},
+		{"negative", func(c *Config) { c.ReadTimeout = -1 }, "read_timeout must not be negative"},
+		{"honeypot", func(c *Config) { c.HoneypotPaths = []string{"wp-login.php"} }, `honeypot path "wp-login.php"`},
+		{"db path", func(c *Config) { c.DBPath = "" }, "db_path is required"},
+		{"bbolt sites", func(c *Config) {
+			c.DBDriver = "bbolt"
+			c.MultiTenant = true
+		}, "multi_tenant needs db_driver sqlite3 or mysql"},
+		{"memory sites", func(c *Config) {
+			c.DBDriver = "memory"
+			c.MultiTenant = true
+		}, "multi_tenant needs db_driver sqlite3 or mysql"},
+		{"s3 keys", func(c *Config) {
+			c.BackupS3Bucket = "backups"
+			c.BackupS3AccessKey = "AKID"
+		}, "backup_s3_access_key and backup_s3_secret_key must be set together"},
+		{"unwritable", func(c *Config) { c.DBPath = filepath.Join(dir, "missing", "guestbook.db") }, "db_path: can't create files in"},
+		{"display timezone", func(c *Config) { c.DisplayTimezone = "Mars/Olympus_Mons" }, "display_timezone: unknown time zone"},
+		{"templates dir", func(c *Config) {
+			os.WriteFile(filepath.Join(dir, "page.html"), []byte("{{.Title"), 0o644)
+			c.TemplatesDir = dir
+		}, "templates_dir: template: page.html"},
+		{"static dir", func(c *Config) { c.StaticDir = filepath.Join(dir, "missing") }, "isn't a directory"},
+		{"autocert port", func(c *Config) {
+			c.AutocertDomains = []string{"example.com"}
+			c.AutocertHTTPPort = c.Port
+		}, "autocert_http_port must differ from port"},
+		{"grpc port", func(c *Config) { c.GRPCPort = -1 }, "grpc_port -1 is out of range"},
+		{"grpc port clash", func(c *Config) { c.GRPCPort = c.Port }, "grpc_port must differ from port"},
+		{"public url", func(c *Config) { c.PublicURL = "guestbook.example.com/blog" }, `public_url "guestbook.example.com/blog"`},
+		{"activitypub url", func(c *Config) { c.ActivityPub = true }, "public_url is required with activitypub"},
+		{"activitypub memory", func(c *Config) {
+			c.DBDriver = "memory"
+			c.ActivityPub = true
+			c.PublicURL = "https://guestbook.example.com"
+		}, "activitypub needs db_driver sqlite3 or mysql"},
+		{"webmention url", func(c *Config) { c.Webmention = true }, "public_url is required with webmention"},
+		{"send webmentions url", func(c *Config) { c.SendWebmentions = true }, "public_url is required with send_webmentions"},
+		{"indieauth url", func(c *Config) { c.IndieAuth = true }, "public_url is required with indieauth"},
+		{"oauth secret", func(c *Config) { c.PublicURL = "https://guestbook.example"; c.GitHubClientID = "id" }, "github_client_id and github_client_secret must be set together"},
+		{"oauth url", func(c *Config) { c.GoogleClientID, c.GoogleClientSecret = "id", "secret" }, "public_url is required with google_client_id"},
+		{"oidc client", func(c *Config) {
+			c.PublicURL, c.OIDCIssuer, c.OIDCAdminGroups = "https://guestbook.example", "https://sso.example", []string{"admins"}
+		}, "oidc_client_id and oidc_client_secret are required with oidc_issuer"},
+		{"oidc issuer", func(c *Config) {
+			c.PublicURL, c.OIDCIssuer, c.OIDCClientID, c.OIDCClientSecret, c.OIDCAdminGroups = "https://guestbook.example", "http://sso.example", "id", "secret", []string{"admins"}
+		}, `oidc_issuer "http://sso.example" must be an https URL`},
+		{"oidc groups", func(c *Config) {
+			c.PublicURL, c.OIDCIssuer, c.OIDCClientID, c.OIDCClientSecret = "https://guestbook.example", "https://sso.example", "id", "secret"
+		}, "oidc_admin_groups or oidc_moderator_groups is required with oidc_issuer"},
+		{"jwt secret", func(c *Config) { c.AdminToken, c.AdminJWTSecret = "token", "short" }, "admin_jwt_secret must be at least 32 characters"},
+		{"jwt login", func(c *Config) { c.AdminJWTSecret = strings.Repeat("s", 32) }, "admin_jwt_secret needs admin_token or oidc_issuer to log in with"},
+		{"trusted proxies", func(c *Config) { c.TrustedProxies = []string{"10.0.0.0/8", "proxy.internal"} }, `trusted_proxies: "proxy.internal" is not an IP address or network`},
+		{"client ip header", func(c *Config) { c.ClientIPHeader = "X-Client-IP" }, "client_ip_header must be Forwarded, X-Forwarded-For or X-Real-IP"},
+		{"backup schedule", func(c *Config) { c.BackupSchedule = "0 3 * *" }, `backup_schedule: schedule "0 3 * *" must be five cron fields`},
+		{"backup schedule and interval", func(c *Config) { c.BackupSchedule, c.BackupIntervalHours = "@daily", 24 }, "set backup_schedule or backup_interval_hours, not both"},
+		{"purge schedule", func(c *Config) { c.PurgeSchedule = "0 0 30 2 *" }, `purge_schedule: schedule "0 0 30 2 *" never runs`},
+		{"retention", func(c *Config) { c.PurgeSchedule, c.SpamRetentionDays = "", 30 }, "spam_retention_days and pending_retention_days need a purge_schedule"},
+		{"dnsbl zone", func(c *Config) { c.DNSBLZones = []string{"zen.spamhaus.org", "http://bl.example"} }, `dnsbl_zones: "http://bl.example" must be a zone like zen.spamhaus.org`},
+		{"dnsbl timeout", func(c *Config) { c.DNSBLTimeoutMS = 0 }, "dnsbl_timeout_ms must be between 1 and 5000"},
+		{"request log bbolt", func(c *Config) { c.DBDriver, c.RequestLogTable = "bbolt", true }, "request_log_table needs db_driver sqlite3 or mysql"},
+		{"request log retention", func(c *Config) { c.PurgeSchedule, c.RequestLogRetentionDays = "", 30 }, "request_log_retention_days needs a purge_schedule"},
+		{"request log age", func(c *Config) { c.RequestLogRetentionDays = -1 }, "request_log_retention_days must not be negative"},
+		{"bot hit retention", func(c *Config) { c.PurgeSchedule, c.BotHitRetentionDays = "", 30 }, "bot_hit_retention_days needs a purge_schedule"},
+		{"honeypot ban", func(c *Config) { c.HoneypotBanMinutes = -1 }, "honeypot_ban_minutes must not be negative"},
+		{"archive", func(c *Config) { c.ArchiveSchedule, c.ArchiveAfterYears = "", 5 }, "archive_after_years needs an archive_schedule"},
+		{"archive age", func(c *Config) { c.ArchiveAfterYears = -1 }, "archive_after_years must not be negative"},
+		{"concurrent writes", func(c *Config) { c.MaxConcurrentWrites = -1 }, "max_concurrent_writes must not be negative"},
+		{"retry after", func(c *Config) { c.OverloadRetryAfter = -1 }, "overload_retry_after must not be negative"},
+		{"breaker", func(c *Config) { c.BreakerFailures = -1 }, "breaker_failures must not be negative"},
+		{"notify email", func(c *Config) { c.NotifyEmail = "owner@example.com" }, "mail_from is required with notify_email"},
+		{"telegram", func(c *Config) { c.TelegramBotToken = "123:abc" }, "telegram_bot_token and telegram_chat_id must be set together"},
+		{"mastodon", func(c *Config) { c.MastodonServer = "https://mastodon.example" }, "mastodon_token is required with mastodon_server"},
+		{"digest", func(c *Config) { c.DigestSchedule = "@weekly" }, "digest_schedule needs notify_email"},
+		{"disabled notifiers", func(c *Config) { c.DisabledNotifiers = []string{"email", "sms"} }, `disabled_notifiers: unknown notifier "sms"`},
+		{"spam weights", func(c *Config) { c.SpamWeights = map[string]float64{"links": -1, "akismet": 1} }, `spam_weights: unknown check "akismet"`},
+		{"avatar uploads", func(c *Config) { c.AvatarUploads = true }, "avatar_uploads needs media_storage"},
+		{"media", func(c *Config) { c.MediaStorage = "s3" }, "media_s3_bucket is required with media_storage s3"},
+		{"link blocklist", func(c *Config) { c.LinkBlocklist = []string{"https://bit.ly"} }, `link_blocklist: "https://bit.ly" must be a domain like example.com`},
+		{"email verification secret", func(c *Config) { c.EmailVerificationSecret = "short" }, "email_verification_secret must be at least 32 characters"},
+		{"approve verified", func(c *Config) { c.ApproveVerified = true }, "approve_verified needs email_verification_secret"},
+		{"double opt-in", func(c *Config) { c.DoubleOptIn = true }, "double_opt_in needs email_verification_secret"},
+		{"comment management", func(c *Config) { c.CommentManagement = true }, "comment_management needs email_verification_secret"},
+		{"email locale", func(c *Config) { c.EmailLocale = "../de" }, `email_locale "../de" must be a language tag like de or pt-BR`},
+		{"deepl", func(c *Config) { c.TranslateProvider = "deepl" }, "translate_api_key is required with translate_provider deepl"},
+		{"translate languages", func(c *Config) { c.TranslateLanguages = []string{"en", "english"} }, `translate_languages: "english" must be a language tag like de or pt-BR`},
+		{"translate without languages", func(c *Config) { c.TranslateProvider, c.TranslateAPIKey = "deepl", "key" }, "translate_provider needs translate_languages"},
+		{"webhook", func(c *Config) { c.WebhookURLs = []string{"ftp://example.com"} }, `webhook URL "ftp://example.com" must be an http or https URL`},
+		{"closed after", func(c *Config) { c.ClosedAfter = "2024-13-01" }, `closed_after "2024-13-01" must be RFC 3339 or YYYY-MM-DD`},
+		{"sign in required", func(c *Config) { c.RequireSignIn = true }, "require_sign_in needs indieauth or an OAuth provider to sign in with"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := defaultConfig()
+			c.DBPath = filepath.Join(dir, "guestbook.db")
+			c.LogPath = filepath.Join(dir, "guestbook.log")
+			tt.modify(&c)
+			err := validateConfig(c)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("validateConfig() = %v, want error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckPathWritable(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.log")
+	if err := os.WriteFile(existing, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkPathWritable(existing); err != nil {
+		t.Errorf("checkPathWritable(existing) = %v", err)
+	}
+	if err := checkPathWritable(filepath.Join(dir, "new.log")); err != nil {
+		t.Errorf("checkPathWritable(new) = %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("checkPathWritable left files behind: %v", entries)
+	}
+	if err := checkPathWritable(filepath.Join(dir, "missing", "new.log")); err == nil {
+		t.Error("checkPathWritable(missing dir) = nil, want error")
+	}
+}
+
+func TestLoadConfigFormats(t *testing.T) {
+	tests := []struct {
+		file string
+		body string
+	}{
+		{"config.toml", "port = 8080\nlog_compress = true\ntrace_sample_ratio = 0.5\nhoneypot_paths = ['/wp-login.php']\n[spam_weights]\nlinks = 2\n"},
+		{"config.yaml", "port: 8080\nlog_compress: true\ntrace_sample_ratio: 0.5\nhoneypot_paths:\n  - /wp-login.php\nspam_weights:\n  links: 2\n"},
+		{"config.yml", "port: 8080\nlog_compress: true\ntrace_sample_ratio: .5\nhoneypot_paths: [/wp-login.php]\nspam_weights: {links: 2.0}\n"},
+		{"config.json", `{"port": 8080, "log_compress": true, "trace_sample_ratio": 0.5, "honeypot_paths": ["/wp-login.php"], "spam_weights": {"links": 2}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.body), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			c, err := loadConfig(path, envMap(nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Port != 8080 || !c.LogCompress || c.TraceSampleRatio != 0.5 || !slices.Equal(c.HoneypotPaths, []string{"/wp-login.php"}) {
+				t.Errorf("loadConfig() = %+v", c)
+			}
+			if c.LogLevel != "info" {
+				t.Errorf("LogLevel = %q, want default", c.LogLevel)
+			}
+			if c.SpamWeights["links"] != 2 || c.SpamWeights["honeypot"] != 5 {
+				t.Errorf("SpamWeights = %v, want links from the file over the defaults", c.SpamWeights)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFormatErrors(t *testing.T) {
+	tests := []struct {
+		file string
+		body string
+		want string
+	}{
+		{"config.yaml", "port: eighty\n", "port: expected int"},
+		{"config.json", `{"port": 80.5}`, "port: expected int"},
+		{"config.json", `{"honeypot_paths": [1, 2]}`, "honeypot_paths: expected a list of strings"},
+		{"config.json", `{"port": `, "config.json"},
+		{"config.ini", "port=80", "unsupported config format"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), tt.file)
+		if err := os.WriteFile(path, []byte(tt.body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := loadConfig(path, envMap(nil))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("loadConfig(%s %q) = %v, want error containing %q", tt.file, tt.body, err, tt.want)
+		}
+	}
+}
+
+func TestFindConfig(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	if got := findConfig(); got != "config.toml" {
+		t.Errorf("findConfig() = %q with no files, want config.toml", got)
+	}
+	os.WriteFile("config.json", []byte("{}"), 0o644)
+	if got := findConfig(); got != "config.json" {
+		t.Errorf("findConfig() = %q, want config.json", got)
+	}
+	os.WriteFile("config.yaml", []byte(""), 0o644)
+	if got := findConfig(); got != "config.yaml" {
+		t.Errorf("findConfig() = %q, want config.yaml before config.json", got)
+	}
+}
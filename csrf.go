@@ -0,0 +1,125 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+const csrfCookie = "guestbook_csrf"
+
+// csrfEnabled is true in csrf_mode "cookie" and "signed", for deployments
+// where browsers submit a form the guestbook serves itself. In "api" mode
+// (the default) clients are expected to be scripts or frontends guarded by
+// CORS and tokens, and no CSRF token is required.
+func csrfEnabled() bool {
+	mode := settings().CSRFMode
+	return mode == "cookie" || mode == "signed"
+}
+
+// csrfToken returns the request's double-submit token, issuing a new cookie
+// if it doesn't have one yet, or in csrf_mode "signed" a new form token.
+// Pages embed the token in their form.
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if cfg := settings(); cfg.CSRFMode == "signed" {
+		return formToken(cfg, r, time.Now())
+	}
+	if c, err := r.Cookie(csrfCookie); err == nil && len(c.Value) == 43 {
+		return c.Value
+	}
+
+	b := make([]byte, 32)
+	rand.Read(b)
+	token := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token
+}
+
+// checkCSRF verifies the double-submit token on a form POST: the csrf_token
+// field (or X-CSRF-Token header) has to match the cookie, which another
+// site can neither read nor set, or be a valid form token in csrf_mode
+// "signed". Requests carrying the admin token or an ID token don't rely on
+// cookies and are exempt.
+func checkCSRF(w http.ResponseWriter, r *http.Request) bool {
+	if !csrfEnabled() || requestRole(r) != "" {
+		return true
+	}
+	if cfg := settings(); cfg.CSRFMode == "signed" {
+		sent := r.Header.Get("X-CSRF-Token")
+		if sent == "" {
+			if !parseForm(w, r) {
+				return false
+			}
+			sent = r.PostFormValue("csrf_token")
+		}
+		if err := verifyFormToken(cfg, r, sent, time.Now()); err != nil {
+			requestLogger(r).Info("form token refused", "error", err)
+			httpError(w, r, http.StatusForbidden, codeCSRFFailed, "Invalid, expired or used form token, reload the page and try again")
+			return false
+		}
+		return true
+	}
+
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil || cookie.Value == "" {
+		httpError(w, r, http.StatusForbidden, codeCSRFFailed, "Missing CSRF cookie, reload the page and try again")
+		return false
+	}
+
+	sent := r.Header.Get("X-CSRF-Token")
+	if sent == "" {
+		if !parseForm(w, r) {
+			return false
+		}
+		sent = r.PostFormValue("csrf_token")
+	}
+	if subtle.ConstantTimeCompare([]byte(sent), []byte(cookie.Value)) != 1 {
+		httpError(w, r, http.StatusForbidden, codeCSRFFailed, "Invalid CSRF token, reload the page and try again")
+		return false
+	}
+	return true
+}
+
+// claimCSRF uses up the form token checkCSRF accepted for a comment, in
+// csrf_mode "signed". It's up to submitComment to call it once the comment
+// turned out to be new, so a retry with the same Idempotency-Key or a
+// double-clicked button gets the first answer, and drafts, which don't
+// call it, don't use the token up. Refusals are *apiError.
+func claimCSRF(ctx context.Context, r *http.Request) error {
+	cfg := settings()
+	if cfg.CSRFMode != "signed" || requestRole(r) != "" {
+		return nil
+	}
+	sent := cmp.Or(r.Header.Get("X-CSRF-Token"), r.PostFormValue("csrf_token"))
+	err := claimFormToken(ctx, cfg, sent, time.Now())
+	if errors.Is(err, errFormTokenUsed) {
+		requestLogger(r).Info("form token refused", "error", err)
+		return &apiError{status: http.StatusForbidden, code: codeCSRFFailed, message: "Invalid, expired or used form token, reload the page and try again"}
+	}
+	return err
+}
+
+// csrfTokenHandler hands the token to script frontends in cookie mode.
+func csrfTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	token := csrfToken(w, r)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
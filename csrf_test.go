@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.CSRFMode = "cookie"
+	config.AdminToken = "secret"
+
+	// Fetch a token the way a frontend would
+	recorder := httptest.NewRecorder()
+	csrfTokenHandler(recorder, httptest.NewRequest("GET", "/csrf-token", nil))
+	var body map[string]string
+	if err := json.NewDecoder(recorder.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	cookies := recorder.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != body["token"] {
+		t.Fatalf("Token %q doesn't match cookies %v", body["token"], cookies)
+	}
+	token := body["token"]
+
+	form := "name=John&email=john@example.com&comment=Hello"
+	tests := []struct {
+		name           string
+		cookie         string
+		field          string
+		header         string
+		admin          bool
+		expectedStatus int
+	}{
+		{"Form field matches cookie", token, token, "", false, 201},
+		{"Header matches cookie", token, "", token, false, 201},
+		{"No cookie", "", token, "", false, 403},
+		{"No token", token, "", "", false, 403},
+		{"Token from elsewhere", token, strings.Repeat("x", 43), "", false, 403},
+		{"Admin token is exempt", "", "", "", true, 201},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := form
+			if tt.field != "" {
+				data += "&csrf_token=" + tt.field
+			}
+			req := httptest.NewRequest("POST", "/comments", strings.NewReader(data))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: csrfCookie, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				req.Header.Set("X-CSRF-Token", tt.header)
+			}
+			if tt.admin {
+				req.Header.Set("Authorization", "Bearer secret")
+			}
+			recorder := httptest.NewRecorder()
+
+			commentsHandler(recorder, req)
+
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, recorder.Code, recorder.Body)
+			}
+		})
+	}
+
+	// API mode doesn't check anything
+	config.CSRFMode = "api"
+	req := httptest.NewRequest("POST", "/comments", strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder = httptest.NewRecorder()
+	commentsHandler(recorder, req)
+	if recorder.Code != 201 {
+		t.Errorf("Expected status 201 in api mode, got %d", recorder.Code)
+	}
+}
+
+func TestCSRFTokenReusesCookie(t *testing.T) {
+	existing := strings.Repeat("a", 43)
+	req := httptest.NewRequest("GET", "/csrf-token", nil)
+	req.AddCookie(&http.Cookie{Name: csrfCookie, Value: existing})
+	recorder := httptest.NewRecorder()
+
+	if got := csrfToken(recorder, req); got != existing {
+		t.Errorf("Expected existing token, got %q", got)
+	}
+	if len(recorder.Result().Cookies()) != 0 {
+		t.Error("A new cookie was set although one existed")
+	}
+}
@@ -0,0 +1,532 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const adminSessionCookie = "guestbook_admin"
+
+// adminSessionTTL is how long a dashboard login lasts.
+const adminSessionTTL = 12 * time.Hour
+
+// Failed logins are counted per IP; after maxLoginFailures within
+// loginWindow the IP can't try again until the window is over.
+const (
+	maxLoginFailures = 10
+	loginWindow      = 15 * time.Minute
+)
+
+const dashboardPageSize = 50
+
+// adminSession is a dashboard login, kept in shared state under a hash of
+// the session cookie, or the refresh token of an API login.
+type adminSession struct {
+	// CSRF has to come with every form the dashboard posts.
+	CSRF string `json:"csrf"`
+	// Token is a hash of the admin token the session was opened with, so
+	// changing admin_token logs everybody out.
+	Token string `json:"token"`
+	// Issuer is set instead for logins with OpenID Connect, which have
+	// the Role and Name the provider gave.
+	Issuer string `json:"issuer,omitempty"`
+	Role   string `json:"role,omitempty"`
+	Name   string `json:"name,omitempty"`
+	// Sid names an API login across its refresh tokens, so revoking one
+	// token revokes all of them. Rotated marks a refresh token that was
+	// already used.
+	Sid     string `json:"sid,omitempty"`
+	Rotated bool   `json:"rotated,omitempty"`
+}
+
+func adminSessionKey(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return "admin-session:" + hex.EncodeToString(sum[:])
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// dashboardSession returns the session of a logged-in admin, or nil.
+func dashboardSession(r *http.Request) *adminSession {
+	c, err := r.Cookie(adminSessionCookie)
+	if err != nil || c.Value == "" {
+		return nil
+	}
+	v, ok, err := shared.Get(r.Context(), adminSessionKey(c.Value))
+	if err != nil {
+		requestLogger(r).Warn("reading the admin session failed", "error", err)
+		return nil
+	}
+	var s adminSession
+	if !ok || json.Unmarshal(v, &s) != nil || !s.current() {
+		return nil
+	}
+	return &s
+}
+
+// current reports whether s still holds: logins with OpenID Connect while
+// oidc_issuer stays the same, the others while admin_token does. It sets
+// the Role of the latter.
+func (s *adminSession) current() bool {
+	if s.Issuer != "" {
+		return s.Issuer == config.OIDCIssuer
+	}
+	adminToken := settings().AdminToken
+	if adminToken == "" || s.Token != hashToken(adminToken) {
+		return false
+	}
+	s.Role = roleAdmin
+	return true
+}
+
+func sameToken(a, b string) bool {
+	return a != "" && subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// adminPageHeaders keeps dashboard pages out of caches and frames.
+func adminPageHeaders(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'self' 'unsafe-inline'; form-action 'self'; frame-ancestors 'none'")
+	w.Header().Set("Referrer-Policy", "same-origin")
+}
+
+type loginView struct {
+	Enabled bool
+	OIDC    bool
+	CSRF    string
+	Error   string
+}
+
+func renderLogin(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	renderHTML(w, r, templates.Load().admin, status, "login.html", loginView{
+		Enabled: settings().AdminToken != "",
+		OIDC:    config.OIDCIssuer != "",
+		CSRF:    csrfToken(w, r),
+		Error:   msg,
+	})
+}
+
+// dashboardHandler serves the admin dashboard at /admin, or the login form
+// to whoever isn't logged in.
+func dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	adminPageHeaders(w)
+	session := dashboardSession(r)
+	if session == nil {
+		renderLogin(w, r, http.StatusOK, "")
+		return
+	}
+
+	site, sites, err := dashboardSite(r)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if site == nil {
+		httpError(w, r, http.StatusNotFound, codeUnknownSite, "No sites yet, add one with guestbook add-site")
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && !slices.Contains(commentStatuses, status) {
+		httpError(w, r, 400, codeInvalidStatus, "status must be one of approved, pending, spam or unconfirmed")
+		return
+	}
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	page = max(page, 1)
+
+	ctx := r.Context()
+	q := CommentQuery{SiteID: site.ID, Status: status, Limit: dashboardPageSize, Offset: (page - 1) * dashboardPageSize}
+	total, err := store.Count(ctx, q)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	comments, err := store.List(ctx, q)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	stats, err := store.Stats(ctx, site.ID, time.Now().UTC())
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	blocklist, err := store.Blocklist(ctx)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+
+	v := dashboardView{
+		Site:      site,
+		Sites:     sites,
+		Comments:  comments,
+		Page:      page,
+		Pages:     max(1, (total+dashboardPageSize-1)/dashboardPageSize),
+		Stats:     stats,
+		Blocklist: blocklist,
+		CSRF:      session.CSRF,
+		Return:    dashboardURL(site, status, page),
+		User:      session.Name,
+		Role:      session.Role,
+	}
+	if page > 1 {
+		v.PrevURL = dashboardURL(site, status, page-1)
+	}
+	if page < v.Pages {
+		v.NextURL = dashboardURL(site, status, page+1)
+	}
+	for _, s := range append([]string{""}, commentStatuses...) {
+		label := "All"
+		if s != "" {
+			label = strings.ToUpper(s[:1]) + s[1:]
+		}
+		v.Filters = append(v.Filters, dashboardFilter{Label: label, URL: dashboardURL(site, s, 1), Current: s == status})
+	}
+	most := 1
+	for _, d := range stats.PerDay {
+		most = max(most, d.Count)
+	}
+	for _, d := range stats.PerDay {
+		v.Days = append(v.Days, dayBar{DayCount: d, Percent: d.Count * 100 / most})
+	}
+	renderHTML(w, r, templates.Load().admin, http.StatusOK, "dashboard.html", v)
+}
+
+type dashboardView struct {
+	Site      *Site
+	Sites     []*Site
+	Filters   []dashboardFilter
+	Comments  []Comment
+	Page      int
+	Pages     int
+	PrevURL   string
+	NextURL   string
+	Stats     *Stats
+	Days      []dayBar
+	Blocklist []BlockedIP
+	CSRF      string
+	// Return is the page actions come back to.
+	Return string
+	// User and Role are who logged in with OpenID Connect.
+	User, Role string
+}
+
+type dashboardFilter struct {
+	Label   string
+	URL     string
+	Current bool
+}
+
+type dayBar struct {
+	DayCount
+	Percent int
+}
+
+// dashboardAction is a button that posts to /admin/action.
+type dashboardAction struct {
+	Name, Label  string
+	ID           int
+	IP           string
+	CSRF, Return string
+	Site         string
+}
+
+var dashboardLabels = map[string]string{
+	"approve": "Approve", "spam": "Spam", "delete": "Delete", "ban": "Ban IP", "unban": "Unban",
+}
+
+// Action builds the button for action on comment id or ip.
+func (v dashboardView) Action(name string, id int, ip string) dashboardAction {
+	return dashboardAction{Name: name, Label: dashboardLabels[name], ID: id, IP: ip, CSRF: v.CSRF, Return: v.Return, Site: v.Site.Slug}
+}
+
+// dashboardURL links to a page of the dashboard.
+func dashboardURL(site *Site, status string, page int) string {
+	q := url.Values{}
+	if config.MultiTenant {
+		q.Set("site", site.Slug)
+	}
+	if status != "" {
+		q.Set("status", status)
+	}
+	if page > 1 {
+		q.Set("page", strconv.Itoa(page))
+	}
+	if len(q) == 0 {
+		return "/admin"
+	}
+	return "/admin?" + q.Encode()
+}
+
+// dashboardSite returns the site the dashboard shows, picked by the site
+// parameter from all sites, or the first one. Without multi_tenant it's
+// always defaultSite. It's nil when there are no sites yet.
+func dashboardSite(r *http.Request) (*Site, []*Site, error) {
+	if !config.MultiTenant {
+		return defaultSite, nil, nil
+	}
+	sites, err := listSites(r.Context())
+	if err != nil || len(sites) == 0 {
+		return nil, nil, err
+	}
+	slug := r.FormValue("site")
+	for _, s := range sites {
+		if s.Slug == slug {
+			return s, sites, nil
+		}
+	}
+	return sites[0], sites, nil
+}
+
+// adminLoginHandler opens a dashboard session for whoever knows the admin
+// token. API clients that ask for JSON get access and refresh tokens
+// instead, see adminTokenLogin.
+func adminLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		adminTokenLogin(w, r)
+		return
+	}
+	adminPageHeaders(w)
+	if !parseForm(w, r) {
+		return
+	}
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil || !sameToken(r.PostFormValue("csrf_token"), cookie.Value) {
+		renderLogin(w, r, http.StatusForbidden, "The form expired, please try again.")
+		return
+	}
+	adminToken := settings().AdminToken
+	if adminToken == "" {
+		renderLogin(w, r, http.StatusForbidden, "")
+		return
+	}
+
+	if loginLocked(r) {
+		renderLogin(w, r, http.StatusTooManyRequests, "Too many failed attempts, try again later.")
+		return
+	}
+	if !sameToken(r.PostFormValue("token"), adminToken) {
+		countLoginFailure(r)
+		renderLogin(w, r, http.StatusUnauthorized, "Wrong admin token.")
+		return
+	}
+
+	if err := startAdminSession(w, r, adminSession{Token: hashToken(adminToken)}); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	requestLogger(r).Info("admin logged in")
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+func loginFailuresKey(r *http.Request) string {
+	return "admin-login:" + getIP(r)
+}
+
+// loginLocked reports whether the request's IP failed to log in
+// maxLoginFailures times within loginWindow.
+func loginLocked(r *http.Request) bool {
+	v, ok, err := shared.Get(r.Context(), loginFailuresKey(r))
+	if err != nil || !ok {
+		return false
+	}
+	n, _ := strconv.Atoi(string(v))
+	return n >= maxLoginFailures
+}
+
+func countLoginFailure(r *http.Request) {
+	if _, err := shared.Incr(r.Context(), loginFailuresKey(r), loginWindow); err != nil {
+		requestLogger(r).Warn("counting the failed login failed", "error", err)
+	}
+	requestLogger(r).Warn("admin login failed")
+}
+
+// startAdminSession logs the browser in to the dashboard as s, with a new
+// CSRF token.
+func startAdminSession(w http.ResponseWriter, r *http.Request, s adminSession) error {
+	id := randomToken()
+	s.CSRF = randomToken()
+	v, _ := json.Marshal(s)
+	if err := shared.Set(r.Context(), adminSessionKey(id), v, adminSessionTTL); err != nil {
+		return err
+	}
+	// Lax for logins with OpenID Connect, which end with a redirect from
+	// the provider; Strict otherwise
+	sameSite := http.SameSiteStrictMode
+	if s.Issuer != "" {
+		sameSite = http.SameSiteLaxMode
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     adminSessionCookie,
+		Value:    id,
+		Path:     "/admin",
+		MaxAge:   int(adminSessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: sameSite,
+	})
+	return nil
+}
+
+func adminLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := checkDashboardForm(w, r); !ok {
+		return
+	}
+	c, _ := r.Cookie(adminSessionCookie)
+	if err := shared.Delete(r.Context(), adminSessionKey(c.Value)); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: adminSessionCookie, Path: "/admin", MaxAge: -1, HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteStrictMode})
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// checkDashboardForm makes sure a dashboard POST comes from a logged-in
+// admin's own page.
+func checkDashboardForm(w http.ResponseWriter, r *http.Request) (*adminSession, bool) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return nil, false
+	}
+	session := dashboardSession(r)
+	if session == nil {
+		httpError(w, r, http.StatusUnauthorized, codeUnauthorized, "Log in to the dashboard first")
+		return nil, false
+	}
+	if !parseForm(w, r) {
+		return nil, false
+	}
+	if !sameToken(r.PostFormValue("csrf_token"), session.CSRF) {
+		httpError(w, r, http.StatusForbidden, codeCSRFFailed, "Invalid CSRF token, reload the page and try again")
+		return nil, false
+	}
+	return session, true
+}
+
+// dashboardActionHandler runs the dashboard's buttons: approve, spam or
+// delete a comment, ban the IP of a comment or a given IP or network, for
+// good or for a duration, or unban.
+func dashboardActionHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := checkDashboardForm(w, r); !ok {
+		return
+	}
+	site, _, err := dashboardSite(r)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if site == nil || (config.MultiTenant && r.PostFormValue("site") != site.Slug) {
+		httpError(w, r, http.StatusNotFound, codeUnknownSite, errUnknownSite.Error())
+		return
+	}
+
+	ctx := r.Context()
+	action := r.PostFormValue("action")
+	ip := r.PostFormValue("ip")
+	var c *Comment
+	if v := r.PostFormValue("id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			httpError(w, r, 400, codeInvalidID, "id must be a comment id")
+			return
+		}
+		if c, err = store.Get(ctx, site.ID, id); err == errNotFound {
+			httpError(w, r, http.StatusNotFound, codeNotFound, "Comment not found")
+			return
+		} else if err != nil {
+			internalError(w, r, err)
+			return
+		}
+		if ip == "" {
+			ip = c.IP
+		}
+	}
+
+	switch action {
+	case "approve", "pending", "spam", "delete":
+		if c == nil {
+			httpError(w, r, 400, codeInvalidID, "id must be a comment id")
+			return
+		}
+		if site.Archived {
+			httpError(w, r, http.StatusForbidden, codeSiteArchived, "The site is archived and read-only")
+			return
+		}
+		if action == "delete" {
+			err = store.Delete(ctx, site.ID, c.ID)
+		} else {
+			c.Status = action
+			if action == "approve" {
+				c.Status = "approved"
+			}
+			if err = store.Update(ctx, c); err == nil {
+				if lerr := learnSpam(ctx, c); lerr != nil {
+					requestLogger(r).Warn("Spam classifier didn't learn from the decision", "id", c.ID, "error", lerr)
+				}
+			}
+		}
+	case "ban", "unban":
+		p, perr := parseNetwork(ip)
+		if perr != nil {
+			httpError(w, r, 400, codeInvalidForm, "ip must be an IP address or a network like 203.0.113.0/24")
+			return
+		}
+		ip = banKey(p)
+		if action == "unban" {
+			err = store.UnblockIP(ctx, ip)
+			break
+		}
+		var expires time.Time
+		if v := r.PostFormValue("duration"); v != "" {
+			d, derr := time.ParseDuration(v)
+			if derr != nil || d <= 0 {
+				httpError(w, r, 400, codeInvalidForm, "duration must be a duration like 24h")
+				return
+			}
+			expires = time.Now().Add(d)
+		}
+		err = store.BlockIP(ctx, ip, "banned from the dashboard", expires)
+	default:
+		httpError(w, r, 400, codeInvalidForm, "action must be approve, pending, spam, delete, ban or unban")
+		return
+	}
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	requestLogger(r).Info("dashboard action", "action", action, "site", site.Slug, "id", r.PostFormValue("id"), "target_ip", ip)
+
+	back := r.PostFormValue("return")
+	if back != "/admin" && !strings.HasPrefix(back, "/admin?") {
+		back = "/admin"
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
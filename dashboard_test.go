@@ -0,0 +1,254 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dashboardClient drives the dashboard like a browser, keeping cookies.
+type dashboardClient struct {
+	t       *testing.T
+	mux     *http.ServeMux
+	cookies map[string]*http.Cookie
+}
+
+func newDashboardClient(t *testing.T) *dashboardClient {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin", dashboardHandler)
+	mux.HandleFunc("/admin/login", adminLoginHandler)
+	mux.HandleFunc("/admin/logout", adminLogoutHandler)
+	mux.HandleFunc("/admin/action", dashboardActionHandler)
+	return &dashboardClient{t: t, mux: mux, cookies: map[string]*http.Cookie{}}
+}
+
+func (c *dashboardClient) do(method, target string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+	req.RemoteAddr = "192.0.2.9:1234"
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for _, ck := range c.cookies {
+		req.AddCookie(ck)
+	}
+	rec := httptest.NewRecorder()
+	c.mux.ServeHTTP(rec, req)
+	for _, ck := range rec.Result().Cookies() {
+		if ck.MaxAge < 0 {
+			delete(c.cookies, ck.Name)
+		} else {
+			c.cookies[ck.Name] = ck
+		}
+	}
+	return rec
+}
+
+var csrfField = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+// csrf returns the token of the first form on the last page.
+func csrf(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	m := csrfField.FindStringSubmatch(rec.Body.String())
+	if m == nil {
+		t.Fatalf("No CSRF token in page:\n%s", rec.Body.String())
+	}
+	return m[1]
+}
+
+func (c *dashboardClient) login(token string) *httptest.ResponseRecorder {
+	page := c.do("GET", "/admin", nil)
+	return c.do("POST", "/admin/login", url.Values{"csrf_token": {csrf(c.t, page)}, "token": {token}})
+}
+
+func TestDashboardLogin(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	defer func(s SharedState) { shared = s }(shared)
+	defer func(s CommentStore) { store = s }(store)
+	config.AdminToken = "secret"
+	shared = newMemoryState()
+	store = newMemoryStore()
+
+	c := newDashboardClient(t)
+	rec := c.do("GET", "/admin", nil)
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `name="token"`) {
+		t.Fatalf("Dashboard before login = %d:\n%s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("X-Frame-Options") != "DENY" || rec.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("Login page headers = %v", rec.Header())
+	}
+
+	if rec := c.do("POST", "/admin/login", url.Values{"token": {"secret"}}); rec.Code != http.StatusForbidden {
+		t.Errorf("Login without CSRF token = %d, want 403", rec.Code)
+	}
+	if rec := c.login("wrong"); rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "Wrong admin token") {
+		t.Errorf("Login with the wrong token = %d", rec.Code)
+	}
+	rec = c.login("secret")
+	if rec.Code != http.StatusSeeOther || c.cookies[adminSessionCookie] == nil {
+		t.Fatalf("Login = %d, cookies %v", rec.Code, c.cookies)
+	}
+	if ck := c.cookies[adminSessionCookie]; !ck.HttpOnly || ck.SameSite != http.SameSiteStrictMode || ck.Path != "/admin" {
+		t.Errorf("Session cookie = %+v", ck)
+	}
+	rec = c.do("GET", "/admin", nil)
+	if !strings.Contains(rec.Body.String(), "Log out") {
+		t.Fatalf("Dashboard after login:\n%s", rec.Body.String())
+	}
+
+	// a new admin token ends every session
+	config.AdminToken = "rotated"
+	if rec := c.do("GET", "/admin", nil); !strings.Contains(rec.Body.String(), `name="token"`) {
+		t.Error("Session survived changing admin_token")
+	}
+	config.AdminToken = "secret"
+
+	page := c.do("GET", "/admin", nil)
+	c.do("POST", "/admin/logout", url.Values{"csrf_token": {csrf(t, page)}})
+	if rec := c.do("GET", "/admin", nil); !strings.Contains(rec.Body.String(), `name="token"`) {
+		t.Error("Still logged in after logging out")
+	}
+}
+
+func TestDashboardLoginLockout(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	defer func(s SharedState) { shared = s }(shared)
+	config.AdminToken = "secret"
+	shared = newMemoryState()
+
+	c := newDashboardClient(t)
+	for range maxLoginFailures {
+		c.login("guess")
+	}
+	if rec := c.login("secret"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Login after %d failures = %d, want 429", maxLoginFailures, rec.Code)
+	}
+}
+
+func TestDashboardWithoutAdminToken(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.AdminToken = ""
+
+	c := newDashboardClient(t)
+	rec := c.do("GET", "/admin", nil)
+	if !strings.Contains(rec.Body.String(), "Set <code>admin_token</code>") {
+		t.Errorf("Dashboard without admin_token:\n%s", rec.Body.String())
+	}
+}
+
+func TestDashboardActions(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	defer func(s SharedState) { shared = s }(shared)
+	defer func(s CommentStore) { store = s }(store)
+	config.AdminToken = "secret"
+	shared = newMemoryState()
+	store = newMemoryStore()
+	ctx := t.Context()
+
+	var ids []int
+	for i := range dashboardPageSize + 2 {
+		cm := &Comment{Name: "Visitor", Text: "Comment " + strconv.Itoa(i), IP: "198.51.100.1"}
+		if i == 0 {
+			cm.Name, cm.Text, cm.IP, cm.Status = "Pat", "Please approve me", "203.0.113.7", "pending"
+		}
+		if err := store.Create(ctx, cm); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, cm.ID)
+	}
+	pending := ids[0]
+
+	c := newDashboardClient(t)
+	if rec := c.login("secret"); rec.Code != http.StatusSeeOther {
+		t.Fatalf("Login = %d", rec.Code)
+	}
+	page := c.do("GET", "/admin?status=pending", nil)
+	body := page.Body.String()
+	if !strings.Contains(body, "Please approve me") || strings.Contains(body, "Comment 1<") {
+		t.Fatalf("Pending filter shows:\n%s", body)
+	}
+	token := csrf(t, page)
+
+	if rec := c.do("GET", "/admin", nil); !strings.Contains(rec.Body.String(), "Page 1 of 2") || !strings.Contains(rec.Body.String(), "page=2") {
+		t.Errorf("First page doesn't link to the second:\n%s", rec.Body.String())
+	}
+	if rec := c.do("GET", "/admin?page=2", nil); !strings.Contains(rec.Body.String(), "Please approve me") {
+		t.Error("The oldest comment isn't on page 2")
+	}
+
+	tests := []struct {
+		name   string
+		form   url.Values
+		status int
+		check  func() bool
+	}{
+		{"Approve", url.Values{"action": {"approve"}, "id": {strconv.Itoa(pending)}}, http.StatusSeeOther, func() bool {
+			c, _ := store.Get(ctx, 0, pending)
+			return c.Status == "approved"
+		}},
+		{"Spam", url.Values{"action": {"spam"}, "id": {strconv.Itoa(ids[1])}}, http.StatusSeeOther, func() bool {
+			c, _ := store.Get(ctx, 0, ids[1])
+			return c.Status == "spam"
+		}},
+		{"Ban the IP of a comment", url.Values{"action": {"ban"}, "id": {strconv.Itoa(pending)}}, http.StatusSeeOther, func() bool {
+			blocked, _ := store.IsBlocked(ctx, "203.0.113.7")
+			return blocked
+		}},
+		{"Unban", url.Values{"action": {"unban"}, "ip": {"203.0.113.7"}}, http.StatusSeeOther, func() bool {
+			blocked, _ := store.IsBlocked(ctx, "203.0.113.7")
+			return !blocked
+		}},
+		{"Ban a network for a day", url.Values{"action": {"ban"}, "ip": {"198.51.100.0/24"}, "duration": {"24h"}}, http.StatusSeeOther, func() bool {
+			blocked, _ := store.IsBlocked(ctx, "198.51.100.200")
+			list, _ := store.Blocklist(ctx)
+			return blocked && len(list) == 1 && list[0].IP == "198.51.100.0/24" && time.Until(list[0].Expires) > 23*time.Hour
+		}},
+		{"Invalid duration", url.Values{"action": {"ban"}, "ip": {"198.51.100.0/24"}, "duration": {"soon"}}, 400, nil},
+		{"Delete", url.Values{"action": {"delete"}, "id": {strconv.Itoa(ids[2])}}, http.StatusSeeOther, func() bool {
+			_, err := store.Get(ctx, 0, ids[2])
+			return err == errNotFound
+		}},
+		{"Unknown comment", url.Values{"action": {"spam"}, "id": {"9999"}}, http.StatusNotFound, nil},
+		{"Invalid IP", url.Values{"action": {"ban"}, "ip": {"nope"}}, 400, nil},
+		{"Invalid network", url.Values{"action": {"ban"}, "ip": {"198.51.100.0/33"}}, 400, nil},
+		{"Unknown action", url.Values{"action": {"edit"}, "id": {strconv.Itoa(pending)}}, 400, nil},
+		{"Wrong CSRF token", url.Values{"action": {"delete"}, "id": {strconv.Itoa(pending)}, "csrf_token": {"forged"}}, http.StatusForbidden, func() bool {
+			_, err := store.Get(ctx, 0, pending)
+			return err == nil
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := tt.form
+			if form.Get("csrf_token") == "" {
+				form.Set("csrf_token", token)
+			}
+			form.Set("return", "/admin?status=pending")
+			rec := c.do("POST", "/admin/action", form)
+			if rec.Code != tt.status {
+				t.Fatalf("Status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if rec.Code == http.StatusSeeOther && rec.Header().Get("Location") != "/admin?status=pending" {
+				t.Errorf("Redirected to %q", rec.Header().Get("Location"))
+			}
+			if tt.check != nil && !tt.check() {
+				t.Error("The action didn't take effect")
+			}
+		})
+	}
+
+	rec := c.do("POST", "/admin/action", url.Values{"csrf_token": {token}, "action": {"ban"}, "ip": {"192.0.2.1"}, "return": {"https://evil.example/"}})
+	if loc := rec.Header().Get("Location"); loc != "/admin" {
+		t.Errorf("Redirect to another site went to %q", loc)
+	}
+
+	delete(c.cookies, adminSessionCookie)
+	rec = c.do("POST", "/admin/action", url.Values{"csrf_token": {token}, "action": {"unban"}, "ip": {"192.0.2.1"}})
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Action without a session = %d, want 401", rec.Code)
+	}
+}
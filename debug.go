@@ -0,0 +1,39 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+)
+
+// debugMux serves the pprof profiles and expvar's memstats and cmdline.
+// Both packages also register themselves on http.DefaultServeMux, which
+// withDebug keeps anyone from reaching.
+var debugMux = func() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}()
+
+// withDebug answers everything under /debug/: with debug_endpoints on,
+// admins get debugMux, and it's 404 for everybody otherwise.
+func withDebug(next http.Handler) http.Handler {
+	debug := requireAdmin(debugMux.ServeHTTP)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/debug/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !settings().DebugEndpoints {
+			http.NotFound(w, r)
+			return
+		}
+		debug(w, r)
+	})
+}
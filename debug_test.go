@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDebugEndpoints(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.AdminToken = "secret"
+	// pprof and expvar are on http.DefaultServeMux too
+	h := withDebug(http.DefaultServeMux)
+
+	get := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := get("/debug/pprof/", "secret"); rec.Code != 404 {
+		t.Errorf("pprof with debug_endpoints off = %d, want 404", rec.Code)
+	}
+
+	config.DebugEndpoints = true
+	for path, want := range map[string]int{"/debug/pprof/": 200, "/debug/pprof/heap": 200, "/debug/vars": 200, "/debug/nope": 404} {
+		if rec := get(path, "secret"); rec.Code != want {
+			t.Errorf("GET %s = %d, want %d", path, rec.Code, want)
+		}
+	}
+	if rec := get("/debug/vars", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expvar without a token = %d, want 401", rec.Code)
+	}
+	if rec := get("/debug/pprof/cmdline", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("pprof with a wrong token = %d, want 401", rec.Code)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// With digest_schedule, notify_email gets one email on that schedule
+// listing the comments since the last one, instead of an email for each.
+
+// digestMaxComments is how many comments of a site a digest lists in
+// full; it only counts the rest.
+const digestMaxComments = 100
+
+// digestData is what the digest email is rendered with.
+type digestData struct {
+	Total int
+	Since time.Time
+	Sites []digestSite
+}
+
+// digestSite is a site's part of a digest.
+type digestSite struct {
+	Name     string
+	URL      string
+	Comments []Comment
+	// More is how many comments there are beyond Comments.
+	More int
+}
+
+// digestJob emails notify_email the comments on every site that isn't
+// archived since the last digest, or since the previous scheduled run
+// when it doesn't know of one.
+func digestJob(ctx context.Context) (string, error) {
+	cfg := settings()
+	if slices.Contains(cfg.DisabledNotifiers, "email") {
+		// the comments are left for the first digest after it's back
+		return "email is in disabled_notifiers", nil
+	}
+	now := time.Now()
+	q := CommentQuery{Sort: "oldest", Limit: digestMaxComments}
+	since := now
+	if b, ok, err := shared.Get(ctx, "digest:last-id"); err != nil {
+		return "", err
+	} else if ok {
+		q.AfterID, _ = strconv.Atoi(string(b))
+		if b, ok, _ := shared.Get(ctx, "digest:last-sent"); ok {
+			since, _ = time.Parse(time.RFC3339, string(b))
+		}
+	} else {
+		sched, err := parseSchedule(cfg.DigestSchedule)
+		if err != nil {
+			return "", err
+		}
+		next := sched.next(now)
+		since = now.Add(-sched.next(next).Sub(next))
+		q.Since = since
+	}
+
+	siteIDs, err := activeSiteIDs(ctx)
+	if err != nil {
+		return "", err
+	}
+	var sites []digestSite
+	total, lastID := 0, q.AfterID
+	for _, id := range siteIDs {
+		site, err := siteByID(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		q.SiteID = id
+		// read first, so comments that come in meanwhile are left for
+		// the next digest rather than skipped
+		v, err := store.Version(ctx, CommentQuery{SiteID: id, AfterID: q.AfterID, Since: q.Since})
+		if err != nil {
+			return "", err
+		}
+		if v.Count == 0 {
+			continue
+		}
+		comments, err := store.List(ctx, q)
+		if err != nil {
+			return "", err
+		}
+		comments = slices.DeleteFunc(comments, func(c Comment) bool { return c.ID > v.MaxID })
+		d := digestSite{Name: cmp.Or(site.Name, site.Slug, "Guestbook"), Comments: comments, More: v.Count - len(comments)}
+		if cfg.PublicURL != "" {
+			d.URL = publicPageURL(site)
+		}
+		sites = append(sites, d)
+		total += v.Count
+		lastID = max(lastID, v.MaxID)
+	}
+	if total == 0 {
+		return "no new comments", nil
+	}
+
+	e, err := renderEmail(cfg, "digest", digestData{Total: total, Since: since, Sites: sites})
+	if err != nil {
+		return "", err
+	}
+	if err := enqueue(ctx, "email", cfg.NotifyEmail, e); err != nil {
+		countNotifier("email", "errors")
+		return "", err
+	}
+	countNotifier("email", "queued")
+	wakeOutbox()
+	if err := shared.Set(ctx, "digest:last-id", []byte(strconv.Itoa(lastID)), 0); err != nil {
+		return "", err
+	}
+	shared.Set(ctx, "digest:last-sent", []byte(now.UTC().Format(time.RFC3339)), 0)
+	return fmt.Sprintf("queued a digest of %d comment%s", total, plural(total)), nil
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
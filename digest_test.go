@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDigest(t *testing.T) {
+	needSQLite(t)
+	defer func(c Config, s CommentStore, st SharedState) { config, store, shared = c, s, st }(config, store, shared)
+	store, shared = newMemoryStore(), newMemoryState()
+	db.Exec("DELETE FROM outbox")
+	config.NotifyEmail, config.DigestSchedule = "owner@example.com", "@daily"
+	ctx := context.Background()
+
+	jobs := scheduledJobs(config)
+	if len(jobs) != 1 || jobs[0].name != "digest" {
+		t.Fatalf("scheduledJobs() = %+v", jobs)
+	}
+	add := func(name, text string, created time.Time) {
+		c := &Comment{Name: name, Email: strings.ToLower(name) + "@example.com", Text: text, Status: "pending", Created: created}
+		if err := store.Create(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+		// a digest replaces the email for every comment
+		notify(ctx, logger, Notification{Event: "comment.created", Site: defaultSite, Comment: c})
+	}
+	add("Old", "Before the first digest", time.Now().Add(-48*time.Hour))
+	add("Ann", "Hello", time.Time{})
+	add("Bob", "Hi there", time.Time{})
+
+	digest := func() emailMessage {
+		t.Helper()
+		var payload string
+		if err := db.QueryRow("SELECT payload FROM outbox WHERE kind = 'email' ORDER BY id DESC LIMIT 1").Scan(&payload); err != nil {
+			t.Fatal(err)
+		}
+		var e emailMessage
+		json.Unmarshal([]byte(payload), &e)
+		return e
+	}
+	if result, err := digestJob(ctx); err != nil || result != "queued a digest of 2 comments" {
+		t.Fatalf("digestJob() = %q, %v", result, err)
+	}
+	e := digest()
+	if e.Subject != "2 new comments on the guestbook" || !strings.Contains(e.Body, "Ann <ann@example.com>") || !strings.Contains(e.Body, "Hi there") || strings.Contains(e.Body, "Old") {
+		t.Errorf("Digest = %q\n%s", e.Subject, e.Body)
+	}
+
+	if result, _ := digestJob(ctx); result != "no new comments" {
+		t.Errorf("digestJob() with nothing new = %q", result)
+	}
+	add("Cat", "Third", time.Time{})
+	if result, _ := digestJob(ctx); result != "queued a digest of 1 comment" {
+		t.Errorf("Next digestJob() = %q", result)
+	}
+	if e := digest(); e.Subject != "1 new comment on the guestbook" || strings.Contains(e.Body, "Ann") {
+		t.Errorf("Next digest = %q\n%s", e.Subject, e.Body)
+	}
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM outbox").Scan(&n)
+	if n != 2 {
+		t.Errorf("%d messages in the outbox, want the 2 digests", n)
+	}
+}
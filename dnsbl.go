@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The dnsbl spam check asks the DNS blocklists in dnsbl_zones, like
+// zen.spamhaus.org or bl.spamcop.net, about the commenter's IP: a zone
+// lists 203.0.113.7 when 7.113.0.203.<zone> resolves to an address in
+// 127.0.0.0/8. The zones are asked at once, each behind a circuit breaker
+// and given dnsbl_timeout_ms, and the answers are cached in shared state
+// for dnsblCacheTTL, so a slow or unreachable blocklist costs a comment
+// that much at most, and a busy IP one lookup an hour.
+
+// dnsblCacheTTL is how long an answer from a zone is reused.
+const dnsblCacheTTL = time.Hour
+
+// dnsblLookup resolves a DNSBL query name. Tests replace it.
+var dnsblLookup = net.DefaultResolver.LookupHost
+
+// dnsblSpamCheck flags comments from IPs on any of dnsbl_zones. A zone
+// that fails or times out counts as not listing the IP; only when every
+// zone failed does the check fail, for scoreSpam to log.
+func dnsblSpamCheck(ctx context.Context, c Config, in commentInput) (float64, string, error) {
+	addr, err := netip.ParseAddr(in.IP)
+	if len(c.DNSBLZones) == 0 || err != nil || !addr.Unmap().IsGlobalUnicast() || addr.Unmap().IsPrivate() {
+		return 0, "", nil
+	}
+	name := dnsblName(addr.Unmap())
+	listed := make([]bool, len(c.DNSBLZones))
+	errs := make([]error, len(c.DNSBLZones))
+	var wg sync.WaitGroup
+	for i, zone := range c.DNSBLZones {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			listed[i], errs[i] = dnsblListed(ctx, c, name, zone)
+		}()
+	}
+	wg.Wait()
+
+	var zones []string
+	failed := 0
+	for i, zone := range c.DNSBLZones {
+		if errs[i] != nil {
+			failed++
+		} else if listed[i] {
+			zones = append(zones, zone)
+		}
+	}
+	if len(zones) == 0 {
+		if failed == len(c.DNSBLZones) {
+			return 0, "", errors.Join(errs...)
+		}
+		return 0, "", nil
+	}
+	return 1, "IP listed on " + strings.Join(zones, ", "), nil
+}
+
+// dnsblName is addr's part of a DNSBL query: the octets of an IPv4
+// address, or the nibbles of an IPv6 one, in reverse.
+func dnsblName(addr netip.Addr) string {
+	b := addr.AsSlice()
+	var parts []string
+	for _, x := range slices.Backward(b) {
+		if addr.Is4() {
+			parts = append(parts, fmt.Sprint(x))
+		} else {
+			parts = append(parts, fmt.Sprintf("%x.%x", x&0xf, x>>4))
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// dnsblListed asks zone about name, or the cache if it was asked lately.
+func dnsblListed(ctx context.Context, c Config, name, zone string) (bool, error) {
+	key := "dnsbl:" + zone + ":" + name
+	if v, ok, err := shared.Get(ctx, key); err == nil && ok {
+		return string(v) == "1", nil
+	}
+	listed, err := callExternal(ctx, "dnsbl:"+zone, time.Duration(c.DNSBLTimeoutMS)*time.Millisecond, func(ctx context.Context) (bool, error) {
+		addrs, err := dnsblLookup(ctx, name+"."+zone)
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		for _, a := range addrs {
+			ip, err := netip.ParseAddr(a)
+			// Spamhaus answers 127.255.255.x when it won't answer a
+			// resolver, which isn't a listing
+			if err == nil && ip.Is4() && ip.As4()[0] == 127 && ip.As4()[1] != 255 {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	v := []byte("0")
+	if listed {
+		v = []byte("1")
+	}
+	// the answer is good without the cache, the next comment asks again
+	shared.Set(ctx, key, v, dnsblCacheTTL)
+	return listed, nil
+}
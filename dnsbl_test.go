@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDNSBLName(t *testing.T) {
+	for ip, want := range map[string]string{
+		"203.0.113.7": "7.113.0.203",
+		"2001:db8::1": "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2",
+	} {
+		if got := dnsblName(netip.MustParseAddr(ip)); got != want {
+			t.Errorf("dnsblName(%s) = %s, want %s", ip, got, want)
+		}
+	}
+}
+
+func TestDNSBLSpamCheck(t *testing.T) {
+	defer func(s SharedState, l func(context.Context, string) ([]string, error)) { shared, dnsblLookup = s, l }(shared, dnsblLookup)
+	shared = newMemoryState()
+	var lookups atomic.Int32
+	dnsblLookup = func(ctx context.Context, host string) ([]string, error) {
+		lookups.Add(1)
+		switch host {
+		case "7.113.0.203.listed.example":
+			return []string{"127.0.0.2"}, nil
+		case "7.113.0.203.refused.example":
+			return []string{"127.255.255.254"}, nil
+		case "7.113.0.203.broken.example":
+			return nil, errors.New("SERVFAIL")
+		case "7.113.0.203.slow.example":
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	c := defaultConfig()
+	c.DNSBLTimeoutMS = 50
+
+	tests := []struct {
+		ip      string
+		zones   []string
+		score   float64
+		reason  string
+		failed  bool
+		lookups int32
+	}{
+		{"203.0.113.7", []string{"clean.example", "listed.example"}, 1, "IP listed on listed.example", false, 2},
+		// answered from the cache
+		{"203.0.113.7", []string{"listed.example"}, 1, "IP listed on listed.example", false, 0},
+		{"203.0.113.7", []string{"refused.example", "broken.example", "slow.example"}, 0, "", false, 3},
+		{"203.0.113.7", []string{"broken.example", "slow.example"}, 0, "", true, 2},
+		{"192.168.1.2", []string{"listed.example"}, 0, "", false, 0},
+		{"", []string{"listed.example"}, 0, "", false, 0},
+	}
+	for _, tt := range tests {
+		lookups.Store(0)
+		c.DNSBLZones = tt.zones
+		start := time.Now()
+		score, reason, err := dnsblSpamCheck(t.Context(), c, commentInput{IP: tt.ip})
+		if score != tt.score || reason != tt.reason || (err != nil) != tt.failed {
+			t.Errorf("%s on %v = %v, %q, %v", tt.ip, tt.zones, score, reason, err)
+		}
+		if n := lookups.Load(); n != tt.lookups {
+			t.Errorf("%s on %v took %d lookups, want %d", tt.ip, tt.zones, n, tt.lookups)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("%s on %v took %v", tt.ip, tt.zones, d)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// A draft is a comment that's still being written, kept in shared state
+// under a random token for draftTTL after it was last saved, so a frontend
+// can save as the visitor types and publish it later, even from another
+// tab or after the connection dropped. Only the token's hash is in the
+// key, and whoever has the token can read and change the draft. Since
+// anybody can save one, drafts are small, short-lived and limited in
+// number, per IP (or IPv6 /64) and per site.
+
+const (
+	// draftTTL is how long a draft is kept after it was last saved.
+	draftTTL = 24 * time.Hour
+	// draftMaxBytes bounds the name, email and comment of a draft together.
+	draftMaxBytes = 16 << 10
+	// draftRateLimit is how many drafts an IP, or an IPv6 /64, may start
+	// in draftRateWindow.
+	draftRateLimit  = 20
+	draftRateWindow = time.Hour
+	// draftMaxEntries is how many drafts may be started on a site in
+	// draftTTL, which bounds how many it keeps at once, and keeps a flood
+	// on one site from stopping drafts on the others.
+	draftMaxEntries = 10000
+)
+
+// commentDraft is a saved draft, as GET /comments/draft returns it.
+type commentDraft struct {
+	Name    string    `json:"name"`
+	Email   string    `json:"email"`
+	Comment string    `json:"comment"`
+	Updated time.Time `json:"updated"`
+	Expires time.Time `json:"expires"`
+}
+
+func draftKey(siteID int, token string) string {
+	return "comment-draft:" + strconv.Itoa(siteID) + ":" + hashToken(token)
+}
+
+// draftHandler saves a draft with POST, creating one unless the form has
+// the token of an existing one, returns it with GET and discards it with
+// DELETE, both with ?token=.
+func draftHandler(w http.ResponseWriter, r *http.Request) {
+	site := siteFor(r)
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		d, err := loadDraft(r.Context(), site.ID, r.URL.Query().Get("token"))
+		if err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(d)
+
+	case http.MethodPost:
+		if !checkCSRF(w, r) || !parseForm(w, r) {
+			return
+		}
+		ctx := r.Context()
+		if err := acceptingComments(ctx, settings(), site, getIP(r)); err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+		token, status := r.PostFormValue("token"), http.StatusOK
+		if token == "" {
+			token, status = randomToken(), http.StatusCreated
+		} else if _, err := loadDraft(ctx, site.ID, token); err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+		if size := len(r.PostFormValue("name")) + len(r.PostFormValue("email")) + len(r.PostFormValue("comment")); size > draftMaxBytes {
+			httpErrorDetails(w, r, http.StatusRequestEntityTooLarge, codeBodyTooLarge,
+				"A draft can't be larger than "+strconv.Itoa(draftMaxBytes)+" bytes", map[string]any{"limit": draftMaxBytes})
+			return
+		}
+		now := time.Now().UTC().Truncate(time.Second)
+		d := commentDraft{
+			Name:    r.PostFormValue("name"),
+			Email:   r.PostFormValue("email"),
+			Comment: r.PostFormValue("comment"),
+			Updated: now,
+			Expires: now.Add(draftTTL),
+		}
+		if d.Name == "" && d.Email == "" && d.Comment == "" {
+			httpErrorDetails(w, r, 400, codeMissingFields, "A draft needs a name, email or comment", map[string]any{"fields": []string{"name", "email", "comment"}})
+			return
+		}
+		if status == http.StatusCreated {
+			err := overRate(ctx, "draft:"+ipNetwork(getIP(r)), draftRateLimit, draftRateWindow)
+			if err == nil {
+				err = overRate(ctx, "draft-site:"+strconv.Itoa(site.ID), draftMaxEntries, draftTTL)
+			}
+			if err != nil {
+				writeAPIError(w, r, err)
+				return
+			}
+		}
+		v, _ := json.Marshal(d)
+		if err := shared.Set(ctx, draftKey(site.ID, token), v, draftTTL); err != nil {
+			internalError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]any{"token": token, "expires": d.Expires})
+
+	case http.MethodDelete:
+		if !checkCSRF(w, r) {
+			return
+		}
+		token := r.URL.Query().Get("token")
+		if _, err := loadDraft(r.Context(), site.ID, token); err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+		if err := shared.Delete(r.Context(), draftKey(site.ID, token)); err != nil {
+			internalError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// loadDraft returns the draft of site with token. Refusals are *apiError.
+func loadDraft(ctx context.Context, siteID int, token string) (*commentDraft, error) {
+	if token == "" {
+		return nil, &apiError{status: 400, code: codeMissingFields, message: "A draft token is required", details: map[string]any{"fields": []string{"token"}}}
+	}
+	v, ok, err := shared.Get(ctx, draftKey(siteID, token))
+	if err != nil {
+		return nil, err
+	}
+	var d commentDraft
+	if !ok || json.Unmarshal(v, &d) != nil {
+		return nil, &apiError{status: http.StatusNotFound, code: codeNotFound, message: "Draft not found, it may have expired"}
+	}
+	return &d, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDrafts(t *testing.T) {
+	defer func(s CommentStore, st SharedState) { store, shared = s, st }(store, shared)
+	store, shared = newMemoryStore(), newMemoryState()
+
+	do := func(method, target string, form url.Values) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		draftHandler(rec, req)
+		return rec
+	}
+
+	rec := do("POST", "/comments/draft", url.Values{"name": {"Ann"}, "comment": {"Dear guestbook,"}})
+	var saved struct {
+		Token   string    `json:"token"`
+		Expires time.Time `json:"expires"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&saved); rec.Code != 201 || err != nil || saved.Token == "" {
+		t.Fatalf("POST = %d, %+v, %v", rec.Code, saved, err)
+	}
+	if time.Until(saved.Expires) < draftTTL-time.Minute {
+		t.Errorf("Draft expires %v", saved.Expires)
+	}
+
+	rec = do("POST", "/comments/draft", url.Values{"token": {saved.Token}, "name": {"Ann"}, "comment": {"Dear guestbook, it was lovely"}})
+	if rec.Code != 200 {
+		t.Fatalf("Saving again = %d %s", rec.Code, rec.Body)
+	}
+	var d commentDraft
+	rec = do("GET", "/comments/draft?token="+url.QueryEscape(saved.Token), nil)
+	if err := json.NewDecoder(rec.Body).Decode(&d); err != nil || d.Name != "Ann" || d.Comment != "Dear guestbook, it was lovely" {
+		t.Fatalf("GET = %d, %+v", rec.Code, d)
+	}
+
+	for _, tc := range []struct {
+		method, target string
+		form           url.Values
+		status         int
+	}{
+		{"GET", "/comments/draft?token=nope", nil, 404},
+		{"GET", "/comments/draft", nil, 400},
+		{"POST", "/comments/draft", url.Values{"token": {"nope"}, "name": {"Ann"}}, 404},
+		{"POST", "/comments/draft", url.Values{}, 400},
+		{"PUT", "/comments/draft", nil, 405},
+	} {
+		if rec := do(tc.method, tc.target, tc.form); rec.Code != tc.status {
+			t.Errorf("%s %s = %d, want %d", tc.method, tc.target, rec.Code, tc.status)
+		}
+	}
+	// drafts belong to their site
+	req := httptest.NewRequest("GET", "/comments/draft?token="+url.QueryEscape(saved.Token), nil)
+	req = req.WithContext(context.WithValue(req.Context(), siteKey{}, &Site{ID: 2, Slug: "other"}))
+	rec = httptest.NewRecorder()
+	draftHandler(rec, req)
+	if rec.Code != 404 {
+		t.Errorf("GET on another site = %d", rec.Code)
+	}
+
+	// publishing fills in what the form leaves out and drops the draft
+	form := url.Values{"draft": {saved.Token}, "email": {"ann@example.com"}}
+	req = httptest.NewRequest("POST", "/comments", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	addComment(rec, req)
+	if rec.Code != 201 {
+		t.Fatalf("Publishing = %d %s", rec.Code, rec.Body)
+	}
+	comments, _ := store.List(t.Context(), CommentQuery{})
+	if len(comments) != 1 || comments[0].Name != "Ann" || comments[0].Text != "Dear guestbook, it was lovely" || comments[0].Email != "ann@example.com" {
+		t.Fatalf("Stored %+v", comments)
+	}
+	if rec := do("GET", "/comments/draft?token="+url.QueryEscape(saved.Token), nil); rec.Code != 404 {
+		t.Errorf("Published draft is still there: %d", rec.Code)
+	}
+
+	rec = do("POST", "/comments/draft", url.Values{"comment": {"Never mind"}})
+	json.NewDecoder(rec.Body).Decode(&saved)
+	if rec := do("DELETE", "/comments/draft?token="+url.QueryEscape(saved.Token), nil); rec.Code != 204 {
+		t.Errorf("DELETE = %d", rec.Code)
+	}
+	if rec := do("DELETE", "/comments/draft?token="+url.QueryEscape(saved.Token), nil); rec.Code != 404 {
+		t.Errorf("Second DELETE = %d", rec.Code)
+	}
+}
+
+func TestDraftLimits(t *testing.T) {
+	defer func(s CommentStore, st SharedState) { store, shared = s, st }(store, shared)
+	store, shared = newMemoryStore(), newMemoryState()
+
+	post := func(ip string, site *Site, form url.Values) int {
+		req := httptest.NewRequest("POST", "/comments/draft", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = ip + ":1234"
+		if site != nil {
+			req = req.WithContext(context.WithValue(req.Context(), siteKey{}, site))
+		}
+		rec := httptest.NewRecorder()
+		draftHandler(rec, req)
+		return rec.Code
+	}
+	hello := url.Values{"comment": {"Hello"}}
+
+	if code := post("203.0.113.1", nil, url.Values{"comment": {strings.Repeat("x", draftMaxBytes+1)}}); code != 413 {
+		t.Errorf("Oversized draft = %d", code)
+	}
+	for i := range draftRateLimit {
+		if code := post("203.0.113.1", nil, hello); code != 201 {
+			t.Fatalf("Draft %d = %d", i+1, code)
+		}
+	}
+	if code := post("203.0.113.1", nil, hello); code != 429 {
+		t.Errorf("Draft beyond the rate limit = %d", code)
+	}
+	if code := post("203.0.113.2", nil, hello); code != 201 {
+		t.Errorf("Draft from another IP = %d", code)
+	}
+	// an IPv6 /64 counts as one client
+	for i := range draftRateLimit {
+		if code := post(fmt.Sprintf("[2001:db8:1:2::%x]", i+1), nil, hello); code != 201 {
+			t.Fatalf("IPv6 draft %d = %d", i+1, code)
+		}
+	}
+	if code := post("[2001:db8:1:2:ffff::1]", nil, hello); code != 429 {
+		t.Errorf("Draft from the same /64 beyond the rate limit = %d", code)
+	}
+	if code := post("[2001:db8:1:3::1]", nil, hello); code != 201 {
+		t.Errorf("Draft from another /64 = %d", code)
+	}
+
+	store.BlockIP(t.Context(), "203.0.113.3", "spam", time.Time{})
+	if code := post("203.0.113.3", nil, hello); code != 403 {
+		t.Errorf("Draft from a blocked IP = %d", code)
+	}
+	closed := &Site{Slug: "closed", ClosedAfter: time.Now().Add(-time.Hour)}
+	if code := post("203.0.113.4", closed, hello); code != 403 {
+		t.Errorf("Draft on a closed site = %d", code)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Emails are rendered from templates under templates/email, or
+// templates_dir/email where it has them: name.txt is the plain text, with
+// the subject in a "subject" template, and name.html, if there is one, the
+// HTML alternative. A translation lives in a directory named after the
+// locale, like email/de/name.txt, and is used for email_locale.
+
+func init() { registerNotifier(emailNotifier{}) }
+
+// emailMessage is the payload of an email in the outbox.
+type emailMessage struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+	HTML    string `json:"html,omitempty"`
+}
+
+// validLocale matches the locales email templates can be translated to.
+var validLocale = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z0-9]{2,8})?$`)
+
+// mailTimeout bounds a delivery to smtp_addr.
+const mailTimeout = 30 * time.Second
+
+// emailFuncs are available to the email templates: local from the pages,
+// and linkify for the HTML ones.
+var emailFuncs = template.FuncMap{"local": templateFuncs["local"]}
+
+// newCommentData is what new_comment is rendered with.
+type newCommentData struct {
+	Site    string
+	Comment *Comment
+	// URL links to the comment, empty without public_url.
+	URL string
+}
+
+// newCommentEmail is the email to notify_email about c, a new comment of
+// site.
+func newCommentEmail(site *Site, c *Comment) (emailMessage, error) {
+	data := newCommentData{Site: cmp.Or(site.Name, site.Slug, "the guestbook"), Comment: c}
+	if config.PublicURL != "" {
+		data.URL = publicPageURL(site) + "#comment-" + strconv.Itoa(c.ID)
+	}
+	return renderEmail(settings(), "new_comment", data)
+}
+
+// emailNotifier emails notify_email about every new comment, unless
+// digest_schedule has them sent in digests instead.
+type emailNotifier struct{}
+
+func (emailNotifier) Name() string { return "email" }
+
+func (emailNotifier) Enabled(c Config) bool { return c.NotifyEmail != "" }
+
+func (emailNotifier) Messages(_ context.Context, c Config, n Notification) ([]OutboxItem, error) {
+	if n.Event != "comment.created" || c.DigestSchedule != "" {
+		return nil, nil
+	}
+	e, err := newCommentEmail(n.Site, n.Comment)
+	if err != nil {
+		return nil, err
+	}
+	return []OutboxItem{{Target: c.NotifyEmail, Payload: e}}, nil
+}
+
+func (emailNotifier) Deliver(ctx context.Context, to string, payload []byte) error {
+	var e emailMessage
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return err
+	}
+	return sendMail(ctx, to, e)
+}
+
+// emailDirs are where to look for a template, in order, for locale: its
+// own directory, the language's without the region, then the default.
+func emailDirs(locale string) []string {
+	var dirs []string
+	if locale != "" {
+		dirs = append(dirs, locale)
+		if lang, _, ok := strings.Cut(locale, "-"); ok {
+			dirs = append(dirs, lang)
+		}
+	}
+	return append(dirs, ".")
+}
+
+// renderEmail renders the email template name in c.EmailLocale with data.
+// The plain text and HTML come from the same place, so a translation or
+// an override without name.html goes out as plain text only.
+func renderEmail(c Config, name string, data any) (emailMessage, error) {
+	base, _ := fs.Sub(templateFiles, "templates/email")
+	sources := []fs.FS{base}
+	if dir := emailOverrideDir(c.TemplatesDir); dir != "" {
+		sources = []fs.FS{os.DirFS(dir), base}
+	}
+	for _, dir := range emailDirs(c.EmailLocale) {
+		txt := path.Join(dir, name+".txt")
+		i := slices.IndexFunc(sources, func(fsys fs.FS) bool {
+			_, err := fs.Stat(fsys, txt)
+			return err == nil
+		})
+		if i < 0 {
+			continue
+		}
+		fsys := sources[i]
+		t, err := template.New(path.Base(txt)).Funcs(emailFuncs).ParseFS(fsys, txt)
+		if err != nil {
+			return emailMessage{}, err
+		}
+		var subject, body strings.Builder
+		if err := t.ExecuteTemplate(&subject, "subject", data); err != nil {
+			return emailMessage{}, err
+		}
+		if err := t.Execute(&body, data); err != nil {
+			return emailMessage{}, err
+		}
+		e := emailMessage{Subject: strings.Join(strings.Fields(subject.String()), " "), Body: body.String()}
+
+		html := path.Join(dir, name+".html")
+		if _, err := fs.Stat(fsys, html); err == nil {
+			t, err := htmltemplate.New(path.Base(html)).Funcs(templateFuncs).ParseFS(fsys, html)
+			if err != nil {
+				return emailMessage{}, err
+			}
+			var b strings.Builder
+			if err := t.Execute(&b, data); err != nil {
+				return emailMessage{}, err
+			}
+			e.HTML = b.String()
+		}
+		return e, nil
+	}
+	return emailMessage{}, fmt.Errorf("no email template %s.txt", name)
+}
+
+// emailOverrideDir is where templates_dir keeps email templates.
+func emailOverrideDir(templatesDir string) string {
+	if templatesDir == "" {
+		return ""
+	}
+	return path.Join(templatesDir, "email")
+}
+
+// parseEmailTemplates checks that every email template parses, for
+// validateConfig.
+func parseEmailTemplates(templatesDir string) error {
+	fsys := withOverrides(templateFiles, "templates/email", emailOverrideDir(templatesDir))
+	return fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		switch path.Ext(name) {
+		case ".txt":
+			_, err = template.New(path.Base(name)).Funcs(emailFuncs).ParseFS(fsys, name)
+		case ".html":
+			_, err = htmltemplate.New(path.Base(name)).Funcs(templateFuncs).ParseFS(fsys, name)
+		}
+		if err != nil {
+			return fmt.Errorf("email/%s: %w", name, err)
+		}
+		return nil
+	})
+}
+
+// sendMail sends e to the address to through smtp_addr, as mail_from,
+// with e.HTML as an alternative to the plain text if it's set.
+func sendMail(ctx context.Context, to string, e emailMessage) error {
+	c := settings()
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\n", c.MailFrom, to, mime.QEncoding.Encode("utf-8", e.Subject), time.Now().Format(time.RFC1123Z))
+	const textPart = "Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n"
+	if e.HTML == "" {
+		msg.WriteString(textPart + crlf(e.Body))
+	} else {
+		b := make([]byte, 12)
+		rand.Read(b)
+		boundary := "guestbook-" + hex.EncodeToString(b)
+		fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+		fmt.Fprintf(&msg, "--%s\r\n%s%s\r\n", boundary, textPart, crlf(e.Body))
+		fmt.Fprintf(&msg, "--%s\r\nContent-Type: text/html; charset=utf-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n%s\r\n", boundary, crlf(e.HTML))
+		fmt.Fprintf(&msg, "--%s--\r\n", boundary)
+	}
+
+	var auth smtp.Auth
+	if c.SMTPUsername != "" {
+		host, _, _ := net.SplitHostPort(c.SMTPAddr)
+		auth = smtp.PlainAuth("", c.SMTPUsername, c.SMTPPassword, host)
+	}
+	_, err := callExternal(ctx, "smtp", mailTimeout, func(context.Context) (struct{}, error) {
+		return struct{}{}, smtp.SendMail(c.SMTPAddr, auth, c.MailFrom, []string{to}, msg.Bytes())
+	})
+	return err
+}
+
+// crlf turns the line endings of s into the CRLF mail needs.
+func crlf(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\n", "\r\n")
+}
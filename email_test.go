@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderEmail(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.PublicURL, config.MultiTenant = "https://guestbook.example", false
+	site := &Site{Slug: "default", Name: "My guestbook"}
+	c := &Comment{ID: 4, Name: "Ann", Email: "ann@example.com", Text: "<b>Hi</b> https://ann.example", Status: "pending"}
+
+	e, err := newCommentEmail(site, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Subject != "New comment on My guestbook from Ann" {
+		t.Errorf("Subject = %q", e.Subject)
+	}
+	if !strings.HasPrefix(e.Body, "Ann <ann@example.com> wrote:\n\n<b>Hi</b>") || !strings.Contains(e.Body, "https://guestbook.example/#comment-4") {
+		t.Errorf("Body = %q", e.Body)
+	}
+	if !strings.Contains(e.HTML, "&lt;b&gt;Hi&lt;/b&gt;") || !strings.Contains(e.HTML, `<a href="https://ann.example"`) {
+		t.Errorf("HTML = %q", e.HTML)
+	}
+
+	for locale, want := range map[string]string{"de": "Neuer Kommentar", "de-AT": "Neuer Kommentar", "fr": "New comment"} {
+		config.EmailLocale = locale
+		if e, _ := newCommentEmail(site, c); !strings.HasPrefix(e.Subject, want) {
+			t.Errorf("Subject in %s = %q, want %s…", locale, e.Subject, want)
+		}
+	}
+
+	// an override without an HTML part goes out as plain text
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "email", "de"), 0o755)
+	os.WriteFile(filepath.Join(dir, "email", "de", "new_comment.txt"), []byte(`{{define "subject"}}  Hallo
+{{.Comment.Name}} {{end}}{{.Comment.Text}}`), 0o644)
+	config.TemplatesDir, config.EmailLocale = dir, "de"
+	if e, err := newCommentEmail(site, c); err != nil || e.Subject != "Hallo Ann" || e.Body != c.Text || e.HTML != "" {
+		t.Errorf("Overridden email = %+v, %v", e, err)
+	}
+
+	if err := parseEmailTemplates(dir); err != nil {
+		t.Errorf("parseEmailTemplates() = %v", err)
+	}
+	os.WriteFile(filepath.Join(dir, "email", "digest.html"), []byte("{{.Total"), 0o644)
+	if err := parseEmailTemplates(dir); err == nil || !strings.Contains(err.Error(), "email/digest.html") {
+		t.Errorf("parseEmailTemplates() with a broken template = %v", err)
+	}
+}
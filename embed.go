@@ -0,0 +1,32 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"regexp"
+	"slices"
+)
+
+var embedThemes = []string{"light", "dark", "auto"}
+
+var validAccent = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// embedHandler serves the guestbook page trimmed down for an iframe at
+// /embed, for sites whose CSP won't run a script widget. theme picks
+// light, dark or auto (following the visitor's system), accent a #rgb or
+// #rrggbb color for links and the button. The page reports its height to
+// the parent with postMessage so the iframe can be sized to fit.
+func embedHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	theme := cmp.Or(q.Get("theme"), "light")
+	if !slices.Contains(embedThemes, theme) {
+		httpError(w, r, 400, codeInvalidTheme, "theme must be light, dark or auto")
+		return
+	}
+	accent := cmp.Or(q.Get("accent"), "#3b6ea5")
+	if !validAccent.MatchString(accent) {
+		httpError(w, r, 400, codeInvalidTheme, "accent must be a color like #3b6ea5")
+		return
+	}
+	serveGuestbookPage(w, r, pageView{Embed: true, Theme: theme, Accent: accent})
+}
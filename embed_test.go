@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestEmbed(t *testing.T) {
+	defer func(s CommentStore) { store = s }(store)
+	store = newMemoryStore()
+	store.Create(t.Context(), &Comment{Name: "Ann", Text: "Framed"})
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+		want   []string
+	}{
+		{"Defaults", "/embed", 200, []string{`class="theme-light"`, "--accent: #3b6ea5;", "Framed", `action="/embed"`}},
+		{"Dark with accent", "/embed?theme=dark&accent=%23c33", 200, []string{`class="theme-dark"`, "--accent: #c33;", `action="/embed?accent=%23c33&amp;theme=dark"`}},
+		{"System theme", "/embed?theme=auto", 200, []string{`class="theme-auto"`}},
+		{"Unknown theme", "/embed?theme=neon", 400, []string{codeInvalidTheme}},
+		{"Accent that isn't a color", "/embed?accent=red", 400, []string{codeInvalidTheme}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			embedHandler(rec, httptest.NewRequest("GET", tt.target, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("Status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(rec.Body.String(), want) {
+					t.Errorf("Response doesn't contain %q:\n%s", want, rec.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestEmbedHeaders(t *testing.T) {
+	defer func(s CommentStore) { store = s }(store)
+	store = newMemoryStore()
+
+	rec := httptest.NewRecorder()
+	embedHandler(rec, httptest.NewRequest("GET", "/embed", nil))
+	csp := rec.Header().Get("Content-Security-Policy")
+	nonce := regexp.MustCompile(`<script nonce="([^"]+)">`).FindStringSubmatch(rec.Body.String())
+	if nonce == nil || !strings.Contains(csp, "script-src 'nonce-"+nonce[1]+"'") {
+		t.Errorf("CSP %q doesn't allow the resize script", csp)
+	}
+	if !strings.Contains(csp, "frame-ancestors *") || rec.Header().Get("X-Frame-Options") != "" {
+		t.Errorf("Embed can't be framed: CSP %q", csp)
+	}
+
+	// a site that lists its origins is only framed by them
+	req := httptest.NewRequest("GET", "/embed", nil)
+	site := &Site{Slug: "blog", Moderation: "approved", AllowedOrigins: []string{"https://blog.example.com"}}
+	req = req.WithContext(context.WithValue(req.Context(), siteKey{}, site))
+	rec = httptest.NewRecorder()
+	embedHandler(rec, req)
+	if csp := rec.Header().Get("Content-Security-Policy"); !strings.HasSuffix(csp, "frame-ancestors https://blog.example.com") {
+		t.Errorf("CSP = %q", csp)
+	}
+
+	rec = postForm(embedHandler, "/embed?theme=dark", url.Values{"name": {"Bo"}, "email": {"bo@example.com"}, "comment": {"Hi"}})
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/embed?posted=1&theme=dark#comments" {
+		t.Errorf("POST = %d to %q", rec.Code, rec.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"io"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var jsonBufPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 16<<10)
+		return &b
+	},
+}
+
+// writeCommentsJSON writes comments the same way json.NewEncoder(w).Encode
+// would, using a pooled buffer so the hot listing path doesn't allocate.
+func writeCommentsJSON(w io.Writer, comments []Comment) error {
+	bp := jsonBufPool.Get().(*[]byte)
+	buf := append(appendCommentsJSON((*bp)[:0], comments), '\n')
+	_, err := w.Write(buf)
+
+	// Don't let one huge /all response pin its buffer forever
+	if cap(buf) <= 1<<20 {
+		*bp = buf
+		jsonBufPool.Put(bp)
+	}
+	return err
+}
+
+// appendCommentsJSON is a hand-rolled replacement for json.Marshal on the
+// listing endpoints, which spent most of their time in reflection. The
+// output is byte-for-byte what encoding/json produces for []Comment,
+// including its HTML-safe escaping, so clients can't tell the difference.
+// (Invalid UTF-8 becomes a literal U+FFFD, which Go before 1.25 wrote as
+// an escape instead; both decode the same.)
+// Keep it in sync with the Comment struct; TestAppendCommentsJSON compares
+// the two encoders.
+func appendCommentsJSON(b []byte, comments []Comment) []byte {
+	if comments == nil {
+		return append(b, "null"...)
+	}
+	b = append(b, '[')
+	for i := range comments {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = appendCommentJSON(b, &comments[i])
+	}
+	return append(b, ']')
+}
+
+func appendCommentJSON(b []byte, c *Comment) []byte {
+	b = append(b, `{"id":`...)
+	b = strconv.AppendInt(b, int64(c.ID), 10)
+	b = append(b, `,"name":`...)
+	b = appendJSONString(b, c.Name)
+	if c.Email != "" {
+		b = append(b, `,"email":`...)
+		b = appendJSONString(b, c.Email)
+	}
+	b = append(b, `,"text":`...)
+	b = appendJSONString(b, c.Text)
+	if c.IP != "" {
+		b = append(b, `,"ip":`...)
+		b = appendJSONString(b, c.IP)
+	}
+	b = append(b, `,"location":`...)
+	b = appendJSONString(b, c.Location)
+	b = append(b, `,"likes":`...)
+	b = strconv.AppendInt(b, int64(c.Likes), 10)
+	b = append(b, `,"created":"`...)
+	b = c.Created.AppendFormat(b, time.RFC3339Nano)
+	b = append(b, '"')
+	if c.ParentID != 0 {
+		b = append(b, `,"parent_id":`...)
+		b = strconv.AppendInt(b, int64(c.ParentID), 10)
+	}
+	if c.Website != "" {
+		b = append(b, `,"website":`...)
+		b = appendJSONString(b, c.Website)
+	}
+	if c.Authenticated {
+		b = append(b, `,"authenticated":true`...)
+	}
+	if c.Avatar != "" {
+		b = append(b, `,"avatar":`...)
+		b = appendJSONString(b, c.Avatar)
+	}
+	if c.Provider != "" {
+		b = append(b, `,"provider":`...)
+		b = appendJSONString(b, c.Provider)
+	}
+	if c.Image != "" {
+		b = append(b, `,"image":`...)
+		b = appendJSONString(b, c.Image)
+	}
+	if c.Returning {
+		b = append(b, `,"returning":true`...)
+	}
+	if c.Verified {
+		b = append(b, `,"verified":true`...)
+	}
+	return append(b, '}')
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString escapes s the way encoding/json does with HTML escaping
+// enabled.
+func appendJSONString(b []byte, s string) []byte {
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+				i++
+				continue
+			}
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\b':
+				b = append(b, '\\', 'b')
+			case '\f':
+				b = append(b, '\\', 'f')
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b = append(b, s[start:i]...)
+			b = utf8.AppendRune(b, utf8.RuneError)
+			i += size
+			start = i
+			continue
+		}
+		// U+2028 and U+2029 are valid JSON but break JavaScript string literals
+		if r == '\u2028' || r == '\u2029' {
+			b = append(b, s[start:i]...)
+			b = append(b, '\\', 'u', '2', '0', '2', hexDigits[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	b = append(b, s[start:]...)
+	return append(b, '"')
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestAppendCommentsJSON(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 30, 45, 123000000, time.UTC)
+	tests := []struct {
+		name     string
+		comments []Comment
+	}{
+		{"Nil slice", nil},
+		{"Empty slice", []Comment{}},
+		{"Plain", []Comment{{ID: 1, Name: "Alice", Email: "alice@example.com", Text: "Hello", IP: "1.2.3.4", Location: "Localhost", Likes: 3, Created: created}}},
+		{"Escaping", []Comment{{ID: 2, Name: `"Bob" \\ <b>&</b>`, Text: "line\nbreak\ttab\r\b\f\x00\x1f", Created: created}}},
+		{"Unicode", []Comment{{ID: 3, Name: "Zoë 🎉", Text: "sep\u2028\u2029 ok", Created: created}}},
+		{"Zero time", []Comment{{ID: 4}}},
+		{"Zone offset", []Comment{{ID: 5, Created: created.In(time.FixedZone("X", 5*3600+1800))}}},
+		{"Reply", []Comment{{ID: 6, ParentID: 5, Created: created}}},
+		{"Signed in", []Comment{{ID: 7, Website: "https://ana.example/?a=1&b=2", Authenticated: true, Avatar: "https://avatars.example/ana.png", Provider: "github", Created: created}}},
+		{"Several", []Comment{{ID: 1, Created: created}, {ID: 2, Created: created}, {ID: 3, Created: created}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := json.Marshal(tt.comments)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := appendCommentsJSON(nil, tt.comments)
+			if !bytes.Equal(got, want) {
+				t.Errorf("appendCommentsJSON mismatch\n got: %s\nwant: %s", got, want)
+			}
+		})
+	}
+}
+
+func TestAppendJSONStringInvalidUTF8(t *testing.T) {
+	got := appendJSONString(nil, "bad\xff\xfe utf8")
+
+	var decoded string
+	if err := json.Unmarshal(got, &decoded); err != nil {
+		t.Fatalf("Invalid JSON %s: %v", got, err)
+	}
+	if decoded != "bad\ufffd\ufffd utf8" {
+		t.Errorf("Expected replacement characters, got %q", decoded)
+	}
+}
+
+func benchmarkComments(n int) []Comment {
+	comments := make([]Comment, n)
+	for i := range comments {
+		comments[i] = Comment{
+			ID:       i + 1,
+			Name:     fmt.Sprintf("Visitor %d", i),
+			Email:    fmt.Sprintf("visitor%d@example.com", i),
+			Text:     "Lovely site! Greetings from <somewhere> & thanks for all the \"fish\".",
+			IP:       "203.0.113.42",
+			Location: "Unknown Location",
+			Likes:    i % 7,
+			Created:  time.Date(2024, 5, 1, 12, 0, i%60, 0, time.UTC),
+		}
+	}
+	return comments
+}
+
+func BenchmarkEncodeCommentsReflect(b *testing.B) {
+	comments := benchmarkComments(15)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		json.NewEncoder(io.Discard).Encode(comments)
+	}
+}
+
+func BenchmarkEncodeCommentsFast(b *testing.B) {
+	comments := benchmarkComments(15)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		writeCommentsJSON(io.Discard, comments)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Error codes returned in the X-Error-Code header and the body of every
+// error response. They are part of the API, see "Error codes" in the README,
+// so never change the meaning of an existing one.
+const (
+	codeMethodNotAllowed      = "method_not_allowed"
+	codeInvalidJSON           = "invalid_json"
+	codeInvalidComment        = "invalid_comment"
+	codeInvalidForm           = "invalid_form"
+	codeBodyTooLarge          = "body_too_large"
+	codeInvalidImage          = "invalid_image"
+	codeImageTooLarge         = "image_too_large"
+	codeMissingFields         = "missing_fields"
+	codeConsentRequired       = "consent_required"
+	codeInvalidFilter         = "invalid_filter"
+	codeInvalidSort           = "invalid_sort"
+	codeInvalidQuery          = "invalid_query"
+	codeInvalidLimit          = "invalid_limit"
+	codeInvalidID             = "invalid_id"
+	codeInvalidStatus         = "invalid_status"
+	codeInvalidFormat         = "invalid_format"
+	codeInvalidTheme          = "invalid_theme"
+	codeInvalidIdempotencyKey = "invalid_idempotency_key"
+	codeInvalidWebmention     = "invalid_webmention"
+	codeInvalidConfig         = "invalid_config"
+	codeSiteRequired          = "site_required"
+	codeUnknownSite           = "unknown_site"
+	codeSiteArchived          = "site_archived"
+	codeCommentsClosed        = "comments_closed"
+	codeSpamRejected          = "spam_rejected"
+	codeTooManyLinks          = "too_many_links"
+	codeLinkNotAllowed        = "link_not_allowed"
+	codeInvalidSite           = "invalid_site"
+	codeUnauthorized          = "unauthorized"
+	codeAdminOnly             = "admin_only"
+	codeForbidden             = "forbidden"
+	codeCSRFFailed            = "csrf_failed"
+	codeInvalidNonce          = "invalid_nonce"
+	codeSignInFailed          = "sign_in_failed"
+	codeSignInRequired        = "sign_in_required"
+	codeInvalidLink           = "invalid_link"
+	codeDuplicateID           = "duplicate_id"
+	codeNotFound              = "not_found"
+	codeNotSupported          = "not_supported"
+	codeOverloaded            = "overloaded"
+	codeRateLimited           = "rate_limited"
+	codeInternal              = "internal_error"
+)
+
+// errorResponse is the body of an error from the versioned API.
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Code      string         `json:"code"`
+	Message   string         `json:"message"`
+	Details   map[string]any `json:"details,omitempty"`
+	RequestID string         `json:"request_id,omitempty"`
+}
+
+// httpError writes an error and logs it so the failure can be matched to
+// what the client saw.
+func httpError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	httpErrorDetails(w, r, status, code, message, nil)
+}
+
+// httpErrorDetails is httpError with machine-readable details, like the
+// fields that are missing, for clients of the versioned API.
+func httpErrorDetails(w http.ResponseWriter, r *http.Request, status int, code, message string, details map[string]any) {
+	writeError(w, r, status, code, message, details)
+
+	level := slog.LevelInfo
+	if status >= 500 {
+		level = slog.LevelError
+	}
+	requestLogger(r).Log(r.Context(), level, "error response", "status", status, "code", code, "message", message)
+}
+
+// internalError answers 500 without telling the client what went wrong,
+// which could be a database error naming tables and columns. The error
+// itself is logged with the request ID the client gets.
+func internalError(w http.ResponseWriter, r *http.Request, err error) {
+	writeError(w, r, http.StatusInternalServerError, codeInternal, "Internal server error", nil)
+	requestLogger(r).Error("error response", "status", http.StatusInternalServerError, "code", codeInternal, "error", err)
+}
+
+// writeError sends errors from /api/v1 as a JSON errorResponse. Everything
+// else, including the deprecated unversioned paths, keeps the plain-text
+// "<code>: <message> (request <id>)". Both set X-Error-Code.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string, details map[string]any) {
+	rid := requestID(r)
+	w.Header().Set("X-Error-Code", code)
+
+	if !strings.HasPrefix(r.URL.Path, apiPrefix+"/") {
+		body := code + ": " + message
+		if rid != "" {
+			body += " (request " + rid + ")"
+		}
+		http.Error(w, body, status)
+		return
+	}
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{errorBody{Code: code, Message: message, Details: details, RequestID: rid}})
+}
+
+// apiError is a request refused by logic the HTTP API shares with gRPC,
+// carrying what the HTTP API answers. grpcError maps it to a gRPC status.
+type apiError struct {
+	status  int
+	code    string
+	message string
+	details map[string]any
+}
+
+func (e *apiError) Error() string { return e.message }
+
+// writeAPIError answers err as httpErrorDetails if it's an *apiError and
+// as internalError otherwise.
+func writeAPIError(w http.ResponseWriter, r *http.Request, err error) {
+	var ae *apiError
+	if errors.As(err, &ae) {
+		httpErrorDetails(w, r, ae.status, ae.code, ae.message, ae.details)
+		return
+	}
+	internalError(w, r, err)
+}
+
+// parseForm parses the request body as form data, URL-encoded or
+// multipart, answering 400 or 413 (see withBodyLimit) itself when that
+// fails.
+func parseForm(w http.ResponseWriter, r *http.Request) bool {
+	err := r.ParseForm()
+	if err == nil && strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		err = r.ParseMultipartForm(mediaMultipartMemory)
+	}
+	if err == nil {
+		return true
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		httpErrorDetails(w, r, http.StatusRequestEntityTooLarge, codeBodyTooLarge,
+			"Request body is larger than "+strconv.FormatInt(tooLarge.Limit, 10)+" bytes", map[string]any{"limit": tooLarge.Limit})
+	} else {
+		httpError(w, r, 400, codeInvalidForm, "Invalid form data")
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPError(t *testing.T) {
+	testLogFile.Truncate(0)
+	testLogFile.Seek(0, 0)
+
+	var reqID string
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqID = requestID(r)
+		httpError(w, r, 400, codeMissingFields, "All fields (name, email, comment) are required")
+	}))
+
+	req := httptest.NewRequest("POST", "/comments", nil)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if len(reqID) != 16 {
+		t.Fatalf("Expected a 16 character request ID, got %q", reqID)
+	}
+	if recorder.Code != 400 {
+		t.Errorf("Expected status 400, got %d", recorder.Code)
+	}
+	if got := recorder.Header().Get("X-Error-Code"); got != codeMissingFields {
+		t.Errorf("X-Error-Code = %q, want %q", got, codeMissingFields)
+	}
+	body := recorder.Body.String()
+	if !strings.HasPrefix(body, "missing_fields: ") || !strings.Contains(body, reqID) {
+		t.Errorf("Unexpected error body %q", body)
+	}
+
+	testLogFile.Seek(0, 0)
+	content, err := io.ReadAll(testLogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range []string{reqID, "code=missing_fields", "status=400"} {
+		if !strings.Contains(string(content), part) {
+			t.Errorf("Log does not contain %q: %q", part, content)
+		}
+	}
+}
+
+func TestRequestIDOutsideMiddleware(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	if id := requestID(req); id != "" {
+		t.Errorf("Expected no request ID, got %q", id)
+	}
+
+	recorder := httptest.NewRecorder()
+	httpError(recorder, req, 404, codeNotFound, "Comment not found")
+	if body := strings.TrimSpace(recorder.Body.String()); body != "not_found: Comment not found" {
+		t.Errorf("Unexpected error body %q", body)
+	}
+}
+
+func TestHTTPErrorJSON(t *testing.T) {
+	var reqID string
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqID = requestID(r)
+		httpErrorDetails(w, r, 400, codeMissingFields, "All fields (name, email, comment) are required", map[string]any{"fields": []string{"email"}})
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/comments", nil))
+
+	if rec.Code != 400 || rec.Header().Get("Content-Type") != "application/json" || rec.Header().Get("X-Error-Code") != codeMissingFields {
+		t.Fatalf("Status %d, headers %v", rec.Code, rec.Header())
+	}
+	want := `{"error":{"code":"missing_fields","message":"All fields (name, email, comment) are required","details":{"fields":["email"]},"request_id":"` + reqID + `"}}`
+	if body := strings.TrimSpace(rec.Body.String()); body != want {
+		t.Errorf("Body = %s\nwant %s", body, want)
+	}
+}
+
+func TestInternalError(t *testing.T) {
+	testLogFile.Truncate(0)
+	testLogFile.Seek(0, 0)
+
+	for _, path := range []string{"/comments", "/api/v1/comments"} {
+		rec := httptest.NewRecorder()
+		internalError(rec, httptest.NewRequest("GET", path, nil), errors.New("no such table: comments"))
+		if rec.Code != 500 || !strings.Contains(rec.Body.String(), "Internal server error") || strings.Contains(rec.Body.String(), "no such table") {
+			t.Errorf("GET %s = %d %q", path, rec.Code, rec.Body.String())
+		}
+	}
+
+	testLogFile.Seek(0, 0)
+	content, _ := io.ReadAll(testLogFile)
+	if !strings.Contains(string(content), "no such table: comments") {
+		t.Errorf("Log doesn't contain the error: %q", content)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// commentEvent is a change to a comment, as feedStore saw it go through.
+type commentEvent struct {
+	// Type is "created", "updated" or "deleted".
+	Type    string
+	Comment Comment
+	// WasApproved tells whether an updated comment was approved before.
+	WasApproved bool
+}
+
+// commentFeed hands comment events to the live endpoints of this process.
+// Other instances' writes don't come through it; listeners find those by
+// watching recentGeneration.
+type commentFeed struct {
+	mu   sync.Mutex
+	subs map[*feedSub]bool
+}
+
+// feedSub receives the events of one site. Events that don't fit in its
+// buffer are dropped and lagged is set, so the listener can catch up from
+// the store instead of holding up every write.
+type feedSub struct {
+	siteID int
+	events chan commentEvent
+	lagged atomic.Bool
+}
+
+var feed = &commentFeed{subs: map[*feedSub]bool{}}
+
+// allSites subscribes to the events of every site.
+const allSites = -1
+
+func (f *commentFeed) subscribe(siteID int) *feedSub {
+	s := &feedSub{siteID: siteID, events: make(chan commentEvent, 32)}
+	f.mu.Lock()
+	f.subs[s] = true
+	f.mu.Unlock()
+	return s
+}
+
+func (f *commentFeed) unsubscribe(s *feedSub) {
+	f.mu.Lock()
+	delete(f.subs, s)
+	f.mu.Unlock()
+}
+
+func (f *commentFeed) publish(e commentEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for s := range f.subs {
+		if s.siteID != allSites && s.siteID != e.Comment.SiteID {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			s.lagged.Store(true)
+		}
+	}
+}
+
+// feedStore publishes every successful write of comments to feed.
+type feedStore struct {
+	CommentStore
+}
+
+func (s feedStore) Create(ctx context.Context, c *Comment) error {
+	if err := s.CommentStore.Create(ctx, c); err != nil {
+		return err
+	}
+	feed.publish(commentEvent{Type: "created", Comment: *c})
+	return nil
+}
+
+func (s feedStore) CreateMany(ctx context.Context, comments []*Comment) error {
+	if err := s.CommentStore.CreateMany(ctx, comments); err != nil {
+		return err
+	}
+	for _, c := range comments {
+		feed.publish(commentEvent{Type: "created", Comment: *c})
+	}
+	return nil
+}
+
+func (s feedStore) Update(ctx context.Context, c *Comment) error {
+	before, _ := s.CommentStore.Get(ctx, c.SiteID, c.ID)
+	if err := s.CommentStore.Update(ctx, c); err != nil {
+		return err
+	}
+	feed.publish(commentEvent{Type: "updated", Comment: *c, WasApproved: before != nil && before.Status == "approved"})
+	return nil
+}
+
+func (s feedStore) Delete(ctx context.Context, siteID, id int) error {
+	before, _ := s.CommentStore.Get(ctx, siteID, id)
+	if err := s.CommentStore.Delete(ctx, siteID, id); err != nil {
+		return err
+	}
+	if before != nil {
+		feed.publish(commentEvent{Type: "deleted", Comment: *before})
+	}
+	return nil
+}
+
+// liveComment is what the live endpoints, /events, /ws and
+// /comments/poll, send of a comment: what the guestbook page shows, so no
+// email or IP.
+type liveComment struct {
+	ID       int       `json:"id"`
+	Name     string    `json:"name"`
+	Text     string    `json:"text"`
+	Location string    `json:"location"`
+	Likes    int       `json:"likes"`
+	Created  time.Time `json:"created"`
+	ParentID int       `json:"parent_id,omitempty"`
+
+	Website       string `json:"website,omitempty"`
+	Authenticated bool   `json:"authenticated,omitempty"`
+	Avatar        string `json:"avatar,omitempty"`
+	Image         string `json:"image,omitempty"`
+	Returning     bool   `json:"returning,omitempty"`
+	Verified      bool   `json:"verified,omitempty"`
+}
+
+func newLiveComment(c Comment) liveComment {
+	return liveComment{ID: c.ID, Name: c.Name, Text: c.Text, Location: c.Location, Likes: c.Likes, Created: c.Created, ParentID: c.ParentID, Website: c.Website, Authenticated: c.Authenticated, Avatar: c.Avatar, Image: c.Image, Returning: c.Returning, Verified: c.Verified}
+}
+
+// eventsKeepalive is how often an idle stream gets a comment line, which
+// keeps proxies from closing it, and checks for comments written by other
+// instances.
+var eventsKeepalive = 15 * time.Second
+
+// eventsRetry is the reconnection delay suggested to browsers, in
+// milliseconds.
+const eventsRetry = 5000
+
+// eventsCatchUpBatch is how many comments a resuming stream reads at once.
+const eventsCatchUpBatch = 100
+
+// eventsHandler streams newly approved comments of a site as Server-Sent
+// Events at /events. Each "comment" event carries a liveComment; its id is
+// the highest comment ID sent so far, so a client that reconnects with
+// Last-Event-ID, or ?last_event_id= on its first connection, gets the
+// comments posted in between. A comment approved by a moderator after it
+// was posted is only sent live, since its ID is older than the stream's.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	last := 0
+	if lastID != "" {
+		n, err := strconv.Atoi(lastID)
+		if err != nil || n < 0 {
+			httpError(w, r, 400, codeInvalidID, "Last-Event-ID must be a comment id")
+			return
+		}
+		last = n
+	}
+
+	ctx := r.Context()
+	site := siteFor(r)
+	sub := feed.subscribe(site.ID)
+	defer feed.unsubscribe(sub)
+
+	gen, err := recentGeneration(ctx, site.ID)
+	if err != nil {
+		requestLogger(r).Warn("reading the cache generation failed", "error", err)
+	}
+	if lastID == "" {
+		v, err := store.Version(ctx, CommentQuery{SiteID: site.ID, Status: "approved"})
+		if err != nil {
+			internalError(w, r, err)
+			return
+		}
+		last = v.MaxID
+	}
+
+	// the stream outlives read_timeout and write_timeout, so every write
+	// gets its own deadline; behind a wrapper that can't do that the stream
+	// ends at write_timeout and the browser reconnects
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	writeTimeout := seconds(settings().WriteTimeout, 60)
+	write := func(format string, args ...any) error {
+		rc.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	send := func(c Comment) error {
+		data, err := json.Marshal(newLiveComment(c))
+		if err != nil {
+			return err
+		}
+		last = max(last, c.ID)
+		return write("id: %d\nevent: comment\ndata: %s\n\n", last, data)
+	}
+	catchUp := func() error {
+		for {
+			comments, err := store.List(ctx, CommentQuery{SiteID: site.ID, Status: "approved", AfterID: last, Sort: "oldest", Limit: eventsCatchUpBatch})
+			if err != nil {
+				requestLogger(r).Error("reading new comments failed", "error", err)
+				return err
+			}
+			for _, c := range comments {
+				if err := send(c); err != nil {
+					return err
+				}
+			}
+			if len(comments) < eventsCatchUpBatch {
+				return nil
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	// nginx buffers responses unless told otherwise
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	err = write("retry: %d\n\n", eventsRetry)
+	if err == nil && lastID != "" {
+		err = catchUp()
+	}
+
+	ticker := time.NewTicker(eventsKeepalive)
+	defer ticker.Stop()
+	for err == nil {
+		select {
+		case <-ctx.Done():
+			return
+		case <-shuttingDown:
+			return
+		case e := <-sub.events:
+			c := e.Comment
+			if c.Status != "approved" {
+				continue
+			}
+			if (e.Type == "created" && c.ID > last) || (e.Type == "updated" && !e.WasApproved) {
+				err = send(c)
+			}
+		case <-ticker.C:
+			g, genErr := recentGeneration(ctx, site.ID)
+			if sub.lagged.Swap(false) || (genErr == nil && g != gen) {
+				gen = g
+				err = catchUp()
+			}
+			if err == nil {
+				err = write(": keepalive\n\n")
+			}
+		}
+	}
+	if !errors.Is(err, context.Canceled) {
+		requestLogger(r).Debug("event stream ended", "error", err)
+	}
+}
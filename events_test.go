@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFeedStore(t *testing.T) {
+	ctx := context.Background()
+	s := feedStore{newMemoryStore()}
+	sub := feed.subscribe(0)
+	defer feed.unsubscribe(sub)
+	other := feed.subscribe(7)
+	defer feed.unsubscribe(other)
+
+	c := &Comment{Name: "Alice", Email: "a@example.com", Text: "Hi", Status: "pending"}
+	s.Create(ctx, c)
+	c.Status = "approved"
+	s.Update(ctx, c)
+	s.Delete(ctx, 0, c.ID)
+	s.Delete(ctx, 0, c.ID)
+
+	want := []commentEvent{
+		{Type: "created"},
+		{Type: "updated", WasApproved: false},
+		{Type: "deleted"},
+	}
+	for _, w := range want {
+		select {
+		case e := <-sub.events:
+			if e.Type != w.Type || e.WasApproved != w.WasApproved || e.Comment.ID != c.ID {
+				t.Errorf("Event %+v, want %+v", e, w)
+			}
+		default:
+			t.Fatalf("No %s event", w.Type)
+		}
+	}
+	select {
+	case e := <-sub.events:
+		t.Errorf("Extra event %+v, deleting a missing comment", e)
+	case e := <-other.events:
+		t.Errorf("Event %+v reached another site", e)
+	default:
+	}
+
+	for range cap(sub.events) + 1 {
+		s.Create(ctx, &Comment{Name: "Bob", Email: "b@example.com", Text: "Hi"})
+	}
+	if !sub.lagged.Load() {
+		t.Error("Full buffer didn't mark the subscriber lagged")
+	}
+}
+
+type sseEvent struct {
+	id, event string
+	comment   liveComment
+}
+
+// readEvent returns the next event of an SSE stream, skipping comments
+// and the retry field.
+func readEvent(t *testing.T, r *bufio.Reader) sseEvent {
+	t.Helper()
+	done := make(chan sseEvent, 1)
+	go func() {
+		var e sseEvent
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				close(done)
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "" && e.event != "":
+				done <- e
+				return
+			case strings.HasPrefix(line, "id: "):
+				e.id = line[4:]
+			case strings.HasPrefix(line, "event: "):
+				e.event = line[7:]
+			case strings.HasPrefix(line, "data: "):
+				json.Unmarshal([]byte(line[6:]), &e.comment)
+			}
+		}
+	}()
+	select {
+	case e, ok := <-done:
+		if !ok {
+			t.Fatal("Stream ended")
+		}
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("No event")
+	}
+	return sseEvent{}
+}
+
+func openEvents(t *testing.T, url, lastID string) *bufio.Reader {
+	t.Helper()
+	req, _ := http.NewRequest("GET", url, nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("GET /events = %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+func TestEventsHandler(t *testing.T) {
+	defer func(s CommentStore) { store = s }(store)
+	defer func(d time.Duration) { eventsKeepalive = d }(eventsKeepalive)
+	ctx := context.Background()
+	mem := newMemoryStore()
+	store = feedStore{cachingStore{mem}}
+	eventsKeepalive = 50 * time.Millisecond
+
+	alice := &Comment{Name: "Alice", Email: "a@example.com", IP: "1.1.1.1", Text: "First"}
+	bob := &Comment{Name: "Bob", Email: "b@example.com", Text: "Held", Status: "pending"}
+	store.Create(ctx, alice)
+	store.Create(ctx, bob)
+
+	srv := httptest.NewServer(http.HandlerFunc(eventsHandler))
+	defer srv.Close()
+	defer srv.CloseClientConnections()
+
+	resumed := openEvents(t, srv.URL, "0")
+	if e := readEvent(t, resumed); e.event != "comment" || e.id != strconv.Itoa(alice.ID) || e.comment.Name != "Alice" {
+		t.Errorf("Catching up sent %+v", e)
+	}
+
+	live := openEvents(t, srv.URL, "")
+	carol := &Comment{Name: "Carol", Email: "c@example.com", Text: "Live"}
+	store.Create(ctx, carol)
+	for _, stream := range []*bufio.Reader{resumed, live} {
+		if e := readEvent(t, stream); e.id != strconv.Itoa(carol.ID) || e.comment.Text != "Live" {
+			t.Errorf("New comment sent as %+v", e)
+		}
+	}
+
+	// approving an older comment doesn't move the stream back
+	bob.Status = "approved"
+	store.Update(ctx, bob)
+	if e := readEvent(t, live); e.comment.ID != bob.ID || e.id != strconv.Itoa(carol.ID) {
+		t.Errorf("Approved comment sent as %+v", e)
+	}
+
+	// another instance wrote to the database and bumped the generation
+	dave := &Comment{Name: "Dave", Email: "d@example.com", Text: "Elsewhere"}
+	mem.Create(ctx, dave)
+	invalidateRecent(ctx, 0)
+	if e := readEvent(t, live); e.comment.ID != dave.ID {
+		t.Errorf("Comment from another instance sent as %+v", e)
+	}
+
+	raw, _ := json.Marshal(readEventRaw(t, srv.URL))
+	if strings.Contains(string(raw), "a@example.com") || strings.Contains(string(raw), "1.1.1.1") {
+		t.Errorf("Event leaks the email or IP: %s", raw)
+	}
+}
+
+// readEventRaw returns the data of the first event after ID 0.
+func readEventRaw(t *testing.T, url string) map[string]any {
+	t.Helper()
+	r := openEvents(t, url+"?last_event_id=0", "")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			var v map[string]any
+			json.Unmarshal([]byte(data), &v)
+			return v
+		}
+	}
+}
+
+func TestEventsHandlerErrors(t *testing.T) {
+	tests := []struct {
+		method, lastID string
+		status         int
+	}{
+		{"POST", "", 405},
+		{"GET", "abc", 400},
+		{"GET", "-1", 400},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/events", nil)
+		req.Header.Set("Last-Event-ID", tt.lastID)
+		rec := httptest.NewRecorder()
+		eventsHandler(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s with Last-Event-ID %q = %d, want %d", tt.method, tt.lastID, rec.Code, tt.status)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+var exportColumns = []string{"id", "parent_id", "site_id", "name", "email", "text", "ip", "location", "likes", "created", "status", "consent_version", "updated", "website", "authenticated", "avatar", "provider", "image", "returning", "verified", "spam_score", "spam_reasons"}
+
+// exportHandler streams every comment of the site, whatever its status and
+// archived or not, oldest first as ?format=csv, json (the default) or xml.
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	exporter, ok := exporters[format]
+	if !ok {
+		httpError(w, r, 400, codeInvalidFormat, "format must be one of csv, json or xml")
+		return
+	}
+	write, finish := exporter(w)
+
+	types := map[string]string{"csv": "text/csv; charset=utf-8", "json": "application/json", "xml": "application/xml"}
+	w.Header().Set("Content-Type", types[format])
+	w.Header().Set("Content-Disposition", `attachment; filename="guestbook-`+time.Now().UTC().Format("20060102")+`.`+format+`"`)
+
+	// the status is sent with the first byte, so a failure half way can
+	// only be logged and the export left truncated
+	n := 0
+	err := eachExported(r.Context(), siteFor(r).ID, func(c Comment) error {
+		n++
+		return write(c)
+	})
+	if err == nil {
+		err = finish()
+	}
+	if err != nil {
+		requestLogger(r).Error("export failed", "format", format, "exported", n, "error", err)
+		return
+	}
+	requestLogger(r).Info("comments exported", "format", format, "count", n)
+}
+
+// eachExported calls fn with every comment of site, oldest first: the
+// archived ones, which are older than all live ones, and then the live
+// ones.
+func eachExported(ctx context.Context, siteID int, fn func(Comment) error) error {
+	for _, archived := range []bool{true, false} {
+		if err := store.Each(ctx, CommentQuery{SiteID: siteID, Sort: "oldest", Archived: archived}, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exporters start writing an export to w. They return a function writing
+// one comment and one finishing the document.
+var exporters = map[string]func(w io.Writer) (func(Comment) error, func() error){
+	"csv":  exportCSV,
+	"json": exportJSON,
+	"xml":  exportXML,
+}
+
+// runExport implements the export subcommand, the same export as
+// /admin/export:
+//
+//	guestbook export [-site slug] [-format csv|json|xml] [-o file]
+func runExport(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	slug := fs.String("site", "", "site to export (default: the single-tenant guestbook)")
+	format := fs.String("format", "json", "csv, json or xml")
+	path := fs.String("o", "", "file to write (default: standard output)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("usage: guestbook export [-site slug] [-format csv|json|xml] [-o file]")
+	}
+	exporter, ok := exporters[*format]
+	if !ok {
+		return fmt.Errorf("unknown export format %q", *format)
+	}
+	ctx := context.Background()
+	site, err := cliSite(ctx, *slug)
+	if err != nil {
+		return err
+	}
+
+	w := out
+	var f *os.File
+	if *path != "" {
+		if f, err = os.Create(*path); err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	write, finish := exporter(bw)
+	n := 0
+	err = eachExported(ctx, site.ID, func(c Comment) error {
+		n++
+		return write(c)
+	})
+	if err == nil {
+		err = finish()
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil && f != nil {
+		err = f.Close()
+	}
+	if err != nil {
+		return err
+	}
+	if f != nil {
+		fmt.Fprintf(out, "Exported %d comments to %s\n", n, *path)
+	}
+	return nil
+}
+
+func exportCSV(w io.Writer) (func(Comment) error, func() error) {
+	cw := csv.NewWriter(w)
+	cw.Write(exportColumns)
+	write := func(c Comment) error {
+		return cw.Write([]string{
+			strconv.Itoa(c.ID), strconv.Itoa(c.ParentID), strconv.Itoa(c.SiteID), c.Name, c.Email, c.Text, c.IP, c.Location,
+			strconv.Itoa(c.Likes), c.Created.UTC().Format(time.RFC3339), c.Status, c.ConsentVersion, c.Updated.UTC().Format(time.RFC3339Nano),
+			c.Website, strconv.FormatBool(c.Authenticated), c.Avatar, c.Provider, c.Image, strconv.FormatBool(c.Returning), strconv.FormatBool(c.Verified),
+			strconv.FormatFloat(c.SpamScore, 'f', -1, 64), c.SpamReasons,
+		})
+	}
+	finish := func() error {
+		cw.Flush()
+		return cw.Error()
+	}
+	return write, finish
+}
+
+// exportJSON writes an array one comment at a time instead of encoding a
+// slice of the whole dataset.
+func exportJSON(w io.Writer) (func(Comment) error, func() error) {
+	enc := json.NewEncoder(w)
+	first := true
+	write := func(c Comment) error {
+		sep := ","
+		if first {
+			sep, first = "[", false
+		}
+		if _, err := w.Write([]byte(sep)); err != nil {
+			return err
+		}
+		return enc.Encode(commentRecord(c))
+	}
+	finish := func() error {
+		end := "]\n"
+		if first {
+			end = "[]\n"
+		}
+		_, err := w.Write([]byte(end))
+		return err
+	}
+	return write, finish
+}
+
+func exportXML(w io.Writer) (func(Comment) error, func() error) {
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	root := xml.StartElement{Name: xml.Name{Local: "comments"}}
+	started := enc.EncodeToken(root)
+	write := func(c Comment) error {
+		if started != nil {
+			return started
+		}
+		return enc.EncodeElement(commentRecord(c), xml.StartElement{Name: xml.Name{Local: "comment"}})
+	}
+	finish := func() error {
+		if err := enc.EncodeToken(root.End()); err != nil {
+			return err
+		}
+		if err := enc.Flush(); err != nil {
+			return err
+		}
+		_, err := w.Write([]byte("\n"))
+		return err
+	}
+	return write, finish
+}
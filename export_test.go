@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportHandler(t *testing.T) {
+	defer func(s CommentStore) { store = s }(store)
+	store = newMemoryStore()
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, c := range []*Comment{
+		{Name: "Ann", Email: "ann@example.com", Text: "First, \"quoted\"\nline", IP: "1.1.1.1", Created: created},
+		{Name: "Bob", Email: "bob@example.com", Text: "<b>spam</b>", IP: "2.2.2.2", Status: "spam", ConsentVersion: "v1", Created: created.Add(time.Hour)},
+		{SiteID: 3, Name: "Other", Text: "Other site", Created: created},
+		{Name: "Old", Email: "old@example.com", Text: "Archived", Created: created.AddDate(-5, 0, 0)},
+	} {
+		if err := store.Create(t.Context(), c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := store.Archive(t.Context(), 0, created.AddDate(-1, 0, 0)); n != 1 || err != nil {
+		t.Fatalf("Archive() = %d, %v", n, err)
+	}
+
+	tests := []struct {
+		format string
+		decode func(body string) ([]commentRecord, error)
+	}{
+		{"json", func(body string) (recs []commentRecord, err error) {
+			err = json.Unmarshal([]byte(body), &recs)
+			return
+		}},
+		{"xml", func(body string) ([]commentRecord, error) {
+			var doc struct {
+				Comments []commentRecord `xml:"comment"`
+			}
+			err := xml.Unmarshal([]byte(body), &doc)
+			return doc.Comments, err
+		}},
+		{"csv", func(body string) ([]commentRecord, error) {
+			rows, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+			if err != nil || strings.Join(rows[0], ",") != strings.Join(exportColumns, ",") {
+				return nil, err
+			}
+			var recs []commentRecord
+			for _, row := range rows[1:] {
+				c, _ := time.Parse(time.RFC3339, row[9])
+				recs = append(recs, commentRecord{Name: row[3], Email: row[4], Text: row[5], IP: row[6], Created: c, Status: row[10], ConsentVersion: row[11]})
+			}
+			return recs, nil
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			exportHandler(rec, httptest.NewRequest("GET", "/admin/export?format="+tt.format, nil))
+			if rec.Code != 200 {
+				t.Fatalf("GET /admin/export = %d: %s", rec.Code, rec.Body)
+			}
+			if d := rec.Header().Get("Content-Disposition"); !strings.HasSuffix(d, "."+tt.format+`"`) {
+				t.Errorf("Content-Disposition = %q", d)
+			}
+			recs, err := tt.decode(rec.Body.String())
+			if err != nil {
+				t.Fatalf("Decoding %s: %v\n%s", tt.format, err, rec.Body)
+			}
+			if len(recs) != 3 {
+				t.Fatalf("Exported %d comments, want 3: %+v", len(recs), recs)
+			}
+			if recs[0].Text != "Archived" {
+				t.Errorf("Archived comment = %+v", recs[0])
+			}
+			if recs[1].Text != "First, \"quoted\"\nline" || !recs[1].Created.Equal(created) {
+				t.Errorf("First comment = %+v", recs[1])
+			}
+			if recs[2].Status != "spam" || recs[2].ConsentVersion != "v1" || recs[2].IP != "2.2.2.2" {
+				t.Errorf("Moderation fields = %+v", recs[2])
+			}
+		})
+	}
+}
+
+func TestExportEmptyAndInvalid(t *testing.T) {
+	defer func(s CommentStore) { store = s }(store)
+	store = newMemoryStore()
+
+	rec := httptest.NewRecorder()
+	exportHandler(rec, httptest.NewRequest("GET", "/admin/export", nil))
+	if rec.Code != 200 || rec.Body.String() != "[]\n" {
+		t.Errorf("Empty export = %d %q", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	exportHandler(rec, httptest.NewRequest("GET", "/admin/export?format=yaml", nil))
+	if rec.Code != 400 || rec.Header().Get("X-Error-Code") != codeInvalidFormat {
+		t.Errorf("format=yaml = %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestRunExport(t *testing.T) {
+	defer func(s CommentStore) { store = s }(store)
+	store = newMemoryStore()
+	for _, text := range []string{"First", "Second"} {
+		if err := store.Create(t.Context(), &Comment{Name: "Ann", Text: text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := runExport([]string{"-format", "csv"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil || len(rows) != 3 || rows[1][5] != "First" {
+		t.Errorf("CSV export = %q, %v", rows, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "comments.json")
+	out.Reset()
+	if err := runExport([]string{"-o", path}, &out); err != nil || out.String() != "Exported 2 comments to "+path+"\n" {
+		t.Fatalf("Export to a file = %q, %v", out.String(), err)
+	}
+	var recs []commentRecord
+	if b, err := os.ReadFile(path); err != nil || json.Unmarshal(b, &recs) != nil || len(recs) != 2 {
+		t.Errorf("Exported file: %s, %v", b, err)
+	}
+	if err := runExport([]string{"-format", "yaml"}, &out); err == nil {
+		t.Error("Export as yaml succeeded")
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var errAdminOnly = errors.New("Filtering by ip or email requires admin access")
+
+// commentQuery translates the ?since=, ?until=, ?name=, ?email=, ?ip= and
+// ?sort= query parameters into a CommentQuery. Only approved comments of
+// the request's site are ever listed.
+func commentQuery(r *http.Request) (CommentQuery, error) {
+	q := r.URL.Query()
+	cq := CommentQuery{
+		SiteID: siteFor(r).ID,
+		Status: "approved",
+		Name:   q.Get("name"),
+		Email:  q.Get("email"),
+		IP:     q.Get("ip"),
+	}
+
+	if (cq.IP != "" || cq.Email != "") && !isAdmin(r) {
+		return cq, errAdminOnly
+	}
+
+	if v := q.Get("since"); v != "" {
+		t, _, err := parseFilterTime(v)
+		if err != nil {
+			return cq, fmt.Errorf("Invalid since: use RFC 3339 or YYYY-MM-DD")
+		}
+		cq.Since = t
+	}
+	if v := q.Get("until"); v != "" {
+		t, dateOnly, err := parseFilterTime(v)
+		if err != nil {
+			return cq, fmt.Errorf("Invalid until: use RFC 3339 or YYYY-MM-DD")
+		}
+		if dateOnly {
+			// until=2024-05-01 includes the whole day, up to the next one
+			cq.Before = t.AddDate(0, 0, 1)
+		} else {
+			cq.Until = t
+		}
+	}
+	return cq, nil
+}
+
+func parseFilterTime(v string) (t time.Time, dateOnly bool, err error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, false, nil
+	}
+	t, err = time.Parse("2006-01-02", v)
+	return t, true, err
+}
+
+var errInvalidSort = errors.New("sort must be one of newest, oldest or popular")
+
+// commentSort checks ?sort=newest|oldest|popular, newest first being the
+// default.
+func commentSort(r *http.Request) (string, error) {
+	return checkSort(r.URL.Query().Get("sort"))
+}
+
+// checkSort validates a sort order, empty meaning newest.
+func checkSort(s string) (string, error) {
+	switch s {
+	case "", "newest":
+		return "newest", nil
+	case "oldest", "popular":
+		return s, nil
+	}
+	return "", errInvalidSort
+}
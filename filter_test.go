@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCommentFilter(t *testing.T) {
+	needSQLite(t)
+	defer func(c Config) { config = c }(config)
+	config.AdminToken = "secret"
+
+	_, err := db.Exec("DELETE FROM comments")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testComments := []struct {
+		name    string
+		email   string
+		ip      string
+		created string
+	}{
+		{"Alice", "alice@example.com", "1.2.3.4", "2024-01-10 12:00:00"},
+		{"Bob", "bob@example.com", "5.6.7.8", "2024-02-10 12:00:00"},
+		{"alice", "alice@example.com", "1.2.3.4", "2024-03-10 12:00:00"},
+	}
+	for _, c := range testComments {
+		_, err := db.Exec("INSERT INTO comments (name, email, text, ip, location, created) VALUES (?, ?, ?, ?, ?, ?)",
+			c.name, c.email, "Hi", c.ip, "Test Location", c.created)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		admin          bool
+		expectedStatus int
+		expected       int
+	}{
+		{"No filter", "", false, 200, 3},
+		{"Since", "?since=2024-02-01", false, 200, 2},
+		{"Until is inclusive", "?until=2024-02-10", false, 200, 2},
+		{"Since and until RFC 3339", "?since=2024-02-10T11:00:00Z&until=2024-02-10T13:00:00Z", false, 200, 1},
+		{"Name ignores case", "?name=ALICE", false, 200, 2},
+		{"Invalid date", "?since=yesterday", false, 400, 0},
+		{"IP requires admin", "?ip=1.2.3.4", false, 403, 0},
+		{"Email requires admin", "?email=bob@example.com", false, 403, 0},
+		{"IP as admin", "?ip=1.2.3.4", true, 200, 2},
+		{"Email and since as admin", "?email=alice@example.com&since=2024-03-01", true, 200, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/all"+tt.query, nil)
+			if tt.admin {
+				req.Header.Set("Authorization", "Bearer secret")
+			}
+			recorder := httptest.NewRecorder()
+
+			allCommentsHandler(recorder, req)
+
+			if recorder.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+			if tt.expectedStatus != 200 {
+				return
+			}
+
+			var comments []Comment
+			if err := json.NewDecoder(recorder.Body).Decode(&comments); err != nil {
+				t.Fatal(err)
+			}
+			if len(comments) != tt.expected {
+				t.Errorf("Expected %d comments, got %d", tt.expected, len(comments))
+			}
+		})
+	}
+}
+
+func TestCommentOrder(t *testing.T) {
+	needSQLite(t)
+	_, err := db.Exec("DELETE FROM comments")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testComments := []struct {
+		name    string
+		likes   int
+		created string
+	}{
+		{"First", 5, "2024-01-10 12:00:00"},
+		{"Second", 0, "2024-02-10 12:00:00"},
+		{"Third", 9, "2024-03-10 12:00:00"},
+	}
+	for _, c := range testComments {
+		_, err := db.Exec("INSERT INTO comments (name, email, text, ip, location, likes, created) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			c.name, "test@example.com", "Hi", "1.2.3.4", "Test Location", c.likes, c.created)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		sort           string
+		expectedStatus int
+		expected       []string
+	}{
+		{"", 200, []string{"Third", "Second", "First"}},
+		{"newest", 200, []string{"Third", "Second", "First"}},
+		{"oldest", 200, []string{"First", "Second", "Third"}},
+		{"popular", 200, []string{"Third", "First", "Second"}},
+		{"random", 400, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run("sort="+tt.sort, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/comments?sort="+tt.sort, nil)
+			recorder := httptest.NewRecorder()
+
+			commentsHandler(recorder, req)
+
+			if recorder.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+			if tt.expectedStatus != 200 {
+				return
+			}
+
+			var comments []Comment
+			if err := json.NewDecoder(recorder.Body).Decode(&comments); err != nil {
+				t.Fatal(err)
+			}
+			if len(comments) != len(tt.expected) {
+				t.Fatalf("Expected %d comments, got %d", len(tt.expected), len(comments))
+			}
+			for i, name := range tt.expected {
+				if comments[i].Name != name {
+					t.Errorf("Position %d: expected %s, got %s", i, name, comments[i].Name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// In csrf_mode "signed" the CSRF token is a form token: the time it was
+// issued and an HMAC over that time and the client's fingerprint, its IP
+// and User-Agent, keyed with form_token_secret. Checking one needs nothing
+// but the secret, so any replica can check a token another issued, and no
+// cookie is involved, so it works in embeds too. A token only works for
+// the client it was issued to, for form_token_max_age seconds, and once:
+// its MAC is kept in shared state until it expires, so a replay finds it.
+
+// formTokenSkew is how far in the future a token may have been issued, for
+// replicas whose clocks disagree a little.
+const formTokenSkew = time.Minute
+
+// formToken issues a token for the client of r at now.
+func formToken(cfg Config, r *http.Request, now time.Time) string {
+	issued := strconv.FormatInt(now.Unix(), 10)
+	return issued + "." + formTokenMAC(cfg, r, issued)
+}
+
+func formTokenMAC(cfg Config, r *http.Request, issued string) string {
+	mac := hmac.New(sha256.New, []byte(cfg.FormTokenSecret))
+	mac.Write([]byte("form-token\n" + issued + "\n" + getIP(r) + "\n" + r.UserAgent()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyFormToken checks that token was issued to the client of r within
+// form_token_max_age of now.
+func verifyFormToken(cfg Config, r *http.Request, token string, now time.Time) error {
+	issued, sig, ok := strings.Cut(token, ".")
+	unix, err := strconv.ParseInt(issued, 10, 64)
+	if !ok || err != nil {
+		return errors.New("malformed form token")
+	}
+	if !hmac.Equal([]byte(sig), []byte(formTokenMAC(cfg, r, issued))) {
+		return errors.New("form token of another client or secret")
+	}
+	at := time.Unix(unix, 0)
+	if at.After(now.Add(formTokenSkew)) {
+		return errors.New("form token issued in the future")
+	}
+	if now.Sub(at) > time.Duration(cfg.FormTokenMaxAge)*time.Second {
+		return errors.New("form token expired")
+	}
+	return nil
+}
+
+// errFormTokenUsed refuses a form token that was already used.
+var errFormTokenUsed = errors.New("form token used already")
+
+// claimFormToken marks token, which verifyFormToken accepted, as used. Only
+// one of several requests with the same token succeeds, however many
+// replicas they reach, since SetNX decides.
+func claimFormToken(ctx context.Context, cfg Config, token string, now time.Time) error {
+	issued, sig, _ := strings.Cut(token, ".")
+	unix, _ := strconv.ParseInt(issued, 10, 64)
+	expires := time.Unix(unix, 0).Add(time.Duration(cfg.FormTokenMaxAge) * time.Second)
+	claimed, err := shared.SetNX(ctx, "form-token:"+sig, []byte("1"), expires.Sub(now)+formTokenSkew)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return errFormTokenUsed
+	}
+	return nil
+}
+
+// formTokenHandler hands form tokens to script frontends in csrf_mode
+// "signed", with the time they stop working.
+func formTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	cfg := settings()
+	if cfg.CSRFMode != "signed" {
+		httpError(w, r, http.StatusNotImplemented, codeNotSupported, `Form tokens need csrf_mode "signed"`)
+		return
+	}
+	now := time.Now()
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"token":   formToken(cfg, r, now),
+		"expires": now.Add(time.Duration(cfg.FormTokenMaxAge) * time.Second).UTC().Truncate(time.Second),
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormTokens(t *testing.T) {
+	defer func(c Config, s CommentStore, st SharedState) { config, store, shared = c, s, st }(config, store, shared)
+	store, shared = newMemoryStore(), newMemoryState()
+	config.CSRFMode, config.FormTokenSecret, config.FormTokenMaxAge = "signed", strings.Repeat("s", 32), 3600
+
+	rec := httptest.NewRecorder()
+	formTokenHandler(rec, httptest.NewRequest("GET", "/form-token", nil))
+	var body struct {
+		Token   string    `json:"token"`
+		Expires time.Time `json:"expires"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || rec.Code != 200 || body.Token == "" {
+		t.Fatalf("GET /form-token = %d, %+v, %v", rec.Code, body, err)
+	}
+	if len(rec.Result().Cookies()) != 0 {
+		t.Error("A form token set a cookie")
+	}
+	if d := time.Until(body.Expires); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("Token expires in %v", d)
+	}
+	issued, _, _ := strings.Cut(body.Token, ".")
+	now := time.Now()
+
+	tests := []struct {
+		name, token, header, ip, agent string
+		want                           int
+	}{
+		{"Form field", body.Token, "", "", "", 201},
+		{"Replayed", body.Token, "", "", "", 403},
+		{"Replayed in the header", "", body.Token, "", "", 403},
+		{"Header", "", formToken(config, httptest.NewRequest("GET", "/", nil), now.Add(-time.Second)), "", "", 201},
+		{"No token", "", "", "", "", 403},
+		{"Another IP", body.Token, "", "198.51.100.7:1234", "", 403},
+		{"Another browser", body.Token, "", "", "curl/8.0", 403},
+		{"Tampered", body.Token + "x", "", "", "", 403},
+		{"Other time", "1" + body.Token, "", "", "", 403},
+		{"Not a token", issued, "", "", "", 403},
+		{"Expired", formToken(config, httptest.NewRequest("GET", "/", nil), now.Add(-2*time.Hour)), "", "", "", 403},
+		{"From the future", formToken(config, httptest.NewRequest("GET", "/", nil), now.Add(time.Hour)), "", "", "", 403},
+		{"Within the skew", formToken(config, httptest.NewRequest("GET", "/", nil), now.Add(30*time.Second)), "", "", "", 201},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"name": {"Ann"}, "email": {"ann@example.com"}, "comment": {"Hello " + tt.name}}
+			if tt.token != "" {
+				form.Set("csrf_token", tt.token)
+			}
+			req := httptest.NewRequest("POST", "/comments", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.header != "" {
+				req.Header.Set("X-CSRF-Token", tt.header)
+			}
+			if tt.ip != "" {
+				req.RemoteAddr = tt.ip
+			}
+			if tt.agent != "" {
+				req.Header.Set("User-Agent", tt.agent)
+			}
+			rec := httptest.NewRecorder()
+			commentsHandler(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("%d: POST = %d %s", i, rec.Code, rec.Body)
+			}
+			if tt.want == 403 && rec.Header().Get("X-Error-Code") != codeCSRFFailed {
+				t.Errorf("Error code %q", rec.Header().Get("X-Error-Code"))
+			}
+		})
+	}
+
+	// saving a draft doesn't use the token up, and a double-clicked button
+	// gets the first answer
+	config.DuplicateWindow = 60
+	token := formToken(config, httptest.NewRequest("GET", "/", nil), now.Add(-time.Minute))
+	form := url.Values{"csrf_token": {token}, "name": {"Ann"}, "email": {"ann@example.com"}, "comment": {"Hello twice"}}
+	post := func(h http.HandlerFunc, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec
+	}
+	for _, want := range []struct {
+		h      http.HandlerFunc
+		target string
+		status int
+	}{{draftHandler, "/comments/draft", 201}, {draftHandler, "/comments/draft", 201}, {commentsHandler, "/comments", 201}, {commentsHandler, "/comments", 200}} {
+		if rec := post(want.h, want.target); rec.Code != want.status {
+			t.Errorf("POST %s = %d %s, want %d", want.target, rec.Code, rec.Body, want.status)
+		}
+	}
+	form.Set("comment", "Hello three times")
+	if rec := post(commentsHandler, "/comments"); rec.Code != 403 {
+		t.Errorf("New comment with a used token = %d", rec.Code)
+	}
+
+	// the page's form carries one
+	rec = httptest.NewRecorder()
+	guestbookPageHandler(rec, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(rec.Body.String(), `name="csrf_token" value="`+issued[:6]) {
+		t.Error("The page's form has no form token")
+	}
+
+	config.CSRFMode = "cookie"
+	rec = httptest.NewRecorder()
+	formTokenHandler(rec, httptest.NewRequest("GET", "/form-token", nil))
+	if rec.Code != 501 {
+		t.Errorf("GET /form-token in cookie mode = %d", rec.Code)
+	}
+}
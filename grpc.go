@@ -0,0 +1,328 @@
+package main
+
+//go:generate protoc -I guestbookpb --go_out=guestbookpb --go_opt=paths=source_relative --go-grpc_out=guestbookpb --go-grpc_opt=paths=source_relative guestbookpb/guestbook.proto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"guestbook/guestbookpb"
+)
+
+// grpcService implements GuestbookService from guestbookpb/guestbook.proto
+// with the same checks as the HTTP API.
+type grpcService struct {
+	guestbookpb.UnimplementedGuestbookServiceServer
+}
+
+// newGRPCServer returns the server for grpc_port. Reflection is on so
+// tools like grpcurl work without the .proto.
+func newGRPCServer() *grpc.Server {
+	maxMsg := config.MaxBodyBytes
+	if maxMsg <= 0 {
+		maxMsg = 64 << 10
+	}
+	srv := grpc.NewServer(grpc.MaxRecvMsgSize(maxMsg), grpc.ChainUnaryInterceptor(grpcLogging))
+	guestbookpb.RegisterGuestbookServiceServer(srv, grpcService{})
+	reflection.Register(srv)
+	return srv
+}
+
+// runGRPCServer serves on ln until ctx is cancelled, then lets calls in
+// flight finish for up to shutdown_timeout like runServer does.
+func runGRPCServer(ctx context.Context, srv *grpc.Server, ln net.Listener) error {
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(shutdownTimeout()):
+		srv.Stop()
+	}
+	if err := <-errc; !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	return nil
+}
+
+type grpcLoggerKey struct{}
+
+// grpcLogging logs one entry per call like withLogging, under a request ID
+// taken from x-request-id metadata or made up and sent back in the same
+// header. net/http survives a panicking handler but gRPC doesn't, so it
+// also turns panics into Internal errors.
+func grpcLogging(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	id := firstMetadata(ctx, "x-request-id")
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs("x-request-id", id))
+	addr := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	log := logger.With("request_id", id, "peer", addr, "method", info.FullMethod)
+	ctx = context.WithValue(ctx, grpcLoggerKey{}, log)
+
+	start := time.Now()
+	defer func() {
+		if p := recover(); p != nil {
+			log.Error("panic in gRPC handler", "panic", p, "stack", string(debug.Stack()))
+			err = status.Error(codes.Internal, "Internal server error")
+		}
+		log.Info("grpc request", "code", status.Code(err).String(), "duration", time.Since(start))
+	}()
+	return handler(ctx, req)
+}
+
+// grpcLogger is the logger grpcLogging set up for the call.
+func grpcLogger(ctx context.Context) *slog.Logger {
+	if log, ok := ctx.Value(grpcLoggerKey{}).(*slog.Logger); ok {
+		return log
+	}
+	return logger
+}
+
+func firstMetadata(ctx context.Context, key string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func grpcIsAdmin(ctx context.Context) bool {
+	return authorizationRole(ctx, firstMetadata(ctx, "authorization")) == roleAdmin
+}
+
+// grpcSite resolves a call's site like requireSite, from x-api-key
+// metadata or the request's site slug. Archived sites refuse writes from
+// anyone but admins.
+func grpcSite(ctx context.Context, slug string, write bool) (*Site, error) {
+	if !config.MultiTenant {
+		return defaultSite, nil
+	}
+	key := firstMetadata(ctx, "x-api-key")
+	if key == "" && slug == "" {
+		return nil, &apiError{status: 400, code: codeSiteRequired, message: "x-api-key metadata or a site is required"}
+	}
+	site, err := lookupSite(ctx, key, slug)
+	if err == errUnknownSite {
+		return nil, &apiError{status: http.StatusNotFound, code: codeUnknownSite, message: err.Error()}
+	} else if err != nil {
+		return nil, err
+	}
+	if site.Archived && write && !grpcIsAdmin(ctx) {
+		return nil, &apiError{status: http.StatusGone, code: codeSiteArchived, message: "This guestbook is archived and read-only"}
+	}
+	return site, nil
+}
+
+// grpcCodes maps the HTTP statuses of apiErrors to gRPC codes.
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:            codes.InvalidArgument,
+	http.StatusUnauthorized:          codes.Unauthenticated,
+	http.StatusForbidden:             codes.PermissionDenied,
+	http.StatusNotFound:              codes.NotFound,
+	http.StatusConflict:              codes.AlreadyExists,
+	http.StatusGone:                  codes.FailedPrecondition,
+	http.StatusRequestEntityTooLarge: codes.ResourceExhausted,
+	http.StatusTooManyRequests:       codes.ResourceExhausted,
+}
+
+// grpcError turns err into a status. An *apiError keeps its message, and
+// its error code goes into an ErrorInfo as the reason, with the details as
+// metadata. Anything else is logged and answered like internalError.
+func grpcError(ctx context.Context, err error) error {
+	ae, ok := err.(*apiError)
+	if !ok {
+		grpcLogger(ctx).Error("error response", "code", codeInternal, "error", err)
+		return status.Error(codes.Internal, "Internal server error")
+	}
+	code, ok := grpcCodes[ae.status]
+	if !ok {
+		code = codes.Unknown
+	}
+	grpcLogger(ctx).Info("error response", "code", ae.code, "message", ae.message)
+
+	info := &errdetails.ErrorInfo{Reason: ae.code, Domain: "guestbook"}
+	if len(ae.details) > 0 {
+		info.Metadata = map[string]string{}
+	}
+	for k, v := range ae.details {
+		if list, ok := v.([]string); ok {
+			info.Metadata[k] = strings.Join(list, ",")
+		} else {
+			info.Metadata[k] = fmt.Sprint(v)
+		}
+	}
+	st, detailErr := status.New(code, ae.message).WithDetails(info)
+	if detailErr != nil {
+		return status.Error(code, ae.message)
+	}
+	return st.Err()
+}
+
+var grpcStatuses = map[guestbookpb.CommentStatus]string{
+	guestbookpb.CommentStatus_COMMENT_STATUS_APPROVED: "approved",
+	guestbookpb.CommentStatus_COMMENT_STATUS_PENDING:  "pending",
+	guestbookpb.CommentStatus_COMMENT_STATUS_SPAM:     "spam",
+}
+
+// commentProto converts c, with the email and IP only for admins, as the
+// HTTP listings do.
+func commentProto(c *Comment, admin bool) *guestbookpb.Comment {
+	pc := &guestbookpb.Comment{
+		Id:       int64(c.ID),
+		Name:     c.Name,
+		Text:     c.Text,
+		Location: c.Location,
+		Likes:    int32(c.Likes),
+		Created:  timestamppb.New(c.Created),
+		ParentId: int64(c.ParentID),
+	}
+	for s, name := range grpcStatuses {
+		if name == c.Status {
+			pc.Status = s
+		}
+	}
+	if admin {
+		pc.Email, pc.Ip = c.Email, c.IP
+	}
+	return pc
+}
+
+// grpcPageSize is the page size of ListComments without one, as for GET
+// /comments.
+const grpcPageSize = 15
+
+func (grpcService) ListComments(ctx context.Context, req *guestbookpb.ListCommentsRequest) (*guestbookpb.ListCommentsResponse, error) {
+	site, err := grpcSite(ctx, req.Site, false)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	admin := grpcIsAdmin(ctx)
+
+	q := CommentQuery{SiteID: site.ID, Status: "approved"}
+	if req.Status != guestbookpb.CommentStatus_COMMENT_STATUS_UNSPECIFIED {
+		s, ok := grpcStatuses[req.Status]
+		if !ok {
+			return nil, grpcError(ctx, &apiError{status: 400, code: codeInvalidStatus, message: "Unknown status"})
+		}
+		if s != "approved" && !admin {
+			return nil, grpcError(ctx, &apiError{status: http.StatusForbidden, code: codeAdminOnly, message: "Listing comments that aren't approved requires admin access"})
+		}
+		q.Status = s
+	}
+	if q.Sort, err = checkSort(req.Sort); err != nil {
+		return nil, grpcError(ctx, &apiError{status: 400, code: codeInvalidSort, message: err.Error()})
+	}
+	if req.Since != nil {
+		q.Since = req.Since.AsTime()
+	}
+	if req.Until != nil {
+		q.Until = req.Until.AsTime()
+	}
+
+	size := int(req.PageSize)
+	if size == 0 {
+		size = grpcPageSize
+	}
+	if size < 0 || size > 100 {
+		return nil, grpcError(ctx, &apiError{status: 400, code: codeInvalidLimit, message: "page_size must be between 1 and 100"})
+	}
+	offset := 0
+	if req.PageToken != "" {
+		// the token is the offset, opaque to clients so that can change
+		if offset, err = strconv.Atoi(req.PageToken); err != nil || offset < 0 {
+			return nil, grpcError(ctx, &apiError{status: 400, code: codeInvalidFilter, message: "Invalid page_token"})
+		}
+	}
+
+	total, err := store.Count(ctx, q)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	q.Limit, q.Offset = size, offset
+	comments, err := store.List(ctx, q)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+
+	resp := &guestbookpb.ListCommentsResponse{TotalSize: int32(total)}
+	for i := range comments {
+		resp.Comments = append(resp.Comments, commentProto(&comments[i], admin))
+	}
+	if next := offset + len(comments); len(comments) == size && next < total {
+		resp.NextPageToken = strconv.Itoa(next)
+	}
+	return resp, nil
+}
+
+func (grpcService) CreateComment(ctx context.Context, req *guestbookpb.CreateCommentRequest) (*guestbookpb.CreateCommentResponse, error) {
+	site, err := grpcSite(ctx, req.Site, true)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	ip := req.Ip
+	if ip == "" {
+		// a client calling on its own behalf
+		if p, ok := peer.FromContext(ctx); ok {
+			ip, _, _ = net.SplitHostPort(p.Addr.String())
+		}
+	}
+	c, dup, err := submitComment(ctx, grpcLogger(ctx), site, commentInput{
+		Name:    req.Name,
+		Email:   req.Email,
+		Text:    req.Text,
+		IP:      ip,
+		Consent: req.Consent,
+	})
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &guestbookpb.CreateCommentResponse{Comment: commentProto(c, grpcIsAdmin(ctx)), Duplicate: dup}, nil
+}
+
+func (grpcService) ModerateComment(ctx context.Context, req *guestbookpb.ModerateCommentRequest) (*guestbookpb.ModerateCommentResponse, error) {
+	if !grpcIsAdmin(ctx) {
+		return nil, grpcError(ctx, &apiError{status: http.StatusUnauthorized, code: codeUnauthorized, message: "Admin token required"})
+	}
+	site, err := grpcSite(ctx, req.Site, true)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	c, err := moderateComment(ctx, site.ID, int(req.Id), grpcStatuses[req.Status])
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &guestbookpb.ModerateCommentResponse{Comment: commentProto(c, true)}, nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"guestbook/guestbookpb"
+)
+
+// grpcTestClient serves newGRPCServer in memory for the length of the test.
+func grpcTestClient(t *testing.T) guestbookpb.GuestbookServiceClient {
+	t.Helper()
+	ln := bufconn.Listen(1 << 20)
+	srv := newGRPCServer()
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return guestbookpb.NewGuestbookServiceClient(conn)
+}
+
+func TestGRPCCreateComment(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	defer func(s CommentStore) { store = s }(store)
+	config.DuplicateWindow = 60
+	store = newMemoryStore()
+	client := grpcTestClient(t)
+	ctx := context.Background()
+
+	req := &guestbookpb.CreateCommentRequest{Name: "Alice", Email: "alice@example.com", Text: "Hi", Ip: "203.0.113.5"}
+	resp, err := client.CreateComment(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := resp.Comment
+	if c.Id == 0 || c.Name != "Alice" || c.Status != guestbookpb.CommentStatus_COMMENT_STATUS_APPROVED || resp.Duplicate {
+		t.Errorf("Created %v", resp)
+	}
+	if c.Email != "" || c.Ip != "" {
+		t.Errorf("Email %q and IP %q sent without the admin token", c.Email, c.Ip)
+	}
+	stored, err := store.Get(ctx, 0, int(c.Id))
+	if err != nil || stored.IP != "203.0.113.5" || stored.Email != "alice@example.com" {
+		t.Errorf("Stored %+v, %v", stored, err)
+	}
+
+	again, err := client.CreateComment(ctx, req)
+	if err != nil || !again.Duplicate || again.Comment.Id != c.Id {
+		t.Errorf("Duplicate = %v, %v", again, err)
+	}
+
+	_, err = client.CreateComment(ctx, &guestbookpb.CreateCommentRequest{Text: "Hi"})
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument || len(st.Details()) != 1 {
+		t.Fatalf("Missing fields = %v", err)
+	}
+	info, ok := st.Details()[0].(*errdetails.ErrorInfo)
+	if !ok || info.Reason != codeMissingFields || info.Metadata["fields"] != "name,email" {
+		t.Errorf("Details %v", st.Details())
+	}
+
+	store.BlockIP(ctx, "198.51.100.7", "test", time.Time{})
+	_, err = client.CreateComment(ctx, &guestbookpb.CreateCommentRequest{Name: "Bob", Email: "b@example.com", Text: "Hi", Ip: "198.51.100.7"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Blocked IP = %v", err)
+	}
+}
+
+func TestGRPCListComments(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	defer func(s CommentStore) { store = s }(store)
+	config.AdminToken = "secret"
+	store = newMemoryStore()
+	client := grpcTestClient(t)
+	ctx := context.Background()
+	admin := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i, name := range []string{"Alice", "Bob", "Carol", "Dave", "Eve"} {
+		s := "approved"
+		if name == "Eve" {
+			s = "pending"
+		}
+		c := &Comment{Name: name, Email: name + "@example.com", Text: "Hi", IP: "203.0.113.5", Status: s, Created: base.Add(time.Duration(i) * time.Hour)}
+		if err := store.Create(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var names []string
+	token := ""
+	for page := 0; ; page++ {
+		resp, err := client.ListComments(ctx, &guestbookpb.ListCommentsRequest{PageSize: 3, PageToken: token})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.TotalSize != 4 {
+			t.Errorf("TotalSize = %d, want 4", resp.TotalSize)
+		}
+		for _, c := range resp.Comments {
+			names = append(names, c.Name)
+			if c.Email != "" {
+				t.Errorf("Email of %s sent without the admin token", c.Name)
+			}
+		}
+		if token = resp.NextPageToken; token == "" {
+			break
+		}
+		if page > 2 {
+			t.Fatal("Paging doesn't end")
+		}
+	}
+	if got := len(names); got != 4 || names[0] != "Dave" || names[3] != "Alice" {
+		t.Errorf("Listed %v, want Dave to Alice", names)
+	}
+
+	resp, err := client.ListComments(ctx, &guestbookpb.ListCommentsRequest{Sort: "oldest", Until: timestamppb.New(base.Add(90 * time.Minute))})
+	if err != nil || len(resp.Comments) != 2 || resp.Comments[0].Name != "Alice" {
+		t.Errorf("Oldest until 13:30 = %v, %v", resp, err)
+	}
+
+	pending := &guestbookpb.ListCommentsRequest{Status: guestbookpb.CommentStatus_COMMENT_STATUS_PENDING}
+	if _, err := client.ListComments(ctx, pending); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Pending without the admin token = %v", err)
+	}
+	resp, err = client.ListComments(admin, pending)
+	if err != nil || len(resp.Comments) != 1 || resp.Comments[0].Email != "Eve@example.com" {
+		t.Errorf("Pending as admin = %v, %v", resp, err)
+	}
+
+	for _, bad := range []*guestbookpb.ListCommentsRequest{
+		{PageSize: 101},
+		{PageToken: "nope"},
+		{Sort: "random"},
+	} {
+		if _, err := client.ListComments(ctx, bad); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("ListComments(%v) = %v, want InvalidArgument", bad, err)
+		}
+	}
+}
+
+func TestGRPCModerateComment(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	defer func(s CommentStore) { store = s }(store)
+	config.AdminToken = "secret"
+	store = newMemoryStore()
+	client := grpcTestClient(t)
+	ctx := context.Background()
+	admin := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+
+	c := &Comment{Name: "Alice", Email: "alice@example.com", Text: "Hi", Status: "approved"}
+	store.Create(ctx, c)
+	spam := &guestbookpb.ModerateCommentRequest{Id: int64(c.ID), Status: guestbookpb.CommentStatus_COMMENT_STATUS_SPAM}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		req  *guestbookpb.ModerateCommentRequest
+		code codes.Code
+	}{
+		{"No token", ctx, spam, codes.Unauthenticated},
+		{"Wrong token", metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer guess"), spam, codes.Unauthenticated},
+		{"No status", admin, &guestbookpb.ModerateCommentRequest{Id: int64(c.ID)}, codes.InvalidArgument},
+		{"Missing", admin, &guestbookpb.ModerateCommentRequest{Id: 99, Status: guestbookpb.CommentStatus_COMMENT_STATUS_SPAM}, codes.NotFound},
+		{"Spam", admin, spam, codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.ModerateComment(tt.ctx, tt.req)
+			if status.Code(err) != tt.code {
+				t.Fatalf("ModerateComment = %v, want %v", err, tt.code)
+			}
+			if err == nil && resp.Comment.Status != guestbookpb.CommentStatus_COMMENT_STATUS_SPAM {
+				t.Errorf("Response %v", resp)
+			}
+		})
+	}
+	if got, _ := store.Get(ctx, 0, c.ID); got.Status != "spam" {
+		t.Errorf("Stored status %q, want spam", got.Status)
+	}
+}
+
+func TestRunGRPCServer(t *testing.T) {
+	ln := bufconn.Listen(1 << 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- runGRPCServer(ctx, newGRPCServer(), ln) }()
+	cancel()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("runGRPCServer = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("runGRPCServer didn't stop")
+	}
+}
@@ -0,0 +1,728 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        v6.33.0
+// source: guestbook.proto
+
+package guestbookpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type CommentStatus int32
+
+const (
+	CommentStatus_COMMENT_STATUS_UNSPECIFIED CommentStatus = 0
+	CommentStatus_COMMENT_STATUS_APPROVED    CommentStatus = 1
+	CommentStatus_COMMENT_STATUS_PENDING     CommentStatus = 2
+	CommentStatus_COMMENT_STATUS_SPAM        CommentStatus = 3
+)
+
+// Enum value maps for CommentStatus.
+var (
+	CommentStatus_name = map[int32]string{
+		0: "COMMENT_STATUS_UNSPECIFIED",
+		1: "COMMENT_STATUS_APPROVED",
+		2: "COMMENT_STATUS_PENDING",
+		3: "COMMENT_STATUS_SPAM",
+	}
+	CommentStatus_value = map[string]int32{
+		"COMMENT_STATUS_UNSPECIFIED": 0,
+		"COMMENT_STATUS_APPROVED":    1,
+		"COMMENT_STATUS_PENDING":     2,
+		"COMMENT_STATUS_SPAM":        3,
+	}
+)
+
+func (x CommentStatus) Enum() *CommentStatus {
+	p := new(CommentStatus)
+	*p = x
+	return p
+}
+
+func (x CommentStatus) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (CommentStatus) Descriptor() protoreflect.EnumDescriptor {
+	return file_guestbook_proto_enumTypes[0].Descriptor()
+}
+
+func (CommentStatus) Type() protoreflect.EnumType {
+	return &file_guestbook_proto_enumTypes[0]
+}
+
+func (x CommentStatus) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use CommentStatus.Descriptor instead.
+func (CommentStatus) EnumDescriptor() ([]byte, []int) {
+	return file_guestbook_proto_rawDescGZIP(), []int{0}
+}
+
+type Comment struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name  string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	// Only filled in for admin calls.
+	Email string `protobuf:"bytes,3,opt,name=email,proto3" json:"email,omitempty"`
+	Text  string `protobuf:"bytes,4,opt,name=text,proto3" json:"text,omitempty"`
+	// Only filled in for admin calls.
+	Ip            string                 `protobuf:"bytes,5,opt,name=ip,proto3" json:"ip,omitempty"`
+	Location      string                 `protobuf:"bytes,6,opt,name=location,proto3" json:"location,omitempty"`
+	Likes         int32                  `protobuf:"varint,7,opt,name=likes,proto3" json:"likes,omitempty"`
+	Created       *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=created,proto3" json:"created,omitempty"`
+	ParentId      int64                  `protobuf:"varint,9,opt,name=parent_id,json=parentId,proto3" json:"parent_id,omitempty"`
+	Status        CommentStatus          `protobuf:"varint,10,opt,name=status,proto3,enum=guestbook.v1.CommentStatus" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Comment) Reset() {
+	*x = Comment{}
+	mi := &file_guestbook_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Comment) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Comment) ProtoMessage() {}
+
+func (x *Comment) ProtoReflect() protoreflect.Message {
+	mi := &file_guestbook_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Comment.ProtoReflect.Descriptor instead.
+func (*Comment) Descriptor() ([]byte, []int) {
+	return file_guestbook_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Comment) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Comment) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Comment) GetEmail() string {
+	if x != nil {
+		return x.Email
+	}
+	return ""
+}
+
+func (x *Comment) GetText() string {
+	if x != nil {
+		return x.Text
+	}
+	return ""
+}
+
+func (x *Comment) GetIp() string {
+	if x != nil {
+		return x.Ip
+	}
+	return ""
+}
+
+func (x *Comment) GetLocation() string {
+	if x != nil {
+		return x.Location
+	}
+	return ""
+}
+
+func (x *Comment) GetLikes() int32 {
+	if x != nil {
+		return x.Likes
+	}
+	return 0
+}
+
+func (x *Comment) GetCreated() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Created
+	}
+	return nil
+}
+
+func (x *Comment) GetParentId() int64 {
+	if x != nil {
+		return x.ParentId
+	}
+	return 0
+}
+
+func (x *Comment) GetStatus() CommentStatus {
+	if x != nil {
+		return x.Status
+	}
+	return CommentStatus_COMMENT_STATUS_UNSPECIFIED
+}
+
+type ListCommentsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Slug of the site, with multi_tenant and no x-api-key.
+	Site string `protobuf:"bytes,1,opt,name=site,proto3" json:"site,omitempty"`
+	// At most 100, 15 if unset.
+	PageSize int32 `protobuf:"varint,2,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	// next_page_token of the previous response.
+	PageToken string `protobuf:"bytes,3,opt,name=page_token,json=pageToken,proto3" json:"page_token,omitempty"`
+	// "newest" (the default), "oldest" or "popular".
+	Sort  string                 `protobuf:"bytes,4,opt,name=sort,proto3" json:"sort,omitempty"`
+	Since *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=since,proto3" json:"since,omitempty"`
+	Until *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=until,proto3" json:"until,omitempty"`
+	// Unspecified lists approved comments; other statuses are admin only.
+	Status        CommentStatus `protobuf:"varint,7,opt,name=status,proto3,enum=guestbook.v1.CommentStatus" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListCommentsRequest) Reset() {
+	*x = ListCommentsRequest{}
+	mi := &file_guestbook_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListCommentsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListCommentsRequest) ProtoMessage() {}
+
+func (x *ListCommentsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_guestbook_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListCommentsRequest.ProtoReflect.Descriptor instead.
+func (*ListCommentsRequest) Descriptor() ([]byte, []int) {
+	return file_guestbook_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ListCommentsRequest) GetSite() string {
+	if x != nil {
+		return x.Site
+	}
+	return ""
+}
+
+func (x *ListCommentsRequest) GetPageSize() int32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *ListCommentsRequest) GetPageToken() string {
+	if x != nil {
+		return x.PageToken
+	}
+	return ""
+}
+
+func (x *ListCommentsRequest) GetSort() string {
+	if x != nil {
+		return x.Sort
+	}
+	return ""
+}
+
+func (x *ListCommentsRequest) GetSince() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Since
+	}
+	return nil
+}
+
+func (x *ListCommentsRequest) GetUntil() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Until
+	}
+	return nil
+}
+
+func (x *ListCommentsRequest) GetStatus() CommentStatus {
+	if x != nil {
+		return x.Status
+	}
+	return CommentStatus_COMMENT_STATUS_UNSPECIFIED
+}
+
+type ListCommentsResponse struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Comments []*Comment             `protobuf:"bytes,1,rep,name=comments,proto3" json:"comments,omitempty"`
+	// Empty on the last page.
+	NextPageToken string `protobuf:"bytes,2,opt,name=next_page_token,json=nextPageToken,proto3" json:"next_page_token,omitempty"`
+	TotalSize     int32  `protobuf:"varint,3,opt,name=total_size,json=totalSize,proto3" json:"total_size,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListCommentsResponse) Reset() {
+	*x = ListCommentsResponse{}
+	mi := &file_guestbook_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListCommentsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListCommentsResponse) ProtoMessage() {}
+
+func (x *ListCommentsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_guestbook_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListCommentsResponse.ProtoReflect.Descriptor instead.
+func (*ListCommentsResponse) Descriptor() ([]byte, []int) {
+	return file_guestbook_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ListCommentsResponse) GetComments() []*Comment {
+	if x != nil {
+		return x.Comments
+	}
+	return nil
+}
+
+func (x *ListCommentsResponse) GetNextPageToken() string {
+	if x != nil {
+		return x.NextPageToken
+	}
+	return ""
+}
+
+func (x *ListCommentsResponse) GetTotalSize() int32 {
+	if x != nil {
+		return x.TotalSize
+	}
+	return 0
+}
+
+type CreateCommentRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Slug of the site, with multi_tenant and no x-api-key.
+	Site  string `protobuf:"bytes,1,opt,name=site,proto3" json:"site,omitempty"`
+	Name  string `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Email string `protobuf:"bytes,3,opt,name=email,proto3" json:"email,omitempty"`
+	Text  string `protobuf:"bytes,4,opt,name=text,proto3" json:"text,omitempty"`
+	// Privacy policy consent, required with require_consent.
+	Consent bool `protobuf:"varint,5,opt,name=consent,proto3" json:"consent,omitempty"`
+	// IP of the person commenting, which the calling service knows and this
+	// one doesn't. Blocklists, duplicates and locations go by it.
+	Ip            string `protobuf:"bytes,6,opt,name=ip,proto3" json:"ip,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateCommentRequest) Reset() {
+	*x = CreateCommentRequest{}
+	mi := &file_guestbook_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateCommentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateCommentRequest) ProtoMessage() {}
+
+func (x *CreateCommentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_guestbook_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateCommentRequest.ProtoReflect.Descriptor instead.
+func (*CreateCommentRequest) Descriptor() ([]byte, []int) {
+	return file_guestbook_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *CreateCommentRequest) GetSite() string {
+	if x != nil {
+		return x.Site
+	}
+	return ""
+}
+
+func (x *CreateCommentRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *CreateCommentRequest) GetEmail() string {
+	if x != nil {
+		return x.Email
+	}
+	return ""
+}
+
+func (x *CreateCommentRequest) GetText() string {
+	if x != nil {
+		return x.Text
+	}
+	return ""
+}
+
+func (x *CreateCommentRequest) GetConsent() bool {
+	if x != nil {
+		return x.Consent
+	}
+	return false
+}
+
+func (x *CreateCommentRequest) GetIp() string {
+	if x != nil {
+		return x.Ip
+	}
+	return ""
+}
+
+type CreateCommentResponse struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Comment *Comment               `protobuf:"bytes,1,opt,name=comment,proto3" json:"comment,omitempty"`
+	// The same comment came in within duplicate_window and wasn't stored
+	// again; comment is the first one.
+	Duplicate     bool `protobuf:"varint,2,opt,name=duplicate,proto3" json:"duplicate,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateCommentResponse) Reset() {
+	*x = CreateCommentResponse{}
+	mi := &file_guestbook_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateCommentResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateCommentResponse) ProtoMessage() {}
+
+func (x *CreateCommentResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_guestbook_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateCommentResponse.ProtoReflect.Descriptor instead.
+func (*CreateCommentResponse) Descriptor() ([]byte, []int) {
+	return file_guestbook_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *CreateCommentResponse) GetComment() *Comment {
+	if x != nil {
+		return x.Comment
+	}
+	return nil
+}
+
+func (x *CreateCommentResponse) GetDuplicate() bool {
+	if x != nil {
+		return x.Duplicate
+	}
+	return false
+}
+
+type ModerateCommentRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Slug of the site, with multi_tenant and no x-api-key.
+	Site          string        `protobuf:"bytes,1,opt,name=site,proto3" json:"site,omitempty"`
+	Id            int64         `protobuf:"varint,2,opt,name=id,proto3" json:"id,omitempty"`
+	Status        CommentStatus `protobuf:"varint,3,opt,name=status,proto3,enum=guestbook.v1.CommentStatus" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ModerateCommentRequest) Reset() {
+	*x = ModerateCommentRequest{}
+	mi := &file_guestbook_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ModerateCommentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ModerateCommentRequest) ProtoMessage() {}
+
+func (x *ModerateCommentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_guestbook_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ModerateCommentRequest.ProtoReflect.Descriptor instead.
+func (*ModerateCommentRequest) Descriptor() ([]byte, []int) {
+	return file_guestbook_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ModerateCommentRequest) GetSite() string {
+	if x != nil {
+		return x.Site
+	}
+	return ""
+}
+
+func (x *ModerateCommentRequest) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *ModerateCommentRequest) GetStatus() CommentStatus {
+	if x != nil {
+		return x.Status
+	}
+	return CommentStatus_COMMENT_STATUS_UNSPECIFIED
+}
+
+type ModerateCommentResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Comment       *Comment               `protobuf:"bytes,1,opt,name=comment,proto3" json:"comment,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ModerateCommentResponse) Reset() {
+	*x = ModerateCommentResponse{}
+	mi := &file_guestbook_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ModerateCommentResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ModerateCommentResponse) ProtoMessage() {}
+
+func (x *ModerateCommentResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_guestbook_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ModerateCommentResponse.ProtoReflect.Descriptor instead.
+func (*ModerateCommentResponse) Descriptor() ([]byte, []int) {
+	return file_guestbook_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ModerateCommentResponse) GetComment() *Comment {
+	if x != nil {
+		return x.Comment
+	}
+	return nil
+}
+
+var File_guestbook_proto protoreflect.FileDescriptor
+
+const file_guestbook_proto_rawDesc = "" +
+	"\n" +
+	"\x0fguestbook.proto\x12\fguestbook.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xa1\x02\n" +
+	"\aComment\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x14\n" +
+	"\x05email\x18\x03 \x01(\tR\x05email\x12\x12\n" +
+	"\x04text\x18\x04 \x01(\tR\x04text\x12\x0e\n" +
+	"\x02ip\x18\x05 \x01(\tR\x02ip\x12\x1a\n" +
+	"\blocation\x18\x06 \x01(\tR\blocation\x12\x14\n" +
+	"\x05likes\x18\a \x01(\x05R\x05likes\x124\n" +
+	"\acreated\x18\b \x01(\v2\x1a.google.protobuf.TimestampR\acreated\x12\x1b\n" +
+	"\tparent_id\x18\t \x01(\x03R\bparentId\x123\n" +
+	"\x06status\x18\n" +
+	" \x01(\x0e2\x1b.guestbook.v1.CommentStatusR\x06status\"\x92\x02\n" +
+	"\x13ListCommentsRequest\x12\x12\n" +
+	"\x04site\x18\x01 \x01(\tR\x04site\x12\x1b\n" +
+	"\tpage_size\x18\x02 \x01(\x05R\bpageSize\x12\x1d\n" +
+	"\n" +
+	"page_token\x18\x03 \x01(\tR\tpageToken\x12\x12\n" +
+	"\x04sort\x18\x04 \x01(\tR\x04sort\x120\n" +
+	"\x05since\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\x05since\x120\n" +
+	"\x05until\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\x05until\x123\n" +
+	"\x06status\x18\a \x01(\x0e2\x1b.guestbook.v1.CommentStatusR\x06status\"\x90\x01\n" +
+	"\x14ListCommentsResponse\x121\n" +
+	"\bcomments\x18\x01 \x03(\v2\x15.guestbook.v1.CommentR\bcomments\x12&\n" +
+	"\x0fnext_page_token\x18\x02 \x01(\tR\rnextPageToken\x12\x1d\n" +
+	"\n" +
+	"total_size\x18\x03 \x01(\x05R\ttotalSize\"\x92\x01\n" +
+	"\x14CreateCommentRequest\x12\x12\n" +
+	"\x04site\x18\x01 \x01(\tR\x04site\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x14\n" +
+	"\x05email\x18\x03 \x01(\tR\x05email\x12\x12\n" +
+	"\x04text\x18\x04 \x01(\tR\x04text\x12\x18\n" +
+	"\aconsent\x18\x05 \x01(\bR\aconsent\x12\x0e\n" +
+	"\x02ip\x18\x06 \x01(\tR\x02ip\"f\n" +
+	"\x15CreateCommentResponse\x12/\n" +
+	"\acomment\x18\x01 \x01(\v2\x15.guestbook.v1.CommentR\acomment\x12\x1c\n" +
+	"\tduplicate\x18\x02 \x01(\bR\tduplicate\"q\n" +
+	"\x16ModerateCommentRequest\x12\x12\n" +
+	"\x04site\x18\x01 \x01(\tR\x04site\x12\x0e\n" +
+	"\x02id\x18\x02 \x01(\x03R\x02id\x123\n" +
+	"\x06status\x18\x03 \x01(\x0e2\x1b.guestbook.v1.CommentStatusR\x06status\"J\n" +
+	"\x17ModerateCommentResponse\x12/\n" +
+	"\acomment\x18\x01 \x01(\v2\x15.guestbook.v1.CommentR\acomment*\x81\x01\n" +
+	"\rCommentStatus\x12\x1e\n" +
+	"\x1aCOMMENT_STATUS_UNSPECIFIED\x10\x00\x12\x1b\n" +
+	"\x17COMMENT_STATUS_APPROVED\x10\x01\x12\x1a\n" +
+	"\x16COMMENT_STATUS_PENDING\x10\x02\x12\x17\n" +
+	"\x13COMMENT_STATUS_SPAM\x10\x032\xa3\x02\n" +
+	"\x10GuestbookService\x12U\n" +
+	"\fListComments\x12!.guestbook.v1.ListCommentsRequest\x1a\".guestbook.v1.ListCommentsResponse\x12X\n" +
+	"\rCreateComment\x12\".guestbook.v1.CreateCommentRequest\x1a#.guestbook.v1.CreateCommentResponse\x12^\n" +
+	"\x0fModerateComment\x12$.guestbook.v1.ModerateCommentRequest\x1a%.guestbook.v1.ModerateCommentResponseB\x17Z\x15guestbook/guestbookpbb\x06proto3"
+
+var (
+	file_guestbook_proto_rawDescOnce sync.Once
+	file_guestbook_proto_rawDescData []byte
+)
+
+func file_guestbook_proto_rawDescGZIP() []byte {
+	file_guestbook_proto_rawDescOnce.Do(func() {
+		file_guestbook_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_guestbook_proto_rawDesc), len(file_guestbook_proto_rawDesc)))
+	})
+	return file_guestbook_proto_rawDescData
+}
+
+var file_guestbook_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_guestbook_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_guestbook_proto_goTypes = []any{
+	(CommentStatus)(0),              // 0: guestbook.v1.CommentStatus
+	(*Comment)(nil),                 // 1: guestbook.v1.Comment
+	(*ListCommentsRequest)(nil),     // 2: guestbook.v1.ListCommentsRequest
+	(*ListCommentsResponse)(nil),    // 3: guestbook.v1.ListCommentsResponse
+	(*CreateCommentRequest)(nil),    // 4: guestbook.v1.CreateCommentRequest
+	(*CreateCommentResponse)(nil),   // 5: guestbook.v1.CreateCommentResponse
+	(*ModerateCommentRequest)(nil),  // 6: guestbook.v1.ModerateCommentRequest
+	(*ModerateCommentResponse)(nil), // 7: guestbook.v1.ModerateCommentResponse
+	(*timestamppb.Timestamp)(nil),   // 8: google.protobuf.Timestamp
+}
+var file_guestbook_proto_depIdxs = []int32{
+	8,  // 0: guestbook.v1.Comment.created:type_name -> google.protobuf.Timestamp
+	0,  // 1: guestbook.v1.Comment.status:type_name -> guestbook.v1.CommentStatus
+	8,  // 2: guestbook.v1.ListCommentsRequest.since:type_name -> google.protobuf.Timestamp
+	8,  // 3: guestbook.v1.ListCommentsRequest.until:type_name -> google.protobuf.Timestamp
+	0,  // 4: guestbook.v1.ListCommentsRequest.status:type_name -> guestbook.v1.CommentStatus
+	1,  // 5: guestbook.v1.ListCommentsResponse.comments:type_name -> guestbook.v1.Comment
+	1,  // 6: guestbook.v1.CreateCommentResponse.comment:type_name -> guestbook.v1.Comment
+	0,  // 7: guestbook.v1.ModerateCommentRequest.status:type_name -> guestbook.v1.CommentStatus
+	1,  // 8: guestbook.v1.ModerateCommentResponse.comment:type_name -> guestbook.v1.Comment
+	2,  // 9: guestbook.v1.GuestbookService.ListComments:input_type -> guestbook.v1.ListCommentsRequest
+	4,  // 10: guestbook.v1.GuestbookService.CreateComment:input_type -> guestbook.v1.CreateCommentRequest
+	6,  // 11: guestbook.v1.GuestbookService.ModerateComment:input_type -> guestbook.v1.ModerateCommentRequest
+	3,  // 12: guestbook.v1.GuestbookService.ListComments:output_type -> guestbook.v1.ListCommentsResponse
+	5,  // 13: guestbook.v1.GuestbookService.CreateComment:output_type -> guestbook.v1.CreateCommentResponse
+	7,  // 14: guestbook.v1.GuestbookService.ModerateComment:output_type -> guestbook.v1.ModerateCommentResponse
+	12, // [12:15] is the sub-list for method output_type
+	9,  // [9:12] is the sub-list for method input_type
+	9,  // [9:9] is the sub-list for extension type_name
+	9,  // [9:9] is the sub-list for extension extendee
+	0,  // [0:9] is the sub-list for field type_name
+}
+
+func init() { file_guestbook_proto_init() }
+func file_guestbook_proto_init() {
+	if File_guestbook_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_guestbook_proto_rawDesc), len(file_guestbook_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_guestbook_proto_goTypes,
+		DependencyIndexes: file_guestbook_proto_depIdxs,
+		EnumInfos:         file_guestbook_proto_enumTypes,
+		MessageInfos:      file_guestbook_proto_msgTypes,
+	}.Build()
+	File_guestbook_proto = out.File
+	file_guestbook_proto_goTypes = nil
+	file_guestbook_proto_depIdxs = nil
+}
@@ -0,0 +1,203 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v6.33.0
+// source: guestbook.proto
+
+package guestbookpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	GuestbookService_ListComments_FullMethodName    = "/guestbook.v1.GuestbookService/ListComments"
+	GuestbookService_CreateComment_FullMethodName   = "/guestbook.v1.GuestbookService/CreateComment"
+	GuestbookService_ModerateComment_FullMethodName = "/guestbook.v1.GuestbookService/ModerateComment"
+)
+
+// GuestbookServiceClient is the client API for GuestbookService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type GuestbookServiceClient interface {
+	// ListComments pages through a site's comments, newest first by default.
+	ListComments(ctx context.Context, in *ListCommentsRequest, opts ...grpc.CallOption) (*ListCommentsResponse, error)
+	// CreateComment adds a comment with the same checks as POST /comments.
+	CreateComment(ctx context.Context, in *CreateCommentRequest, opts ...grpc.CallOption) (*CreateCommentResponse, error)
+	// ModerateComment sets a comment's status. Admin only.
+	ModerateComment(ctx context.Context, in *ModerateCommentRequest, opts ...grpc.CallOption) (*ModerateCommentResponse, error)
+}
+
+type guestbookServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewGuestbookServiceClient(cc grpc.ClientConnInterface) GuestbookServiceClient {
+	return &guestbookServiceClient{cc}
+}
+
+func (c *guestbookServiceClient) ListComments(ctx context.Context, in *ListCommentsRequest, opts ...grpc.CallOption) (*ListCommentsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListCommentsResponse)
+	err := c.cc.Invoke(ctx, GuestbookService_ListComments_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *guestbookServiceClient) CreateComment(ctx context.Context, in *CreateCommentRequest, opts ...grpc.CallOption) (*CreateCommentResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CreateCommentResponse)
+	err := c.cc.Invoke(ctx, GuestbookService_CreateComment_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *guestbookServiceClient) ModerateComment(ctx context.Context, in *ModerateCommentRequest, opts ...grpc.CallOption) (*ModerateCommentResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ModerateCommentResponse)
+	err := c.cc.Invoke(ctx, GuestbookService_ModerateComment_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GuestbookServiceServer is the server API for GuestbookService service.
+// All implementations must embed UnimplementedGuestbookServiceServer
+// for forward compatibility.
+type GuestbookServiceServer interface {
+	// ListComments pages through a site's comments, newest first by default.
+	ListComments(context.Context, *ListCommentsRequest) (*ListCommentsResponse, error)
+	// CreateComment adds a comment with the same checks as POST /comments.
+	CreateComment(context.Context, *CreateCommentRequest) (*CreateCommentResponse, error)
+	// ModerateComment sets a comment's status. Admin only.
+	ModerateComment(context.Context, *ModerateCommentRequest) (*ModerateCommentResponse, error)
+	mustEmbedUnimplementedGuestbookServiceServer()
+}
+
+// UnimplementedGuestbookServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedGuestbookServiceServer struct{}
+
+func (UnimplementedGuestbookServiceServer) ListComments(context.Context, *ListCommentsRequest) (*ListCommentsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListComments not implemented")
+}
+func (UnimplementedGuestbookServiceServer) CreateComment(context.Context, *CreateCommentRequest) (*CreateCommentResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateComment not implemented")
+}
+func (UnimplementedGuestbookServiceServer) ModerateComment(context.Context, *ModerateCommentRequest) (*ModerateCommentResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ModerateComment not implemented")
+}
+func (UnimplementedGuestbookServiceServer) mustEmbedUnimplementedGuestbookServiceServer() {}
+func (UnimplementedGuestbookServiceServer) testEmbeddedByValue()                          {}
+
+// UnsafeGuestbookServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to GuestbookServiceServer will
+// result in compilation errors.
+type UnsafeGuestbookServiceServer interface {
+	mustEmbedUnimplementedGuestbookServiceServer()
+}
+
+func RegisterGuestbookServiceServer(s grpc.ServiceRegistrar, srv GuestbookServiceServer) {
+	// If the following call pancis, it indicates UnimplementedGuestbookServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&GuestbookService_ServiceDesc, srv)
+}
+
+func _GuestbookService_ListComments_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListCommentsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GuestbookServiceServer).ListComments(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: GuestbookService_ListComments_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GuestbookServiceServer).ListComments(ctx, req.(*ListCommentsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GuestbookService_CreateComment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateCommentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GuestbookServiceServer).CreateComment(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: GuestbookService_CreateComment_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GuestbookServiceServer).CreateComment(ctx, req.(*CreateCommentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GuestbookService_ModerateComment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ModerateCommentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GuestbookServiceServer).ModerateComment(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: GuestbookService_ModerateComment_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GuestbookServiceServer).ModerateComment(ctx, req.(*ModerateCommentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// GuestbookService_ServiceDesc is the grpc.ServiceDesc for GuestbookService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var GuestbookService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "guestbook.v1.GuestbookService",
+	HandlerType: (*GuestbookServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListComments",
+			Handler:    _GuestbookService_ListComments_Handler,
+		},
+		{
+			MethodName: "CreateComment",
+			Handler:    _GuestbookService_CreateComment_Handler,
+		},
+		{
+			MethodName: "ModerateComment",
+			Handler:    _GuestbookService_ModerateComment_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "guestbook.proto",
+}
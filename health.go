@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+)
+
+type ComponentStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type HealthResponse struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components,omitempty"`
+}
+
+// healthzHandler answers liveness probes: if it runs, the process is alive.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// readyzHandler answers readiness probes, checking everything a request
+// needs. It responds 503 when any component is down.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	resp := HealthResponse{Status: "ok", Components: map[string]ComponentStatus{}}
+	check := func(name string, err error) {
+		if err != nil {
+			resp.Status = "unavailable"
+			resp.Components[name] = ComponentStatus{Status: "down", Error: err.Error()}
+		} else {
+			resp.Components[name] = ComponentStatus{Status: "ok"}
+		}
+	}
+
+	check("database", store.Ping(ctx))
+	if config.RedisURL != "" {
+		check("redis", shared.Ping(ctx))
+	}
+	if logFile != nil {
+		check("log", checkWritable(logFile.path))
+	}
+	if accessLogFile != nil {
+		check("access_log", checkWritable(accessLogFile.path))
+	}
+
+	status := http.StatusOK
+	if resp.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeHealth(w, status, resp)
+}
+
+func writeHealth(w http.ResponseWriter, status int, resp HealthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// checkWritable opens path for appending without writing anything, which
+// catches permission changes and full or read-only filesystems mounted
+// over it.
+func checkWritable(path string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestHealthzHandler(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	healthzHandler(recorder, httptest.NewRequest("GET", "/healthz", nil))
+
+	if recorder.Code != 200 {
+		t.Errorf("Expected status 200, got %d", recorder.Code)
+	}
+}
+
+func TestReadyzHandler(t *testing.T) {
+	defer func(f *rotatingFile) { logFile = f }(logFile)
+
+	dir := t.TempDir()
+	logFile = &rotatingFile{path: filepath.Join(dir, "guestbook.log")}
+
+	tests := []struct {
+		name           string
+		setup          func()
+		expectedStatus int
+		expectedLog    string
+	}{
+		{"All ok", func() {}, 200, "ok"},
+		{"Log not writable", func() {
+			// A directory in place of the log file can never be opened for writing
+			logFile.path = dir
+		}, 503, "down"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setup()
+			recorder := httptest.NewRecorder()
+			readyzHandler(recorder, httptest.NewRequest("GET", "/readyz", nil))
+
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+
+			var resp HealthResponse
+			if err := json.NewDecoder(recorder.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Components["database"].Status != "ok" {
+				t.Errorf("Expected database ok, got %+v", resp.Components["database"])
+			}
+			if resp.Components["log"].Status != tt.expectedLog {
+				t.Errorf("Expected log %s, got %+v", tt.expectedLog, resp.Components["log"])
+			}
+		})
+	}
+}
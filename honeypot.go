@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// BotHit is a request to one of the honeypot paths.
+type BotHit struct {
+	IP          string
+	Path        string
+	UserAgent   string
+	Fingerprint string
+}
+
+// BlockedIP is an entry of the blocklist, an address or a network in CIDR
+// notation. Hits counts how often it was blocked, Updated is the last
+// time. Expires is zero for bans that don't.
+type BlockedIP struct {
+	IP      string
+	Reason  string
+	Hits    int
+	Created time.Time
+	Updated time.Time
+	Expires time.Time
+}
+
+// maxHoneypotHits is how many honeypot requests are served at once. They
+// don't take a load shedding slot, so a swarm of bots sitting in the
+// tarpit can't crowd out real visitors; beyond this they get 503 straight
+// away.
+const maxHoneypotHits = 32
+
+var honeypotSlots = make(chan struct{}, maxHoneypotHits)
+
+// honeypotHandler serves the decoy endpoints from honeypot_paths. No human
+// ever has a reason to hit them, so the caller is recorded, blocklisted for
+// honeypot_ban_minutes if that's set, and then kept busy in the tarpit for
+// as long as it's willing to wait. A human can still be sent there by a
+// page embedding the path, which is why the ban expires and is off by
+// default.
+func honeypotHandler(w http.ResponseWriter, r *http.Request) {
+	select {
+	case honeypotSlots <- struct{}{}:
+		defer func() { <-honeypotSlots }()
+	default:
+		httpError(w, r, http.StatusServiceUnavailable, codeOverloaded, "The server is busy, try again shortly")
+		return
+	}
+	ip := getIP(r)
+	cfg := settings()
+
+	err := store.RecordBotHit(r.Context(), BotHit{
+		IP:          ip,
+		Path:        r.URL.Path,
+		UserAgent:   r.UserAgent(),
+		Fingerprint: botFingerprint(r),
+	})
+	if err == nil && cfg.HoneypotBanMinutes > 0 {
+		err = banHoneypotIP(r.Context(), ip, r.URL.Path, time.Duration(cfg.HoneypotBanMinutes)*time.Minute)
+	}
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	requestLogger(r).Warn("honeypot hit", "user_agent", r.UserAgent(), "banned", cfg.HoneypotBanMinutes > 0)
+
+	tarpit(w, r, time.Duration(cfg.TarpitSeconds)*time.Second)
+}
+
+// banHoneypotIP blocklists ip for d, unless it's blocked already: BlockIP
+// would replace a longer ban, or a permanent one, with this one.
+func banHoneypotIP(ctx context.Context, ip, path string, d time.Duration) error {
+	blocked, err := store.IsBlocked(ctx, ip)
+	if err != nil || blocked {
+		return err
+	}
+	return store.BlockIP(ctx, ip, "honeypot "+path, time.Now().Add(d))
+}
+
+// botFingerprint hashes the headers that tend to stay stable across a
+// bot's requests even when it rotates IPs.
+func botFingerprint(r *http.Request) string {
+	h := sha256.New()
+	for _, name := range []string{"User-Agent", "Accept", "Accept-Language", "Accept-Encoding", "Connection"} {
+		h.Write([]byte(name + "=" + r.Header.Get(name) + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// tarpit trickles a bogus page out one byte per second until d has passed
+// or the client gives up.
+func tarpit(w http.ResponseWriter, r *http.Request, d time.Duration) {
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	deadline := time.After(d)
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-shuttingDown:
+			return
+		case <-deadline:
+			w.Write([]byte("\n"))
+			return
+		case <-ticker.C:
+			w.Write([]byte(" "))
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
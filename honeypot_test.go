@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHoneypotHandler(t *testing.T) {
+	needSQLite(t)
+	defer func(c Config) { config = c }(config)
+	config.TarpitSeconds = 0
+
+	if _, err := db.Exec("DELETE FROM blocklist; DELETE FROM bot_hits"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without honeypot_ban_minutes the hit is only recorded
+	req := httptest.NewRequest("GET", "/wp-login.php", nil)
+	req.RemoteAddr = "198.51.100.7:4444"
+	honeypotHandler(httptest.NewRecorder(), req)
+	if blocked, err := store.IsBlocked(req.Context(), "198.51.100.7"); err != nil || blocked {
+		t.Fatalf("IsBlocked() after a hit without a ban = %v, %v", blocked, err)
+	}
+
+	config.HoneypotBanMinutes = 60
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "/wp-comments-post.php", nil)
+		req.RemoteAddr = "198.51.100.7:4444"
+		req.Header.Set("User-Agent", "spambot/1.0")
+		recorder := httptest.NewRecorder()
+
+		honeypotHandler(recorder, req)
+
+		if recorder.Code != 200 {
+			t.Fatalf("Expected status 200, got %d", recorder.Code)
+		}
+	}
+
+	var recorded int
+	var fingerprint string
+	blocked, err := store.Blocklist(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the second hit leaves the first ban alone
+	if len(blocked) != 1 || blocked[0].Hits != 1 || blocked[0].Expires.IsZero() || time.Until(blocked[0].Expires) > time.Hour {
+		t.Errorf("Blocklist() = %+v, want one ban expiring within the hour", blocked)
+	}
+	if err := db.QueryRow("SELECT COUNT(*), MAX(fingerprint) FROM bot_hits WHERE ip = '198.51.100.7'").Scan(&recorded, &fingerprint); err != nil {
+		t.Fatal(err)
+	}
+	if recorded != 3 || len(fingerprint) != 16 {
+		t.Errorf("Expected 3 recorded hits with fingerprint, got %d %q", recorded, fingerprint)
+	}
+
+	// The trapped IP can no longer post comments
+	req = httptest.NewRequest("POST", "/comments", strings.NewReader("name=Bot&email=bot@example.com&comment=Buy now"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = "198.51.100.7:5555"
+	recorder := httptest.NewRecorder()
+
+	addComment(recorder, req)
+
+	if recorder.Code != 403 {
+		t.Errorf("Expected status 403 for blocked IP, got %d", recorder.Code)
+	}
+
+	// beyond maxHoneypotHits at once, the rest are turned away
+	for range maxHoneypotHits {
+		honeypotSlots <- struct{}{}
+	}
+	recorder = httptest.NewRecorder()
+	honeypotHandler(recorder, httptest.NewRequest("GET", "/wp-login.php", nil))
+	for range maxHoneypotHits {
+		<-honeypotSlots
+	}
+	if recorder.Code != 503 || recorder.Header().Get("X-Error-Code") != codeOverloaded {
+		t.Errorf("Honeypot hit over the cap = %d", recorder.Code)
+	}
+}
+
+func TestBotFingerprint(t *testing.T) {
+	a := httptest.NewRequest("GET", "/xmlrpc.php", nil)
+	a.Header.Set("User-Agent", "spambot/1.0")
+	b := httptest.NewRequest("GET", "/wp-login.php", nil)
+	b.Header.Set("User-Agent", "spambot/1.0")
+	c := httptest.NewRequest("GET", "/xmlrpc.php", nil)
+	c.Header.Set("User-Agent", "otherbot/2.0")
+
+	if botFingerprint(a) != botFingerprint(b) {
+		t.Error("Same headers on different paths should share a fingerprint")
+	}
+	if botFingerprint(a) == botFingerprint(c) {
+		t.Error("Different user agents should not share a fingerprint")
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// HTTP Signatures as the fediverse uses them: draft-cavage-http-signatures
+// with rsa-sha256 over (request-target), host, date and, for requests with
+// a body, a SHA-256 Digest of it.
+
+// signatureMaxSkew is how far a signed request's Date may be from now.
+const signatureMaxSkew = 12 * time.Hour
+
+// signRequest signs req and its body with key, named keyID for the
+// receiver to fetch.
+func signRequest(req *http.Request, keyID string, key *rsa.PrivateKey, body []byte) error {
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	headers := []string{"(request-target)", "host", "date"}
+	if body != nil {
+		sum := sha256.Sum256(body)
+		req.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]))
+		headers = append(headers, "digest")
+	}
+	hashed := sha256.Sum256([]byte(signingString(req, headers)))
+	sig, err := rsa.SignPKCS1v15(nil, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyID, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(sig)))
+	return nil
+}
+
+// signingString is what gets signed: each of headers as "name: value" on
+// its own line.
+func signingString(req *http.Request, headers []string) string {
+	lines := make([]string, len(headers))
+	for i, h := range headers {
+		var v string
+		switch h {
+		case "(request-target)":
+			v = strings.ToLower(req.Method) + " " + req.URL.RequestURI()
+		case "host":
+			v = req.Host
+			if v == "" {
+				v = req.URL.Host
+			}
+		default:
+			v = strings.Join(req.Header.Values(h), ", ")
+		}
+		lines[i] = h + ": " + v
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parseSignature splits a Signature header into its parameters.
+func parseSignature(header string) map[string]string {
+	params := map[string]string{}
+	for _, part := range strings.Split(header, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if ok {
+			params[k] = strings.Trim(v, `"`)
+		}
+	}
+	return params
+}
+
+var errBadSignature = errors.New("invalid HTTP signature")
+
+// signatureKeyID returns the keyId of r's signature, or "" if it has none.
+func signatureKeyID(r *http.Request) string {
+	return parseSignature(r.Header.Get("Signature"))["keyId"]
+}
+
+// verifySignature checks that r and its body were signed with key: that
+// the signature covers the request target, host and date, and the digest
+// if there's a body, and that the date is recent.
+func verifySignature(r *http.Request, body []byte, key *rsa.PublicKey) error {
+	if err := checkSignedParts(r, body); err != nil {
+		return err
+	}
+	params := parseSignature(r.Header.Get("Signature"))
+	sig, err := base64.StdEncoding.DecodeString(params["signature"])
+	if err != nil {
+		return fmt.Errorf("%w: %v", errBadSignature, err)
+	}
+	hashed := sha256.Sum256([]byte(signingString(r, strings.Fields(params["headers"]))))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], sig); err != nil {
+		return errBadSignature
+	}
+	return nil
+}
+
+// checkSignedParts is everything verifySignature checks short of the
+// signature itself, which needs the key: that can be refused before
+// fetching the key from another server.
+func checkSignedParts(r *http.Request, body []byte) error {
+	params := parseSignature(r.Header.Get("Signature"))
+	if alg := params["algorithm"]; alg != "" && alg != "rsa-sha256" && alg != "hs2019" {
+		return fmt.Errorf("%w: algorithm %q", errBadSignature, alg)
+	}
+	headers := strings.Fields(params["headers"])
+	required := []string{"(request-target)", "host", "date"}
+	if len(body) > 0 {
+		required = append(required, "digest")
+	}
+	for _, h := range required {
+		if !slices.Contains(headers, h) {
+			return fmt.Errorf("%w: %s isn't signed", errBadSignature, h)
+		}
+	}
+
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil || time.Since(date).Abs() > signatureMaxSkew {
+		return fmt.Errorf("%w: Date is missing or too far off", errBadSignature)
+	}
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		if r.Header.Get("Digest") != "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]) {
+			return fmt.Errorf("%w: Digest doesn't match the body", errBadSignature)
+		}
+	}
+	return nil
+}
+
+func encodePrivateKey(key *rsa.PrivateKey) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+func decodePrivateKey(s string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, errors.New("no PEM private key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key isn't RSA")
+	}
+	return rsaKey, nil
+}
+
+func encodePublicKey(key *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// decodePublicKey reads the publicKeyPem of an actor, which some servers
+// write as PKIX and some as PKCS #1.
+func decodePublicKey(s string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, errors.New("no PEM public key")
+	}
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("public key isn't RSA")
+	}
+	return rsaKey, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestVerifySignature(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	body := []byte(`{"type":"Follow"}`)
+
+	signed := func() *http.Request {
+		req := httptest.NewRequest("POST", "https://guestbook.example/ap/guestbook/inbox", bytes.NewReader(body))
+		if err := signRequest(req, "https://remote.example/users/alice#main-key", key, body); err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(*http.Request) []byte
+		key    *rsa.PublicKey
+		ok     bool
+	}{
+		{"Valid", func(*http.Request) []byte { return body }, &key.PublicKey, true},
+		{"Wrong key", func(*http.Request) []byte { return body }, &other.PublicKey, false},
+		{"Other body", func(*http.Request) []byte { return []byte(`{"type":"Delete"}`) }, &key.PublicKey, false},
+		{"Other path", func(r *http.Request) []byte { r.URL.Path = "/ap/other/inbox"; return body }, &key.PublicKey, false},
+		{"Other host", func(r *http.Request) []byte { r.Host = "evil.example"; return body }, &key.PublicKey, false},
+		{"Old date", func(r *http.Request) []byte {
+			r.Header.Set("Date", time.Now().Add(-24*time.Hour).UTC().Format(http.TimeFormat))
+			return body
+		}, &key.PublicKey, false},
+		{"No digest signed", func(r *http.Request) []byte {
+			p := parseSignature(r.Header.Get("Signature"))
+			r.Header.Set("Signature", `keyId="`+p["keyId"]+`",headers="(request-target) host date",signature="`+p["signature"]+`"`)
+			return body
+		}, &key.PublicKey, false},
+		{"Unsigned", func(r *http.Request) []byte { r.Header.Del("Signature"); return body }, &key.PublicKey, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := signed()
+			err := verifySignature(req, tt.tamper(req), tt.key)
+			if (err == nil) != tt.ok {
+				t.Errorf("verifySignature() = %v, want ok %v", err, tt.ok)
+			}
+			if err != nil && !errors.Is(err, errBadSignature) {
+				t.Errorf("Error %v isn't errBadSignature", err)
+			}
+		})
+	}
+}
+
+func TestKeyEncoding(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err := encodePrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodePrivateKey(priv)
+	if err != nil || !decoded.Equal(key) {
+		t.Errorf("Private key round trip = %v", err)
+	}
+	pub, err := encodePublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodedPub, err := decodePublicKey(pub)
+	if err != nil || !decodedPub.Equal(&key.PublicKey) {
+		t.Errorf("Public key round trip = %v", err)
+	}
+	if _, err := decodePublicKey("not a key"); err == nil {
+		t.Error("decodePublicKey accepted garbage")
+	}
+}
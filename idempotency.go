@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// idempotencyTTL is how long the response to an Idempotency-Key is
+// replayed for.
+const idempotencyTTL = 24 * time.Hour
+
+// idempotencyLockTTL bounds how long a key stays claimed by a request that
+// never finished, say because its instance crashed.
+const idempotencyLockTTL = time.Minute
+
+// idempotentResponse is what a request with an Idempotency-Key answered.
+// It's stored in shared state, empty while the first request still runs.
+type idempotentResponse struct {
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type"`
+	Body        []byte `json:"body"`
+}
+
+// withIdempotency runs next unless the request carries an Idempotency-Key
+// header that was already used by the same client on the same site, in
+// which case the first response is sent again. A retry that arrives while
+// the first request runs waits for it. Server errors aren't remembered so
+// they can be retried.
+func withIdempotency(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		next(w, r)
+		return
+	}
+	if len(key) > 255 {
+		httpError(w, r, 400, codeInvalidIdempotencyKey, "Idempotency-Key must be at most 255 characters")
+		return
+	}
+	key = "idempotency:" + strconv.Itoa(siteFor(r).ID) + ":" + getIP(r) + ":" + key
+
+	prev, err := claimIdempotencyKey(r.Context(), key)
+	if err != nil {
+		if r.Context().Err() != nil {
+			return
+		}
+		// better a possible duplicate than no comments at all
+		requestLogger(r).Warn("idempotency check failed", "error", err)
+		next(w, r)
+		return
+	}
+	if prev != nil {
+		w.Header().Set("Content-Type", prev.ContentType)
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(prev.Status)
+		w.Write(prev.Body)
+		return
+	}
+
+	rec := &recordingWriter{statusWriter: &statusWriter{ResponseWriter: w, status: http.StatusOK}}
+	next(rec, r)
+
+	// the client is gone or not, the outcome has to be recorded
+	ctx := context.WithoutCancel(r.Context())
+	if rec.status >= 500 {
+		err = shared.Delete(ctx, key)
+	} else {
+		var v []byte
+		v, err = json.Marshal(idempotentResponse{Status: rec.status, ContentType: w.Header().Get("Content-Type"), Body: rec.body.Bytes()})
+		if err == nil {
+			err = shared.Set(ctx, key, v, idempotencyTTL)
+		}
+	}
+	if err != nil {
+		requestLogger(r).Warn("saving the idempotent response failed", "error", err)
+	}
+}
+
+// claimIdempotencyKey returns the stored response for key, or nil once the
+// caller holds the key and has to run the request itself.
+func claimIdempotencyKey(ctx context.Context, key string) (*idempotentResponse, error) {
+	for {
+		claimed, err := shared.SetNX(ctx, key, nil, idempotencyLockTTL)
+		if err != nil || claimed {
+			return nil, err
+		}
+		v, ok, err := shared.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if ok && len(v) > 0 {
+			var resp idempotentResponse
+			return &resp, json.Unmarshal(v, &resp)
+		}
+		// still running, or it just failed and the key is free again
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// recordingWriter keeps a copy of the body it writes.
+type recordingWriter struct {
+	*statusWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.statusWriter.Write(b)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWithIdempotency(t *testing.T) {
+	var calls atomic.Int32
+	status := 201
+	h := func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(status)
+		w.Write([]byte("call " + strings.Repeat("x", int(calls.Load()))))
+	}
+	do := func(key, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/comments", nil)
+		req.RemoteAddr = remoteAddr
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		withIdempotency(rec, req, h)
+		return rec
+	}
+
+	first := do("key-1", "192.0.2.1:1")
+	again := do("key-1", "192.0.2.1:1")
+	if calls.Load() != 1 {
+		t.Fatalf("Handler ran %d times for the same key", calls.Load())
+	}
+	if again.Code != 201 || again.Body.String() != first.Body.String() || again.Header().Get("Idempotent-Replayed") != "true" ||
+		again.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("Replay = %d %q %v", again.Code, again.Body, again.Header())
+	}
+
+	// keys are scoped to the client, and no key means no deduplication
+	do("key-1", "192.0.2.2:1")
+	do("", "192.0.2.1:1")
+	do("", "192.0.2.1:1")
+	if calls.Load() != 4 {
+		t.Errorf("Handler ran %d times, want 4", calls.Load())
+	}
+
+	// server errors are forgotten so the client can retry
+	status = 500
+	do("key-2", "192.0.2.1:1")
+	status = 201
+	if rec := do("key-2", "192.0.2.1:1"); rec.Code != 201 || calls.Load() != 6 {
+		t.Errorf("Retry after 500 = %d after %d calls", rec.Code, calls.Load())
+	}
+
+	if rec := do(strings.Repeat("k", 256), "192.0.2.1:1"); rec.Code != 400 || rec.Header().Get("X-Error-Code") != codeInvalidIdempotencyKey {
+		t.Errorf("Long key = %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestWithIdempotencyConcurrent(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	h := func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		w.WriteHeader(201)
+	}
+
+	var wg sync.WaitGroup
+	codes := make([]int, 5)
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("POST", "/comments", nil)
+			req.Header.Set("Idempotency-Key", "double-click")
+			rec := httptest.NewRecorder()
+			withIdempotency(rec, req, h)
+			codes[i] = rec.Code
+		}()
+	}
+	close(release)
+	wg.Wait()
+	if calls.Load() != 1 {
+		t.Errorf("Handler ran %d times for concurrent requests with one key", calls.Load())
+	}
+	for i, code := range codes {
+		if code != 201 {
+			t.Errorf("Request %d = %d, want 201", i, code)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// importedComment is a comment read from another system, still carrying
+// that system's IDs so replies can be linked up once everything is read.
+type importedComment struct {
+	Comment
+	SourceID     string
+	SourceParent string
+}
+
+// importers read a dump from the path they're given, in any order.
+var importers = map[string]func(path string) ([]importedComment, error){
+	"disqus":   readDisqus,
+	"isso":     readIsso,
+	"commento": readCommento,
+}
+
+// runImport implements the import subcommand:
+//
+//	guestbook import [-site slug] <format> <file>
+func runImport(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	slug := fs.String("site", "", "site to import into (default: the single-tenant guestbook)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errors.New("usage: guestbook import [-site slug] <disqus|isso|commento> <file>")
+	}
+	read, ok := importers[fs.Arg(0)]
+	if !ok {
+		return fmt.Errorf("unknown import format %q", fs.Arg(0))
+	}
+
+	ctx := context.Background()
+	siteID := 0
+	if *slug != "" {
+		if db == nil {
+			return errors.New("sites need db_driver sqlite3 or mysql")
+		}
+		site, err := lookupSite(ctx, "", *slug)
+		if err != nil {
+			return fmt.Errorf("site %s: %w", *slug, err)
+		}
+		siteID = site.ID
+	}
+
+	comments, err := read(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	n, err := importComments(ctx, siteID, comments)
+	fmt.Fprintf(out, "Imported %d of %d comments\n", n, len(comments))
+	return err
+}
+
+// importComments stores comments oldest first so a reply's parent already
+// has its new ID when the reply is written. Replies to comments that aren't
+// part of the import become top-level comments.
+func importComments(ctx context.Context, siteID int, comments []importedComment) (int, error) {
+	slices.SortStableFunc(comments, func(a, b importedComment) int {
+		return a.Created.Compare(b.Created)
+	})
+	ids := make(map[string]int, len(comments))
+	for i, ic := range comments {
+		c := ic.Comment
+		c.SiteID = siteID
+		c.ParentID = ids[ic.SourceParent]
+		if err := store.Create(ctx, &c); err != nil {
+			return i, fmt.Errorf("importing comment %s: %w", ic.SourceID, err)
+		}
+		if ic.SourceID != "" {
+			ids[ic.SourceID] = c.ID
+		}
+	}
+	return len(comments), nil
+}
+
+type disqusPost struct {
+	ID        string    `xml:"http://disqus.com/disqus-internals id,attr"`
+	Message   string    `xml:"message"`
+	CreatedAt time.Time `xml:"createdAt"`
+	IsDeleted bool      `xml:"isDeleted"`
+	IsSpam    bool      `xml:"isSpam"`
+	IPAddress string    `xml:"ipAddress"`
+	Author    struct {
+		Name     string `xml:"name"`
+		Email    string `xml:"email"`
+		Username string `xml:"username"`
+	} `xml:"author"`
+	Parent struct {
+		ID string `xml:"http://disqus.com/disqus-internals id,attr"`
+	} `xml:"parent"`
+}
+
+// readDisqus reads the posts of a Disqus XML export. Threads (the pages
+// comments were left on) are ignored since the guestbook is a single list,
+// and deleted posts are skipped.
+func readDisqus(path string) ([]importedComment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var comments []importedComment
+	dec := xml.NewDecoder(f)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "post" {
+			continue
+		}
+		var p disqusPost
+		if err := dec.DecodeElement(&p, &start); err != nil {
+			return nil, fmt.Errorf("%s: post: %w", path, err)
+		}
+		if p.IsDeleted {
+			continue
+		}
+
+		c := importedComment{SourceID: p.ID, SourceParent: p.Parent.ID}
+		c.Name = cmp.Or(p.Author.Name, p.Author.Username, "Anonymous")
+		c.Email = p.Author.Email
+		c.Text = htmlToText(p.Message)
+		c.IP = p.IPAddress
+		c.Created = p.CreatedAt.UTC()
+		c.Status = "approved"
+		if p.IsSpam {
+			c.Status = "spam"
+		}
+		comments = append(comments, c)
+	}
+	return comments, nil
+}
+
+// Isso comment modes.
+const (
+	issoAccepted = 1
+	issoPending  = 2
+	issoDeleted  = 4
+)
+
+// readIsso reads the comments table of an Isso SQLite database. Like
+// Disqus threads, Isso's per-page threads are ignored. Comments deleted
+// while they had replies are only marked deleted by Isso, so they are
+// skipped here.
+func readIsso(path string) ([]importedComment, error) {
+	if !sqliteAvailable {
+		return nil, errors.New("importing from Isso needs a build with cgo")
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	idb, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer idb.Close()
+
+	rows, err := idb.Query(`SELECT id, parent, created, mode, remote_addr, text, author, email, likes FROM comments`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	defer rows.Close()
+
+	var comments []importedComment
+	for rows.Next() {
+		var (
+			id, mode, likes         int
+			parent                  sql.NullInt64
+			created                 float64
+			ip, text, author, email sql.NullString
+		)
+		if err := rows.Scan(&id, &parent, &created, &mode, &ip, &text, &author, &email, &likes); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if mode == issoDeleted {
+			continue
+		}
+		c := importedComment{SourceID: strconv.Itoa(id)}
+		if parent.Valid {
+			c.SourceParent = strconv.FormatInt(parent.Int64, 10)
+		}
+		c.Name = cmp.Or(author.String, "Anonymous")
+		c.Email = email.String
+		c.Text = text.String
+		c.IP = ip.String
+		c.Likes = likes
+		c.Created = time.UnixMicro(int64(created * 1e6)).UTC().Truncate(time.Second)
+		c.Status = "approved"
+		if mode == issoPending {
+			c.Status = "pending"
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+// commentoExport is the JSON Commento's "Export data" produces, gzipped or
+// not.
+type commentoExport struct {
+	Version  int `json:"version"`
+	Comments []struct {
+		CommentHex   string    `json:"commentHex"`
+		CommenterHex string    `json:"commenterHex"`
+		Markdown     string    `json:"markdown"`
+		ParentHex    string    `json:"parentHex"`
+		State        string    `json:"state"`
+		CreationDate time.Time `json:"creationDate"`
+		Deleted      bool      `json:"deleted"`
+	} `json:"comments"`
+	Commenters []struct {
+		CommenterHex string `json:"commenterHex"`
+		Email        string `json:"email"`
+		Name         string `json:"name"`
+	} `json:"commenters"`
+}
+
+// readCommento reads a Commento (or Commento++) export. Unapproved
+// comments become pending and comments Commento flagged as spam become
+// spam. Commento doesn't export IPs.
+func readCommento(path string) ([]importedComment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	var export commentoExport
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if export.Version != 1 {
+		return nil, fmt.Errorf("%s: unsupported Commento export version %d", path, export.Version)
+	}
+
+	commenters := make(map[string]int, len(export.Commenters))
+	for i, c := range export.Commenters {
+		commenters[c.CommenterHex] = i
+	}
+	var comments []importedComment
+	for _, ec := range export.Comments {
+		if ec.Deleted {
+			continue
+		}
+		c := importedComment{SourceID: ec.CommentHex}
+		if ec.ParentHex != "root" {
+			c.SourceParent = ec.ParentHex
+		}
+		c.Name = "Anonymous"
+		if i, ok := commenters[ec.CommenterHex]; ok {
+			c.Name = cmp.Or(export.Commenters[i].Name, c.Name)
+			c.Email = export.Commenters[i].Email
+		}
+		c.Text = ec.Markdown
+		c.Created = ec.CreationDate.UTC()
+		switch ec.State {
+		case "unapproved":
+			c.Status = "pending"
+		case "flagged":
+			c.Status = "spam"
+		default:
+			c.Status = "approved"
+		}
+		comments = append(comments, c)
+	}
+	return comments, nil
+}
+
+// htmlToText flattens the HTML other systems store comments as into the
+// plain text we store, keeping paragraphs and line breaks as newlines.
+func htmlToText(s string) string {
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			lines := strings.Split(b.String(), "\n")
+			for i, l := range lines {
+				lines[i] = strings.TrimSpace(l)
+			}
+			text := strings.Join(lines, "\n")
+			for strings.Contains(text, "\n\n\n") {
+				text = strings.ReplaceAll(text, "\n\n\n", "\n\n")
+			}
+			return strings.TrimSpace(text)
+		case html.TextToken:
+			b.Write(z.Text())
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if name, _ := z.TagName(); string(name) == "br" {
+				b.WriteString("\n")
+			}
+		case html.EndTagToken:
+			switch name, _ := z.TagName(); string(name) {
+			case "p", "div", "blockquote", "li", "pre":
+				b.WriteString("\n\n")
+			}
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const disqusDump = `<?xml version="1.0" encoding="utf-8"?>
+<disqus xmlns="http://disqus.com" xmlns:dsq="http://disqus.com/disqus-internals">
+  <thread dsq:id="100">
+    <link>https://example.com/guestbook</link>
+    <title>Guestbook</title>
+    <createdAt>2015-03-01T09:00:00Z</createdAt>
+  </thread>
+  <post dsq:id="3">
+    <message><![CDATA[<p>Thanks &amp; welcome!</p>]]></message>
+    <createdAt>2015-03-02T10:00:00Z</createdAt>
+    <isDeleted>false</isDeleted>
+    <isSpam>false</isSpam>
+    <author><email>owner@example.com</email><name>Owner</name><isAnonymous>false</isAnonymous></author>
+    <ipAddress>10.0.0.1</ipAddress>
+    <thread dsq:id="100"/>
+    <parent dsq:id="1"/>
+  </post>
+  <post dsq:id="1">
+    <message><![CDATA[<p>First line<br>second line</p><p>New paragraph</p>]]></message>
+    <createdAt>2015-03-01T10:00:00Z</createdAt>
+    <isDeleted>false</isDeleted>
+    <isSpam>false</isSpam>
+    <author><email>ann@example.com</email><name>Ann</name><isAnonymous>false</isAnonymous></author>
+    <ipAddress>192.0.2.1</ipAddress>
+    <thread dsq:id="100"/>
+  </post>
+  <post dsq:id="2">
+    <message><![CDATA[<p>Gone</p>]]></message>
+    <createdAt>2015-03-01T11:00:00Z</createdAt>
+    <isDeleted>true</isDeleted>
+    <isSpam>false</isSpam>
+    <author><name>Deleted</name></author>
+    <thread dsq:id="100"/>
+  </post>
+  <post dsq:id="4">
+    <message><![CDATA[<a href="http://spam.example">cheap</a>]]></message>
+    <createdAt>2015-03-03T10:00:00Z</createdAt>
+    <isDeleted>false</isDeleted>
+    <isSpam>true</isSpam>
+    <author><username>spammer99</username></author>
+    <thread dsq:id="100"/>
+    <parent dsq:id="2"/>
+  </post>
+</disqus>`
+
+func TestImportDisqus(t *testing.T) {
+	defer func(s CommentStore) { store = s }(store)
+	store = newMemoryStore()
+
+	path := filepath.Join(t.TempDir(), "disqus.xml")
+	if err := os.WriteFile(path, []byte(disqusDump), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := runImport([]string{"disqus", path}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "Imported 3 of 3 comments\n" {
+		t.Errorf("Output = %q", out.String())
+	}
+
+	got, err := store.List(t.Context(), CommentQuery{Sort: "oldest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("Imported %d comments: %+v", len(got), got)
+	}
+	ann, owner, spam := got[0], got[1], got[2]
+	if ann.Name != "Ann" || ann.IP != "192.0.2.1" || ann.Text != "First line\nsecond line\n\nNew paragraph" ||
+		!ann.Created.Equal(time.Date(2015, 3, 1, 10, 0, 0, 0, time.UTC)) || ann.ParentID != 0 {
+		t.Errorf("First comment = %+v", ann)
+	}
+	if owner.Text != "Thanks & welcome!" || owner.ParentID != ann.ID {
+		t.Errorf("Reply = %+v, want parent %d", owner, ann.ID)
+	}
+	// the parent was deleted, so the reply moves to the top level
+	if spam.Name != "spammer99" || spam.Status != "spam" || spam.Text != "cheap" || spam.ParentID != 0 {
+		t.Errorf("Spam = %+v", spam)
+	}
+}
+
+func TestImportIsso(t *testing.T) {
+	needSQLite(t)
+	defer func(s CommentStore) { store = s }(store)
+	store = newMemoryStore()
+
+	// Isso's own schema, trimmed to the columns that matter here
+	path := filepath.Join(t.TempDir(), "comments.db")
+	idb, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = idb.Exec(`
+		CREATE TABLE comments (
+			tid REFERENCES threads(id), id INTEGER PRIMARY KEY, parent INTEGER,
+			created FLOAT NOT NULL, modified FLOAT, mode INTEGER, remote_addr VARCHAR,
+			text VARCHAR, author VARCHAR, email VARCHAR, website VARCHAR,
+			likes INTEGER DEFAULT 0, dislikes INTEGER DEFAULT 0, voters BLOB NOT NULL,
+			notification INTEGER DEFAULT 0);
+		INSERT INTO comments (tid, id, parent, created, mode, remote_addr, text, author, email, likes, voters) VALUES
+			(1, 1, NULL, 1425200400.25, 1, '192.0.2.0', 'Hello *world*', 'Ann', 'ann@example.com', 2, ''),
+			(1, 2, 1, 1425204000.0, 2, '198.51.100.0', 'A reply', NULL, NULL, 0, ''),
+			(1, 3, NULL, 1425207600.0, 4, '', '', NULL, NULL, 0, '')`)
+	idb.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runImport([]string{"isso", path}, &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := store.List(t.Context(), CommentQuery{Sort: "oldest"})
+	if len(got) != 2 {
+		t.Fatalf("Imported %d comments: %+v", len(got), got)
+	}
+	if got[0].Name != "Ann" || got[0].Text != "Hello *world*" || got[0].Likes != 2 || got[0].IP != "192.0.2.0" ||
+		!got[0].Created.Equal(time.Date(2015, 3, 1, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("First comment = %+v", got[0])
+	}
+	if got[1].Name != "Anonymous" || got[1].Status != "pending" || got[1].ParentID != got[0].ID {
+		t.Errorf("Reply = %+v", got[1])
+	}
+}
+
+func TestImportCommento(t *testing.T) {
+	defer func(s CommentStore) { store = s }(store)
+	store = newMemoryStore()
+
+	export := `{"version": 1,
+		"comments": [
+			{"commentHex": "b2", "commenterHex": "anonymous", "markdown": "Me too", "parentHex": "a1", "state": "unapproved", "creationDate": "2019-06-02T10:00:00Z"},
+			{"commentHex": "a1", "commenterHex": "c1", "markdown": "Nice **site**", "parentHex": "root", "state": "approved", "creationDate": "2019-06-01T10:00:00Z"},
+			{"commentHex": "c3", "commenterHex": "c1", "markdown": "gone", "parentHex": "root", "state": "approved", "creationDate": "2019-06-03T10:00:00Z", "deleted": true},
+			{"commentHex": "d4", "commenterHex": "c2", "markdown": "buy now", "parentHex": "root", "state": "flagged", "creationDate": "2019-06-04T10:00:00Z"}
+		],
+		"commenters": [
+			{"commenterHex": "c1", "email": "bea@example.com", "name": "Bea"},
+			{"commenterHex": "c2", "email": "spam@example.com", "name": "Spammer"}
+		]}`
+	path := filepath.Join(t.TempDir(), "commento.json.gz")
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(export))
+	gz.Close()
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runImport([]string{"commento", path}, &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := store.List(t.Context(), CommentQuery{Sort: "oldest"})
+	if len(got) != 3 {
+		t.Fatalf("Imported %d comments: %+v", len(got), got)
+	}
+	if got[0].Name != "Bea" || got[0].Email != "bea@example.com" || got[0].Text != "Nice **site**" || got[0].Status != "approved" {
+		t.Errorf("First comment = %+v", got[0])
+	}
+	if got[1].Name != "Anonymous" || got[1].Status != "pending" || got[1].ParentID != got[0].ID {
+		t.Errorf("Reply = %+v", got[1])
+	}
+	if got[2].Status != "spam" {
+		t.Errorf("Flagged comment status = %q, want spam", got[2].Status)
+	}
+
+	os.WriteFile(path, []byte(`{"version": 2}`), 0o644)
+	if err := runImport([]string{"commento", path}, &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "version 2") {
+		t.Errorf("Import of version 2 = %v", err)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"Missing file argument", []string{"disqus"}, "usage"},
+		{"Unknown format", []string{"wordpress", "x.xml"}, "unknown import format"},
+		{"Missing file", []string{"disqus", filepath.Join(t.TempDir(), "nope.xml")}, "no such file"},
+		{"Site without a database", []string{"-site", "blog", "disqus", "x.xml"}, "sites need"},
+	}
+	old := db
+	db = nil
+	defer func() { db = old }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runImport(tt.args, &bytes.Buffer{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("runImport(%q) = %v, want %q", tt.args, err, tt.want)
+			}
+		})
+	}
+}
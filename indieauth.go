@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// indieAuthState is a sign-in in progress, see beginSignIn.
+type indieAuthState struct {
+	Me       string `json:"me"`
+	Endpoint string `json:"endpoint"`
+	Issuer   string `json:"issuer,omitempty"`
+	Verifier string `json:"verifier"`
+	Return   string `json:"return"`
+}
+
+// indieAuthClientID identifies the guestbook to authorization endpoints,
+// which show it to the visitor.
+func indieAuthClientID() string {
+	return strings.TrimSuffix(config.PublicURL, "/") + "/"
+}
+
+func indieAuthRedirectURI() string {
+	return indieAuthClientID() + "indieauth/callback"
+}
+
+// indieAuthLoginHandler starts signing a commenter in with the website in
+// ?me=, sending them to its authorization endpoint. ?return= is the page
+// to come back to.
+func indieAuthLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	me, err := profileURL(r.URL.Query().Get("me"))
+	if err != nil {
+		httpError(w, r, 400, codeSignInFailed, err.Error())
+		return
+	}
+	endpoint, issuer, err := authorizationEndpoint(r.Context(), me)
+	if err != nil {
+		requestLogger(r).Info("IndieAuth discovery failed", "me", me, "error", err)
+		httpError(w, r, 400, codeSignInFailed, "No IndieAuth authorization endpoint found at "+me)
+		return
+	}
+
+	st := indieAuthState{Me: me, Endpoint: endpoint, Issuer: issuer, Verifier: randomToken(), Return: localReturn(r.URL.Query().Get("return"))}
+	state, err := beginSignIn(w, r, "/indieauth", st)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+
+	u, _ := url.Parse(endpoint)
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", indieAuthClientID())
+	q.Set("redirect_uri", indieAuthRedirectURI())
+	q.Set("state", state)
+	q.Set("code_challenge", codeChallenge(st.Verifier))
+	q.Set("code_challenge_method", "S256")
+	q.Set("me", me)
+	u.RawQuery = q.Encode()
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// indieAuthCallbackHandler is where the authorization endpoint sends the
+// commenter back to. It redeems the code for the website it was issued
+// for and signs the commenter in.
+func indieAuthCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var st indieAuthState
+	if !finishSignIn(w, r, "/indieauth", &st) {
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("error") != "" {
+		requestLogger(r).Info("IndieAuth sign-in refused", "me", st.Me, "error", q.Get("error"))
+		httpError(w, r, 400, codeSignInFailed, "Sign-in was cancelled")
+		return
+	}
+	if st.Issuer != "" && q.Get("iss") != st.Issuer {
+		httpError(w, r, 400, codeSignInFailed, "Sign-in came back from the wrong server")
+		return
+	}
+	me, err := redeemIndieAuthCode(r.Context(), st, q.Get("code"))
+	if err != nil {
+		requestLogger(r).Info("IndieAuth sign-in failed", "me", st.Me, "error", err)
+		httpError(w, r, 400, codeSignInFailed, "Couldn't verify "+st.Me)
+		return
+	}
+	if err := startCommenterSession(w, r, commenterSession{Provider: "indieauth", Website: me}); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, st.Return, http.StatusSeeOther)
+}
+
+// redeemIndieAuthCode trades code for the profile URL the authorization
+// endpoint vouches for. That may differ from the one the visitor typed,
+// but then it has to use the same endpoint, so no server can claim
+// websites it doesn't authorize for.
+func redeemIndieAuthCode(ctx context.Context, st indieAuthState, code string) (string, error) {
+	if code == "" {
+		return "", errors.New("no code")
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"client_id":     {indieAuthClientID()},
+		"redirect_uri":  {indieAuthRedirectURI()},
+		"code_verifier": {st.Verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, st.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := publicClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := responseError(resp); err != nil {
+		return "", err
+	}
+	var body struct {
+		Me string `json:"me"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, webmentionMaxSource)).Decode(&body); err != nil {
+		return "", err
+	}
+	me, err := profileURL(body.Me)
+	if err != nil {
+		return "", err
+	}
+	if me == st.Me {
+		return me, nil
+	}
+	endpoint, _, err := authorizationEndpoint(ctx, me)
+	if err != nil {
+		return "", err
+	}
+	if endpoint != st.Endpoint {
+		return "", fmt.Errorf("%s uses %s, not %s", me, endpoint, st.Endpoint)
+	}
+	return me, nil
+}
+
+// profileURL normalizes a website a visitor typed in, "example.com"
+// becoming "https://example.com/".
+func profileURL(me string) (string, error) {
+	me = strings.TrimSpace(me)
+	if me == "" {
+		return "", errors.New("Enter the address of your website")
+	}
+	if !strings.Contains(me, "://") {
+		me = "https://" + me
+	}
+	u, err := url.Parse(me)
+	if err != nil || u.Scheme != "https" && u.Scheme != "http" || u.Hostname() == "" || u.User != nil || u.Fragment != "" {
+		return "", fmt.Errorf("%q isn't a website address", me)
+	}
+	if _, err := netip.ParseAddr(u.Hostname()); err == nil {
+		return "", fmt.Errorf("%q isn't a website address, use its domain name", me)
+	}
+	u.Host = strings.ToLower(u.Host)
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u.String(), nil
+}
+
+// authorizationEndpoint discovers the IndieAuth authorization endpoint of
+// the website me, from its server metadata or else its
+// rel="authorization_endpoint" link. issuer is empty without metadata.
+func authorizationEndpoint(ctx context.Context, me string) (endpoint, issuer string, err error) {
+	links, err := discoverLinks(ctx, me, "indieauth-metadata", "authorization_endpoint")
+	if err != nil {
+		return "", "", err
+	}
+	metadataURL, ok := links["indieauth-metadata"]
+	if !ok {
+		if endpoint, ok = links["authorization_endpoint"]; !ok {
+			return "", "", errors.New("no authorization endpoint")
+		}
+		return endpoint, "", nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataURL, nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := publicClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if err := responseError(resp); err != nil {
+		return "", "", err
+	}
+	var metadata struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, webmentionMaxSource)).Decode(&metadata); err != nil {
+		return "", "", err
+	}
+	u, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil || u.Scheme != "https" && u.Scheme != "http" {
+		return "", "", fmt.Errorf("authorization endpoint %q isn't an http or https URL", metadata.AuthorizationEndpoint)
+	}
+	return u.String(), metadata.Issuer, nil
+}
+
+// codeChallenge is the PKCE S256 challenge of verifier.
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
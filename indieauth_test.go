@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestProfileURL(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"example.com", "https://example.com/"},
+		{" https://Example.com/ana ", "https://example.com/ana"},
+		{"http://example.com", "http://example.com/"},
+		{"", ""},
+		{"ftp://example.com/", ""},
+		{"https://ana@example.com/", ""},
+		{"https://example.com/#me", ""},
+		{"https://192.0.2.1/", ""},
+	}
+	for _, tt := range tests {
+		got, err := profileURL(tt.in)
+		if got != tt.want || (err != nil) != (tt.want == "") {
+			t.Errorf("profileURL(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestLocalReturn(t *testing.T) {
+	for ref, want := range map[string]string{
+		"/guestbook?site=blog": "/guestbook?site=blog",
+		"":                     "/",
+		"//evil.example/":      "/",
+		"/\\evil.example/":     "/",
+		"https://evil.example": "/",
+	} {
+		if got := localReturn(ref); got != want {
+			t.Errorf("localReturn(%q) = %q, want %q", ref, got, want)
+		}
+	}
+}
+
+func TestIndieAuth(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.IndieAuth = true
+	config.PublicURL = "https://guestbook.example"
+	config.MultiTenant = false
+	config.CSRFMode = "api"
+	defer func(s CommentStore) { store = s }(store)
+	store = newMemoryStore()
+
+	// alice.example.com delegates to an authorization endpoint on
+	// auth.example.com, which only vouches for her
+	var challenge string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Host + r.URL.Path {
+		case "alice.example.com/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<link rel="authorization_endpoint" href="https://auth.example.com/auth">`))
+		case "auth.example.com/auth":
+			sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+			if r.PostFormValue("code") != "good" || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge ||
+				r.PostFormValue("client_id") != "https://guestbook.example/" || r.PostFormValue("redirect_uri") != "https://guestbook.example/indieauth/callback" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"me": "https://alice.example.com/"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	defer func(c *http.Client) { publicClient = c }(publicClient)
+	publicClient = srv.Client()
+	publicClient.Transport.(*http.Transport).DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+	}
+
+	rec := httptest.NewRecorder()
+	indieAuthLoginHandler(rec, httptest.NewRequest("GET", "/indieauth/login?me=alice.example.com&return=/guestbook", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Login = %d: %s", rec.Code, rec.Body.String())
+	}
+	to, _ := url.Parse(rec.Header().Get("Location"))
+	q := to.Query()
+	if to.Host != "auth.example.com" || q.Get("me") != "https://alice.example.com/" || q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("Login redirects to %s", to)
+	}
+	challenge = q.Get("code_challenge")
+	stateCookie := rec.Result().Cookies()[0]
+
+	callback := func(query string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/indieauth/callback?"+query, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		indieAuthCallbackHandler(rec, req)
+		return rec
+	}
+	if rec := callback("code=good&state=" + q.Get("state")); rec.Code != 400 || rec.Header().Get("X-Error-Code") != codeSignInFailed {
+		t.Errorf("Callback without the state cookie = %d", rec.Code)
+	}
+	rec = callback("code=good&state="+q.Get("state"), stateCookie)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/guestbook" {
+		t.Fatalf("Callback = %d to %q: %s", rec.Code, rec.Header().Get("Location"), rec.Body.String())
+	}
+	var session *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == commenterCookie {
+			session = c
+		}
+	}
+	if session == nil {
+		t.Fatal("Callback didn't sign in")
+	}
+	if rec := callback("code=good&state="+q.Get("state"), stateCookie); rec.Code != 400 {
+		t.Errorf("Second callback with the same state = %d, want 400", rec.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(session)
+	rec = httptest.NewRecorder()
+	guestbookPageHandler(rec, req)
+	if body := rec.Body.String(); !strings.Contains(body, `Signed in as <a href="https://alice.example.com/"`) {
+		t.Errorf("Page doesn't show the sign-in:\n%s", body)
+	}
+
+	form := url.Values{"name": {"Alice"}, "email": {"alice@example.com"}, "comment": {"Hi"}}
+	req = httptest.NewRequest("POST", "/comments", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(session)
+	rec = httptest.NewRecorder()
+	addComment(rec, req)
+	got, _ := store.List(t.Context(), CommentQuery{})
+	if rec.Code != 201 || len(got) != 1 || got[0].Website != "https://alice.example.com/" || !got[0].Authenticated {
+		t.Errorf("Comment while signed in = %d, stored %+v", rec.Code, got)
+	}
+
+	rec = httptest.NewRecorder()
+	guestbookPageHandler(rec, httptest.NewRequest("GET", "/", nil))
+	if body := rec.Body.String(); !strings.Contains(body, `<a href="https://alice.example.com/" rel="nofollow noopener ugc">Alice</a></b> <span class="verified"`) {
+		t.Errorf("Page doesn't link the verified website:\n%s", body)
+	}
+}
+
+func TestApproveAuthenticated(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	defer func(s CommentStore) { store = s }(store)
+	store = newMemoryStore()
+	site := &Site{Slug: "held", Moderation: "pending"}
+	log := slog.New(slog.DiscardHandler)
+
+	alice := &commenterSession{Provider: "indieauth", Website: "https://alice.example.com/"}
+	for i, tt := range []struct {
+		approve   bool
+		commenter *commenterSession
+		want      string
+	}{
+		{false, alice, "pending"},
+		{true, nil, "pending"},
+		{true, alice, "approved"},
+	} {
+		config.ApproveAuthenticated = tt.approve
+		c, _, err := submitComment(t.Context(), log, site, commentInput{Name: "Alice", Email: "alice@example.com", Text: "Hi " + strconv.Itoa(i), IP: "192.0.2.1", Commenter: tt.commenter})
+		if err != nil || c.Status != tt.want || c.Authenticated != (tt.commenter != nil) {
+			t.Errorf("approve_authenticated %v signed in %v: %+v, %v", tt.approve, tt.commenter != nil, c, err)
+		}
+	}
+}
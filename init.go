@@ -0,0 +1,97 @@
+package main
+
+import (
+	_ "embed"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultConfigFile is the commented example config shipped with the repo,
+// written out by `guestbook init`.
+//
+//go:embed config.toml
+var defaultConfigFile []byte
+
+// runInit implements `guestbook init [-force]`: it writes a default config
+// unless one exists, then creates the log directories and the database
+// with its schema, so a fresh install is ready to start.
+func runInit(args []string, path string, out io.Writer) error {
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	force := fs.Bool("force", false, "overwrite an existing config file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if path == "" {
+		path = "config.toml"
+	}
+	if !strings.EqualFold(filepath.Ext(path), ".toml") {
+		return fmt.Errorf("init writes TOML, use a .toml path instead of %s", path)
+	}
+	if fileExists(path) && !*force {
+		fmt.Fprintf(out, "Keeping existing %s (use -force to overwrite)\n", path)
+	} else {
+		if err := os.WriteFile(path, defaultConfigFile, 0o644); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Wrote %s\n", path)
+	}
+
+	c, err := loadConfig(path, os.LookupEnv)
+	if err != nil {
+		return err
+	}
+	var dirs []string
+	if c.DBDriver == "sqlite3" || c.DBDriver == "bbolt" {
+		dirs = append(dirs, filepath.Dir(c.DBPath))
+	}
+	if c.LogOutput == "file" {
+		dirs = append(dirs, filepath.Dir(c.LogPath))
+	}
+	if c.AccessLogPath != "" {
+		dirs = append(dirs, filepath.Dir(c.AccessLogPath))
+	}
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	if err := validateConfig(c); err != nil {
+		return err
+	}
+
+	config = c
+	if c.DBDriver == "memory" {
+		fmt.Fprintln(out, "Comments are kept in memory, no database to create")
+		fmt.Fprintln(out, "Run guestbook to start the server")
+		return nil
+	}
+	if c.DBDriver == "bbolt" {
+		bs, err := openBoltStore(c.DBPath)
+		if err != nil {
+			return err
+		}
+		bs.Close()
+		fmt.Fprintf(out, "Created database %s\n", c.DBPath)
+		fmt.Fprintln(out, "Run guestbook to start the server")
+		return nil
+	}
+	if db, err = openDB(); err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := initSchema(); err != nil {
+		return err
+	}
+	if c.DBDriver == "mysql" {
+		fmt.Fprintln(out, "Created tables in the MySQL database")
+	} else {
+		fmt.Fprintf(out, "Created database %s\n", c.DBPath)
+	}
+	fmt.Fprintln(out, "Run guestbook to start the server")
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunInit(t *testing.T) {
+	needSQLite(t)
+	defer func(c Config, d *sql.DB) { config, db = c, d }(config, db)
+	t.Chdir(t.TempDir())
+	t.Setenv("GUESTBOOK_DB_PATH", "data/guestbook.db")
+	t.Setenv("GUESTBOOK_LOG_PATH", "logs/guestbook.log")
+	t.Setenv("GUESTBOOK_ACCESS_LOG_PATH", "logs/access.log")
+
+	var out bytes.Buffer
+	if err := runInit(nil, "", &out); err != nil {
+		t.Fatal(err)
+	}
+	written, err := os.ReadFile("config.toml")
+	if err != nil || !bytes.Equal(written, defaultConfigFile) {
+		t.Errorf("config.toml not written: %v", err)
+	}
+	if !fileExists("logs") {
+		t.Error("log directory not created")
+	}
+
+	check, err := sql.Open("sqlite3", filepath.Join("data", "guestbook.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer check.Close()
+	var n int
+	if err := check.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name IN ('comments', 'likes', 'blocklist')`).Scan(&n); err != nil || n != 3 {
+		t.Errorf("Schema not created: %d tables, %v", n, err)
+	}
+
+	// a second run keeps the edited config but is otherwise harmless
+	os.WriteFile("config.toml", []byte("port = 8080\n"), 0o644)
+	out.Reset()
+	if err := runInit(nil, "", &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Keeping existing config.toml") {
+		t.Errorf("Output = %q", out.String())
+	}
+	if data, _ := os.ReadFile("config.toml"); string(data) != "port = 8080\n" {
+		t.Errorf("Existing config overwritten: %q", data)
+	}
+
+	if err := runInit([]string{"-force"}, "", &out); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile("config.toml"); !bytes.Equal(data, defaultConfigFile) {
+		t.Error("-force didn't overwrite the config")
+	}
+}
+
+func TestRunInitRejectsOtherFormats(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := runInit(nil, "config.yaml", &bytes.Buffer{}); err == nil {
+		t.Error("runInit(config.yaml) succeeded, want error")
+	}
+	if fileExists("config.yaml") {
+		t.Error("config.yaml was written")
+	}
+}
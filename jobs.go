@@ -0,0 +1,397 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// job is a task the scheduler runs on its schedule. run returns a short
+// summary of what it did for GET /admin/jobs.
+type job struct {
+	name     string
+	schedule string
+	run      func(ctx context.Context) (string, error)
+}
+
+// scheduledJobs returns the jobs c turns on.
+func scheduledJobs(c Config) []job {
+	var jobs []job
+	if spec := backupSchedule(c); spec != "" {
+		jobs = append(jobs, job{"backup", spec, backupJob})
+	}
+	if c.SpamRetentionDays > 0 || c.PendingRetentionDays > 0 || c.RequestLogRetentionDays > 0 || c.BotHitRetentionDays > 0 {
+		jobs = append(jobs, job{"purge", c.PurgeSchedule, purgeJob})
+	}
+	if c.ArchiveAfterYears > 0 {
+		jobs = append(jobs, job{"archive", c.ArchiveSchedule, archiveJob})
+	}
+	if c.DigestSchedule != "" && c.NotifyEmail != "" {
+		jobs = append(jobs, job{"digest", c.DigestSchedule, digestJob})
+	}
+	return jobs
+}
+
+// backupSchedule is backup_schedule, or backup_interval_hours as a
+// schedule for configs from before there were schedules.
+func backupSchedule(c Config) string {
+	if c.BackupSchedule == "" && c.BackupIntervalHours > 0 {
+		return fmt.Sprintf("@every %dh", c.BackupIntervalHours)
+	}
+	return c.BackupSchedule
+}
+
+// JobRun is the outcome of the last run of a job.
+type JobRun struct {
+	Started  time.Time `json:"started"`
+	Duration float64   `json:"duration_seconds"`
+	Result   string    `json:"result,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// JobStatus is what GET /admin/jobs reports for a job.
+type JobStatus struct {
+	Name     string    `json:"name"`
+	Schedule string    `json:"schedule"`
+	Next     time.Time `json:"next_run"`
+	LastRun  *JobRun   `json:"last_run"`
+}
+
+// scheduler is what the running jobs report back, for the status endpoint.
+var scheduler struct {
+	sync.Mutex
+	jobs []job
+	next map[string]time.Time
+}
+
+// runJobs runs every job on its schedule until ctx is done.
+func runJobs(ctx context.Context, jobs []job) {
+	scheduler.Lock()
+	scheduler.jobs, scheduler.next = jobs, map[string]time.Time{}
+	scheduler.Unlock()
+	for _, j := range jobs {
+		go runJob(ctx, j)
+	}
+}
+
+// runJob waits for each time j is due, plus up to job_jitter_seconds so a
+// fleet of instances doesn't hit the database at the same moment, and runs
+// it unless another instance sharing redis_url got there first.
+func runJob(ctx context.Context, j job) {
+	sched, err := parseSchedule(j.schedule)
+	if err != nil {
+		logger.Error("Job not scheduled", "job", j.name, "error", err)
+		return
+	}
+	for {
+		next := sched.next(time.Now())
+		if next.IsZero() {
+			return
+		}
+		scheduler.Lock()
+		scheduler.next[j.name] = next
+		scheduler.Unlock()
+
+		// the jitter mustn't push a run past the one after it
+		period := max(sched.next(next).Sub(next), time.Minute)
+		var jitter time.Duration
+		if n := min(time.Duration(settings().JobJitterSeconds)*time.Second, period/2); n > 0 {
+			jitter = rand.N(n)
+		}
+		timer := time.NewTimer(time.Until(next) + jitter)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		key := "job:" + j.name + ":" + strconv.FormatInt(next.Unix(), 10)
+		claimed, err := shared.SetNX(ctx, key, []byte("1"), period)
+		if err != nil {
+			// better twice than not at all
+			logger.Warn("Couldn't claim job, running it anyway", "job", j.name, "error", err)
+			claimed = true
+		}
+		if claimed {
+			runJobNow(ctx, j)
+		}
+	}
+}
+
+// runJobNow runs j and records how it went where every instance can see it.
+func runJobNow(ctx context.Context, j job) JobRun {
+	start := time.Now()
+	result, err := j.run(ctx)
+	run := JobRun{Started: start.UTC(), Duration: time.Since(start).Seconds(), Result: result}
+	if err != nil {
+		run.Error = err.Error()
+		logger.Error("Job failed", "job", j.name, "result", result, "error", err, "duration", time.Since(start))
+	} else {
+		logger.Info("Job finished", "job", j.name, "result", result, "duration", time.Since(start))
+	}
+	b, _ := json.Marshal(run)
+	if err := shared.Set(ctx, "job-status:"+j.name, b, 0); err != nil {
+		logger.Warn("Couldn't record job status", "job", j.name, "error", err)
+	}
+	return run
+}
+
+// jobsHandler lists the scheduled jobs with their next and last run.
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	scheduler.Lock()
+	statuses := make([]JobStatus, len(scheduler.jobs))
+	for i, j := range scheduler.jobs {
+		statuses[i] = JobStatus{Name: j.name, Schedule: j.schedule, Next: scheduler.next[j.name]}
+	}
+	scheduler.Unlock()
+
+	for i := range statuses {
+		b, ok, err := shared.Get(r.Context(), "job-status:"+statuses[i].Name)
+		if err != nil {
+			internalError(w, r, err)
+			return
+		}
+		if ok {
+			json.Unmarshal(b, &statuses[i].LastRun)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statuses)
+}
+
+func backupJob(ctx context.Context) (string, error) {
+	b, err := createBackup(ctx)
+	if err != nil {
+		return "", err
+	}
+	result := fmt.Sprintf("created %s (%d bytes)", b.Name, b.Size)
+	if b.UploadError != "" {
+		return result, errors.New("upload failed: " + b.UploadError)
+	}
+	return result, nil
+}
+
+// purgeJob deletes spam and pending comments older than
+// spam_retention_days and pending_retention_days, on every site that isn't
+// archived. Unconfirmed comments go with the pending ones. It also deletes
+// bot hits older than bot_hit_retention_days and request_log rows older
+// than request_log_retention_days.
+func purgeJob(ctx context.Context) (string, error) {
+	cfg := settings()
+	siteIDs, err := activeSiteIDs(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	deleted := 0
+	for _, rule := range []struct {
+		status string
+		days   int
+	}{{"spam", cfg.SpamRetentionDays}, {"pending", cfg.PendingRetentionDays}, {"unconfirmed", cfg.PendingRetentionDays}} {
+		if rule.days <= 0 {
+			continue
+		}
+		until := time.Now().AddDate(0, 0, -rule.days)
+		for _, id := range siteIDs {
+			comments, err := bulkSelect(ctx, id, []string{rule.status}, until)
+			if err != nil {
+				return fmt.Sprintf("deleted %d comments", deleted), err
+			}
+			for _, c := range comments {
+				if err := store.Delete(ctx, id, c.ID); err != nil && err != errNotFound {
+					return fmt.Sprintf("deleted %d comments", deleted), err
+				}
+				deleted++
+			}
+		}
+	}
+	result := fmt.Sprintf("deleted %d comments", deleted)
+	if cfg.BotHitRetentionDays > 0 {
+		n, err := store.DeleteBotHits(ctx, time.Now().AddDate(0, 0, -cfg.BotHitRetentionDays))
+		result += fmt.Sprintf(", %d bot hits", n)
+		if err != nil {
+			return result, err
+		}
+	}
+	if cfg.RequestLogRetentionDays > 0 && db != nil {
+		n, err := purgeRequestLog(ctx, cfg.RequestLogRetentionDays)
+		result += fmt.Sprintf(", %d request log entries", n)
+		return result, err
+	}
+	return result, nil
+}
+
+// archiveJob moves comments older than archive_after_years into the
+// archive on every site that isn't archived.
+func archiveJob(ctx context.Context) (string, error) {
+	siteIDs, err := activeSiteIDs(ctx)
+	if err != nil {
+		return "", err
+	}
+	before := time.Now().AddDate(-settings().ArchiveAfterYears, 0, 0)
+	archived := 0
+	for _, id := range siteIDs {
+		n, err := store.Archive(ctx, id, before)
+		archived += n
+		if err != nil {
+			return fmt.Sprintf("archived %d comments", archived), err
+		}
+	}
+	return fmt.Sprintf("archived %d comments", archived), nil
+}
+
+// activeSiteIDs returns the default site and every site that isn't
+// archived, for jobs that go through all of them.
+func activeSiteIDs(ctx context.Context) ([]int, error) {
+	siteIDs := []int{defaultSite.ID}
+	if db != nil {
+		sites, err := listSites(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range sites {
+			if !s.Archived {
+				siteIDs = append(siteIDs, s.ID)
+			}
+		}
+	}
+	return siteIDs, nil
+}
+
+// schedule is when a job runs: either every so often, or at the minutes,
+// hours, days, months and weekdays of a cron expression, in UTC.
+type schedule struct {
+	every                         time.Duration
+	minute, hour, dom, month, dow uint64
+	// cron fires on a day that matches either the day of the month or
+	// the weekday, unless one of them is *
+	anyDOM, anyDOW bool
+}
+
+var scheduleMacros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// parseSchedule reads a cron expression like "30 3 * * *", one of
+// scheduleMacros or "@every 6h".
+func parseSchedule(spec string) (*schedule, error) {
+	s := &schedule{}
+	if d, ok := strings.CutPrefix(spec, "@every "); ok {
+		every, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil || every < time.Minute {
+			return nil, fmt.Errorf("schedule %q needs a duration of at least 1m", spec)
+		}
+		s.every = every
+		return s, nil
+	}
+	fields := strings.Fields(cmp.Or(scheduleMacros[spec], spec))
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q must be five cron fields, @hourly, @daily, @weekly, @monthly or @every <duration>", spec)
+	}
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	sets := [5]*uint64{&s.minute, &s.hour, &s.dom, &s.month, &s.dow}
+	for i, f := range fields {
+		bits, err := parseCronField(f, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %w", spec, err)
+		}
+		*sets[i] = bits
+	}
+	// 7 is Sunday too
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.anyDOM, s.anyDOW = fields[2] == "*", fields[4] == "*"
+	if s.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("schedule %q never runs", spec)
+	}
+	return s, nil
+}
+
+// parseCronField reads a comma separated list of *, numbers and ranges,
+// each optionally with a /step, into a set of bits between lo and hi.
+func parseCronField(f string, lo, hi int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(f, ",") {
+		r, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step < 1 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+		}
+		from, to := lo, hi
+		if r != "*" {
+			a, b, isRange := strings.Cut(r, "-")
+			var err1, err2 error
+			from, err1 = strconv.Atoi(a)
+			to, err2 = from, nil
+			if isRange {
+				to, err2 = strconv.Atoi(b)
+			} else if hasStep {
+				to = hi
+			}
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("%q is not a number or range", part)
+			}
+		}
+		if from < lo || to > hi || from > to {
+			return 0, fmt.Errorf("%q is outside %d-%d", part, lo, hi)
+		}
+		for v := from; v <= to; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// next returns the first time after t the schedule is due, or the zero
+// time if it never is.
+func (s *schedule) next(t time.Time) time.Time {
+	if s.every > 0 {
+		// aligned to the clock, so every instance agrees on the time
+		return t.Truncate(s.every).Add(s.every)
+	}
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		switch {
+		case s.month&(1<<t.Month()) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case s.hour&(1<<t.Hour()) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s *schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<t.Weekday()) != 0
+	if s.anyDOM || s.anyDOW {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	from := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC) // a Wednesday
+	tests := []struct {
+		spec string
+		want []string
+	}{
+		{"@hourly", []string{"2024-05-01T13:00:00Z", "2024-05-01T14:00:00Z"}},
+		{"@daily", []string{"2024-05-02T00:00:00Z", "2024-05-03T00:00:00Z"}},
+		{"30 3 * * *", []string{"2024-05-02T03:30:00Z", "2024-05-03T03:30:00Z"}},
+		{"*/20 9-17 * * 1-5", []string{"2024-05-01T12:40:00Z", "2024-05-01T13:00:00Z"}},
+		{"0 0 * * 0", []string{"2024-05-05T00:00:00Z", "2024-05-12T00:00:00Z"}},
+		{"0 0 * * 7", []string{"2024-05-05T00:00:00Z", "2024-05-12T00:00:00Z"}},
+		// a day of the month or a weekday when both are given
+		{"0 0 13 * 5", []string{"2024-05-03T00:00:00Z", "2024-05-10T00:00:00Z", "2024-05-13T00:00:00Z"}},
+		{"0 0 29 2 *", []string{"2028-02-29T00:00:00Z"}},
+		{"@every 6h", []string{"2024-05-01T18:00:00Z", "2024-05-02T00:00:00Z"}},
+	}
+	for _, tt := range tests {
+		s, err := parseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("parseSchedule(%q) = %v", tt.spec, err)
+			continue
+		}
+		at := from
+		for _, want := range tt.want {
+			at = s.next(at)
+			if got := at.UTC().Format(time.RFC3339); got != want {
+				t.Errorf("%q after %v = %s, want %s", tt.spec, from, got, want)
+				break
+			}
+		}
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@every 30s", "@every soon", "@yearly", "0 0 31 4 *"} {
+		if _, err := parseSchedule(spec); err == nil {
+			t.Errorf("parseSchedule(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestJobs(t *testing.T) {
+	defer func(c Config, s CommentStore, st SharedState) { config, store, shared = c, s, st }(config, store, shared)
+	store = newMemoryStore()
+	shared = newMemoryState()
+	config.SpamRetentionDays, config.PurgeSchedule = 30, "@daily"
+	ctx := context.Background()
+
+	old := time.Now().AddDate(0, 0, -31)
+	for _, c := range []*Comment{
+		{Name: "Old spam", Status: "spam", Created: old},
+		{Name: "New spam", Status: "spam"},
+		{Name: "Old pending", Status: "pending", Created: old},
+	} {
+		if err := store.Create(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	jobs := scheduledJobs(config)
+	if len(jobs) != 1 || jobs[0].name != "purge" {
+		t.Fatalf("scheduledJobs() = %+v", jobs)
+	}
+	jobs = append(jobs, job{"broken", "@hourly", func(context.Context) (string, error) { return "", errors.New("boom") }})
+	scheduler.Lock()
+	scheduler.jobs, scheduler.next = jobs, map[string]time.Time{"purge": time.Now().Add(time.Hour)}
+	scheduler.Unlock()
+	for _, j := range jobs {
+		runJobNow(ctx, j)
+	}
+	if n, _ := store.Count(ctx, CommentQuery{}); n != 2 {
+		t.Errorf("%d comments left after the purge, want 2", n)
+	}
+
+	rec := httptest.NewRecorder()
+	jobsHandler(rec, httptest.NewRequest("GET", "/admin/jobs", nil))
+	var statuses []JobStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &statuses); err != nil || len(statuses) != 2 {
+		t.Fatalf("GET /admin/jobs = %d: %s", rec.Code, rec.Body.String())
+	}
+	if s := statuses[0]; s.Name != "purge" || s.Next.IsZero() || s.LastRun == nil || s.LastRun.Result != "deleted 1 comments" || s.LastRun.Error != "" {
+		t.Errorf("Purge status = %+v %+v", s, s.LastRun)
+	}
+	if s := statuses[1]; s.LastRun == nil || s.LastRun.Error != "boom" {
+		t.Errorf("Failed job status = %+v", s)
+	}
+}
+
+func TestBackupSchedule(t *testing.T) {
+	for _, tt := range []struct {
+		c    Config
+		want string
+	}{
+		{Config{}, ""},
+		{Config{BackupIntervalHours: 6}, "@every 6h"},
+		{Config{BackupSchedule: "30 3 * * *"}, "30 3 * * *"},
+	} {
+		if got := backupSchedule(tt.c); got != tt.want {
+			t.Errorf("backupSchedule(%+v) = %q, want %q", tt.c, got, tt.want)
+		}
+	}
+}
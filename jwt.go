@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// jwtHeader is the part of a JWT's header the guestbook reads.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// jwtClaims are the claims of a JWT. Which ones matter, and their types,
+// depends on who issued it.
+type jwtClaims map[string]any
+
+func (c jwtClaims) str(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// values reads a claim that is a string or a list of strings, like aud or
+// groups.
+func (c jwtClaims) values(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var values []string
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// time reads a NumericDate claim like exp, zero if it's missing.
+func (c jwtClaims) time(name string) time.Time {
+	n, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(int64(n), 0)
+}
+
+// jwtLeeway is how far clocks may be apart when checking exp, nbf and iat.
+const jwtLeeway = time.Minute
+
+// checkTimes checks that the token has an exp that isn't over, and that
+// nbf and iat, if present, aren't in the future.
+func (c jwtClaims) checkTimes(now time.Time) error {
+	exp := c.time("exp")
+	if exp.IsZero() || now.After(exp.Add(jwtLeeway)) {
+		return errors.New("token expired")
+	}
+	for _, name := range []string{"nbf", "iat"} {
+		if t := c.time(name); !t.IsZero() && t.After(now.Add(jwtLeeway)) {
+			return fmt.Errorf("token %s is in the future", name)
+		}
+	}
+	return nil
+}
+
+// hasAudience reports whether aud is, or includes, audience.
+func (c jwtClaims) hasAudience(audience string) bool {
+	return slices.Contains(c.values("aud"), audience)
+}
+
+// splitJWT decodes a compact JWT without verifying it, returning the part
+// that is signed and the signature for the caller to check.
+func splitJWT(token string) (h jwtHeader, claims jwtClaims, signed string, sig []byte, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return h, nil, "", nil, errors.New("malformed JWT")
+	}
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(header, &h) != nil {
+		return h, nil, "", nil, errors.New("malformed JWT header")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(payload, &claims) != nil || claims == nil {
+		return h, nil, "", nil, errors.New("malformed JWT claims")
+	}
+	if sig, err = base64.RawURLEncoding.DecodeString(parts[2]); err != nil {
+		return h, nil, "", nil, errors.New("malformed JWT signature")
+	}
+	return h, claims, parts[0] + "." + parts[1], sig, nil
+}
+
+// signJWT signs claims with HS256, which is what the guestbook's own
+// tokens use.
+func signJWT(key []byte, claims jwtClaims) (string, error) {
+	header, _ := json.Marshal(jwtHeader{Alg: "HS256", Typ: "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(hs256(key, signed)), nil
+}
+
+func hs256(key []byte, signed string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signed))
+	return mac.Sum(nil)
+}
+
+// verifyJWTSignature checks sig over signed with key, for the RS256 and
+// ES256 algorithms OpenID Connect providers sign with, and HS256 with a
+// []byte secret.
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	sum := sha256.Sum256([]byte(signed))
+	switch k := key.(type) {
+	case []byte:
+		if alg != "HS256" {
+			break
+		}
+		if !hmac.Equal(sig, hs256(k, signed)) {
+			return errors.New("invalid HS256 signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			break
+		}
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], sig)
+	case *ecdsa.PublicKey:
+		if alg != "ES256" || k.Curve != elliptic.P256() {
+			break
+		}
+		if len(sig) != 64 || !ecdsa.Verify(k, sum[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+			return errors.New("invalid ES256 signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported JWT algorithm %q for the key", alg)
+}
+
+// jsonWebKey is a public key from a JWK Set (RFC 7517).
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey returns the RSA or P-256 key k describes.
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+		if err1 != nil || err2 != nil || len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid RSA key %q", k.Kid)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+		y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+		if k.Crv != "P-256" || err1 != nil || err2 != nil || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("invalid or unsupported EC key %q", k.Kid)
+		}
+		// ecdh checks the point is on the curve
+		if _, err := ecdh.P256().NewPublicKey(slices.Concat([]byte{4}, x, y)); err != nil {
+			return nil, fmt.Errorf("invalid EC key %q: %w", k.Kid, err)
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
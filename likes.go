@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// likeHandler records a like for ?id=N. Each IP can like a comment once,
+// repeated likes are accepted but don't count again.
+func likeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		httpError(w, r, 400, codeInvalidID, "Query parameter id must be a comment id")
+		return
+	}
+
+	likes, err := store.Like(r.Context(), siteFor(r).ID, id, getIP(r))
+	if err == errNotFound {
+		httpError(w, r, http.StatusNotFound, codeNotFound, "Comment not found")
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"id": id, "likes": likes})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestLikeHandler(t *testing.T) {
+	needSQLite(t)
+	_, err := db.Exec("DELETE FROM comments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := db.Exec("INSERT INTO comments (name, email, text, ip, location) VALUES (?, ?, ?, ?, ?)",
+		"Test", "test@example.com", "Like me", "127.0.0.1", "Localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+
+	tests := []struct {
+		name           string
+		method         string
+		id             string
+		remoteAddr     string
+		expectedStatus int
+		expectedLikes  int
+	}{
+		{"First like", "POST", strconv.FormatInt(id, 10), "1.1.1.1:1234", 200, 1},
+		{"Same IP again", "POST", strconv.FormatInt(id, 10), "1.1.1.1:4321", 200, 1},
+		{"Another IP", "POST", strconv.FormatInt(id, 10), "2.2.2.2:1234", 200, 2},
+		{"Unknown comment", "POST", "999999", "1.1.1.1:1234", 404, 0},
+		{"Bad id", "POST", "abc", "1.1.1.1:1234", 400, 0},
+		{"GET not allowed", "GET", strconv.FormatInt(id, 10), "1.1.1.1:1234", 405, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/like?id="+tt.id, nil)
+			req.RemoteAddr = tt.remoteAddr
+			recorder := httptest.NewRecorder()
+
+			likeHandler(recorder, req)
+
+			if recorder.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+			if tt.expectedStatus != 200 {
+				return
+			}
+
+			var body map[string]int
+			if err := json.NewDecoder(recorder.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body["likes"] != tt.expectedLikes {
+				t.Errorf("Expected %d likes, got %d", tt.expectedLikes, body["likes"])
+			}
+		})
+	}
+}
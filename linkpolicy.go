@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The link policy refuses comments with more than max_links links, links
+// to link_blocklist domains or, with a link_allowlist, to any other
+// domain, and, with safe_browsing_api_key, links Google Safe Browsing
+// knows as malware or phishing. Unlike the spam checks it doesn't score:
+// a comment that breaks it is refused.
+
+// safeBrowsingURL is the Safe Browsing Lookup API.
+var safeBrowsingURL = "https://safebrowsing.googleapis.com/v4/threatMatches:find"
+
+// safeBrowsingClient calls safeBrowsingURL. A comment waits for it, so it
+// gives up sooner than the other clients.
+var safeBrowsingClient = &http.Client{Timeout: 5 * time.Second, Transport: breakerTransport{http.DefaultTransport}}
+
+// checkLinks applies the link policy of c to text, returning an *apiError
+// for the first rule it breaks. When Safe Browsing can't be asked the
+// links are let through and the failure logged.
+func checkLinks(ctx context.Context, log *slog.Logger, c Config, text string) error {
+	urls := findURLs(text)
+	if len(urls) == 0 {
+		return nil
+	}
+	if n := len(urlPattern.FindAllString(text, -1)); c.MaxLinks > 0 && n > c.MaxLinks {
+		return &apiError{status: 400, code: codeTooManyLinks, message: fmt.Sprintf("A comment can have at most %d links", c.MaxLinks), details: map[string]any{"max_links": c.MaxLinks}}
+	}
+	for _, u := range urls {
+		p, err := url.Parse(u)
+		host := ""
+		if err == nil {
+			host = strings.ToLower(p.Hostname())
+		}
+		if inDomains(host, c.LinkBlocklist) || len(c.LinkAllowlist) > 0 && !inDomains(host, c.LinkAllowlist) {
+			return &apiError{status: http.StatusForbidden, code: codeLinkNotAllowed, message: "Links to " + host + " aren't allowed", details: map[string]any{"url": u}}
+		}
+	}
+	if c.SafeBrowsingAPIKey == "" {
+		return nil
+	}
+	unsafe, err := safeBrowsingMatches(ctx, c.SafeBrowsingAPIKey, urls)
+	if err != nil {
+		log.Warn("Safe Browsing lookup failed", "error", err)
+		return nil
+	}
+	if len(unsafe) > 0 {
+		log.Info("comment links to unsafe pages", "urls", unsafe)
+		return &apiError{status: http.StatusForbidden, code: codeLinkNotAllowed, message: "The comment links to an unsafe page", details: map[string]any{"url": unsafe[0]}}
+	}
+	return nil
+}
+
+// inDomains reports whether host is one of domains or a subdomain of one.
+func inDomains(host string, domains []string) bool {
+	return slices.ContainsFunc(domains, func(d string) bool {
+		d = strings.ToLower(d)
+		return host == d || strings.HasSuffix(host, "."+d)
+	})
+}
+
+// safeBrowsingMatches returns the urls Safe Browsing lists as malware,
+// social engineering or unwanted software.
+func safeBrowsingMatches(ctx context.Context, key string, urls []string) ([]string, error) {
+	entries := make([]map[string]string, len(urls))
+	for i, u := range urls {
+		entries[i] = map[string]string{"url": u}
+	}
+	body, err := json.Marshal(map[string]any{
+		"client": map[string]string{"clientId": "guestbook", "clientVersion": "1"},
+		"threatInfo": map[string]any{
+			"threatTypes":      []string{"MALWARE", "SOCIAL_ENGINEERING", "UNWANTED_SOFTWARE", "POTENTIALLY_HARMFUL_APPLICATION"},
+			"platformTypes":    []string{"ANY_PLATFORM"},
+			"threatEntryTypes": []string{"URL"},
+			"threatEntries":    entries,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, safeBrowsingURL+"?key="+url.QueryEscape(key), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := safeBrowsingClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return nil, fmt.Errorf("Safe Browsing answered %s", resp.Status)
+	}
+	var result struct {
+		Matches []struct {
+			Threat struct {
+				URL string `json:"url"`
+			} `json:"threat"`
+		} `json:"matches"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result); err != nil {
+		return nil, err
+	}
+	var unsafe []string
+	for _, m := range result.Matches {
+		if !slices.Contains(unsafe, m.Threat.URL) {
+			unsafe = append(unsafe, m.Threat.URL)
+		}
+	}
+	return unsafe, nil
+}
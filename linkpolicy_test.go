@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckLinks(t *testing.T) {
+	defer func(u string) { safeBrowsingURL = u }(safeBrowsingURL)
+	log := slog.New(slog.DiscardHandler)
+
+	var asked []string
+	fail := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail || r.URL.Query().Get("key") != "key" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var body struct {
+			ThreatInfo struct {
+				ThreatEntries []struct{ URL string } `json:"threatEntries"`
+			} `json:"threatInfo"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		var matches []map[string]any
+		for _, e := range body.ThreatInfo.ThreatEntries {
+			asked = append(asked, e.URL)
+			if e.URL == "http://malware.example/x" {
+				matches = append(matches, map[string]any{"threatType": "MALWARE", "threat": map[string]string{"url": e.URL}})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"matches": matches})
+	}))
+	defer srv.Close()
+	safeBrowsingURL = srv.URL
+
+	c := defaultConfig()
+	c.MaxLinks, c.LinkBlocklist = 2, []string{"Spam.example"}
+	c.SafeBrowsingAPIKey = "key"
+	tests := []struct {
+		text string
+		code string
+	}{
+		{"No links at all", ""},
+		{"See https://ok.example/ and https://ok.example/.", ""},
+		{"https://a.example https://b.example https://c.example", codeTooManyLinks},
+		{"Buy at https://shop.spam.example/pills", codeLinkNotAllowed},
+		{"Not a subdomain: https://notspam.example", ""},
+		{"Look http://malware.example/x", codeLinkNotAllowed},
+	}
+	for _, tt := range tests {
+		err := checkLinks(t.Context(), log, c, tt.text)
+		code := ""
+		if err != nil {
+			code = err.(*apiError).code
+		}
+		if code != tt.code {
+			t.Errorf("checkLinks(%q) = %v, want %q", tt.text, err, tt.code)
+		}
+	}
+	if len(asked) != 3 {
+		t.Errorf("Safe Browsing was asked about %v, want the links of the 3 comments that got that far", asked)
+	}
+
+	c.LinkAllowlist = []string{"example.org"}
+	if err := checkLinks(t.Context(), log, c, "https://docs.example.org/a"); err != nil {
+		t.Errorf("Allowed subdomain: %v", err)
+	}
+	if err := checkLinks(t.Context(), log, c, "https://example.net/a"); err == nil || err.(*apiError).details["url"] != "https://example.net/a" {
+		t.Errorf("Domain not on the allowlist: %v", err)
+	}
+
+	// a lookup that fails lets the comment through
+	fail = true
+	if err := checkLinks(t.Context(), log, c, "http://malware.example.org/x"); err != nil {
+		t.Errorf("Failed lookup: %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// urlPattern finds http and https URLs in comment text. Punctuation that
+// ends a sentence isn't part of the URL, see trimURL.
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// trimURL drops trailing punctuation from a URL found in text, keeping a
+// closing parenthesis that has an opening one in the URL.
+func trimURL(u string) string {
+	for {
+		trimmed := strings.TrimRight(u, ".,;:!?'*")
+		if strings.HasSuffix(trimmed, ")") && strings.Count(trimmed, "(") < strings.Count(trimmed, ")") {
+			trimmed = trimmed[:len(trimmed)-1]
+		}
+		if trimmed == u {
+			return u
+		}
+		u = trimmed
+	}
+}
+
+// findURLs returns the URLs in text, each once, in order.
+func findURLs(text string) []string {
+	var urls []string
+	seen := map[string]bool{}
+	for _, u := range urlPattern.FindAllString(text, -1) {
+		u = trimURL(u)
+		if !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// linkify escapes text for HTML and turns its URLs into links. They're
+// rel="nofollow noopener ugc" since anyone can post them.
+func linkify(text string) template.HTML {
+	var b strings.Builder
+	last := 0
+	for _, loc := range urlPattern.FindAllStringIndex(text, -1) {
+		start, end := loc[0], loc[0]+len(trimURL(text[loc[0]:loc[1]]))
+		b.WriteString(template.HTMLEscapeString(text[last:start]))
+		u := template.HTMLEscapeString(text[start:end])
+		b.WriteString(`<a href="` + u + `" rel="nofollow noopener ugc">` + u + `</a>`)
+		last = end
+	}
+	b.WriteString(template.HTMLEscapeString(text[last:]))
+	return template.HTML(b.String())
+}
+
+// publicClient fetches URLs that visitors hand the guestbook, like
+// Webmention sources and targets. It only connects to public addresses,
+// so nobody can point the guestbook at internal services.
+var publicClient = &http.Client{Timeout: 10 * time.Second, Transport: breakerTransport{publicTransport()}}
+
+// publicTransport is a transport that only dials public addresses. It
+// ignores HTTP_PROXY and HTTPS_PROXY: through a proxy, dialPublicOnly would
+// only see the proxy's address, and the proxy would reach anything.
+func publicTransport() *http.Transport {
+	return &http.Transport{Proxy: nil, DialContext: (&net.Dialer{Timeout: 10 * time.Second, Control: dialPublicOnly}).DialContext}
+}
+
+// dialPublicOnly refuses connections to loopback, private, link-local and
+// other addresses that aren't on the public internet.
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return fmt.Errorf("%s isn't a public address", ip)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindURLs(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"no links here", nil},
+		{"See https://example.com/a, and http://example.org.", []string{"https://example.com/a", "http://example.org"}},
+		{"(https://en.wikipedia.org/wiki/Go_(programming_language)) twice https://example.com/a https://example.com/a!", []string{"https://en.wikipedia.org/wiki/Go_(programming_language)", "https://example.com/a"}},
+		{`<a href="https://example.com/x">`, []string{"https://example.com/x"}},
+	}
+	for _, tt := range tests {
+		if got := findURLs(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("findURLs(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestLinkify(t *testing.T) {
+	got := linkify(`<b>hi</b> see https://example.com/?a=1&b=2. "javascript:alert(1)"`)
+	want := `&lt;b&gt;hi&lt;/b&gt; see <a href="https://example.com/?a=1&amp;b=2" rel="nofollow noopener ugc">https://example.com/?a=1&amp;b=2</a>. &#34;javascript:alert(1)&#34;`
+	if string(got) != want {
+		t.Errorf("linkify = %s\nwant %s", got, want)
+	}
+}
+
+func TestDialPublicOnly(t *testing.T) {
+	for addr, public := range map[string]bool{
+		"93.184.215.14:443":          true,
+		"[2606:2800:21f:cb07::1]:80": true,
+		"127.0.0.1:80":               false,
+		"10.1.2.3:443":               false,
+		"192.168.0.10:80":            false,
+		"169.254.169.254:80":         false,
+		"[::1]:80":                   false,
+		"[::ffff:127.0.0.1]:80":      false,
+		"[fd00::1]:80":               false,
+	} {
+		if err := dialPublicOnly("tcp", addr, nil); (err == nil) != public {
+			t.Errorf("dialPublicOnly(%s) = %v, want public %v", addr, err, public)
+		}
+	}
+
+	// a proxy would reach internal addresses on the guestbook's behalf
+	if publicTransport().Proxy != nil {
+		t.Error("publicTransport() goes through a proxy")
+	}
+}
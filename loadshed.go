@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// unlimitedPaths hold their connection open for as long as the client
+// stays, or have to answer however busy the server is, so they don't take
+// a slot. Neither do the profiles under /debug/, which take their time, or
+// the honeypot paths, whose tarpit has its own cap, see honeypotHandler.
+var unlimitedPaths = []string{"/healthz", "/livez", "/readyz", "/events", "/ws", "/comments/poll", apiPrefix + "/comments/poll"}
+
+// withLoadShedding lets at most max_concurrent_reads GET, HEAD and OPTIONS
+// requests and max_concurrent_writes others run at once. A request beyond
+// that gets 503 with Retry-After straight away rather than queueing for
+// the database, where SQLite would make it wait for the write lock.
+func withLoadShedding(next http.Handler, c Config) http.Handler {
+	if c.MaxConcurrentReads <= 0 && c.MaxConcurrentWrites <= 0 {
+		return next
+	}
+	var reads, writes chan struct{}
+	if c.MaxConcurrentReads > 0 {
+		reads = make(chan struct{}, c.MaxConcurrentReads)
+	}
+	if c.MaxConcurrentWrites > 0 {
+		writes = make(chan struct{}, c.MaxConcurrentWrites)
+	}
+	retryAfter := strconv.Itoa(c.OverloadRetryAfter)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slots := writes
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			slots = reads
+		}
+		if slots == nil || isUnlimited(r.URL.Path) || slices.Contains(c.HoneypotPaths, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			next.ServeHTTP(w, r)
+		default:
+			w.Header().Set("Retry-After", retryAfter)
+			httpError(w, r, http.StatusServiceUnavailable, codeOverloaded, "The server is busy, try again shortly")
+		}
+	})
+}
+
+func isUnlimited(path string) bool {
+	return slices.Contains(unlimitedPaths, path) || strings.HasPrefix(path, "/debug/")
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoadShedding(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	h := withLoadShedding(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-release
+		}
+	}), Config{MaxConcurrentWrites: 1, OverloadRetryAfter: 3, HoneypotPaths: []string{"/wp-login.php"}})
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+	done := make(chan int)
+	go func() { done <- serve("POST", "/slow").Code }()
+	<-started
+
+	rec := serve("POST", "/comments")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "3" || rec.Header().Get("X-Error-Code") != codeOverloaded {
+		t.Errorf("Write over the limit = %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	for _, req := range [][2]string{{"GET", "/comments"}, {"POST", "/ws"}, {"POST", "/api/v1/comments/poll"}, {"POST", "/wp-login.php"}} {
+		if rec := serve(req[0], req[1]); rec.Code != 200 {
+			t.Errorf("%s %s while writes are full = %d, want 200", req[0], req[1], rec.Code)
+		}
+	}
+
+	close(release)
+	if code := <-done; code != 200 {
+		t.Errorf("Slow write = %d", code)
+	}
+	if rec := serve("POST", "/comments"); rec.Code != 200 {
+		t.Errorf("Write after the slot freed up = %d, want 200", rec.Code)
+	}
+}
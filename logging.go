@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// logLevel is shared by every handler logger builds so the level can be
+// changed while running.
+var logLevel = new(slog.LevelVar)
+
+// logger writes to stderr until main has read the config and called
+// setupLogging.
+var logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+
+// loggingToStderr is false once logs go somewhere the operator starting
+// the server might not be watching.
+var loggingToStderr = true
+
+// setupLogging points logger at log_output: the rotating log_path file
+// (default), stdout or stderr where journald or a container runtime
+// collects them, or the system logger.
+func setupLogging() error {
+	var err error
+	switch config.LogOutput {
+	case "", "file":
+		if logFile, err = openLogFile(config.LogPath); err != nil {
+			return err
+		}
+		logger, err = newLogger(logFile)
+		loggingToStderr = false
+	case "stdout":
+		logger, err = newLogger(os.Stdout)
+		loggingToStderr = false
+	case "stderr":
+		logger, err = newLogger(os.Stderr)
+	case "syslog":
+		var h slog.Handler
+		if h, err = newSyslogHandler(); err == nil {
+			logger = slog.New(h)
+			loggingToStderr = false
+		}
+	default:
+		err = fmt.Errorf("invalid log_output %q, use file, stdout, stderr or syslog", config.LogOutput)
+	}
+	return err
+}
+
+// newLogger builds a logger writing log_format ("text" or "json") to w,
+// dropping entries below log_level.
+func newLogger(w io.Writer) (*slog.Logger, error) {
+	h, err := newHandler(w, nil)
+	if err != nil {
+		return nil, err
+	}
+	return slog.New(h), nil
+}
+
+func newHandler(w io.Writer, replace func([]string, slog.Attr) slog.Attr) (slog.Handler, error) {
+	level := config.LogLevel
+	if level == "" {
+		level = "info"
+	}
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log_level %q", config.LogLevel)
+	}
+
+	opts := &slog.HandlerOptions{Level: logLevel, ReplaceAttr: replace}
+	switch config.LogFormat {
+	case "", "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	}
+	return nil, fmt.Errorf("invalid log_format %q, use text or json", config.LogFormat)
+}
+
+// requestLogger returns a logger carrying the fields every entry about r
+// should have.
+func requestLogger(r *http.Request) *slog.Logger {
+	return logger.With("request_id", requestID(r), "ip", getIP(r), "method", r.Method, "path", r.URL.Path)
+}
+
+// fatal logs err and exits. Unless logs go to stderr anyway the message
+// is repeated there so whoever started the server sees why it stopped.
+func fatal(msg string, err error) {
+	logger.Error(msg, "error", err)
+	if !loggingToStderr {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", msg, err)
+	}
+	os.Exit(1)
+}
+
+// withLogging logs one entry per request with its status, size and duration.
+func withLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		requestLogger(r).Info("request",
+			"status", sw.status, "bytes", sw.bytes, "duration", time.Since(start))
+	})
+}
+
+// statusWriter remembers the status code and body size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
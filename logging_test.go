@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	defer logLevel.Set(logLevel.Level())
+
+	tests := []struct {
+		name      string
+		format    string
+		level     string
+		expectErr bool
+	}{
+		{"Defaults", "", "", false},
+		{"JSON debug", "json", "debug", false},
+		{"Text warn", "text", "warn", false},
+		{"Unknown format", "xml", "", true},
+		{"Unknown level", "", "loud", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.LogFormat = tt.format
+			config.LogLevel = tt.level
+			_, err := newLogger(&bytes.Buffer{})
+			if (err != nil) != tt.expectErr {
+				t.Errorf("newLogger() error = %v, expectErr %v", err, tt.expectErr)
+			}
+		})
+	}
+}
+
+func TestWithLogging(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	defer func(l *slog.Logger) { logger = l }(logger)
+	defer logLevel.Set(logLevel.Level())
+
+	var buf bytes.Buffer
+	config.LogFormat = "json"
+	config.LogLevel = "info"
+	l, err := newLogger(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger = l
+
+	handler := withRequestID(withLogging(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	})))
+
+	req := httptest.NewRequest("POST", "/comments", nil)
+	req.RemoteAddr = "192.168.1.1:1234"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Log entry is not JSON: %v: %q", err, buf.String())
+	}
+
+	expected := map[string]any{
+		"msg":    "request",
+		"ip":     "192.168.1.1",
+		"method": "POST",
+		"path":   "/comments",
+		"status": float64(201),
+		"bytes":  float64(5),
+	}
+	for k, v := range expected {
+		if entry[k] != v {
+			t.Errorf("Log field %s = %v, want %v", k, entry[k], v)
+		}
+	}
+	if id, _ := entry["request_id"].(string); len(id) != 16 {
+		t.Errorf("Log entry has no request_id: %v", entry)
+	}
+	if _, ok := entry["duration"]; !ok {
+		t.Error("Log entry has no duration")
+	}
+
+	// Entries below the configured level are dropped
+	buf.Reset()
+	logLevel.UnmarshalText([]byte("warn"))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if strings.TrimSpace(buf.String()) != "" {
+		t.Errorf("Expected no info entries at warn level, got %q", buf.String())
+	}
+}
+
+func TestSetupLoggingOutputs(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	defer func(l *slog.Logger, s bool) { logger, loggingToStderr = l, s }(logger, loggingToStderr)
+	defer logLevel.Set(logLevel.Level())
+
+	tests := []struct {
+		output    string
+		toStderr  bool
+		expectErr bool
+	}{
+		{"stdout", false, false},
+		{"stderr", true, false},
+		{"kafka", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.output, func(t *testing.T) {
+			config.LogOutput = tt.output
+			loggingToStderr = true
+			err := setupLogging()
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("setupLogging() error = %v, expectErr %v", err, tt.expectErr)
+			}
+			if loggingToStderr != tt.toStderr {
+				t.Errorf("loggingToStderr = %v, want %v", loggingToStderr, tt.toStderr)
+			}
+		})
+	}
+}
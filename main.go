@@ -1,81 +1,627 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"crypto/tls"
 	"database/sql"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
 	"strings"
+	"syscall"
 	"time"
-
-	"github.com/BurntSushi/toml"
-	_ "github.com/mattn/go-sqlite3"
 )
 
 type Config struct {
 	Port    int    `toml:"port"`
 	DBPath  string `toml:"db_path"`
 	LogPath string `toml:"log_path"`
+
+	DBDriver    string `toml:"db_driver"`
+	DBDSN       string `toml:"db_dsn"`
+	AutoMigrate bool   `toml:"auto_migrate"`
+
+	SQLiteJournalMode string `toml:"sqlite_journal_mode"`
+	SQLiteBusyTimeout int    `toml:"sqlite_busy_timeout"`
+	SQLiteForeignKeys bool   `toml:"sqlite_foreign_keys"`
+	DBMaxOpenConns    int    `toml:"db_max_open_conns"`
+	DBMaxIdleConns    int    `toml:"db_max_idle_conns"`
+	DBConnMaxLifetime int    `toml:"db_conn_max_lifetime"`
+
+	BackupDir           string `toml:"backup_dir"`
+	BackupIntervalHours int    `toml:"backup_interval_hours"`
+	BackupSchedule      string `toml:"backup_schedule"`
+	BackupKeep          int    `toml:"backup_keep"`
+
+	PurgeSchedule        string `toml:"purge_schedule"`
+	SpamRetentionDays    int    `toml:"spam_retention_days"`
+	PendingRetentionDays int    `toml:"pending_retention_days"`
+	// RequestLogTable records comment submissions in request_log, see
+	// withSubmissionLog, for RequestLogRetentionDays.
+	RequestLogTable         bool   `toml:"request_log_table"`
+	RequestLogRetentionDays int    `toml:"request_log_retention_days"`
+	ArchiveSchedule         string `toml:"archive_schedule"`
+	ArchiveAfterYears       int    `toml:"archive_after_years"`
+	JobJitterSeconds        int    `toml:"job_jitter_seconds"`
+
+	BackupS3Endpoint  string `toml:"backup_s3_endpoint"`
+	BackupS3Region    string `toml:"backup_s3_region"`
+	BackupS3Bucket    string `toml:"backup_s3_bucket"`
+	BackupS3Prefix    string `toml:"backup_s3_prefix"`
+	BackupS3AccessKey string `toml:"backup_s3_access_key"`
+	BackupS3SecretKey string `toml:"backup_s3_secret_key"`
+
+	MediaStorage  string `toml:"media_storage"`
+	MediaDir      string `toml:"media_dir"`
+	MediaMaxBytes int    `toml:"media_max_bytes"`
+	MediaS3Bucket string `toml:"media_s3_bucket"`
+	MediaS3Prefix string `toml:"media_s3_prefix"`
+	AvatarUploads bool   `toml:"avatar_uploads"`
+	Identicons    bool   `toml:"identicons"`
+
+	LogOutput     string `toml:"log_output"`
+	LogFormat     string `toml:"log_format"`
+	LogLevel      string `toml:"log_level"`
+	AccessLogPath string `toml:"access_log_path"`
+
+	SyslogNetwork string `toml:"syslog_network"`
+	SyslogAddress string `toml:"syslog_address"`
+	SyslogTag     string `toml:"syslog_tag"`
+
+	LogMaxSizeMB   int  `toml:"log_max_size_mb"`
+	LogMaxAgeHours int  `toml:"log_max_age_hours"`
+	LogMaxBackups  int  `toml:"log_max_backups"`
+	LogCompress    bool `toml:"log_compress"`
+
+	AutocertDomains  []string `toml:"autocert_domains"`
+	AutocertCacheDir string   `toml:"autocert_cache_dir"`
+	AutocertEmail    string   `toml:"autocert_email"`
+	AutocertHTTPPort int      `toml:"autocert_http_port"`
+
+	GRPCPort int `toml:"grpc_port"`
+
+	PublicURL            string `toml:"public_url"`
+	ActivityPub          bool   `toml:"activitypub"`
+	ActivityPubUsername  string `toml:"activitypub_username"`
+	Webmention           bool   `toml:"webmention"`
+	SendWebmentions      bool   `toml:"send_webmentions"`
+	IndieAuth            bool   `toml:"indieauth"`
+	ApproveAuthenticated bool   `toml:"approve_authenticated"`
+	RequireSignIn        bool   `toml:"require_sign_in"`
+	ApproveReturning     bool   `toml:"approve_returning"`
+	RememberCommenters   bool   `toml:"remember_commenters"`
+	ApproveVerified      bool   `toml:"approve_verified"`
+	DoubleOptIn          bool   `toml:"double_opt_in"`
+	CommentManagement    bool   `toml:"comment_management"`
+	GitHubClientID       string `toml:"github_client_id"`
+	GitHubClientSecret   string `toml:"github_client_secret"`
+	GoogleClientID       string `toml:"google_client_id"`
+	GoogleClientSecret   string `toml:"google_client_secret"`
+
+	MultiTenant bool `toml:"multi_tenant"`
+
+	AdminToken      string `toml:"admin_token"`
+	AdminJWTSecret  string `toml:"admin_jwt_secret"`
+	AdminJWTTTL     int    `toml:"admin_jwt_ttl"`
+	AdminRefreshTTL int    `toml:"admin_refresh_ttl"`
+
+	OIDCIssuer          string   `toml:"oidc_issuer"`
+	OIDCClientID        string   `toml:"oidc_client_id"`
+	OIDCClientSecret    string   `toml:"oidc_client_secret"`
+	OIDCScopes          []string `toml:"oidc_scopes"`
+	OIDCRoleClaim       string   `toml:"oidc_role_claim"`
+	OIDCAdminGroups     []string `toml:"oidc_admin_groups"`
+	OIDCModeratorGroups []string `toml:"oidc_moderator_groups"`
+	CSRFMode            string   `toml:"csrf_mode"`
+	FormTokenSecret     string   `toml:"form_token_secret"`
+	FormTokenMaxAge     int      `toml:"form_token_max_age"`
+	SubmissionNonces    bool     `toml:"submission_nonces"`
+	SubmissionNonceTTL  int      `toml:"submission_nonce_ttl"`
+
+	RequireConsent bool   `toml:"require_consent"`
+	PolicyVersion  string `toml:"policy_version"`
+	ClosedAfter    string `toml:"closed_after"`
+
+	PageTitle       string `toml:"page_title"`
+	DisplayTimezone string `toml:"display_timezone"`
+	APIDocs         bool   `toml:"api_docs"`
+	TemplatesDir    string `toml:"templates_dir"`
+	StaticDir       string `toml:"static_dir"`
+
+	DuplicateWindow int `toml:"duplicate_window"`
+
+	SpamWeights       map[string]float64 `toml:"spam_weights"`
+	SpamModerateScore float64            `toml:"spam_moderate_score"`
+	SpamRejectScore   float64            `toml:"spam_reject_score"`
+	SpamRateLimit     int                `toml:"spam_rate_limit"`
+	SpamMaxLinks      int                `toml:"spam_max_links"`
+	SpamWords         []string           `toml:"spam_words"`
+	DNSBLZones        []string           `toml:"dnsbl_zones"`
+	DNSBLTimeoutMS    int                `toml:"dnsbl_timeout_ms"`
+
+	MaxLinks           int      `toml:"max_links"`
+	LinkBlocklist      []string `toml:"link_blocklist"`
+	LinkAllowlist      []string `toml:"link_allowlist"`
+	SafeBrowsingAPIKey string   `toml:"safe_browsing_api_key"`
+
+	ResponseCacheSeconds int `toml:"response_cache_seconds"`
+	PollTimeout          int `toml:"poll_timeout"`
+
+	RedisURL    string `toml:"redis_url"`
+	RedisPrefix string `toml:"redis_prefix"`
+
+	SkipWarmup bool `toml:"skip_warmup"`
+
+	HoneypotPaths []string `toml:"honeypot_paths"`
+	TarpitSeconds int      `toml:"tarpit_seconds"`
+	// HoneypotBanMinutes blocklists an IP that hits a honeypot path for
+	// that long. It's 0 by default: an <img> on any page can send its
+	// visitors there.
+	HoneypotBanMinutes  int `toml:"honeypot_ban_minutes"`
+	BotHitRetentionDays int `toml:"bot_hit_retention_days"`
+
+	OTLPEndpoint     string  `toml:"otlp_endpoint"`
+	ServiceName      string  `toml:"service_name"`
+	TraceSampleRatio float64 `toml:"trace_sample_ratio"`
+
+	ShutdownTimeout   int `toml:"shutdown_timeout"`
+	ReadHeaderTimeout int `toml:"read_header_timeout"`
+	ReadTimeout       int `toml:"read_timeout"`
+	WriteTimeout      int `toml:"write_timeout"`
+	IdleTimeout       int `toml:"idle_timeout"`
+	MaxHeaderBytes    int `toml:"max_header_bytes"`
+	MaxBodyBytes      int `toml:"max_body_bytes"`
+	MaxBulkBodyBytes  int `toml:"max_bulk_body_bytes"`
+
+	TrustedProxies []string `toml:"trusted_proxies"`
+	ClientIPHeader string   `toml:"client_ip_header"`
+
+	MaxConcurrentReads  int `toml:"max_concurrent_reads"`
+	MaxConcurrentWrites int `toml:"max_concurrent_writes"`
+	OverloadRetryAfter  int `toml:"overload_retry_after"`
+
+	LookupTimeout   int `toml:"lookup_timeout"`
+	BreakerFailures int `toml:"breaker_failures"`
+	BreakerCooldown int `toml:"breaker_cooldown"`
+
+	WebhookURLs  []string `toml:"webhook_urls"`
+	NotifyEmail  string   `toml:"notify_email"`
+	MailFrom     string   `toml:"mail_from"`
+	EmailLocale  string   `toml:"email_locale"`
+	SMTPAddr     string   `toml:"smtp_addr"`
+	SMTPUsername string   `toml:"smtp_username"`
+	SMTPPassword string   `toml:"smtp_password"`
+	// EmailVerificationSecret signs the links of verification emails, and
+	// turns them on.
+	EmailVerificationSecret string   `toml:"email_verification_secret"`
+	OutboxMaxAttempts       int      `toml:"outbox_max_attempts"`
+	DisabledNotifiers       []string `toml:"disabled_notifiers"`
+	DigestSchedule          string   `toml:"digest_schedule"`
+	TelegramBotToken        string   `toml:"telegram_bot_token"`
+	TelegramChatID          string   `toml:"telegram_chat_id"`
+
+	MastodonServer     string `toml:"mastodon_server"`
+	MastodonToken      string `toml:"mastodon_token"`
+	MastodonTemplate   string `toml:"mastodon_template"`
+	MastodonVisibility string `toml:"mastodon_visibility"`
+	MastodonMaxPerHour int    `toml:"mastodon_max_per_hour"`
+
+	TranslateProvider  string   `toml:"translate_provider"`
+	TranslateURL       string   `toml:"translate_url"`
+	TranslateAPIKey    string   `toml:"translate_api_key"`
+	TranslateLanguages []string `toml:"translate_languages"`
+
+	WatchdogInterval int  `toml:"watchdog_interval"`
+	MaxGoroutines    int  `toml:"max_goroutines"`
+	MaxHeapMB        int  `toml:"max_heap_mb"`
+	MaxOpenFDs       int  `toml:"max_open_fds"`
+	WatchdogRestart  bool `toml:"watchdog_restart"`
+
+	DebugEndpoints bool `toml:"debug_endpoints"`
 }
 
 type Comment struct {
 	ID       int       `json:"id"`
 	Name     string    `json:"name"`
-	Email    string    `json:"email"`
+	Email    string    `json:"email,omitempty"`
 	Text     string    `json:"text"`
-	IP       string    `json:"ip"`
+	IP       string    `json:"ip,omitempty"`
 	Location string    `json:"location"`
+	Likes    int       `json:"likes"`
 	Created  time.Time `json:"created"`
+	ParentID int       `json:"parent_id,omitempty"`
+	// Website is the commenter's own site, and Authenticated tells they
+	// signed in to prove it's theirs, with IndieAuth or the Provider
+	// their Avatar is from.
+	Website       string `json:"website,omitempty"`
+	Authenticated bool   `json:"authenticated,omitempty"`
+	Avatar        string `json:"avatar,omitempty"`
+	Provider      string `json:"provider,omitempty"`
+	// Image is the URL of the image posted with the comment.
+	Image string `json:"image,omitempty"`
+	// Returning tells the email had an approved comment on the site when
+	// this one was posted.
+	Returning bool `json:"returning,omitempty"`
+	// Verified tells the commenter had verified their email, see
+	// verify.go.
+	Verified bool `json:"verified,omitempty"`
+
+	SiteID         int       `json:"-"`
+	Status         string    `json:"-"`
+	ConsentVersion string    `json:"-"`
+	Updated        time.Time `json:"-"`
+	// SpamScore is what the spam checks made of the comment, and
+	// SpamReasons which of them added to it, separated by "; ".
+	SpamScore   float64 `json:"-"`
+	SpamReasons string  `json:"-"`
+}
+
+// hidePersonal clears the commenter's email and IP, which only admins
+// get to see, from c before it's listed.
+func (c *Comment) hidePersonal() {
+	c.Email, c.IP = "", ""
 }
 
 var db *sql.DB
-var logFile *os.File
+var logFile *rotatingFile
 var config Config
 
 func main() {
-	if _, err := toml.DecodeFile("config.toml", &config); err != nil {
-		log.Fatal("Error loading config.toml:", err)
+	configFlag := flag.String("config", "", "config file (.toml, .yaml or .json)")
+	checkConfig := flag.Bool("check-config", false, "validate the configuration and exit")
+	flag.Usage = usage
+	flag.Parse()
+
+	name, args := "serve", flag.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	if name == "init" {
+		if err := runInit(args, *configFlag, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "init failed:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	configPath = *configFlag
+	if configPath == "" {
+		configPath = findConfig()
+	} else if !fileExists(configPath) {
+		fatal("Error loading config", fmt.Errorf("%s doesn't exist", configPath))
 	}
 
 	var err error
-	logFile, err = os.OpenFile(config.LogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	config, err = loadConfig(configPath, os.LookupEnv)
 	if err != nil {
-		log.Fatal("Error opening log file:", err)
+		fatal("Error loading config", err)
+	}
+	if err := validateConfig(config); err != nil {
+		// one problem per line reads better than a single log entry
+		fmt.Fprintf(os.Stderr, "Invalid config:\n  %v\n", strings.ReplaceAll(err.Error(), "\n", "\n  "))
+		os.Exit(1)
+	}
+	if *checkConfig {
+		fmt.Println("config OK")
+		return
+	}
+
+	displayLocation.Store(mustLoadLocation(config.DisplayTimezone))
+	setForwarding(config)
+	if config.TemplatesDir != "" {
+		t, err := parseTemplates(config.TemplatesDir)
+		if err != nil {
+			fatal("Error loading templates", err)
+		}
+		templates.Store(t)
+	}
+
+	if err := setupLogging(); err != nil {
+		fatal("Error setting up logging", err)
+	}
+	if logFile != nil {
+		defer logFile.Close()
+	}
+
+	// migrate has to run before the schema is checked, or there'd be no
+	// way to fix it
+	closeStore := openStore(name != "migrate")
+	defer closeStore()
+
+	if config.RedisURL != "" {
+		rs, err := openRedisState(config.RedisURL, config.RedisPrefix)
+		if err != nil {
+			fatal("Error connecting to Redis", err)
+		}
+		defer rs.Close()
+		shared = rs
+	}
+	if err := cmd.run(args, os.Stdout); err != nil {
+		fatal("Error running "+name, err)
+	}
+}
+
+// openStore opens the store config.DBDriver names and, if schema is set,
+// creates or checks the SQL schema. It returns a function closing it.
+func openStore(schema bool) func() {
+	var closers []func() error
+	switch config.DBDriver {
+	case "memory":
+		store = newMemoryStore()
+	case "bbolt":
+		bs, err := openBoltStore(config.DBPath)
+		if err != nil {
+			fatal("Error opening database", err)
+		}
+		closers = append(closers, bs.Close)
+		store = bs
+	default:
+		var err error
+		db, err = openDB()
+		if err != nil {
+			fatal("Error opening database", err)
+		}
+		closers = append(closers, db.Close)
+		ss := &sqlStore{db: db}
+		closers = append(closers, ss.Close)
+		store = ss
+
+		if !schema {
+			break
+		}
+		if config.AutoMigrate {
+			err = initSchema()
+		} else if err = checkSchema(context.Background()); err == nil {
+			err = initSearch()
+		}
+		if err != nil {
+			fatal("Error creating schema", err)
+		}
+		if err := ss.prepareStatements(context.Background()); err != nil {
+			fatal("Error preparing statements", err)
+		}
+	}
+	store = feedStore{cachingStore{store}}
+	return func() {
+		for _, c := range slices.Backward(closers) {
+			c()
+		}
+	}
+}
+
+// runServe implements the serve subcommand, which is what runs without
+// one: it serves the guestbook until SIGINT or SIGTERM, or until the
+// watchdog asks for a restart, see runWatchdog.
+func runServe(args []string, out io.Writer) error {
+	if len(args) != 0 {
+		return errors.New("usage: guestbook serve")
+	}
+	var err error
+	if config.AccessLogPath != "" {
+		accessLogFile, err = openLogFile(config.AccessLogPath)
+		if err != nil {
+			fatal("Error opening access log", err)
+		}
+		defer accessLogFile.Close()
+	}
+
+	if tracingEnabled() {
+		shutdown, err := initTracing(context.Background())
+		if err != nil {
+			fatal("Error setting up tracing", err)
+		}
+		defer shutdown(context.Background())
+	}
+
+	if !config.SkipWarmup {
+		if err := warmup(); err != nil {
+			fatal("Error warming up", err)
+		}
+	}
+
+	http.HandleFunc("/{$}", requireSite(guestbookPageHandler))
+	http.HandleFunc("/guestbook", requireSite(guestbookPageHandler))
+	http.HandleFunc("/embed", requireSite(embedHandler))
+	http.HandleFunc("/static/", staticHandler)
+	if media, err = openMediaStore(config); err != nil {
+		fatal("Error opening media storage", err)
+	} else if media != nil {
+		http.HandleFunc("/media/", mediaHandler)
+	}
+	if config.Identicons {
+		http.HandleFunc("/avatar/", identiconHandler)
+	}
+	if config.EmailVerificationSecret != "" {
+		http.HandleFunc("/verify-email", verifyEmailHandler)
+	}
+	if config.CommentManagement {
+		http.HandleFunc("/my-comments", requireSite(manageCommentsHandler))
+	}
+	http.HandleFunc("/events", requireSite(eventsHandler))
+	http.HandleFunc("/ws", requireSite(wsHandler))
+	// sites aren't part of the CommentStore, the other backends have none
+	for _, route := range apiRoutes(db != nil) {
+		handleAPI(http.DefaultServeMux, route.path, route.handler)
+	}
+	http.HandleFunc("/openapi.json", openAPIHandler)
+	http.HandleFunc("/api/docs", apiDocsHandler)
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/livez", healthzHandler)
+	http.HandleFunc("/readyz", readyzHandler)
+	http.HandleFunc("/admin", dashboardHandler)
+	http.HandleFunc("/admin/login", adminLoginHandler)
+	http.HandleFunc("/admin/logout", adminLogoutHandler)
+	if config.AdminJWTSecret != "" {
+		http.HandleFunc("/admin/refresh", adminRefreshHandler)
+		http.HandleFunc("/admin/revoke", adminRevokeHandler)
+	}
+	http.HandleFunc("/admin/action", dashboardActionHandler)
+	if config.OIDCIssuer != "" {
+		http.HandleFunc("/admin/oidc/login", oidcLoginHandler)
+		http.HandleFunc("/admin/oidc/callback", oidcCallbackHandler)
+	}
+	if config.ActivityPub {
+		handleActivityPub(http.DefaultServeMux)
+	}
+	if config.Webmention {
+		http.HandleFunc("/webmention", webmentionHandler)
+	}
+	if config.TelegramBotToken != "" {
+		http.HandleFunc("/telegram/webhook", telegramWebhookHandler)
+	}
+	if config.IndieAuth {
+		http.HandleFunc("/indieauth/login", indieAuthLoginHandler)
+		http.HandleFunc("/indieauth/callback", indieAuthCallbackHandler)
+	}
+	if len(enabledOAuthProviders()) > 0 {
+		http.HandleFunc("/oauth/login", oauthLoginHandler)
+		http.HandleFunc("/oauth/callback", oauthCallbackHandler)
+	}
+	if signInEnabled() {
+		http.HandleFunc("/logout", commenterLogoutHandler)
+	}
+	for _, path := range config.HoneypotPaths {
+		http.HandleFunc(path, honeypotHandler)
 	}
 
-	defer logFile.Close()
+	if config.WatchdogInterval > 0 {
+		go runWatchdog(time.Duration(config.WatchdogInterval) * time.Second)
+	}
 
-	db, err = sql.Open("sqlite3", config.DBPath)
+	addr := fmt.Sprintf(":%d", config.Port)
+	handler := withRequestID(withLogging(withLoadShedding(withDebug(http.DefaultServeMux), config)))
+	if accessLogFile != nil {
+		handler = withAccessLog(accessLogFile, handler)
+	}
+	if tracingEnabled() {
+		handler = withTracing(handler)
+	}
+	ln, err := listen(0, addr)
 	if err != nil {
-		log.Fatal(err)
-	}
-	defer db.Close()
-
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS comments (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			name TEXT,
-			email TEXT,
-			text TEXT,
-			ip TEXT,
-			location TEXT,
-			created DATETIME DEFAULT CURRENT_TIMESTAMP
-		)
-	`)
+		fatal("Error listening", err)
+	}
+	listeners := []net.Listener{ln}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if autocertEnabled() {
+		m := newCertManager()
+		httpPort := config.AutocertHTTPPort
+		if httpPort <= 0 {
+			httpPort = 80
+		}
+		challengeLn, err := listen(1, fmt.Sprintf(":%d", httpPort))
+		if err != nil {
+			fatal("Error listening for ACME challenges", err)
+		}
+		listeners = append(listeners, challengeLn)
+		go func() {
+			if err := runServer(ctx, challengeServer(m), challengeLn); err != nil {
+				logger.Error("ACME challenge server stopped", "error", err)
+			}
+		}()
+		ln = tls.NewListener(ln, m.TLSConfig())
+		logger.Info("Serving HTTPS with automatic certificates", "domains", config.AutocertDomains)
+	}
+
+	if config.GRPCPort > 0 {
+		grpcLn, err := listen(len(listeners), fmt.Sprintf(":%d", config.GRPCPort))
+		if err != nil {
+			fatal("Error listening for gRPC", err)
+		}
+		listeners = append(listeners, grpcLn)
+		go func() {
+			if err := runGRPCServer(ctx, newGRPCServer(), grpcLn); err != nil {
+				logger.Error("gRPC server stopped", "error", err)
+			}
+		}()
+		logger.Info("Serving gRPC", "addr", grpcLn.Addr().String())
+	}
+
+	if config.ActivityPub {
+		go runFederation(ctx)
+	}
+	if config.SendWebmentions {
+		go runWebmentions(ctx)
+	}
+	if db != nil {
+		go runOutbox(ctx)
+		go runNotifications(ctx)
+	}
+	if config.TelegramBotToken != "" {
+		go func() {
+			if err := setTelegramWebhook(ctx); err != nil {
+				logger.Error("Telegram webhook not set, the buttons won't work", "error", err)
+			}
+		}()
+	}
+	runJobs(ctx, scheduledJobs(config))
+	go handleRestarts(ctx, listeners)
+	go handleReloads(ctx)
+	notifyParent()
+
+	logger.Info("Guestbook started :)", "addr", addr)
+	if err := runServer(ctx, newServer(handler), ln); err != nil {
+		logger.Error("Server stopped uncleanly", "error", err)
+		return nil
+	}
+	logger.Info("Guestbook stopped")
+	if watchdogTripped.Load() {
+		return errWatchdogRestart
+	}
+	return nil
+}
+
+// initSchema applies pending migrations and sets up search.
+func initSchema() error {
+	if _, err := migrateUp(context.Background(), 0); err != nil {
+		return err
+	}
+	return initSearch()
+}
+
+// addColumn adds a column to an existing SQLite table unless it's already
+// there.
+func addColumn(table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	defer rows.Close()
 
-	http.HandleFunc("/comments", commentsHandler)
-	http.HandleFunc("/all", allCommentsHandler)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
 
-	addr := fmt.Sprintf(":%d", config.Port)
-	fmt.Printf("Guestbook started :)")
-	log.Fatal(http.ListenAndServe(addr, nil))
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
 }
 
 // --- Handlers ---
@@ -83,98 +629,425 @@ func commentsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		getComments(w, r, 15)
 	} else if r.Method == http.MethodPost {
-		addComment(w, r)
+		withSubmissionLog(w, r, postComment)
 	} else {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func postComment(w http.ResponseWriter, r *http.Request) {
+	if !checkCSRF(w, r) {
+		return
 	}
+	withIdempotency(w, r, addComment)
 }
 
 func allCommentsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		getComments(w, r, -1)
 	} else {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
 	}
 }
 
 // limit = N, or -1 is all brawtherrr
 func getComments(w http.ResponseWriter, r *http.Request, limit int) {
-	query := `
-		SELECT id, name, email, text, ip, location, created
-		FROM comments
-		ORDER BY created DESC
-	`
-	if limit > 0 {
-		query += fmt.Sprintf(" LIMIT %d", limit)
+	q, err := commentQuery(r)
+	if err == errAdminOnly {
+		httpError(w, r, http.StatusForbidden, codeAdminOnly, err.Error())
+		return
+	} else if err != nil {
+		httpError(w, r, 400, codeInvalidFilter, err.Error())
+		return
+	}
+	if q.Sort, err = commentSort(r); err != nil {
+		httpError(w, r, 400, codeInvalidSort, err.Error())
+		return
+	}
+	q.Limit = limit
+	lang := r.URL.Query().Get("translate")
+	if r.URL.Query().Has("translate") {
+		if err := translationLanguage(settings(), lang); err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+		if limit < 0 {
+			httpError(w, r, 400, codeInvalidQuery, "translate only works on the paged listing, not on all comments")
+			return
+		}
 	}
 
-	rows, err := db.Query(query)
+	// the plain recent-comments listing is what embeds poll, so it's the
+	// one worth caching, without the emails and IPs admins get
+	admin := isAdmin(r)
+	ttl := time.Duration(settings().ResponseCacheSeconds) * time.Second
+	cacheable := ttl > 0 && limit == 15 && onlySiteParams(r) && !admin
+	var gen int64
+	if cacheable {
+		cached, g, hit, err := cachedRecent(r.Context(), q.SiteID)
+		if err != nil {
+			// the database can still answer
+			requestLogger(r).Warn("reading the response cache failed", "error", err)
+		}
+		if hit {
+			w.Header().Set("X-Cache", "HIT")
+			if !notModified(w, r, cached.Version) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(cached.Body)
+			}
+			return
+		}
+		gen = g
+		w.Header().Set("X-Cache", "MISS")
+	}
+
+	v, err := store.Version(r.Context(), q)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		internalError(w, r, err)
+		return
+	}
+	if notModified(w, r, v) {
 		return
 	}
-	defer rows.Close()
 
-	var comments []Comment
-	for rows.Next() {
-		var c Comment
-		var created string
-		if err := rows.Scan(&c.ID, &c.Name, &c.Email, &c.Text, &c.IP, &c.Location, &created); err != nil {
-			http.Error(w, err.Error(), 500)
-			return
+	comments, err := store.List(r.Context(), q)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if !admin {
+		for i := range comments {
+			comments[i].hidePersonal()
+		}
+	}
+	if lang != "" {
+		if translateComments(r.Context(), requestLogger(r), settings(), lang, comments) {
+			w.Header().Set("Content-Language", lang)
+		} else {
+			// the originals mustn't be cached as the translation
+			w.Header().Del("ETag")
+			w.Header().Del("Last-Modified")
 		}
-		c.Created, _ = time.Parse("2006-01-02 15:04:05", created)
-		comments = append(comments, c)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(comments)
+	if !cacheable {
+		writeCommentsJSON(w, comments)
+		return
+	}
+	body := append(appendCommentsJSON(nil, comments), '\n')
+	if err := cacheRecent(r.Context(), q.SiteID, cachedResponse{Generation: gen, Body: body, Version: v}, ttl); err != nil {
+		requestLogger(r).Warn("writing the response cache failed", "error", err)
+	}
+	w.Write(body)
+}
+
+// onlySiteParams reports whether r has no query parameters besides the
+// ones that pick the site.
+func onlySiteParams(r *http.Request) bool {
+	for key := range r.URL.Query() {
+		if key != "site" && key != "api_key" {
+			return false
+		}
+	}
+	return true
 }
 
 func addComment(w http.ResponseWriter, r *http.Request) {
-	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid form data", 400)
+	if !parseForm(w, r) {
 		return
 	}
-	name := r.FormValue("name")
-	email := r.FormValue("email")
-	text := r.FormValue("comment")
+	site := siteFor(r)
+	if cfg := settings(); cfg.SubmissionNonces && requestRole(r) == "" {
+		nonce := cmp.Or(r.Header.Get("X-Submission-Nonce"), r.FormValue("submission_nonce"))
+		if err := checkNonce(cfg, site, nonce, time.Now()); err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+	}
+	in := commentInput{
+		Name:     r.FormValue("name"),
+		Email:    r.FormValue("email"),
+		Text:     r.FormValue("comment"),
+		IP:       getIP(r),
+		Consent:  hasConsent(r.FormValue("consent")),
+		Honeypot: r.FormValue("homepage"),
 
-	if name == "" || email == "" || text == "" {
-		http.Error(w, "All fields (name, email, comment) are required", 400)
+		VerifiedEmail: verifiedEmail(settings(), r),
+		Remembered:    remembered(r),
+		Claim: func(ctx context.Context) error {
+			if err := claimCSRF(ctx, r); err != nil {
+				return err
+			}
+			if cfg := settings(); cfg.SubmissionNonces && requestRole(r) == "" {
+				nonce := cmp.Or(r.Header.Get("X-Submission-Nonce"), r.FormValue("submission_nonce"))
+				return claimNonce(ctx, cfg, site, nonce, time.Now())
+			}
+			return nil
+		},
+	}
+	// publishing a draft, the form can leave out what the draft has
+	draft := r.FormValue("draft")
+	if draft != "" {
+		d, err := loadDraft(r.Context(), site.ID, draft)
+		if err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+		in.Name, in.Email, in.Text = cmp.Or(in.Name, d.Name), cmp.Or(in.Email, d.Email), cmp.Or(in.Text, d.Comment)
+	}
+	if signInEnabled() {
+		if in.Commenter = signedInCommenter(r); in.Commenter != nil {
+			// the provider's name is the one that's verified
+			in.Name = cmp.Or(in.Commenter.Name, in.Name)
+			in.Email = cmp.Or(in.Email, in.Commenter.Email)
+		}
+	}
+	if media != nil {
+		cfg := settings()
+		// decoding images takes time and memory, so it waits until the
+		// comment would be let in at all
+		if err := acceptingComments(r.Context(), cfg, site, in.IP); err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+		var err error
+		if in.Image, err = formImage(r, cfg); err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+		if cfg.AvatarUploads && (in.Commenter == nil || in.Commenter.Avatar == "") {
+			if in.Avatar, err = formAvatar(r, cfg); err != nil {
+				writeAPIError(w, r, err)
+				return
+			}
+		}
+	}
+	c, dup, err := submitComment(r.Context(), requestLogger(r), site, in)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	name := ""
+	if settings().RememberCommenters && in.Commenter == nil {
+		name = in.Name
+	}
+	rememberCommenter(w, r, name, c)
+	if draft != "" {
+		if err := shared.Delete(r.Context(), draftKey(site.ID, draft)); err != nil {
+			requestLogger(r).Warn("deleting the published draft failed", "error", err)
+		}
+	}
+	if dup {
+		// answer like the first submission did, a double-clicked button
+		// shouldn't show an error for a comment that was saved
+		fmt.Fprintln(w, "Comment already received")
 		return
 	}
 
-	ip := getIP(r)
-	location := getLocation(ip)
+	w.WriteHeader(http.StatusCreated)
+	switch c.Status {
+	case "pending":
+		fmt.Fprintln(w, "Comment received and awaiting moderation")
+		return
+	case "unconfirmed":
+		fmt.Fprintln(w, "Comment received, follow the link emailed to you to confirm it")
+		return
+	}
+	fmt.Fprintln(w, "Comment added successfully")
+}
+
+// commentInput is a new comment as submitted, over HTTP or gRPC.
+type commentInput struct {
+	Name, Email, Text string
+	IP                string
+	Consent           bool
+	// Honeypot is the form's hidden homepage field, which only bots fill in.
+	Honeypot string
+	// Commenter is who signed in, if anybody.
+	Commenter *commenterSession
+	// Image was uploaded with the comment, see formImage, and Avatar as
+	// the commenter's avatar, see formAvatar.
+	Image  *mediaUpload
+	Avatar *mediaUpload
+	// VerifiedEmail is the email the commenter's verified cookie proves,
+	// see verifiedEmail, and Remembered what their returning cookie stands
+	// for, see isReturning.
+	VerifiedEmail string
+	Remembered    rememberedCommenter
+	// Claim, if set, uses up what the submission brought to be let in only
+	// once, see claimCSRF and claimNonce. It runs once the comment turned
+	// out not to be a duplicate.
+	Claim func(context.Context) error
+}
 
-	_, err := db.Exec(
-		"INSERT INTO comments (name, email, text, ip, location) VALUES (?, ?, ?, ?, ?)",
-		name, email, text, ip, location,
-	)
+// acceptingComments refuses ip if it's blocklisted and everybody if site
+// is closed for comments. Refusals are *apiError.
+func acceptingComments(ctx context.Context, cfg Config, site *Site, ip string) error {
+	blocked, err := store.IsBlocked(ctx, ip)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
-		return
+		return err
+	}
+	if blocked {
+		return &apiError{status: http.StatusForbidden, code: codeForbidden, message: "Forbidden"}
 	}
+	if closed := closedSince(site, cfg, time.Now()); !closed.IsZero() {
+		return &apiError{status: http.StatusForbidden, code: codeCommentsClosed, message: "Comments are closed", details: map[string]any{"closed_after": closed}}
+	}
+	return nil
+}
 
-	logRequest(ip, location, fmt.Sprintf("name=%s email=%s comment=%s", name, email, text))
+// submitComment checks in and stores it as a comment of site. If the same
+// comment came in within duplicate_window it isn't stored again, and the
+// earlier one is returned with dup set. Refusals are *apiError.
+func submitComment(ctx context.Context, log *slog.Logger, site *Site, in commentInput) (c *Comment, dup bool, err error) {
+	cfg := settings()
+	if err := acceptingComments(ctx, cfg, site, in.IP); err != nil {
+		return nil, false, err
+	}
+	if in.Commenter == nil && cfg.RequireSignIn {
+		return nil, false, &apiError{status: http.StatusUnauthorized, code: codeSignInRequired, message: "Sign in to comment"}
+	}
+	if in.Name == "" || in.Email == "" || in.Text == "" {
+		var missing []string
+		for _, f := range []struct{ name, value string }{{"name", in.Name}, {"email", in.Email}, {"comment", in.Text}} {
+			if f.value == "" {
+				missing = append(missing, f.name)
+			}
+		}
+		return nil, false, &apiError{status: 400, code: codeMissingFields, message: "All fields (name, email, comment) are required", details: map[string]any{"fields": missing}}
+	}
 
-	w.WriteHeader(http.StatusCreated)
-	fmt.Fprintln(w, "Comment added successfully")
+	consentVersion := ""
+	if in.Consent {
+		consentVersion = cfg.PolicyVersion
+	} else if cfg.RequireConsent || site.RequireConsent {
+		return nil, false, &apiError{status: 400, code: codeConsentRequired, message: "Consent to the privacy policy is required"}
+	}
+
+	location := lookupLocation(ctx, log, in.IP)
+
+	c = &Comment{
+		SiteID:         site.ID,
+		Name:           in.Name,
+		Email:          in.Email,
+		Text:           in.Text,
+		IP:             in.IP,
+		Location:       location,
+		ConsentVersion: consentVersion,
+	}
+	if s := in.Commenter; s != nil {
+		c.Website, c.Avatar, c.Provider, c.Authenticated = s.Website, s.Avatar, s.Provider, true
+	}
+	if c.Returning, err = isReturning(ctx, c, in.Remembered); err != nil {
+		return nil, false, err
+	}
+	if c.Verified, err = emailVerified(ctx, c.Email, in.VerifiedEmail); err != nil {
+		return nil, false, err
+	}
+	first, err := findDuplicate(ctx, c, time.Duration(cfg.DuplicateWindow)*time.Second)
+	if err != nil {
+		return nil, false, err
+	}
+	if first != nil {
+		log.Info("duplicate comment ignored", "site", site.Slug, "name", in.Name, "email", in.Email)
+		return first, true, nil
+	}
+	if in.Claim != nil {
+		if err := in.Claim(ctx); err != nil {
+			return nil, false, err
+		}
+	}
+	if err := checkLinks(ctx, log, cfg, in.Text); err != nil {
+		log.Info("comment refused by the link policy", "site", site.Slug, "error", err, "name", in.Name, "email", in.Email)
+		return nil, false, err
+	}
+	spam := scoreSpam(ctx, log, cfg, in)
+	c.SpamScore, c.SpamReasons = spam.Score, strings.Join(spam.Reasons, "; ")
+	if cfg.SpamRejectScore > 0 && spam.Score >= cfg.SpamRejectScore {
+		log.Info("comment rejected as spam", "site", site.Slug, "score", spam.Score, "reasons", c.SpamReasons, "name", in.Name, "email", in.Email)
+		return nil, false, &apiError{status: http.StatusForbidden, code: codeSpamRejected, message: "The comment looks like spam"}
+	}
+	c.Status = moderationStatus(cfg, site, c)
+	if cfg.DoubleOptIn && !c.Verified {
+		// hidden from everyone until the link in the email is followed,
+		// see confirmComments
+		c.Status = "unconfirmed"
+	}
+	for _, up := range []struct {
+		file *mediaUpload
+		url  *string
+	}{{in.Image, &c.Image}, {in.Avatar, &c.Avatar}} {
+		if up.file == nil {
+			continue
+		}
+		if err := media.Put(ctx, up.file.Name, up.file.ContentType, up.file.Data); err != nil {
+			return nil, false, fmt.Errorf("storing %s: %w", up.file.Name, err)
+		}
+		*up.url = mediaURL(cfg, up.file.Name)
+	}
+	if c.Avatar == "" && cfg.Identicons {
+		c.Avatar = identiconURL(cfg, c.Email)
+	}
+	if err := store.Create(ctx, c); err != nil {
+		return nil, false, err
+	}
+	if cfg.EmailVerificationSecret != "" && !c.Verified {
+		sendVerification(ctx, log, cfg, site, c)
+	}
+
+	if c.Status != "unconfirmed" {
+		notify(ctx, log, Notification{Event: "comment.created", Site: site, Comment: c})
+	}
+
+	log.Info("comment added", "site", site.Slug, "status", c.Status, "location", location, "name", in.Name, "email", in.Email, "comment", in.Text)
+	return c, false, nil
+}
+
+// moderationStatus is the status a new comment of site starts out with:
+// the site's moderation, approved for commenters one of the approve_*
+// settings trusts, but pending if the spam checks scored it at
+// spam_moderate_score or more.
+func moderationStatus(cfg Config, site *Site, c *Comment) string {
+	status := site.Moderation
+	if (c.Authenticated && cfg.ApproveAuthenticated) || (c.Returning && cfg.ApproveReturning) || (c.Verified && cfg.ApproveVerified) {
+		status = "approved"
+	}
+	if cfg.SpamModerateScore > 0 && c.SpamScore >= cfg.SpamModerateScore && status == "approved" {
+		status = "pending"
+	}
+	return status
 }
 
-func getIP(r *http.Request) string {
-	ip := r.Header.Get("X-Forwarded-For")
-	if ip == "" {
-		ip = r.RemoteAddr
+// findDuplicate returns the last comment from c's IP or email within
+// window if it has the same name and text, or nil.
+func findDuplicate(ctx context.Context, c *Comment, window time.Duration) (*Comment, error) {
+	if window <= 0 {
+		return nil, nil
 	}
-	if strings.Contains(ip, ":") {
-		host, _, err := net.SplitHostPort(ip)
-		if err == nil {
-			return host
+	since := time.Now().Add(-window)
+	for _, q := range []CommentQuery{
+		{SiteID: c.SiteID, IP: c.IP, Since: since, Limit: 1},
+		{SiteID: c.SiteID, Email: c.Email, Since: since, Limit: 1},
+	} {
+		last, err := store.List(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		if len(last) == 1 && last[0].Name == c.Name && last[0].Text == c.Text {
+			return &last[0], nil
 		}
 	}
-	return ip
+	return nil, nil
+}
+
+// hasConsent accepts the values a checkbox or API client would send.
+func hasConsent(v string) bool {
+	switch strings.ToLower(v) {
+	case "on", "1", "true", "yes":
+		return true
+	}
+	return false
 }
 
 func getLocation(ip string) string {
@@ -183,9 +1056,3 @@ func getLocation(ip string) string {
 	}
 	return "Unknown Location"
 }
-
-func logRequest(ip, location, data string) {
-	entry := fmt.Sprintf("[%s] [%s] [%s] [%s]\n",
-		ip, time.Now().Format(time.RFC3339), location, data)
-	io.WriteString(logFile, entry)
-}
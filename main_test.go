@@ -3,7 +3,6 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
-	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -14,42 +13,60 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// testLogFile receives everything logged during tests
+var testLogFile *os.File
+
 func TestMain(m *testing.M) {
-	// Setup test database in memory
+	// Setup test database in memory, or without cgo, where there's no
+	// SQLite, leave db unset and the tests needing it skip, see needSQLite
 	var err error
-	db, err = sql.Open("sqlite3", ":memory:")
-	if err != nil {
-		panic(err)
+	store = newMemoryStore()
+	if sqliteAvailable {
+		db, err = sql.Open("sqlite3", ":memory:")
+		if err != nil {
+			panic(err)
+		}
+
+		// Every new connection to :memory: is a fresh database, so stick to one
+		db.SetMaxOpenConns(1)
+		store = &sqlStore{db: db}
+
+		// Create tables
+		if err := initSchema(); err != nil {
+			panic(err)
+		}
 	}
 
-	// Create table
-	_, err = db.Exec(`
-		CREATE TABLE comments (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			name TEXT,
-			email TEXT,
-			text TEXT,
-			ip TEXT,
-			location TEXT,
-			created DATETIME DEFAULT CURRENT_TIMESTAMP
-		)
-	`)
+	// Setup temp log file
+	testLogFile, err = ioutil.TempFile("", "test_log")
 	if err != nil {
 		panic(err)
 	}
-
-	// Setup temp log file
-	logFile, err = ioutil.TempFile("", "test_log")
+	defer os.Remove(testLogFile.Name())
+	defer testLogFile.Close()
+	logger, err = newLogger(testLogFile)
 	if err != nil {
 		panic(err)
 	}
-	defer os.Remove(logFile.Name())
-	defer logFile.Close()
 
 	os.Exit(m.Run())
 }
 
+// needSQLite skips a test that uses the SQLite database, which builds
+// without cgo don't have.
+func needSQLite(t *testing.T) {
+	t.Helper()
+	if db == nil {
+		t.Skip("needs SQLite, which builds without cgo don't have")
+	}
+}
+
 func TestGetIP(t *testing.T) {
+	defer forwarding.Store(forwarding.Load())
+	c := defaultConfig()
+	c.TrustedProxies = []string{"127.0.0.1", "10.0.0.0/8"}
+	setForwarding(c)
+
 	tests := []struct {
 		name          string
 		xForwardedFor string
@@ -68,6 +85,24 @@ func TestGetIP(t *testing.T) {
 			remoteAddr:    "127.0.0.1",
 			expected:      "203.0.113.1",
 		},
+		{
+			name:          "X-Forwarded-For from a trusted network",
+			xForwardedFor: "203.0.113.1",
+			remoteAddr:    "10.1.2.3:40000",
+			expected:      "203.0.113.1",
+		},
+		{
+			name:          "X-Forwarded-For from an untrusted peer",
+			xForwardedFor: "203.0.113.1",
+			remoteAddr:    "198.51.100.7:40000",
+			expected:      "198.51.100.7",
+		},
+		{
+			name:          "Invalid X-Forwarded-For",
+			xForwardedFor: "unknown",
+			remoteAddr:    "127.0.0.1:40000",
+			expected:      "127.0.0.1",
+		},
 		{
 			name:          "IP with port",
 			xForwardedFor: "",
@@ -98,6 +133,47 @@ func TestGetIP(t *testing.T) {
 	}
 }
 
+func TestForwardingHeaders(t *testing.T) {
+	defer forwarding.Store(forwarding.Load())
+	c := defaultConfig()
+	c.TrustedProxies = []string{"127.0.0.1", "10.0.0.0/8"}
+
+	tests := []struct {
+		name   string
+		using  string
+		peer   string
+		header http.Header
+		want   string
+	}{
+		{"Rightmost untrusted hop", "X-Forwarded-For", "10.0.0.1:1", http.Header{"X-Forwarded-For": {"198.51.100.1, 203.0.113.1, 10.0.0.2"}}, "203.0.113.1"},
+		{"Several X-Forwarded-For headers", "X-Forwarded-For", "10.0.0.1:1", http.Header{"X-Forwarded-For": {"198.51.100.1", "203.0.113.1"}}, "203.0.113.1"},
+		{"Only trusted hops", "X-Forwarded-For", "10.0.0.1:1", http.Header{"X-Forwarded-For": {"10.0.0.5, 10.0.0.2"}}, "10.0.0.5"},
+		{"Unknown hop", "X-Forwarded-For", "10.0.0.1:1", http.Header{"X-Forwarded-For": {"198.51.100.1, unknown, 10.0.0.2"}}, "10.0.0.2"},
+		{"IPv6 hop", "X-Forwarded-For", "10.0.0.1:1", http.Header{"X-Forwarded-For": {"2001:db8::17"}}, "2001:db8::17"},
+		{"Spoofed Forwarded", "X-Forwarded-For", "10.0.0.1:1", http.Header{"Forwarded": {"for=192.0.2.60"}, "X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"Only a spoofed Forwarded", "X-Forwarded-For", "10.0.0.1:1", http.Header{"Forwarded": {"for=192.0.2.60"}}, "10.0.0.1"},
+		{"Spoofed X-Real-IP", "X-Forwarded-For", "10.0.0.1:1", http.Header{"X-Real-Ip": {"192.0.2.60"}, "X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"X-Real-IP", "x-real-ip", "127.0.0.1:1", http.Header{"X-Real-Ip": {"203.0.113.9"}}, "203.0.113.9"},
+		{"X-Real-IP from an untrusted peer", "X-Real-IP", "198.51.100.7:1", http.Header{"X-Real-Ip": {"203.0.113.9"}}, "198.51.100.7"},
+		{"Forwarded", "Forwarded", "10.0.0.1:1", http.Header{"Forwarded": {"for=192.0.2.60;proto=http;by=203.0.113.43"}}, "192.0.2.60"},
+		{"Forwarded chain", "Forwarded", "10.0.0.1:1", http.Header{"Forwarded": {`for=198.51.100.1, for="[2001:db8:cafe::17]:4711";proto=https`, "for=10.0.0.2"}}, "2001:db8:cafe::17"},
+		{"Forwarded with a port", "Forwarded", "10.0.0.1:1", http.Header{"Forwarded": {`For="192.0.2.60:8080"`}}, "192.0.2.60"},
+		{"Obfuscated Forwarded hop", "Forwarded", "10.0.0.1:1", http.Header{"Forwarded": {"for=_hidden, for=10.0.0.2"}}, "10.0.0.2"},
+		{"Spoofed X-Forwarded-For", "Forwarded", "10.0.0.1:1", http.Header{"Forwarded": {"for=192.0.2.60"}, "X-Forwarded-For": {"198.51.100.1"}}, "192.0.2.60"},
+		{"Forwarded from an untrusted peer", "Forwarded", "198.51.100.7:1", http.Header{"Forwarded": {"for=192.0.2.60"}}, "198.51.100.7"},
+	}
+	for _, tt := range tests {
+		c.ClientIPHeader = tt.using
+		setForwarding(c)
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.peer
+		req.Header = tt.header
+		if got := getIP(req); got != tt.want {
+			t.Errorf("%s: getIP() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
 func TestGetLocation(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -136,39 +212,8 @@ func TestGetLocation(t *testing.T) {
 	}
 }
 
-func TestLogRequest(t *testing.T) {
-	// Clear the log file
-	logFile.Truncate(0)
-	logFile.Seek(0, 0)
-
-	ip := "192.168.1.1"
-	location := "Test Location"
-	data := "test data"
-
-	logRequest(ip, location, data)
-
-	// Read the log file
-	logFile.Seek(0, 0)
-	content, err := io.ReadAll(logFile)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
-	if len(lines) != 1 {
-		t.Errorf("Expected 1 line, got %d", len(lines))
-	}
-
-	line := lines[0]
-	expectedParts := []string{ip, location, data}
-	for _, part := range expectedParts {
-		if !strings.Contains(line, part) {
-			t.Errorf("Log line does not contain %q: %q", part, line)
-		}
-	}
-}
-
 func TestAddComment(t *testing.T) {
+	needSQLite(t)
 	// Clear table
 	_, err := db.Exec("DELETE FROM comments")
 	if err != nil {
@@ -234,6 +279,7 @@ func TestAddComment(t *testing.T) {
 }
 
 func TestGetComments(t *testing.T) {
+	needSQLite(t)
 	// Clear table
 	_, err := db.Exec("DELETE FROM comments")
 	if err != nil {
@@ -302,6 +348,11 @@ func TestGetComments(t *testing.T) {
 			if len(comments) != tt.expected {
 				t.Errorf("Expected %d comments, got %d", tt.expected, len(comments))
 			}
+			for _, c := range comments {
+				if c.Email != "" || c.IP != "" {
+					t.Errorf("Public listing has the email and IP of %+v", c)
+				}
+			}
 
 			// Check order (DESC by created)
 			if len(comments) > 1 {
@@ -313,7 +364,33 @@ func TestGetComments(t *testing.T) {
 	}
 }
 
+func TestGetCommentsAdmin(t *testing.T) {
+	needSQLite(t)
+	defer func(c Config) { config = c }(config)
+	config.AdminToken = "secret"
+	if _, err := db.Exec("DELETE FROM comments"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO comments (name, email, text, ip, location) VALUES ('Alice', 'alice@example.com', 'Hi', '1.2.3.4', '')"); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	recorder := httptest.NewRecorder()
+	getComments(recorder, req, 15)
+
+	var comments []Comment
+	if err := json.NewDecoder(recorder.Body).Decode(&comments); err != nil {
+		t.Fatal(err)
+	}
+	if len(comments) != 1 || comments[0].Email != "alice@example.com" || comments[0].IP != "1.2.3.4" {
+		t.Errorf("Admin listing %+v, want the email and IP", comments)
+	}
+}
+
 func TestCommentsHandler(t *testing.T) {
+	needSQLite(t)
 	// Clear table
 	_, err := db.Exec("DELETE FROM comments")
 	if err != nil {
@@ -404,3 +481,126 @@ func TestAllCommentsHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestAddCommentConsent(t *testing.T) {
+	needSQLite(t)
+	defer func(c Config) { config = c }(config)
+	config.PolicyVersion = "2024-05"
+
+	_, err := db.Exec("DELETE FROM comments")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		require        bool
+		formData       string
+		expectedStatus int
+		expectedPolicy string
+	}{
+		{
+			name:           "Consent optional, not given",
+			require:        false,
+			formData:       "name=John&email=john@example.com&comment=Hello",
+			expectedStatus: 201,
+			expectedPolicy: "",
+		},
+		{
+			name:           "Consent optional, given",
+			require:        false,
+			formData:       "name=John&email=john@example.com&comment=Hello&consent=on",
+			expectedStatus: 201,
+			expectedPolicy: "2024-05",
+		},
+		{
+			name:           "Consent required, not given",
+			require:        true,
+			formData:       "name=John&email=john@example.com&comment=Hello&consent=no",
+			expectedStatus: 400,
+		},
+		{
+			name:           "Consent required, given",
+			require:        true,
+			formData:       "name=John&email=john@example.com&comment=Hello&consent=true",
+			expectedStatus: 201,
+			expectedPolicy: "2024-05",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.RequireConsent = tt.require
+
+			req := httptest.NewRequest("POST", "/", strings.NewReader(tt.formData))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			recorder := httptest.NewRecorder()
+
+			addComment(recorder, req)
+
+			if recorder.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+			if tt.expectedStatus != 201 {
+				return
+			}
+
+			var policy string
+			err := db.QueryRow("SELECT consent_version FROM comments ORDER BY id DESC LIMIT 1").Scan(&policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if policy != tt.expectedPolicy {
+				t.Errorf("Expected consent_version %q, got %q", tt.expectedPolicy, policy)
+			}
+		})
+	}
+}
+
+func TestAddCommentDuplicate(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	defer func(s CommentStore) { store = s }(store)
+	store = newMemoryStore()
+	config.DuplicateWindow = 60
+
+	tests := []struct {
+		name       string
+		form       string
+		remoteAddr string
+		wantStatus int
+		wantCount  int
+	}{
+		{"First", "name=Ann&email=ann@example.com&comment=Hi", "192.0.2.1:1234", 201, 1},
+		{"Same again", "name=Ann&email=ann@example.com&comment=Hi", "192.0.2.1:1234", 200, 1},
+		{"Same email, new IP", "name=Ann&email=ann@example.com&comment=Hi", "192.0.2.9:1234", 200, 1},
+		{"Different text", "name=Ann&email=ann@example.com&comment=Hi again", "192.0.2.1:1234", 201, 2},
+		// only the last comment counts, so going back to an earlier text is fine
+		{"Earlier text", "name=Ann&email=ann@example.com&comment=Hi", "192.0.2.1:1234", 201, 3},
+		{"Someone else", "name=Bob&email=bob@example.com&comment=Hi", "198.51.100.1:1234", 201, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/comments", strings.NewReader(tt.form))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.RemoteAddr = tt.remoteAddr
+			rec := httptest.NewRecorder()
+			addComment(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if n, _ := store.Count(t.Context(), CommentQuery{}); n != tt.wantCount {
+				t.Errorf("%d comments stored, want %d", n, tt.wantCount)
+			}
+		})
+	}
+
+	config.DuplicateWindow = 0
+	req := httptest.NewRequest("POST", "/comments", strings.NewReader("name=Bob&email=bob@example.com&comment=Hi"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = "198.51.100.1:1234"
+	rec := httptest.NewRecorder()
+	addComment(rec, req)
+	if rec.Code != 201 {
+		t.Errorf("With duplicate_window = 0, status = %d, want 201", rec.Code)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With comment_management, /my-comments lets commenters see, edit and
+// delete their own comments without an account: they enter their email,
+// and get a link carrying a JWT signed with email_verification_secret
+// that opens the list of that email's comments on the site. Like
+// verification links nothing is stored for it, and the link is the only
+// credential, so the page posts it back in a hidden field.
+
+// manageIssuer is the iss and aud of links to /my-comments.
+const manageIssuer = "guestbook-manage"
+
+// manageLinkTTL is how long a link to /my-comments works.
+const manageLinkTTL = 24 * time.Hour
+
+// manageResendInterval is how long an address waits for another link
+// from a site.
+const manageResendInterval = 10 * time.Minute
+
+// manageView is what manage.html is rendered with. Without a Token it
+// shows the form asking for an email.
+type manageView struct {
+	Title    string
+	Action   string
+	CSRF     string
+	Token    string
+	Email    string
+	Comments []Comment
+	// Archived are the commenter's archived comments, which are read-only.
+	Archived []Comment
+	Notice   string
+	Error    string
+}
+
+// manageEmailData is what manage_comments is rendered with.
+type manageEmailData struct {
+	Site string
+	Name string
+	URL  string
+}
+
+// manageCommentsHandler serves /my-comments: the form that emails a link
+// on GET and POST without a token, and with one the commenter's comments,
+// which POSTs with action edit or delete change.
+func manageCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPost:
+	default:
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if r.Method == http.MethodPost && (!checkCSRF(w, r) || !parseForm(w, r)) {
+		return
+	}
+	cfg := settings()
+	site := siteFor(r)
+	v := manageView{Title: cmp.Or(cfg.PageTitle, "Guestbook"), Action: manageURL(r, "")}
+	if config.MultiTenant {
+		v.Title = site.Name
+	}
+
+	status := http.StatusOK
+	// refusals are shown on the page
+	refused := func(err error) bool {
+		var ae *apiError
+		if !errors.As(err, &ae) {
+			internalError(w, r, err)
+			return false
+		}
+		w.Header().Set("X-Error-Code", ae.code)
+		status, v.Error = ae.status, ae.message
+		return true
+	}
+
+	token := r.FormValue("token")
+	if token == "" {
+		if r.Method == http.MethodPost {
+			email := strings.TrimSpace(r.PostFormValue("email"))
+			if err := sendManageLink(r.Context(), requestLogger(r), cfg, site, email); err == nil {
+				// the same whether or not the email has comments, so the
+				// page doesn't tell who commented
+				v.Notice = "If you've commented with that address, we've emailed you a link to your comments. It works for a day."
+			} else if !refused(err) {
+				return
+			}
+			v.Email = email
+		}
+		renderManagePage(w, r, status, v)
+		return
+	}
+
+	claims, err := manageToken(cfg, token)
+	if err == nil && int(claims["site"].(float64)) != site.ID {
+		err = errors.New("link for another site")
+	}
+	if err != nil {
+		requestLogger(r).Info("comment management link refused", "error", err)
+		w.Header().Set("X-Error-Code", codeInvalidLink)
+		v.Error = "The link is invalid or has expired, enter your email to get a new one."
+		renderManagePage(w, r, http.StatusBadRequest, v)
+		return
+	}
+	email := claims.str("sub")
+
+	if r.Method == http.MethodPost {
+		err := manageComment(r.Context(), requestLogger(r), cfg, site, email, r.PostFormValue("action"), r.PostFormValue("id"), r.PostFormValue("comment"))
+		if err == nil {
+			http.Redirect(w, r, manageURL(r, token)+"&done="+url.QueryEscape(r.PostFormValue("action")), http.StatusSeeOther)
+			return
+		}
+		if !refused(err) {
+			return
+		}
+	}
+
+	for _, archived := range []bool{false, true} {
+		comments, err := store.List(r.Context(), CommentQuery{SiteID: site.ID, Email: email, Archived: archived})
+		if err != nil {
+			internalError(w, r, err)
+			return
+		}
+		for _, c := range comments {
+			// what the admins marked as spam stays out of sight
+			if c.Status == "spam" {
+				continue
+			}
+			if archived {
+				v.Archived = append(v.Archived, c)
+			} else {
+				v.Comments = append(v.Comments, c)
+			}
+		}
+	}
+	v.Token, v.Email = token, email
+	switch r.URL.Query().Get("done") {
+	case "edit":
+		v.Notice = "Your comment was saved."
+	case "delete":
+		v.Notice = "Your comment was deleted."
+	}
+	renderManagePage(w, r, status, v)
+}
+
+func renderManagePage(w http.ResponseWriter, r *http.Request, status int, v manageView) {
+	v.CSRF = csrfToken(w, r)
+	w.Header().Set("Cache-Control", "no-store")
+	// the link carries the token, keep it out of Referer headers
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'self' 'unsafe-inline'; img-src 'self' https:; form-action 'self'; frame-ancestors 'none'")
+	renderHTML(w, r, templates.Load().page, status, "manage.html", v)
+}
+
+// manageURL is /my-comments of r's site, with token if it isn't empty.
+func manageURL(r *http.Request, token string) string {
+	q := url.Values{}
+	if site := r.URL.Query().Get("site"); site != "" {
+		q.Set("site", site)
+	}
+	if token != "" {
+		q.Set("token", token)
+	}
+	if len(q) == 0 {
+		return "/my-comments"
+	}
+	return "/my-comments?" + q.Encode()
+}
+
+// sendManageLink queues an email with a link to /my-comments to email, if
+// it has comments on site, live or archived. Refusals are *apiError.
+func sendManageLink(ctx context.Context, log *slog.Logger, cfg Config, site *Site, email string) error {
+	if email == "" || !strings.Contains(email, "@") {
+		return &apiError{status: 400, code: codeMissingFields, message: "Enter the email address you commented with", details: map[string]any{"fields": []string{"email"}}}
+	}
+	latest, err := store.List(ctx, CommentQuery{SiteID: site.ID, Email: email, Limit: 1})
+	if err == nil && len(latest) == 0 {
+		latest, err = store.List(ctx, CommentQuery{SiteID: site.ID, Email: email, Limit: 1, Archived: true})
+	}
+	if err != nil || len(latest) == 0 {
+		return err
+	}
+	sentKey := fmt.Sprintf("manage-link-sent:%d:%s", site.ID, emailHash(email))
+	if ok, err := shared.SetNX(ctx, sentKey, []byte("1"), manageResendInterval); err != nil || !ok {
+		return err
+	}
+
+	now := time.Now()
+	token, err := signJWT([]byte(cfg.EmailVerificationSecret), jwtClaims{
+		"iss":  manageIssuer,
+		"aud":  manageIssuer,
+		"sub":  strings.ToLower(email),
+		"site": site.ID,
+		"iat":  now.Unix(),
+		"exp":  now.Add(manageLinkTTL).Unix(),
+	})
+	if err == nil {
+		q := url.Values{"token": {token}}
+		if config.MultiTenant {
+			q.Set("site", site.Slug)
+		}
+		var e emailMessage
+		data := manageEmailData{
+			Site: cmp.Or(site.Name, site.Slug, "the guestbook"),
+			Name: latest[0].Name,
+			URL:  strings.TrimSuffix(cfg.PublicURL, "/") + "/my-comments?" + q.Encode(),
+		}
+		if e, err = renderEmail(cfg, "manage_comments", data); err == nil {
+			err = enqueue(ctx, "email", email, e)
+		}
+	}
+	if err != nil {
+		countNotifier("email", "errors")
+		shared.Delete(ctx, sentKey)
+		return err
+	}
+	log.Info("comment management link sent", "site", site.Slug, "email", email)
+	countNotifier("email", "queued")
+	wakeOutbox()
+	return nil
+}
+
+// manageComment edits or deletes comment id of site for whoever followed
+// a link for email. An edit goes through the link policy and the spam
+// checks like a new comment, and an approved comment goes back to
+// moderation if a new one with its text would have. Refusals are
+// *apiError.
+func manageComment(ctx context.Context, log *slog.Logger, cfg Config, site *Site, email, action, id, text string) error {
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return &apiError{status: 400, code: codeInvalidID, message: "id must be a comment id"}
+	}
+	c, err := store.Get(ctx, site.ID, n)
+	if err != nil && !errors.Is(err, errNotFound) {
+		return err
+	}
+	// someone else's comment is as good as none
+	if c == nil || !strings.EqualFold(c.Email, email) || c.Status == "spam" {
+		return &apiError{status: http.StatusNotFound, code: codeNotFound, message: "Comment not found"}
+	}
+
+	switch action {
+	case "delete":
+		if err := store.Delete(ctx, site.ID, c.ID); err != nil {
+			return err
+		}
+		log.Info("comment deleted by its commenter", "site", site.Slug, "id", c.ID)
+		return nil
+	case "edit":
+	default:
+		return &apiError{status: 400, code: codeInvalidForm, message: "action must be edit or delete"}
+	}
+
+	if text = strings.TrimSpace(text); text == "" {
+		return &apiError{status: 400, code: codeMissingFields, message: "The comment can't be empty", details: map[string]any{"fields": []string{"comment"}}}
+	}
+	if err := checkLinks(ctx, log, cfg, text); err != nil {
+		return err
+	}
+	spam := scoreSpam(ctx, log, cfg, commentInput{Name: c.Name, Email: c.Email, Text: text, IP: c.IP})
+	if cfg.SpamRejectScore > 0 && spam.Score >= cfg.SpamRejectScore {
+		log.Info("comment edit rejected as spam", "site", site.Slug, "id", c.ID, "score", spam.Score)
+		return &apiError{status: http.StatusForbidden, code: codeSpamRejected, message: "The comment looks like spam"}
+	}
+	c.Text = text
+	c.SpamScore, c.SpamReasons = spam.Score, strings.Join(spam.Reasons, "; ")
+	if c.Status == "approved" {
+		c.Status = moderationStatus(cfg, site, c)
+	}
+	if err := store.Update(ctx, c); err != nil {
+		return err
+	}
+	log.Info("comment edited by its commenter", "site", site.Slug, "id", c.ID, "status", c.Status)
+	return nil
+}
+
+// manageToken checks the token of a link to /my-comments and returns its
+// claims.
+func manageToken(cfg Config, token string) (jwtClaims, error) {
+	h, claims, signed, sig, err := splitJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(h.Alg, []byte(cfg.EmailVerificationSecret), signed, sig); err != nil {
+		return nil, err
+	}
+	if claims.str("iss") != manageIssuer || !claims.hasAudience(manageIssuer) || claims.str("sub") == "" {
+		return nil, errors.New("not a comment management link of the guestbook")
+	}
+	if _, ok := claims["site"].(float64); !ok {
+		return nil, errors.New("comment management link without a site")
+	}
+	if err := claims.checkTimes(time.Now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
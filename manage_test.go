@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManageComments(t *testing.T) {
+	needSQLite(t)
+	defer func(c Config, s CommentStore, st SharedState) { config, store, shared = c, s, st }(config, store, shared)
+	store, shared = newMemoryStore(), newMemoryState()
+	db.Exec("DELETE FROM outbox")
+	config.EmailVerificationSecret, config.CommentManagement = strings.Repeat("s", 32), true
+	config.PublicURL = "https://guestbook.example"
+
+	var ids []int
+	for _, c := range []Comment{
+		{Name: "Ann", Email: "ann@example.com", Text: "First", Status: "approved"},
+		{Name: "Ann", Email: "Ann@example.com", Text: "Buy pills", Status: "spam"},
+		{Name: "Bob", Email: "bob@example.com", Text: "Bob's", Status: "approved"},
+		{Name: "Ann", Email: "ann@example.com", Text: "Long ago", Status: "approved", Created: time.Now().AddDate(-5, 0, 0)},
+		{Name: "Cy", Email: "cy@example.com", Text: "Cy's", Status: "approved", Created: time.Now().AddDate(-5, 0, 0)},
+	} {
+		if err := store.Create(t.Context(), &c); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, c.ID)
+	}
+	ids = ids[:3]
+	if _, err := store.Archive(t.Context(), 0, time.Now().AddDate(-1, 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, target string, form url.Values) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		manageCommentsHandler(rec, req)
+		return rec
+	}
+	queued := func() []string {
+		var payloads []string
+		rows, _ := db.Query("SELECT payload FROM outbox ORDER BY id")
+		for rows.Next() {
+			var p string
+			rows.Scan(&p)
+			payloads = append(payloads, p)
+		}
+		rows.Close()
+		return payloads
+	}
+
+	if rec := do("GET", "/my-comments", nil); rec.Code != 200 || !strings.Contains(rec.Body.String(), `name="email"`) {
+		t.Fatalf("GET = %d %s", rec.Code, rec.Body)
+	}
+	// no comments, no email, and no hint of that either
+	nobody := do("POST", "/my-comments", url.Values{"email": {"nobody@example.com"}})
+	if nobody.Code != 200 || len(queued()) != 0 {
+		t.Fatalf("POST for an unknown email = %d, %d queued", nobody.Code, len(queued()))
+	}
+	rec := do("POST", "/my-comments", url.Values{"email": {"ANN@example.com"}})
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), "emailed you a link") {
+		t.Fatalf("POST = %d %s", rec.Code, rec.Body)
+	}
+	do("POST", "/my-comments", url.Values{"email": {"ann@example.com"}})
+	payloads := queued()
+	if len(payloads) != 1 {
+		t.Fatalf("%d emails queued, want 1", len(payloads))
+	}
+	// archived comments count too
+	do("POST", "/my-comments", url.Values{"email": {"cy@example.com"}})
+	if n := len(queued()); n != 2 {
+		t.Errorf("%d emails queued after asking for archived comments, want 2", n)
+	}
+	if rec := do("POST", "/my-comments", url.Values{"email": {""}}); rec.Code != 400 || rec.Header().Get("X-Error-Code") != codeMissingFields {
+		t.Errorf("POST without an email = %d %s", rec.Code, rec.Body)
+	}
+
+	var e emailMessage
+	json.Unmarshal([]byte(payloads[0]), &e)
+	i := strings.Index(e.Body, "https://guestbook.example/my-comments?token=")
+	if i < 0 || !strings.Contains(e.Subject, "Your comments") {
+		t.Fatalf("Email %q\n%s", e.Subject, e.Body)
+	}
+	link, _ := url.Parse(strings.Fields(e.Body[i:])[0])
+	token := link.Query().Get("token")
+
+	rec = do("GET", link.RequestURI(), nil)
+	if body := rec.Body.String(); rec.Code != 200 || !strings.Contains(body, "First") || strings.Contains(body, "Buy pills") || strings.Contains(body, "Bob") {
+		t.Fatalf("GET %s = %d %s", link.RequestURI(), rec.Code, body)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "Archived comments") || !strings.Contains(body, "Long ago") || strings.Contains(body, "Cy&#39;s") {
+		t.Errorf("Archived comments on the page:\n%s", body)
+	}
+	if rec.Header().Get("Referrer-Policy") != "no-referrer" {
+		t.Errorf("Headers %v", rec.Header())
+	}
+	other, _ := signJWT([]byte(config.EmailVerificationSecret), jwtClaims{
+		"iss": manageIssuer, "aud": manageIssuer, "sub": "ann@example.com", "site": 5,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	expired, _ := signJWT([]byte(config.EmailVerificationSecret), jwtClaims{
+		"iss": manageIssuer, "aud": manageIssuer, "sub": "ann@example.com", "site": 0,
+		"exp": time.Now().Add(-time.Hour).Unix(),
+	})
+	for _, bad := range []string{token[:len(token)-2], other, expired} {
+		if rec := do("GET", "/my-comments?token="+url.QueryEscape(bad), nil); rec.Code != 400 || rec.Header().Get("X-Error-Code") != codeInvalidLink {
+			t.Errorf("Bad token: %d %s", rec.Code, rec.Body)
+		}
+	}
+
+	post := func(action string, id int, text string) *httptest.ResponseRecorder {
+		return do("POST", "/my-comments", url.Values{"token": {token}, "action": {action}, "id": {strconv.Itoa(id)}, "comment": {text}})
+	}
+	for _, id := range ids[1:] {
+		if rec := post("delete", id, ""); rec.Code != 404 {
+			t.Errorf("Deleting comment %d = %d", id, rec.Code)
+		}
+	}
+	if rec := post("edit", ids[0], " "); rec.Code != 400 {
+		t.Errorf("Empty edit = %d", rec.Code)
+	}
+	rec = post("edit", ids[0], "First, edited")
+	if rec.Code != 303 || !strings.HasPrefix(rec.Header().Get("Location"), "/my-comments?token=") {
+		t.Fatalf("Edit = %d %v", rec.Code, rec.Header())
+	}
+	if c, _ := store.Get(t.Context(), 0, ids[0]); c.Text != "First, edited" || c.Status != "approved" {
+		t.Errorf("Edited comment %+v", c)
+	}
+
+	// an edit into spam is scored again, and the score is kept
+	config.SpamWords, config.SpamWeights, config.SpamModerateScore, config.SpamRejectScore = []string{"casino"}, map[string]float64{"words": 2}, 1, 5
+	post("edit", ids[0], "Visit my casino")
+	if c, _ := store.Get(t.Context(), 0, ids[0]); c.Status != "pending" || c.SpamScore != 2 || c.SpamReasons != "words: casino (+2)" {
+		t.Errorf("Comment edited into spam %+v", c)
+	}
+	config.SpamWords = nil
+
+	// an edit on a moderated site waits for approval again
+	defer func(m string) { defaultSite.Moderation = m }(defaultSite.Moderation)
+	defaultSite.Moderation = "pending"
+	post("edit", ids[0], "Edited again")
+	if c, _ := store.Get(t.Context(), 0, ids[0]); c.Status != "pending" {
+		t.Errorf("Comment edited on a moderated site is %s", c.Status)
+	}
+
+	if rec := post("delete", ids[0], ""); rec.Code != 303 {
+		t.Fatalf("Delete = %d %s", rec.Code, rec.Body)
+	}
+	if _, err := store.Get(t.Context(), 0, ids[0]); err != errNotFound {
+		t.Errorf("Deleted comment: %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// With mastodon_server and mastodon_token, every comment that gets
+// approved is posted to that Mastodon account too, rendered through
+// mastodon_template and at most mastodon_max_per_hour an hour. The posts
+// go through the outbox like every other notification.
+
+func init() { registerNotifier(mastodonNotifier{}) }
+
+// defaultMastodonTemplate is mastodon_template's default.
+const defaultMastodonTemplate = "New in {{.Site}} from {{.Name}}:\n\n{{.Text}}\n\n{{.URL}}"
+
+// mastodonMaxChars is the length limit of a post on most servers.
+const mastodonMaxChars = 500
+
+// mastodonPostedTTL is how long a comment is remembered as posted, so
+// approving it again, or another instance, doesn't post it twice.
+const mastodonPostedTTL = 30 * 24 * time.Hour
+
+// mastodonPost is what mastodon_template is executed with.
+type mastodonPost struct {
+	Site, Name, Text, URL string
+}
+
+// mastodonStatus is a post waiting in the outbox.
+type mastodonStatus struct {
+	Status     string `json:"status"`
+	Visibility string `json:"visibility"`
+	// IdempotencyKey keeps a retry from posting twice. It goes in a
+	// header rather than the body.
+	IdempotencyKey string `json:"idempotency_key"`
+}
+
+// mastodonClient posts to mastodon_server.
+var mastodonClient = &http.Client{Timeout: 10 * time.Second, Transport: breakerTransport{http.DefaultTransport}}
+
+type mastodonNotifier struct{}
+
+func (mastodonNotifier) Name() string { return "mastodon" }
+
+func (mastodonNotifier) Enabled(c Config) bool { return c.MastodonServer != "" }
+
+// Messages posts n's comment once it's approved, unless it was posted
+// before or mastodon_max_per_hour is used up.
+func (mastodonNotifier) Messages(ctx context.Context, c Config, n Notification) ([]OutboxItem, error) {
+	if n.Event != "comment.approved" {
+		return nil, nil
+	}
+	first, err := shared.SetNX(ctx, "mastodon-posted:"+strconv.Itoa(n.Comment.ID), []byte("1"), mastodonPostedTTL)
+	if err != nil || !first {
+		return nil, err
+	}
+	if c.MastodonMaxPerHour > 0 {
+		count, err := shared.Incr(ctx, "mastodon-posts", time.Hour)
+		if err != nil {
+			return nil, err
+		}
+		if count > int64(c.MastodonMaxPerHour) {
+			logger.Info("Mastodon post skipped, mastodon_max_per_hour reached", "id", n.Comment.ID)
+			return nil, nil
+		}
+	}
+	text, err := renderMastodonPost(c.MastodonTemplate, n.Site, *n.Comment)
+	if err != nil {
+		return nil, err
+	}
+	status := mastodonStatus{Status: text, Visibility: c.MastodonVisibility, IdempotencyKey: "guestbook-comment-" + strconv.Itoa(n.Comment.ID)}
+	return []OutboxItem{{Target: c.MastodonServer, Payload: status}}, nil
+}
+
+// Deliver posts a queued status to server.
+func (mastodonNotifier) Deliver(ctx context.Context, server string, payload []byte) error {
+	return postMastodonStatus(ctx, server, payload)
+}
+
+// renderMastodonPost executes tmpl for c, shortening the comment's text
+// so the post fits in mastodonMaxChars.
+func renderMastodonPost(tmpl string, site *Site, c Comment) (string, error) {
+	t, err := template.New("mastodon").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+	p := mastodonPost{Site: cmp.Or(site.Name, site.Slug, "the guestbook"), Name: c.Name, Text: c.Text}
+	if config.PublicURL != "" {
+		p.URL = publicPageURL(site) + "#comment-" + strconv.Itoa(c.ID)
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, p); err != nil {
+		return "", err
+	}
+	if over := utf8.RuneCountInString(b.String()) - mastodonMaxChars; over > 0 {
+		text := []rune(p.Text)
+		p.Text = string(text[:max(len(text)-over-1, 0)]) + "…"
+		b.Reset()
+		if err := t.Execute(&b, p); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+// postMastodonStatus posts a queued status to server.
+func postMastodonStatus(ctx context.Context, server string, payload []byte) error {
+	var s mastodonStatus
+	if err := json.Unmarshal(payload, &s); err != nil {
+		return err
+	}
+	body, _ := json.Marshal(map[string]string{"status": s.Status, "visibility": s.Visibility})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(server, "/")+"/api/v1/statuses", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+settings().MastodonToken)
+	req.Header.Set("Idempotency-Key", s.IdempotencyKey)
+	resp, err := mastodonClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("POST %s: %s", req.URL, resp.Status)
+	}
+	return nil
+}
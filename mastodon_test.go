@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestRenderMastodonPost(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.PublicURL, config.MultiTenant = "https://guestbook.example", false
+	site := &Site{Slug: "default", Name: "My guestbook"}
+
+	got, err := renderMastodonPost(defaultMastodonTemplate, site, Comment{ID: 3, Name: "Ann", Text: "Hello"})
+	if want := "New in My guestbook from Ann:\n\nHello\n\nhttps://guestbook.example/#comment-3"; err != nil || got != want {
+		t.Errorf("renderMastodonPost() = %q, %v, want %q", got, err, want)
+	}
+	got, _ = renderMastodonPost(defaultMastodonTemplate, site, Comment{ID: 3, Name: "Ann", Text: strings.Repeat("é", 600)})
+	if n := utf8.RuneCountInString(got); n != mastodonMaxChars || !strings.Contains(got, "é…\n\nhttps://") {
+		t.Errorf("Long post is %d characters: %q", n, got)
+	}
+}
+
+func TestMastodon(t *testing.T) {
+	needSQLite(t)
+	defer func(c Config, s SharedState, client *http.Client) { config, shared, mastodonClient = c, s, client }(config, shared, mastodonClient)
+	shared = newMemoryState()
+	db.Exec("DELETE FROM outbox")
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var posts []map[string]string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		body["path"], body["auth"], body["key"] = r.URL.Path, r.Header.Get("Authorization"), r.Header.Get("Idempotency-Key")
+		mu.Lock()
+		posts = append(posts, body)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	mastodonClient = srv.Client()
+	config.MastodonServer, config.MastodonToken = srv.URL, "token"
+	config.MastodonTemplate, config.MastodonVisibility, config.MastodonMaxPerHour = "{{.Name}}: {{.Text}}", "unlisted", 2
+	config.OutboxMaxAttempts = 3
+
+	for _, c := range []Comment{{ID: 1, Name: "Ann", Text: "Hi"}, {ID: 1, Name: "Ann", Text: "Hi"}, {ID: 2, Name: "Bob", Text: "Yo"}, {ID: 3, Name: "Cat", Text: "Hey"}} {
+		notify(ctx, logger, Notification{Event: "comment.approved", Site: defaultSite, Comment: &c})
+	}
+	deliverDue(ctx)
+
+	if len(posts) != 2 {
+		t.Fatalf("Posted %d times, want 2 (one duplicate, one over the limit): %v", len(posts), posts)
+	}
+	want := map[string]string{"path": "/api/v1/statuses", "auth": "Bearer token", "key": "guestbook-comment-1", "status": "Ann: Hi", "visibility": "unlisted"}
+	for k, v := range want {
+		if posts[0][k] != v {
+			t.Errorf("Post %s = %q, want %q", k, posts[0][k], v)
+		}
+	}
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// With media_storage, a comment can come with an image, uploaded as the
+// image field of a multipart form. It has to be a JPEG, PNG or GIF of at
+// most media_max_bytes. JPEGs and PNGs are decoded and encoded again,
+// which drops EXIF and other metadata along with anything hiding in the
+// file; GIFs lose their comment and application blocks. It's stored
+// under the hash of the result in media_dir or media_s3_bucket. Either way
+// it's served at /media/<name>, and the comment's image is that URL.
+
+const (
+	// mediaMaxPixels bounds the size of an image once decoded, so a small
+	// file can't take all the memory: imageType checks the header for it
+	// before anything is decoded, and 16 megapixels decode to 64 MB.
+	mediaMaxPixels = 16_000_000
+	// mediaMultipartMemory is how much of a multipart form is kept in
+	// memory rather than in temporary files.
+	mediaMultipartMemory = 1 << 20
+)
+
+// MediaStore keeps uploaded images.
+type MediaStore interface {
+	// Put stores data as name, replacing what may be there.
+	Put(ctx context.Context, name, contentType string, data []byte) error
+	// Open returns the image called name, or an error matching
+	// fs.ErrNotExist.
+	Open(ctx context.Context, name string) (io.ReadSeekCloser, error)
+}
+
+// media is where images go, nil without media_storage.
+var media MediaStore
+
+// mediaUpload is an image that came with a comment, ready to store.
+type mediaUpload struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// mediaName matches the names images are stored under.
+var mediaName = regexp.MustCompile(`^[0-9a-f]{32}\.(jpg|png|gif)$`)
+
+var mediaTypes = map[string]string{"jpg": "image/jpeg", "png": "image/png", "gif": "image/gif"}
+
+// openMediaStore opens the store media_storage names, or returns nil when
+// it's empty.
+func openMediaStore(c Config) (MediaStore, error) {
+	switch c.MediaStorage {
+	case "disk":
+		if err := os.MkdirAll(c.MediaDir, 0o755); err != nil {
+			return nil, err
+		}
+		return diskMedia{c.MediaDir}, nil
+	case "s3":
+		client, err := newS3Client()
+		if err != nil {
+			return nil, err
+		}
+		return s3Media{client, c.MediaS3Bucket, c.MediaS3Prefix}, nil
+	}
+	return nil, nil
+}
+
+// diskMedia keeps images as files in a directory.
+type diskMedia struct{ dir string }
+
+func (m diskMedia) Put(_ context.Context, name, _ string, data []byte) error {
+	// written to a temporary file first so a crash can't leave half an
+	// image under the final name
+	f, err := os.CreateTemp(m.dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(m.dir, name))
+}
+
+func (m diskMedia) Open(_ context.Context, name string) (io.ReadSeekCloser, error) {
+	return os.Open(filepath.Join(m.dir, name))
+}
+
+// s3Media keeps images in a bucket of backup_s3_endpoint, under a prefix.
+type s3Media struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func (m s3Media) Put(ctx context.Context, name, contentType string, data []byte) error {
+	_, err := m.client.PutObject(ctx, m.bucket, path.Join(m.prefix, name), bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType:  contentType,
+		CacheControl: "public, max-age=31536000, immutable",
+	})
+	return err
+}
+
+func (m s3Media) Open(ctx context.Context, name string) (io.ReadSeekCloser, error) {
+	obj, err := m.client.GetObject(ctx, m.bucket, path.Join(m.prefix, name), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// GetObject doesn't ask the server until it's read
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, fs.ErrNotExist
+		}
+		return nil, err
+	}
+	return obj, nil
+}
+
+// mediaURL is where the image called name is served.
+func mediaURL(c Config, name string) string {
+	return strings.TrimSuffix(c.PublicURL, "/") + "/media/" + name
+}
+
+// formImage reads and cleans the image field of r's multipart form, if
+// there is one. Refusals are *apiError.
+func formImage(r *http.Request, c Config) (*mediaUpload, error) {
+	data, err := formFile(r, c, "image")
+	if data == nil || err != nil {
+		return nil, err
+	}
+	return cleanImage(data)
+}
+
+// formFile reads the file uploaded as field, nil if there is none, and
+// refuses it if it's larger than media_max_bytes.
+func formFile(r *http.Request, c Config, field string) ([]byte, error) {
+	if r.MultipartForm == nil {
+		return nil, nil
+	}
+	f, _, err := r.FormFile(field)
+	if errors.Is(err, http.ErrMissingFile) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, int64(c.MediaMaxBytes)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		// a form with a file input but no file chosen
+		return nil, nil
+	}
+	if len(data) > c.MediaMaxBytes {
+		return nil, &apiError{status: http.StatusRequestEntityTooLarge, code: codeImageTooLarge,
+			message: "The " + field + " is larger than " + strconv.Itoa(c.MediaMaxBytes) + " bytes", details: map[string]any{"field": field, "limit": c.MediaMaxBytes}}
+	}
+	return data, nil
+}
+
+// cleanImage checks that data is a JPEG, PNG or GIF and drops its
+// metadata. A JPEG is turned the way its EXIF orientation says first,
+// since that goes with the rest of the EXIF.
+func cleanImage(data []byte) (*mediaUpload, error) {
+	invalid := func(msg string) error {
+		return &apiError{status: 400, code: codeInvalidImage, message: msg}
+	}
+	ext, err := imageType(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	switch ext {
+	case "jpg":
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, invalid("The image can't be read")
+		}
+		err = jpeg.Encode(&out, orient(img, jpegOrientation(data)), &jpeg.Options{Quality: 90})
+		if err != nil {
+			return nil, err
+		}
+	case "png":
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, invalid("The image can't be read")
+		}
+		if err := png.Encode(&out, img); err != nil {
+			return nil, err
+		}
+	case "gif":
+		// decoding every frame of an animation could take far more memory
+		// than mediaMaxPixels allows for, so the blocks are filtered instead
+		if !stripGIF(&out, data) {
+			return nil, invalid("The image can't be read")
+		}
+	}
+	return newMediaUpload(ext, out.Bytes()), nil
+}
+
+// newMediaUpload names data, an image of type ext, after its hash.
+func newMediaUpload(ext string, data []byte) *mediaUpload {
+	sum := sha256.Sum256(data)
+	return &mediaUpload{Name: hex.EncodeToString(sum[:16]) + "." + ext, ContentType: mediaTypes[ext], Data: data}
+}
+
+// imageType checks that data is a JPEG, PNG or GIF of at most
+// mediaMaxPixels, returning its extension. Refusals are *apiError.
+func imageType(data []byte) (string, error) {
+	invalid := func(msg string) error {
+		return &apiError{status: 400, code: codeInvalidImage, message: msg}
+	}
+	var ext string
+	switch http.DetectContentType(data) {
+	case "image/jpeg":
+		ext = "jpg"
+	case "image/png":
+		ext = "png"
+	case "image/gif":
+		ext = "gif"
+	default:
+		return "", invalid("The image must be a JPEG, PNG or GIF")
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", invalid("The image can't be read")
+	}
+	if cfg.Width*cfg.Height > mediaMaxPixels {
+		return "", invalid(fmt.Sprintf("The image is %d×%d pixels, larger than allowed", cfg.Width, cfg.Height))
+	}
+	return ext, nil
+}
+
+// stripGIF copies the GIF in data to out without its comments, plain
+// text and application extensions, except the one that makes animations
+// loop. It reports whether data was a well-formed GIF.
+func stripGIF(out *bytes.Buffer, data []byte) bool {
+	// subBlocks returns where the data sub-blocks starting at i end
+	subBlocks := func(i int) int {
+		for i < len(data) {
+			n := int(data[i])
+			i += 1 + n
+			if n == 0 {
+				return i
+			}
+		}
+		return -1
+	}
+	colorTable := func(flags byte) int {
+		if flags&0x80 == 0 {
+			return 0
+		}
+		return 3 << (flags&0x07 + 1)
+	}
+	if len(data) < 13 {
+		return false
+	}
+	i := 13 + colorTable(data[10])
+	if i > len(data) {
+		return false
+	}
+	out.Write(data[:i])
+	for i < len(data) {
+		start := i
+		switch data[i] {
+		case 0x3B:
+			out.WriteByte(0x3B)
+			return true
+		case 0x21:
+			if i+2 > len(data) {
+				return false
+			}
+			label := data[i+1]
+			if i = subBlocks(i + 2); i < 0 {
+				return false
+			}
+			keep := label == 0xF9 // graphic control: delays and transparency
+			if label == 0xFF && start+14 <= len(data) {
+				keep = string(data[start+3:start+14]) == "NETSCAPE2.0"
+			}
+			if keep {
+				out.Write(data[start:i])
+			}
+		case 0x2C:
+			if i+10 > len(data) {
+				return false
+			}
+			i += 10 + colorTable(data[i+9]) + 1 // the LZW minimum code size
+			if i > len(data) {
+				return false
+			}
+			if i = subBlocks(i); i < 0 {
+				return false
+			}
+			out.Write(data[start:i])
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// jpegOrientation reads the orientation from the EXIF of a JPEG, 1 (as
+// is) when it has none.
+func jpegOrientation(data []byte) int {
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker, size := data[i+1], int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xDA || size < 2 || i+2+size > len(data) {
+			// the image data starts, and metadata comes before it
+			break
+		}
+		seg := data[i+4 : i+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return exifOrientation(seg[6:])
+		}
+		i += 2 + size
+	}
+	return 1
+}
+
+// exifOrientation finds the Orientation tag in the first IFD of tiff.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	n := int(order.Uint16(tiff[ifd:]))
+	for e := ifd + 2; n > 0 && e+12 <= len(tiff); n, e = n-1, e+12 {
+		if order.Uint16(tiff[e:]) == 0x0112 {
+			if o := int(order.Uint16(tiff[e+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// orient turns and flips img the way EXIF orientation o says it has to
+// be to show upright.
+func orient(img image.Image, o int) image.Image {
+	if o <= 1 || o > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	var dst *image.RGBA
+	if o >= 5 {
+		// 5 to 8 swap width and height
+		dst = image.NewRGBA(image.Rect(0, 0, h, w))
+	} else {
+		dst = image.NewRGBA(image.Rect(0, 0, w, h))
+	}
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch o {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			dst.SetRGBA(dx, dy, src.RGBAAt(x, y))
+		}
+	}
+	return dst
+}
+
+// mediaHandler serves the images at /media/<name>. Names are hashes of
+// the content, so they can be cached for good.
+func mediaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/media/")
+	if !mediaName.MatchString(name) {
+		httpError(w, r, http.StatusNotFound, codeNotFound, "File not found")
+		return
+	}
+	f, err := media.Open(r.Context(), name)
+	if errors.Is(err, fs.ErrNotExist) {
+		httpError(w, r, http.StatusNotFound, codeNotFound, "File not found")
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", mediaTypes[strings.TrimPrefix(path.Ext(name), ".")])
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'")
+	http.ServeContent(w, r, name, time.Time{}, f)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exifJPEG is a w×h JPEG with an EXIF orientation.
+func exifJPEG(t *testing.T, w, h, orientation int) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, image.NewGray(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatal(err)
+	}
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00")
+	tiff[19] = byte(orientation)
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	seg := append([]byte{0xFF, 0xE1, byte((len(app1) + 2) >> 8), byte(len(app1) + 2)}, app1...)
+	return append(append(b.Bytes()[:2:2], seg...), b.Bytes()[2:]...)
+}
+
+func TestCleanImage(t *testing.T) {
+	data := exifJPEG(t, 4, 2, 6)
+	if o := jpegOrientation(data); o != 6 {
+		t.Fatalf("jpegOrientation() = %d, want 6", o)
+	}
+	up, err := cleanImage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(up.Data, []byte("Exif")) || !mediaName.MatchString(up.Name) || !strings.HasSuffix(up.Name, ".jpg") || up.ContentType != "image/jpeg" {
+		t.Errorf("Cleaned JPEG %s %s still has EXIF or a bad name", up.Name, up.ContentType)
+	}
+	if cfg, _ := jpeg.DecodeConfig(bytes.NewReader(up.Data)); cfg.Width != 2 || cfg.Height != 4 {
+		t.Errorf("Turned JPEG is %d×%d, want 2×4", cfg.Width, cfg.Height)
+	}
+
+	var p bytes.Buffer
+	png.Encode(&p, image.NewRGBA(image.Rect(0, 0, 3, 3)))
+	if up, err := cleanImage(p.Bytes()); err != nil || !strings.HasSuffix(up.Name, ".png") {
+		t.Errorf("PNG: %v, %v", up, err)
+	}
+
+	var g bytes.Buffer
+	gif.EncodeAll(&g, &gif.GIF{
+		Image: []*image.Paletted{image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Black, color.White}), image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.White, color.Black})},
+		Delay: []int{10, 10},
+	})
+	withComment := append(g.Bytes()[:g.Len()-1:g.Len()-1], "\x21\xFE\x0Dsecret things\x00\x3B"...)
+	up, err = cleanImage(withComment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(up.Data, []byte("secret")) {
+		t.Error("GIF comment wasn't dropped")
+	}
+	if anim, err := gif.DecodeAll(bytes.NewReader(up.Data)); err != nil || len(anim.Image) != 2 || anim.LoopCount != 0 {
+		t.Errorf("Cleaned GIF: %v, %v", anim, err)
+	}
+
+	for name, data := range map[string][]byte{"text": []byte("hello, world"), "truncated JPEG": data[:40], "truncated GIF": g.Bytes()[:30]} {
+		if _, err := cleanImage(data); err == nil || err.(*apiError).code != codeInvalidImage {
+			t.Errorf("%s: %v, want invalid_image", name, err)
+		}
+	}
+}
+
+func TestAddCommentImage(t *testing.T) {
+	defer func(c Config, s CommentStore, m MediaStore) { config, store, media = c, s, m }(config, store, media)
+	store = newMemoryStore()
+	config.MediaStorage, config.MediaMaxBytes, config.PublicURL = "disk", 1000, "https://guestbook.example/"
+	var err error
+	if media, err = openMediaStore(Config{MediaStorage: "disk", MediaDir: t.TempDir()}); err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(image []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		mw.WriteField("name", "Ann")
+		mw.WriteField("email", "ann@example.com")
+		mw.WriteField("comment", "Our trip")
+		fw, _ := mw.CreateFormFile("image", "trip.jpg")
+		fw.Write(image)
+		mw.Close()
+		req := httptest.NewRequest("POST", "/comments", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		rec := httptest.NewRecorder()
+		addComment(rec, req)
+		return rec
+	}
+
+	if rec := post(make([]byte, 1001)); rec.Code != 413 || rec.Header().Get("X-Error-Code") != codeImageTooLarge {
+		t.Errorf("Too large: %d %s", rec.Code, rec.Body)
+	}
+	if rec := post([]byte("not an image")); rec.Code != 400 {
+		t.Errorf("Not an image: %d %s", rec.Code, rec.Body)
+	}
+	// a blocked IP is refused before its image is looked at
+	store.BlockIP(t.Context(), "192.0.2.1", "spam", time.Time{})
+	if rec := post([]byte("not an image")); rec.Code != 403 {
+		t.Errorf("Image from a blocked IP: %d %s", rec.Code, rec.Body)
+	}
+	store.UnblockIP(t.Context(), "192.0.2.1")
+	if rec := post(exifJPEG(t, 4, 2, 1)); rec.Code != 201 {
+		t.Fatalf("Image: %d %s", rec.Code, rec.Body)
+	}
+	comments, _ := store.List(t.Context(), CommentQuery{})
+	if len(comments) != 1 || !strings.HasPrefix(comments[0].Image, "https://guestbook.example/media/") {
+		t.Fatalf("Stored %+v", comments)
+	}
+
+	path := strings.TrimPrefix(comments[0].Image, "https://guestbook.example")
+	rec := httptest.NewRecorder()
+	mediaHandler(rec, httptest.NewRequest("GET", path, nil))
+	if rec.Code != 200 || rec.Header().Get("Content-Type") != "image/jpeg" || !strings.Contains(rec.Header().Get("Cache-Control"), "immutable") {
+		t.Errorf("GET %s = %d, headers %v", path, rec.Code, rec.Header())
+	}
+	for _, p := range []string{"/media/" + strings.Repeat("0", 32) + ".jpg", "/media/../guestbook.db"} {
+		rec := httptest.NewRecorder()
+		mediaHandler(rec, httptest.NewRequest("GET", p, nil))
+		if rec.Code != 404 {
+			t.Errorf("GET %s = %d, want 404", p, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// memoryStore keeps everything in maps and forgets it on exit. It needs no
+// database at all, which makes it handy for demos and fast tests.
+type memoryStore struct {
+	mu        sync.RWMutex
+	nextID    int
+	comments  map[int]Comment
+	archive   map[int]Comment
+	likes     map[memoryLike]bool
+	blocklist map[string]BlockedIP
+	bans      banCache
+	botHits   []memoryBotHit
+}
+
+type memoryLike struct {
+	commentID int
+	ip        string
+}
+
+type memoryBotHit struct {
+	BotHit
+	created time.Time
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		comments:  map[int]Comment{},
+		archive:   map[int]Comment{},
+		likes:     map[memoryLike]bool{},
+		blocklist: map[string]BlockedIP{},
+	}
+}
+
+func (s *memoryStore) Create(ctx context.Context, c *Comment) error {
+	c.setDefaults()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	c.ID = s.nextID
+	s.comments[c.ID] = *c
+	return nil
+}
+
+func (s *memoryStore) CreateMany(ctx context.Context, comments []*Comment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// hand out IDs first so a clash leaves the store untouched
+	next := s.nextID
+	ids := make(map[int]bool, len(comments))
+	assigned := make([]int, len(comments))
+	for i, c := range comments {
+		id := c.ID
+		if id == 0 {
+			next++
+			id = next
+		}
+		_, live := s.comments[id]
+		if _, archived := s.archive[id]; live || archived || ids[id] {
+			return fmt.Errorf("%w: %d", errDuplicateID, id)
+		}
+		ids[id] = true
+		assigned[i] = id
+		next = max(next, id)
+	}
+
+	for i, c := range comments {
+		c.setDefaults()
+		c.ID = assigned[i]
+		s.comments[c.ID] = *c
+	}
+	s.nextID = next
+	return nil
+}
+
+// matches reports whether c is selected by q, ignoring Sort and Limit.
+func (q CommentQuery) matches(c Comment) bool {
+	return c.SiteID == q.SiteID &&
+		(q.Status == "" || c.Status == q.Status) &&
+		(q.Since.IsZero() || !c.Created.Before(q.Since)) &&
+		(q.Until.IsZero() || !c.Created.After(q.Until)) &&
+		(q.Before.IsZero() || c.Created.Before(q.Before)) &&
+		c.ID > q.AfterID &&
+		(q.Name == "" || strings.EqualFold(c.Name, q.Name)) &&
+		(q.Email == "" || strings.EqualFold(c.Email, q.Email)) &&
+		(q.IP == "" || c.IP == q.IP)
+}
+
+// less orders comments the way orderBy does in SQL.
+func (q CommentQuery) less(a, b Comment) int {
+	newest := func() int {
+		if c := b.Created.Compare(a.Created); c != 0 {
+			return c
+		}
+		return b.ID - a.ID
+	}
+	switch q.Sort {
+	case "oldest":
+		if c := a.Created.Compare(b.Created); c != 0 {
+			return c
+		}
+		return a.ID - b.ID
+	case "popular":
+		if a.Likes != b.Likes {
+			return b.Likes - a.Likes
+		}
+	}
+	return newest()
+}
+
+// page cuts sorted comments down to q's Offset and Limit.
+func (q CommentQuery) page(comments []Comment) []Comment {
+	if q.Limit <= 0 {
+		return comments
+	}
+	comments = comments[min(q.Offset, len(comments)):]
+	return comments[:min(q.Limit, len(comments))]
+}
+
+// table returns the map q reads from. The caller holds the lock.
+func (s *memoryStore) table(q CommentQuery) map[int]Comment {
+	if q.Archived {
+		return s.archive
+	}
+	return s.comments
+}
+
+func (s *memoryStore) List(ctx context.Context, q CommentQuery) ([]Comment, error) {
+	s.mu.RLock()
+	var comments []Comment
+	for _, c := range s.table(q) {
+		if q.matches(c) {
+			comments = append(comments, c)
+		}
+	}
+	s.mu.RUnlock()
+
+	slices.SortFunc(comments, q.less)
+	return q.page(comments), nil
+}
+
+func (s *memoryStore) Each(ctx context.Context, q CommentQuery, fn func(Comment) error) error {
+	comments, err := s.List(ctx, q)
+	if err != nil {
+		return err
+	}
+	for _, c := range comments {
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, siteID, id int) (*Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.comments[id]
+	if !ok || c.SiteID != siteID {
+		return nil, errNotFound
+	}
+	return &c, nil
+}
+
+func (s *memoryStore) Update(ctx context.Context, c *Comment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.comments[c.ID]
+	if !ok || old.SiteID != c.SiteID {
+		return errNotFound
+	}
+	old.Name, old.Email, old.Text, old.Location, old.Status = c.Name, c.Email, c.Text, c.Location, c.Status
+	old.SpamScore, old.SpamReasons = c.SpamScore, c.SpamReasons
+	old.Updated = time.Now().UTC()
+	s.comments[c.ID] = old
+	return nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, siteID, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.comments[id]; !ok || c.SiteID != siteID {
+		return errNotFound
+	}
+	delete(s.comments, id)
+	for l := range s.likes {
+		if l.commentID == id {
+			delete(s.likes, l)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Archive(ctx context.Context, siteID int, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, c := range s.comments {
+		if c.SiteID != siteID || !c.Created.Before(before) {
+			continue
+		}
+		s.archive[id] = c
+		delete(s.comments, id)
+		n++
+	}
+	for l := range s.likes {
+		if _, ok := s.archive[l.commentID]; ok {
+			delete(s.likes, l)
+		}
+	}
+	return n, nil
+}
+
+func (s *memoryStore) Count(ctx context.Context, q CommentQuery) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	n := 0
+	for _, c := range s.table(q) {
+		if q.matches(c) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *memoryStore) Search(ctx context.Context, siteID int, query string, limit int) ([]SearchResult, error) {
+	return searchComments(s.siteComments(siteID), query, limit), nil
+}
+
+// siteComments copies the comments of a site.
+func (s *memoryStore) siteComments(siteID int) []Comment {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var comments []Comment
+	for _, c := range s.comments {
+		if c.SiteID == siteID {
+			comments = append(comments, c)
+		}
+	}
+	return comments
+}
+
+// searchComments is Search for backends without a full-text index. It
+// matches whole words like FTS does among the approved comments, ranking
+// them by how often the words occur and newest first on ties.
+func searchComments(comments []Comment, query string, limit int) []SearchResult {
+	words := strings.Fields(strings.ToLower(strings.ReplaceAll(query, `"`, " ")))
+	hits := map[int]int{}
+	results := []SearchResult{}
+	for _, c := range comments {
+		if c.Status != "approved" {
+			continue
+		}
+		tokens := searchTokens(c.Name + " " + c.Text)
+		n := 0
+		for _, w := range words {
+			if tokens[w] == 0 {
+				n = 0
+				break
+			}
+			n += tokens[w]
+		}
+		if n > 0 {
+			hits[c.ID] = n
+			results = append(results, SearchResult{Comment: c})
+		}
+	}
+
+	slices.SortFunc(results, func(a, b SearchResult) int {
+		if hits[a.ID] != hits[b.ID] {
+			return hits[b.ID] - hits[a.ID]
+		}
+		return CommentQuery{}.less(a.Comment, b.Comment)
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	for i := range results {
+		results[i].Snippet = makeSnippet(results[i].Text, words, 12)
+	}
+	return results
+}
+
+// searchTokens counts the lowercased words of text, splitting on anything
+// that isn't a letter or digit like the FTS unicode61 tokenizer.
+func searchTokens(text string) map[string]int {
+	tokens := map[string]int{}
+	for _, t := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		tokens[t]++
+	}
+	return tokens
+}
+
+func (s *memoryStore) Like(ctx context.Context, siteID, id int, ip string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.comments[id]
+	if !ok || c.SiteID != siteID {
+		return 0, errNotFound
+	}
+	l := memoryLike{id, ip}
+	if !s.likes[l] {
+		s.likes[l] = true
+		c.Likes++
+		c.Updated = time.Now().UTC()
+		s.comments[id] = c
+	}
+	return c.Likes, nil
+}
+
+func (s *memoryStore) Version(ctx context.Context, q CommentQuery) (CommentVersion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var v CommentVersion
+	for _, c := range s.table(q) {
+		if q.matches(c) {
+			v.add(c)
+		}
+	}
+	return v, nil
+}
+
+// add counts c into v, for backends that compute versions themselves.
+func (v *CommentVersion) add(c Comment) {
+	v.Count++
+	v.MaxID = max(v.MaxID, c.ID)
+	if c.Updated.After(v.Modified) {
+		v.Modified = c.Updated
+	} else if c.Created.After(v.Modified) {
+		v.Modified = c.Created
+	}
+}
+
+func (s *memoryStore) Stats(ctx context.Context, siteID int, now time.Time) (*Stats, error) {
+	return commentStats(s.siteComments(siteID), now), nil
+}
+
+// commentStats is Stats computed from the comments of a site.
+func commentStats(comments []Comment, now time.Time) *Stats {
+	stats := &Stats{ByStatus: map[string]int{}}
+	for _, st := range commentStatuses {
+		stats.ByStatus[st] = 0
+	}
+
+	first := now.AddDate(0, 0, -29)
+	days := map[string]int{}
+	names := map[string]int{}
+	ips := map[string]int{}
+	for _, c := range comments {
+		stats.Total++
+		stats.Likes += c.Likes
+		stats.ByStatus[c.Status]++
+		if day := c.Created.Format("2006-01-02"); day >= first.Format("2006-01-02") {
+			days[day]++
+		}
+		names[c.Name]++
+		ips[c.IP]++
+	}
+
+	if stats.Total > 0 {
+		stats.ApprovedRatio = float64(stats.ByStatus["approved"]) / float64(stats.Total)
+		stats.SpamRatio = float64(stats.ByStatus["spam"]) / float64(stats.Total)
+	}
+	for d := 0; d < 30; d++ {
+		day := first.AddDate(0, 0, d).Format("2006-01-02")
+		stats.PerDay = append(stats.PerDay, DayCount{Date: day, Count: days[day]})
+	}
+	stats.TopNames = topKeys(names)
+	stats.TopIPs = topKeys(ips)
+	return stats
+}
+
+// topKeys returns the statsTopN most frequent keys of counts.
+func topKeys(counts map[string]int) []KeyCount {
+	top := []KeyCount{}
+	for k, n := range counts {
+		top = append(top, KeyCount{Key: k, Count: n})
+	}
+	slices.SortFunc(top, func(a, b KeyCount) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		return strings.Compare(a.Key, b.Key)
+	})
+	if len(top) > statsTopN {
+		top = top[:statsTopN]
+	}
+	return top
+}
+
+func (s *memoryStore) BlockIP(ctx context.Context, ip, reason string, expires time.Time) error {
+	defer s.bans.invalidate()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	b, ok := s.blocklist[ip]
+	if !ok {
+		b = BlockedIP{IP: ip, Created: now}
+	}
+	b.Reason = reason
+	b.Hits++
+	b.Updated = now
+	b.Expires = expires.UTC()
+	s.blocklist[ip] = b
+	return nil
+}
+
+func (s *memoryStore) UnblockIP(ctx context.Context, ip string) error {
+	defer s.bans.invalidate()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.blocklist, ip)
+	return nil
+}
+
+func (s *memoryStore) IsBlocked(ctx context.Context, ip string) (bool, error) {
+	return s.bans.isBlocked(ip, 0, func() ([]BlockedIP, error) { return s.Blocklist(ctx) })
+}
+
+func (s *memoryStore) Blocklist(ctx context.Context) ([]BlockedIP, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	blocked := make([]BlockedIP, 0, len(s.blocklist))
+	for ip, b := range s.blocklist {
+		if !b.Expires.IsZero() && !b.Expires.After(now) {
+			delete(s.blocklist, ip)
+			continue
+		}
+		blocked = append(blocked, b)
+	}
+	sortBlocklist(blocked)
+	return blocked, nil
+}
+
+// sortBlocklist puts the most recently blocked IPs first, the way the SQL
+// query does.
+func sortBlocklist(blocked []BlockedIP) {
+	slices.SortFunc(blocked, func(a, b BlockedIP) int {
+		if c := b.Updated.Compare(a.Updated); c != 0 {
+			return c
+		}
+		return strings.Compare(a.IP, b.IP)
+	})
+}
+
+func (s *memoryStore) RecordBotHit(ctx context.Context, hit BotHit) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.botHits = append(s.botHits, memoryBotHit{hit, time.Now()})
+	return nil
+}
+
+func (s *memoryStore) DeleteBotHits(ctx context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.botHits)
+	s.botHits = slices.DeleteFunc(s.botHits, func(h memoryBotHit) bool { return h.created.Before(before) })
+	return n - len(s.botHits), nil
+}
+
+func (s *memoryStore) Ping(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, newMemoryStore())
+}
+
+func TestHandlersWithMemoryStore(t *testing.T) {
+	defer func(d *sql.DB, s CommentStore) { db, store = d, s }(db, store)
+	db, store = nil, newMemoryStore()
+
+	for _, text := range []string{"First!", "Lovely guestbook"} {
+		form := url.Values{"name": {"Ann"}, "email": {"ann@example.com"}, "comment": {text}}
+		if rec := postForm(commentsHandler, "/comments", form); rec.Code != 201 {
+			t.Fatalf("POST /comments = %d: %s", rec.Code, rec.Body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	allCommentsHandler(rec, httptest.NewRequest("GET", "/all?sort=oldest", nil))
+	var comments []Comment
+	json.NewDecoder(rec.Body).Decode(&comments)
+	if len(comments) != 2 || comments[0].Text != "First!" {
+		t.Fatalf("GET /all = %+v", comments)
+	}
+
+	rec = httptest.NewRecorder()
+	searchHandler(rec, httptest.NewRequest("GET", "/search?q=LOVELY", nil))
+	var results []SearchResult
+	json.NewDecoder(rec.Body).Decode(&results)
+	if len(results) != 1 || results[0].Snippet != "<mark>Lovely</mark> guestbook" {
+		t.Errorf("GET /search = %+v", results)
+	}
+
+	rec = httptest.NewRecorder()
+	readyzHandler(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != 200 {
+		t.Errorf("GET /readyz = %d: %s", rec.Code, rec.Body)
+	}
+}
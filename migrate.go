@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrationFiles holds migrations/<db_driver>/NNNN_name.up.sql and the
+// matching .down.sql. Statements end with a semicolon at the end of a line.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+func migrationDialect() string {
+	if usingMySQL() {
+		return "mysql"
+	}
+	return "sqlite3"
+}
+
+// loadMigrations reads the migrations for driver, ordered by version.
+func loadMigrations(driver string) ([]migration, error) {
+	dir := path.Join("migrations", driver)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*migration{}
+	for _, e := range entries {
+		base, direction, ok := strings.Cut(strings.TrimSuffix(e.Name(), ".sql"), ".")
+		num, name, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(num)
+		if !ok || err != nil || version < 1 || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("bad migration file name %s", e.Name())
+		}
+		content, err := migrationFiles.ReadFile(path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &migration{version: version, name: name}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.up = string(content)
+		} else {
+			m.down = string(content)
+		}
+	}
+
+	var migrations []migration
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", m.version, m.name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	for i, m := range migrations {
+		if m.version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// splitStatements splits a migration into statements and drops comment
+// lines, since the MySQL driver only runs one statement per Exec.
+func splitStatements(content string) []string {
+	var stmts []string
+	var cur strings.Builder
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		cur.WriteString(line + "\n")
+		if strings.HasSuffix(trimmed, ";") {
+			stmts = append(stmts, strings.TrimSuffix(strings.TrimSpace(cur.String()), ";"))
+			cur.Reset()
+		}
+	}
+	if s := strings.TrimSpace(cur.String()); s != "" {
+		stmts = append(stmts, s)
+	}
+	return stmts
+}
+
+// schemaVersion returns the newest applied migration, creating the
+// schema_version table on first use.
+func schemaVersion(ctx context.Context) (int, error) {
+	if !tableExists(ctx, "schema_version") {
+		// databases set up before migrations existed lack some columns
+		// the initial migration's indexes need
+		if !usingMySQL() && tableExists(ctx, "comments") {
+			if err := upgradeLegacySQLite(); err != nil {
+				return 0, err
+			}
+		}
+		_, err := db.ExecContext(ctx, `
+			CREATE TABLE schema_version (
+				version INT PRIMARY KEY,
+				name VARCHAR(255) NOT NULL,
+				applied DATETIME DEFAULT CURRENT_TIMESTAMP
+			)
+		`)
+		if err != nil {
+			return 0, err
+		}
+	}
+	var version int
+	err := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	return version, err
+}
+
+func tableExists(ctx context.Context, table string) bool {
+	// LIMIT 0 errors out only if the table is missing, on any database
+	_, err := db.ExecContext(ctx, "SELECT 1 FROM "+table+" LIMIT 0")
+	return err == nil
+}
+
+// upgradeLegacySQLite adds the columns older versions added on startup,
+// before there were migrations.
+func upgradeLegacySQLite() error {
+	for _, col := range []struct{ table, column, definition string }{
+		{"comments", "consent_version", "TEXT NOT NULL DEFAULT ''"},
+		{"comments", "likes", "INTEGER NOT NULL DEFAULT 0"},
+		{"comments", "status", "TEXT NOT NULL DEFAULT 'approved'"},
+		{"comments", "site_id", "INTEGER NOT NULL DEFAULT 0"},
+		{"sites", "allowed_origins", "TEXT NOT NULL DEFAULT ''"},
+		{"sites", "archived", "INTEGER NOT NULL DEFAULT 0"},
+	} {
+		if !tableExists(context.Background(), col.table) {
+			continue
+		}
+		if err := addColumn(col.table, col.column, col.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateUp applies the migrations after the current version up to and
+// including target, or all of them if target is 0.
+func migrateUp(ctx context.Context, target int) ([]migration, error) {
+	migrations, err := loadMigrations(migrationDialect())
+	if err != nil {
+		return nil, err
+	}
+	current, err := schemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if target == 0 {
+		target = len(migrations)
+	}
+	if target > len(migrations) {
+		return nil, fmt.Errorf("there is no migration %d, the newest is %d", target, len(migrations))
+	}
+
+	var applied []migration
+	for _, m := range migrations[current:target] {
+		err := runMigration(ctx, m.up, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, "INSERT INTO schema_version (version, name) VALUES (?, ?)", m.version, m.name)
+			return err
+		})
+		if err != nil {
+			return applied, fmt.Errorf("migration %04d_%s: %w", m.version, m.name, err)
+		}
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// migrateDown reverts the newest steps migrations.
+func migrateDown(ctx context.Context, steps int) ([]migration, error) {
+	migrations, err := loadMigrations(migrationDialect())
+	if err != nil {
+		return nil, err
+	}
+	current, err := schemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if current > len(migrations) {
+		return nil, fmt.Errorf("database is at version %d, newer than this binary knows", current)
+	}
+
+	var reverted []migration
+	for i := current - 1; i >= 0 && i >= current-steps; i-- {
+		m := migrations[i]
+		err := runMigration(ctx, m.down, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, "DELETE FROM schema_version WHERE version = ?", m.version)
+			return err
+		})
+		if err != nil {
+			return reverted, fmt.Errorf("reverting %04d_%s: %w", m.version, m.name, err)
+		}
+		reverted = append(reverted, m)
+	}
+	return reverted, nil
+}
+
+// runMigration runs content and record in one transaction. MySQL commits
+// DDL statements right away, so a failed migration there may need fixing
+// up by hand.
+func runMigration(ctx context.Context, content string, record func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range splitStatements(content) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+var errPendingMigrations = errors.New("database schema is out of date, run guestbook migrate or set auto_migrate = true")
+
+// checkSchema fails if there are migrations that haven't been applied.
+func checkSchema(ctx context.Context) error {
+	migrations, err := loadMigrations(migrationDialect())
+	if err != nil {
+		return err
+	}
+	current, err := schemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if current < len(migrations) {
+		return errPendingMigrations
+	}
+	return nil
+}
+
+// runMigrate implements the migrate subcommand:
+//
+//	guestbook migrate [up [version]]
+//	guestbook migrate down [steps]
+//	guestbook migrate status
+func runMigrate(args []string, out io.Writer) error {
+	if db == nil {
+		return fmt.Errorf("db_driver %s has no schema to migrate", config.DBDriver)
+	}
+	ctx := context.Background()
+	cmd := "up"
+	if len(args) > 0 {
+		cmd, args = args[0], args[1:]
+	}
+	n := 0
+	if len(args) > 0 {
+		var err error
+		if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+			return fmt.Errorf("%q is not a positive number", args[0])
+		}
+	}
+
+	switch cmd {
+	case "up":
+		applied, err := migrateUp(ctx, n)
+		for _, m := range applied {
+			fmt.Fprintf(out, "Applied %04d_%s\n", m.version, m.name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Fprintln(out, "Nothing to migrate")
+		}
+		return initSearch()
+	case "down":
+		if n == 0 {
+			n = 1
+		}
+		reverted, err := migrateDown(ctx, n)
+		for _, m := range reverted {
+			fmt.Fprintf(out, "Reverted %04d_%s\n", m.version, m.name)
+		}
+		return err
+	case "status":
+		migrations, err := loadMigrations(migrationDialect())
+		if err != nil {
+			return err
+		}
+		current, err := schemaVersion(ctx)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			state := "pending"
+			if m.version <= current {
+				state = "applied"
+			}
+			fmt.Fprintf(out, "%04d_%s %s\n", m.version, m.name, state)
+		}
+		return nil
+	}
+	return errors.New("usage: guestbook migrate [up [version] | down [steps] | status]")
+}
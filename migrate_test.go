@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	for _, driver := range []string{"sqlite3", "mysql"} {
+		t.Run(driver, func(t *testing.T) {
+			migrations, err := loadMigrations(driver)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(migrations) == 0 || migrations[0].name != "init" {
+				t.Fatalf("loadMigrations() = %+v", migrations)
+			}
+			for _, m := range migrations {
+				if len(splitStatements(m.up)) == 0 || len(splitStatements(m.down)) == 0 {
+					t.Errorf("Migration %d has an empty up or down", m.version)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	got := splitStatements(`
+-- a comment; not a statement
+CREATE TABLE a (
+	x TEXT DEFAULT ';'
+);
+
+DROP TABLE b;
+SELECT 1`)
+	want := []string{"CREATE TABLE a (\n\tx TEXT DEFAULT ';'\n)", "DROP TABLE b", "SELECT 1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitStatements() = %q, want %q", got, want)
+	}
+}
+
+// withTempDB points db at a fresh SQLite file for the rest of the test.
+func withTempDB(t testing.TB) {
+	t.Helper()
+	old := db
+	t.Cleanup(func() { db = old })
+
+	var err error
+	db, err = sql.Open("sqlite3", filepath.Join(t.TempDir(), "guestbook.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	needSQLite(t)
+	withTempDB(t)
+	ctx := context.Background()
+
+	applied, err := migrateUp(ctx, 0)
+	if err != nil || len(applied) == 0 {
+		t.Fatalf("migrateUp() = %d migrations, %v", len(applied), err)
+	}
+	if again, err := migrateUp(ctx, 0); err != nil || len(again) != 0 {
+		t.Errorf("Second migrateUp() = %d migrations, %v; want none", len(again), err)
+	}
+	if !tableExists(ctx, "comments") {
+		t.Fatal("comments table missing after migrating up")
+	}
+
+	reverted, err := migrateDown(ctx, len(applied))
+	if err != nil || len(reverted) != len(applied) {
+		t.Fatalf("migrateDown() = %d migrations, %v", len(reverted), err)
+	}
+	if tableExists(ctx, "comments") {
+		t.Error("comments table still there after migrating down")
+	}
+	if v, _ := schemaVersion(ctx); v != 0 {
+		t.Errorf("schemaVersion() after migrating down = %d, want 0", v)
+	}
+	if err := checkSchema(ctx); err != errPendingMigrations {
+		t.Errorf("checkSchema() = %v, want errPendingMigrations", err)
+	}
+
+	if err := initSchema(); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkSchema(ctx); err != nil {
+		t.Errorf("checkSchema() after initSchema() = %v", err)
+	}
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	needSQLite(t)
+	withTempDB(t)
+
+	// the schema of the very first release, before any column was added
+	_, err := db.Exec(`
+		CREATE TABLE comments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT,
+			email TEXT,
+			text TEXT,
+			ip TEXT,
+			location TEXT,
+			created DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		INSERT INTO comments (name, email, text, ip, location) VALUES ('Old', 'old@example.com', 'From way back', '', '');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := initSchema(); err != nil {
+		t.Fatal(err)
+	}
+	var status string
+	var siteID int
+	if err := db.QueryRow("SELECT status, site_id FROM comments").Scan(&status, &siteID); err != nil || status != "approved" {
+		t.Errorf("Old comment after migrating: %q, %d, %v", status, siteID, err)
+	}
+	if !tableExists(context.Background(), "sites") {
+		t.Error("sites table not created")
+	}
+}
+
+func TestRunMigrate(t *testing.T) {
+	needSQLite(t)
+	withTempDB(t)
+
+	var out bytes.Buffer
+	if err := runMigrate([]string{"status"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "0001_init pending\n") {
+		t.Errorf("status before = %q", out.String())
+	}
+
+	out.Reset()
+	if err := runMigrate([]string{"up", "1"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "Applied 0001_init\n" {
+		t.Errorf("up = %q", out.String())
+	}
+
+	out.Reset()
+	if err := runMigrate([]string{"down", "x"}, &out); err == nil {
+		t.Error("down x should fail")
+	}
+	if err := runMigrate([]string{"sideways"}, &out); err == nil {
+		t.Error("Unknown command should fail")
+	}
+}
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS bot_hits;
+DROP TABLE IF EXISTS sites;
+DROP TABLE IF EXISTS blocklist;
+DROP TABLE IF EXISTS likes;
+DROP TABLE IF EXISTS comments;
@@ -0,0 +1,55 @@
+-- Mirrors the SQLite schema. TEXT can't be a key or have a default in
+-- MySQL, so keyed and defaulted columns are VARCHARs, and search uses a
+-- FULLTEXT index instead of an FTS table.
+
+CREATE TABLE IF NOT EXISTS comments (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	name TEXT,
+	email TEXT,
+	text TEXT,
+	ip VARCHAR(64),
+	location TEXT,
+	created DATETIME DEFAULT CURRENT_TIMESTAMP,
+	consent_version VARCHAR(64) NOT NULL DEFAULT '',
+	likes INT NOT NULL DEFAULT 0,
+	status VARCHAR(16) NOT NULL DEFAULT 'approved',
+	site_id INT NOT NULL DEFAULT 0,
+	INDEX comments_site_created (site_id, created),
+	FULLTEXT INDEX comments_fts (name, text)
+) DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE IF NOT EXISTS likes (
+	comment_id INT NOT NULL,
+	ip VARCHAR(64) NOT NULL,
+	created DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (comment_id, ip)
+) DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE IF NOT EXISTS blocklist (
+	ip VARCHAR(64) PRIMARY KEY,
+	reason TEXT,
+	hits INT NOT NULL DEFAULT 1,
+	created DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated DATETIME DEFAULT CURRENT_TIMESTAMP
+) DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE IF NOT EXISTS sites (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	slug VARCHAR(64) NOT NULL UNIQUE,
+	name TEXT NOT NULL,
+	api_key_hash CHAR(64) NOT NULL UNIQUE,
+	moderation VARCHAR(16) NOT NULL DEFAULT 'approved',
+	require_consent BOOLEAN NOT NULL DEFAULT 0,
+	allowed_origins VARCHAR(2048) NOT NULL DEFAULT '',
+	archived BOOLEAN NOT NULL DEFAULT 0,
+	created DATETIME DEFAULT CURRENT_TIMESTAMP
+) DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE IF NOT EXISTS bot_hits (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	ip VARCHAR(64),
+	path TEXT,
+	user_agent TEXT,
+	fingerprint VARCHAR(32),
+	created DATETIME DEFAULT CURRENT_TIMESTAMP
+) DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,2 @@
+-- Replies point at the comment they answer; 0 is a top-level comment.
+ALTER TABLE comments ADD COLUMN parent_id INT NOT NULL DEFAULT 0;
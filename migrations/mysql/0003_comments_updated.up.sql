@@ -0,0 +1,4 @@
+-- When a comment last changed, for ETags and Last-Modified. Starts out as
+-- the creation time.
+ALTER TABLE comments ADD COLUMN updated DATETIME(6);
+UPDATE comments SET updated = created;
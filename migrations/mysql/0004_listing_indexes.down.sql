@@ -0,0 +1,5 @@
+ALTER TABLE comments
+	DROP INDEX comments_site_ip_created,
+	DROP INDEX comments_site_status_created,
+	DROP INDEX comments_site_created,
+	ADD INDEX comments_site_created (site_id, created);
@@ -0,0 +1,9 @@
+-- Listings filter by site and usually status, and sort by created with id
+-- as the tie-breaker. With every one of those in the index, a page of the
+-- newest comments is read straight off it instead of sorting the site.
+ALTER TABLE comments
+	DROP INDEX comments_site_created,
+	ADD INDEX comments_site_created (site_id, created, id),
+	ADD INDEX comments_site_status_created (site_id, status, created, id),
+	-- duplicate detection looks up the last comment from an IP on every post
+	ADD INDEX comments_site_ip_created (site_id, ip, created);
@@ -0,0 +1,22 @@
+-- ActivityPub: the signing key of every site's actor, who follows it, and
+-- which comments came in as replies from the fediverse. Actor and object
+-- IDs are URLs; 512 characters keeps the keys within InnoDB's limit.
+CREATE TABLE activitypub_keys (
+	site_id INT PRIMARY KEY,
+	private_key TEXT NOT NULL,
+	created DATETIME DEFAULT CURRENT_TIMESTAMP
+) DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE activitypub_followers (
+	site_id INT NOT NULL,
+	actor VARCHAR(512) NOT NULL,
+	inbox VARCHAR(2048) NOT NULL,
+	created DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (site_id, actor)
+) DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE activitypub_notes (
+	comment_id INT PRIMARY KEY,
+	object_id VARCHAR(512) NOT NULL UNIQUE,
+	actor VARCHAR(512) NOT NULL
+) DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,11 @@
+-- Webmentions: which comments were made from mentions on other sites, by
+-- the page that mentioned the guestbook. 512 characters keeps the source
+-- key within InnoDB's limit.
+CREATE TABLE webmentions (
+	comment_id INT PRIMARY KEY,
+	site_id INT NOT NULL,
+	source VARCHAR(512) NOT NULL,
+	target VARCHAR(2048) NOT NULL,
+	created DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (site_id, source)
+) DEFAULT CHARSET = utf8mb4;
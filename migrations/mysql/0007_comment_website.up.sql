@@ -0,0 +1,3 @@
+-- The commenter's website, and whether they signed in to prove it's theirs.
+ALTER TABLE comments ADD COLUMN website VARCHAR(2048) NOT NULL DEFAULT '';
+ALTER TABLE comments ADD COLUMN authenticated BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,3 @@
+-- The avatar of a commenter who signed in, and where they signed in.
+ALTER TABLE comments ADD COLUMN avatar VARCHAR(2048) NOT NULL DEFAULT '';
+ALTER TABLE comments ADD COLUMN provider VARCHAR(32) NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE blocklist DROP COLUMN expires;
@@ -0,0 +1,2 @@
+-- When a temporary ban ends, NULL for bans that don't.
+ALTER TABLE blocklist ADD COLUMN expires DATETIME;
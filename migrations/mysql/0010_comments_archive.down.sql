@@ -0,0 +1,4 @@
+-- Archived comments go back where they came from.
+INSERT INTO comments (id, name, email, text, ip, location, created, consent_version, likes, status, site_id, parent_id, updated, website, authenticated, avatar, provider)
+	SELECT id, name, email, text, ip, location, created, consent_version, likes, status, site_id, parent_id, updated, website, authenticated, avatar, provider FROM comments_archive;
+DROP TABLE comments_archive;
@@ -0,0 +1,23 @@
+-- Comments old enough to be archived move here, out of the way of every
+-- listing, with their likes counted in. IDs are kept, so they stay unique
+-- across both tables.
+CREATE TABLE comments_archive (
+	id INT PRIMARY KEY,
+	name TEXT,
+	email TEXT,
+	text TEXT,
+	ip VARCHAR(64),
+	location TEXT,
+	created DATETIME,
+	consent_version VARCHAR(64) NOT NULL DEFAULT '',
+	likes INT NOT NULL DEFAULT 0,
+	status VARCHAR(16) NOT NULL DEFAULT 'approved',
+	site_id INT NOT NULL DEFAULT 0,
+	parent_id INT NOT NULL DEFAULT 0,
+	updated DATETIME(6),
+	website VARCHAR(2048) NOT NULL DEFAULT '',
+	authenticated BOOLEAN NOT NULL DEFAULT FALSE,
+	avatar VARCHAR(2048) NOT NULL DEFAULT '',
+	provider VARCHAR(32) NOT NULL DEFAULT '',
+	INDEX comments_archive_site_status_created (site_id, status, created, id)
+) DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,2 @@
+-- When a site stops taking new comments, NULL for sites that never do.
+ALTER TABLE sites ADD COLUMN closed_after DATETIME;
@@ -0,0 +1,14 @@
+-- Notifications waiting to go out, and the ones that gave up ("dead")
+-- after outbox_max_attempts. Delivered ones are deleted.
+CREATE TABLE outbox (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	kind VARCHAR(16) NOT NULL,
+	target VARCHAR(2048) NOT NULL,
+	payload MEDIUMTEXT NOT NULL,
+	status VARCHAR(16) NOT NULL DEFAULT 'pending',
+	attempts INT NOT NULL DEFAULT 0,
+	next_attempt DATETIME NOT NULL,
+	last_error TEXT NOT NULL,
+	created DATETIME DEFAULT CURRENT_TIMESTAMP,
+	INDEX outbox_due (status, next_attempt)
+) DEFAULT CHARSET = utf8mb4;
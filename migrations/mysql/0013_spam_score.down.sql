@@ -0,0 +1,4 @@
+ALTER TABLE comments_archive DROP COLUMN spam_reasons;
+ALTER TABLE comments_archive DROP COLUMN spam_score;
+ALTER TABLE comments DROP COLUMN spam_reasons;
+ALTER TABLE comments DROP COLUMN spam_score;
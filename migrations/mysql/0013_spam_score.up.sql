@@ -0,0 +1,5 @@
+-- What the spam checks made of a comment, for moderators.
+ALTER TABLE comments ADD COLUMN spam_score DOUBLE NOT NULL DEFAULT 0;
+ALTER TABLE comments ADD COLUMN spam_reasons VARCHAR(1024) NOT NULL DEFAULT '';
+ALTER TABLE comments_archive ADD COLUMN spam_score DOUBLE NOT NULL DEFAULT 0;
+ALTER TABLE comments_archive ADD COLUMN spam_reasons VARCHAR(1024) NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+DROP TABLE spam_trained;
+DROP TABLE spam_tokens;
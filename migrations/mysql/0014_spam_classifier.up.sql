@@ -0,0 +1,12 @@
+-- What the bayes spam check learned from moderators: how many spam and
+-- ham comments each token was in, and which comments it learned from as
+-- what, so a changed decision can be unlearned.
+CREATE TABLE spam_tokens (
+	token VARCHAR(191) PRIMARY KEY,
+	spam INT NOT NULL DEFAULT 0,
+	ham INT NOT NULL DEFAULT 0
+) DEFAULT CHARSET = utf8mb4;
+CREATE TABLE spam_trained (
+	comment_id INT PRIMARY KEY,
+	class VARCHAR(8) NOT NULL
+) DEFAULT CHARSET = utf8mb4;
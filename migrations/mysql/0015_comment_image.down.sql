@@ -0,0 +1,2 @@
+ALTER TABLE comments_archive DROP COLUMN image;
+ALTER TABLE comments DROP COLUMN image;
@@ -0,0 +1,3 @@
+-- The URL of an image posted with a comment.
+ALTER TABLE comments ADD COLUMN image VARCHAR(2048) NOT NULL DEFAULT '';
+ALTER TABLE comments_archive ADD COLUMN image VARCHAR(2048) NOT NULL DEFAULT '';
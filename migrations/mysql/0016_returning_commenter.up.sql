@@ -0,0 +1,3 @@
+-- Whether the email had an approved comment when the comment was posted.
+ALTER TABLE comments ADD COLUMN returning_commenter BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE comments_archive ADD COLUMN returning_commenter BOOLEAN NOT NULL DEFAULT FALSE;
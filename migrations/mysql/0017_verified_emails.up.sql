@@ -0,0 +1,8 @@
+-- Emails whose owner followed the link in a verification email, by the
+-- SHA-256 of the lower-case address, and whether comments came from one.
+CREATE TABLE verified_emails (
+	email_hash CHAR(64) PRIMARY KEY,
+	verified DATETIME DEFAULT CURRENT_TIMESTAMP
+) DEFAULT CHARSET = utf8mb4;
+ALTER TABLE comments ADD COLUMN verified BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE comments_archive ADD COLUMN verified BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,16 @@
+-- Comment submissions over HTTP and how they were answered, with
+-- request_log_table, for looking into abuse by IP and time.
+CREATE TABLE request_log (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	created DATETIME NOT NULL,
+	site_id INT NOT NULL DEFAULT 0,
+	ip VARCHAR(64) NOT NULL,
+	method VARCHAR(16) NOT NULL,
+	path VARCHAR(2048) NOT NULL,
+	status INT NOT NULL,
+	error_code VARCHAR(64) NOT NULL DEFAULT '',
+	user_agent VARCHAR(512) NOT NULL DEFAULT '',
+	request_id VARCHAR(128) NOT NULL DEFAULT '',
+	INDEX request_log_ip (ip, created),
+	INDEX request_log_created (created)
+) DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS comments_fts;
+DROP TABLE IF EXISTS bot_hits;
+DROP TABLE IF EXISTS sites;
+DROP TABLE IF EXISTS blocklist;
+DROP TABLE IF EXISTS likes;
+DROP TABLE IF EXISTS comments;
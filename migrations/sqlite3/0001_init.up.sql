@@ -0,0 +1,51 @@
+CREATE TABLE IF NOT EXISTS comments (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT,
+	email TEXT,
+	text TEXT,
+	ip TEXT,
+	location TEXT,
+	created DATETIME DEFAULT CURRENT_TIMESTAMP,
+	consent_version TEXT NOT NULL DEFAULT '',
+	likes INTEGER NOT NULL DEFAULT 0,
+	status TEXT NOT NULL DEFAULT 'approved',
+	site_id INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS comments_site_created ON comments (site_id, created);
+
+CREATE TABLE IF NOT EXISTS likes (
+	comment_id INTEGER NOT NULL,
+	ip TEXT NOT NULL,
+	created DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (comment_id, ip)
+);
+
+CREATE TABLE IF NOT EXISTS blocklist (
+	ip TEXT PRIMARY KEY,
+	reason TEXT,
+	hits INTEGER NOT NULL DEFAULT 1,
+	created DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS sites (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	slug TEXT NOT NULL UNIQUE,
+	name TEXT NOT NULL,
+	api_key_hash TEXT NOT NULL UNIQUE,
+	moderation TEXT NOT NULL DEFAULT 'approved',
+	require_consent INTEGER NOT NULL DEFAULT 0,
+	created DATETIME DEFAULT CURRENT_TIMESTAMP,
+	allowed_origins TEXT NOT NULL DEFAULT '',
+	archived INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS bot_hits (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	ip TEXT,
+	path TEXT,
+	user_agent TEXT,
+	fingerprint TEXT,
+	created DATETIME DEFAULT CURRENT_TIMESTAMP
+);
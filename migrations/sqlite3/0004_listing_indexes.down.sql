@@ -0,0 +1,4 @@
+DROP INDEX comments_site_ip_created;
+DROP INDEX comments_site_status_created;
+DROP INDEX comments_site_created;
+CREATE INDEX comments_site_created ON comments (site_id, created);
@@ -0,0 +1,8 @@
+-- Listings filter by site and usually status, and sort by created with id
+-- as the tie-breaker. With every one of those in the index, a page of the
+-- newest comments is read straight off it instead of sorting the site.
+DROP INDEX IF EXISTS comments_site_created;
+CREATE INDEX comments_site_created ON comments (site_id, created, id);
+CREATE INDEX comments_site_status_created ON comments (site_id, status, created, id);
+-- Duplicate detection looks up the last comment from an IP on every post.
+CREATE INDEX comments_site_ip_created ON comments (site_id, ip, created);
@@ -0,0 +1,21 @@
+-- ActivityPub: the signing key of every site's actor, who follows it, and
+-- which comments came in as replies from the fediverse.
+CREATE TABLE activitypub_keys (
+	site_id INTEGER PRIMARY KEY,
+	private_key TEXT NOT NULL,
+	created DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE activitypub_followers (
+	site_id INTEGER NOT NULL,
+	actor TEXT NOT NULL,
+	inbox TEXT NOT NULL,
+	created DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (site_id, actor)
+);
+
+CREATE TABLE activitypub_notes (
+	comment_id INTEGER PRIMARY KEY,
+	object_id TEXT NOT NULL UNIQUE,
+	actor TEXT NOT NULL
+);
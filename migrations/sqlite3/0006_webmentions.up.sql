@@ -0,0 +1,10 @@
+-- Webmentions: which comments were made from mentions on other sites, by
+-- the page that mentioned the guestbook.
+CREATE TABLE webmentions (
+	comment_id INTEGER PRIMARY KEY,
+	site_id INTEGER NOT NULL,
+	source TEXT NOT NULL,
+	target TEXT NOT NULL,
+	created DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (site_id, source)
+);
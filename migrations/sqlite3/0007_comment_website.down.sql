@@ -0,0 +1,2 @@
+ALTER TABLE comments DROP COLUMN authenticated;
+ALTER TABLE comments DROP COLUMN website;
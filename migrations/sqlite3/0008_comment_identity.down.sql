@@ -0,0 +1,2 @@
+ALTER TABLE comments DROP COLUMN provider;
+ALTER TABLE comments DROP COLUMN avatar;
@@ -0,0 +1,23 @@
+-- Comments old enough to be archived move here, out of the way of every
+-- listing, with their likes counted in. IDs are kept, so they stay unique
+-- across both tables.
+CREATE TABLE comments_archive (
+	id INTEGER PRIMARY KEY,
+	name TEXT,
+	email TEXT,
+	text TEXT,
+	ip TEXT,
+	location TEXT,
+	created DATETIME,
+	consent_version TEXT NOT NULL DEFAULT '',
+	likes INTEGER NOT NULL DEFAULT 0,
+	status TEXT NOT NULL DEFAULT 'approved',
+	site_id INTEGER NOT NULL DEFAULT 0,
+	parent_id INTEGER NOT NULL DEFAULT 0,
+	updated DATETIME,
+	website TEXT NOT NULL DEFAULT '',
+	authenticated BOOLEAN NOT NULL DEFAULT 0,
+	avatar TEXT NOT NULL DEFAULT '',
+	provider TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX comments_archive_site_status_created ON comments_archive (site_id, status, created, id);
@@ -0,0 +1,15 @@
+-- Notifications waiting to go out, and the ones that gave up ("dead")
+-- after outbox_max_attempts. Delivered ones are deleted.
+CREATE TABLE outbox (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind TEXT NOT NULL,
+	target TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt DATETIME NOT NULL,
+	last_error TEXT NOT NULL DEFAULT '',
+	created DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX outbox_due ON outbox (status, next_attempt);
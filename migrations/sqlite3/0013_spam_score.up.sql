@@ -0,0 +1,5 @@
+-- What the spam checks made of a comment, for moderators.
+ALTER TABLE comments ADD COLUMN spam_score REAL NOT NULL DEFAULT 0;
+ALTER TABLE comments ADD COLUMN spam_reasons TEXT NOT NULL DEFAULT '';
+ALTER TABLE comments_archive ADD COLUMN spam_score REAL NOT NULL DEFAULT 0;
+ALTER TABLE comments_archive ADD COLUMN spam_reasons TEXT NOT NULL DEFAULT '';
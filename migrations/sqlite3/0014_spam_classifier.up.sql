@@ -0,0 +1,12 @@
+-- What the bayes spam check learned from moderators: how many spam and
+-- ham comments each token was in, and which comments it learned from as
+-- what, so a changed decision can be unlearned.
+CREATE TABLE spam_tokens (
+	token TEXT PRIMARY KEY,
+	spam INTEGER NOT NULL DEFAULT 0,
+	ham INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE spam_trained (
+	comment_id INTEGER PRIMARY KEY,
+	class TEXT NOT NULL
+);
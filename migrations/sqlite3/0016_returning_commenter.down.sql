@@ -0,0 +1,2 @@
+ALTER TABLE comments_archive DROP COLUMN returning_commenter;
+ALTER TABLE comments DROP COLUMN returning_commenter;
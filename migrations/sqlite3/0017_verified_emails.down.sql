@@ -0,0 +1,3 @@
+ALTER TABLE comments_archive DROP COLUMN verified;
+ALTER TABLE comments DROP COLUMN verified;
+DROP TABLE verified_emails;
@@ -0,0 +1,8 @@
+-- Emails whose owner followed the link in a verification email, by the
+-- SHA-256 of the lower-case address, and whether comments came from one.
+CREATE TABLE verified_emails (
+	email_hash TEXT PRIMARY KEY,
+	verified DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+ALTER TABLE comments ADD COLUMN verified BOOLEAN NOT NULL DEFAULT 0;
+ALTER TABLE comments_archive ADD COLUMN verified BOOLEAN NOT NULL DEFAULT 0;
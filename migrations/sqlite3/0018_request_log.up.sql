@@ -0,0 +1,17 @@
+-- Comment submissions over HTTP and how they were answered, with
+-- request_log_table, for looking into abuse by IP and time.
+CREATE TABLE request_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	created DATETIME NOT NULL,
+	site_id INTEGER NOT NULL DEFAULT 0,
+	ip TEXT NOT NULL,
+	method TEXT NOT NULL,
+	path TEXT NOT NULL,
+	status INTEGER NOT NULL,
+	error_code TEXT NOT NULL DEFAULT '',
+	user_agent TEXT NOT NULL DEFAULT '',
+	request_id TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX request_log_ip ON request_log (ip, created);
+CREATE INDEX request_log_created ON request_log (created);
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"html"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// usingMySQL reports whether db_driver selects MySQL/MariaDB instead of
+// the default SQLite.
+func usingMySQL() bool {
+	return config.DBDriver == "mysql"
+}
+
+// mysqlDSN pins the session to UTC so CURRENT_TIMESTAMP defaults match
+// SQLite's, and keeps DATETIME columns as strings like SQLite returns them.
+func mysqlDSN(dsn string) (string, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", err
+	}
+	if cfg.Params == nil {
+		cfg.Params = map[string]string{}
+	}
+	cfg.Params["time_zone"] = "'+00:00'"
+	cfg.ParseTime = false
+	return cfg.FormatDSN(), nil
+}
+
+// insertIgnore starts an INSERT that silently skips rows violating a
+// unique key.
+func insertIgnore() string {
+	if usingMySQL() {
+		return "INSERT IGNORE"
+	}
+	return "INSERT OR IGNORE"
+}
+
+// isUniqueViolation reports whether err comes from inserting a duplicate
+// into a unique column.
+func isUniqueViolation(err error) bool {
+	if isSQLiteUniqueViolation(err) {
+		return true
+	}
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+}
+
+// mysqlMatchQuery is ftsQuery for MySQL's boolean mode: every word is a
+// required, quoted phrase.
+func mysqlMatchQuery(q string) string {
+	var terms []string
+	for _, word := range strings.Fields(strings.ReplaceAll(q, `"`, " ")) {
+		terms = append(terms, `+"`+word+`"`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// makeSnippet returns up to n words of text around the first word that
+// matches one of words, HTML-escaped, with every match wrapped in <mark>
+// like Search does with the FTS snippet function.
+func makeSnippet(text string, words []string, n int) string {
+	fields := strings.Fields(text)
+	matches := func(field string) bool {
+		f := strings.ToLower(strings.Trim(field, ".,;:!?()[]\"'"))
+		for _, w := range words {
+			if strings.HasPrefix(f, strings.ToLower(w)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	first := 0
+	for i, f := range fields {
+		if matches(f) {
+			first = i
+			break
+		}
+	}
+	start := max(0, first-n/2)
+	end := min(len(fields), start+n)
+	start = max(0, end-n)
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	for i := start; i < end; i++ {
+		if i > start {
+			b.WriteByte(' ')
+		}
+		if matches(fields[i]) {
+			b.WriteString("<mark>" + html.EscapeString(fields[i]) + "</mark>")
+		} else {
+			b.WriteString(html.EscapeString(fields[i]))
+		}
+	}
+	if end < len(fields) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
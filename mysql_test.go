@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMySQLDSN(t *testing.T) {
+	dsn, err := mysqlDSN("guestbook:secret@tcp(db:3306)/guestbook?parseTime=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dsn, "time_zone=%27%2B00%3A00%27") || strings.Contains(dsn, "parseTime") {
+		t.Errorf("mysqlDSN() = %q", dsn)
+	}
+	if _, err := mysqlDSN("not a dsn"); err == nil {
+		t.Error("mysqlDSN(invalid) succeeded")
+	}
+}
+
+func TestMySQLMatchQuery(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"hello world", `+"hello" +"world"`},
+		{`say "hi`, `+"say" +"hi"`},
+		{"  ", ""},
+	}
+	for _, tt := range tests {
+		if got := mysqlMatchQuery(tt.in); got != tt.want {
+			t.Errorf("mysqlMatchQuery(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMakeSnippet(t *testing.T) {
+	tests := []struct {
+		text  string
+		words []string
+		n     int
+		want  string
+	}{
+		{"Hello there, guestbook!", []string{"hello"}, 12, "<mark>Hello</mark> there, guestbook!"},
+		{"one two three four five six seven", []string{"five"}, 4, "…three four <mark>five</mark> six…"},
+		{"one two three four", []string{"one"}, 2, "<mark>one</mark> two…"},
+		{"one two three four", []string{"four"}, 2, "…three <mark>four</mark>"},
+		{"no match here", []string{"zzz"}, 2, "no match…"},
+		{`<script>alert(1)</script> zebra & "co"`, []string{"zebra"}, 12, `&lt;script&gt;alert(1)&lt;/script&gt; <mark>zebra</mark> &amp; &#34;co&#34;`},
+	}
+	for _, tt := range tests {
+		if got := makeSnippet(tt.text, tt.words, tt.n); got != tt.want {
+			t.Errorf("makeSnippet(%q, %q) = %q, want %q", tt.text, tt.words, got, tt.want)
+		}
+	}
+}
+
+func TestIsUniqueViolation(t *testing.T) {
+	needSQLite(t)
+	db.Exec("DELETE FROM sites")
+	if _, _, err := createSite(context.Background(), Site{Slug: "dup"}); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := createSite(context.Background(), Site{Slug: "dup"})
+	if !isUniqueViolation(err) {
+		t.Errorf("isUniqueViolation(%v) = false", err)
+	}
+	if isUniqueViolation(sql.ErrNoRows) {
+		t.Error("isUniqueViolation(ErrNoRows) = true")
+	}
+}
+
+// TestMySQLIntegration runs the main flows against a real server, e.g.
+//
+//	docker run -d -p 3306:3306 -e MYSQL_ROOT_PASSWORD=test -e MYSQL_DATABASE=guestbook mysql:8
+//	GUESTBOOK_TEST_MYSQL_DSN='root:test@tcp(127.0.0.1:3306)/guestbook' go test -run MySQL
+func TestMySQLIntegration(t *testing.T) {
+	needSQLite(t)
+	dsn := os.Getenv("GUESTBOOK_TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("GUESTBOOK_TEST_MYSQL_DSN not set")
+	}
+	defer func(c Config, d *sql.DB, s CommentStore) { config, db, store = c, d, s }(config, db, store)
+	config.DBDriver, config.DBDSN = "mysql", dsn
+
+	var err error
+	if db, err = openDB(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store = &sqlStore{db: db}
+	if err := initSchema(); err != nil {
+		t.Fatal(err)
+	}
+	// running it twice must be harmless
+	if err := initSchema(); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"comments", "likes", "blocklist", "sites", "bot_hits"} {
+		if _, err := db.Exec("DELETE FROM " + table); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, text := range []string{"Greetings from the MySQL backend", "Another lovely comment"} {
+		form := url.Values{"name": {"Ann"}, "email": {"ann@example.com"}, "comment": {text}}
+		if rec := postForm(commentsHandler, "/comments", form); rec.Code != 201 {
+			t.Fatalf("POST /comments = %d: %s", rec.Code, rec.Body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	allCommentsHandler(rec, httptest.NewRequest("GET", "/all?name=ANN&sort=oldest", nil))
+	var comments []Comment
+	json.NewDecoder(rec.Body).Decode(&comments)
+	if len(comments) != 2 || comments[0].Text != "Greetings from the MySQL backend" {
+		t.Fatalf("GET /all = %+v", comments)
+	}
+	if time.Since(comments[0].Created) > time.Hour || time.Since(comments[0].Created) < -time.Minute {
+		t.Errorf("Created = %v, want a UTC timestamp from just now", comments[0].Created)
+	}
+
+	rec = httptest.NewRecorder()
+	searchHandler(rec, httptest.NewRequest("GET", "/search?q=lovely", nil))
+	var results []SearchResult
+	json.NewDecoder(rec.Body).Decode(&results)
+	if len(results) != 1 || !strings.Contains(results[0].Snippet, "<mark>lovely</mark>") {
+		t.Errorf("GET /search = %+v", results)
+	}
+
+	id := strconv.Itoa(comments[0].ID)
+	for i := 0; i < 2; i++ {
+		rec = httptest.NewRecorder()
+		likeHandler(rec, httptest.NewRequest("POST", "/like?id="+id, nil))
+	}
+	if !strings.Contains(rec.Body.String(), `"likes":1`) {
+		t.Errorf("Likes after two likes from one IP: %s", rec.Body)
+	}
+
+	ctx := context.Background()
+	store.BlockIP(ctx, "203.0.113.9", "test", time.Time{})
+	store.BlockIP(ctx, "203.0.113.9", "test again", time.Time{})
+	var hits int
+	db.QueryRow("SELECT hits FROM blocklist WHERE ip = ?", "203.0.113.9").Scan(&hits)
+	if hits != 2 {
+		t.Errorf("Blocklist hits = %d, want 2", hits)
+	}
+
+	stats, err := store.Stats(ctx, 0, time.Now().UTC())
+	if err != nil || stats.Total != 2 || stats.PerDay[29].Count != 2 {
+		t.Errorf("Stats() = %+v, %v", stats, err)
+	}
+
+	createSite(ctx, Site{Slug: "blog"})
+	if _, _, err := createSite(ctx, Site{Slug: "blog"}); !isUniqueViolation(err) {
+		t.Errorf("Duplicate site error = %v, want unique violation", err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With submission_nonces every new comment has to bring a nonce from
+// GET /nonce, or from the page's form, which works once. A nonce is the
+// time it was issued, a random part and an HMAC over both and the site,
+// keyed with form_token_secret, so issuing one stores nothing. The first
+// new comment that brings it claims it in shared state until it expires; a
+// duplicate or a retry with the same Idempotency-Key gets the first answer
+// before that. A captured request can't be sent again, and each comment
+// costs a round trip for a fresh nonce.
+
+const (
+	// nonceRateLimit is how many nonces an IP gets in nonceRateWindow,
+	// from GET /nonce and the pages together.
+	nonceRateLimit  = 60
+	nonceRateWindow = time.Hour
+)
+
+// issueNonce returns a new nonce for site, issued at now, unless the
+// client of r had nonceRateLimit already. Refusals are *apiError.
+func issueNonce(ctx context.Context, cfg Config, r *http.Request, site *Site, now time.Time) (string, error) {
+	if err := overRate(ctx, "nonce:"+getIP(r), nonceRateLimit, nonceRateWindow); err != nil {
+		return "", err
+	}
+	body := strconv.FormatInt(now.Unix(), 10) + "." + randomToken()
+	return body + "." + nonceMAC(cfg, site, body), nil
+}
+
+func nonceMAC(cfg Config, site *Site, body string) string {
+	mac := hmac.New(sha256.New, []byte(cfg.FormTokenSecret))
+	mac.Write([]byte("submission-nonce\n" + strconv.Itoa(site.ID) + "\n" + body))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// errNonceRefused refuses a submission without a valid, unused nonce.
+var errNonceRefused = &apiError{status: http.StatusForbidden, code: codeInvalidNonce, message: "The submission nonce is missing, was used already or expired, get a new one and try again"}
+
+// checkNonce checks the nonce a submission to site brings was issued for
+// it and hasn't expired. Refusals are *apiError.
+func checkNonce(cfg Config, site *Site, nonce string, now time.Time) error {
+	_, err := nonceExpiry(cfg, site, nonce, now)
+	return err
+}
+
+// claimNonce uses up a nonce checkNonce accepted. Only one of several
+// submissions with the same nonce succeeds, however many replicas they
+// reach, since SetNX decides. Refusals are *apiError.
+func claimNonce(ctx context.Context, cfg Config, site *Site, nonce string, now time.Time) error {
+	expires, err := nonceExpiry(cfg, site, nonce, now)
+	if err != nil {
+		return err
+	}
+	sig := nonce[strings.LastIndexByte(nonce, '.')+1:]
+	claimed, err := shared.SetNX(ctx, "submission-nonce:"+sig, []byte("1"), expires.Sub(now)+formTokenSkew)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return errNonceRefused
+	}
+	return nil
+}
+
+// nonceExpiry returns when nonce stops working if it's one of site's and
+// still works at now.
+func nonceExpiry(cfg Config, site *Site, nonce string, now time.Time) (time.Time, error) {
+	i := strings.LastIndexByte(nonce, '.')
+	if i < 0 || !hmac.Equal([]byte(nonce[i+1:]), []byte(nonceMAC(cfg, site, nonce[:i]))) {
+		return time.Time{}, errNonceRefused
+	}
+	issued, _, _ := strings.Cut(nonce, ".")
+	unix, err := strconv.ParseInt(issued, 10, 64)
+	if err != nil {
+		return time.Time{}, errNonceRefused
+	}
+	at := time.Unix(unix, 0)
+	expires := at.Add(time.Duration(cfg.SubmissionNonceTTL) * time.Second)
+	if at.After(now.Add(formTokenSkew)) || !now.Before(expires) {
+		return time.Time{}, errNonceRefused
+	}
+	return expires, nil
+}
+
+// nonceHandler hands out nonces with submission_nonces.
+func nonceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	cfg := settings()
+	if !cfg.SubmissionNonces {
+		httpError(w, r, http.StatusNotImplemented, codeNotSupported, "Submission nonces are off")
+		return
+	}
+	now := time.Now()
+	nonce, err := issueNonce(r.Context(), cfg, r, siteFor(r), now)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"nonce":   nonce,
+		"expires": now.Add(time.Duration(cfg.SubmissionNonceTTL) * time.Second).UTC().Truncate(time.Second),
+	})
+}
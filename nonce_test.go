@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSubmissionNonces(t *testing.T) {
+	defer func(c Config, s CommentStore, st SharedState) { config, store, shared = c, s, st }(config, store, shared)
+	store, shared = newMemoryStore(), newMemoryState()
+	config.SubmissionNonces, config.SubmissionNonceTTL, config.AdminToken = true, 60, "secret"
+	config.FormTokenSecret = strings.Repeat("s", 32)
+
+	getNonce := func() string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		nonceHandler(rec, httptest.NewRequest("GET", "/nonce", nil))
+		var body struct{ Nonce string }
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || rec.Code != 200 || body.Nonce == "" {
+			t.Fatalf("GET /nonce = %d, %v", rec.Code, err)
+		}
+		return body.Nonce
+	}
+	post := func(nonce, header string, admin bool) *httptest.ResponseRecorder {
+		form := url.Values{"name": {"Ann"}, "email": {"ann@example.com"}, "comment": {"Hello " + nonce + header}}
+		if nonce != "" {
+			form.Set("submission_nonce", nonce)
+		}
+		req := httptest.NewRequest("POST", "/comments", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if header != "" {
+			req.Header.Set("X-Submission-Nonce", header)
+		}
+		if admin {
+			req.Header.Set("Authorization", "Bearer secret")
+		}
+		rec := httptest.NewRecorder()
+		commentsHandler(rec, req)
+		return rec
+	}
+
+	nonce := getNonce()
+	if rec := post(nonce, "", false); rec.Code != 201 {
+		t.Fatalf("POST with a nonce = %d %s", rec.Code, rec.Body)
+	}
+	if rec := post(nonce, "", false); rec.Code != 403 || rec.Header().Get("X-Error-Code") != codeInvalidNonce {
+		t.Errorf("Replayed POST = %d %s", rec.Code, rec.Body)
+	}
+	if rec := post("", getNonce(), false); rec.Code != 201 {
+		t.Errorf("POST with the header = %d %s", rec.Code, rec.Body)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	expired, _ := issueNonce(t.Context(), config, req, siteFor(req), time.Now().Add(-time.Minute))
+	otherSite, _ := issueNonce(t.Context(), config, req, &Site{ID: 2}, time.Now())
+	for _, n := range []string{"", "made-up", "1.2.3", expired, otherSite} {
+		if rec := post(n, "", false); rec.Code != 403 {
+			t.Errorf("POST with nonce %q = %d", n, rec.Code)
+		}
+	}
+	if rec := post("", "", true); rec.Code != 201 {
+		t.Errorf("Admin POST without a nonce = %d", rec.Code)
+	}
+
+	// of many submissions with one nonce exactly one gets through
+	nonce = getNonce()
+	var wg sync.WaitGroup
+	codes := make([]int, 10)
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			form := url.Values{"name": {"Ann"}, "email": {"ann@example.com"}, "comment": {"Race"}, "submission_nonce": {nonce}}
+			req := httptest.NewRequest("POST", "/comments", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+			addComment(rec, req)
+			codes[i] = rec.Code
+		}()
+	}
+	wg.Wait()
+	ok := 0
+	for _, c := range codes {
+		if c == 201 {
+			ok++
+		}
+	}
+	if ok != 1 {
+		t.Errorf("%d submissions with one nonce got through: %v", ok, codes)
+	}
+
+	// a double-submitted form gets the first answer, without using up
+	// another nonce
+	config.DuplicateWindow = 60
+	nonce = getNonce()
+	for _, want := range []int{201, 200} {
+		form := url.Values{"name": {"Ann"}, "email": {"ann@example.com"}, "comment": {"Twice"}, "submission_nonce": {nonce}}
+		req := httptest.NewRequest("POST", "/comments", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		commentsHandler(rec, req)
+		if rec.Code != want {
+			t.Errorf("Double-submitted POST = %d %s, want %d", rec.Code, rec.Body, want)
+		}
+	}
+	config.DuplicateWindow = 0
+
+	// the page's form comes with a nonce that works
+	rec := httptest.NewRecorder()
+	guestbookPageHandler(rec, httptest.NewRequest("GET", "/", nil))
+	m := regexp.MustCompile(`name="submission_nonce" value="([^"]+)"`).FindStringSubmatch(rec.Body.String())
+	if m == nil {
+		t.Fatal("The page's form has no nonce")
+	}
+	if rec := post(m[1], "", false); rec.Code != 201 {
+		t.Errorf("POST with the page's nonce = %d", rec.Code)
+	}
+
+	// an IP gets nonceRateLimit nonces an hour, the pages' included
+	for i := 0; i < nonceRateLimit; i++ {
+		rec = httptest.NewRecorder()
+		nonceHandler(rec, httptest.NewRequest("GET", "/nonce", nil))
+	}
+	if rec.Code != 429 || rec.Header().Get("X-Error-Code") != codeRateLimited {
+		t.Errorf("GET /nonce over the limit = %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	guestbookPageHandler(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != 200 || strings.Contains(rec.Body.String(), `name="submission_nonce"`) {
+		t.Errorf("Page over the limit = %d, with a nonce", rec.Code)
+	}
+
+	config.SubmissionNonces = false
+	rec = httptest.NewRecorder()
+	nonceHandler(rec, httptest.NewRequest("GET", "/nonce", nil))
+	if rec.Code != 501 {
+		t.Errorf("GET /nonce when off = %d", rec.Code)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+)
+
+// Every notification channel is a Notifier that registers itself from an
+// init function. notify fans an event out to the ones that are enabled,
+// each of which turns it into messages for the outbox, and the outbox
+// hands the messages back to the notifier of their kind to deliver.
+
+// Notifier is a channel notifications go out on.
+type Notifier interface {
+	// Name identifies the notifier in disabled_notifiers and its metrics,
+	// and is the kind of its messages in the outbox.
+	Name() string
+	// Enabled reports whether c configures the notifier.
+	Enabled(c Config) bool
+	// Messages returns what to queue about n, nothing if the notifier
+	// doesn't care about n's event.
+	Messages(ctx context.Context, c Config, n Notification) ([]OutboxItem, error)
+	// Deliver sends a message that was queued for target.
+	Deliver(ctx context.Context, target string, payload []byte) error
+}
+
+// Notification is an event that notifiers may tell someone about.
+type Notification struct {
+	// Event is "comment.created" for a comment that was just posted,
+	// whatever its status, or "comment.approved" for one that is now
+	// public.
+	Event   string
+	Site    *Site
+	Comment *Comment
+}
+
+// OutboxItem is a message a notifier wants queued.
+type OutboxItem struct {
+	Target  string
+	Payload any
+}
+
+// NotifierStatus is how a notifier is set up and how it's doing since
+// the server started.
+type NotifierStatus struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	Queued  int64  `json:"queued"`
+	Sent    int64  `json:"sent"`
+	Failed  int64  `json:"failed"`
+	Dead    int64  `json:"dead"`
+	// Errors counts events the notifier couldn't queue messages for.
+	Errors int64 `json:"errors"`
+}
+
+// notifiers are the registered notifiers, sorted by name.
+var notifiers []Notifier
+
+// notifierStats counts, for each notifier, the messages it queued, sent,
+// failed to send and gave up on, and the events it couldn't queue. It's
+// also at /debug/vars.
+var notifierStats = expvar.NewMap("notifiers")
+
+// registerNotifier adds n to notifiers. It's meant for init functions.
+func registerNotifier(n Notifier) {
+	if notifierByName(n.Name()) != nil {
+		panic("notifier " + n.Name() + " registered twice")
+	}
+	notifiers = append(notifiers, n)
+	slices.SortFunc(notifiers, func(a, b Notifier) int { return cmp.Compare(a.Name(), b.Name()) })
+	notifierStats.Set(n.Name(), new(expvar.Map).Init())
+}
+
+func notifierByName(name string) Notifier {
+	for _, n := range notifiers {
+		if n.Name() == name {
+			return n
+		}
+	}
+	return nil
+}
+
+// notifierEnabled reports whether c configures n and doesn't list it in
+// disabled_notifiers.
+func notifierEnabled(c Config, n Notifier) bool {
+	return n.Enabled(c) && !slices.Contains(c.DisabledNotifiers, n.Name())
+}
+
+// notificationsEnabled reports whether c sends anything through the outbox.
+func notificationsEnabled(c Config) bool {
+	return slices.ContainsFunc(notifiers, func(n Notifier) bool { return n.Enabled(c) })
+}
+
+// countNotifier adds one to a notifier's stat.
+func countNotifier(name, stat string) {
+	if m, ok := notifierStats.Get(name).(*expvar.Map); ok {
+		m.Add(stat, 1)
+	}
+}
+
+// notify queues the messages of every enabled notifier about n. A
+// failure is only logged, and doesn't keep the other notifiers from
+// queueing theirs.
+func notify(ctx context.Context, log *slog.Logger, n Notification) {
+	cfg := settings()
+	if db == nil {
+		return
+	}
+	queued := false
+	for _, nt := range notifiers {
+		if !notifierEnabled(cfg, nt) {
+			continue
+		}
+		items, err := nt.Messages(ctx, cfg, n)
+		for i := 0; err == nil && i < len(items); i++ {
+			if err = enqueue(ctx, nt.Name(), items[i].Target, items[i].Payload); err == nil {
+				countNotifier(nt.Name(), "queued")
+				queued = true
+			}
+		}
+		if err != nil {
+			countNotifier(nt.Name(), "errors")
+			log.Error("Notification not queued", "notifier", nt.Name(), "event", n.Event, "id", n.Comment.ID, "error", err)
+		}
+	}
+	if queued {
+		wakeOutbox()
+	}
+}
+
+// runNotifications sends "comment.approved" for every comment that
+// becomes public until ctx is cancelled.
+func runNotifications(ctx context.Context) {
+	sub := feed.subscribe(allSites)
+	defer feed.unsubscribe(sub)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-sub.events:
+			if sub.lagged.Swap(false) {
+				logger.Warn("Notifications fell behind, some approvals were missed")
+			}
+			if change, ok := publicChange(e); ok && change == "created" {
+				site, err := siteByID(ctx, e.Comment.SiteID)
+				if err != nil {
+					logger.Error("Notification not queued", "event", "comment.approved", "id", e.Comment.ID, "error", err)
+					continue
+				}
+				c := e.Comment
+				notify(ctx, logger, Notification{Event: "comment.approved", Site: site, Comment: &c})
+			}
+		}
+	}
+}
+
+// deliverMessage hands m to the notifier of its kind.
+func deliverMessage(ctx context.Context, m OutboxMessage) error {
+	n := notifierByName(m.Kind)
+	if n == nil {
+		return fmt.Errorf("unknown kind %q", m.Kind)
+	}
+	return n.Deliver(ctx, m.Target, []byte(m.payload))
+}
+
+// notifierStatuses reports on every registered notifier under c.
+func notifierStatuses(c Config) []NotifierStatus {
+	statuses := make([]NotifierStatus, len(notifiers))
+	for i, n := range notifiers {
+		s := NotifierStatus{Name: n.Name(), Enabled: notifierEnabled(c, n)}
+		if m, ok := notifierStats.Get(n.Name()).(*expvar.Map); ok {
+			for stat, v := range map[string]*int64{"queued": &s.Queued, "sent": &s.Sent, "failed": &s.Failed, "dead": &s.Dead, "errors": &s.Errors} {
+				if n, ok := m.Get(stat).(*expvar.Int); ok {
+					*v = n.Value()
+				}
+			}
+		}
+		statuses[i] = s
+	}
+	return statuses
+}
+
+func notifiersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notifierStatuses(settings()))
+}
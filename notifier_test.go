@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testNotifier queues a message for every event and fails to deliver
+// when fail is set.
+type testNotifier struct {
+	fail      bool
+	delivered *[]string
+}
+
+func (testNotifier) Name() string { return "test" }
+
+func (testNotifier) Enabled(c Config) bool { return true }
+
+func (n testNotifier) Messages(_ context.Context, _ Config, e Notification) ([]OutboxItem, error) {
+	if e.Event == "comment.approved" {
+		return nil, errors.New("can't")
+	}
+	return []OutboxItem{{Target: "somewhere", Payload: e.Comment.Text}}, nil
+}
+
+func (n testNotifier) Deliver(_ context.Context, target string, payload []byte) error {
+	if n.fail {
+		return errors.New("down")
+	}
+	*n.delivered = append(*n.delivered, target+" "+string(payload))
+	return nil
+}
+
+func TestNotifiers(t *testing.T) {
+	needSQLite(t)
+	defer func(c Config, n []Notifier) { config, notifiers = c, n }(config, notifiers)
+	db.Exec("DELETE FROM outbox")
+	ctx := context.Background()
+
+	var delivered []string
+	notifiers = slices.Clone(notifiers)
+	registerNotifier(testNotifier{delivered: &delivered})
+	config.OutboxMaxAttempts = 1
+	status := func() NotifierStatus {
+		for _, s := range notifierStatuses(settings()) {
+			if s.Name == "test" {
+				return s
+			}
+		}
+		t.Fatal("test notifier not listed")
+		return NotifierStatus{}
+	}
+
+	c := &Comment{ID: 1, Text: "Hello"}
+	notify(ctx, logger, Notification{Event: "comment.created", Site: defaultSite, Comment: c})
+	notify(ctx, logger, Notification{Event: "comment.approved", Site: defaultSite, Comment: c})
+	deliverDue(ctx)
+	if want := []string{`somewhere "Hello"`}; !slices.Equal(delivered, want) {
+		t.Errorf("Delivered %q, want %q", delivered, want)
+	}
+	if s := status(); !s.Enabled || s.Queued != 1 || s.Sent != 1 || s.Errors != 1 {
+		t.Errorf("Status = %+v", s)
+	}
+
+	// a failed delivery is counted, and with outbox_max_attempts 1 given up
+	notifiers[slices.IndexFunc(notifiers, func(n Notifier) bool { return n.Name() == "test" })] = testNotifier{fail: true}
+	notify(ctx, logger, Notification{Event: "comment.created", Site: defaultSite, Comment: c})
+	deliverDue(ctx)
+	if s := status(); s.Failed != 1 || s.Dead != 1 {
+		t.Errorf("Status after a failure = %+v", s)
+	}
+	db.Exec("DELETE FROM outbox")
+
+	config.DisabledNotifiers = []string{"test"}
+	notify(ctx, logger, Notification{Event: "comment.created", Site: defaultSite, Comment: c})
+	if msgs, _ := listOutbox(ctx, ""); len(msgs) != 0 {
+		t.Errorf("A disabled notifier queued %+v", msgs)
+	}
+
+	rec := httptest.NewRecorder()
+	notifiersHandler(rec, httptest.NewRequest("GET", "/admin/notifiers", nil))
+	var got []NotifierStatus
+	json.Unmarshal(rec.Body.Bytes(), &got)
+	i := slices.IndexFunc(got, func(s NotifierStatus) bool { return s.Name == "test" })
+	if rec.Code != 200 || i < 0 || got[i].Enabled || got[i].Sent != 1 {
+		t.Errorf("GET /admin/notifiers = %d %s", rec.Code, rec.Body.String())
+	}
+	if !slices.IsSortedFunc(got, func(a, b NotifierStatus) int { return strings.Compare(a.Name, b.Name) }) {
+		t.Errorf("Notifiers aren't sorted by name: %+v", got)
+	}
+}
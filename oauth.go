@@ -0,0 +1,262 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// oauthProvider is a site commenters can sign in with over OAuth 2.0,
+// posting under the name and avatar it has for them.
+type oauthProvider struct {
+	Name  string
+	Title string
+	// AuthURL is where the commenter signs in, TokenURL where the code
+	// they come back with is traded for a token, and UserURL where that
+	// token gets their profile.
+	AuthURL, TokenURL, UserURL string
+	Scope                      string
+	// credentials are the client ID and secret registered with the
+	// provider, empty if it's not set up.
+	credentials func(Config) (id, secret string)
+	// profile reads the commenter from the UserURL response.
+	profile func(body []byte) (commenterSession, error)
+}
+
+var oauthProviders = []*oauthProvider{
+	{
+		Name:     "github",
+		Title:    "GitHub",
+		AuthURL:  "https://github.com/login/oauth/authorize",
+		TokenURL: "https://github.com/login/oauth/access_token",
+		UserURL:  "https://api.github.com/user",
+		Scope:    "read:user",
+		credentials: func(c Config) (string, string) {
+			return c.GitHubClientID, c.GitHubClientSecret
+		},
+		profile: func(body []byte) (commenterSession, error) {
+			var u struct {
+				ID        int64  `json:"id"`
+				Login     string `json:"login"`
+				Name      string `json:"name"`
+				Email     string `json:"email"`
+				AvatarURL string `json:"avatar_url"`
+				HTMLURL   string `json:"html_url"`
+			}
+			if err := json.Unmarshal(body, &u); err != nil {
+				return commenterSession{}, err
+			}
+			if u.ID == 0 || u.Login == "" {
+				return commenterSession{}, errors.New("no GitHub user in the response")
+			}
+			return commenterSession{Name: cmp.Or(u.Name, u.Login), Email: u.Email, Avatar: u.AvatarURL, Website: u.HTMLURL}, nil
+		},
+	},
+	{
+		Name:     "google",
+		Title:    "Google",
+		AuthURL:  "https://accounts.google.com/o/oauth2/v2/auth",
+		TokenURL: "https://oauth2.googleapis.com/token",
+		UserURL:  "https://openidconnect.googleapis.com/v1/userinfo",
+		Scope:    "openid profile email",
+		credentials: func(c Config) (string, string) {
+			return c.GoogleClientID, c.GoogleClientSecret
+		},
+		profile: func(body []byte) (commenterSession, error) {
+			var u struct {
+				Sub           string `json:"sub"`
+				Name          string `json:"name"`
+				Email         string `json:"email"`
+				EmailVerified bool   `json:"email_verified"`
+				Picture       string `json:"picture"`
+			}
+			if err := json.Unmarshal(body, &u); err != nil {
+				return commenterSession{}, err
+			}
+			if u.Sub == "" || u.Name == "" {
+				return commenterSession{}, errors.New("no Google user in the response")
+			}
+			s := commenterSession{Name: u.Name, Avatar: u.Picture}
+			if u.EmailVerified {
+				s.Email = u.Email
+			}
+			return s, nil
+		},
+	},
+}
+
+// oauthClient talks to the providers, whose URLs are fixed so it needs
+// none of publicClient's care.
+var oauthClient = &http.Client{Timeout: 10 * time.Second, Transport: breakerTransport{http.DefaultTransport}}
+
+// enabledOAuthProviders are the providers with credentials in the config.
+func enabledOAuthProviders() []*oauthProvider {
+	var enabled []*oauthProvider
+	for _, p := range oauthProviders {
+		if id, _ := p.credentials(config); id != "" {
+			enabled = append(enabled, p)
+		}
+	}
+	return enabled
+}
+
+func findOAuthProvider(name string) *oauthProvider {
+	for _, p := range enabledOAuthProviders() {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func oauthRedirectURI() string {
+	return strings.TrimSuffix(config.PublicURL, "/") + "/oauth/callback"
+}
+
+// oauthState is a sign-in in progress, see beginSignIn.
+type oauthState struct {
+	Provider string `json:"provider"`
+	Verifier string `json:"verifier"`
+	Return   string `json:"return"`
+}
+
+// oauthLoginHandler sends the commenter to sign in with ?provider=.
+// ?return= is the page to come back to.
+func oauthLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	p := findOAuthProvider(r.URL.Query().Get("provider"))
+	if p == nil {
+		httpError(w, r, 400, codeSignInFailed, "Unknown sign-in provider")
+		return
+	}
+	st := oauthState{Provider: p.Name, Verifier: randomToken(), Return: localReturn(r.URL.Query().Get("return"))}
+	state, err := beginSignIn(w, r, "/oauth", st)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+
+	id, _ := p.credentials(config)
+	u, _ := url.Parse(p.AuthURL)
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", id)
+	q.Set("redirect_uri", oauthRedirectURI())
+	q.Set("scope", p.Scope)
+	q.Set("state", state)
+	q.Set("code_challenge", codeChallenge(st.Verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// oauthCallbackHandler is where providers send the commenter back to. It
+// gets their profile with the code and signs them in.
+func oauthCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var st oauthState
+	if !finishSignIn(w, r, "/oauth", &st) {
+		return
+	}
+	p := findOAuthProvider(st.Provider)
+	if p == nil {
+		httpError(w, r, 400, codeSignInFailed, "Unknown sign-in provider")
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("error") != "" {
+		requestLogger(r).Info("OAuth sign-in refused", "provider", p.Name, "error", q.Get("error"))
+		httpError(w, r, 400, codeSignInFailed, "Sign-in was cancelled")
+		return
+	}
+	s, err := oauthProfile(r.Context(), p, st, q.Get("code"))
+	if err != nil {
+		requestLogger(r).Info("OAuth sign-in failed", "provider", p.Name, "error", err)
+		httpError(w, r, 400, codeSignInFailed, "Couldn't sign in with "+p.Title)
+		return
+	}
+	if err := startCommenterSession(w, r, s); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, st.Return, http.StatusSeeOther)
+}
+
+// oauthProfile trades code for an access token and reads the commenter's
+// profile with it.
+func oauthProfile(ctx context.Context, p *oauthProvider, st oauthState, code string) (commenterSession, error) {
+	if code == "" {
+		return commenterSession{}, errors.New("no code")
+	}
+	id, secret := p.credentials(config)
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {oauthRedirectURI()},
+		"client_id":     {id},
+		"client_secret": {secret},
+		"code_verifier": {st.Verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return commenterSession{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	var token struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	body, err := oauthDo(req)
+	if err != nil {
+		return commenterSession{}, err
+	}
+	// GitHub answers errors with 200
+	if err := json.Unmarshal(body, &token); err != nil || token.AccessToken == "" {
+		return commenterSession{}, fmt.Errorf("no access token from %s: %q", p.TokenURL, cmp.Or(token.Error, string(body)))
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, p.UserURL, nil)
+	if err != nil {
+		return commenterSession{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	req.Header.Set("Accept", "application/json")
+	if body, err = oauthDo(req); err != nil {
+		return commenterSession{}, err
+	}
+	s, err := p.profile(body)
+	s.Provider = p.Name
+	return s, err
+}
+
+// oauthDo sends req to a provider and returns the body of a 2xx answer.
+func oauthDo(req *http.Request) ([]byte, error) {
+	resp, err := oauthClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s %s: %s", req.Method, req.URL, resp.Status)
+	}
+	return body, nil
+}
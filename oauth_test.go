@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestOAuth(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.PublicURL = "https://guestbook.example"
+	config.MultiTenant = false
+	config.CSRFMode = "api"
+	config.GitHubClientID, config.GitHubClientSecret = "client", "secret"
+	config.RequireSignIn = true
+	defer func(s CommentStore) { store = s }(store)
+	store = newMemoryStore()
+
+	var challenge string
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/token":
+			if r.PostFormValue("client_secret") != "secret" || r.PostFormValue("code") != "good" || codeChallenge(r.PostFormValue("code_verifier")) != challenge {
+				json.NewEncoder(w).Encode(map[string]string{"error": "bad_verification_code"})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"access_token": "token", "token_type": "bearer"})
+		case "/user":
+			if r.Header.Get("Authorization") != "Bearer token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"id": 1, "login": "octo", "name": "Octo Cat", "avatar_url": "https://avatars.example/octo.png", "html_url": "https://github.example/octo"}`))
+		}
+	}))
+	defer provider.Close()
+	github := oauthProviders[0]
+	defer func(p oauthProvider) { *github = p }(*github)
+	github.AuthURL, github.TokenURL, github.UserURL = provider.URL+"/authorize", provider.URL+"/token", provider.URL+"/user"
+
+	post := func(form url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/comments", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		addComment(rec, req)
+		return rec
+	}
+	if rec := post(url.Values{"name": {"Anon"}, "email": {"anon@example.com"}, "comment": {"Hi"}}); rec.Code != 401 || rec.Header().Get("X-Error-Code") != codeSignInRequired {
+		t.Errorf("Anonymous comment with require_sign_in = %d %q", rec.Code, rec.Header().Get("X-Error-Code"))
+	}
+
+	rec := httptest.NewRecorder()
+	guestbookPageHandler(rec, httptest.NewRequest("GET", "/guestbook", nil))
+	if body := rec.Body.String(); !strings.Contains(body, `<a href="/oauth/login?provider=github&amp;return=%2Fguestbook">Sign in with GitHub</a>`) || strings.Contains(body, `name="comment"`) {
+		t.Errorf("Page before signing in:\n%s", body)
+	}
+
+	rec = httptest.NewRecorder()
+	oauthLoginHandler(rec, httptest.NewRequest("GET", "/oauth/login?provider=github&return=/guestbook", nil))
+	to, _ := url.Parse(rec.Header().Get("Location"))
+	q := to.Query()
+	if rec.Code != http.StatusFound || !strings.HasPrefix(to.String(), provider.URL+"/authorize?") || q.Get("client_id") != "client" || q.Get("redirect_uri") != "https://guestbook.example/oauth/callback" {
+		t.Fatalf("Login = %d to %s", rec.Code, to)
+	}
+	challenge = q.Get("code_challenge")
+	stateCookie := rec.Result().Cookies()[0]
+
+	req := httptest.NewRequest("GET", "/oauth/callback?code=bad&state="+q.Get("state"), nil)
+	req.AddCookie(stateCookie)
+	rec = httptest.NewRecorder()
+	oauthCallbackHandler(rec, req)
+	if rec.Code != 400 {
+		t.Errorf("Callback with a bad code = %d, want 400", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	oauthLoginHandler(rec, httptest.NewRequest("GET", "/oauth/login?provider=github&return=/guestbook", nil))
+	to, _ = url.Parse(rec.Header().Get("Location"))
+	challenge = to.Query().Get("code_challenge")
+	req = httptest.NewRequest("GET", "/oauth/callback?code=good&state="+to.Query().Get("state"), nil)
+	req.AddCookie(rec.Result().Cookies()[0])
+	rec = httptest.NewRecorder()
+	oauthCallbackHandler(rec, req)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/guestbook" {
+		t.Fatalf("Callback = %d to %q: %s", rec.Code, rec.Header().Get("Location"), rec.Body.String())
+	}
+	session := rec.Result().Cookies()[len(rec.Result().Cookies())-1]
+
+	if rec := post(url.Values{"name": {"Someone else"}, "email": {"octo@example.com"}, "comment": {"Hello"}}, session); rec.Code != 201 {
+		t.Fatalf("Comment while signed in = %d: %s", rec.Code, rec.Body.String())
+	}
+	got, _ := store.List(t.Context(), CommentQuery{})
+	want := Comment{Name: "Octo Cat", Website: "https://github.example/octo", Avatar: "https://avatars.example/octo.png", Provider: "github", Authenticated: true}
+	if len(got) != 1 || got[0].Name != want.Name || got[0].Website != want.Website || got[0].Avatar != want.Avatar || got[0].Provider != want.Provider || !got[0].Authenticated {
+		t.Errorf("Stored %+v, want %+v", got, want)
+	}
+
+	req = httptest.NewRequest("GET", "/guestbook", nil)
+	req.AddCookie(session)
+	rec = httptest.NewRecorder()
+	guestbookPageHandler(rec, req)
+	if body := rec.Body.String(); !strings.Contains(body, `Signed in as <a href="https://github.example/octo" rel="nofollow">Octo Cat</a>`) || strings.Contains(body, `id="name"`) {
+		t.Errorf("Page while signed in:\n%s", body)
+	}
+
+	form := url.Values{"csrf_token": {"tok"}, "return": {"/guestbook"}}
+	req = httptest.NewRequest("POST", "/logout", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(session)
+	req.AddCookie(&http.Cookie{Name: csrfCookie, Value: "tok"})
+	rec = httptest.NewRecorder()
+	commenterLogoutHandler(rec, req)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Logout = %d", rec.Code)
+	}
+	if rec := post(url.Values{"name": {"Octo"}, "email": {"octo@example.com"}, "comment": {"Again"}}, session); rec.Code != 401 {
+		t.Errorf("Comment after signing out = %d, want 401", rec.Code)
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Roles of whoever uses the admin API or dashboard. Admins can do
+// anything; moderators only moderate comments.
+const (
+	roleAdmin     = "admin"
+	roleModerator = "moderator"
+)
+
+// oidcMetadata is the part of an OpenID Connect provider's discovery
+// document the guestbook needs.
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcMetadataTTL is how long the discovery document is used before it's
+// fetched again. Signing keys are fetched again whenever a token names one
+// that isn't known yet, but at most every oidcKeysInterval.
+const (
+	oidcMetadataTTL  = time.Hour
+	oidcKeysInterval = time.Minute
+)
+
+var oidcCache struct {
+	sync.Mutex
+	metadata        *oidcMetadata
+	metadataFetched time.Time
+	keys            map[string]crypto.PublicKey
+	keysFetched     time.Time
+}
+
+// oidcDiscover returns the metadata of oidc_issuer.
+func oidcDiscover(ctx context.Context) (*oidcMetadata, error) {
+	oidcCache.Lock()
+	defer oidcCache.Unlock()
+	if oidcCache.metadata != nil && time.Since(oidcCache.metadataFetched) < oidcMetadataTTL {
+		return oidcCache.metadata, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(config.OIDCIssuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	body, err := oauthDo(req)
+	if err != nil {
+		return nil, err
+	}
+	var m oidcMetadata
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, err
+	}
+	if m.Issuer != config.OIDCIssuer {
+		return nil, fmt.Errorf("discovery document is for issuer %q, not %q", m.Issuer, config.OIDCIssuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, errors.New("discovery document lacks an endpoint")
+	}
+	oidcCache.metadata, oidcCache.metadataFetched = &m, time.Now()
+	return &m, nil
+}
+
+// oidcKey returns the provider's signing key kid. A token without a kid
+// can only use the provider's one key.
+func oidcKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	m, err := oidcDiscover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	oidcCache.Lock()
+	defer oidcCache.Unlock()
+	lookup := func() crypto.PublicKey {
+		if kid == "" && len(oidcCache.keys) == 1 {
+			for _, k := range oidcCache.keys {
+				return k
+			}
+		}
+		return oidcCache.keys[kid]
+	}
+	if k := lookup(); k != nil {
+		return k, nil
+	}
+	if time.Since(oidcCache.keysFetched) < oidcKeysInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	body, err := oauthDo(req)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		// keys the guestbook can't use are skipped, not fatal
+		if k, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = k
+		}
+	}
+	oidcCache.keys, oidcCache.keysFetched = keys, time.Now()
+	if k := lookup(); k != nil {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// verifyIDToken checks an ID token from oidc_issuer: its signature, that
+// it's meant for oidc_client_id and hasn't expired, and its nonce if one
+// is given.
+func verifyIDToken(ctx context.Context, token, nonce string) (jwtClaims, error) {
+	h, claims, signed, sig, err := splitJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	key, err := oidcKey(ctx, h.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(h.Alg, key, signed, sig); err != nil {
+		return nil, err
+	}
+	if claims.str("iss") != config.OIDCIssuer {
+		return nil, fmt.Errorf("token issued by %q", claims.str("iss"))
+	}
+	if !claims.hasAudience(config.OIDCClientID) {
+		return nil, errors.New("token is for another client")
+	}
+	if err := claims.checkTimes(time.Now()); err != nil {
+		return nil, err
+	}
+	if nonce != "" && !sameToken(claims.str("nonce"), nonce) {
+		return nil, errors.New("token nonce doesn't match")
+	}
+	if claims.str("sub") == "" {
+		return nil, errors.New("token has no subject")
+	}
+	return claims, nil
+}
+
+// oidcRole maps the oidc_role_claim of a verified token to a role, empty
+// if the user is neither admin nor moderator.
+func oidcRole(claims jwtClaims) string {
+	groups := claims.values(config.OIDCRoleClaim)
+	has := func(names []string) bool {
+		return slices.ContainsFunc(groups, func(g string) bool { return slices.Contains(names, g) })
+	}
+	switch {
+	case has(config.OIDCAdminGroups):
+		return roleAdmin
+	case has(config.OIDCModeratorGroups):
+		return roleModerator
+	}
+	return ""
+}
+
+// oidcName is how a user shows up in the dashboard and logs.
+func oidcName(claims jwtClaims) string {
+	return cmp.Or(claims.str("email"), claims.str("sub"))
+}
+
+func oidcRedirectURI() string {
+	return strings.TrimSuffix(config.PublicURL, "/") + "/admin/oidc/callback"
+}
+
+// oidcState is a dashboard login in progress, see beginSignIn.
+type oidcState struct {
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+}
+
+// oidcLoginHandler sends whoever wants to log in to the dashboard to the
+// OpenID Connect provider.
+func oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	adminPageHeaders(w)
+	m, err := oidcDiscover(r.Context())
+	if err != nil {
+		requestLogger(r).Error("OIDC discovery failed", "issuer", config.OIDCIssuer, "error", err)
+		renderLogin(w, r, http.StatusBadGateway, "The single sign-on provider can't be reached, try again later.")
+		return
+	}
+	st := oidcState{Verifier: randomToken(), Nonce: randomToken()}
+	state, err := beginSignIn(w, r, "/admin/oidc", st)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+
+	u, err := url.Parse(m.AuthorizationEndpoint)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", config.OIDCClientID)
+	q.Set("redirect_uri", oidcRedirectURI())
+	q.Set("scope", strings.Join(config.OIDCScopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", st.Nonce)
+	q.Set("code_challenge", codeChallenge(st.Verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// oidcCallbackHandler is where the provider sends the user back to. A
+// verified ID token with an admin or moderator group opens a dashboard
+// session with that role.
+func oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	adminPageHeaders(w)
+	var st oidcState
+	if !finishSignIn(w, r, "/admin/oidc", &st) {
+		return
+	}
+	log := requestLogger(r)
+	if e := r.URL.Query().Get("error"); e != "" {
+		log.Info("OIDC login refused", "error", e)
+		renderLogin(w, r, http.StatusUnauthorized, "Single sign-on was cancelled.")
+		return
+	}
+	claims, err := oidcExchange(r.Context(), st, r.URL.Query().Get("code"))
+	if err != nil {
+		log.Warn("OIDC login failed", "error", err)
+		renderLogin(w, r, http.StatusUnauthorized, "Single sign-on failed, please try again.")
+		return
+	}
+	role, name := oidcRole(claims), oidcName(claims)
+	if role == "" {
+		log.Warn("OIDC login without a role", "sub", claims.str("sub"), "name", name)
+		renderLogin(w, r, http.StatusForbidden, name+" is neither admin nor moderator of the guestbook.")
+		return
+	}
+	if err := startAdminSession(w, r, adminSession{Role: role, Name: name, Issuer: config.OIDCIssuer}); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	log.Info("admin logged in", "role", role, "name", name)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// oidcExchange trades code for the provider's tokens and returns the
+// claims of the verified ID token.
+func oidcExchange(ctx context.Context, st oidcState, code string) (jwtClaims, error) {
+	if code == "" {
+		return nil, errors.New("no code")
+	}
+	m, err := oidcDiscover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {oidcRedirectURI()},
+		"code_verifier": {st.Verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(url.QueryEscape(config.OIDCClientID), url.QueryEscape(config.OIDCClientSecret))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	body, err := oauthDo(req)
+	if err != nil {
+		return nil, err
+	}
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil || tokens.IDToken == "" {
+		return nil, errors.New("no ID token in the token response")
+	}
+	return verifyIDToken(ctx, tokens.IDToken, st.Nonce)
+}
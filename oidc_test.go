@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// signTestJWT signs claims with key as an RS256 JWT.
+func signTestJWT(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(jwtHeader{Alg: "RS256", Kid: kid, Typ: "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// fakeOIDCProvider serves discovery, keys and a token endpoint that
+// answers the code "good" with the ID token idToken returns.
+func fakeOIDCProvider(t *testing.T, key *rsa.PrivateKey, idToken func(nonce string) string) *httptest.Server {
+	var mu sync.Mutex
+	var nonce string
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(oidcMetadata{Issuer: srv.URL, AuthorizationEndpoint: srv.URL + "/authorize", TokenEndpoint: srv.URL + "/token", JWKSURI: srv.URL + "/jwks"})
+		case "/jwks":
+			json.NewEncoder(w).Encode(map[string]any{"keys": []jsonWebKey{{
+				Kty: "RSA", Kid: "k1", Use: "sig",
+				N: base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}}})
+		case "/nonce":
+			// the test passes on the nonce it was redirected with
+			mu.Lock()
+			nonce = r.URL.Query().Get("nonce")
+			mu.Unlock()
+		case "/token":
+			id, secret, _ := r.BasicAuth()
+			if id != "guestbook" || secret != "s3cret" || r.PostFormValue("code") != "good" || r.PostFormValue("code_verifier") == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error": "invalid_grant"}`))
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "id_token": idToken(nonce)})
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func setupOIDC(t *testing.T, issuer string) {
+	t.Helper()
+	c := config
+	t.Cleanup(func() { config = c })
+	config.PublicURL = "https://guestbook.example"
+	config.OIDCIssuer = issuer
+	config.OIDCClientID, config.OIDCClientSecret = "guestbook", "s3cret"
+	config.OIDCScopes = []string{"openid", "email"}
+	config.OIDCRoleClaim = "groups"
+	config.OIDCAdminGroups = []string{"guestbook-admins"}
+	config.OIDCModeratorGroups = []string{"guestbook-mods"}
+	resetOIDCCache := func() {
+		oidcCache.Lock()
+		oidcCache.metadata, oidcCache.keys, oidcCache.keysFetched = nil, nil, time.Time{}
+		oidcCache.Unlock()
+	}
+	resetOIDCCache()
+	t.Cleanup(resetOIDCCache)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	groups := []string{"guestbook-mods"}
+	var srv *httptest.Server
+	srv = fakeOIDCProvider(t, key, func(nonce string) string {
+		return signTestJWT(t, key, "k1", map[string]any{
+			"iss": srv.URL, "aud": "guestbook", "sub": "42", "email": "mo@example.com", "nonce": nonce,
+			"exp": time.Now().Add(time.Hour).Unix(), "iat": time.Now().Unix(), "groups": groups,
+		})
+	})
+	setupOIDC(t, srv.URL)
+
+	login := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		oidcLoginHandler(rec, httptest.NewRequest("GET", "/admin/oidc/login", nil))
+		to, _ := url.Parse(rec.Header().Get("Location"))
+		q := to.Query()
+		if rec.Code != http.StatusFound || !strings.HasPrefix(to.String(), srv.URL+"/authorize?") || q.Get("client_id") != "guestbook" ||
+			q.Get("scope") != "openid email" || q.Get("redirect_uri") != "https://guestbook.example/admin/oidc/callback" {
+			t.Fatalf("Login = %d to %s", rec.Code, to)
+		}
+		http.Get(srv.URL + "/nonce?nonce=" + url.QueryEscape(q.Get("nonce")))
+
+		req := httptest.NewRequest("GET", "/admin/oidc/callback?code=good&state="+q.Get("state"), nil)
+		req.AddCookie(rec.Result().Cookies()[0])
+		rec = httptest.NewRecorder()
+		oidcCallbackHandler(rec, req)
+		return rec
+	}
+
+	rec := login()
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/admin" {
+		t.Fatalf("Callback = %d to %q: %s", rec.Code, rec.Header().Get("Location"), rec.Body.String())
+	}
+	req := httptest.NewRequest("GET", "/admin", nil)
+	req.AddCookie(rec.Result().Cookies()[len(rec.Result().Cookies())-1])
+	if s := dashboardSession(req); s == nil || s.Role != roleModerator || s.Name != "mo@example.com" {
+		t.Errorf("Session after login: %+v", s)
+	}
+	// the session ends when the provider changes
+	config.OIDCIssuer = "https://other.example"
+	if s := dashboardSession(req); s != nil {
+		t.Errorf("Session with another issuer: %+v", s)
+	}
+	config.OIDCIssuer = srv.URL
+
+	groups = []string{"staff"}
+	if rec := login(); rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "neither admin nor moderator") {
+		t.Errorf("Login without a group = %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestOIDCBearer(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	srv := fakeOIDCProvider(t, key, func(string) string { return "" })
+	setupOIDC(t, srv.URL)
+	config.AdminToken = "secret"
+
+	claims := func(groups ...string) map[string]any {
+		return map[string]any{"iss": srv.URL, "aud": []string{"guestbook"}, "sub": "42", "exp": time.Now().Add(time.Hour).Unix(), "groups": groups}
+	}
+	expired := claims("guestbook-admins")
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	wrongAudience := claims("guestbook-admins")
+	wrongAudience["aud"] = "someone-else"
+	tests := []struct {
+		name      string
+		auth      string
+		moderate  int
+		adminOnly int
+	}{
+		{"admin token", "Bearer secret", 200, 200},
+		{"admin", "Bearer " + signTestJWT(t, key, "k1", claims("staff", "guestbook-admins")), 200, 200},
+		{"moderator", "Bearer " + signTestJWT(t, key, "k1", claims("guestbook-mods")), 200, 403},
+		{"no group", "Bearer " + signTestJWT(t, key, "k1", claims("staff")), 401, 401},
+		{"other key", "Bearer " + signTestJWT(t, other, "k1", claims("guestbook-admins")), 401, 401},
+		{"unknown kid", "Bearer " + signTestJWT(t, key, "k2", claims("guestbook-admins")), 401, 401},
+		{"expired", "Bearer " + signTestJWT(t, key, "k1", expired), 401, 401},
+		{"wrong audience", "Bearer " + signTestJWT(t, key, "k1", wrongAudience), 401, 401},
+		{"none", "", 401, 401},
+	}
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, h := range []struct {
+				handler http.HandlerFunc
+				want    int
+			}{{requireModerator(ok), tt.moderate}, {requireAdmin(ok), tt.adminOnly}} {
+				req := httptest.NewRequest("GET", "/admin/stats", nil)
+				if tt.auth != "" {
+					req.Header.Set("Authorization", tt.auth)
+				}
+				rec := httptest.NewRecorder()
+				h.handler(rec, req)
+				if rec.Code != h.want {
+					t.Errorf("Status = %d, want %d: %s", rec.Code, h.want, rec.Body.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Notifications go through the outbox table: the messages notify queues
+// are stored first and delivered by runOutbox, which keeps trying with
+// exponential backoff until outbox_max_attempts, when the message is
+// marked dead for an admin to look at and retry.
+
+// OutboxMessage is a notification waiting in the outbox.
+type OutboxMessage struct {
+	ID          int       `json:"id"`
+	Kind        string    `json:"kind"`
+	Target      string    `json:"target"`
+	Status      string    `json:"status"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+	Created     time.Time `json:"created"`
+	payload     string
+}
+
+const (
+	// outboxInterval is how often runOutbox looks for messages that are
+	// due, besides right after one is added.
+	outboxInterval = 30 * time.Second
+	// outboxLease is how long a message being delivered is left alone
+	// by other instances, in case this one dies halfway.
+	outboxLease = 5 * time.Minute
+	// outboxBatch is how many due messages are delivered in one go.
+	outboxBatch = 20
+	// outboxMaxBackoff caps the wait between attempts.
+	outboxMaxBackoff = 6 * time.Hour
+)
+
+// outboxWake has runOutbox look for due messages straight away.
+var outboxWake = make(chan struct{}, 1)
+
+// enqueue adds a message for target to the outbox, due now.
+func enqueue(ctx context.Context, kind, target string, payload any) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "INSERT INTO outbox (kind, target, payload, next_attempt, last_error) VALUES (?, ?, ?, ?, '')",
+		kind, target, string(b), time.Now().UTC().Format(sqlTimeFormat))
+	return err
+}
+
+// wakeOutbox has runOutbox look for due messages now.
+func wakeOutbox() {
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// runOutbox delivers due messages until ctx is cancelled.
+func runOutbox(ctx context.Context) {
+	t := time.NewTicker(outboxInterval)
+	defer t.Stop()
+	for {
+		for deliverDue(ctx) == outboxBatch {
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-outboxWake:
+		}
+	}
+}
+
+// deliverDue delivers up to outboxBatch due messages, returning how many
+// it tried.
+func deliverDue(ctx context.Context) int {
+	due, err := dueMessages(ctx, time.Now())
+	if err != nil {
+		logger.Error("Outbox not read", "error", err)
+		return 0
+	}
+	for _, m := range due {
+		claimed, err := claimMessage(ctx, m, time.Now())
+		if err != nil {
+			logger.Error("Outbox message not claimed", "id", m.ID, "error", err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		m.Attempts++
+		err = deliverMessage(ctx, m)
+		if ctx.Err() != nil {
+			return 0
+		}
+		if err := finishMessage(ctx, m, err, time.Now()); err != nil {
+			logger.Error("Outbox message not updated", "id", m.ID, "error", err)
+		}
+	}
+	return len(due)
+}
+
+func dueMessages(ctx context.Context, now time.Time) ([]OutboxMessage, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, kind, target, payload, attempts FROM outbox WHERE status = 'pending' AND next_attempt <= ? ORDER BY next_attempt, id LIMIT "+strconv.Itoa(outboxBatch),
+		now.UTC().Format(sqlTimeFormat))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var due []OutboxMessage
+	for rows.Next() {
+		var m OutboxMessage
+		if err := rows.Scan(&m.ID, &m.Kind, &m.Target, &m.payload, &m.Attempts); err != nil {
+			return nil, err
+		}
+		due = append(due, m)
+	}
+	return due, rows.Err()
+}
+
+// claimMessage counts the attempt at m and leases it for outboxLease,
+// unless another instance got to it first.
+func claimMessage(ctx context.Context, m OutboxMessage, now time.Time) (bool, error) {
+	res, err := db.ExecContext(ctx, "UPDATE outbox SET attempts = attempts + 1, next_attempt = ? WHERE id = ? AND status = 'pending' AND attempts = ?",
+		now.Add(outboxLease).UTC().Format(sqlTimeFormat), m.ID, m.Attempts)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// finishMessage deletes m once it's delivered, and otherwise schedules
+// the next attempt or, after the last one, marks it dead.
+func finishMessage(ctx context.Context, m OutboxMessage, delivered error, now time.Time) error {
+	if delivered == nil {
+		countNotifier(m.Kind, "sent")
+		logger.Info("Notification sent", "kind", m.Kind, "target", m.Target, "attempts", m.Attempts)
+		_, err := db.ExecContext(ctx, "DELETE FROM outbox WHERE id = ?", m.ID)
+		return err
+	}
+	countNotifier(m.Kind, "failed")
+	status, next := "pending", now.Add(outboxBackoff(m.Attempts))
+	if m.Attempts >= settings().OutboxMaxAttempts {
+		status = "dead"
+		countNotifier(m.Kind, "dead")
+		logger.Error("Notification given up", "kind", m.Kind, "target", m.Target, "attempts", m.Attempts, "error", delivered)
+	} else {
+		logger.Warn("Notification failed", "kind", m.Kind, "target", m.Target, "attempts", m.Attempts, "retry_at", next, "error", delivered)
+	}
+	_, err := db.ExecContext(ctx, "UPDATE outbox SET status = ?, next_attempt = ?, last_error = ? WHERE id = ?",
+		status, next.UTC().Format(sqlTimeFormat), delivered.Error(), m.ID)
+	return err
+}
+
+// outboxBackoff is how long to wait after attempt number attempts failed:
+// 30 seconds, doubling each time up to outboxMaxBackoff.
+func outboxBackoff(attempts int) time.Duration {
+	d := 30 * time.Second
+	for i := 1; i < attempts && d < outboxMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, outboxMaxBackoff)
+}
+
+// outboxHandler lists the outbox on GET, pending and dead messages or
+// just the ones with ?status. POST puts dead messages, all of them or the
+// one with id, back in line for another outbox_max_attempts.
+func outboxHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		status := r.URL.Query().Get("status")
+		if !oneOf(status, "", "pending", "dead") {
+			httpError(w, r, 400, codeInvalidStatus, "status must be pending or dead")
+			return
+		}
+		msgs, err := listOutbox(r.Context(), status)
+		if err != nil {
+			internalError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(msgs)
+	case http.MethodPost:
+		if !parseForm(w, r) {
+			return
+		}
+		id := 0
+		if v := r.FormValue("id"); v != "" {
+			var err error
+			if id, err = strconv.Atoi(v); err != nil || id <= 0 {
+				httpError(w, r, 400, codeInvalidID, "id must be a positive integer")
+				return
+			}
+		}
+		n, err := retryOutbox(r.Context(), id)
+		if err != nil {
+			internalError(w, r, err)
+			return
+		}
+		if n > 0 {
+			wakeOutbox()
+		}
+		requestLogger(r).Info("outbox retried", "id", id, "messages", n)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"retried": n})
+	default:
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func listOutbox(ctx context.Context, status string) ([]OutboxMessage, error) {
+	q, args := "SELECT id, kind, target, status, attempts, next_attempt, last_error, created FROM outbox", []any{}
+	if status != "" {
+		q, args = q+" WHERE status = ?", append(args, status)
+	}
+	rows, err := db.QueryContext(ctx, q+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	msgs := []OutboxMessage{}
+	for rows.Next() {
+		var m OutboxMessage
+		var next, created string
+		if err := rows.Scan(&m.ID, &m.Kind, &m.Target, &m.Status, &m.Attempts, &next, &m.LastError, &created); err != nil {
+			return nil, err
+		}
+		m.NextAttempt, m.Created = parseSQLTime(next), parseSQLTime(created)
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
+
+// retryOutbox makes dead messages, or just the one with id, due again.
+func retryOutbox(ctx context.Context, id int) (int, error) {
+	q, args := "UPDATE outbox SET status = 'pending', attempts = 0, next_attempt = ? WHERE status = 'dead'", []any{time.Now().UTC().Format(sqlTimeFormat)}
+	if id != 0 {
+		q, args = q+" AND id = ?", append(args, id)
+	}
+	res, err := db.ExecContext(ctx, q, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
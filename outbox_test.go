@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOutbox(t *testing.T) {
+	needSQLite(t)
+	defer func(c Config) { config = c }(config)
+	db.Exec("DELETE FROM outbox")
+	ctx := context.Background()
+
+	var fail atomic.Bool
+	var got atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		var e webhookEvent
+		json.NewDecoder(r.Body).Decode(&e)
+		got.Store(e)
+	}))
+	defer srv.Close()
+	config.WebhookURLs, config.OutboxMaxAttempts = []string{srv.URL}, 2
+	config.NotifyEmail, config.MailFrom, config.SMTPAddr = "owner@example.com", "guestbook@example.com", "localhost:25"
+
+	notify(ctx, logger, Notification{Event: "comment.created", Site: defaultSite, Comment: &Comment{ID: 7, Name: "Ann", Email: "ann@example.com", Text: "Hello", Status: "pending"}})
+	msgs, err := listOutbox(ctx, "")
+	if err != nil || len(msgs) != 2 || msgs[0].Kind != "email" || msgs[0].Target != "owner@example.com" || msgs[1].Kind != "webhook" {
+		t.Fatalf("Outbox = %+v, %v", msgs, err)
+	}
+	// the email is only kept around for the rest of the test
+	db.Exec("DELETE FROM outbox WHERE kind = 'email'")
+
+	due := func() {
+		db.Exec("UPDATE outbox SET next_attempt = ?", time.Now().Add(-time.Second).UTC().Format(sqlTimeFormat))
+		deliverDue(ctx)
+	}
+	fail.Store(true)
+	deliverDue(ctx)
+	if msgs, _ := listOutbox(ctx, "pending"); len(msgs) != 1 || msgs[0].Attempts != 1 || !msgs[0].NextAttempt.After(time.Now()) || !strings.Contains(msgs[0].LastError, "503") {
+		t.Fatalf("Outbox after a failure = %+v", msgs)
+	}
+	if deliverDue(ctx) != 0 {
+		t.Error("A message was delivered before its backoff was up")
+	}
+	due()
+	if msgs, _ := listOutbox(ctx, "dead"); len(msgs) != 1 || msgs[0].Attempts != 2 {
+		t.Fatalf("Outbox after the last attempt = %+v", msgs)
+	}
+
+	h := requireAdmin(outboxHandler)
+	config.AdminToken = "secret"
+	req := httptest.NewRequest("GET", "/admin/outbox?status=dead", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	if !strings.Contains(rec.Body.String(), `"status":"dead"`) {
+		t.Errorf("GET /admin/outbox = %d %s", rec.Code, rec.Body.String())
+	}
+	req = httptest.NewRequest("GET", "/admin/outbox?status=sent", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	h(rec, req)
+	if rec.Code != 400 {
+		t.Errorf("GET /admin/outbox?status=sent = %d, want 400", rec.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/admin/outbox", strings.NewReader(url.Values{}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	h(rec, req)
+	if rec.Body.String() != "{\"retried\":1}\n" {
+		t.Errorf("POST /admin/outbox = %d %s", rec.Code, rec.Body.String())
+	}
+	fail.Store(false)
+	due()
+	if msgs, _ := listOutbox(ctx, ""); len(msgs) != 0 {
+		t.Errorf("Outbox after delivery = %+v", msgs)
+	}
+	if e, _ := got.Load().(webhookEvent); e.Event != "comment.created" || e.Comment.ID != 7 {
+		t.Errorf("Webhook got %+v", e)
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{1: 30 * time.Second, 2: time.Minute, 4: 4 * time.Minute, 20: outboxMaxBackoff} {
+		if got := outboxBackoff(attempts); got != want {
+			t.Errorf("outboxBackoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
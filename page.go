@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const guestbookPageSize = 20
+
+type pageView struct {
+	Title          string
+	Comments       []Comment
+	PrevURL        string
+	NextURL        string
+	Action         string
+	CSRF           string
+	RequireConsent bool
+	Form           pageForm
+	Notice         string
+	Error          string
+	// EventsURL is where static/live.js gets new comments from, on the
+	// first page only.
+	EventsURL string
+	Timezone  string
+	// SignIn shows the ways to sign in: the IndieAuth form and links to
+	// OAuth providers. SignedIn is the commenter who did.
+	SignIn        bool
+	IndieAuth     bool
+	SignInLinks   []signInLink
+	SignedIn      *commenterSession
+	RequireSignIn bool
+	// Closed hides the form once closed_after has passed.
+	Closed bool
+	// Uploads adds an image field to the form, with media_storage, and
+	// AvatarUploads an avatar field.
+	Uploads       bool
+	AvatarUploads bool
+	// SubmissionNonce goes with the form, with submission_nonces.
+	SubmissionNonce string
+
+	// Embed renders the page for an iframe, see embedHandler.
+	Embed  bool
+	Theme  string
+	Accent string
+	Nonce  string
+}
+
+type signInLink struct {
+	Title, URL string
+}
+
+// pageForm is what the visitor typed, shown again when posting failed.
+type pageForm struct {
+	Name, Email, Comment string
+}
+
+// guestbookPageHandler serves the guestbook as a plain HTML page at / and
+// /guestbook: the approved comments, newest first, and a form that posts
+// back to the page. It works without any JavaScript.
+func guestbookPageHandler(w http.ResponseWriter, r *http.Request) {
+	serveGuestbookPage(w, r, pageView{})
+}
+
+func serveGuestbookPage(w http.ResponseWriter, r *http.Request, v pageView) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		v.Notice = postedNotice(r)
+		renderGuestbookPage(w, r, http.StatusOK, v)
+	case http.MethodPost:
+		withSubmissionLog(w, r, func(w http.ResponseWriter, r *http.Request) {
+			postGuestbookPage(w, r, v)
+		})
+	default:
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// postGuestbookPage adds the comment the way POST /comments does. On
+// success the browser is sent back to the page so reloading doesn't post
+// again; otherwise the page shows the error with the form filled in.
+func postGuestbookPage(w http.ResponseWriter, r *http.Request, v pageView) {
+	var res bufferedWriter
+	if checkCSRF(&res, r) {
+		addComment(&res, r)
+	}
+	if res.status < 300 {
+		// the remembered commenter's cookie, see rememberCommenter
+		for _, c := range res.header.Values("Set-Cookie") {
+			w.Header().Add("Set-Cookie", c)
+		}
+		http.Redirect(w, r, guestbookPageURL(r, 1, true)+"#comments", http.StatusSeeOther)
+		return
+	}
+
+	msg := strings.TrimSpace(res.body.String())
+	msg = strings.TrimPrefix(msg, res.header.Get("X-Error-Code")+": ")
+	v.Form = pageForm{Name: r.PostFormValue("name"), Email: r.PostFormValue("email"), Comment: r.PostFormValue("comment")}
+	v.Error = msg
+	renderGuestbookPage(w, r, res.status, v)
+}
+
+func renderGuestbookPage(w http.ResponseWriter, r *http.Request, status int, v pageView) {
+	site := siteFor(r)
+	cfg := settings()
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	page = max(page, 1)
+
+	q := CommentQuery{SiteID: site.ID, Status: "approved", Limit: guestbookPageSize, Offset: (page - 1) * guestbookPageSize}
+	total, err := store.Count(r.Context(), q)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if v.Comments, err = store.List(r.Context(), q); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if page > 1 {
+		v.PrevURL = guestbookPageURL(r, page-1, false)
+	}
+	if page*guestbookPageSize < total {
+		v.NextURL = guestbookPageURL(r, page+1, false)
+	}
+
+	v.Title = cmp.Or(cfg.PageTitle, "Guestbook")
+	if config.MultiTenant {
+		v.Title = site.Name
+	}
+	if page == 1 {
+		v.EventsURL = eventsURL(r, v.Comments)
+		v.Timezone = displayLocation.Load().String()
+	}
+	v.Action = guestbookPageURL(r, 1, false)
+	v.CSRF = csrfToken(w, r)
+	v.RequireConsent = cfg.RequireConsent || site.RequireConsent
+	v.RequireSignIn = cfg.RequireSignIn
+	v.Closed = !closedSince(site, cfg, time.Now()).IsZero()
+	v.Uploads = media != nil
+	v.AvatarUploads = v.Uploads && cfg.AvatarUploads
+	if cfg.SubmissionNonces && !v.Closed {
+		// over the limit the form goes without, and posting it says so
+		var err error
+		v.SubmissionNonce, err = issueNonce(r.Context(), cfg, r, site, time.Now())
+		if ae := (*apiError)(nil); err != nil && !errors.As(err, &ae) {
+			internalError(w, r, err)
+			return
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if config.Webmention {
+		w.Header().Add("Link", "<"+webmentionURL()+`>; rel="webmention"`)
+	}
+	if !v.Embed {
+		// not in embeds, where the sign-in cookie isn't sent
+		formAction := "'self'"
+		if signInEnabled() {
+			v.SignIn = true
+			v.SignedIn = signedInCommenter(r)
+			v.IndieAuth = config.IndieAuth
+			for _, p := range enabledOAuthProviders() {
+				q := url.Values{"provider": {p.Name}, "return": {v.Action}}
+				v.SignInLinks = append(v.SignInLinks, signInLink{Title: p.Title, URL: "/oauth/login?" + q.Encode()})
+			}
+		}
+		if cfg.RememberCommenters && v.Form.Name == "" && v.SignedIn == nil {
+			v.Form.Name = rememberedName(r)
+		}
+		if config.IndieAuth {
+			// the sign-in form redirects to the commenter's site
+			formAction += " https: http:"
+		}
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'self' 'unsafe-inline'; img-src 'self' https:; script-src 'self'; connect-src 'self'; form-action "+formAction)
+		renderHTML(w, r, templates.Load().page, status, "page.html", v)
+		return
+	}
+	// sites that list their origins are the only ones that may frame it
+	ancestors := "*"
+	if len(site.AllowedOrigins) > 0 {
+		ancestors = strings.Join(site.AllowedOrigins, " ")
+	}
+	v.Nonce = randomToken()
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'self' 'unsafe-inline'; img-src 'self' https:; script-src 'nonce-"+v.Nonce+"' 'self'; connect-src 'self'; form-action 'self'; frame-ancestors "+ancestors)
+	renderHTML(w, r, templates.Load().page, status, "embed.html", v)
+}
+
+// guestbookPageURL links to a page of the guestbook at the path it was
+// requested on, keeping the site and the embed's theme.
+func guestbookPageURL(r *http.Request, page int, posted bool) string {
+	q := url.Values{}
+	for _, key := range []string{"site", "theme", "accent"} {
+		if v := r.URL.Query().Get(key); v != "" {
+			q.Set(key, v)
+		}
+	}
+	if page > 1 {
+		q.Set("page", strconv.Itoa(page))
+	}
+	if posted {
+		q.Set("posted", "1")
+	}
+	if len(q) == 0 {
+		return r.URL.Path
+	}
+	return r.URL.Path + "?" + q.Encode()
+}
+
+// publicPageURL is where people see the guestbook of site, under
+// public_url.
+func publicPageURL(site *Site) string {
+	u := strings.TrimSuffix(config.PublicURL, "/") + "/"
+	if config.MultiTenant {
+		u += "?site=" + url.QueryEscape(site.Slug)
+	}
+	return u
+}
+
+// eventsURL is the event stream of r's site, resuming after the newest of
+// the comments on the page.
+func eventsURL(r *http.Request, comments []Comment) string {
+	last := 0
+	for _, c := range comments {
+		last = max(last, c.ID)
+	}
+	q := url.Values{"last_event_id": {strconv.Itoa(last)}}
+	if site := r.URL.Query().Get("site"); site != "" {
+		q.Set("site", site)
+	}
+	return "/events?" + q.Encode()
+}
+
+func postedNotice(r *http.Request) string {
+	if r.URL.Query().Get("verified") != "" {
+		return "Thanks! Your email address is verified."
+	}
+	if r.URL.Query().Get("posted") == "" {
+		return ""
+	}
+	if settings().DoubleOptIn {
+		return "Thanks! Unless you've confirmed your email address before, follow the link we just emailed you to confirm your comment."
+	}
+	if siteFor(r).Moderation == "pending" {
+		return "Thanks! Your comment will appear once it's approved."
+	}
+	return "Thanks for signing the guestbook!"
+}
+
+// bufferedWriter holds on to a response instead of sending it, for
+// handlers whose outcome another handler presents.
+type bufferedWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedWriter) Header() http.Header {
+	if w.header == nil {
+		w.header = http.Header{}
+	}
+	return w.header
+}
+
+func (w *bufferedWriter) WriteHeader(status int) {
+	w.status = cmp.Or(w.status, status)
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGuestbookPage(t *testing.T) {
+	defer func(s CommentStore) { store = s }(store)
+	store = newMemoryStore()
+	for i := range guestbookPageSize + 1 {
+		store.Create(t.Context(), &Comment{Name: "Visitor " + strconv.Itoa(i), Text: "Entry " + strconv.Itoa(i), IP: "192.0.2.1"})
+	}
+	store.Create(t.Context(), &Comment{Name: "Hidden", Text: "Held for review", Status: "pending"})
+	store.Create(t.Context(), &Comment{Name: "<script>", Text: "<b>bold</b>"})
+
+	rec := httptest.NewRecorder()
+	guestbookPageHandler(rec, httptest.NewRequest("GET", "/", nil))
+	body := rec.Body.String()
+	if rec.Code != 200 || rec.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Fatalf("GET / = %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	for _, want := range []string{"<title>Guestbook</title>", `<form method="post" action="/">`, "&lt;script&gt;", "&lt;b&gt;bold&lt;/b&gt;", `href="/?page=2"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Page doesn't contain %q", want)
+		}
+	}
+	if strings.Contains(body, "Held for review") || strings.Contains(body, "Entry 0<") {
+		t.Error("Page shows a pending comment or more than one page")
+	}
+	// new comments come in live after the newest one shown
+	if !strings.Contains(body, `<script src="/static/live.js" data-events="/events?last_event_id=23" data-timezone="UTC" defer>`) {
+		t.Errorf("Page doesn't load live.js:\n%s", body)
+	}
+
+	rec = httptest.NewRecorder()
+	guestbookPageHandler(rec, httptest.NewRequest("GET", "/guestbook?page=2", nil))
+	if body := rec.Body.String(); !strings.Contains(body, "Entry 0<") || !strings.Contains(body, `href="/guestbook"`) || strings.Contains(body, "live.js") {
+		t.Errorf("Second page:\n%s", body)
+	}
+}
+
+func TestGuestbookPageTimezone(t *testing.T) {
+	defer func(s CommentStore) { store = s }(store)
+	defer displayLocation.Store(displayLocation.Load())
+	store = newMemoryStore()
+	store.Create(t.Context(), &Comment{Name: "Late", Text: "Just before midnight", Created: time.Date(2024, 12, 31, 23, 30, 0, 0, time.UTC)})
+
+	tests := []struct {
+		zone string
+		want string
+	}{
+		{"UTC", ">31 December 2024</time>"},
+		{"Asia/Tokyo", ">1 January 2025</time>"},
+	}
+	for _, tt := range tests {
+		displayLocation.Store(mustLoadLocation(tt.zone))
+		rec := httptest.NewRecorder()
+		guestbookPageHandler(rec, httptest.NewRequest("GET", "/", nil))
+		body := rec.Body.String()
+		if !strings.Contains(body, tt.want) || !strings.Contains(body, `datetime="2024-12-31T23:30:00Z"`) {
+			t.Errorf("Page in %s:\n%s", tt.zone, body)
+		}
+	}
+}
+
+func TestGuestbookPagePost(t *testing.T) {
+	defer func(s CommentStore) { store = s }(store)
+	store = newMemoryStore()
+
+	rec := postForm(guestbookPageHandler, "/guestbook", url.Values{"name": {"Ann"}, "email": {"ann@example.com"}, "comment": {"Lovely site"}})
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/guestbook?posted=1#comments" {
+		t.Fatalf("POST = %d to %q: %s", rec.Code, rec.Header().Get("Location"), rec.Body.String())
+	}
+	if n, _ := store.Count(t.Context(), CommentQuery{}); n != 1 {
+		t.Errorf("%d comments stored, want 1", n)
+	}
+
+	rec = httptest.NewRecorder()
+	guestbookPageHandler(rec, httptest.NewRequest("GET", "/guestbook?posted=1", nil))
+	if body := rec.Body.String(); !strings.Contains(body, "Thanks for signing") || !strings.Contains(body, "Lovely site") {
+		t.Errorf("Page after posting:\n%s", body)
+	}
+
+	rec = postForm(guestbookPageHandler, "/guestbook", url.Values{"name": {"Bo"}, "comment": {"No email"}})
+	body := rec.Body.String()
+	if rec.Code != 400 || !strings.Contains(body, "All fields (name, email, comment) are required") {
+		t.Fatalf("POST without email = %d:\n%s", rec.Code, body)
+	}
+	if strings.Contains(body, codeMissingFields) || !strings.Contains(body, `value="Bo"`) || !strings.Contains(body, "No email</textarea>") {
+		t.Errorf("Form isn't filled in again after an error:\n%s", body)
+	}
+}
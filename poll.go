@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// pollLimit is the most comments one poll returns. A client with more to
+// catch up on gets them by polling again from the last one.
+const pollLimit = 100
+
+// pollCheckInterval is how often a waiting poll looks for comments written
+// by other instances.
+var pollCheckInterval = 2 * time.Second
+
+// pollHandler is GET /comments/poll?since_id=<id>, the long-polling
+// fallback for clients behind proxies that break /events and /ws. It
+// answers with the approved comments after since_id, oldest first, as soon
+// as there are any, and otherwise with an empty list after ?timeout=
+// seconds, at most poll_timeout. The client polls again from the highest ID
+// it got.
+func pollHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	since, err := strconv.Atoi(r.URL.Query().Get("since_id"))
+	if err != nil || since < 0 {
+		httpError(w, r, 400, codeInvalidID, "since_id must be a comment id")
+		return
+	}
+	maxWait := settings().PollTimeout
+	wait := maxWait
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			httpError(w, r, 400, codeInvalidLimit, "timeout must be a number of seconds")
+			return
+		}
+		wait = min(n, maxWait)
+	}
+
+	ctx := r.Context()
+	site := siteFor(r)
+	sub := feed.subscribe(site.ID)
+	defer feed.unsubscribe(sub)
+	gen, err := recentGeneration(ctx, site.ID)
+	if err != nil {
+		requestLogger(r).Warn("reading the cache generation failed", "error", err)
+	}
+
+	// a long wait would run into read_timeout and write_timeout
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Now().Add(time.Duration(wait)*time.Second + seconds(settings().WriteTimeout, 60)))
+	timer := time.NewTimer(time.Duration(wait) * time.Second)
+	defer timer.Stop()
+	ticker := time.NewTicker(pollCheckInterval)
+	defer ticker.Stop()
+
+	q := CommentQuery{SiteID: site.ID, Status: "approved", AfterID: since, Sort: "oldest", Limit: pollLimit}
+	var comments []Comment
+wait:
+	for {
+		if comments, err = store.List(ctx, q); err != nil {
+			internalError(w, r, err)
+			return
+		}
+		if len(comments) > 0 {
+			break
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-shuttingDown:
+				break wait
+			case <-timer.C:
+				break wait
+			case e := <-sub.events:
+				if e.Comment.Status == "approved" && e.Comment.ID > since {
+					continue wait
+				}
+			case <-ticker.C:
+				g, genErr := recentGeneration(ctx, site.ID)
+				if sub.lagged.Swap(false) || (genErr == nil && g != gen) {
+					gen = g
+					continue wait
+				}
+			}
+		}
+	}
+
+	// the same fields as /events and /ws send
+	live := make([]liveComment, len(comments))
+	for i, c := range comments {
+		live[i] = newLiveComment(c)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(live)
+}
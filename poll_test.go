@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type pollResult struct {
+	comments []Comment
+	status   int
+	elapsed  time.Duration
+}
+
+// poll runs one GET /comments/poll in the background.
+func poll(t *testing.T, url string, since int, timeout string) <-chan pollResult {
+	t.Helper()
+	u := fmt.Sprintf("%s?since_id=%d", url, since)
+	if timeout != "" {
+		u += "&timeout=" + timeout
+	}
+	res := make(chan pollResult, 1)
+	go func() {
+		start := time.Now()
+		resp, err := http.Get(u)
+		if err != nil {
+			res <- pollResult{}
+			return
+		}
+		defer resp.Body.Close()
+		var comments []Comment
+		json.NewDecoder(resp.Body).Decode(&comments)
+		res <- pollResult{comments, resp.StatusCode, time.Since(start)}
+	}()
+	return res
+}
+
+func TestPollHandler(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	defer func(s CommentStore) { store = s }(store)
+	defer func(d time.Duration) { pollCheckInterval = d }(pollCheckInterval)
+	ctx := context.Background()
+	mem := newMemoryStore()
+	store = feedStore{cachingStore{mem}}
+	config.PollTimeout = 5
+	pollCheckInterval = 20 * time.Millisecond
+
+	alice := &Comment{Name: "Alice", Email: "a@example.com", Text: "First"}
+	store.Create(ctx, alice)
+
+	srv := httptest.NewServer(http.HandlerFunc(pollHandler))
+	defer srv.Close()
+
+	if res := <-poll(t, srv.URL, 0, ""); res.status != 200 || len(res.comments) != 1 || res.comments[0].ID != alice.ID || res.comments[0].Email != "" {
+		t.Errorf("Poll with a newer comment = %d %+v", res.status, res.comments)
+	}
+
+	res := <-poll(t, srv.URL, alice.ID, "0")
+	if res.status != 200 || res.comments == nil || len(res.comments) != 0 {
+		t.Errorf("Poll with timeout=0 = %d %+v, want []", res.status, res.comments)
+	}
+
+	tests := []struct {
+		name  string
+		write func() *Comment
+	}{
+		{"new comment", func() *Comment {
+			c := &Comment{Name: "Bob", Email: "b@example.com", Text: "Later"}
+			store.Create(ctx, c)
+			return c
+		}},
+		{"another instance", func() *Comment {
+			c := &Comment{Name: "Carol", Email: "c@example.com", Text: "Elsewhere"}
+			mem.Create(ctx, c)
+			invalidateRecent(ctx, 0)
+			return c
+		}},
+	}
+	last := alice.ID
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pending := poll(t, srv.URL, last, "")
+			time.Sleep(50 * time.Millisecond)
+			// a held comment doesn't end the wait
+			store.Create(ctx, &Comment{Name: "Spammer", Email: "s@example.com", Text: "Held", Status: "pending"})
+			c := tt.write()
+			res := <-pending
+			if len(res.comments) != 1 || res.comments[0].ID != c.ID {
+				t.Errorf("Poll returned %+v, want only %d", res.comments, c.ID)
+			}
+			if res.elapsed > 4*time.Second {
+				t.Errorf("Poll took %v, until its timeout", res.elapsed)
+			}
+			last = c.ID
+		})
+	}
+}
+
+func TestPollHandlerErrors(t *testing.T) {
+	tests := []struct {
+		method, query string
+		status        int
+	}{
+		{"POST", "?since_id=0", 405},
+		{"GET", "", 400},
+		{"GET", "?since_id=abc", 400},
+		{"GET", "?since_id=-1", 400},
+		{"GET", "?since_id=0&timeout=soon", 400},
+		{"GET", "?since_id=0&timeout=-5", 400},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/comments/poll"+tt.query, nil)
+		rec := httptest.NewRecorder()
+		pollHandler(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.query, rec.Code, tt.status)
+		}
+	}
+}
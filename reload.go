@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"sync"
+	"syscall"
+)
+
+// configMu guards the reloadable settings in config. Handlers read them
+// through settings(), everything else is fixed once the server starts.
+var configMu sync.RWMutex
+
+// reloadableKeys are the settings a reload applies without a restart.
+var reloadableKeys = []string{
+	"log_level", "admin_token", "csrf_mode", "form_token_secret", "form_token_max_age",
+	"submission_nonces", "submission_nonce_ttl", "request_log_table", "require_consent", "policy_version",
+	"trusted_proxies", "client_ip_header", "tarpit_seconds", "honeypot_ban_minutes", "max_goroutines", "max_heap_mb", "max_open_fds", "watchdog_restart",
+	"backup_dir", "backup_keep", "duplicate_window", "response_cache_seconds", "poll_timeout",
+	"page_title", "display_timezone", "api_docs", "templates_dir", "static_dir", "approve_authenticated", "require_sign_in", "approve_returning", "remember_commenters", "approve_verified", "double_opt_in",
+	"job_jitter_seconds", "closed_after", "debug_endpoints", "disabled_notifiers",
+	"spam_weights", "spam_moderate_score", "spam_reject_score", "spam_rate_limit", "spam_max_links", "spam_words",
+	"dnsbl_zones", "dnsbl_timeout_ms",
+	"max_links", "link_blocklist", "link_allowlist", "safe_browsing_api_key",
+	"translate_provider", "translate_url", "translate_api_key", "translate_languages",
+}
+
+// settings returns a snapshot of the current config that is safe to read
+// while a reload may be running.
+func settings() Config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return config
+}
+
+// ReloadResult lists the keys whose values changed, split into the ones
+// that were applied and the ones that only take effect after a restart.
+type ReloadResult struct {
+	Reloaded        []string `json:"reloaded"`
+	RestartRequired []string `json:"restart_required"`
+}
+
+// reloadConfig loads and validates the config again and applies the
+// reloadable settings that changed. An invalid config changes nothing.
+func reloadConfig() (ReloadResult, error) {
+	res := ReloadResult{Reloaded: []string{}, RestartRequired: []string{}}
+	c, err := loadConfig(configPath, os.LookupEnv)
+	if err == nil {
+		err = validateConfig(c)
+	}
+	if err != nil {
+		return res, err
+	}
+	// templates are read again even if templates_dir didn't change, so
+	// edits to them show up
+	t, err := parseTemplates(c.TemplatesDir)
+	if err != nil {
+		return res, err
+	}
+
+	configMu.Lock()
+	defer configMu.Unlock()
+	cur := reflect.ValueOf(&config).Elem()
+	next := reflect.ValueOf(c)
+	for i := 0; i < cur.NumField(); i++ {
+		key := cur.Type().Field(i).Tag.Get("toml")
+		if key == "" || reflect.DeepEqual(cur.Field(i).Interface(), next.Field(i).Interface()) {
+			continue
+		}
+		if !slices.Contains(reloadableKeys, key) {
+			res.RestartRequired = append(res.RestartRequired, key)
+			continue
+		}
+		if key == "log_level" {
+			if err := logLevel.UnmarshalText([]byte(c.LogLevel)); err != nil {
+				return res, err
+			}
+		}
+		if key == "display_timezone" {
+			displayLocation.Store(mustLoadLocation(c.DisplayTimezone))
+		}
+		cur.Field(i).Set(next.Field(i))
+		res.Reloaded = append(res.Reloaded, key)
+	}
+	setForwarding(config)
+	templates.Store(t)
+	return res, nil
+}
+
+// logReload reloads the config and logs what happened.
+func logReload() (ReloadResult, error) {
+	res, err := reloadConfig()
+	if err != nil {
+		logger.Error("Config reload failed, keeping the current settings", "error", err)
+		return res, err
+	}
+	logger.Info("Config reloaded", "reloaded", res.Reloaded)
+	if len(res.RestartRequired) > 0 {
+		logger.Warn("Some config changes need a restart", "keys", res.RestartRequired)
+	}
+	return res, nil
+}
+
+// handleReloads reloads the config on every SIGHUP until ctx is cancelled.
+func handleReloads(ctx context.Context) {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGHUP)
+	defer signal.Stop(sigc)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigc:
+			logReload()
+		}
+	}
+}
+
+// reloadHandler is the HTTP equivalent of SIGHUP.
+func reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	res, err := logReload()
+	if err != nil {
+		httpError(w, r, http.StatusUnprocessableEntity, codeInvalidConfig, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func writeTestConfig(t *testing.T, dir, body string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, "config.toml"), []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	needSQLite(t)
+	defer func(c Config, path string, level slog.Level) {
+		config, configPath = c, path
+		logLevel.Set(level)
+	}(config, configPath, logLevel.Level())
+
+	dir := t.TempDir()
+	base := "db_path = '" + filepath.Join(dir, "g.db") + "'\nlog_path = '" + filepath.Join(dir, "g.log") + "'\n"
+	configPath = filepath.Join(dir, "config.toml")
+	writeTestConfig(t, dir, base+"port = 9001\nlog_level = 'info'\n")
+	var err error
+	if config, err = loadConfig(configPath, envMap(nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestConfig(t, dir, base+"port = 9002\nlog_level = 'debug'\nrequire_consent = true\n")
+	res, err := reloadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(res.Reloaded, []string{"log_level", "require_consent"}) {
+		t.Errorf("Reloaded = %v", res.Reloaded)
+	}
+	if !slices.Equal(res.RestartRequired, []string{"port"}) {
+		t.Errorf("RestartRequired = %v", res.RestartRequired)
+	}
+	if !settings().RequireConsent || logLevel.Level() != slog.LevelDebug {
+		t.Error("Reloadable settings were not applied")
+	}
+	if settings().Port != 9001 {
+		t.Errorf("Port = %d, want it unchanged until a restart", settings().Port)
+	}
+
+	writeTestConfig(t, dir, base+"log_level = 'loud'\nrequire_consent = false\n")
+	if _, err := reloadConfig(); err == nil {
+		t.Error("reloadConfig() with invalid config succeeded")
+	}
+	if !settings().RequireConsent {
+		t.Error("Invalid config was partially applied")
+	}
+}
+
+func TestReloadHandler(t *testing.T) {
+	needSQLite(t)
+	defer func(c Config, path string) { config, configPath = c, path }(config, configPath)
+
+	dir := t.TempDir()
+	configPath = filepath.Join(dir, "config.toml")
+	writeTestConfig(t, dir, "db_path = '"+filepath.Join(dir, "g.db")+"'\nlog_output = 'stderr'\ntarpit_seconds = 3\n")
+	var err error
+	if config, err = loadConfig(configPath, envMap(nil)); err != nil {
+		t.Fatal(err)
+	}
+	config.TarpitSeconds = 0
+
+	rec := httptest.NewRecorder()
+	reloadHandler(rec, httptest.NewRequest("POST", "/admin/reload", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status = %d: %s", rec.Code, rec.Body)
+	}
+	var res ReloadResult
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(res.Reloaded, []string{"tarpit_seconds"}) || settings().TarpitSeconds != 3 {
+		t.Errorf("Reload result = %+v", res)
+	}
+
+	writeTestConfig(t, dir, "port = -1\n")
+	rec = httptest.NewRecorder()
+	reloadHandler(rec, httptest.NewRequest("POST", "/admin/reload", nil))
+	if rec.Code != http.StatusUnprocessableEntity || rec.Header().Get("X-Error-Code") != codeInvalidConfig {
+		t.Errorf("Invalid config: status %d, code %q", rec.Code, rec.Header().Get("X-Error-Code"))
+	}
+
+	rec = httptest.NewRecorder()
+	reloadHandler(rec, httptest.NewRequest("GET", "/admin/reload", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d, want 405", rec.Code)
+	}
+}
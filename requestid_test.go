@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWithRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"No incoming ID", "", false},
+		{"UUID", "3f2b8c1e-5d4a-4e6f-9a7b-1c2d3e4f5a6b", true},
+		{"Proxy style", "req_01HZX.42", true},
+		{"Log injection", "abc\n[fake] entry", false},
+		{"Spaces", "a b", false},
+		{"Too long", strings.Repeat("a", 65), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = requestID(r)
+			}))
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set("X-Request-ID", tt.incoming)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			if got := recorder.Header().Get("X-Request-ID"); got != seen {
+				t.Errorf("Response header %q doesn't match request ID %q", got, seen)
+			}
+			if tt.keep && seen != tt.incoming {
+				t.Errorf("Expected incoming ID %q to be kept, got %q", tt.incoming, seen)
+			}
+			if !tt.keep && (seen == tt.incoming || len(seen) != 16) {
+				t.Errorf("Expected a fresh ID, got %q", seen)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With request_log_table every comment submission over HTTP, to the API
+// or from the page's form, goes into the request_log table with how it was
+// answered, so abuse can be looked into by IP and time with GET
+// /admin/request-log instead of grepping the access log. Rows older than
+// request_log_retention_days are deleted on purge_schedule.
+
+// requestLogMaxLimit caps ?limit= of GET /admin/request-log.
+const requestLogMaxLimit = 1000
+
+// RequestLogEntry is a row of request_log.
+type RequestLogEntry struct {
+	ID        int       `json:"id"`
+	Created   time.Time `json:"created"`
+	SiteID    int       `json:"site_id"`
+	IP        string    `json:"ip"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	ErrorCode string    `json:"error_code,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// withSubmissionLog runs next and, with request_log_table, records the
+// submission in request_log. A failure to record it is only logged.
+func withSubmissionLog(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if db == nil || !settings().RequestLogTable {
+		next(w, r)
+		return
+	}
+	start := time.Now()
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	next(sw, r)
+
+	// the client is gone or not, the submission happened
+	_, err := db.ExecContext(context.WithoutCancel(r.Context()),
+		"INSERT INTO request_log (created, site_id, ip, method, path, status, error_code, user_agent, request_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		start.UTC().Format(sqlTimeFormat), siteFor(r).ID, getIP(r), r.Method, clipString(r.URL.Path, 2048), sw.status,
+		w.Header().Get("X-Error-Code"), clipString(r.UserAgent(), 512), requestID(r))
+	if err != nil {
+		requestLogger(r).Warn("writing the request log failed", "error", err)
+	}
+}
+
+// clipString cuts s to at most n bytes, on a character boundary, to fit a
+// column.
+func clipString(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	// what's left of a cut character isn't valid UTF-8
+	return strings.ToValidUTF8(s[:n], "")
+}
+
+// requestLogHandler lists request_log, newest first, filtered by ?ip=,
+// ?since=, ?until= and ?status=, a page of ?limit= (default 100) at a
+// time; ?before_id= continues after the last one.
+func requestLogHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	query := r.URL.Query()
+	var where []string
+	var args []any
+	if ip := query.Get("ip"); ip != "" {
+		where, args = append(where, "ip = ?"), append(args, ip)
+	}
+	for _, bound := range []struct{ param, op string }{{"since", ">="}, {"until", "<="}} {
+		v := query.Get(bound.param)
+		if v == "" {
+			continue
+		}
+		t, dateOnly, err := parseFilterTime(v)
+		if err != nil {
+			httpError(w, r, 400, codeInvalidFilter, bound.param+" must be an RFC 3339 timestamp or YYYY-MM-DD")
+			return
+		}
+		op := bound.op
+		if dateOnly && bound.param == "until" {
+			// the whole day, up to the next one
+			t, op = t.AddDate(0, 0, 1), "<"
+		}
+		where, args = append(where, "created "+op+" ?"), append(args, t.UTC().Format(sqlTimeFormat))
+	}
+	if v := query.Get("status"); v != "" {
+		status, err := strconv.Atoi(v)
+		if err != nil {
+			httpError(w, r, 400, codeInvalidFilter, "status must be an HTTP status code")
+			return
+		}
+		where, args = append(where, "status = ?"), append(args, status)
+	}
+	if v := query.Get("before_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			httpError(w, r, 400, codeInvalidID, "before_id must be an ID from the request log")
+			return
+		}
+		where, args = append(where, "id < ?"), append(args, id)
+	}
+	limit := 100
+	if v := query.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > requestLogMaxLimit {
+			httpError(w, r, 400, codeInvalidLimit, "limit must be between 1 and "+strconv.Itoa(requestLogMaxLimit))
+			return
+		}
+	}
+
+	q := "SELECT id, created, site_id, ip, method, path, status, error_code, user_agent, request_id FROM request_log"
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	rows, err := db.QueryContext(r.Context(), q+" ORDER BY id DESC LIMIT ?", append(args, limit)...)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+	entries := []RequestLogEntry{}
+	for rows.Next() {
+		var e RequestLogEntry
+		var created string
+		if err := rows.Scan(&e.ID, &created, &e.SiteID, &e.IP, &e.Method, &e.Path, &e.Status, &e.ErrorCode, &e.UserAgent, &e.RequestID); err != nil {
+			internalError(w, r, err)
+			return
+		}
+		e.Created = parseSQLTime(created)
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// purgeRequestLog deletes request_log rows older than days.
+func purgeRequestLog(ctx context.Context, days int) (int64, error) {
+	res, err := db.ExecContext(ctx, "DELETE FROM request_log WHERE created < ?", time.Now().AddDate(0, 0, -days).UTC().Format(sqlTimeFormat))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRequestLog(t *testing.T) {
+	needSQLite(t)
+	defer func(c Config, s CommentStore) { config, store = c, s }(config, store)
+	defer db.Exec("DELETE FROM request_log")
+	store = newMemoryStore()
+	config.RequestLogTable = true
+
+	post := func(ip string, form url.Values) int {
+		req := httptest.NewRequest("POST", "/comments", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("User-Agent", "test-agent")
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		commentsHandler(rec, req)
+		return rec.Code
+	}
+	if code := post("203.0.113.1", url.Values{"name": {"Ann"}, "email": {"ann@example.com"}, "comment": {"Hello"}}); code != 201 {
+		t.Fatalf("POST = %d", code)
+	}
+	if code := post("203.0.113.2", url.Values{"name": {"Bob"}}); code != 400 {
+		t.Fatalf("POST without a comment = %d", code)
+	}
+	config.RequestLogTable = false
+	post("203.0.113.3", url.Values{"name": {"Cid"}, "email": {"cid@example.com"}, "comment": {"Hi"}})
+
+	list := func(query string) ([]RequestLogEntry, int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		requestLogHandler(rec, httptest.NewRequest("GET", "/admin/request-log?"+query, nil))
+		var entries []RequestLogEntry
+		if rec.Code == 200 {
+			if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return entries, rec.Code
+	}
+	entries, _ := list("")
+	if len(entries) != 2 || entries[0].IP != "203.0.113.2" || entries[1].IP != "203.0.113.1" {
+		t.Fatalf("Request log = %+v", entries)
+	}
+	if e := entries[0]; e.Status != 400 || e.ErrorCode == "" || e.Method != "POST" || e.UserAgent != "test-agent" {
+		t.Errorf("Refused entry = %+v", e)
+	}
+	if e := entries[1]; e.Status != 201 || e.ErrorCode != "" || e.Path != "/comments" {
+		t.Errorf("Accepted entry = %+v", e)
+	}
+
+	for _, tt := range []struct {
+		query string
+		want  int
+	}{
+		{"ip=203.0.113.1", 1},
+		{"status=400", 1},
+		{"limit=1", 1},
+		{"before_id=" + strconv.Itoa(entries[0].ID), 1},
+		{"since=2000-01-01", 2},
+		{"until=2000-01-01", 0},
+	} {
+		if got, _ := list(tt.query); len(got) != tt.want {
+			t.Errorf("?%s = %d entries, want %d", tt.query, len(got), tt.want)
+		}
+	}
+	for _, q := range []string{"limit=0", "limit=1001", "status=x", "since=yesterday", "before_id=x"} {
+		if _, code := list(q); code != 400 {
+			t.Errorf("?%s = %d", q, code)
+		}
+	}
+
+	if n, err := purgeRequestLog(t.Context(), 1); err != nil || n != 0 {
+		t.Errorf("purgeRequestLog = %d, %v", n, err)
+	}
+}
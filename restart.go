@@ -0,0 +1,119 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// listenFDsEnv tells a restarted guestbook how many listening sockets it
+// inherited from the process it replaces.
+const listenFDsEnv = "GUESTBOOK_LISTEN_FDS"
+
+// listenFDStart is the first inherited descriptor, right after stdio.
+var listenFDStart = 3
+
+// listen returns the i-th listener handed over by the previous process
+// during a restart, or opens a new one on addr.
+func listen(i int, addr string) (net.Listener, error) {
+	n, _ := strconv.Atoi(os.Getenv(listenFDsEnv))
+	if i >= n {
+		return net.Listen("tcp", addr)
+	}
+	f := os.NewFile(uintptr(listenFDStart+i), fmt.Sprintf("listener%d", i))
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// handleRestarts starts a new copy of the binary on every SIGUSR2, passing
+// it the listening sockets so no connection is refused during an upgrade.
+// The new process tells us to drain and exit once it's ready.
+func handleRestarts(ctx context.Context, lns []net.Listener) {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGUSR2)
+	defer signal.Stop(sigc)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigc:
+		}
+		if config.DBDriver == "bbolt" {
+			// the new process couldn't open the file while we hold its lock
+			logger.Error("Restarts need db_driver sqlite3 or mysql, bbolt can't be opened twice")
+			continue
+		}
+		pid, err := restart(lns)
+		if err != nil {
+			logger.Error("Restart failed, keeping the current process", "error", err)
+			continue
+		}
+		logger.Info("Started new process, handing over listeners", "pid", pid)
+	}
+}
+
+// restart execs the current binary with the same arguments and the
+// listeners' sockets as extra files.
+func restart(lns []net.Listener) (int, error) {
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, ln := range lns {
+		fl, ok := ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			return 0, fmt.Errorf("can't pass %T to a new process", ln)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return 0, err
+		}
+		files = append(files, f)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = restartEnv(os.Environ(), len(files))
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	return cmd.Process.Pid, nil
+}
+
+// restartEnv returns environ with listenFDsEnv set to n.
+func restartEnv(environ []string, n int) []string {
+	env := make([]string, 0, len(environ)+1)
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, listenFDsEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	return append(env, fmt.Sprintf("%s=%d", listenFDsEnv, n))
+}
+
+// notifyParent asks the process we took the listeners over from to shut
+// down gracefully. It does nothing when we weren't started by a restart.
+func notifyParent() {
+	if os.Getenv(listenFDsEnv) == "" {
+		return
+	}
+	if err := syscall.Kill(os.Getppid(), syscall.SIGTERM); err != nil {
+		logger.Warn("Couldn't stop the previous process", "error", err)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"context"
+	"net"
+)
+
+func listen(i int, addr string) (net.Listener, error) {
+	return net.Listen("tcp", addr)
+}
+
+func handleRestarts(ctx context.Context, lns []net.Listener) {}
+
+func notifyParent() {}
@@ -0,0 +1,69 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"net"
+	"os"
+	"slices"
+	"syscall"
+	"testing"
+)
+
+func TestListenInherited(t *testing.T) {
+	defer func(start int) { listenFDStart = start }(listenFDStart)
+
+	parent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer parent.Close()
+	f, err := parent.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// listen takes ownership of the descriptor, so hand it a copy f doesn't
+	// also close
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listenFDStart = fd
+	t.Setenv(listenFDsEnv, "1")
+
+	ln, err := listen(0, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if ln.Addr().String() != parent.Addr().String() {
+		t.Errorf("listen() = %s, want inherited %s", ln.Addr(), parent.Addr())
+	}
+}
+
+func TestListenNew(t *testing.T) {
+	t.Setenv(listenFDsEnv, "1")
+
+	// only the first listener was inherited, the second is opened fresh
+	ln, err := listen(1, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+
+	os.Unsetenv(listenFDsEnv)
+	ln, err = listen(0, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+}
+
+func TestRestartEnv(t *testing.T) {
+	env := restartEnv([]string{"HOME=/root", listenFDsEnv + "=1", "PATH=/bin"}, 2)
+	want := []string{"HOME=/root", "PATH=/bin", listenFDsEnv + "=2"}
+	if !slices.Equal(env, want) {
+		t.Errorf("restartEnv() = %q, want %q", env, want)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A comment is from a returning commenter when it comes with the
+// guestbook_returning cookie of a browser that posted an approved comment
+// with the same email on the site before; such comments have Returning
+// set, and are published right away with approve_returning. Emails are no
+// proof, anyone can type in one they've seen. The cookie only holds a
+// random ID; the comments posted with it, and with remember_commenters the
+// name to fill in the form with next time, are kept in shared state, like
+// commenter sessions.
+
+const returningCookie = "guestbook_returning"
+
+// returningTTL is how long a commenter is remembered after the last
+// comment.
+const returningTTL = 365 * 24 * time.Hour
+
+// returningMaxComments is how many of the latest comments posted with a
+// cookie are remembered.
+const returningMaxComments = 20
+
+// rememberedCommenter is what the returning cookie stands for.
+type rememberedCommenter struct {
+	Name     string              `json:"name,omitempty"`
+	Comments []rememberedComment `json:"comments,omitempty"`
+}
+
+type rememberedComment struct {
+	SiteID int `json:"site_id"`
+	ID     int `json:"id"`
+}
+
+func rememberedCommenterKey(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return "returning-commenter:" + hex.EncodeToString(sum[:])
+}
+
+// isReturning reports whether one of the comments rc posted on c's site
+// is approved and has c's email.
+func isReturning(ctx context.Context, c *Comment, rc rememberedCommenter) (bool, error) {
+	if c.Email == "" {
+		return false, nil
+	}
+	for _, rem := range rc.Comments {
+		if rem.SiteID != c.SiteID {
+			continue
+		}
+		earlier, err := store.Get(ctx, rem.SiteID, rem.ID)
+		if errors.Is(err, errNotFound) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if earlier.Status == "approved" && strings.EqualFold(earlier.Email, c.Email) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// rememberCommenter adds c to what the visitor's cookie stands for, and
+// name, unless it's empty, setting a cookie if they have none yet.
+func rememberCommenter(w http.ResponseWriter, r *http.Request, name string, c *Comment) {
+	id := randomToken()
+	if cookie, err := r.Cookie(returningCookie); err == nil && cookie.Value != "" {
+		id = cookie.Value
+	}
+	rc := remembered(r)
+	rc.Name = cmp.Or(name, rc.Name)
+	if rem := (rememberedComment{c.SiteID, c.ID}); !slices.Contains(rc.Comments, rem) {
+		rc.Comments = append(rc.Comments, rem)
+	}
+	rc.Comments = rc.Comments[max(0, len(rc.Comments)-returningMaxComments):]
+	v, _ := json.Marshal(rc)
+	if err := shared.Set(r.Context(), rememberedCommenterKey(id), v, returningTTL); err != nil {
+		requestLogger(r).Warn("remembering the commenter failed", "error", err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     returningCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(returningTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// remembered is what the visitor's returning cookie stands for, if they
+// have one.
+func remembered(r *http.Request) rememberedCommenter {
+	var rc rememberedCommenter
+	c, err := r.Cookie(returningCookie)
+	if err != nil || c.Value == "" {
+		return rc
+	}
+	v, ok, err := shared.Get(r.Context(), rememberedCommenterKey(c.Value))
+	if err != nil {
+		requestLogger(r).Warn("reading the remembered commenter failed", "error", err)
+		return rc
+	}
+	if ok && json.Unmarshal(v, &rc) != nil {
+		return rememberedCommenter{}
+	}
+	return rc
+}
+
+// rememberedName is the name rememberCommenter kept for the visitor, or
+// empty.
+func rememberedName(r *http.Request) string {
+	return remembered(r).Name
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestReturningCommenter(t *testing.T) {
+	defer func(c Config, s CommentStore, sh SharedState) { config, store, shared = c, s, sh }(config, store, shared)
+	store, shared = newMemoryStore(), newMemoryState()
+	config.ApproveReturning, config.RememberCommenters = true, true
+	site := &Site{ID: 1, Slug: "default", Moderation: "pending"}
+
+	post := func(name, email string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		form := url.Values{"name": {name}, "email": {email}, "comment": {"Hello from " + name}}
+		req := httptest.NewRequest("POST", "/comments", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), siteKey{}, site))
+		rec := httptest.NewRecorder()
+		addComment(rec, req)
+		if rec.Code != 201 {
+			t.Fatalf("POST = %d %s", rec.Code, rec.Body)
+		}
+		return rec
+	}
+
+	rec := post("Ann", "ann@example.com")
+	comments, _ := store.List(t.Context(), CommentQuery{SiteID: site.ID})
+	if len(comments) != 1 || comments[0].Returning || comments[0].Status != "pending" {
+		t.Fatalf("First comment %+v", comments)
+	}
+
+	// still pending, so the next one isn't from a returning commenter
+	post("Ann", "ann@example.com", rec.Result().Cookies()...)
+	comments, _ = store.List(t.Context(), CommentQuery{SiteID: site.ID})
+	if comments[0].Returning {
+		t.Errorf("Comment after a pending one is returning")
+	}
+
+	comments[1].Status = "approved"
+	store.Update(t.Context(), &comments[1])
+	post("Ann", "ANN@example.com", rec.Result().Cookies()...)
+	comments, _ = store.List(t.Context(), CommentQuery{SiteID: site.ID})
+	if !comments[0].Returning || comments[0].Status != "approved" {
+		t.Errorf("Returning comment %+v", comments[0])
+	}
+	if !strings.Contains(string(appendCommentJSON(nil, &comments[0])), `"returning":true`) {
+		t.Error("JSON doesn't tell the comment is from a returning commenter")
+	}
+
+	// the email alone, from another browser, isn't enough
+	other := post("Mallory", "ann@example.com")
+	post("Mallory", "ann@example.com", other.Result().Cookies()...)
+	comments, _ = store.List(t.Context(), CommentQuery{SiteID: site.ID, Limit: 2})
+	for _, c := range comments {
+		if c.Returning || c.Status != "pending" {
+			t.Errorf("Comment with a copied email %+v", c)
+		}
+	}
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != returningCookie || !cookies[0].HttpOnly {
+		t.Fatalf("Cookies %v", cookies)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookies[0])
+	if name := rememberedName(req); name != "Ann" {
+		t.Errorf("rememberedName() = %q, want Ann", name)
+	}
+	rec = post("Ann B", "ann@example.com", cookies[0])
+	if c := rec.Result().Cookies(); len(c) != 1 || c[0].Value != cookies[0].Value {
+		t.Errorf("Cookie changed to %v", c)
+	}
+	if name := rememberedName(req); name != "Ann B" {
+		t.Errorf("rememberedName() = %q, want Ann B", name)
+	}
+	rec = httptest.NewRecorder()
+	guestbookPageHandler(rec, req)
+	if !strings.Contains(rec.Body.String(), `value="Ann B"`) {
+		t.Error("The form isn't filled in with the remembered name")
+	}
+	if name := rememberedName(httptest.NewRequest("GET", "/", nil)); name != "" {
+		t.Errorf("rememberedName() without a cookie = %q", name)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatingFile is an append-only log file that moves itself aside once it
+// grows past maxBytes or has been written to for longer than maxAge. Rotated
+// files are named "<path>.<timestamp>", optionally gzipped, and only the
+// newest keep of them are kept. Zero values disable the respective limit.
+type rotatingFile struct {
+	path     string
+	maxBytes int64
+	maxAge   time.Duration
+	keep     int
+	compress bool
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+
+	// compressions tracks background gzip jobs so Close can wait for them
+	compressions sync.WaitGroup
+}
+
+// openLogFile opens path for appending with the log_max_* settings.
+func openLogFile(path string) (*rotatingFile, error) {
+	f := &rotatingFile{
+		path:     path,
+		maxBytes: int64(config.LogMaxSizeMB) << 20,
+		maxAge:   time.Duration(config.LogMaxAgeHours) * time.Hour,
+		keep:     config.LogMaxBackups,
+		compress: config.LogCompress,
+	}
+	return f, f.open()
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.opened = time.Now()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.size > 0 && ((f.maxBytes > 0 && f.size+int64(len(p)) > f.maxBytes) ||
+		(f.maxAge > 0 && time.Since(f.opened) > f.maxAge)) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate must be called with f.mu held.
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	base := f.path + "." + time.Now().UTC().Format("20060102-150405")
+	rotated := base
+	for i := 1; fileExists(rotated) || fileExists(rotated+".gz"); i++ {
+		rotated = base + "-" + strconv.Itoa(i)
+	}
+	if err := os.Rename(f.path, rotated); err != nil {
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	if f.compress {
+		f.compressions.Add(1)
+		go func() {
+			defer f.compressions.Done()
+			if err := gzipFile(rotated); err != nil {
+				logger.Error("compressing rotated log failed", "file", rotated, "error", err)
+			}
+			f.prune()
+		}()
+	} else {
+		f.prune()
+	}
+	return nil
+}
+
+// prune deletes the oldest rotated files beyond the retention count.
+func (f *rotatingFile) prune() {
+	if f.keep <= 0 {
+		return
+	}
+	matches, _ := filepath.Glob(f.path + ".*")
+	var rotated []string
+	for _, m := range matches {
+		if !strings.HasSuffix(m, ".gz.tmp") {
+			rotated = append(rotated, m)
+		}
+	}
+	// The timestamp suffix sorts chronologically
+	sort.Strings(rotated)
+	for len(rotated) > f.keep {
+		os.Remove(rotated[0])
+		rotated = rotated[1:]
+	}
+}
+
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	err := f.file.Close()
+	f.mu.Unlock()
+	f.compressions.Wait()
+	return err
+}
+
+// gzipFile replaces path with path.gz.
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz.tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	if err := os.Rename(path+".gz.tmp", path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
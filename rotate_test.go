@@ -0,0 +1,80 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingFileSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "guestbook.log")
+	f := &rotatingFile{path: path, maxBytes: 20, keep: 2}
+	if err := f.open(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each write is 10 bytes, so every third write starts a new file
+	for i := 0; i < 9; i++ {
+		if _, err := f.Write([]byte("123456789\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(current) != 10 {
+		t.Errorf("Expected 10 bytes in the current file, got %d", len(current))
+	}
+
+	rotated, _ := filepath.Glob(path + ".*")
+	if len(rotated) != 2 {
+		t.Errorf("Expected 2 rotated files kept, got %v", rotated)
+	}
+}
+
+func TestRotatingFileAgeAndCompress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f := &rotatingFile{path: path, maxAge: time.Hour, compress: true}
+	if err := f.open(); err != nil {
+		t.Fatal(err)
+	}
+
+	f.Write([]byte("old entry\n"))
+	f.opened = time.Now().Add(-2 * time.Hour)
+	f.Write([]byte("new entry\n"))
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	current, _ := os.ReadFile(path)
+	if string(current) != "new entry\n" {
+		t.Errorf("Unexpected current file %q", current)
+	}
+
+	rotated, _ := filepath.Glob(path + ".*")
+	if len(rotated) != 1 || !strings.HasSuffix(rotated[0], ".gz") {
+		t.Fatalf("Expected one gzipped rotated file, got %v", rotated)
+	}
+	gz, err := os.Open(rotated[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gz.Close()
+	zr, err := gzip.NewReader(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, _ := io.ReadAll(zr)
+	if string(old) != "old entry\n" {
+		t.Errorf("Unexpected rotated content %q", old)
+	}
+}
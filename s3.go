@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+func s3Enabled() bool {
+	return config.BackupS3Bucket != ""
+}
+
+// newS3Client connects to backup_s3_endpoint, which may be a bare host
+// (HTTPS) or a URL to pick the scheme. Without configured keys the usual
+// AWS_* environment variables and instance credentials are tried.
+func newS3Client() (*minio.Client, error) {
+	host, secure, err := parseS3Endpoint(config.BackupS3Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	creds := credentials.NewStaticV4(config.BackupS3AccessKey, config.BackupS3SecretKey, "")
+	if config.BackupS3AccessKey == "" {
+		creds = credentials.NewChainCredentials([]credentials.Provider{&credentials.EnvAWS{}, &credentials.IAM{}})
+	}
+	return minio.New(host, &minio.Options{
+		Creds:  creds,
+		Secure: secure,
+		Region: config.BackupS3Region,
+		// a backup that can't be uploaded is retried at the next interval
+		MaxRetries: 3,
+	})
+}
+
+func parseS3Endpoint(endpoint string) (host string, secure bool, err error) {
+	if !strings.Contains(endpoint, "://") {
+		return endpoint, true, nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", false, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return "", false, fmt.Errorf("%q must be a host or an http(s) URL", endpoint)
+	}
+	return u.Host, u.Scheme == "https", nil
+}
+
+// s3Key names the object for a backup taken at b.Created. Keys sort by
+// date under backup_s3_prefix, so bucket lifecycle rules can expire or
+// archive old backups by prefix and age.
+func s3Key(b *Backup) string {
+	return path.Join(config.BackupS3Prefix, b.Created.Format("2006/01"), b.Name)
+}
+
+// uploadBackup copies the backup file at path to the bucket and returns
+// its key.
+func uploadBackup(ctx context.Context, b *Backup, file string) (string, error) {
+	client, err := newS3Client()
+	if err != nil {
+		return "", err
+	}
+	key := s3Key(b)
+	_, err = client.FPutObject(ctx, config.BackupS3Bucket, key, file, minio.PutObjectOptions{
+		ContentType: "application/vnd.sqlite3",
+	})
+	return key, err
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseS3Endpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		host     string
+		secure   bool
+		wantErr  bool
+	}{
+		{"s3.amazonaws.com", "s3.amazonaws.com", true, false},
+		{"https://s3.eu-central-003.backblazeb2.com", "s3.eu-central-003.backblazeb2.com", true, false},
+		{"http://localhost:9000", "localhost:9000", false, false},
+		{"ftp://example.com", "", false, true},
+	}
+	for _, tt := range tests {
+		host, secure, err := parseS3Endpoint(tt.endpoint)
+		if host != tt.host || secure != tt.secure || (err != nil) != tt.wantErr {
+			t.Errorf("parseS3Endpoint(%q) = %q, %v, %v", tt.endpoint, host, secure, err)
+		}
+	}
+}
+
+func TestS3Key(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.BackupS3Prefix = "guestbook"
+	b := &Backup{Name: "guestbook-20240501T030000Z.db", Created: time.Date(2024, 5, 1, 3, 0, 0, 0, time.UTC)}
+	if got, want := s3Key(b), "guestbook/2024/05/guestbook-20240501T030000Z.db"; got != want {
+		t.Errorf("s3Key() = %q, want %q", got, want)
+	}
+}
+
+func TestBackupUpload(t *testing.T) {
+	needSQLite(t)
+	var mu sync.Mutex
+	uploads := map[string]int{}
+	auth := ""
+	s3 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method == http.MethodPut {
+			mu.Lock()
+			// the body is signed in chunks, so it's larger than the file
+			uploads[r.URL.Path], _ = strconv.Atoi(r.Header.Get("X-Amz-Decoded-Content-Length"))
+			if uploads[r.URL.Path] == 0 {
+				uploads[r.URL.Path] = len(body)
+			}
+			auth = r.Header.Get("Authorization")
+			mu.Unlock()
+		}
+		w.Header().Set("ETag", `"d41d8cd98f00b204e9800998ecf8427e"`)
+	}))
+	defer s3.Close()
+
+	defer func(c Config) { config = c }(config)
+	config.DBDriver = "sqlite3"
+	config.BackupDir = filepath.Join(t.TempDir(), "backups")
+	config.BackupS3Endpoint = s3.URL
+	config.BackupS3Region = "us-east-1"
+	config.BackupS3Bucket = "backups"
+	config.BackupS3Prefix = "guestbook"
+	config.BackupS3AccessKey, config.BackupS3SecretKey = "AKID", "secret"
+
+	b, err := createBackup(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.UploadError != "" || !strings.HasPrefix(b.S3Key, "guestbook/") {
+		t.Fatalf("Backup = %+v", b)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if n := uploads["/backups/"+b.S3Key]; int64(n) != b.Size {
+		t.Errorf("Uploaded %v, want %d bytes at /backups/%s", uploads, b.Size, b.S3Key)
+	}
+	if !strings.Contains(auth, "Credential=AKID/") {
+		t.Errorf("Upload not signed with the configured key: %q", auth)
+	}
+
+	// a broken bucket doesn't lose the local backup
+	s3.Close()
+	b, err = createBackup(t.Context())
+	if err != nil || b.UploadError == "" || b.S3Key != "" {
+		t.Errorf("Backup with S3 down = %+v, %v", b, err)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type SearchResult struct {
+	Comment
+	Snippet string `json:"snippet"`
+}
+
+// The FTS snippet function marks matches with these, in place of tags that
+// couldn't be told apart from ones in the comment, and markSnippet turns
+// them into <mark> once the text is escaped.
+const (
+	snippetMarkStart = "\x02"
+	snippetMarkEnd   = "\x03"
+)
+
+// markSnippet HTML-escapes an FTS snippet and wraps its matches in <mark>.
+// Markers that don't pair up, which only a comment can bring, are dropped
+// or closed.
+func markSnippet(s string) string {
+	var b strings.Builder
+	open := false
+	for _, r := range html.EscapeString(s) {
+		switch {
+		case string(r) == snippetMarkStart && !open:
+			b.WriteString("<mark>")
+			open = true
+		case string(r) == snippetMarkEnd && open:
+			b.WriteString("</mark>")
+			open = false
+		case string(r) != snippetMarkStart && string(r) != snippetMarkEnd:
+			b.WriteRune(r)
+		}
+	}
+	if open {
+		b.WriteString("</mark>")
+	}
+	return b.String()
+}
+
+// ftsVersion is "fts5" when the sqlite3 driver was built with the
+// sqlite_fts5 tag, otherwise we fall back to the always-available fts4.
+var ftsVersion string
+
+// initSearch sets up the comments_fts index and the triggers that keep it
+// in sync with the comments table. The index is rebuilt from scratch the
+// first time it is created so existing guestbooks become searchable.
+func initSearch() error {
+	if usingMySQL() {
+		return nil
+	}
+	var exists int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'comments_fts'`).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if exists == 0 {
+		_, err = db.Exec(`CREATE VIRTUAL TABLE comments_fts USING fts5(name, text, content='comments', content_rowid='id')`)
+		if err == nil {
+			ftsVersion = "fts5"
+		} else if strings.Contains(err.Error(), "no such module") {
+			_, err = db.Exec(`CREATE VIRTUAL TABLE comments_fts USING fts4(content='comments', name, text)`)
+			ftsVersion = "fts4"
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		var sqlText string
+		if err := db.QueryRow(`SELECT sql FROM sqlite_master WHERE name = 'comments_fts'`).Scan(&sqlText); err != nil {
+			return err
+		}
+		ftsVersion = "fts4"
+		if strings.Contains(strings.ToLower(sqlText), "fts5") {
+			ftsVersion = "fts5"
+		}
+	}
+
+	var triggers string
+	if ftsVersion == "fts5" {
+		triggers = `
+			CREATE TRIGGER IF NOT EXISTS comments_fts_ai AFTER INSERT ON comments BEGIN
+				INSERT INTO comments_fts(rowid, name, text) VALUES (new.id, new.name, new.text);
+			END;
+			CREATE TRIGGER IF NOT EXISTS comments_fts_ad AFTER DELETE ON comments BEGIN
+				INSERT INTO comments_fts(comments_fts, rowid, name, text) VALUES ('delete', old.id, old.name, old.text);
+			END;
+			CREATE TRIGGER IF NOT EXISTS comments_fts_au AFTER UPDATE OF name, text ON comments BEGIN
+				INSERT INTO comments_fts(comments_fts, rowid, name, text) VALUES ('delete', old.id, old.name, old.text);
+				INSERT INTO comments_fts(rowid, name, text) VALUES (new.id, new.name, new.text);
+			END;
+		`
+	} else {
+		// fts4 external content tables read the old row back from comments,
+		// so deletes have to happen BEFORE the row changes.
+		triggers = `
+			CREATE TRIGGER IF NOT EXISTS comments_fts_bd BEFORE DELETE ON comments BEGIN
+				DELETE FROM comments_fts WHERE docid = old.id;
+			END;
+			CREATE TRIGGER IF NOT EXISTS comments_fts_bu BEFORE UPDATE OF name, text ON comments BEGIN
+				DELETE FROM comments_fts WHERE docid = old.id;
+			END;
+			CREATE TRIGGER IF NOT EXISTS comments_fts_ai AFTER INSERT ON comments BEGIN
+				INSERT INTO comments_fts(docid, name, text) VALUES (new.id, new.name, new.text);
+			END;
+			CREATE TRIGGER IF NOT EXISTS comments_fts_au AFTER UPDATE OF name, text ON comments BEGIN
+				INSERT INTO comments_fts(docid, name, text) VALUES (new.id, new.name, new.text);
+			END;
+		`
+	}
+	if _, err := db.Exec(triggers); err != nil {
+		return err
+	}
+
+	if exists == 0 {
+		_, err = db.Exec(`INSERT INTO comments_fts(comments_fts) VALUES ('rebuild')`)
+	}
+	return err
+}
+
+// ftsQuery turns free-form user input into a MATCH expression where every
+// word is a quoted phrase, so stray quotes or operators can't break the query.
+func ftsQuery(q string) string {
+	var terms []string
+	for _, word := range strings.Fields(strings.ReplaceAll(q, `"`, " ")) {
+		terms = append(terms, `"`+word+`"`)
+	}
+	return strings.Join(terms, " ")
+}
+
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	q := r.URL.Query().Get("q")
+	if ftsQuery(q) == "" {
+		httpError(w, r, 400, codeInvalidQuery, "Query parameter q is required")
+		return
+	}
+
+	limit := 15
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > 100 {
+			httpError(w, r, 400, codeInvalidLimit, "limit must be between 1 and 100")
+			return
+		}
+		limit = n
+	}
+
+	results, err := store.Search(r.Context(), siteFor(r).ID, q, limit)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if !isAdmin(r) {
+		for i := range results {
+			results[i].hidePersonal()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
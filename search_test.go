@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestFTSQuery(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"", ""},
+		{"hello", `"hello"`},
+		{"hello world", `"hello" "world"`},
+		{`say "hi" OR`, `"say" "hi" "OR"`},
+	}
+
+	for _, tt := range tests {
+		if got := ftsQuery(tt.input); got != tt.expected {
+			t.Errorf("ftsQuery(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestSearchHandler(t *testing.T) {
+	needSQLite(t)
+	_, err := db.Exec("DELETE FROM comments")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, text := range []string{"Lovely website, greetings from Berlin", "Hello from Paris", "Nice photos"} {
+		_, err := db.Exec("INSERT INTO comments (name, email, text, ip, location) VALUES (?, ?, ?, ?, ?)",
+			"Tester", "test@example.com", text, "1.2.3.4", "Test Location")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Updates and deletes must be reflected in the index too
+	if _, err := db.Exec("UPDATE comments SET text = 'Nice photos of Berlin' WHERE text = 'Nice photos'"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("DELETE FROM comments WHERE text = 'Hello from Paris'"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expected       int
+	}{
+		{"Single match", "greetings", 200, 1},
+		{"Updated row", "berlin", 200, 2},
+		{"Deleted row", "paris", 200, 0},
+		{"Quotes are harmless", `"berlin`, 200, 2},
+		{"Missing query", "", 400, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/search?q="+url.QueryEscape(tt.query), nil)
+			recorder := httptest.NewRecorder()
+
+			searchHandler(recorder, req)
+
+			if recorder.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+			if tt.expectedStatus != 200 {
+				return
+			}
+
+			var results []SearchResult
+			if err := json.NewDecoder(recorder.Body).Decode(&results); err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != tt.expected {
+				t.Errorf("Expected %d results, got %d", tt.expected, len(results))
+			}
+			for _, res := range results {
+				if !strings.Contains(res.Snippet, "<mark>") {
+					t.Errorf("Snippet has no highlight: %q", res.Snippet)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchSnippetEscaped(t *testing.T) {
+	needSQLite(t)
+	defer db.Exec("DELETE FROM comments")
+	_, err := db.Exec("INSERT INTO comments (name, email, text, ip, location) VALUES (?, ?, ?, ?, ?)",
+		"Tester", "test@example.com", "<script>alert(1)</script> zebra <mark>", "1.2.3.4", "Test Location")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := store.Search(t.Context(), 0, "zebra", 15)
+	if err != nil || len(results) != 1 {
+		t.Fatalf("Search = %v, %v", results, err)
+	}
+	want := "&lt;script&gt;alert(1)&lt;/script&gt; <mark>zebra</mark> &lt;mark&gt;"
+	if got := results[0].Snippet; got != want {
+		t.Errorf("Snippet = %q, want %q", got, want)
+	}
+}
+
+func TestMarkSnippet(t *testing.T) {
+	for in, want := range map[string]string{
+		"a \x02b\x03 <i>": "a <mark>b</mark> &lt;i&gt;",
+		"\x03a \x02\x02b": "a <mark>b</mark>",
+		"":                "",
+	} {
+		if got := markSnippet(in); got != want {
+			t.Errorf("markSnippet(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
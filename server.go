@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// shuttingDown is closed when the server starts shutting down, telling
+// long-running handlers like the tarpit to let go of their connections.
+var shuttingDown = make(chan struct{})
+var shutdownOnce sync.Once
+
+// newServer returns an http.Server with timeouts and header limits from
+// the config, so slow or oversized clients can't tie up connections.
+func newServer(handler http.Handler) *http.Server {
+	maxHeader := config.MaxHeaderBytes
+	if maxHeader <= 0 {
+		maxHeader = 16 << 10
+	}
+	return &http.Server{
+		Handler:           withBodyLimit(handler),
+		ReadHeaderTimeout: seconds(config.ReadHeaderTimeout, 5),
+		ReadTimeout:       seconds(config.ReadTimeout, 15),
+		WriteTimeout:      seconds(config.WriteTimeout, 60),
+		IdleTimeout:       seconds(config.IdleTimeout, 120),
+		MaxHeaderBytes:    maxHeader,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+	}
+}
+
+// withBodyLimit caps request bodies at max_body_bytes, or
+// max_bulk_body_bytes for bulk uploads. With media_storage, multipart
+// forms may be media_max_bytes larger for the image, and as much again
+// for an avatar with avatar_uploads. Reading past the
+// limit fails with *http.MaxBytesError, which parseForm turns into a 413.
+func withBodyLimit(next http.Handler) http.Handler {
+	limit := int64(config.MaxBodyBytes)
+	if limit <= 0 {
+		limit = 64 << 10
+	}
+	bulkLimit := int64(config.MaxBulkBodyBytes)
+	if bulkLimit <= 0 {
+		bulkLimit = 32 << 20
+	}
+	uploadLimit := limit
+	if config.MediaStorage != "" {
+		uploadLimit += int64(config.MediaMaxBytes)
+	}
+	if config.AvatarUploads {
+		uploadLimit += int64(config.MediaMaxBytes)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil && r.Body != http.NoBody {
+			if r.URL.Path == "/admin/comments/bulk" || r.URL.Path == apiPrefix+"/admin/comments/bulk" {
+				r.Body = http.MaxBytesReader(w, r.Body, bulkLimit)
+			} else if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+				r.Body = http.MaxBytesReader(w, r.Body, uploadLimit)
+			} else {
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// seconds converts a config value in seconds, falling back to def when it
+// isn't set.
+func seconds(v, def int) time.Duration {
+	if v <= 0 {
+		v = def
+	}
+	return time.Duration(v) * time.Second
+}
+
+// shutdownTimeout is how long a stopping server waits for requests in
+// flight.
+func shutdownTimeout() time.Duration {
+	return seconds(config.ShutdownTimeout, 10)
+}
+
+// runServer serves on ln until ctx is cancelled, then stops accepting
+// connections and waits up to shutdown_timeout for in-flight requests.
+func runServer(ctx context.Context, srv *http.Server, ln net.Listener) error {
+	srv.RegisterOnShutdown(func() { shutdownOnce.Do(func() { close(shuttingDown) }) })
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	timeout := shutdownTimeout()
+	logger.Info("shutting down, draining requests", "timeout", timeout)
+
+	sctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := srv.Shutdown(sctx)
+	if serveErr := <-errc; !errors.Is(serveErr, http.ErrServerClosed) {
+		return serveErr
+	}
+	return err
+}
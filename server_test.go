@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunServerDrainsRequests(t *testing.T) {
+	// later tests need a server that isn't shutting down
+	defer func() {
+		shutdownOnce.Do(func() {}) // waits for the close to finish
+		shuttingDown = make(chan struct{})
+	}()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		io.WriteString(w, "finished")
+	})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- runServer(ctx, srv, ln) }()
+
+	type result struct {
+		body string
+		err  error
+	}
+	resc := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/")
+		if err != nil {
+			resc <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		resc <- result{string(body), err}
+	}()
+
+	<-started
+	cancel()
+
+	res := <-resc
+	if res.err != nil || res.body != "finished" {
+		t.Errorf("In-flight request was not drained: %q, %v", res.body, res.err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("runServer() = %v", err)
+	}
+
+	select {
+	case <-shuttingDown:
+	default:
+		t.Error("shuttingDown was not closed")
+	}
+
+	if _, err := http.Get("http://" + ln.Addr().String() + "/"); err == nil {
+		t.Error("Server still accepts connections after shutdown")
+	}
+}
+
+func TestNewServerDefaults(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.ReadHeaderTimeout = 0
+	config.ReadTimeout = 0
+	config.WriteTimeout = 7
+	config.IdleTimeout = 0
+	config.MaxHeaderBytes = 0
+
+	srv := newServer(http.NotFoundHandler())
+
+	if srv.ReadHeaderTimeout != 5*time.Second || srv.ReadTimeout != 15*time.Second ||
+		srv.WriteTimeout != 7*time.Second || srv.IdleTimeout != 120*time.Second {
+		t.Errorf("Unexpected timeouts %v %v %v %v", srv.ReadHeaderTimeout, srv.ReadTimeout, srv.WriteTimeout, srv.IdleTimeout)
+	}
+	if srv.MaxHeaderBytes != 16<<10 {
+		t.Errorf("Unexpected MaxHeaderBytes %d", srv.MaxHeaderBytes)
+	}
+}
+
+func TestBodyLimit(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.MaxBodyBytes = 100
+
+	handler := withBodyLimit(http.HandlerFunc(commentsHandler))
+
+	tests := []struct {
+		name           string
+		comment        string
+		expectedStatus int
+	}{
+		{"Small comment", "Hello", 201},
+		{"Huge comment", strings.Repeat("spam ", 100), 413},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := "name=John&email=john@example.com&comment=" + url.QueryEscape(tt.comment)
+			req := httptest.NewRequest("POST", "/comments", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			recorder := httptest.NewRecorder()
+
+			handler.ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+			if tt.expectedStatus == 413 && recorder.Header().Get("X-Error-Code") != codeBodyTooLarge {
+				t.Errorf("Expected %s error code, got %q", codeBodyTooLarge, recorder.Header().Get("X-Error-Code"))
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SharedState holds the small bits of state every instance behind a load
+// balancer has to agree on: cached responses, idempotency keys, counters
+// and one-time tokens. It lives in memory unless redis_url is set.
+type SharedState interface {
+	// Get returns the value of key, or false if it isn't set.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// SetNX sets key only if it isn't set yet and reports whether it did.
+	SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+	Delete(ctx context.Context, key string) error
+	// Incr adds one to the counter at key and returns the new value. A new
+	// counter expires after ttl; incrementing doesn't extend it, so it also
+	// serves as a fixed-window rate limit counter.
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	Ping(ctx context.Context) error
+}
+
+// shared is the SharedState every handler uses.
+var shared SharedState = newMemoryState()
+
+// overRate counts one more event for key and reports whether that's more
+// than limit in the fixed window it started. Refusals are *apiError.
+func overRate(ctx context.Context, key string, limit int, window time.Duration) error {
+	n, err := shared.Incr(ctx, "rate:"+key, window)
+	if err != nil {
+		return err
+	}
+	if n > int64(limit) {
+		return &apiError{status: http.StatusTooManyRequests, code: codeRateLimited, message: "Too many requests, try again later"}
+	}
+	return nil
+}
+
+// memoryState is SharedState for a single instance.
+type memoryState struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	pruned  time.Time
+}
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+func newMemoryState() *memoryState {
+	return &memoryState{entries: map[string]memoryEntry{}}
+}
+
+// get returns the live entry at key. The caller holds the lock.
+func (s *memoryState) get(key string, now time.Time) (memoryEntry, bool) {
+	e, ok := s.entries[key]
+	if ok && !e.expires.IsZero() && now.After(e.expires) {
+		delete(s.entries, key)
+		return e, false
+	}
+	return e, ok
+}
+
+// set stores value and, at most once a minute, drops expired entries that
+// nobody asked for again. The caller holds the lock.
+func (s *memoryState) set(key string, value []byte, ttl time.Duration, now time.Time) {
+	e := memoryEntry{value: value}
+	if ttl > 0 {
+		e.expires = now.Add(ttl)
+	}
+	s.entries[key] = e
+
+	if now.Sub(s.pruned) < time.Minute {
+		return
+	}
+	s.pruned = now
+	for k, e := range s.entries {
+		if !e.expires.IsZero() && now.After(e.expires) {
+			delete(s.entries, k)
+		}
+	}
+}
+
+func (s *memoryState) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.get(key, time.Now())
+	return e.value, ok, nil
+}
+
+func (s *memoryState) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set(key, value, ttl, time.Now())
+	return nil
+}
+
+func (s *memoryState) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if _, ok := s.get(key, now); ok {
+		return false, nil
+	}
+	s.set(key, value, ttl, now)
+	return true, nil
+}
+
+func (s *memoryState) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *memoryState) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	e, ok := s.get(key, now)
+	if !ok {
+		s.set(key, []byte("1"), ttl, now)
+		return 1, nil
+	}
+	n, _ := strconv.ParseInt(string(e.value), 10, 64)
+	n++
+	e.value = strconv.AppendInt(nil, n, 10)
+	s.entries[key] = e
+	return n, nil
+}
+
+func (s *memoryState) Ping(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// testSharedState runs the SharedState contract against s. advance moves
+// s's clock forward.
+func testSharedState(t *testing.T, s SharedState, advance func(time.Duration)) {
+	ctx := t.Context()
+
+	if _, ok, err := s.Get(ctx, "missing"); ok || err != nil {
+		t.Errorf("Get() of a missing key = %v, %v", ok, err)
+	}
+	if err := s.Set(ctx, "k", []byte("v"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, err := s.Get(ctx, "k"); !ok || string(v) != "v" || err != nil {
+		t.Errorf("Get() = %q, %v, %v", v, ok, err)
+	}
+
+	if set, err := s.SetNX(ctx, "k", []byte("other"), time.Minute); set || err != nil {
+		t.Errorf("SetNX() of a set key = %v, %v", set, err)
+	}
+	if set, err := s.SetNX(ctx, "lock", nil, time.Second); !set || err != nil {
+		t.Errorf("SetNX() of a new key = %v, %v", set, err)
+	}
+	if v, ok, _ := s.Get(ctx, "lock"); !ok || len(v) != 0 {
+		t.Errorf("Get() of an empty value = %q, %v", v, ok)
+	}
+
+	for want := int64(1); want <= 3; want++ {
+		if n, err := s.Incr(ctx, "counter", 30*time.Second); n != want || err != nil {
+			t.Errorf("Incr() = %d, %v; want %d", n, err, want)
+		}
+	}
+	if _, err := s.Incr(ctx, "forever", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Delete(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := s.Get(ctx, "k"); ok {
+		t.Error("Key still there after Delete()")
+	}
+
+	// incrementing doesn't push the expiry of a counter back
+	advance(20 * time.Second)
+	s.Incr(ctx, "counter", 30*time.Second)
+	advance(20 * time.Second)
+	if _, ok, _ := s.Get(ctx, "lock"); ok {
+		t.Error("Key outlived its TTL")
+	}
+	if n, _ := s.Incr(ctx, "counter", 30*time.Second); n != 1 {
+		t.Errorf("Incr() after the window = %d, want a new counter", n)
+	}
+	if v, ok, _ := s.Get(ctx, "forever"); !ok || string(v) != "1" {
+		t.Errorf("Counter without TTL = %q, %v", v, ok)
+	}
+
+	if err := s.Ping(ctx); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMemoryState(t *testing.T) {
+	s := newMemoryState()
+	// memoryState reads the wall clock, so age the entries instead
+	testSharedState(t, s, func(d time.Duration) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for k, e := range s.entries {
+			if !e.expires.IsZero() {
+				e.expires = e.expires.Add(-d)
+				s.entries[k] = e
+			}
+		}
+	})
+}
+
+func TestRedisState(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s, err := openRedisState("redis://"+mr.Addr()+"/0", "test:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	testSharedState(t, s, mr.FastForward)
+
+	if !mr.Exists("test:forever") {
+		t.Error("Keys aren't prefixed with redis_prefix")
+	}
+	if _, err := openRedisState("redis://127.0.0.1:1/0", ""); err == nil {
+		t.Error("openRedisState() of a closed port succeeded")
+	}
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Site is one guestbook served by a multi-tenant deployment. Its comments
+// are invisible to every other site.
+type Site struct {
+	ID             int      `json:"id"`
+	Slug           string   `json:"slug"`
+	Name           string   `json:"name"`
+	Moderation     string   `json:"moderation"`
+	RequireConsent bool     `json:"require_consent"`
+	AllowedOrigins []string `json:"allowed_origins"`
+	Archived       bool     `json:"archived"`
+	// ClosedAfter is when the site stops taking new comments, zero for
+	// never.
+	ClosedAfter time.Time `json:"closed_after,omitzero"`
+}
+
+// defaultSite owns every comment when multi_tenant is off, and the
+// comments written before it was turned on.
+var defaultSite = &Site{ID: 0, Slug: "default", Name: "default", Moderation: "approved", AllowedOrigins: []string{}}
+
+// moderationModes are the statuses a site can give new comments: approved
+// shows them right away, pending holds them until an admin approves them.
+var moderationModes = []string{"approved", "pending"}
+
+var validSlug = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+var errUnknownSite = errors.New("No site matches the API key or slug")
+
+type siteKey struct{}
+
+// requireSite resolves the site a request is for from its X-API-Key
+// header or ?api_key=, or from the ?site= slug, and rejects requests that
+// don't name one. Browser requests from origins the site doesn't allow
+// are refused, and archived sites are read-only. Without multi_tenant
+// every request gets defaultSite.
+func requireSite(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !config.MultiTenant {
+			next(w, r)
+			return
+		}
+		key := r.Header.Get("X-API-Key")
+		if key == "" {
+			key = r.URL.Query().Get("api_key")
+		}
+		slug := r.URL.Query().Get("site")
+		if key == "" && slug == "" {
+			httpError(w, r, 400, codeSiteRequired, "An X-API-Key header or site parameter is required")
+			return
+		}
+
+		site, err := lookupSite(r.Context(), key, slug)
+		if err == errUnknownSite {
+			httpError(w, r, http.StatusNotFound, codeUnknownSite, err.Error())
+			return
+		} else if err != nil {
+			internalError(w, r, err)
+			return
+		}
+
+		// forms on the guestbook's own pages post from its own origin
+		if origin := r.Header.Get("Origin"); origin != "" && len(site.AllowedOrigins) > 0 && !sameOrigin(r, origin) {
+			if !slices.Contains(site.AllowedOrigins, origin) {
+				httpError(w, r, http.StatusForbidden, codeForbidden, "Origin not allowed for this site")
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+		}
+		if site.Archived && r.Method != http.MethodGet && !isAdmin(r) {
+			httpError(w, r, http.StatusGone, codeSiteArchived, "This guestbook is archived and read-only")
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), siteKey{}, site)))
+	}
+}
+
+// sameOrigin reports whether origin is the host r was sent to.
+func sameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// siteFor returns the site requireSite resolved for r.
+func siteFor(r *http.Request) *Site {
+	if site, ok := r.Context().Value(siteKey{}).(*Site); ok {
+		return site
+	}
+	return defaultSite
+}
+
+// lookupSite finds a site by API key, or by slug when no key is given. If
+// both are given they have to belong to the same site.
+func lookupSite(ctx context.Context, key, slug string) (*Site, error) {
+	query := siteColumns + " WHERE "
+	var arg string
+	if key != "" {
+		query += "api_key_hash = ?"
+		arg = hashAPIKey(key)
+	} else {
+		query += "slug = ?"
+		arg = slug
+	}
+
+	s, err := scanSite(db.QueryRowContext(ctx, query, arg))
+	if err == sql.ErrNoRows || (err == nil && key != "" && slug != "" && slug != s.Slug) {
+		return nil, errUnknownSite
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// siteByID returns site id, defaultSite for 0.
+func siteByID(ctx context.Context, id int) (*Site, error) {
+	if id == 0 {
+		return defaultSite, nil
+	}
+	s, err := scanSite(db.QueryRowContext(ctx, siteColumns+" WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, errUnknownSite
+	}
+	return s, err
+}
+
+const siteColumns = `SELECT id, slug, name, moderation, require_consent, allowed_origins, archived, closed_after FROM sites`
+
+func scanSite(row interface{ Scan(...any) error }) (*Site, error) {
+	var s Site
+	var origins string
+	var closed sql.NullString
+	if err := row.Scan(&s.ID, &s.Slug, &s.Name, &s.Moderation, &s.RequireConsent, &origins, &s.Archived, &closed); err != nil {
+		return nil, err
+	}
+	s.AllowedOrigins = strings.Fields(origins)
+	if closed.Valid {
+		s.ClosedAfter = parseSQLTime(closed.String)
+	}
+	return &s, nil
+}
+
+// closedAfterArg is t as the closed_after column stores it.
+func closedAfterArg(t time.Time) sql.NullString {
+	if t.IsZero() {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: t.UTC().Format(sqlTimeFormat), Valid: true}
+}
+
+// listSites returns every site, archived ones included.
+func listSites(ctx context.Context) ([]*Site, error) {
+	rows, err := db.QueryContext(ctx, siteColumns+" ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sites := []*Site{}
+	for rows.Next() {
+		s, err := scanSite(rows)
+		if err != nil {
+			return nil, err
+		}
+		sites = append(sites, s)
+	}
+	return sites, rows.Err()
+}
+
+// createSite adds site and returns it with its API key. Only a hash of the
+// key is stored, so this is the one time it can be shown.
+func createSite(ctx context.Context, site Site) (*Site, string, error) {
+	if !validSlug.MatchString(site.Slug) {
+		return nil, "", fmt.Errorf("slug %q must be lowercase letters, digits and dashes", site.Slug)
+	}
+	if site.Moderation == "" {
+		site.Moderation = "approved"
+	}
+	valid := false
+	for _, m := range moderationModes {
+		valid = valid || m == site.Moderation
+	}
+	if !valid {
+		return nil, "", fmt.Errorf("moderation %q must be approved or pending", site.Moderation)
+	}
+	if site.Name == "" {
+		site.Name = site.Slug
+	}
+	origins, err := normalizeOrigins(site.AllowedOrigins)
+	if err != nil {
+		return nil, "", err
+	}
+	site.AllowedOrigins = origins
+
+	key := newAPIKey()
+	res, err := db.ExecContext(ctx,
+		"INSERT INTO sites (slug, name, api_key_hash, moderation, require_consent, allowed_origins, closed_after) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		site.Slug, site.Name, hashAPIKey(key), site.Moderation, site.RequireConsent, strings.Join(origins, " "), closedAfterArg(site.ClosedAfter),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	id, _ := res.LastInsertId()
+	site.ID = int(id)
+	site.ClosedAfter = site.ClosedAfter.UTC().Truncate(time.Second)
+	return &site, key, nil
+}
+
+// normalizeOrigins checks that every origin is a bare scheme://host[:port]
+// as browsers send it in the Origin header.
+func normalizeOrigins(origins []string) ([]string, error) {
+	normalized := []string{}
+	for _, o := range origins {
+		u, err := url.Parse(strings.TrimSuffix(o, "/"))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			return nil, fmt.Errorf("allowed origin %q must look like https://example.com", o)
+		}
+		normalized = append(normalized, u.Scheme+"://"+u.Host)
+	}
+	return normalized, nil
+}
+
+// rotateAPIKey replaces the API key of site slug, invalidating the old one.
+func rotateAPIKey(ctx context.Context, slug string) (string, error) {
+	key := newAPIKey()
+	res, err := db.ExecContext(ctx, "UPDATE sites SET api_key_hash = ? WHERE slug = ?", hashAPIKey(key), slug)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", errUnknownSite
+	}
+	return key, nil
+}
+
+// archiveSite makes site slug read-only. Its comments stay readable.
+func archiveSite(ctx context.Context, slug string) (*Site, error) {
+	res, err := db.ExecContext(ctx, "UPDATE sites SET archived = 1 WHERE slug = ?", slug)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, errUnknownSite
+	}
+	return lookupSite(ctx, "", slug)
+}
+
+// closeSite stops site slug taking new comments after t, or opens it again
+// if t is zero.
+func closeSite(ctx context.Context, slug string, t time.Time) (*Site, error) {
+	// MySQL counts only changed rows, so the lookup tells if slug exists
+	if _, err := db.ExecContext(ctx, "UPDATE sites SET closed_after = ? WHERE slug = ?", closedAfterArg(t), slug); err != nil {
+		return nil, err
+	}
+	return lookupSite(ctx, "", slug)
+}
+
+func newAPIKey() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return "gb_" + base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// runAddSite implements `guestbook add-site [flags] <slug>`.
+func runAddSite(args []string, out io.Writer) error {
+	var site Site
+	fs := flag.NewFlagSet("add-site", flag.ContinueOnError)
+	fs.StringVar(&site.Name, "name", "", "display name (default: the slug)")
+	fs.StringVar(&site.Moderation, "moderation", "approved", "status of new comments: approved or pending")
+	fs.BoolVar(&site.RequireConsent, "require-consent", false, "reject comments without privacy policy consent")
+	closedAfter := fs.String("closed-after", "", "stop taking comments after this RFC 3339 time or YYYY-MM-DD day")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: guestbook add-site [-name N] [-moderation M] [-require-consent] [-closed-after T] <slug>")
+	}
+	var err error
+	if site.ClosedAfter, err = parseClosedAfter(*closedAfter); err != nil {
+		return err
+	}
+	site.Slug = fs.Arg(0)
+	if db == nil {
+		return errors.New("sites need db_driver sqlite3 or mysql")
+	}
+
+	created, key, err := createSite(context.Background(), site)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Created site %s (id %d)\nAPI key: %s\n", created.Slug, created.ID, key)
+	return nil
+}
+
+// sitesHandler lists sites on GET and creates one on POST from the form
+// fields slug, name, moderation, require_consent, allowed_origins (space or
+// comma separated) and closed_after. The response to a POST is the only place
+// the new site's API key is shown.
+func sitesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		sites, err := listSites(r.Context())
+		if err != nil {
+			internalError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sites)
+	case http.MethodPost:
+		if !parseForm(w, r) {
+			return
+		}
+		site := Site{
+			Slug:           r.FormValue("slug"),
+			Name:           r.FormValue("name"),
+			Moderation:     r.FormValue("moderation"),
+			RequireConsent: hasConsent(r.FormValue("require_consent")),
+			AllowedOrigins: strings.FieldsFunc(r.FormValue("allowed_origins"), func(c rune) bool {
+				return c == ' ' || c == ','
+			}),
+		}
+		var err error
+		if site.ClosedAfter, err = parseClosedAfter(r.FormValue("closed_after")); err != nil {
+			httpError(w, r, 400, codeInvalidSite, err.Error())
+			return
+		}
+		created, key, err := createSite(r.Context(), site)
+		if err != nil {
+			if isUniqueViolation(err) {
+				httpError(w, r, http.StatusConflict, codeInvalidSite, "A site with this slug already exists")
+			} else {
+				httpError(w, r, 400, codeInvalidSite, err.Error())
+			}
+			return
+		}
+		requestLogger(r).Info("site created", "site", created.Slug)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(struct {
+			*Site
+			APIKey string `json:"api_key"`
+		}{created, key})
+	default:
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// rotateKeyHandler issues a new API key for the site in form field slug.
+// The old key stops working immediately.
+func rotateKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !parseForm(w, r) {
+		return
+	}
+	slug := r.FormValue("slug")
+	key, err := rotateAPIKey(r.Context(), slug)
+	if err == errUnknownSite {
+		httpError(w, r, http.StatusNotFound, codeUnknownSite, err.Error())
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	requestLogger(r).Info("site api key rotated", "site", slug)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"slug": slug, "api_key": key})
+}
+
+// archiveSiteHandler makes the site in form field slug read-only.
+func archiveSiteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !parseForm(w, r) {
+		return
+	}
+	site, err := archiveSite(r.Context(), r.FormValue("slug"))
+	if err == errUnknownSite {
+		httpError(w, r, http.StatusNotFound, codeUnknownSite, err.Error())
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	requestLogger(r).Info("site archived", "site", site.Slug)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(site)
+}
+
+// closeSiteHandler stops the site in form field slug taking new comments
+// after closed_after, which is now if it's missing. An empty closed_after
+// opens it again.
+func closeSiteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !parseForm(w, r) {
+		return
+	}
+	closed := time.Now().UTC()
+	if _, ok := r.Form["closed_after"]; ok {
+		var err error
+		if closed, err = parseClosedAfter(r.FormValue("closed_after")); err != nil {
+			httpError(w, r, 400, codeInvalidSite, err.Error())
+			return
+		}
+	}
+	site, err := closeSite(r.Context(), r.FormValue("slug"), closed)
+	if err == errUnknownSite {
+		httpError(w, r, http.StatusNotFound, codeUnknownSite, err.Error())
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	requestLogger(r).Info("site closed", "site", site.Slug, "closed_after", site.ClosedAfter)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(site)
+}
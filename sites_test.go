@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateSite(t *testing.T) {
+	needSQLite(t)
+	db.Exec("DELETE FROM sites")
+
+	site, key, err := createSite(context.Background(), Site{Slug: "blog"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if site.Name != "blog" || site.Moderation != "approved" || !strings.HasPrefix(key, "gb_") {
+		t.Errorf("createSite() = %+v, %q", site, key)
+	}
+	var stored string
+	db.QueryRow("SELECT api_key_hash FROM sites WHERE id = ?", site.ID).Scan(&stored)
+	if stored == key || stored != hashAPIKey(key) {
+		t.Errorf("Stored key %q, want the hash of %q", stored, key)
+	}
+
+	tests := []struct {
+		slug, moderation string
+	}{
+		{"blog", "approved"},
+		{"My Blog", "approved"},
+		{"-blog", "approved"},
+		{"shop", "spam"},
+	}
+	for _, tt := range tests {
+		if _, _, err := createSite(context.Background(), Site{Slug: tt.slug, Moderation: tt.moderation}); err == nil {
+			t.Errorf("createSite(%q, %q) succeeded, want error", tt.slug, tt.moderation)
+		}
+	}
+}
+
+func TestRequireSite(t *testing.T) {
+	needSQLite(t)
+	defer func(c Config) { config = c }(config)
+	config.MultiTenant = true
+	db.Exec("DELETE FROM sites")
+	_, blogKey, _ := createSite(context.Background(), Site{Slug: "blog"})
+	createSite(context.Background(), Site{Slug: "shop"})
+
+	tests := []struct {
+		name     string
+		query    string
+		key      string
+		wantCode int
+		wantSite string
+	}{
+		{"Missing", "", "", 400, ""},
+		{"Slug", "?site=blog", "", 200, "blog"},
+		{"Unknown slug", "?site=nope", "", 404, ""},
+		{"Header key", "", blogKey, 200, "blog"},
+		{"Query key", "?api_key=" + blogKey, "", 200, "blog"},
+		{"Wrong key", "", "gb_wrong", 404, ""},
+		{"Key for another site", "?site=shop", blogKey, 404, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *Site
+			h := requireSite(func(w http.ResponseWriter, r *http.Request) { got = siteFor(r) })
+			req := httptest.NewRequest("GET", "/comments"+tt.query, nil)
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			rec := httptest.NewRecorder()
+			h(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("Status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantSite != "" && (got == nil || got.Slug != tt.wantSite) {
+				t.Errorf("Site = %+v, want %s", got, tt.wantSite)
+			}
+		})
+	}
+
+	config.MultiTenant = false
+	var got *Site
+	requireSite(func(w http.ResponseWriter, r *http.Request) { got = siteFor(r) })(httptest.NewRecorder(), httptest.NewRequest("GET", "/comments", nil))
+	if got != defaultSite {
+		t.Errorf("Single-tenant site = %+v, want defaultSite", got)
+	}
+}
+
+func TestSiteIsolation(t *testing.T) {
+	needSQLite(t)
+	defer func(c Config) { config = c }(config)
+	config.MultiTenant = true
+	db.Exec("DELETE FROM sites")
+	db.Exec("DELETE FROM comments")
+	createSite(context.Background(), Site{Slug: "blog"})
+	createSite(context.Background(), Site{Slug: "shop", Moderation: "pending", RequireConsent: true})
+
+	post := func(site, text string) *httptest.ResponseRecorder {
+		form := url.Values{"name": {"Ann"}, "email": {"ann@example.com"}, "comment": {text}, "consent": {"on"}}
+		req := httptest.NewRequest("POST", "/comments?site="+site, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		requireSite(commentsHandler)(rec, req)
+		return rec
+	}
+	list := func(path, site string) []Comment {
+		rec := httptest.NewRecorder()
+		requireSite(allCommentsHandler)(rec, httptest.NewRequest("GET", path+"site="+site, nil))
+		var comments []Comment
+		json.NewDecoder(rec.Body).Decode(&comments)
+		return comments
+	}
+
+	form := url.Values{"name": {"Ann"}, "email": {"ann@example.com"}, "comment": {"no consent"}}
+	req := httptest.NewRequest("POST", "/comments?site=shop", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	requireSite(commentsHandler)(rec, req)
+	if rec.Code != 400 || rec.Header().Get("X-Error-Code") != codeConsentRequired {
+		t.Errorf("Shop post without consent = %d, want consent_required", rec.Code)
+	}
+
+	if rec := post("blog", "hello from the blog"); rec.Code != http.StatusCreated {
+		t.Fatalf("Blog post status = %d", rec.Code)
+	}
+	rec = post("shop", "hello from the shop")
+	if rec.Code != http.StatusCreated || !strings.Contains(rec.Body.String(), "awaiting moderation") {
+		t.Fatalf("Shop post = %d %q", rec.Code, rec.Body)
+	}
+
+	if c := list("/all?", "blog"); len(c) != 1 || c[0].Text != "hello from the blog" {
+		t.Errorf("Blog comments = %+v", c)
+	}
+	if c := list("/all?", "shop"); len(c) != 0 {
+		t.Errorf("Shop comments = %+v, want pending comment hidden", c)
+	}
+
+	rec = httptest.NewRecorder()
+	requireSite(searchHandler)(rec, httptest.NewRequest("GET", "/search?q=hello&site=blog", nil))
+	var results []SearchResult
+	json.NewDecoder(rec.Body).Decode(&results)
+	if len(results) != 1 || results[0].Text != "hello from the blog" {
+		t.Errorf("Blog search = %+v", results)
+	}
+
+	var shopComment int
+	db.QueryRow("SELECT id FROM comments WHERE text = 'hello from the shop'").Scan(&shopComment)
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/like?site=blog&id="+strconv.Itoa(shopComment), nil)
+	requireSite(likeHandler)(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Liking another site's comment: status %d, want 404", rec.Code)
+	}
+}
+
+func TestRunAddSite(t *testing.T) {
+	needSQLite(t)
+	db.Exec("DELETE FROM sites")
+
+	var out bytes.Buffer
+	if err := runAddSite([]string{"-name", "My Blog", "-moderation", "pending", "-require-consent", "blog"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "API key: gb_") {
+		t.Errorf("Output = %q", out.String())
+	}
+	site, err := lookupSite(context.Background(), "", "blog")
+	if err != nil || site.Name != "My Blog" || site.Moderation != "pending" || !site.RequireConsent {
+		t.Errorf("lookupSite() = %+v, %v", site, err)
+	}
+
+	if err := runAddSite(nil, &out); err == nil {
+		t.Error("runAddSite() without slug succeeded")
+	}
+}
+
+func postForm(h http.HandlerFunc, target string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+func TestSitesHandler(t *testing.T) {
+	needSQLite(t)
+	db.Exec("DELETE FROM sites")
+
+	rec := postForm(sitesHandler, "/admin/sites", url.Values{
+		"slug":            {"blog"},
+		"name":            {"My Blog"},
+		"moderation":      {"pending"},
+		"allowed_origins": {"https://blog.example.com/, http://localhost:8080"},
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Create status = %d: %s", rec.Code, rec.Body)
+	}
+	var created struct {
+		Site
+		APIKey string `json:"api_key"`
+	}
+	json.NewDecoder(rec.Body).Decode(&created)
+	if created.Slug != "blog" || created.Moderation != "pending" || created.APIKey == "" {
+		t.Errorf("Created = %+v", created)
+	}
+	if want := []string{"https://blog.example.com", "http://localhost:8080"}; strings.Join(created.AllowedOrigins, " ") != strings.Join(want, " ") {
+		t.Errorf("AllowedOrigins = %q, want %q", created.AllowedOrigins, want)
+	}
+	if site, err := lookupSite(context.Background(), created.APIKey, ""); err != nil || site.Slug != "blog" {
+		t.Errorf("Returned key doesn't resolve: %+v, %v", site, err)
+	}
+
+	tests := []struct {
+		name string
+		form url.Values
+		code int
+	}{
+		{"Duplicate", url.Values{"slug": {"blog"}}, http.StatusConflict},
+		{"Bad slug", url.Values{"slug": {"My Blog"}}, 400},
+		{"Bad origin", url.Values{"slug": {"shop"}, "allowed_origins": {"shop.example.com"}}, 400},
+		{"Bad moderation", url.Values{"slug": {"shop"}, "moderation": {"never"}}, 400},
+	}
+	for _, tt := range tests {
+		if rec := postForm(sitesHandler, "/admin/sites", tt.form); rec.Code != tt.code {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.code)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	sitesHandler(rec, httptest.NewRequest("GET", "/admin/sites", nil))
+	var sites []Site
+	json.NewDecoder(rec.Body).Decode(&sites)
+	if len(sites) != 1 || sites[0].Name != "My Blog" {
+		t.Errorf("Listed sites = %+v", sites)
+	}
+}
+
+func TestRotateAndArchiveSite(t *testing.T) {
+	needSQLite(t)
+	defer func(c Config) { config = c }(config)
+	config.MultiTenant = true
+	db.Exec("DELETE FROM sites")
+	_, oldKey, _ := createSite(context.Background(), Site{Slug: "blog"})
+
+	rec := postForm(rotateKeyHandler, "/admin/sites/rotate-key", url.Values{"slug": {"blog"}})
+	var rotated map[string]string
+	json.NewDecoder(rec.Body).Decode(&rotated)
+	if rec.Code != 200 || rotated["api_key"] == "" || rotated["api_key"] == oldKey {
+		t.Fatalf("Rotate = %d %v", rec.Code, rotated)
+	}
+	if _, err := lookupSite(context.Background(), oldKey, ""); err != errUnknownSite {
+		t.Errorf("Old key still works: %v", err)
+	}
+	if rec := postForm(rotateKeyHandler, "/admin/sites/rotate-key", url.Values{"slug": {"nope"}}); rec.Code != 404 {
+		t.Errorf("Rotate unknown site: status %d, want 404", rec.Code)
+	}
+
+	rec = postForm(archiveSiteHandler, "/admin/sites/archive", url.Values{"slug": {"blog"}})
+	if rec.Code != 200 {
+		t.Fatalf("Archive status = %d", rec.Code)
+	}
+	form := url.Values{"name": {"Ann"}, "email": {"ann@example.com"}, "comment": {"hi"}}
+	if rec := postForm(requireSite(commentsHandler), "/comments?site=blog", form); rec.Code != http.StatusGone {
+		t.Errorf("Posting to archived site: status %d, want 410", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	requireSite(allCommentsHandler)(rec, httptest.NewRequest("GET", "/all?site=blog", nil))
+	if rec.Code != 200 {
+		t.Errorf("Reading archived site: status %d, want 200", rec.Code)
+	}
+}
+
+func TestCloseSite(t *testing.T) {
+	needSQLite(t)
+	defer func(c Config) { config = c }(config)
+	config.MultiTenant = true
+	db.Exec("DELETE FROM sites")
+	createSite(context.Background(), Site{Slug: "blog"})
+	created, _, err := createSite(context.Background(), Site{Slug: "shop", ClosedAfter: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)})
+	if err != nil || !created.ClosedAfter.Equal(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("createSite() = %+v, %v", created, err)
+	}
+	if site, _ := lookupSite(context.Background(), "", "shop"); !site.ClosedAfter.Equal(created.ClosedAfter) {
+		t.Errorf("Stored closed_after = %v, want %v", site.ClosedAfter, created.ClosedAfter)
+	}
+
+	form := url.Values{"name": {"Ann"}, "email": {"ann@example.com"}, "comment": {"hi"}}
+	rec := postForm(closeSiteHandler, "/admin/sites/close", url.Values{"slug": {"blog"}})
+	var closed Site
+	json.NewDecoder(rec.Body).Decode(&closed)
+	if rec.Code != 200 || closed.ClosedAfter.IsZero() || closed.ClosedAfter.After(time.Now()) {
+		t.Fatalf("Close = %d %+v", rec.Code, closed)
+	}
+	if rec := postForm(requireSite(commentsHandler), "/comments?site=blog", form); rec.Code != http.StatusForbidden || rec.Header().Get("X-Error-Code") != codeCommentsClosed {
+		t.Errorf("Posting to a closed site: status %d, want 403", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	requireSite(allCommentsHandler)(rec, httptest.NewRequest("GET", "/all?site=blog", nil))
+	if rec.Code != 200 {
+		t.Errorf("Reading a closed site: status %d, want 200", rec.Code)
+	}
+
+	if rec := postForm(closeSiteHandler, "/admin/sites/close", url.Values{"slug": {"blog"}, "closed_after": {""}}); rec.Code != 200 {
+		t.Fatalf("Reopen status = %d", rec.Code)
+	}
+	if rec := postForm(requireSite(commentsHandler), "/comments?site=blog", form); rec.Code != http.StatusCreated {
+		t.Errorf("Posting to a reopened site: status %d, want 201: %s", rec.Code, rec.Body.String())
+	}
+	for _, tt := range []struct {
+		form url.Values
+		code int
+	}{
+		{url.Values{"slug": {"nope"}}, 404},
+		{url.Values{"slug": {"blog"}, "closed_after": {"soon"}}, 400},
+	} {
+		if rec := postForm(closeSiteHandler, "/admin/sites/close", tt.form); rec.Code != tt.code {
+			t.Errorf("Close %v: status %d, want %d", tt.form, rec.Code, tt.code)
+		}
+	}
+}
+
+func TestSiteAllowedOrigins(t *testing.T) {
+	needSQLite(t)
+	defer func(c Config) { config = c }(config)
+	config.MultiTenant = true
+	db.Exec("DELETE FROM sites")
+	createSite(context.Background(), Site{Slug: "blog", AllowedOrigins: []string{"https://blog.example.com"}})
+
+	tests := []struct {
+		origin string
+		code   int
+		cors   string
+	}{
+		{"", 200, ""},
+		{"https://blog.example.com", 200, "https://blog.example.com"},
+		{"https://evil.example.com", 403, ""},
+		// the guestbook's own page or embed, httptest requests go to example.com
+		{"http://example.com", 200, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/all?site=blog", nil)
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		rec := httptest.NewRecorder()
+		requireSite(allCommentsHandler)(rec, req)
+		if rec.Code != tt.code || rec.Header().Get("Access-Control-Allow-Origin") != tt.cors {
+			t.Errorf("Origin %q: status %d, CORS %q", tt.origin, rec.Code, rec.Header().Get("Access-Control-Allow-Origin"))
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Every new comment goes through the spam checks. Each one scores it
+// between 0 and 1 and is weighted by spam_weights; when the sum reaches
+// spam_moderate_score the comment waits for moderation even on a site
+// that publishes right away, and at spam_reject_score it's refused. The
+// score and the reasons for it are stored with the comment for
+// moderators.
+
+// spamCheck is one of the checks. run returns 0 for a comment that
+// doesn't look like spam at all, up to 1 for one that certainly is, and
+// why if it's above 0.
+type spamCheck struct {
+	name string
+	run  func(ctx context.Context, c Config, in commentInput) (float64, string, error)
+}
+
+// spamChecks run in this order. Their names are the keys of spam_weights.
+var spamChecks = []spamCheck{
+	{"honeypot", honeypotSpamCheck},
+	{"rate", rateSpamCheck},
+	{"words", wordsSpamCheck},
+	{"links", linksSpamCheck},
+	{"bayes", bayesSpamCheck},
+	{"dnsbl", dnsblSpamCheck},
+}
+
+// spamRateWindow is the window spam_rate_limit counts comments in.
+const spamRateWindow = 10 * time.Minute
+
+// spamVerdict is what the checks make of a comment.
+type spamVerdict struct {
+	Score   float64
+	Reasons []string
+}
+
+// scoreSpam runs every check with a weight over in. A check that fails
+// is logged and left out, so a Redis hiccup doesn't hold up comments.
+func scoreSpam(ctx context.Context, log *slog.Logger, c Config, in commentInput) spamVerdict {
+	var v spamVerdict
+	for _, check := range spamChecks {
+		weight := c.SpamWeights[check.name]
+		if weight <= 0 {
+			continue
+		}
+		score, reason, err := check.run(ctx, c, in)
+		if err != nil {
+			log.Warn("Spam check failed", "check", check.name, "error", err)
+			continue
+		}
+		if score <= 0 {
+			continue
+		}
+		points := weight * min(score, 1)
+		v.Score += points
+		v.Reasons = append(v.Reasons, fmt.Sprintf("%s: %s (+%s)", check.name, reason, strconv.FormatFloat(points, 'f', -1, 64)))
+	}
+	return v
+}
+
+// honeypotSpamCheck catches bots that fill in the form's hidden
+// homepage field, which people never see.
+func honeypotSpamCheck(_ context.Context, _ Config, in commentInput) (float64, string, error) {
+	if in.Honeypot == "" {
+		return 0, "", nil
+	}
+	return 1, "hidden field filled in", nil
+}
+
+// rateSpamCheck counts the comments from in's IP, and flags the ones
+// beyond spam_rate_limit in spamRateWindow.
+func rateSpamCheck(ctx context.Context, c Config, in commentInput) (float64, string, error) {
+	if c.SpamRateLimit == 0 || in.IP == "" {
+		return 0, "", nil
+	}
+	n, err := shared.Incr(ctx, "spam-rate:"+in.IP, spamRateWindow)
+	if err != nil || n <= int64(c.SpamRateLimit) {
+		return 0, "", err
+	}
+	return 1, fmt.Sprintf("%d comments from the IP in %d minutes", n, int(spamRateWindow.Minutes())), nil
+}
+
+// wordsSpamCheck looks for spam_words in the name and text, ignoring
+// case. The reason names the first few it found.
+func wordsSpamCheck(_ context.Context, c Config, in commentInput) (float64, string, error) {
+	text := strings.ToLower(in.Name + "\n" + in.Text)
+	var found []string
+	for _, w := range c.SpamWords {
+		if len(found) == 5 {
+			break
+		}
+		if w != "" && strings.Contains(text, strings.ToLower(w)) && !slices.Contains(found, w) {
+			found = append(found, w)
+		}
+	}
+	if len(found) == 0 {
+		return 0, "", nil
+	}
+	return 1, strings.Join(found, ", "), nil
+}
+
+// linksSpamCheck flags comments with more than spam_max_links links.
+func linksSpamCheck(_ context.Context, c Config, in commentInput) (float64, string, error) {
+	n := len(urlPattern.FindAllString(in.Text, -1))
+	if n <= c.SpamMaxLinks {
+		return 0, "", nil
+	}
+	return 1, fmt.Sprintf("%d links", n), nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestScoreSpam(t *testing.T) {
+	defer func(s SharedState) { shared = s }(shared)
+	shared = newMemoryState()
+	c := defaultConfig()
+	c.SpamWords, c.SpamRateLimit = []string{"Casino", "pills"}, 2
+	log := slog.New(slog.DiscardHandler)
+
+	tests := []struct {
+		in      commentInput
+		score   float64
+		reasons []string
+	}{
+		{commentInput{Name: "Ann", Text: "Lovely site", IP: "192.0.2.1"}, 0, nil},
+		{commentInput{Name: "Bot", Text: "Hi", IP: "192.0.2.1", Honeypot: "http://spam.example"}, 5, []string{"honeypot: hidden field filled in (+5)"}},
+		{commentInput{Name: "Bob", Text: "Best CASINO and pills and casino", IP: "192.0.2.2"}, 2, []string{"words: Casino, pills (+2)"}},
+		{commentInput{Name: "Cat", Text: "https://a.example https://b.example http://c.example https://a.example", IP: "192.0.2.3"}, 1, []string{"links: 4 links (+1)"}},
+		// the third comment from 192.0.2.1
+		{commentInput{Name: "Ann", Text: "Me again", IP: "192.0.2.1"}, 1, []string{"rate: 3 comments from the IP in 10 minutes (+1)"}},
+	}
+	for _, tt := range tests {
+		v := scoreSpam(t.Context(), log, c, tt.in)
+		if v.Score != tt.score || strings.Join(v.Reasons, "|") != strings.Join(tt.reasons, "|") {
+			t.Errorf("scoreSpam(%+v) = %+v, want %v %q", tt.in, v, tt.score, tt.reasons)
+		}
+	}
+
+	// a weight of 0 turns a check off
+	c.SpamWeights = map[string]float64{"links": 0.5}
+	if v := scoreSpam(t.Context(), log, c, commentInput{Text: strings.Repeat("http://x.example ", 5), Honeypot: "x"}); v.Score != 0.5 || len(v.Reasons) != 1 {
+		t.Errorf("Only links weighted: %+v", v)
+	}
+}
+
+func TestSubmitCommentSpam(t *testing.T) {
+	defer func(c Config, s CommentStore, sh SharedState) { config, store, shared = c, s, sh }(config, store, shared)
+	store, shared = newMemoryStore(), newMemoryState()
+	config.SpamWeights, config.SpamModerateScore, config.SpamRejectScore = map[string]float64{"honeypot": 5, "links": 1}, 1, 5
+	config.SpamMaxLinks = 1
+	site := &Site{Slug: "open", Moderation: "approved"}
+	log := slog.New(slog.DiscardHandler)
+
+	c, _, err := submitComment(t.Context(), log, site, commentInput{Name: "Ann", Email: "ann@example.com", Text: "See https://a.example and https://b.example", IP: "192.0.2.1"})
+	if err != nil || c.Status != "pending" || c.SpamScore != 1 || c.SpamReasons != "links: 2 links (+1)" {
+		t.Fatalf("Comment with links = %+v, %v", c, err)
+	}
+	if got, err := store.Get(t.Context(), 0, c.ID); err != nil || got.SpamScore != 1 || got.SpamReasons != c.SpamReasons {
+		t.Errorf("Stored comment = %+v, %v", got, err)
+	}
+
+	_, _, err = submitComment(t.Context(), log, site, commentInput{Name: "Bot", Email: "bot@example.com", Text: "Hi", IP: "192.0.2.2", Honeypot: "x"})
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) || apiErr.code != codeSpamRejected {
+		t.Errorf("Honeypot comment: %v, want %s", err, codeSpamRejected)
+	}
+
+	c, _, err = submitComment(t.Context(), log, site, commentInput{Name: "Bob", Email: "bob@example.com", Text: "Hello", IP: "192.0.2.3"})
+	if err != nil || c.Status != "approved" || c.SpamScore != 0 {
+		t.Errorf("Clean comment = %+v, %v", c, err)
+	}
+}
@@ -0,0 +1,79 @@
+//go:build cgo
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteAvailable is false in builds without cgo, which can't include the
+// sqlite3 driver.
+const sqliteAvailable = true
+
+func isSQLiteUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) &&
+		(sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+}
+
+// sqliteBackup copies the open database to path with SQLite's online backup
+// API. It copies a few pages at a time so writers are only held up briefly.
+func sqliteBackup(ctx context.Context, src *sql.DB, path string) error {
+	dest, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return destConn.Raw(func(d any) error {
+		return srcConn.Raw(func(s any) error {
+			destSQLite, ok1 := unwrapConn(d).(*sqlite3.SQLiteConn)
+			srcSQLite, ok2 := unwrapConn(s).(*sqlite3.SQLiteConn)
+			if !ok1 || !ok2 {
+				return errors.New("backups need the sqlite3 driver")
+			}
+			b, err := destSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return err
+			}
+			for {
+				done, err := b.Step(256)
+				if err != nil {
+					b.Finish()
+					return err
+				}
+				if done {
+					return b.Finish()
+				}
+				if err := ctx.Err(); err != nil {
+					b.Finish()
+					return err
+				}
+			}
+		})
+	})
+}
+
+// unwrapConn digs the driver's connection out of otelsql's wrapper.
+func unwrapConn(c any) any {
+	if w, ok := c.(interface{ Raw() driver.Conn }); ok {
+		return w.Raw()
+	}
+	return c
+}
@@ -0,0 +1,19 @@
+//go:build !cgo
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+const sqliteAvailable = false
+
+func isSQLiteUniqueViolation(err error) bool {
+	return false
+}
+
+func sqliteBackup(ctx context.Context, src *sql.DB, path string) error {
+	return errors.New("SQLite isn't available in builds without cgo")
+}
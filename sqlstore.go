@@ -0,0 +1,720 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sqlStore keeps comments in SQLite or MySQL.
+type sqlStore struct {
+	db *sql.DB
+
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
+
+	// bans may be changed by other instances sharing the database
+	bans banCache
+}
+
+// stmt returns query prepared on the pool, preparing it on first use. Only
+// queries built from a fixed set of pieces come through here, with values
+// as parameters, so the map stays small.
+func (s *sqlStore) stmt(ctx context.Context, query string) (*sql.Stmt, error) {
+	s.mu.Lock()
+	st, ok := s.stmts[query]
+	s.mu.Unlock()
+	if ok {
+		return st, nil
+	}
+
+	// prepared without the lock, it waits for a free connection
+	st, err := s.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if prev, ok := s.stmts[query]; ok {
+		st.Close()
+		return prev, nil
+	}
+	if s.stmts == nil {
+		s.stmts = map[string]*sql.Stmt{}
+	}
+	s.stmts[query] = st
+	return st, nil
+}
+
+func (s *sqlStore) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	st, err := s.stmt(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return st.ExecContext(ctx, args...)
+}
+
+func (s *sqlStore) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	st, err := s.stmt(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return st.QueryContext(ctx, args...)
+}
+
+func (s *sqlStore) queryRow(ctx context.Context, query string, args ...any) rowScanner {
+	st, err := s.stmt(ctx, query)
+	if err != nil {
+		return errRow{err}
+	}
+	return st.QueryRowContext(ctx, args...)
+}
+
+type rowScanner interface{ Scan(...any) error }
+
+// errRow is a row that failed before the query ran.
+type errRow struct{ err error }
+
+func (r errRow) Scan(...any) error { return r.err }
+
+// prepareStatements prepares the queries every page view and new comment
+// runs, so a broken schema shows up at startup rather than on the first
+// request. Call it once the schema is in place.
+func (s *sqlStore) prepareStatements(ctx context.Context) error {
+	public := CommentQuery{Status: "approved", Limit: 1}
+	for _, query := range []string{
+		insertCommentQuery, getCommentQuery, likesQuery, addLikeQuery(), countLikeQuery, blocklistQuery,
+		public.listQuery(), public.versionQuery(),
+	} {
+		if _, err := s.stmt(ctx, query); err != nil {
+			return fmt.Errorf("preparing %q: %w", query, err)
+		}
+	}
+	return nil
+}
+
+// Close releases the prepared statements. The pool stays open.
+func (s *sqlStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, st := range s.stmts {
+		st.Close()
+	}
+	s.stmts = nil
+	return nil
+}
+
+// sqliteDSN adds the pragmas from the config to db_path. The driver runs
+// them on every new connection, which matters since they're per connection.
+func sqliteDSN(c Config) string {
+	params := url.Values{}
+	params.Set("_busy_timeout", strconv.Itoa(c.SQLiteBusyTimeout))
+	if c.SQLiteJournalMode != "" {
+		params.Set("_journal_mode", strings.ToUpper(c.SQLiteJournalMode))
+	}
+	if c.SQLiteForeignKeys {
+		params.Set("_foreign_keys", "1")
+	}
+	// take the write lock up front so a transaction that reads first can't
+	// fail with "database is locked" when it gets to writing
+	params.Set("_txlock", "immediate")
+
+	sep := "?"
+	if strings.Contains(c.DBPath, "?") {
+		sep = "&"
+	}
+	return c.DBPath + sep + params.Encode()
+}
+
+// maxOpenConns returns db_max_open_conns or picks a pool size: enough
+// SQLite connections for readers to run alongside the one writer WAL
+// allows, and well under MySQL's default connection limit.
+func maxOpenConns(c Config) int {
+	if c.DBMaxOpenConns > 0 {
+		return c.DBMaxOpenConns
+	}
+	if c.DBDriver == "mysql" {
+		return 25
+	}
+	return max(4, runtime.NumCPU())
+}
+
+// configurePool applies the pool settings. Idle connections default to
+// the pool size, so a burst of requests doesn't reconnect afterwards.
+func configurePool(db *sql.DB, c Config) {
+	n := maxOpenConns(c)
+	idle := n
+	if c.DBMaxIdleConns > 0 {
+		idle = min(c.DBMaxIdleConns, n)
+	}
+	db.SetMaxOpenConns(n)
+	db.SetMaxIdleConns(idle)
+	db.SetConnMaxLifetime(time.Duration(c.DBConnMaxLifetime) * time.Second)
+}
+
+const sqlTimeFormat = "2006-01-02 15:04:05"
+
+// sqlUpdatedFormat keeps microseconds so two changes in the same second get
+// different ETags.
+const sqlUpdatedFormat = "2006-01-02 15:04:05.000000"
+
+const commentColumns = "id, name, email, text, ip, location, likes, created, parent_id, site_id, status, consent_version, updated, website, authenticated, avatar, provider, image, returning_commenter, verified, spam_score, spam_reasons"
+
+func scanComment(row rowScanner, extra ...any) (Comment, error) {
+	var c Comment
+	var created string
+	var updated sql.NullString
+	dest := append([]any{&c.ID, &c.Name, &c.Email, &c.Text, &c.IP, &c.Location, &c.Likes, &created, &c.ParentID, &c.SiteID, &c.Status, &c.ConsentVersion, &updated, &c.Website, &c.Authenticated, &c.Avatar, &c.Provider, &c.Image, &c.Returning, &c.Verified, &c.SpamScore, &c.SpamReasons}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return c, err
+	}
+	c.Created = parseSQLTime(created)
+	c.Updated = c.Created
+	if updated.Valid {
+		c.Updated = parseSQLTime(updated.String)
+	}
+	return c, nil
+}
+
+// parseSQLTime reads a DATETIME column. The sqlite3 driver hands them back
+// as RFC 3339, MySQL in its own format.
+func parseSQLTime(v string) time.Time {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t
+	}
+	t, _ := time.Parse(sqlTimeFormat, v)
+	return t
+}
+
+func (s *sqlStore) Create(ctx context.Context, c *Comment) error {
+	c.setDefaults()
+	res, err := s.exec(ctx, insertCommentQuery, insertArgs(c)...)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	c.ID = int(id)
+	return err
+}
+
+const (
+	insertColumns = "name, email, text, ip, location, consent_version, site_id, status, created, parent_id, likes, updated, website, authenticated, avatar, provider, image, returning_commenter, verified, spam_score, spam_reasons"
+	insertParams  = "?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?"
+
+	insertCommentQuery       = "INSERT INTO comments (" + insertColumns + ") VALUES (" + insertParams + ")"
+	insertCommentWithIDQuery = "INSERT INTO comments (id, " + insertColumns + ") VALUES (?, " + insertParams + ")"
+	getCommentQuery          = "SELECT " + commentColumns + " FROM comments WHERE id = ? AND site_id = ?"
+	likesQuery               = "SELECT likes FROM comments WHERE id = ? AND site_id = ?"
+	countLikeQuery           = "UPDATE comments SET likes = likes + 1, updated = ? WHERE id = ?"
+	blocklistQuery           = "SELECT ip, COALESCE(reason, ''), hits, created, updated, expires FROM blocklist WHERE expires IS NULL OR expires > ? ORDER BY updated DESC, ip"
+)
+
+func addLikeQuery() string {
+	return insertIgnore() + " INTO likes (comment_id, ip) VALUES (?, ?)"
+}
+
+func insertArgs(c *Comment) []any {
+	return []any{c.Name, c.Email, c.Text, c.IP, c.Location, c.ConsentVersion, c.SiteID, c.Status, c.Created.UTC().Format(sqlTimeFormat), c.ParentID, c.Likes, c.Updated.UTC().Format(sqlUpdatedFormat), c.Website, c.Authenticated, c.Avatar, c.Provider, c.Image, c.Returning, c.Verified, c.SpamScore, c.SpamReasons}
+}
+
+// CreateMany inserts comments in one transaction through two prepared
+// statements, one for comments with an ID and one for the rest.
+func (s *sqlStore) CreateMany(ctx context.Context, comments []*Comment) error {
+	// prepared before the transaction takes a connection, the pool may
+	// only have the one
+	insert, err := s.stmt(ctx, insertCommentQuery)
+	if err != nil {
+		return err
+	}
+	insertWithID, err := s.stmt(ctx, insertCommentWithIDQuery)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	insert = tx.StmtContext(ctx, insert)
+	defer insert.Close()
+	insertWithID = tx.StmtContext(ctx, insertWithID)
+	defer insertWithID.Close()
+
+	for _, c := range comments {
+		c.setDefaults()
+		if c.ID != 0 {
+			_, err = insertWithID.ExecContext(ctx, append([]any{c.ID}, insertArgs(c)...)...)
+			if isUniqueViolation(err) {
+				return fmt.Errorf("%w: %d", errDuplicateID, c.ID)
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		res, err := insert.ExecContext(ctx, insertArgs(c)...)
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		c.ID = int(id)
+	}
+	return tx.Commit()
+}
+
+// where translates q into a WHERE clause and its arguments.
+func (q CommentQuery) where() (string, []any) {
+	conds := []string{"site_id = ?"}
+	args := []any{q.SiteID}
+	if q.Status != "" {
+		conds = append(conds, "status = ?")
+		args = append(args, q.Status)
+	}
+	if !q.Since.IsZero() {
+		conds = append(conds, "created >= ?")
+		args = append(args, q.Since.UTC().Format(sqlTimeFormat))
+	}
+	if !q.Until.IsZero() {
+		conds = append(conds, "created <= ?")
+		args = append(args, q.Until.UTC().Format(sqlTimeFormat))
+	}
+	if !q.Before.IsZero() {
+		conds = append(conds, "created < ?")
+		args = append(args, q.Before.UTC().Format(sqlTimeFormat))
+	}
+	if q.AfterID > 0 {
+		conds = append(conds, "id > ?")
+		args = append(args, q.AfterID)
+	}
+	for _, p := range []struct {
+		value string
+		cond  string
+	}{
+		{q.Name, "LOWER(name) = LOWER(?)"},
+		{q.Email, "LOWER(email) = LOWER(?)"},
+		{q.IP, "ip = ?"},
+	} {
+		if p.value != "" {
+			conds = append(conds, p.cond)
+			args = append(args, p.value)
+		}
+	}
+	return "WHERE " + strings.Join(conds, " AND "), args
+}
+
+// table is where the comments q selects live.
+func (q CommentQuery) table() string {
+	if q.Archived {
+		return "comments_archive"
+	}
+	return "comments"
+}
+
+// orderBy maps CommentQuery.Sort to an ORDER BY expression.
+func (q CommentQuery) orderBy() string {
+	switch q.Sort {
+	case "oldest":
+		return "created ASC, id ASC"
+	case "popular":
+		return "likes DESC, created DESC, id DESC"
+	}
+	return "created DESC, id DESC"
+}
+
+// listQuery selects the comments matching q, with the arguments of where
+// followed by the limit and offset if there are any.
+func (q CommentQuery) listQuery() string {
+	where, _ := q.where()
+	query := "SELECT " + commentColumns + " FROM " + q.table() + " " + where + " ORDER BY " + q.orderBy()
+	if q.Limit > 0 {
+		query += " LIMIT ?"
+		if q.Offset > 0 {
+			query += " OFFSET ?"
+		}
+	}
+	return query
+}
+
+func (q CommentQuery) versionQuery() string {
+	where, _ := q.where()
+	return "SELECT COUNT(*), COALESCE(MAX(id), 0), MAX(COALESCE(updated, created)) FROM " + q.table() + " " + where
+}
+
+func (s *sqlStore) List(ctx context.Context, q CommentQuery) ([]Comment, error) {
+	var comments []Comment
+	err := s.Each(ctx, q, func(c Comment) error {
+		comments = append(comments, c)
+		return nil
+	})
+	return comments, err
+}
+
+func (s *sqlStore) Each(ctx context.Context, q CommentQuery, fn func(Comment) error) error {
+	_, args := q.where()
+	if q.Limit > 0 {
+		args = append(args, q.Limit)
+		if q.Offset > 0 {
+			args = append(args, q.Offset)
+		}
+	}
+	rows, err := s.query(ctx, q.listQuery(), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *sqlStore) Get(ctx context.Context, siteID, id int) (*Comment, error) {
+	c, err := scanComment(s.queryRow(ctx, getCommentQuery, id, siteID))
+	if err == sql.ErrNoRows {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s *sqlStore) Update(ctx context.Context, c *Comment) error {
+	res, err := s.exec(ctx,
+		"UPDATE comments SET name = ?, email = ?, text = ?, location = ?, status = ?, spam_score = ?, spam_reasons = ?, updated = ? WHERE id = ? AND site_id = ?",
+		c.Name, c.Email, c.Text, c.Location, c.Status, c.SpamScore, c.SpamReasons, time.Now().UTC().Format(sqlUpdatedFormat), c.ID, c.SiteID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// MySQL counts only changed rows, so check the comment exists
+		if _, err := s.Get(ctx, c.SiteID, c.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) Delete(ctx context.Context, siteID, id int) error {
+	res, err := s.exec(ctx, "DELETE FROM comments WHERE id = ? AND site_id = ?", id, siteID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errNotFound
+	}
+	_, err = s.exec(ctx, "DELETE FROM likes WHERE comment_id = ?", id)
+	return err
+}
+
+// Archive copies the comments into comments_archive and deletes them from
+// comments in one transaction, which also takes them out of the search
+// index.
+func (s *sqlStore) Archive(ctx context.Context, siteID int, before time.Time) (int, error) {
+	cutoff := before.UTC().Format(sqlTimeFormat)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		"INSERT INTO comments_archive (" + commentColumns + ") SELECT " + commentColumns + " FROM comments WHERE site_id = ? AND created < ?",
+		"DELETE FROM likes WHERE comment_id IN (SELECT id FROM comments WHERE site_id = ? AND created < ?)",
+	} {
+		if _, err := tx.ExecContext(ctx, query, siteID, cutoff); err != nil {
+			return 0, err
+		}
+	}
+	res, err := tx.ExecContext(ctx, "DELETE FROM comments WHERE site_id = ? AND created < ?", siteID, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), tx.Commit()
+}
+
+func (s *sqlStore) Count(ctx context.Context, q CommentQuery) (int, error) {
+	where, args := q.where()
+	var n int
+	err := s.queryRow(ctx, "SELECT COUNT(*) FROM "+q.table()+" "+where, args...).Scan(&n)
+	return n, err
+}
+
+func (s *sqlStore) Search(ctx context.Context, siteID int, q string, limit int) ([]SearchResult, error) {
+	match := ftsQuery(q)
+	args := []any{match, siteID}
+	var query string
+	if usingMySQL() {
+		// the text is selected in place of the snippet, which is cut below
+		match = mysqlMatchQuery(q)
+		args = []any{match, siteID, match}
+		query = `
+			SELECT c.id, c.name, c.email, c.text, c.ip, c.location, c.likes, c.created, c.parent_id, c.site_id, c.status, c.consent_version, c.updated, c.website, c.authenticated, c.avatar, c.provider, c.image, c.returning_commenter, c.verified, c.spam_score, c.spam_reasons, c.text
+			FROM comments c
+			WHERE MATCH(c.name, c.text) AGAINST (? IN BOOLEAN MODE) AND c.site_id = ? AND c.status = 'approved'
+			ORDER BY MATCH(c.name, c.text) AGAINST (? IN BOOLEAN MODE) DESC
+		`
+	} else if ftsVersion == "fts5" {
+		query = `
+			SELECT c.id, c.name, c.email, c.text, c.ip, c.location, c.likes, c.created, c.parent_id, c.site_id, c.status, c.consent_version, c.updated, c.website, c.authenticated, c.avatar, c.provider, c.image, c.returning_commenter, c.verified, c.spam_score, c.spam_reasons,
+				snippet(comments_fts, -1, '` + snippetMarkStart + `', '` + snippetMarkEnd + `', '…', 12)
+			FROM comments_fts
+			JOIN comments c ON c.id = comments_fts.rowid
+			WHERE comments_fts MATCH ? AND c.site_id = ? AND c.status = 'approved'
+			ORDER BY rank
+		`
+	} else {
+		// fts4 has no built-in ranking, newest matches first is the next best thing
+		query = `
+			SELECT c.id, c.name, c.email, c.text, c.ip, c.location, c.likes, c.created, c.parent_id, c.site_id, c.status, c.consent_version, c.updated, c.website, c.authenticated, c.avatar, c.provider, c.image, c.returning_commenter, c.verified, c.spam_score, c.spam_reasons,
+				snippet(comments_fts, '` + snippetMarkStart + `', '` + snippetMarkEnd + `', '…', -1, 12)
+			FROM comments_fts
+			JOIN comments c ON c.id = comments_fts.docid
+			WHERE comments_fts MATCH ? AND c.site_id = ? AND c.status = 'approved'
+			ORDER BY c.created DESC
+		`
+	}
+	query += fmt.Sprintf(" LIMIT %d", limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var res SearchResult
+		if res.Comment, err = scanComment(rows, &res.Snippet); err != nil {
+			return nil, err
+		}
+		if usingMySQL() {
+			res.Snippet = makeSnippet(res.Snippet, strings.Fields(strings.ReplaceAll(q, `"`, " ")), 12)
+		} else {
+			res.Snippet = markSnippet(res.Snippet)
+		}
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
+
+func (s *sqlStore) Like(ctx context.Context, siteID, id int, ip string) (int, error) {
+	var likes int
+	err := s.queryRow(ctx, likesQuery, id, siteID).Scan(&likes)
+	if err == sql.ErrNoRows {
+		return 0, errNotFound
+	} else if err != nil {
+		return 0, err
+	}
+
+	res, err := s.exec(ctx, addLikeQuery(), id, ip)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		if _, err := s.exec(ctx, countLikeQuery, time.Now().UTC().Format(sqlUpdatedFormat), id); err != nil {
+			return 0, err
+		}
+		likes++
+	}
+	return likes, nil
+}
+
+func (s *sqlStore) Version(ctx context.Context, q CommentQuery) (CommentVersion, error) {
+	var v CommentVersion
+	var modified sql.NullString
+	_, args := q.where()
+	err := s.queryRow(ctx, q.versionQuery(), args...).
+		Scan(&v.Count, &v.MaxID, &modified)
+	if modified.Valid {
+		v.Modified = parseSQLTime(modified.String)
+	}
+	return v, err
+}
+
+// Stats aggregates the comments of a site for the admin dashboard.
+// PerDay covers the 30 days up to and including now, with empty days as 0.
+func (s *sqlStore) Stats(ctx context.Context, siteID int, now time.Time) (*Stats, error) {
+	stats := &Stats{ByStatus: map[string]int{}}
+	for _, s := range commentStatuses {
+		stats.ByStatus[s] = 0
+	}
+
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*), COALESCE(SUM(likes), 0) FROM comments WHERE site_id = ?", siteID).Scan(&stats.Total, &stats.Likes)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT status, COUNT(*) FROM comments WHERE site_id = ? GROUP BY status", siteID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		stats.ByStatus[status] = n
+	}
+	rows.Close()
+	if stats.Total > 0 {
+		stats.ApprovedRatio = float64(stats.ByStatus["approved"]) / float64(stats.Total)
+		stats.SpamRatio = float64(stats.ByStatus["spam"]) / float64(stats.Total)
+	}
+
+	first := now.AddDate(0, 0, -29)
+	counts := map[string]int{}
+	rows, err = s.db.QueryContext(ctx,
+		"SELECT date(created) AS day, COUNT(*) FROM comments WHERE site_id = ? AND created >= ? GROUP BY day",
+		siteID, first.Format("2006-01-02"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var day string
+		var n int
+		if err := rows.Scan(&day, &n); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		counts[day] = n
+	}
+	rows.Close()
+	for d := 0; d < 30; d++ {
+		day := first.AddDate(0, 0, d).Format("2006-01-02")
+		stats.PerDay = append(stats.PerDay, DayCount{Date: day, Count: counts[day]})
+	}
+
+	if stats.TopNames, err = s.topCounts(ctx, siteID, "name"); err != nil {
+		return nil, err
+	}
+	if stats.TopIPs, err = s.topCounts(ctx, siteID, "ip"); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// topCounts returns the most frequent values of column, which must be a
+// trusted column name since it's spliced into the query.
+func (s *sqlStore) topCounts(ctx context.Context, siteID int, column string) ([]KeyCount, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+column+", COUNT(*) AS n FROM comments WHERE site_id = ? GROUP BY "+column+" ORDER BY n DESC, "+column+" LIMIT ?",
+		siteID, statsTopN,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	top := []KeyCount{}
+	for rows.Next() {
+		var kc KeyCount
+		if err := rows.Scan(&kc.Key, &kc.Count); err != nil {
+			return nil, err
+		}
+		top = append(top, kc)
+	}
+	return top, rows.Err()
+}
+
+func (s *sqlStore) BlockIP(ctx context.Context, ip, reason string, expires time.Time) error {
+	defer s.bans.invalidate()
+	upsert := `
+		INSERT INTO blocklist (ip, reason, expires) VALUES (?, ?, ?)
+		ON CONFLICT (ip) DO UPDATE SET hits = hits + 1, reason = excluded.reason, expires = excluded.expires, updated = CURRENT_TIMESTAMP
+	`
+	if usingMySQL() {
+		upsert = `
+			INSERT INTO blocklist (ip, reason, expires) VALUES (?, ?, ?)
+			ON DUPLICATE KEY UPDATE hits = hits + 1, reason = VALUES(reason), expires = VALUES(expires), updated = CURRENT_TIMESTAMP
+		`
+	}
+	var until sql.NullString
+	if !expires.IsZero() {
+		until = sql.NullString{String: expires.UTC().Format(sqlTimeFormat), Valid: true}
+	}
+	_, err := s.db.ExecContext(ctx, upsert, ip, reason, until)
+	return err
+}
+
+// IsBlocked looks ip up in the blocklist loaded into memory, which is
+// loaded again every banCacheMaxAge.
+func (s *sqlStore) IsBlocked(ctx context.Context, ip string) (bool, error) {
+	return s.bans.isBlocked(ip, banCacheMaxAge, func() ([]BlockedIP, error) { return s.Blocklist(ctx) })
+}
+
+func (s *sqlStore) UnblockIP(ctx context.Context, ip string) error {
+	defer s.bans.invalidate()
+	_, err := s.db.ExecContext(ctx, "DELETE FROM blocklist WHERE ip = ?", ip)
+	return err
+}
+
+func (s *sqlStore) Blocklist(ctx context.Context) ([]BlockedIP, error) {
+	rows, err := s.db.QueryContext(ctx, blocklistQuery, time.Now().UTC().Format(sqlTimeFormat))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blocked []BlockedIP
+	for rows.Next() {
+		var b BlockedIP
+		var created, updated string
+		var expires sql.NullString
+		if err := rows.Scan(&b.IP, &b.Reason, &b.Hits, &created, &updated, &expires); err != nil {
+			return nil, err
+		}
+		b.Created, b.Updated = parseSQLTime(created), parseSQLTime(updated)
+		if expires.Valid {
+			b.Expires = parseSQLTime(expires.String)
+		}
+		blocked = append(blocked, b)
+	}
+	return blocked, rows.Err()
+}
+
+func (s *sqlStore) RecordBotHit(ctx context.Context, hit BotHit) error {
+	_, err := s.exec(ctx,
+		"INSERT INTO bot_hits (ip, path, user_agent, fingerprint) VALUES (?, ?, ?, ?)",
+		hit.IP, hit.Path, hit.UserAgent, hit.Fingerprint,
+	)
+	return err
+}
+
+func (s *sqlStore) DeleteBotHits(ctx context.Context, before time.Time) (int, error) {
+	res, err := s.exec(ctx, "DELETE FROM bot_hits WHERE created < ?", before.UTC().Format(sqlTimeFormat))
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (s *sqlStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
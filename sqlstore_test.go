@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSQLiteDSN(t *testing.T) {
+	c := defaultConfig()
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   string
+	}{
+		{"defaults", func(c *Config) {}, "./guestbook.db?_busy_timeout=5000&_foreign_keys=1&_journal_mode=WAL&_txlock=immediate"},
+		{"no pragmas", func(c *Config) {
+			c.SQLiteJournalMode, c.SQLiteBusyTimeout, c.SQLiteForeignKeys = "", 0, false
+		}, "./guestbook.db?_busy_timeout=0&_txlock=immediate"},
+		{"path with params", func(c *Config) {
+			c.DBPath = "file:guestbook.db?mode=rwc"
+			c.SQLiteForeignKeys = false
+		}, "file:guestbook.db?mode=rwc&_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := c
+			tt.modify(&c)
+			if got := sqliteDSN(c); got != tt.want {
+				t.Errorf("sqliteDSN() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenDBPragmas(t *testing.T) {
+	needSQLite(t)
+	defer func(c Config) { config = c }(config)
+	config = defaultConfig()
+	config.DBPath = filepath.Join(t.TempDir(), "guestbook.db")
+
+	d, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	var journal string
+	var busy, fk int
+	if err := d.QueryRow("PRAGMA journal_mode").Scan(&journal); err != nil || journal != "wal" {
+		t.Errorf("journal_mode = %q, %v", journal, err)
+	}
+	if err := d.QueryRow("PRAGMA busy_timeout").Scan(&busy); err != nil || busy != 5000 {
+		t.Errorf("busy_timeout = %d, %v", busy, err)
+	}
+	if err := d.QueryRow("PRAGMA foreign_keys").Scan(&fk); err != nil || fk != 1 {
+		t.Errorf("foreign_keys = %d, %v", fk, err)
+	}
+	if n := d.Stats().MaxOpenConnections; n != maxOpenConns(config) {
+		t.Errorf("MaxOpenConnections = %d, want %d", n, maxOpenConns(config))
+	}
+
+	// readers and writers at once used to fail with "database is locked"
+	if _, err := d.Exec("CREATE TABLE t (n INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			tx, err := d.BeginTx(ctx, nil)
+			if err == nil {
+				var n int
+				tx.QueryRow("SELECT COUNT(*) FROM t").Scan(&n)
+				_, err = tx.Exec("INSERT INTO t VALUES (?)", n)
+				if err == nil {
+					err = tx.Commit()
+				} else {
+					tx.Rollback()
+				}
+			}
+			if err != nil {
+				errs <- fmt.Errorf("write: %w", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			var n int
+			if err := d.QueryRow("SELECT COUNT(*) FROM t").Scan(&n); err != nil {
+				errs <- fmt.Errorf("read: %w", err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestConfigurePool(t *testing.T) {
+	needSQLite(t)
+	tests := []struct {
+		name     string
+		open     int
+		idle     int
+		wantIdle int
+	}{
+		{"idle defaults to the pool size", 3, 0, 3},
+		{"fewer idle", 3, 1, 1},
+		{"idle capped at the pool size", 2, 5, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "guestbook.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+			configurePool(d, Config{DBMaxOpenConns: tt.open, DBMaxIdleConns: tt.idle, DBConnMaxLifetime: 60})
+
+			var conns []*sql.Conn
+			for range tt.open {
+				c, err := d.Conn(t.Context())
+				if err != nil {
+					t.Fatal(err)
+				}
+				conns = append(conns, c)
+			}
+			for _, c := range conns {
+				c.Close()
+			}
+			if s := d.Stats(); s.MaxOpenConnections != tt.open || s.Idle != tt.wantIdle {
+				t.Errorf("MaxOpenConnections = %d, Idle = %d; want %d, %d", s.MaxOpenConnections, s.Idle, tt.open, tt.wantIdle)
+			}
+		})
+	}
+}
+
+func TestPreparedStatements(t *testing.T) {
+	needSQLite(t)
+	withTempDB(t)
+	if _, err := migrateUp(t.Context(), 0); err != nil {
+		t.Fatal(err)
+	}
+	s := &sqlStore{db: db}
+	defer s.Close()
+	if err := s.prepareStatements(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	prepared := len(s.stmts)
+
+	ctx := t.Context()
+	c := &Comment{Name: "Ann", Text: "Hi", SiteID: 1}
+	if err := s.Create(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	for _, limit := range []int{1, 15, 50} {
+		q := CommentQuery{SiteID: 1, Status: "approved", Limit: limit}
+		if got, err := s.List(ctx, q); err != nil || len(got) != 1 {
+			t.Fatalf("List(limit %d) = %d comments, %v", limit, len(got), err)
+		}
+		if _, err := s.Version(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Get(ctx, 1, c.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Like(ctx, 1, c.ID, "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	// the limit is a parameter, so page views reuse what startup prepared
+	if len(s.stmts) != prepared {
+		t.Errorf("%d statements prepared after requests, want %d", len(s.stmts), prepared)
+	}
+
+	if _, err := db.Exec("DROP TABLE blocklist"); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&sqlStore{db: db}).prepareStatements(t.Context()); err == nil || !strings.Contains(err.Error(), "blocklist") {
+		t.Errorf("prepareStatements() without blocklist = %v", err)
+	}
+}
+
+func TestListingQueryPlans(t *testing.T) {
+	needSQLite(t)
+	withTempDB(t)
+	if _, err := migrateUp(t.Context(), 0); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		q     CommentQuery
+		index string
+	}{
+		{"Public listing", CommentQuery{SiteID: 1, Status: "approved", Limit: 15}, "comments_site_status_created"},
+		{"Oldest first", CommentQuery{SiteID: 1, Status: "approved", Sort: "oldest", Limit: 15}, "comments_site_status_created"},
+		{"Admin listing", CommentQuery{SiteID: 1, Limit: 15}, "comments_site_created"},
+		{"Duplicate check", CommentQuery{SiteID: 1, IP: "192.0.2.1", Since: time.Now(), Limit: 1}, "comments_site_ip_created"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, args := tt.q.where()
+			rows, err := db.Query("EXPLAIN QUERY PLAN "+tt.q.listQuery(), append(args, tt.q.Limit)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			var plan []string
+			for rows.Next() {
+				var id, parent, unused int
+				var detail string
+				if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+					t.Fatal(err)
+				}
+				plan = append(plan, detail)
+			}
+			got := strings.Join(plan, "; ")
+			if !strings.Contains(got, "INDEX "+tt.index) || strings.Contains(got, "TEMP B-TREE") {
+				t.Errorf("Query plan = %q, want %s without sorting", got, tt.index)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+// Adds comments to the guestbook page as they're posted, from the event
+// stream at /events. Without it the page still works, it just needs a
+// reload to show new comments.
+(function () {
+	var script = document.currentScript;
+	var list = document.getElementById("comments");
+	if (!script || !list || !window.EventSource) {
+		return;
+	}
+
+	function el(tag, text) {
+		var e = document.createElement(tag);
+		if (text) {
+			e.textContent = text;
+		}
+		return e;
+	}
+
+	// the same markup as the server renders in guestbook.html
+	function render(c) {
+		var article = el("article");
+		article.id = "comment-" + c.id;
+		var meta = el("div");
+		meta.className = "meta";
+		if (c.avatar) {
+			var avatar = el("img");
+			avatar.className = "avatar";
+			avatar.src = c.avatar;
+			avatar.alt = "";
+			avatar.width = avatar.height = 24;
+			meta.appendChild(avatar);
+			meta.appendChild(document.createTextNode(" "));
+		}
+		var name = el("b");
+		if (c.website) {
+			var link = el("a", c.name);
+			link.href = c.website;
+			link.rel = "nofollow noopener ugc";
+			name.appendChild(link);
+		} else {
+			name.textContent = c.name;
+		}
+		meta.appendChild(name);
+		if (c.authenticated || c.verified) {
+			var verified = el("span", "✓");
+			verified.className = "verified";
+			verified.title = c.authenticated ? "Signed in as " + (c.website || c.name) : "Verified email address";
+			meta.appendChild(document.createTextNode(" "));
+			meta.appendChild(verified);
+		}
+		if (c.location) {
+			meta.appendChild(document.createTextNode(" from " + c.location));
+		}
+		meta.appendChild(document.createTextNode(" · "));
+		var time = el("time", new Date(c.created).toLocaleDateString("en-GB", {
+			day: "numeric", month: "long", year: "numeric", timeZone: script.dataset.timezone
+		}));
+		time.dateTime = c.created;
+		meta.appendChild(time);
+		if (c.likes) {
+			meta.appendChild(document.createTextNode(" · " + c.likes + " ♥"));
+		}
+		article.appendChild(meta);
+		article.appendChild(el("p", c.text));
+		if (c.image) {
+			var image = el("img");
+			image.className = "attachment";
+			image.src = c.image;
+			image.alt = "";
+			image.loading = "lazy";
+			article.appendChild(image);
+		}
+		return article;
+	}
+
+	new EventSource(script.dataset.events).addEventListener("comment", function (e) {
+		var c = JSON.parse(e.data);
+		if (document.getElementById("comment-" + c.id)) {
+			return;
+		}
+		var empty = list.querySelector(":scope > p");
+		if (empty) {
+			empty.remove();
+		}
+		list.insertBefore(render(c), list.firstChild);
+	});
+})();
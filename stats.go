@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type Stats struct {
+	Total         int            `json:"total"`
+	Likes         int            `json:"likes"`
+	ByStatus      map[string]int `json:"by_status"`
+	ApprovedRatio float64        `json:"approved_ratio"`
+	SpamRatio     float64        `json:"spam_ratio"`
+	PerDay        []DayCount     `json:"per_day"`
+	TopNames      []KeyCount     `json:"top_names"`
+	TopIPs        []KeyCount     `json:"top_ips"`
+}
+
+type DayCount struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+type KeyCount struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+const statsTopN = 10
+
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	stats, err := store.Stats(r.Context(), siteFor(r).ID, time.Now().UTC())
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestComputeStats(t *testing.T) {
+	needSQLite(t)
+	_, err := db.Exec("DELETE FROM comments")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 3, 30, 15, 0, 0, 0, time.UTC)
+	testComments := []struct {
+		name    string
+		ip      string
+		status  string
+		likes   int
+		created string
+	}{
+		{"Alice", "1.1.1.1", "approved", 2, "2024-03-30 10:00:00"},
+		{"Alice", "1.1.1.1", "approved", 0, "2024-03-30 11:00:00"},
+		{"Bob", "2.2.2.2", "spam", 0, "2024-03-01 10:00:00"},
+		{"Carol", "1.1.1.1", "pending", 1, "2024-01-01 10:00:00"},
+	}
+	for _, c := range testComments {
+		_, err := db.Exec("INSERT INTO comments (name, email, text, ip, location, status, likes, created) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			c.name, "test@example.com", "Hi", c.ip, "Test Location", c.status, c.likes, c.created)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := store.Stats(context.Background(), 0, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Total != 4 || stats.Likes != 3 {
+		t.Errorf("Expected 4 comments and 3 likes, got %d and %d", stats.Total, stats.Likes)
+	}
+	if stats.ByStatus["approved"] != 2 || stats.ByStatus["spam"] != 1 || stats.ByStatus["pending"] != 1 {
+		t.Errorf("Unexpected status counts %v", stats.ByStatus)
+	}
+	if stats.ApprovedRatio != 0.5 || stats.SpamRatio != 0.25 {
+		t.Errorf("Unexpected ratios %v %v", stats.ApprovedRatio, stats.SpamRatio)
+	}
+
+	if len(stats.PerDay) != 30 {
+		t.Fatalf("Expected 30 days, got %d", len(stats.PerDay))
+	}
+	if first := stats.PerDay[0]; first.Date != "2024-03-01" || first.Count != 1 {
+		t.Errorf("Unexpected first day %+v", first)
+	}
+	if last := stats.PerDay[29]; last.Date != "2024-03-30" || last.Count != 2 {
+		t.Errorf("Unexpected last day %+v", last)
+	}
+
+	if len(stats.TopNames) != 3 || stats.TopNames[0] != (KeyCount{"Alice", 2}) {
+		t.Errorf("Unexpected top names %v", stats.TopNames)
+	}
+	if len(stats.TopIPs) != 2 || stats.TopIPs[0] != (KeyCount{"1.1.1.1", 3}) {
+		t.Errorf("Unexpected top IPs %v", stats.TopIPs)
+	}
+}
+
+func TestStatsHandlerRequiresAdmin(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.AdminToken = "secret"
+
+	tests := []struct {
+		name     string
+		token    string
+		expected int
+	}{
+		{"No token", "", 401},
+		{"Wrong token", "Bearer nope", 401},
+		{"Admin token", "Bearer secret", 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/admin/stats", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", tt.token)
+			}
+			recorder := httptest.NewRecorder()
+
+			requireAdmin(statsHandler)(recorder, req)
+
+			if recorder.Code != tt.expected {
+				t.Fatalf("Expected status %d, got %d", tt.expected, recorder.Code)
+			}
+			if tt.expected == 200 {
+				var stats Stats
+				if err := json.NewDecoder(recorder.Body).Decode(&stats); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// CommentStore is where comments live. Handlers only talk to the store, so
+// backends can be swapped without touching them.
+type CommentStore interface {
+	// Create inserts c and fills in its ID and Created time.
+	Create(ctx context.Context, c *Comment) error
+	// CreateMany inserts comments all at once or, on any error, not at all.
+	// Comments with an ID keep it, errDuplicateID if it's taken; the others
+	// get one after the highest ID so far.
+	CreateMany(ctx context.Context, comments []*Comment) error
+	List(ctx context.Context, q CommentQuery) ([]Comment, error)
+	// Each calls fn for every comment List would return, without holding
+	// them all in memory where the backend allows. An error from fn stops
+	// the iteration and is returned.
+	Each(ctx context.Context, q CommentQuery, fn func(Comment) error) error
+	// Get returns comment id of a site, or errNotFound.
+	Get(ctx context.Context, siteID, id int) (*Comment, error)
+	// Update saves the name, email, text, location, status and spam
+	// verdict of c. Likes only change through Like.
+	Update(ctx context.Context, c *Comment) error
+	Delete(ctx context.Context, siteID, id int) error
+	Count(ctx context.Context, q CommentQuery) (int, error)
+	// Search returns approved comments matching the words of query, best
+	// matches first, with <mark>ed snippets.
+	Search(ctx context.Context, siteID int, query string, limit int) ([]SearchResult, error)
+	// Like records a like from ip, once per IP, and returns the new count.
+	Like(ctx context.Context, siteID, id int, ip string) (int, error)
+	Stats(ctx context.Context, siteID int, now time.Time) (*Stats, error)
+	// Archive moves the comments of a site created before before out of
+	// the live comments into the archive, where only queries with Archived
+	// see them, and returns how many it moved. Their likes are kept as
+	// counts; Search and Stats only cover live comments.
+	Archive(ctx context.Context, siteID int, before time.Time) (int, error)
+
+	// BlockIP adds ip, an address or a network as banKey writes it, to
+	// the blocklist until expires, or for good if it's zero. If it's
+	// already there its hit count goes up and expires replaces the old
+	// expiry.
+	BlockIP(ctx context.Context, ip, reason string, expires time.Time) error
+	// UnblockIP removes ip from the blocklist. It's not an error if it
+	// isn't there.
+	UnblockIP(ctx context.Context, ip string) error
+	// IsBlocked reports whether ip is in a network on the blocklist whose
+	// ban hasn't expired.
+	IsBlocked(ctx context.Context, ip string) (bool, error)
+	// Blocklist returns every ban that hasn't expired, most recently
+	// blocked first.
+	Blocklist(ctx context.Context) ([]BlockedIP, error)
+	RecordBotHit(ctx context.Context, hit BotHit) error
+	// DeleteBotHits deletes the bot hits recorded before before.
+	DeleteBotHits(ctx context.Context, before time.Time) (int, error)
+
+	// Version sums up the comments q selects so that any change to them,
+	// including likes and moderation, changes it.
+	Version(ctx context.Context, q CommentQuery) (CommentVersion, error)
+
+	// Ping checks the backend is reachable, for /readyz.
+	Ping(ctx context.Context) error
+}
+
+// CommentQuery selects comments for List and Count. Zero fields match
+// everything.
+type CommentQuery struct {
+	SiteID int
+	Status string
+	// Since and Until bound the creation time, both inclusive, and Before
+	// bounds it exclusively.
+	Since  time.Time
+	Until  time.Time
+	Before time.Time
+	// AfterID selects comments with a higher ID, for following new ones.
+	AfterID int
+	// Name and Email match case-insensitively, IP exactly.
+	Name  string
+	Email string
+	IP    string
+	// Sort is newest (the default), oldest or popular.
+	Sort  string
+	Limit int
+	// Offset skips that many comments before Limit applies, for paging.
+	// It's ignored without a Limit.
+	Offset int
+	// Archived selects archived comments instead of the live ones.
+	Archived bool
+}
+
+// CommentVersion identifies the state of a set of comments without reading
+// them: adding or deleting one changes Count or MaxID, any other write
+// moves Modified.
+type CommentVersion struct {
+	Count    int
+	MaxID    int
+	Modified time.Time
+}
+
+// ETag formats v as a strong entity tag.
+func (v CommentVersion) ETag() string {
+	var modified int64
+	if !v.Modified.IsZero() {
+		modified = v.Modified.UnixMicro()
+	}
+	return fmt.Sprintf(`"%d-%d-%x"`, v.Count, v.MaxID, modified)
+}
+
+var errNotFound = errors.New("comment not found")
+
+var errDuplicateID = errors.New("comment ID already exists")
+
+// setDefaults fills in the status, creation and update time of a new
+// comment. Times are kept in UTC, creation times to the second like the
+// SQL backends store them, so every backend returns the same RFC 3339
+// timestamps.
+func (c *Comment) setDefaults() {
+	if c.Status == "" {
+		c.Status = "approved"
+	}
+	if c.Created.IsZero() {
+		c.Created = time.Now()
+	}
+	c.Created = c.Created.UTC().Truncate(time.Second)
+	if c.Updated.IsZero() {
+		c.Updated = c.Created
+	}
+	c.Updated = c.Updated.UTC()
+}
+
+// store is the backend every handler uses.
+var store CommentStore
+
+// commentRecord is a Comment with every field exported, for backends that
+// serialize comments and for exports. The fields must stay in the same
+// order as Comment's so the two convert into each other.
+type commentRecord struct {
+	ID             int       `json:"id" xml:"id"`
+	Name           string    `json:"name" xml:"name"`
+	Email          string    `json:"email" xml:"email"`
+	Text           string    `json:"text" xml:"text"`
+	IP             string    `json:"ip" xml:"ip"`
+	Location       string    `json:"location" xml:"location"`
+	Likes          int       `json:"likes" xml:"likes"`
+	Created        time.Time `json:"created" xml:"created"`
+	ParentID       int       `json:"parent_id" xml:"parent_id"`
+	Website        string    `json:"website" xml:"website"`
+	Authenticated  bool      `json:"authenticated" xml:"authenticated"`
+	Avatar         string    `json:"avatar" xml:"avatar"`
+	Provider       string    `json:"provider" xml:"provider"`
+	Image          string    `json:"image" xml:"image"`
+	Returning      bool      `json:"returning" xml:"returning"`
+	Verified       bool      `json:"verified" xml:"verified"`
+	SiteID         int       `json:"site_id" xml:"site_id"`
+	Status         string    `json:"status" xml:"status"`
+	ConsentVersion string    `json:"consent_version" xml:"consent_version"`
+	Updated        time.Time `json:"updated" xml:"updated"`
+	SpamScore      float64   `json:"spam_score" xml:"spam_score"`
+	SpamReasons    string    `json:"spam_reasons" xml:"spam_reasons"`
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// testStore runs the CommentStore contract against s, which must be empty.
+func testStore(t *testing.T, s CommentStore) {
+	ctx := context.Background()
+	day := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	comments := []*Comment{
+		{Name: "Alice", Email: "alice@example.com", Text: "Hello from the garden", IP: "1.1.1.1", Created: day, Website: "https://alice.example/", Authenticated: true},
+		{Name: "Bob", Email: "bob@example.com", Text: "Buy cheap watches", IP: "2.2.2.2", Status: "spam", Created: day.Add(time.Hour)},
+		{Name: "alice", Email: "alice@example.com", Text: "Another visit", IP: "1.1.1.1", Created: day.AddDate(0, 0, 1)},
+		// the same instant as day, sent with an offset and a fraction
+		{SiteID: 7, Name: "Carol", Email: "carol@example.com", Text: "Other site garden", IP: "3.3.3.3", Created: day.In(time.FixedZone("CEST", 2*3600)).Add(250 * time.Millisecond)},
+	}
+	for _, c := range comments {
+		if err := s.Create(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+		if c.ID == 0 {
+			t.Fatalf("Create() left ID unset for %q", c.Text)
+		}
+	}
+	if comments[0].Status != "approved" {
+		t.Errorf("Default status = %q, want approved", comments[0].Status)
+	}
+
+	lists := []struct {
+		name  string
+		query CommentQuery
+		want  []int
+	}{
+		{"Site comments newest first", CommentQuery{}, []int{2, 1, 0}},
+		{"Approved only", CommentQuery{Status: "approved"}, []int{2, 0}},
+		{"Oldest first with limit", CommentQuery{Sort: "oldest", Limit: 2}, []int{0, 1}},
+		{"Second page", CommentQuery{Limit: 2, Offset: 2}, []int{0}},
+		{"Page past the end", CommentQuery{Limit: 2, Offset: 4}, nil},
+		{"Name ignores case", CommentQuery{Name: "ALICE"}, []int{2, 0}},
+		{"IP", CommentQuery{IP: "2.2.2.2"}, []int{1}},
+		{"Since and until", CommentQuery{Since: day.Add(time.Minute), Until: day.Add(time.Hour)}, []int{1}},
+		{"Before", CommentQuery{Before: day.AddDate(0, 0, 1)}, []int{1, 0}},
+		{"Other site", CommentQuery{SiteID: 7}, []int{3}},
+		{"After an ID", CommentQuery{AfterID: comments[0].ID}, []int{2, 1}},
+	}
+	for _, tt := range lists {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.List(ctx, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []int
+			for _, c := range got {
+				ids = append(ids, c.ID)
+			}
+			var want []int
+			for _, i := range tt.want {
+				want = append(want, comments[i].ID)
+			}
+			if len(ids) != len(want) {
+				t.Fatalf("List() = %v, want %v", ids, want)
+			}
+			for i := range ids {
+				if ids[i] != want[i] {
+					t.Fatalf("List() = %v, want %v", ids, want)
+				}
+			}
+			if tt.query.Limit == 0 {
+				if n, err := s.Count(ctx, tt.query); err != nil || n != len(want) {
+					t.Errorf("Count() = %d, %v; want %d", n, err, len(want))
+				}
+			}
+		})
+	}
+
+	// Each stops at, and returns, the first error from fn
+	stop := errors.New("stop")
+	visited := 0
+	err := s.Each(ctx, CommentQuery{Sort: "oldest"}, func(c Comment) error {
+		visited++
+		if c.ID == comments[1].ID {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || visited != 2 {
+		t.Errorf("Each() = %v after %d comments, want stop after 2", err, visited)
+	}
+
+	c, err := s.Get(ctx, 0, comments[0].ID)
+	if err != nil || c.Text != "Hello from the garden" || !c.Created.Equal(day) || c.Website != "https://alice.example/" || !c.Authenticated {
+		t.Fatalf("Get() = %+v, %v", c, err)
+	}
+	if c, err := s.Get(ctx, 7, comments[3].ID); err != nil || c.Created.Format(time.RFC3339Nano) != "2024-05-01T12:00:00Z" {
+		t.Errorf("Created = %v, %v; want it in UTC to the second", c.Created, err)
+	}
+	if _, err := s.Get(ctx, 7, comments[0].ID); err != errNotFound {
+		t.Errorf("Get() from another site = %v, want errNotFound", err)
+	}
+
+	c.Text = "Hello from the rose garden"
+	c.Status = "pending"
+	c.SpamScore, c.SpamReasons = 2, "words: rose (+2)"
+	if err := s.Update(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := s.Get(ctx, 0, c.ID); c.Text != "Hello from the rose garden" || c.Status != "pending" || c.SpamScore != 2 || c.SpamReasons != "words: rose (+2)" {
+		t.Errorf("After Update() got %+v", c)
+	}
+	if err := s.Update(ctx, &Comment{ID: 9999}); err != errNotFound {
+		t.Errorf("Update() of a missing comment = %v, want errNotFound", err)
+	}
+
+	before, err := s.Version(ctx, CommentQuery{})
+	if err != nil || before.Count != 3 || before.MaxID != comments[2].ID || before.Modified.Before(day.AddDate(0, 0, 1)) {
+		t.Errorf("Version() = %+v, %v", before, err)
+	}
+	for i, want := range []int{1, 1} {
+		if likes, err := s.Like(ctx, 0, comments[2].ID, "9.9.9.9"); err != nil || likes != want {
+			t.Errorf("Like() #%d = %d, %v; want %d", i+1, likes, err, want)
+		}
+	}
+	if after, _ := s.Version(ctx, CommentQuery{}); after.ETag() == before.ETag() {
+		t.Errorf("Version() unchanged by a like: %+v", after)
+	}
+	if _, err := s.Like(ctx, 7, comments[2].ID, "9.9.9.9"); err != errNotFound {
+		t.Errorf("Like() on another site = %v, want errNotFound", err)
+	}
+
+	results, err := s.Search(ctx, 7, "garden", 10)
+	if err != nil || len(results) != 1 || results[0].ID != comments[3].ID {
+		t.Errorf("Search() = %+v, %v", results, err)
+	}
+
+	stats, err := s.Stats(ctx, 0, day.AddDate(0, 0, 1))
+	if err != nil || stats.Total != 3 || stats.Likes != 1 || stats.ByStatus["spam"] != 1 || stats.PerDay[28].Count != 2 {
+		t.Errorf("Stats() = %+v, %v", stats, err)
+	}
+
+	if err := s.Delete(ctx, 0, comments[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, 0, comments[1].ID); err != errNotFound {
+		t.Errorf("Second Delete() = %v, want errNotFound", err)
+	}
+	if n, _ := s.Count(ctx, CommentQuery{}); n != 2 {
+		t.Errorf("Count() after Delete() = %d, want 2", n)
+	}
+
+	bulk := []*Comment{
+		{SiteID: 9, ID: 500, Name: "Dan", Text: "Kept ID", Created: day},
+		{SiteID: 9, Name: "Eve", Text: "Next ID", ParentID: 500},
+	}
+	if err := s.CreateMany(ctx, bulk); err != nil {
+		t.Fatal(err)
+	}
+	if bulk[1].ID != 501 || bulk[1].Status != "approved" {
+		t.Errorf("CreateMany() gave the second comment %+v, want ID 501", bulk[1])
+	}
+	if c, err := s.Get(ctx, 9, 501); err != nil || c.ParentID != 500 || c.Created.IsZero() {
+		t.Errorf("Get() after CreateMany() = %+v, %v", c, err)
+	}
+	// a clash anywhere in the batch stores none of it
+	err = s.CreateMany(ctx, []*Comment{{SiteID: 9, ID: 600, Name: "Fay"}, {SiteID: 9, ID: 500, Name: "Gus"}})
+	if !errors.Is(err, errDuplicateID) {
+		t.Errorf("CreateMany() with a taken ID = %v, want errDuplicateID", err)
+	}
+	if n, _ := s.Count(ctx, CommentQuery{SiteID: 9}); n != 2 {
+		t.Errorf("Count() after failed CreateMany() = %d, want 2", n)
+	}
+	c = &Comment{SiteID: 9, Name: "Hal"}
+	if err := s.Create(ctx, c); err != nil || c.ID != 502 {
+		t.Errorf("Create() after CreateMany() = ID %d, %v; want 502", c.ID, err)
+	}
+
+	// archiving moves old comments out of the live ones, likes and all
+	if _, err := s.Like(ctx, 9, 500, "9.9.9.9"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.Archive(ctx, 9, day.Add(time.Second)); err != nil || n != 1 {
+		t.Errorf("Archive() = %d, %v; want 1", n, err)
+	}
+	if n, _ := s.Count(ctx, CommentQuery{SiteID: 9}); n != 2 {
+		t.Errorf("Count() after Archive() = %d, want 2", n)
+	}
+	if _, err := s.Get(ctx, 9, 500); err != errNotFound {
+		t.Errorf("Get() of an archived comment = %v, want errNotFound", err)
+	}
+	archived, err := s.List(ctx, CommentQuery{SiteID: 9, Archived: true})
+	if err != nil || len(archived) != 1 || archived[0].ID != 500 || archived[0].Likes != 1 || !archived[0].Created.Equal(day) {
+		t.Errorf("List() of the archive = %+v, %v", archived, err)
+	}
+	if v, err := s.Version(ctx, CommentQuery{SiteID: 9, Archived: true}); err != nil || v.Count != 1 || v.MaxID != 500 {
+		t.Errorf("Version() of the archive = %+v, %v", v, err)
+	}
+	if n, _ := s.Count(ctx, CommentQuery{SiteID: 0, Archived: true}); n != 0 {
+		t.Errorf("Archive() moved %d comments of another site", n)
+	}
+	if n, err := s.Archive(ctx, 9, day.Add(time.Second)); err != nil || n != 0 {
+		t.Errorf("Second Archive() = %d, %v; want 0", n, err)
+	}
+
+	if blocked, err := s.IsBlocked(ctx, "6.6.6.6"); err != nil || blocked {
+		t.Errorf("IsBlocked() before BlockIP() = %v, %v", blocked, err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.BlockIP(ctx, "6.6.6.6", "test", time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if blocked, err := s.IsBlocked(ctx, "6.6.6.6"); err != nil || !blocked {
+		t.Errorf("IsBlocked() after BlockIP() = %v, %v", blocked, err)
+	}
+	if err := s.BlockIP(ctx, "7.7.7.7", "manual", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	blocked, err := s.Blocklist(ctx)
+	if err != nil || len(blocked) != 2 {
+		t.Fatalf("Blocklist() = %+v, %v; want 2 entries", blocked, err)
+	}
+	for _, b := range blocked {
+		if b.IP == "6.6.6.6" && (b.Hits != 2 || b.Reason != "test" || b.Created.IsZero()) {
+			t.Errorf("Blocklist() entry = %+v, want 2 hits", b)
+		}
+	}
+	if err := s.UnblockIP(ctx, "6.6.6.6"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UnblockIP(ctx, "8.8.8.8"); err != nil {
+		t.Errorf("UnblockIP() of an IP that isn't blocked = %v", err)
+	}
+	if blocked, err := s.IsBlocked(ctx, "6.6.6.6"); err != nil || blocked {
+		t.Errorf("IsBlocked() after UnblockIP() = %v, %v", blocked, err)
+	}
+	if blocked, _ := s.Blocklist(ctx); len(blocked) != 1 || blocked[0].IP != "7.7.7.7" {
+		t.Errorf("Blocklist() after UnblockIP() = %+v", blocked)
+	}
+	// networks cover all of their addresses, and temporary bans end
+	for _, b := range []BlockedIP{
+		{IP: "203.0.113.0/24"},
+		{IP: "2001:db8::/32"},
+		{IP: "198.51.100.9", Expires: time.Now().Add(-time.Minute)},
+		{IP: "198.51.100.10", Expires: time.Now().Add(time.Hour)},
+	} {
+		if err := s.BlockIP(ctx, b.IP, "range", b.Expires); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for ip, want := range map[string]bool{
+		"203.0.113.77": true, "203.0.114.1": false, "::ffff:203.0.113.5": true, "2001:db8:1::1": true, "2001:db9::1": false,
+		"198.51.100.9": false, "198.51.100.10": true, "not an ip": false,
+	} {
+		if blocked, err := s.IsBlocked(ctx, ip); err != nil || blocked != want {
+			t.Errorf("IsBlocked(%q) = %v, %v; want %v", ip, blocked, err, want)
+		}
+	}
+	if blocked, _ := s.Blocklist(ctx); len(blocked) != 4 {
+		t.Errorf("Blocklist() with an expired ban = %+v, want 4 entries", blocked)
+	} else {
+		for _, b := range blocked {
+			if (b.IP == "198.51.100.10") == b.Expires.IsZero() {
+				t.Errorf("Blocklist() entry %+v has the wrong expiry", b)
+			}
+		}
+	}
+	if err := s.RecordBotHit(ctx, BotHit{IP: "6.6.6.6", Path: "/wp-login.php"}); err != nil {
+		t.Error(err)
+	}
+	if n, err := s.DeleteBotHits(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("DeleteBotHits() of old hits = %d, %v, want 0", n, err)
+	}
+	if n, err := s.DeleteBotHits(ctx, time.Now().Add(time.Hour)); err != nil || n != 1 {
+		t.Errorf("DeleteBotHits() = %d, %v, want 1", n, err)
+	}
+	if err := s.Ping(ctx); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSQLStore(t *testing.T) {
+	needSQLite(t)
+	for _, table := range []string{"comments", "comments_archive", "likes", "blocklist", "bot_hits"} {
+		if _, err := db.Exec("DELETE FROM " + table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testStore(t, &sqlStore{db: db})
+}
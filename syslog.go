@@ -0,0 +1,90 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"log/syslog"
+	"sync"
+)
+
+// syslogHandler formats records with the configured text or JSON handler
+// and hands each one to syslog at the matching priority. Syslog stamps the
+// time itself, so it's left out of the message.
+type syslogHandler struct {
+	w      syslogWriter
+	mu     *sync.Mutex
+	buf    *bytes.Buffer
+	format slog.Handler
+}
+
+// syslogWriter is the part of *syslog.Writer the handler uses.
+type syslogWriter interface {
+	Debug(m string) error
+	Info(m string) error
+	Warning(m string) error
+	Err(m string) error
+}
+
+// newSyslogHandler connects to syslog_address over syslog_network, or to
+// the local syslog daemon when they're empty.
+func newSyslogHandler() (slog.Handler, error) {
+	tag := config.SyslogTag
+	if tag == "" {
+		tag = "guestbook"
+	}
+	w, err := syslog.Dial(config.SyslogNetwork, config.SyslogAddress, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+	return newSyslogHandlerFor(w)
+}
+
+func newSyslogHandlerFor(w syslogWriter) (slog.Handler, error) {
+	buf := &bytes.Buffer{}
+	format, err := newHandler(buf, func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) == 0 && a.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return a
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &syslogHandler{w: w, mu: &sync.Mutex{}, buf: buf, format: format}, nil
+}
+
+func (h *syslogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.format.Enabled(ctx, level)
+}
+
+func (h *syslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.buf.Reset()
+	if err := h.format.Handle(ctx, r); err != nil {
+		return err
+	}
+	msg := string(bytes.TrimRight(h.buf.Bytes(), "\n"))
+
+	switch {
+	case r.Level >= slog.LevelError:
+		return h.w.Err(msg)
+	case r.Level >= slog.LevelWarn:
+		return h.w.Warning(msg)
+	case r.Level >= slog.LevelInfo:
+		return h.w.Info(msg)
+	}
+	return h.w.Debug(msg)
+}
+
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &syslogHandler{w: h.w, mu: h.mu, buf: h.buf, format: h.format.WithAttrs(attrs)}
+}
+
+func (h *syslogHandler) WithGroup(name string) slog.Handler {
+	return &syslogHandler{w: h.w, mu: h.mu, buf: h.buf, format: h.format.WithGroup(name)}
+}
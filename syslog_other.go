@@ -0,0 +1,12 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"errors"
+	"log/slog"
+)
+
+func newSyslogHandler() (slog.Handler, error) {
+	return nil, errors.New("syslog is not available on this platform, use log_output = \"stderr\"")
+}
@@ -0,0 +1,53 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+type fakeSyslog struct {
+	entries []string
+}
+
+func (f *fakeSyslog) Debug(m string) error   { f.entries = append(f.entries, "debug "+m); return nil }
+func (f *fakeSyslog) Info(m string) error    { f.entries = append(f.entries, "info "+m); return nil }
+func (f *fakeSyslog) Warning(m string) error { f.entries = append(f.entries, "warning "+m); return nil }
+func (f *fakeSyslog) Err(m string) error     { f.entries = append(f.entries, "err "+m); return nil }
+
+func TestSyslogHandler(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	defer logLevel.Set(logLevel.Level())
+	config.LogLevel = "info"
+
+	w := &fakeSyslog{}
+	h, err := newSyslogHandlerFor(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := slog.New(h).With("request_id", "abc")
+	l.Debug("dropped")
+	l.Info("comment added", "ip", "1.2.3.4")
+	l.Warn("honeypot hit")
+	l.Error("boom")
+
+	expected := []string{
+		`info level=INFO msg="comment added" request_id=abc ip=1.2.3.4`,
+		`warning level=WARN msg="honeypot hit" request_id=abc`,
+		`err level=ERROR msg=boom request_id=abc`,
+	}
+	if len(w.entries) != len(expected) {
+		t.Fatalf("Expected %d entries, got %q", len(expected), w.entries)
+	}
+	for i, e := range expected {
+		if w.entries[i] != e {
+			t.Errorf("Entry %d = %q, want %q", i, w.entries[i], e)
+		}
+		if strings.Contains(w.entries[i], "time=") {
+			t.Errorf("Entry %d still has a timestamp", i)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Telegram bot can message telegram_chat_id about every new comment,
+// with buttons to approve it, delete it or ban its IP. The message goes
+// through the outbox; Telegram posts the button presses to
+// /telegram/webhook, which public_url points it at on startup.
+
+func init() { registerNotifier(telegramNotifier{}) }
+
+// telegramAPI is where the Bot API lives, a variable for tests.
+var telegramAPI = "https://api.telegram.org"
+
+// telegramMaxText is Telegram's limit on a message's length.
+const telegramMaxText = 4096
+
+// telegramMessage is the sendMessage call stored in the outbox.
+type telegramMessage struct {
+	ChatID      string          `json:"chat_id"`
+	Text        string          `json:"text"`
+	ReplyMarkup telegramButtons `json:"reply_markup"`
+}
+
+type telegramButtons struct {
+	InlineKeyboard [][]telegramButton `json:"inline_keyboard"`
+}
+
+type telegramButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+// telegramUpdate is the part of an update /telegram/webhook looks at.
+type telegramUpdate struct {
+	CallbackQuery *struct {
+		ID      string `json:"id"`
+		Data    string `json:"data"`
+		Message struct {
+			MessageID int    `json:"message_id"`
+			Text      string `json:"text"`
+			Chat      struct {
+				ID int64 `json:"id"`
+			} `json:"chat"`
+		} `json:"message"`
+	} `json:"callback_query"`
+}
+
+// telegramClient calls the Bot API.
+var telegramClient = &http.Client{Timeout: 10 * time.Second, Transport: breakerTransport{http.DefaultTransport}}
+
+// telegramSecret is the secret_token Telegram sends with every update, so
+// only Telegram can press the buttons. It's derived from the bot token,
+// which only Telegram and the guestbook know.
+func telegramSecret(token string) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte("telegram webhook"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newTelegramMessage describes c, a new comment of site, with a button
+// for every action on it.
+func newTelegramMessage(site *Site, c *Comment, chatID string) telegramMessage {
+	text := fmt.Sprintf("New comment on %s from %s <%s> (%s):\n\n%s", cmp.Or(site.Name, site.Slug, "the guestbook"), c.Name, c.Email, c.Status, c.Text)
+	if len(text) > telegramMaxText {
+		text = strings.ToValidUTF8(text[:telegramMaxText-1], "") + "…"
+	}
+	ref := strconv.Itoa(site.ID) + ":" + strconv.Itoa(c.ID)
+	return telegramMessage{ChatID: chatID, Text: text, ReplyMarkup: telegramButtons{InlineKeyboard: [][]telegramButton{{
+		{Text: "Approve", CallbackData: "approve:" + ref},
+		{Text: "Delete", CallbackData: "delete:" + ref},
+		{Text: "Ban", CallbackData: "ban:" + ref},
+	}}}}
+}
+
+type telegramNotifier struct{}
+
+func (telegramNotifier) Name() string { return "telegram" }
+
+func (telegramNotifier) Enabled(c Config) bool { return c.TelegramBotToken != "" }
+
+func (telegramNotifier) Messages(_ context.Context, c Config, n Notification) ([]OutboxItem, error) {
+	if n.Event != "comment.created" {
+		return nil, nil
+	}
+	return []OutboxItem{{Target: c.TelegramChatID, Payload: newTelegramMessage(n.Site, n.Comment, c.TelegramChatID)}}, nil
+}
+
+func (telegramNotifier) Deliver(ctx context.Context, _ string, payload []byte) error {
+	return telegramCall(ctx, "sendMessage", json.RawMessage(payload))
+}
+
+// telegramCall calls method of the Bot API with params, returning its
+// error description if it fails.
+func telegramCall(ctx context.Context, method string, params any) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, telegramAPI+"/bot"+settings().TelegramBotToken+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := telegramClient.Do(req)
+	if err != nil {
+		// the URL has the token in it
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return fmt.Errorf("telegram %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&result)
+	if !result.OK {
+		return fmt.Errorf("telegram %s: %s %s", method, resp.Status, result.Description)
+	}
+	return nil
+}
+
+// setTelegramWebhook points the bot's updates at /telegram/webhook.
+func setTelegramWebhook(ctx context.Context) error {
+	return telegramCall(ctx, "setWebhook", map[string]any{
+		"url":             strings.TrimSuffix(config.PublicURL, "/") + "/telegram/webhook",
+		"secret_token":    telegramSecret(config.TelegramBotToken),
+		"allowed_updates": []string{"callback_query"},
+	})
+}
+
+// telegramWebhookHandler acts on a button press in telegram_chat_id. The
+// answer to the press goes back in the response, and the message is
+// edited to say what was done.
+func telegramWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	cfg := settings()
+	if !hmac.Equal([]byte(r.Header.Get("X-Telegram-Bot-Api-Secret-Token")), []byte(telegramSecret(cfg.TelegramBotToken))) {
+		httpError(w, r, http.StatusUnauthorized, codeUnauthorized, "Unauthorized")
+		return
+	}
+	var u telegramUpdate
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		httpError(w, r, 400, codeInvalidJSON, "Invalid JSON")
+		return
+	}
+	q := u.CallbackQuery
+	if q == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	log := requestLogger(r)
+
+	answer := func(text string) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"method": "answerCallbackQuery", "callback_query_id": q.ID, "text": text})
+	}
+	if strconv.FormatInt(q.Message.Chat.ID, 10) != cfg.TelegramChatID {
+		log.Warn("telegram action from another chat", "chat", q.Message.Chat.ID)
+		answer("Not allowed")
+		return
+	}
+	done, err := telegramAction(r.Context(), q.Data)
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		answer(apiErr.message)
+		return
+	} else if err != nil {
+		log.Error("telegram action failed", "action", q.Data, "error", err)
+		answer("Failed, try again")
+		return
+	}
+	log.Info("telegram action", "action", q.Data)
+	// without buttons, and saying what happened
+	err = telegramCall(r.Context(), "editMessageText", map[string]any{
+		"chat_id": q.Message.Chat.ID, "message_id": q.Message.MessageID, "text": q.Message.Text + "\n\n" + done,
+	})
+	if err != nil {
+		log.Warn("telegram message not edited", "error", err)
+	}
+	answer(done)
+}
+
+// telegramAction carries out a button's callback data, "action:site:id",
+// and says what it did. Refusals are *apiError.
+func telegramAction(ctx context.Context, data string) (string, error) {
+	action, ref, _ := strings.Cut(data, ":")
+	siteRef, idRef, _ := strings.Cut(ref, ":")
+	siteID, err1 := strconv.Atoi(siteRef)
+	id, err2 := strconv.Atoi(idRef)
+	if err1 != nil || err2 != nil {
+		return "", &apiError{status: 400, code: codeInvalidID, message: "Unknown comment"}
+	}
+	site, err := siteByID(ctx, siteID)
+	if err == errUnknownSite {
+		return "", &apiError{status: http.StatusNotFound, code: codeUnknownSite, message: "The site is gone"}
+	} else if err != nil {
+		return "", err
+	}
+	if site.Archived {
+		return "", &apiError{status: http.StatusForbidden, code: codeSiteArchived, message: "The site is archived and read-only"}
+	}
+
+	switch action {
+	case "approve":
+		if _, err := moderateComment(ctx, site.ID, id, "approved"); err != nil {
+			return "", err
+		}
+		return "✓ Approved", nil
+	case "delete":
+		if err := store.Delete(ctx, site.ID, id); err == errNotFound {
+			return "", &apiError{status: http.StatusNotFound, code: codeNotFound, message: "Comment not found"}
+		} else if err != nil {
+			return "", err
+		}
+		return "✗ Deleted", nil
+	case "ban":
+		c, err := moderateComment(ctx, site.ID, id, "spam")
+		if err != nil {
+			return "", err
+		}
+		p, err := parseNetwork(c.IP)
+		if err != nil {
+			return "", &apiError{status: 400, code: codeInvalidForm, message: "The comment has no IP to ban"}
+		}
+		if err := store.BlockIP(ctx, banKey(p), "banned from Telegram", time.Time{}); err != nil {
+			return "", err
+		}
+		return "⛔ Marked as spam and banned " + banKey(p), nil
+	}
+	return "", &apiError{status: 400, code: codeInvalidForm, message: "Unknown action"}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTelegram(t *testing.T) {
+	needSQLite(t)
+	defer func(c Config, s CommentStore, api string, client *http.Client) {
+		config, store, telegramAPI, telegramClient = c, s, api, client
+	}(config, store, telegramAPI, telegramClient)
+	store = newMemoryStore()
+	db.Exec("DELETE FROM outbox")
+	config.TelegramBotToken, config.TelegramChatID, config.OutboxMaxAttempts = "123:abc", "42", 3
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	calls := map[string]map[string]any{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params map[string]any
+		json.NewDecoder(r.Body).Decode(&params)
+		mu.Lock()
+		calls[r.URL.Path] = params
+		mu.Unlock()
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer srv.Close()
+	telegramAPI, telegramClient = srv.URL, srv.Client()
+
+	c := &Comment{Name: "Ann", Email: "ann@example.com", Text: "Hello", IP: "203.0.113.9", Status: "pending"}
+	if err := store.Create(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	notify(ctx, logger, Notification{Event: "comment.created", Site: defaultSite, Comment: c})
+	deliverDue(ctx)
+	sent := calls["/bot123:abc/sendMessage"]
+	if sent == nil || sent["chat_id"] != "42" || !strings.Contains(sent["text"].(string), "Hello") {
+		t.Fatalf("sendMessage = %v", sent)
+	}
+	if b, _ := json.Marshal(sent["reply_markup"]); !strings.Contains(string(b), `"callback_data":"ban:0:1"`) {
+		t.Errorf("Buttons = %s", b)
+	}
+
+	press := func(secret string, chat int, data string) (int, string) {
+		body, _ := json.Marshal(map[string]any{"callback_query": map[string]any{
+			"id": "q1", "data": data, "message": map[string]any{"message_id": 5, "text": "New comment", "chat": map[string]any{"id": chat}},
+		}})
+		req := httptest.NewRequest("POST", "/telegram/webhook", strings.NewReader(string(body)))
+		req.Header.Set("X-Telegram-Bot-Api-Secret-Token", secret)
+		rec := httptest.NewRecorder()
+		telegramWebhookHandler(rec, req)
+		var answer struct{ Text string }
+		json.Unmarshal(rec.Body.Bytes(), &answer)
+		return rec.Code, answer.Text
+	}
+	secret := telegramSecret("123:abc")
+	if code, _ := press("wrong", 42, "approve:0:1"); code != http.StatusUnauthorized {
+		t.Errorf("Press with the wrong secret = %d, want 401", code)
+	}
+	if _, text := press(secret, 7, "approve:0:1"); text != "Not allowed" {
+		t.Errorf("Press from another chat = %q", text)
+	}
+	if _, text := press(secret, 42, "approve:0:99"); text != "Comment not found" {
+		t.Errorf("Press on a missing comment = %q", text)
+	}
+
+	if _, text := press(secret, 42, "approve:0:1"); text != "✓ Approved" {
+		t.Errorf("Approve = %q", text)
+	}
+	if got, _ := store.Get(ctx, 0, 1); got.Status != "approved" {
+		t.Errorf("Status after Approve = %q", got.Status)
+	}
+	if edit := calls["/bot123:abc/editMessageText"]; edit == nil || edit["text"] != "New comment\n\n✓ Approved" {
+		t.Errorf("editMessageText = %v", edit)
+	}
+
+	if _, text := press(secret, 42, "ban:0:1"); !strings.Contains(text, "banned 203.0.113.9") {
+		t.Errorf("Ban = %q", text)
+	}
+	if blocked, _ := store.IsBlocked(ctx, "203.0.113.9"); !blocked {
+		t.Error("IP not banned")
+	}
+	if got, _ := store.Get(ctx, 0, 1); got.Status != "spam" {
+		t.Errorf("Status after Ban = %q", got.Status)
+	}
+}
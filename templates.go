@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//go:embed templates
+var templateFiles embed.FS
+
+//go:embed static
+var staticFiles embed.FS
+
+// The pages and the files they're built from, relative to templates/ or
+// templates_dir.
+var (
+	pageTemplateFiles  = []string{"page.html", "embed.html", "guestbook.html", "docs.html", "manage.html"}
+	adminTemplateFiles = []string{"admin/layout.html", "admin/login.html", "admin/dashboard.html"}
+)
+
+// htmlTemplates holds the parsed pages. A reload swaps in a new set, so
+// handlers Load it once per request.
+type htmlTemplates struct {
+	page, admin *template.Template
+}
+
+var templates atomic.Pointer[htmlTemplates]
+
+// displayLocation is display_timezone, the zone pages show times in.
+// Everything else, stored or sent by the API, is UTC.
+var displayLocation atomic.Pointer[time.Location]
+
+// mustLoadLocation loads a zone validateConfig has already checked.
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}
+
+// templateFuncs are available to every template, including overrides.
+var templateFuncs = template.FuncMap{
+	// local converts a time to display_timezone
+	"local": func(t time.Time) time.Time {
+		if loc := displayLocation.Load(); loc != nil {
+			return t.In(loc)
+		}
+		return t.UTC()
+	},
+	// linkify escapes comment text and links its URLs
+	"linkify": linkify,
+}
+
+func init() {
+	t, err := parseTemplates("")
+	if err != nil {
+		panic(err)
+	}
+	templates.Store(t)
+}
+
+// overlayFS serves files from dir and the rest from base, so an override
+// directory only needs the files it changes.
+type overlayFS struct {
+	dir, base fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.dir.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.base.Open(name)
+	}
+	return f, err
+}
+
+// withOverrides lays dir over the embedded files under root.
+func withOverrides(files embed.FS, root, dir string) fs.FS {
+	base, _ := fs.Sub(files, root)
+	if dir == "" {
+		return base
+	}
+	return overlayFS{dir: os.DirFS(dir), base: base}
+}
+
+// parseTemplates parses the pages, taking each file from dir if it's
+// there and from the built-in templates otherwise.
+func parseTemplates(dir string) (*htmlTemplates, error) {
+	fsys := withOverrides(templateFiles, "templates", dir)
+	page, err := template.New("").Funcs(templateFuncs).ParseFS(fsys, pageTemplateFiles...)
+	if err != nil {
+		return nil, err
+	}
+	admin, err := template.New("").Funcs(templateFuncs).ParseFS(fsys, adminTemplateFiles...)
+	if err != nil {
+		return nil, err
+	}
+	return &htmlTemplates{page: page, admin: admin}, nil
+}
+
+// renderHTML executes template name of t, answering 500 if that fails
+// rather than sending half a page.
+func renderHTML(w http.ResponseWriter, r *http.Request, t *template.Template, status int, name string, data any) {
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, name, data); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// staticHandler serves the stylesheets under /static/, from static_dir
+// where it has them. Files there are read on every request, so edits show
+// up without a reload.
+func staticHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	fsys := withOverrides(staticFiles, "static", settings().StaticDir)
+	name := strings.TrimPrefix(r.URL.Path, "/static/")
+	if fi, err := fs.Stat(fsys, name); err != nil || fi.IsDir() {
+		httpError(w, r, http.StatusNotFound, codeNotFound, "File not found")
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	http.ServeFileFS(w, r, fsys, name)
+}
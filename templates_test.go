@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, path, body string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTemplateOverrides(t *testing.T) {
+	defer func(s CommentStore) { store = s }(store)
+	defer templates.Store(templates.Load())
+	store = newMemoryStore()
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "guestbook.html"), `{{define "guestbook"}}<p class="custom">{{len .Comments}} signatures</p>{{end}}`)
+	writeTestFile(t, filepath.Join(dir, "admin", "login.html"), `Custom login {{.CSRF}}`)
+	tmpl, err := parseTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	templates.Store(tmpl)
+
+	rec := httptest.NewRecorder()
+	guestbookPageHandler(rec, httptest.NewRequest("GET", "/", nil))
+	body := rec.Body.String()
+	if !strings.Contains(body, `<p class="custom">0 signatures</p>`) || !strings.Contains(body, "<title>Guestbook</title>") {
+		t.Errorf("Page with an overridden guestbook.html:\n%s", body)
+	}
+
+	rec = httptest.NewRecorder()
+	dashboardHandler(rec, httptest.NewRequest("GET", "/admin", nil))
+	if !strings.HasPrefix(rec.Body.String(), "Custom login") {
+		t.Errorf("Login with an overridden login.html:\n%s", rec.Body.String())
+	}
+
+	writeTestFile(t, filepath.Join(dir, "page.html"), `{{template "missing"}}`)
+	tmpl, err = parseTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	templates.Store(tmpl)
+	rec = httptest.NewRecorder()
+	guestbookPageHandler(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != 500 || strings.Contains(rec.Body.String(), "<html") {
+		t.Errorf("Broken template = %d:\n%s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestStaticHandler(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "guestbook.css"), "body { color: red; }")
+	writeTestFile(t, filepath.Join(dir, "fonts", "serif.woff2"), "font")
+
+	tests := []struct {
+		name      string
+		staticDir string
+		path      string
+		status    int
+		want      string
+	}{
+		{"Built in", "", "/static/guestbook.css", 200, "font: 16px/1.5 Georgia"},
+		{"Overridden", dir, "/static/guestbook.css", 200, "color: red"},
+		{"Fallback", dir, "/static/admin.css", 200, "header h1"},
+		{"Added", dir, "/static/fonts/serif.woff2", 200, "font"},
+		{"Missing", dir, "/static/nope.css", 404, "not_found"},
+		{"Directory", dir, "/static/fonts", 404, "not_found"},
+		{"Directory listing", dir, "/static/", 404, "not_found"},
+		{"Outside", dir, "/static/../config.toml", 404, "not_found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.StaticDir = tt.staticDir
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/", nil)
+			req.URL.Path = tt.path
+			staticHandler(rec, req)
+			if rec.Code != tt.status || !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("GET %s = %d:\n%s", tt.path, rec.Code, rec.Body.String())
+			}
+			if rec.Code == 200 && rec.Header().Get("Cache-Control") == "" {
+				t.Error("No Cache-Control header")
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	staticHandler(rec, httptest.NewRequest("POST", "/static/guestbook.css", nil))
+	if rec.Code != 405 {
+		t.Errorf("POST = %d, want 405", rec.Code)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+
+	"github.com/XSAM/otelsql"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func tracingEnabled() bool {
+	return config.OTLPEndpoint != ""
+}
+
+// initTracing exports spans over OTLP/HTTP to otlp_endpoint and accepts
+// W3C trace context from upstream proxies. The returned function flushes
+// pending spans and must be called before exiting.
+func initTracing(ctx context.Context) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(config.OTLPEndpoint))
+	if err != nil {
+		return nil, err
+	}
+
+	name := config.ServiceName
+	if name == "" {
+		name = "guestbook"
+	}
+	ratio := config.TraceSampleRatio
+	if ratio <= 0 {
+		ratio = 1
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", name))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return tp.Shutdown, nil
+}
+
+// openDB opens the SQLite or MySQL database, instrumenting every query
+// with a span when tracing is enabled.
+func openDB() (*sql.DB, error) {
+	driver, dsn, system := "sqlite3", sqliteDSN(config), "sqlite"
+	if usingMySQL() {
+		var err error
+		if dsn, err = mysqlDSN(config.DBDSN); err != nil {
+			return nil, err
+		}
+		driver, system = "mysql", "mysql"
+	}
+
+	var db *sql.DB
+	var err error
+	if !tracingEnabled() {
+		db, err = sql.Open(driver, dsn)
+	} else {
+		db, err = otelsql.Open(driver, dsn,
+			otelsql.WithAttributes(attribute.String("db.system.name", system)),
+			otelsql.WithSpanOptions(otelsql.SpanOptions{OmitRows: true}),
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+	configurePool(db, config)
+	return db, nil
+}
+
+// withTracing starts a server span per request, continuing the caller's
+// trace if it sent a traceparent header.
+func withTracing(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "guestbook",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + r.URL.Path
+		}),
+	)
+}
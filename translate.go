@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// With translate_provider, GET /comments?translate=<language> returns the
+// comments translated by DeepL or a LibreTranslate server, to one of
+// translate_languages. Translations are kept in the shared state per
+// comment, text and language, so each comment is only sent to the
+// provider once per language. The provider charges by the character, so
+// only the paged listing, not /comments/all, can be translated.
+
+const (
+	// translationTTL is how long a translation is kept.
+	translationTTL = 30 * 24 * time.Hour
+	// translateTimeout bounds the call to the provider, after which the
+	// comments are returned as they are.
+	translateTimeout = 5 * time.Second
+	// defaultDeepLURL is where the DeepL API Free lives, for keys without
+	// a translate_url.
+	defaultDeepLURL = "https://api-free.deepl.com"
+	// translateMaxBatch is the most comments sent to the provider in one
+	// call; the rest of a longer list keep their text.
+	translateMaxBatch = 50
+)
+
+// translateClient calls translate_url.
+var translateClient = &http.Client{Timeout: translateTimeout, Transport: breakerTransport{http.DefaultTransport}}
+
+// translationLanguage checks ?translate, returning an *apiError if it
+// can't be served.
+func translationLanguage(c Config, lang string) error {
+	if c.TranslateProvider == "" {
+		return &apiError{status: http.StatusNotImplemented, code: codeNotSupported, message: "Translation isn't configured"}
+	}
+	if !validLocale.MatchString(lang) {
+		return &apiError{status: 400, code: codeInvalidQuery, message: "translate must be a language like en or pt-BR"}
+	}
+	if !slices.ContainsFunc(c.TranslateLanguages, func(l string) bool { return strings.EqualFold(l, lang) }) {
+		return &apiError{status: 400, code: codeInvalidQuery, message: "translate must be one of " + strings.Join(c.TranslateLanguages, ", ")}
+	}
+	return nil
+}
+
+// translationKey is where the translation of text, the text of comment
+// id, to lang is kept. The text is part of it so an edit isn't served
+// the old translation.
+func translationKey(id int, lang, text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return fmt.Sprintf("translation:%s:%d:%s", strings.ToLower(lang), id, hex.EncodeToString(sum[:8]))
+}
+
+// translateComments replaces the text of comments with its translation
+// to lang, asking the provider only for the ones it hasn't translated
+// before, at most translateMaxBatch of them in one call. It reports
+// whether every comment was translated; the ones that weren't keep their
+// text.
+func translateComments(ctx context.Context, log *slog.Logger, c Config, lang string, comments []Comment) bool {
+	var missing []int
+	for i, cm := range comments {
+		b, ok, err := shared.Get(ctx, translationKey(cm.ID, lang, cm.Text))
+		if err != nil {
+			log.Warn("Reading a translation failed", "id", cm.ID, "error", err)
+		}
+		if ok {
+			comments[i].Text = string(b)
+		} else {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) == 0 {
+		return true
+	}
+	all := len(missing) <= translateMaxBatch
+	missing = missing[:min(len(missing), translateMaxBatch)]
+
+	texts := make([]string, len(missing))
+	for j, i := range missing {
+		texts[j] = comments[i].Text
+	}
+	ctx, cancel := context.WithTimeout(ctx, translateTimeout)
+	defer cancel()
+	translated, err := translateTexts(ctx, c, lang, texts)
+	if err == nil && len(translated) != len(texts) {
+		err = fmt.Errorf("asked for %d translations, got %d", len(texts), len(translated))
+	}
+	if err != nil {
+		log.Warn("Translation failed", "provider", c.TranslateProvider, "language", lang, "error", err)
+		return false
+	}
+	for j, i := range missing {
+		if err := shared.Set(ctx, translationKey(comments[i].ID, lang, comments[i].Text), []byte(translated[j]), translationTTL); err != nil {
+			log.Warn("Storing a translation failed", "id", comments[i].ID, "error", err)
+		}
+		comments[i].Text = translated[j]
+	}
+	return all
+}
+
+// translateTexts has translate_provider translate texts to lang.
+func translateTexts(ctx context.Context, c Config, lang string, texts []string) ([]string, error) {
+	switch c.TranslateProvider {
+	case "deepl":
+		var resp struct {
+			Translations []struct {
+				Text string `json:"text"`
+			} `json:"translations"`
+		}
+		base := c.TranslateURL
+		if base == "" {
+			base = defaultDeepLURL
+		}
+		body := map[string]any{"text": texts, "target_lang": strings.ToUpper(lang)}
+		err := postTranslate(ctx, strings.TrimSuffix(base, "/")+"/v2/translate", "DeepL-Auth-Key "+c.TranslateAPIKey, body, &resp)
+		out := make([]string, len(resp.Translations))
+		for i, t := range resp.Translations {
+			out[i] = t.Text
+		}
+		return out, err
+	case "libretranslate":
+		var resp struct {
+			TranslatedText []string `json:"translatedText"`
+		}
+		body := map[string]any{"q": texts, "source": "auto", "target": lang, "format": "text"}
+		if c.TranslateAPIKey != "" {
+			body["api_key"] = c.TranslateAPIKey
+		}
+		err := postTranslate(ctx, strings.TrimSuffix(c.TranslateURL, "/")+"/translate", "", body, &resp)
+		return resp.TranslatedText, err
+	}
+	return nil, fmt.Errorf("unknown translate_provider %q", c.TranslateProvider)
+}
+
+// postTranslate posts body as JSON to endpoint and decodes the answer
+// into result.
+func postTranslate(ctx context.Context, endpoint, auth string, body, result any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	resp, err := translateClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return fmt.Errorf("POST %s: %s", endpoint, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(result)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTranslationLanguage(t *testing.T) {
+	c := defaultConfig()
+	if err := translationLanguage(c, "de"); err == nil || err.(*apiError).status != http.StatusNotImplemented {
+		t.Errorf("Without a provider: %v, want 501", err)
+	}
+	c.TranslateProvider, c.TranslateLanguages = "deepl", []string{"de", "pt-BR"}
+	for lang, ok := range map[string]bool{"de": true, "pt-br": true, "fr": false, "": false, "../de": false} {
+		if err := translationLanguage(c, lang); (err == nil) != ok {
+			t.Errorf("translationLanguage(%q) = %v", lang, err)
+		}
+	}
+	c.TranslateLanguages = nil
+	if err := translationLanguage(c, "de"); err == nil {
+		t.Error("translationLanguage() without translate_languages accepted de")
+	}
+}
+
+func TestTranslateComments(t *testing.T) {
+	defer func(s SharedState) { shared = s }(shared)
+	shared = newMemoryState()
+
+	var calls []map[string]any
+	var auth string
+	fail := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			http.Error(w, "quota exceeded", 456)
+			return
+		}
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		body["path"] = r.URL.Path
+		calls, auth = append(calls, body), r.Header.Get("Authorization")
+		var out []string
+		texts, _ := body["text"].([]any)
+		if q, ok := body["q"].([]any); ok {
+			texts = q
+		}
+		for _, q := range texts {
+			out = append(out, strings.ToUpper(q.(string)))
+		}
+		if r.URL.Path == "/v2/translate" {
+			var ts []map[string]string
+			for _, s := range out {
+				ts = append(ts, map[string]string{"text": s})
+			}
+			json.NewEncoder(w).Encode(map[string]any{"translations": ts})
+		} else {
+			json.NewEncoder(w).Encode(map[string]any{"translatedText": out})
+		}
+	}))
+	defer srv.Close()
+	c := defaultConfig()
+	c.TranslateProvider, c.TranslateURL, c.TranslateAPIKey = "deepl", srv.URL, "key"
+
+	comments := []Comment{{ID: 1, Text: "hallo"}, {ID: 2, Text: "welt"}}
+	if !translateComments(t.Context(), logger, c, "en", comments) || comments[0].Text != "HALLO" || comments[1].Text != "WELT" {
+		t.Fatalf("Translated to %v", comments)
+	}
+	if len(calls) != 1 || calls[0]["path"] != "/v2/translate" || calls[0]["target_lang"] != "EN" || auth != "DeepL-Auth-Key key" {
+		t.Errorf("DeepL called with %v, Authorization %q", calls, auth)
+	}
+
+	// only the edited comment goes to the provider again
+	comments = []Comment{{ID: 1, Text: "hallo"}, {ID: 2, Text: "welt!"}}
+	if !translateComments(t.Context(), logger, c, "en", comments) || comments[0].Text != "HALLO" || comments[1].Text != "WELT!" {
+		t.Fatalf("Translated to %v", comments)
+	}
+	if len(calls) != 2 || len(calls[1]["text"].([]any)) != 1 {
+		t.Errorf("Second call = %v, want only the edited comment", calls[1:])
+	}
+
+	c.TranslateProvider = "libretranslate"
+	comments = []Comment{{ID: 1, Text: "hallo"}}
+	if !translateComments(t.Context(), logger, c, "fr", comments) || comments[0].Text != "HALLO" {
+		t.Fatalf("Translated to %v", comments)
+	}
+	if last := calls[len(calls)-1]; last["path"] != "/translate" || last["target"] != "fr" || last["source"] != "auto" || last["api_key"] != "key" {
+		t.Errorf("LibreTranslate called with %v", last)
+	}
+
+	// a long list is sent in parts, one per call
+	comments = make([]Comment, translateMaxBatch+1)
+	for i := range comments {
+		comments[i] = Comment{ID: 100 + i, Text: "neu"}
+	}
+	if translateComments(t.Context(), logger, c, "fr", comments) || comments[0].Text != "NEU" || comments[translateMaxBatch].Text != "neu" {
+		t.Errorf("Translating more than translateMaxBatch comments at once")
+	}
+	if n := len(calls[len(calls)-1]["q"].([]any)); n != translateMaxBatch {
+		t.Errorf("%d comments sent at once", n)
+	}
+
+	fail = true
+	comments = []Comment{{ID: 3, Text: "tschüss"}}
+	if translateComments(t.Context(), logger, c, "fr", comments) || comments[0].Text != "tschüss" {
+		t.Errorf("Failed translation left %v", comments)
+	}
+}
+
+func TestGetCommentsTranslated(t *testing.T) {
+	defer func(c Config, s CommentStore, st SharedState) { config, store, shared = c, s, st }(config, store, shared)
+	store, shared = newMemoryStore(), newMemoryState()
+	store.Create(t.Context(), &Comment{Name: "Ann", Text: "hallo"})
+
+	fail := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"translatedText": []string{"hello"}})
+	}))
+	defer srv.Close()
+
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		getComments(rec, httptest.NewRequest("GET", "/comments"+query, nil), 15)
+		return rec
+	}
+	if rec := get("?translate=en"); rec.Code != http.StatusNotImplemented {
+		t.Errorf("Without a provider: %d", rec.Code)
+	}
+
+	config.TranslateProvider, config.TranslateURL, config.TranslateLanguages = "libretranslate", srv.URL, []string{"en", "de"}
+	if rec := get("?translate=english"); rec.Code != 400 {
+		t.Errorf("Bad language: %d", rec.Code)
+	}
+	rec := httptest.NewRecorder()
+	getComments(rec, httptest.NewRequest("GET", "/comments/all?translate=en", nil), -1)
+	if rec.Code != 400 || rec.Header().Get("X-Error-Code") != codeInvalidQuery {
+		t.Errorf("Translating all comments: %d", rec.Code)
+	}
+	rec = get("?translate=en")
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `"text":"hello"`) || rec.Header().Get("Content-Language") != "en" {
+		t.Errorf("GET = %d %s, headers %v", rec.Code, rec.Body, rec.Header())
+	}
+
+	fail = true
+	rec = get("?translate=de")
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `"text":"hallo"`) || rec.Header().Get("ETag") != "" || rec.Header().Get("Content-Language") != "" {
+		t.Errorf("Failed translation: %d %s, headers %v", rec.Code, rec.Body, rec.Header())
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// With email_verification_secret, a commenter whose email isn't verified
+// yet gets an email with a link after posting. The link carries a JWT
+// signed with the secret, so nothing is stored until it's followed; then
+// the email's hash goes into verified_emails and the browser that followed
+// it gets a verified cookie, another JWT naming the email. Since the
+// listings are no secret to anyone, only comments that come with both
+// have Verified set, and are published right away with approve_verified,
+// along with the comment the link was sent for. A new browser gets a link
+// of its own with its first comment.
+//
+// double_opt_in holds comments from addresses that aren't verified as
+// unconfirmed, where nobody sees them and nobody is notified about them,
+// until the link is followed; see confirmComments.
+
+// verifyEmailIssuer is the iss and aud of verification links.
+const verifyEmailIssuer = "guestbook-verify-email"
+
+// verifiedCookie holds the proof that the browser followed a verification
+// link, whose iss and aud are verifiedCookieIssuer, for verifiedCookieTTL.
+const (
+	verifiedCookie       = "guestbook_verified"
+	verifiedCookieIssuer = "guestbook-verified-email"
+	verifiedCookieTTL    = 365 * 24 * time.Hour
+)
+
+// verifyLinkTTL is how long a verification link works.
+const verifyLinkTTL = 7 * 24 * time.Hour
+
+// verifyResendInterval is how long an address waits for another
+// verification email from a site, so posting a lot doesn't flood its
+// inbox.
+const verifyResendInterval = time.Hour
+
+// verifyEmailData is what verify_email is rendered with.
+type verifyEmailData struct {
+	Site string
+	Name string
+	URL  string
+	// Confirm tells the comment waits for the link, with double_opt_in.
+	Confirm bool
+}
+
+// emailVerified reports whether email was verified and proven, the email
+// of the commenter's verified cookie, is the same.
+func emailVerified(ctx context.Context, email, proven string) (bool, error) {
+	if db == nil || email == "" || !strings.EqualFold(strings.TrimSpace(email), proven) {
+		return false, nil
+	}
+	var n int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM verified_emails WHERE email_hash = ?", emailHash(email)).Scan(&n)
+	return n > 0, err
+}
+
+// sendVerification queues an email to the commenter of c, whose email
+// isn't verified, or not in their browser, with a link to verify it. A
+// failure is only logged.
+func sendVerification(ctx context.Context, log *slog.Logger, cfg Config, site *Site, c *Comment) {
+	sentKey := fmt.Sprintf("verify-email-sent:%d:%s", c.SiteID, emailHash(c.Email))
+	ok, err := shared.SetNX(ctx, sentKey, []byte("1"), verifyResendInterval)
+	if err != nil || !ok {
+		return
+	}
+	now := time.Now()
+	token, err := signJWT([]byte(cfg.EmailVerificationSecret), jwtClaims{
+		"iss":  verifyEmailIssuer,
+		"aud":  verifyEmailIssuer,
+		"sub":  strings.ToLower(strings.TrimSpace(c.Email)),
+		"site": c.SiteID,
+		"cid":  c.ID,
+		"iat":  now.Unix(),
+		"exp":  now.Add(verifyLinkTTL).Unix(),
+	})
+	if err == nil {
+		var e emailMessage
+		data := verifyEmailData{
+			Site:    cmp.Or(site.Name, site.Slug, "the guestbook"),
+			Name:    c.Name,
+			URL:     strings.TrimSuffix(cfg.PublicURL, "/") + "/verify-email?" + url.Values{"token": {token}}.Encode(),
+			Confirm: c.Status == "unconfirmed",
+		}
+		if e, err = renderEmail(cfg, "verify_email", data); err == nil {
+			err = enqueue(ctx, "email", c.Email, e)
+		}
+	}
+	if err != nil {
+		countNotifier("email", "errors")
+		log.Error("verification email not queued", "email", c.Email, "error", err)
+		shared.Delete(ctx, sentKey)
+		return
+	}
+	countNotifier("email", "queued")
+	wakeOutbox()
+}
+
+// verifyEmailHandler follows a link from sendVerification: it marks the
+// email as verified and, with approve_verified, publishes the comment the
+// link was sent for, then sends the visitor to the guestbook.
+func verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	cfg := settings()
+	claims, err := verifyEmailToken(cfg, r.URL.Query().Get("token"))
+	if err != nil {
+		requestLogger(r).Info("email verification failed", "error", err)
+		httpError(w, r, http.StatusBadRequest, codeInvalidLink, "The link is invalid or has expired")
+		return
+	}
+	email := claims.str("sub")
+	if _, err := db.ExecContext(r.Context(), insertIgnore()+" INTO verified_emails (email_hash) VALUES (?)", emailHash(email)); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if err := setVerifiedCookie(w, r, cfg, email); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	requestLogger(r).Info("email verified", "email", email)
+
+	site, err := siteByID(r.Context(), int(claims["site"].(float64)))
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if _, err := confirmComments(r.Context(), requestLogger(r), cfg, site, email); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if cfg.ApproveVerified {
+		c, err := store.Get(r.Context(), site.ID, int(claims["cid"].(float64)))
+		if err != nil && !errors.Is(err, errNotFound) {
+			internalError(w, r, err)
+			return
+		}
+		if c != nil && c.Status == "pending" && strings.EqualFold(c.Email, email) {
+			// unless the spam checks are what held it back
+			v := *c
+			v.Verified = true
+			if c.Status = moderationStatus(cfg, site, &v); c.Status == "approved" {
+				if err := store.Update(r.Context(), c); err != nil {
+					internalError(w, r, err)
+					return
+				}
+			}
+		}
+	}
+
+	q := url.Values{"verified": {"1"}}
+	if config.MultiTenant {
+		q.Set("site", site.Slug)
+	}
+	http.Redirect(w, r, "/?"+q.Encode(), http.StatusSeeOther)
+}
+
+// confirmComments moves the unconfirmed comments from email on site, see
+// double_opt_in, on to the status they would have had if the email had
+// been verified when they were posted, and returns how many there were.
+func confirmComments(ctx context.Context, log *slog.Logger, cfg Config, site *Site, email string) (int, error) {
+	comments, err := store.List(ctx, CommentQuery{SiteID: site.ID, Email: email, Status: "unconfirmed"})
+	if err != nil {
+		return 0, err
+	}
+	for i := range comments {
+		c := &comments[i]
+		v := *c
+		v.Verified = true
+		c.Status = moderationStatus(cfg, site, &v)
+		if err := store.Update(ctx, c); err != nil {
+			return i, err
+		}
+		log.Info("comment confirmed", "site", site.Slug, "id", c.ID, "status", c.Status)
+		notify(ctx, log, Notification{Event: "comment.created", Site: site, Comment: c})
+	}
+	return len(comments), nil
+}
+
+// setVerifiedCookie gives the browser of r the verified cookie for email.
+func setVerifiedCookie(w http.ResponseWriter, r *http.Request, cfg Config, email string) error {
+	now := time.Now()
+	token, err := signJWT([]byte(cfg.EmailVerificationSecret), jwtClaims{
+		"iss": verifiedCookieIssuer,
+		"aud": verifiedCookieIssuer,
+		"sub": email,
+		"iat": now.Unix(),
+		"exp": now.Add(verifiedCookieTTL).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     verifiedCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(verifiedCookieTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// verifiedEmail is the email the verified cookie of r was given for, or
+// empty if it has none that checks out.
+func verifiedEmail(cfg Config, r *http.Request) string {
+	c, err := r.Cookie(verifiedCookie)
+	if err != nil || cfg.EmailVerificationSecret == "" {
+		return ""
+	}
+	h, claims, signed, sig, err := splitJWT(c.Value)
+	if err != nil || verifyJWTSignature(h.Alg, []byte(cfg.EmailVerificationSecret), signed, sig) != nil {
+		return ""
+	}
+	if claims.str("iss") != verifiedCookieIssuer || !claims.hasAudience(verifiedCookieIssuer) || claims.checkTimes(time.Now()) != nil {
+		return ""
+	}
+	return claims.str("sub")
+}
+
+// verifyEmailToken checks the token of a verification link and returns
+// its claims.
+func verifyEmailToken(cfg Config, token string) (jwtClaims, error) {
+	h, claims, signed, sig, err := splitJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(h.Alg, []byte(cfg.EmailVerificationSecret), signed, sig); err != nil {
+		return nil, err
+	}
+	if claims.str("iss") != verifyEmailIssuer || !claims.hasAudience(verifyEmailIssuer) || claims.str("sub") == "" {
+		return nil, errors.New("not a verification link of the guestbook")
+	}
+	if _, ok := claims["site"].(float64); !ok {
+		return nil, errors.New("verification link without a site")
+	}
+	if _, ok := claims["cid"].(float64); !ok {
+		return nil, errors.New("verification link without a comment")
+	}
+	if err := claims.checkTimes(time.Now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmailVerification(t *testing.T) {
+	needSQLite(t)
+	defer func(c Config, s CommentStore, st SharedState) { config, store, shared = c, s, st }(config, store, shared)
+	store, shared = newMemoryStore(), newMemoryState()
+	db.Exec("DELETE FROM outbox")
+	db.Exec("DELETE FROM verified_emails")
+	defer db.Exec("DELETE FROM verified_emails")
+	config.EmailVerificationSecret = strings.Repeat("s", 32)
+	config.ApproveVerified, config.PublicURL = true, "https://guestbook.example"
+	site := &Site{ID: 0, Slug: "default", Moderation: "pending"}
+	log := logger
+
+	submit := func(email, proven string) *Comment {
+		t.Helper()
+		c, _, err := submitComment(t.Context(), log, site, commentInput{Name: "Ann", Email: email, Text: "Hello " + email + proven, IP: "192.0.2.1", Consent: true, VerifiedEmail: proven})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	link := func() string {
+		t.Helper()
+		var payload string
+		if err := db.QueryRow("SELECT payload FROM outbox WHERE kind = 'email' ORDER BY id DESC LIMIT 1").Scan(&payload); err != nil {
+			t.Fatal(err)
+		}
+		var e emailMessage
+		json.Unmarshal([]byte(payload), &e)
+		i := strings.Index(e.Body, "https://guestbook.example/verify-email?token=")
+		if i < 0 || !strings.Contains(e.Subject, "Verify") {
+			t.Fatalf("Verification email %q\n%s", e.Subject, e.Body)
+		}
+		return strings.Fields(e.Body[i:])[0]
+	}
+
+	first := submit("Ann@example.com", "")
+	if first.Verified || first.Status != "pending" {
+		t.Fatalf("Unverified comment %+v", first)
+	}
+	u := link()
+	// one email an hour per address
+	submit("ann@example.com", "")
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM outbox").Scan(&n)
+	if n != 1 {
+		t.Errorf("%d emails queued, want 1", n)
+	}
+
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		verifyEmailHandler(rec, httptest.NewRequest("GET", target, nil))
+		return rec
+	}
+	parsed, _ := url.Parse(u)
+	token := parsed.Query().Get("token")
+	for _, bad := range []string{"", token[:len(token)-2], token + "x"} {
+		if rec := get("/verify-email?token=" + url.QueryEscape(bad)); rec.Code != 400 || rec.Header().Get("X-Error-Code") != codeInvalidLink {
+			t.Errorf("Bad token: %d %s", rec.Code, rec.Body)
+		}
+	}
+	expired, _ := signJWT([]byte(config.EmailVerificationSecret), jwtClaims{
+		"iss": verifyEmailIssuer, "aud": verifyEmailIssuer, "sub": "ann@example.com", "site": 0, "cid": first.ID,
+		"exp": time.Now().Add(-time.Hour).Unix(),
+	})
+	if rec := get("/verify-email?token=" + expired); rec.Code != 400 {
+		t.Errorf("Expired token: %d %s", rec.Code, rec.Body)
+	}
+
+	rec := get(parsed.RequestURI())
+	if rec.Code != 303 || rec.Header().Get("Location") != "/?verified=1" {
+		t.Fatalf("GET %s = %d %v", parsed.RequestURI(), rec.Code, rec.Header())
+	}
+	if c, _ := store.Get(t.Context(), 0, first.ID); c.Status != "approved" {
+		t.Errorf("Comment the link was for is %s", c.Status)
+	}
+	// following it again is fine
+	if rec := get(parsed.RequestURI()); rec.Code != 303 {
+		t.Errorf("Second GET = %d", rec.Code)
+	}
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != verifiedCookie || !cookies[0].HttpOnly {
+		t.Fatalf("Cookies %v", cookies)
+	}
+	req := httptest.NewRequest("POST", "/comments", nil)
+	req.AddCookie(cookies[0])
+	proven := verifiedEmail(config, req)
+	if proven != "ann@example.com" {
+		t.Fatalf("verifiedEmail() = %q", proven)
+	}
+	forged := httptest.NewRequest("POST", "/comments", nil)
+	forged.AddCookie(&http.Cookie{Name: verifiedCookie, Value: expired})
+	if e := verifiedEmail(config, forged); e != "" {
+		t.Errorf("verifiedEmail() with a link's token = %q", e)
+	}
+
+	c := submit("ANN@example.com", proven)
+	if !c.Verified || c.Status != "approved" || !strings.Contains(string(appendCommentJSON(nil, c)), `"verified":true`) {
+		t.Errorf("Verified comment %+v", c)
+	}
+	// the email alone, which anyone can copy, isn't enough
+	if c := submit("ann@example.com", ""); c.Verified || c.Status != "pending" {
+		t.Errorf("Comment without the cookie %+v", c)
+	}
+	if c := submit("bob@example.com", proven); c.Verified || c.Status != "pending" {
+		t.Errorf("Other email's comment %+v", c)
+	}
+}
+
+func TestDoubleOptIn(t *testing.T) {
+	needSQLite(t)
+	defer func(c Config, s CommentStore, st SharedState) { config, store, shared = c, s, st }(config, store, shared)
+	store, shared = newMemoryStore(), newMemoryState()
+	db.Exec("DELETE FROM outbox")
+	db.Exec("DELETE FROM verified_emails")
+	defer db.Exec("DELETE FROM verified_emails")
+	config.EmailVerificationSecret, config.DoubleOptIn = strings.Repeat("s", 32), true
+	config.PublicURL, config.NotifyEmail = "https://guestbook.example", "owner@example.com"
+
+	form := url.Values{"name": {"Ann"}, "email": {"ann@example.com"}, "comment": {"Hello"}}
+	var cookies []*http.Cookie
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/comments", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		addComment(rec, req)
+		return rec
+	}
+	if rec := post(); rec.Code != 201 || !strings.Contains(rec.Body.String(), "confirm") {
+		t.Fatalf("POST = %d %s", rec.Code, rec.Body)
+	}
+	form.Set("comment", "Hello again")
+	post()
+
+	comments, _ := store.List(t.Context(), CommentQuery{})
+	if len(comments) != 2 || comments[0].Status != "unconfirmed" || comments[1].Status != "unconfirmed" {
+		t.Fatalf("Stored %+v", comments)
+	}
+	var payloads []string
+	rows, _ := db.Query("SELECT target, payload FROM outbox ORDER BY id")
+	for rows.Next() {
+		var target, payload string
+		rows.Scan(&target, &payload)
+		payloads = append(payloads, target+" "+payload)
+	}
+	rows.Close()
+	// one confirmation email, and nothing for notify_email yet
+	if len(payloads) != 1 || !strings.HasPrefix(payloads[0], "ann@example.com ") || !strings.Contains(payloads[0], "Confirm your comment") {
+		t.Fatalf("Outbox %q", payloads)
+	}
+	var e emailMessage
+	json.Unmarshal([]byte(strings.TrimPrefix(payloads[0], "ann@example.com ")), &e)
+	i := strings.Index(e.Body, "/verify-email?")
+	link := strings.Fields(e.Body[i:])[0]
+
+	rec := httptest.NewRecorder()
+	verifyEmailHandler(rec, httptest.NewRequest("GET", link, nil))
+	if rec.Code != 303 {
+		t.Fatalf("GET %s = %d %s", link, rec.Code, rec.Body)
+	}
+	cookies = rec.Result().Cookies()
+	comments, _ = store.List(t.Context(), CommentQuery{})
+	for _, c := range comments {
+		if c.Status != "approved" {
+			t.Errorf("Confirmed comment %d is %s", c.ID, c.Status)
+		}
+	}
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM outbox WHERE target = 'owner@example.com'").Scan(&n)
+	if n != 2 {
+		t.Errorf("%d notifications after confirming, want 2", n)
+	}
+
+	form.Set("comment", "Verified now")
+	post()
+	if c, _ := store.List(t.Context(), CommentQuery{Limit: 1}); c[0].Status != "approved" || !c[0].Verified {
+		t.Errorf("Comment after verifying %+v", c[0])
+	}
+}
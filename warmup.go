@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// warmup runs the work the first visitors after a deploy would otherwise
+// pay for: opening the database, reading the recent comments listing of
+// every site into the response cache and loading the search index.
+func warmup() error {
+	steps := []struct {
+		name string
+		fn   func() error
+	}{
+		{"database", func() error { return store.Ping(context.Background()) }},
+		{"recent comments", warmRecentComments},
+		{"search index", warmSearch},
+	}
+
+	for _, step := range steps {
+		start := time.Now()
+		if err := step.fn(); err != nil {
+			return err
+		}
+		logger.Info("warmup step done", "step", step.name, "duration", time.Since(start))
+	}
+	return nil
+}
+
+// warmRecentComments builds the GET /comments response of every site that
+// isn't archived and, with response_cache_seconds, caches it the way
+// getComments would.
+func warmRecentComments() error {
+	ctx := context.Background()
+	ttl := time.Duration(settings().ResponseCacheSeconds) * time.Second
+	siteIDs, err := activeSiteIDs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range siteIDs {
+		q := CommentQuery{SiteID: id, Status: "approved", Sort: "newest", Limit: 15}
+		_, gen, hit, err := cachedRecent(ctx, id)
+		if err != nil {
+			return err
+		}
+		if hit {
+			continue
+		}
+		v, err := store.Version(ctx, q)
+		if err != nil {
+			return err
+		}
+		comments, err := store.List(ctx, q)
+		if err != nil {
+			return err
+		}
+		if ttl == 0 {
+			continue
+		}
+		for i := range comments {
+			comments[i].hidePersonal()
+		}
+		body := append(appendCommentsJSON(nil, comments), '\n')
+		if err := cacheRecent(ctx, id, cachedResponse{Generation: gen, Body: body, Version: v}, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func warmSearch() error {
+	_, err := store.Search(context.Background(), 0, "guestbook", 15)
+	return err
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWarmup(t *testing.T) {
+	needSQLite(t)
+	defer func(c Config, st SharedState) { config, shared = c, st }(config, shared)
+	config.ResponseCacheSeconds, shared = 60, newMemoryState()
+
+	_, err := db.Exec("INSERT INTO comments (name, email, text, ip, location) VALUES (?, ?, ?, ?, ?)",
+		"Test", "test@example.com", "Thanks for the guestbook", "127.0.0.1", "Localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := warmup(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first visitor gets the listing from the cache
+	rec := httptest.NewRecorder()
+	getComments(rec, httptest.NewRequest("GET", "/comments", nil), 15)
+	if got := rec.Header().Get("X-Cache"); got != "HIT" || !strings.Contains(rec.Body.String(), "Thanks for the guestbook") {
+		t.Errorf("GET /comments after warmup: X-Cache = %q, body %s", got, rec.Body)
+	}
+}
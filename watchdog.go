@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type WatchdogSample struct {
+	Time       time.Time `json:"time"`
+	Goroutines int       `json:"goroutines"`
+	HeapMB     float64   `json:"heap_mb"`
+	OpenFDs    int       `json:"open_fds"` // -1 where it can't be counted
+	Breaches   []string  `json:"breaches"`
+}
+
+var watchdog struct {
+	sync.Mutex
+	last        WatchdogSample
+	breachCount int
+}
+
+// errWatchdogRestart is what serve returns after shutting down for the
+// watchdog, so the process exits with a failure and a service manager
+// restarting on failure starts a fresh one.
+var errWatchdogRestart = errors.New("watchdog limits exceeded, exiting to be restarted")
+
+// watchdogTripped is set once the watchdog asked the process to terminate.
+var watchdogTripped atomic.Bool
+
+// runWatchdog samples the process every interval and complains when it
+// grows past the configured limits. With watchdog_restart set it asks the
+// process to shut down and exit with errWatchdogRestart, so the service
+// manager can start a fresh one.
+func runWatchdog(interval time.Duration) {
+	for range time.Tick(interval) {
+		s := takeSample()
+		s.Breaches = checkThresholds(s)
+
+		watchdog.Lock()
+		watchdog.last = s
+		watchdog.breachCount += len(s.Breaches)
+		watchdog.Unlock()
+
+		if len(s.Breaches) == 0 {
+			continue
+		}
+		logger.Warn("watchdog limits exceeded", "breaches", s.Breaches,
+			"goroutines", s.Goroutines, "heap_mb", s.HeapMB, "open_fds", s.OpenFDs)
+		if settings().WatchdogRestart {
+			logger.Warn("watchdog terminating for restart")
+			watchdogTripped.Store(true)
+			p, _ := os.FindProcess(os.Getpid())
+			if err := p.Signal(os.Interrupt); err != nil {
+				os.Exit(1)
+			}
+			return
+		}
+	}
+}
+
+func takeSample() WatchdogSample {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return WatchdogSample{
+		Time:       time.Now().UTC(),
+		Goroutines: runtime.NumGoroutine(),
+		HeapMB:     float64(m.HeapAlloc) / (1 << 20),
+		OpenFDs:    countOpenFDs(),
+	}
+}
+
+// countOpenFDs relies on /proc, so it only works on Linux.
+func countOpenFDs() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(entries)
+}
+
+// checkThresholds lists the configured limits s exceeds. A limit of 0 is
+// not checked.
+func checkThresholds(s WatchdogSample) []string {
+	var breaches []string
+	cfg := settings()
+	if cfg.MaxGoroutines > 0 && s.Goroutines > cfg.MaxGoroutines {
+		breaches = append(breaches, fmt.Sprintf("goroutines %d > %d", s.Goroutines, cfg.MaxGoroutines))
+	}
+	if cfg.MaxHeapMB > 0 && s.HeapMB > float64(cfg.MaxHeapMB) {
+		breaches = append(breaches, fmt.Sprintf("heap %.1fMB > %dMB", s.HeapMB, cfg.MaxHeapMB))
+	}
+	if cfg.MaxOpenFDs > 0 && s.OpenFDs > cfg.MaxOpenFDs {
+		breaches = append(breaches, fmt.Sprintf("open fds %d > %d", s.OpenFDs, cfg.MaxOpenFDs))
+	}
+	return breaches
+}
+
+func watchdogHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	current := takeSample()
+	current.Breaches = checkThresholds(current)
+
+	cfg := settings()
+	watchdog.Lock()
+	resp := map[string]any{
+		"current":      current,
+		"last_check":   watchdog.last,
+		"breach_count": watchdog.breachCount,
+		"limits": map[string]int{
+			"max_goroutines": cfg.MaxGoroutines,
+			"max_heap_mb":    cfg.MaxHeapMB,
+			"max_open_fds":   cfg.MaxOpenFDs,
+		},
+	}
+	watchdog.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckThresholds(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.MaxGoroutines = 100
+	config.MaxHeapMB = 64
+	config.MaxOpenFDs = 0
+
+	tests := []struct {
+		name     string
+		sample   WatchdogSample
+		expected int
+	}{
+		{"Within limits", WatchdogSample{Goroutines: 10, HeapMB: 12, OpenFDs: 5000}, 0},
+		{"Too many goroutines", WatchdogSample{Goroutines: 101, HeapMB: 12}, 1},
+		{"Everything over", WatchdogSample{Goroutines: 500, HeapMB: 65, OpenFDs: 5000}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkThresholds(tt.sample); len(got) != tt.expected {
+				t.Errorf("Expected %d breaches, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestWatchdogHandler(t *testing.T) {
+	req := httptest.NewRequest("GET", "/admin/watchdog", nil)
+	recorder := httptest.NewRecorder()
+
+	watchdogHandler(recorder, req)
+
+	if recorder.Code != 200 {
+		t.Errorf("Expected status 200, got %d", recorder.Code)
+	}
+	if s := takeSample(); s.Goroutines < 1 || s.HeapMB <= 0 {
+		t.Errorf("Implausible sample %+v", s)
+	}
+}
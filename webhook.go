@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Every new comment is posted as JSON to each of webhook_urls.
+
+func init() { registerNotifier(webhookNotifier{}) }
+
+// webhookEvent is the JSON body posted to every webhook_urls.
+type webhookEvent struct {
+	Event   string  `json:"event"`
+	Site    string  `json:"site"`
+	Comment Comment `json:"comment"`
+}
+
+// webhookClient posts to webhook_urls.
+var webhookClient = &http.Client{Timeout: 10 * time.Second, Transport: breakerTransport{http.DefaultTransport}}
+
+type webhookNotifier struct{}
+
+func (webhookNotifier) Name() string { return "webhook" }
+
+func (webhookNotifier) Enabled(c Config) bool { return len(c.WebhookURLs) > 0 }
+
+func (webhookNotifier) Messages(_ context.Context, c Config, n Notification) ([]OutboxItem, error) {
+	if n.Event != "comment.created" {
+		return nil, nil
+	}
+	var items []OutboxItem
+	for _, u := range c.WebhookURLs {
+		items = append(items, OutboxItem{Target: u, Payload: webhookEvent{Event: n.Event, Site: n.Site.Slug, Comment: *n.Comment}})
+	}
+	return items, nil
+}
+
+func (webhookNotifier) Deliver(ctx context.Context, target string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("POST %s: %s", target, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,557 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// Webmentions let other sites tell the guestbook they link to it. The
+// source page is fetched to check the link, and its h-entry, if it has
+// one, becomes a comment held for moderation. Which comments are mentions
+// lives in the webmentions table, so a source that is updated or removed
+// updates or deletes its comment.
+//
+// The other way round, the sites that approved comments link to are sent
+// a Webmention from the comment on the guestbook page.
+
+// webmentionMaxSource bounds the source pages read.
+const webmentionMaxSource = 1 << 20
+
+// webmentionMaxText is how many characters of a mention's content are
+// kept.
+const webmentionMaxText = 2000
+
+// webmentionURL is the endpoint the guestbook page advertises.
+func webmentionURL() string {
+	return strings.TrimSuffix(config.PublicURL, "/") + "/webmention"
+}
+
+// webmentionHandler takes a form-encoded source and target, where target
+// is the guestbook page under public_url, optionally with #comment-<id>
+// to reply to a comment. It answers 201 for a new mention, 200 for an
+// update or deletion, and 400 if the source doesn't link to the target.
+func webmentionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !parseForm(w, r) {
+		return
+	}
+	ctx := r.Context()
+	source, target := r.PostFormValue("source"), r.PostFormValue("target")
+	if u, err := url.Parse(source); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || source == target {
+		httpError(w, r, 400, codeInvalidWebmention, "source must be an http or https URL other than target")
+		return
+	}
+	site, parentID, err := webmentionTarget(ctx, target)
+	if err == errUnknownSite {
+		httpError(w, r, 400, codeInvalidWebmention, "target isn't this guestbook")
+		return
+	} else if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	blocked, err := store.IsBlocked(ctx, getIP(r))
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if blocked {
+		httpError(w, r, http.StatusForbidden, codeForbidden, "Forbidden")
+		return
+	}
+	if site.Archived {
+		httpError(w, r, http.StatusGone, codeSiteArchived, "This guestbook is archived and read-only")
+		return
+	}
+
+	created, err := receiveWebmention(ctx, requestLogger(r), site, source, target, parentID, getIP(r))
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if created {
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintln(w, "Webmention received and awaiting moderation")
+		return
+	}
+	fmt.Fprintln(w, "Webmention processed")
+}
+
+// webmentionTarget returns the site whose page target is, and the
+// approved comment its #comment-<id> fragment names, or errUnknownSite.
+func webmentionTarget(ctx context.Context, target string) (*Site, int, error) {
+	base, err := url.Parse(config.PublicURL)
+	if err != nil {
+		return nil, 0, err
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != base.Scheme || u.Host != base.Host || !oneOf(u.Path, "", "/", "/guestbook") {
+		return nil, 0, errUnknownSite
+	}
+	site := defaultSite
+	if config.MultiTenant {
+		slug := u.Query().Get("site")
+		if slug == "" {
+			return nil, 0, errUnknownSite
+		}
+		if site, err = lookupSite(ctx, "", slug); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	s, ok := strings.CutPrefix(u.Fragment, "comment-")
+	if !ok {
+		return site, 0, nil
+	}
+	id, err := strconv.Atoi(s)
+	if err != nil {
+		return site, 0, nil
+	}
+	parent, err := store.Get(ctx, site.ID, id)
+	if err == errNotFound || (err == nil && parent.Status != "approved") {
+		return site, 0, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+	return site, parent.ID, nil
+}
+
+// receiveWebmention verifies that source links to target and stores or
+// updates its comment, reporting whether it's new. A source that is gone
+// or no longer links deletes the comment it made.
+func receiveWebmention(ctx context.Context, log *slog.Logger, site *Site, source, target string, parentID int, ip string) (bool, error) {
+	doc, gone, err := fetchSource(ctx, source)
+	if err != nil {
+		log.Info("Webmention source not fetched", "source", source, "error", err)
+		return false, &apiError{status: 400, code: codeInvalidWebmention, message: "The source couldn't be fetched"}
+	}
+	existing, err := mentionComment(ctx, site.ID, source)
+	if err != nil {
+		return false, err
+	}
+
+	if gone || !linksTo(doc, source, target) {
+		if existing == nil {
+			return false, &apiError{status: 400, code: codeInvalidWebmention, message: "The source doesn't link to the target"}
+		}
+		if err := store.Delete(ctx, site.ID, existing.ID); err != nil && err != errNotFound {
+			return false, err
+		}
+		if _, err := db.ExecContext(ctx, "DELETE FROM webmentions WHERE comment_id = ?", existing.ID); err != nil {
+			return false, err
+		}
+		log.Info("Webmention removed", "site", site.Slug, "id", existing.ID, "source", source)
+		return false, nil
+	}
+
+	location := ""
+	if u, err := url.Parse(source); err == nil {
+		location = u.Host
+	}
+	name, text := parseMention(doc)
+	if name == "" {
+		name = location
+	}
+	if text == "" {
+		text = source
+	}
+	if runes := []rune(text); len(runes) > webmentionMaxText {
+		text = string(runes[:webmentionMaxText]) + "…"
+	}
+
+	if existing != nil {
+		existing.Name, existing.Text = name, text
+		if err := store.Update(ctx, existing); err != nil {
+			return false, err
+		}
+		log.Info("Webmention updated", "site", site.Slug, "id", existing.ID, "source", source)
+		return false, nil
+	}
+	c := &Comment{
+		SiteID:   site.ID,
+		Name:     name,
+		Text:     text,
+		IP:       ip,
+		Location: location,
+		ParentID: parentID,
+		Status:   "pending",
+	}
+	if err := store.Create(ctx, c); err != nil {
+		return false, err
+	}
+	if _, err := db.ExecContext(ctx, "INSERT INTO webmentions (comment_id, site_id, source, target) VALUES (?, ?, ?, ?)", c.ID, site.ID, source, target); err != nil {
+		return false, err
+	}
+	log.Info("Webmention received", "site", site.Slug, "id", c.ID, "source", source)
+	return true, nil
+}
+
+// mentionComment returns the comment source made on a site, or nil. A
+// record left over from a comment an admin deleted is dropped, so the
+// source can mention the guestbook again.
+func mentionComment(ctx context.Context, siteID int, source string) (*Comment, error) {
+	var id int
+	err := db.QueryRowContext(ctx, "SELECT comment_id FROM webmentions WHERE site_id = ? AND source = ?", siteID, source).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	c, err := store.Get(ctx, siteID, id)
+	if err == errNotFound {
+		_, err = db.ExecContext(ctx, "DELETE FROM webmentions WHERE comment_id = ?", id)
+		return nil, err
+	}
+	return c, err
+}
+
+// fetchSource reads the HTML page at source. gone is set if it answers
+// 410.
+func fetchSource(ctx context.Context, source string) (doc *html.Node, gone bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Accept", "text/html, application/xhtml+xml")
+	resp, err := publicClient.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone {
+		return nil, true, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("GET %s: %s", source, resp.Status)
+	}
+	if mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mt != "text/html" && mt != "application/xhtml+xml" {
+		return nil, false, fmt.Errorf("GET %s: %q isn't HTML", source, mt)
+	}
+	doc, err = html.Parse(io.LimitReader(resp.Body, webmentionMaxSource))
+	return doc, false, err
+}
+
+// linksTo reports whether doc, found at source, has a link or embed whose
+// URL is target.
+func linksTo(doc *html.Node, source, target string) bool {
+	base, err := url.Parse(source)
+	if err != nil {
+		return false
+	}
+	found := false
+	walkHTML(doc, func(n *html.Node) bool {
+		for _, key := range []string{"href", "src"} {
+			ref, err := url.Parse(htmlAttr(n, key))
+			if htmlAttr(n, key) != "" && err == nil && base.ResolveReference(ref).String() == target {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// parseMention returns the author's name and the text of doc's first
+// h-entry, as far as microformats2 give them.
+func parseMention(doc *html.Node) (name, text string) {
+	entry := findClass(doc, "h-entry")
+	if entry == nil {
+		return "", ""
+	}
+	if author := findClass(entry, "p-author", "u-author"); author != nil {
+		name = nodeText(author)
+		if hasClass(author, "h-card") {
+			if n := findClass(author, "p-name"); n != nil {
+				name = nodeText(n)
+			}
+		}
+	}
+	if content := findClass(entry, "e-content", "p-content", "p-summary", "p-name"); content != nil {
+		text = nodeText(content)
+	}
+	return strings.Join(strings.Fields(name), " "), text
+}
+
+// walkHTML calls fn for n and its descendants in document order, until fn
+// returns false.
+func walkHTML(n *html.Node, fn func(*html.Node) bool) bool {
+	if n.Type == html.ElementNode && !fn(n) {
+		return false
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if !walkHTML(c, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// findClass returns the first descendant of n that has one of classes,
+// trying them in order.
+func findClass(n *html.Node, classes ...string) *html.Node {
+	for _, class := range classes {
+		var found *html.Node
+		walkHTML(n, func(c *html.Node) bool {
+			if c != n && hasClass(c, class) {
+				found = c
+			}
+			return found == nil
+		})
+		if found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func hasClass(n *html.Node, class string) bool {
+	return slices.Contains(strings.Fields(htmlAttr(n, "class")), class)
+}
+
+func htmlAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// nodeText is the text of n's children, with line breaks where htmlToText
+// puts them.
+func nodeText(n *html.Node) string {
+	var b bytes.Buffer
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if err := html.Render(&b, c); err != nil {
+			return ""
+		}
+	}
+	return htmlToText(b.String())
+}
+
+// webmentionRetries are the waits before sending a mention again after a
+// network error or a 5xx or 429 answer.
+var webmentionRetries = []time.Duration{time.Minute, 10 * time.Minute, time.Hour}
+
+// webmentionMaxTargets bounds the mentions sent for one comment.
+const webmentionMaxTargets = 10
+
+var errNoEndpoint = errors.New("no webmention endpoint")
+
+// retryableError is a failure to send a mention that may go away.
+type retryableError struct{ error }
+
+// runWebmentions sends Webmentions to the URLs in approved comments when
+// they're published, edited or deleted, until ctx is cancelled.
+func runWebmentions(ctx context.Context) {
+	sub := feed.subscribe(allSites)
+	defer feed.unsubscribe(sub)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-sub.events:
+			if sub.lagged.Swap(false) {
+				logger.Warn("Webmentions fell behind, some weren't sent")
+			}
+			if _, ok := publicChange(e); ok {
+				go sendMentions(ctx, e.Comment)
+			}
+		}
+	}
+}
+
+// sendMentions sends a Webmention from c to every URL in its text except
+// the guestbook's own, one after the other.
+func sendMentions(ctx context.Context, c Comment) {
+	targets := findURLs(c.Text)
+	if len(targets) == 0 {
+		return
+	}
+	site, err := siteByID(ctx, c.SiteID)
+	if err != nil {
+		logger.Warn("Webmentions not sent", "id", c.ID, "error", err)
+		return
+	}
+	source := publicPageURL(site) + "#comment-" + strconv.Itoa(c.ID)
+	own := strings.TrimSuffix(config.PublicURL, "/") + "/"
+	sent := 0
+	for _, target := range targets {
+		if strings.HasPrefix(target, own) || sent == webmentionMaxTargets {
+			continue
+		}
+		sent++
+		switch err := sendWebmention(ctx, source, target); {
+		case err == nil:
+			logger.Info("Webmention sent", "id", c.ID, "target", target)
+		case errors.Is(err, errNoEndpoint):
+			logger.Debug("Webmention not sent, the target takes none", "id", c.ID, "target", target)
+		default:
+			logger.Warn("Webmention not sent", "id", c.ID, "target", target, "error", err)
+		}
+	}
+}
+
+// sendWebmention tells target that source links to it, trying again after
+// each of webmentionRetries while the failure is retryable.
+func sendWebmention(ctx context.Context, source, target string) error {
+	for attempt := 0; ; attempt++ {
+		err := trySendWebmention(ctx, source, target)
+		var retryable retryableError
+		if err == nil || !errors.As(err, &retryable) || attempt == len(webmentionRetries) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(webmentionRetries[attempt]):
+		}
+	}
+}
+
+func trySendWebmention(ctx context.Context, source, target string) error {
+	endpoint, err := discoverEndpoint(ctx, target)
+	if err != nil {
+		return err
+	}
+	form := url.Values{"source": {source}, "target": {target}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := publicClient.Do(req)
+	if err != nil {
+		return retryableError{err}
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, webmentionMaxSource))
+	return responseError(resp)
+}
+
+// discoverEndpoint finds where target takes Webmentions: in its Link
+// header, or else the first <link> or <a> with rel="webmention" in its
+// HTML. It returns errNoEndpoint if there's neither.
+func discoverEndpoint(ctx context.Context, target string) (string, error) {
+	links, err := discoverLinks(ctx, target, "webmention")
+	if err != nil {
+		return "", err
+	}
+	endpoint, ok := links["webmention"]
+	if !ok {
+		return "", errNoEndpoint
+	}
+	return endpoint, nil
+}
+
+// discoverLinks fetches page and returns the absolute URL of the first
+// link for each of rels, from its Link headers or else from the <link>
+// and <a> elements of its HTML. Rels it doesn't find aren't in the map.
+func discoverLinks(ctx context.Context, page string, rels ...string) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, page, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/html, application/xhtml+xml")
+	resp, err := publicClient.Do(req)
+	if err != nil {
+		return nil, retryableError{err}
+	}
+	defer resp.Body.Close()
+	if err := responseError(resp); err != nil {
+		return nil, err
+	}
+	// relative links are relative to where redirects ended up
+	base := resp.Request.URL
+	refs := map[string]string{}
+	for _, rel := range rels {
+		if ref, ok := linkHeaderRel(resp.Header, rel); ok {
+			refs[rel] = ref
+		}
+	}
+	mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if len(refs) < len(rels) && (mt == "text/html" || mt == "application/xhtml+xml") {
+		doc, err := html.Parse(io.LimitReader(resp.Body, webmentionMaxSource))
+		if err != nil {
+			return nil, err
+		}
+		walkHTML(doc, func(n *html.Node) bool {
+			if n.Data != "link" && n.Data != "a" || !slices.ContainsFunc(n.Attr, func(a html.Attribute) bool { return a.Key == "href" }) {
+				return true
+			}
+			for _, rel := range rels {
+				if _, ok := refs[rel]; !ok && hasRel(n, rel) {
+					// an empty href is the page itself
+					refs[rel] = htmlAttr(n, "href")
+				}
+			}
+			return len(refs) < len(rels)
+		})
+	}
+
+	links := map[string]string{}
+	for rel, ref := range refs {
+		u, err := base.Parse(ref)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "https" && u.Scheme != "http" {
+			return nil, fmt.Errorf("%s link %q isn't an http or https URL", rel, ref)
+		}
+		links[rel] = u.String()
+	}
+	return links, nil
+}
+
+// responseError is nil for a 2xx response, and retryable for 5xx and 429.
+func responseError(resp *http.Response) error {
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	err := fmt.Errorf("%s %s: %s", resp.Request.Method, resp.Request.URL, resp.Status)
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return retryableError{err}
+	}
+	return err
+}
+
+// linkHeaderRel returns the URL of the first link in h's Link headers
+// whose rel includes rel.
+func linkHeaderRel(h http.Header, rel string) (string, bool) {
+	for _, v := range h.Values("Link") {
+		for _, link := range strings.Split(v, ",") {
+			ref, params, _ := strings.Cut(link, ";")
+			ref = strings.TrimSpace(ref)
+			if !strings.HasPrefix(ref, "<") || !strings.HasSuffix(ref, ">") {
+				continue
+			}
+			for _, p := range strings.Split(params, ";") {
+				key, value, _ := strings.Cut(strings.TrimSpace(p), "=")
+				if strings.EqualFold(key, "rel") && slices.Contains(strings.Fields(strings.ToLower(strings.Trim(value, `"`))), rel) {
+					return ref[1 : len(ref)-1], true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+func hasRel(n *html.Node, rel string) bool {
+	return slices.Contains(strings.Fields(strings.ToLower(htmlAttr(n, "rel"))), rel)
+}
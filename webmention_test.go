@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+func TestParseMention(t *testing.T) {
+	tests := []struct {
+		name       string
+		page       string
+		wantAuthor string
+		wantText   string
+	}{
+		{
+			"h-card author",
+			`<article class="h-entry"><a class="p-author h-card" href="/"><img src="me.jpg"> <span class="p-name">Ana  B.</span></a>
+			<div class="e-content"><p>Signed the <a href="https://guestbook.example/">guestbook</a>!</p><p>Bye &amp; thanks</p></div></article>`,
+			"Ana B.", "Signed the guestbook!\n\nBye & thanks",
+		},
+		{
+			"plain author and name",
+			`<div class="h-entry"><span class="p-author">Bob</span><h1 class="p-name">A title</h1></div>`,
+			"Bob", "A title",
+		},
+		{"no h-entry", `<title>Just a page</title><p>Hello</p>`, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := html.Parse(strings.NewReader(tt.page))
+			if err != nil {
+				t.Fatal(err)
+			}
+			author, text := parseMention(doc)
+			if author != tt.wantAuthor || text != tt.wantText {
+				t.Errorf("parseMention = %q, %q, want %q, %q", author, text, tt.wantAuthor, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestWebmention(t *testing.T) {
+	needSQLite(t)
+	defer func(c Config) { config = c }(config)
+	config.Webmention = true
+	config.PublicURL = "https://guestbook.example"
+	config.MultiTenant = false
+	defer func(s CommentStore) { store = s }(store)
+	store = newMemoryStore()
+	db.Exec("DELETE FROM webmentions")
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	page, status := "", http.StatusOK
+	setPage := func(p string, s int) {
+		mu.Lock()
+		page, status = p, s
+		mu.Unlock()
+	}
+	src := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		w.Write([]byte(page))
+	}))
+	defer src.Close()
+	defer func(c *http.Client) { publicClient = c }(publicClient)
+	publicClient = src.Client()
+	source := src.URL + "/posts/1"
+
+	send := func(source, target string) int {
+		form := url.Values{"source": {source}, "target": {target}}
+		req := httptest.NewRequest("POST", "/webmention", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		webmentionHandler(rec, req)
+		return rec.Code
+	}
+	entry := func(text string) string {
+		return `<div class="h-entry"><span class="p-author h-card"><span class="p-name">Ana</span></span>
+			<p class="e-content">` + text + ` <a href="https://guestbook.example/">my visit</a></p></div>`
+	}
+
+	setPage(entry("About"), http.StatusOK)
+	for _, tt := range []struct{ source, target string }{
+		{source, "https://elsewhere.example/"},
+		{source, "https://guestbook.example/admin"},
+		{"ftp://example.com/", "https://guestbook.example/"},
+		{"https://guestbook.example/", "https://guestbook.example/"},
+	} {
+		if code := send(tt.source, tt.target); code != 400 {
+			t.Errorf("Webmention %s -> %s = %d, want 400", tt.source, tt.target, code)
+		}
+	}
+
+	setPage(`<p>No link here</p>`, http.StatusOK)
+	if code := send(source, "https://guestbook.example/"); code != 400 {
+		t.Errorf("Webmention without a link = %d, want 400", code)
+	}
+
+	setPage(entry("About"), http.StatusOK)
+	if code := send(source, "https://guestbook.example/"); code != 201 {
+		t.Fatalf("Webmention = %d, want 201", code)
+	}
+	got, _ := store.List(ctx, CommentQuery{})
+	if len(got) != 1 {
+		t.Fatalf("Mentions stored: %+v, want one", got)
+	}
+	want := Comment{Name: "Ana", Text: "About my visit", Status: "pending", Location: strings.TrimPrefix(src.URL, "http://")}
+	if c := got[0]; c.Name != want.Name || c.Text != want.Text || c.Status != want.Status || c.Location != want.Location {
+		t.Errorf("Mention stored as %+v", c)
+	}
+
+	setPage(entry("Updated: about"), http.StatusOK)
+	if code := send(source, "https://guestbook.example/"); code != 200 {
+		t.Errorf("Updated webmention = %d, want 200", code)
+	}
+	if c, _ := store.Get(ctx, 0, got[0].ID); c == nil || c.Text != "Updated: about my visit" {
+		t.Errorf("Mention after update: %+v", c)
+	}
+
+	setPage("", http.StatusGone)
+	if code := send(source, "https://guestbook.example/"); code != 200 {
+		t.Errorf("Webmention of a deleted source = %d, want 200", code)
+	}
+	if _, err := store.Get(ctx, 0, got[0].ID); err != errNotFound {
+		t.Errorf("Mention of a deleted source still there: %v", err)
+	}
+}
+
+func TestSendWebmentions(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.PublicURL = "https://guestbook.example"
+	config.MultiTenant = false
+	defer func(s CommentStore) { store = s }(store)
+	store = feedStore{newMemoryStore()}
+	defer func(d []time.Duration) { webmentionRetries = d }(webmentionRetries)
+	webmentionRetries = []time.Duration{time.Millisecond}
+
+	received := make(chan url.Values, 10)
+	var flaky sync.Once
+	var site *httptest.Server
+	site = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/header":
+			w.Header().Set("Link", `<https://example.com/other>; rel="me", </wm?from=header>; rel="webmention"`)
+		case "/html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="/nope">x</a><link rel="stylesheet" href="a.css"><link rel="webmention" href="wm">`))
+		case "/flaky":
+			w.Header().Set("Link", `<`+site.URL+`/wm>; rel="webmention"`)
+		case "/none":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<p>No endpoint</p>`))
+		case "/wm":
+			if r.FormValue("target") == site.URL+"/flaky" {
+				retry := false
+				flaky.Do(func() { retry = true })
+				if retry {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+			}
+			r.ParseForm()
+			received <- r.PostForm
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer site.Close()
+	defer func(c *http.Client) { publicClient = c }(publicClient)
+	publicClient = site.Client()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	before := feedSubscribers()
+	go runWebmentions(ctx)
+	for feedSubscribers() == before {
+		time.Sleep(time.Millisecond)
+	}
+
+	store.Create(ctx, &Comment{Name: "Held", Email: "h@example.com", Text: site.URL + "/header", Status: "pending"})
+	c := &Comment{Name: "Ana", Email: "a@example.com", Text: "See " + site.URL + "/header, " + site.URL + "/html and (" + site.URL + "/flaky). Also " + site.URL + "/none and https://guestbook.example/?page=2"}
+	store.Create(ctx, c)
+
+	source := "https://guestbook.example/#comment-" + strconv.Itoa(c.ID)
+	got := map[string]bool{}
+	for range 3 {
+		select {
+		case form := <-received:
+			if form.Get("source") != source {
+				t.Errorf("Webmention from %q, want %q", form.Get("source"), source)
+			}
+			got[form.Get("target")] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Webmentions received for %v, want three", got)
+		}
+	}
+	for _, path := range []string{"/header", "/html", "/flaky"} {
+		if !got[site.URL+path] {
+			t.Errorf("No webmention for %s, got %v", path, got)
+		}
+	}
+	select {
+	case form := <-received:
+		t.Errorf("Unexpected webmention %v", form)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+)
+
+// wsWriteTimeout bounds sending one message or ping to a WebSocket client.
+// A client that can't keep up with that is disconnected.
+const wsWriteTimeout = 10 * time.Second
+
+// wsMessage is what /ws sends for every change to a comment.
+type wsMessage struct {
+	Type    string      `json:"type"`
+	Comment liveComment `json:"comment"`
+}
+
+// publicChange says how e looks to someone who only sees approved
+// comments: a comment that gets approved is created for them, one that
+// loses its approval is deleted.
+func publicChange(e commentEvent) (string, bool) {
+	approved := e.Comment.Status == "approved"
+	switch e.Type {
+	case "created", "deleted":
+		return e.Type, approved
+	case "updated":
+		switch {
+		case approved && e.WasApproved:
+			return "updated", true
+		case approved:
+			return "created", true
+		case e.WasApproved:
+			return "deleted", true
+		}
+	}
+	return "", false
+}
+
+// wsHandler pushes every change to the approved comments of a site over a
+// WebSocket at /ws, optionally only those in ?thread=<id>: that comment and
+// its replies. It's a stream of what happens from now on; catching up
+// after a disconnect is up to the client, with GET /comments or /events.
+//
+// Every client has a buffer in feed. If it fills up because the client
+// reads too slowly, the client is disconnected with 1013 (try again later)
+// rather than missing changes without knowing.
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	thread := 0
+	if v := r.URL.Query().Get("thread"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			httpError(w, r, 400, codeInvalidID, "thread must be a comment id")
+			return
+		}
+		thread = n
+	}
+	site := siteFor(r)
+	log := requestLogger(r)
+
+	// requireSite has already checked the Origin against the site's
+	// allowed origins
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{InsecureSkipVerify: true})
+	if err != nil {
+		log.Info("WebSocket handshake failed", "error", err)
+		return
+	}
+	defer conn.CloseNow()
+	sub := feed.subscribe(site.ID)
+	defer feed.unsubscribe(sub)
+
+	// clients only listen; CloseRead answers pings and closes, and ends ctx
+	// when the client goes away or sends a message
+	ctx := conn.CloseRead(context.Background())
+	ticker := time.NewTicker(eventsKeepalive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-shuttingDown:
+			conn.Close(websocket.StatusGoingAway, "server shutting down")
+			return
+		case e := <-sub.events:
+			if sub.lagged.Load() {
+				log.Info("WebSocket client too slow, disconnecting")
+				conn.Close(websocket.StatusTryAgainLater, "too slow, reconnect")
+				return
+			}
+			typ, ok := publicChange(e)
+			if !ok || (thread != 0 && e.Comment.ID != thread && e.Comment.ParentID != thread) {
+				continue
+			}
+			wctx, cancel := context.WithTimeout(ctx, wsWriteTimeout)
+			err = wsjson.Write(wctx, conn, wsMessage{Type: typ, Comment: newLiveComment(e.Comment)})
+			cancel()
+		case <-ticker.C:
+			// a client that doesn't answer is gone, even if the
+			// connection hasn't noticed yet
+			pctx, cancel := context.WithTimeout(ctx, wsWriteTimeout)
+			err = conn.Ping(pctx)
+			cancel()
+		}
+		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				log.Debug("WebSocket closed", "error", err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+)
+
+func TestPublicChange(t *testing.T) {
+	tests := []struct {
+		name  string
+		event commentEvent
+		want  string
+	}{
+		{"Created", commentEvent{Type: "created", Comment: Comment{Status: "approved"}}, "created"},
+		{"Created pending", commentEvent{Type: "created", Comment: Comment{Status: "pending"}}, ""},
+		{"Edited", commentEvent{Type: "updated", Comment: Comment{Status: "approved"}, WasApproved: true}, "updated"},
+		{"Approved", commentEvent{Type: "updated", Comment: Comment{Status: "approved"}}, "created"},
+		{"Marked spam", commentEvent{Type: "updated", Comment: Comment{Status: "spam"}, WasApproved: true}, "deleted"},
+		{"Edited pending", commentEvent{Type: "updated", Comment: Comment{Status: "pending"}}, ""},
+		{"Deleted", commentEvent{Type: "deleted", Comment: Comment{Status: "approved"}}, "deleted"},
+		{"Deleted spam", commentEvent{Type: "deleted", Comment: Comment{Status: "spam"}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := publicChange(tt.event)
+			if ok != (tt.want != "") || (ok && got != tt.want) {
+				t.Errorf("publicChange() = %q, %v; want %q", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func dialWS(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(url, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.CloseNow() })
+	return conn
+}
+
+func readWS(t *testing.T, conn *websocket.Conn) wsMessage {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var m wsMessage
+	if err := wsjson.Read(ctx, conn, &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestWSHandler(t *testing.T) {
+	defer func(s CommentStore) { store = s }(store)
+	ctx := context.Background()
+	store = feedStore{newMemoryStore()}
+	srv := httptest.NewServer(http.HandlerFunc(wsHandler))
+	defer srv.Close()
+
+	thread := &Comment{Name: "Alice", Email: "a@example.com", Text: "Topic"}
+	store.Create(ctx, thread)
+	all := dialWS(t, srv.URL)
+	replies := dialWS(t, srv.URL+"?thread="+strconv.Itoa(thread.ID))
+	// both are subscribed once the handlers run
+	for deadline := time.Now().Add(5 * time.Second); feedSubscribers() < 2; {
+		if time.Now().After(deadline) {
+			t.Fatal("Clients never subscribed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	other := &Comment{Name: "Bob", Email: "b@example.com", Text: "Elsewhere"}
+	store.Create(ctx, other)
+	reply := &Comment{Name: "Carol", Email: "c@example.com", IP: "1.1.1.1", Text: "Reply", ParentID: thread.ID, Status: "pending"}
+	store.Create(ctx, reply)
+	reply.Status = "approved"
+	store.Update(ctx, reply)
+	reply.Text = "Edited reply"
+	store.Update(ctx, reply)
+	store.Delete(ctx, 0, reply.ID)
+
+	want := []struct {
+		typ string
+		id  int
+	}{
+		{"created", other.ID},
+		{"created", reply.ID},
+		{"updated", reply.ID},
+		{"deleted", reply.ID},
+	}
+	for _, w := range want {
+		if m := readWS(t, all); m.Type != w.typ || m.Comment.ID != w.id {
+			t.Errorf("Got %s of %d, want %s of %d", m.Type, m.Comment.ID, w.typ, w.id)
+		}
+	}
+	for _, w := range want[1:] {
+		if m := readWS(t, replies); m.Type != w.typ || m.Comment.ID != w.id {
+			t.Errorf("Thread got %s of %d, want %s of %d", m.Type, m.Comment.ID, w.typ, w.id)
+		}
+	}
+
+	// a client whose buffer overflowed is told to reconnect
+	feed.mu.Lock()
+	for s := range feed.subs {
+		s.lagged.Store(true)
+	}
+	feed.mu.Unlock()
+	store.Create(ctx, &Comment{Name: "Dave", Email: "d@example.com", Text: "Wake up"})
+	rctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	_, _, err := all.Read(rctx)
+	if websocket.CloseStatus(err) != websocket.StatusTryAgainLater {
+		t.Errorf("Slow client read %v, want close 1013", err)
+	}
+}
+
+func TestWSHandlerErrors(t *testing.T) {
+	tests := []struct {
+		method, query string
+		status        int
+	}{
+		{"POST", "", 405},
+		{"GET", "?thread=abc", 400},
+		{"GET", "?thread=0", 400},
+		{"GET", "", http.StatusUpgradeRequired},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		wsHandler(rec, httptest.NewRequest(tt.method, "/ws"+tt.query, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s /ws%s = %d, want %d", tt.method, tt.query, rec.Code, tt.status)
+		}
+	}
+}
+
+func feedSubscribers() int {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	return len(feed.subs)
+}